@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RenameUser меняет логин пользователя. Запись переносится целиком: пароль, история входов,
+// блокировки и согласия сохраняются, а записи журнала аудита связываются событием user_renamed.
+func (um *UserManager) RenameUser(oldName, newName, reason string) error {
+	oldName = strings.TrimSpace(oldName)
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return fmt.Errorf("логин не может быть пустым")
+	}
+	if oldName == newName {
+		return fmt.Errorf("новый логин совпадает с прежним")
+	}
+
+	user, exists := um.store.GetUser(oldName)
+	if !exists || user.IsHoneypot {
+		return fmt.Errorf("пользователь не найден")
+	}
+
+	if err := um.store.Rename(oldName, newName); err != nil {
+		return err
+	}
+
+	um.recordAudit(AuditUserRenamed, newName, fmt.Sprintf("прежний логин %s; %s", oldName, reason))
+	um.usersChanged()
+	return nil
+}
+
+// MergeUsers объединяет дублирующую учетную запись source с основной target и удаляет source.
+// Пароль, блокировки и согласия остаются от target; из source переносятся более ранняя дата
+// создания, более поздний вход, а также адрес и расписание, если в target они не заданы.
+func (um *UserManager) MergeUsers(target, source, reason string) error {
+	target = strings.TrimSpace(target)
+	source = strings.TrimSpace(source)
+	if target == source {
+		return fmt.Errorf("нельзя объединить учетную запись саму с собой")
+	}
+
+	var taken []string
+	merge := func(targetUser, sourceUser *User) (err error) {
+		taken, err = mergeAccounts(targetUser, sourceUser)
+		return err
+	}
+
+	if um.dryRun {
+		// Объединение проверяется на копиях записей, хранилище не меняется
+		targetUser, targetExists := um.store.GetUser(target)
+		sourceUser, sourceExists := um.store.GetUser(source)
+		if !targetExists || !sourceExists {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if err := merge(targetUser, sourceUser); err != nil {
+			return err
+		}
+	} else if err := um.store.Merge(target, source, merge); err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("объединена с %s", source)
+	if len(taken) > 0 {
+		details += " (перенесено: " + strings.Join(taken, ", ") + ")"
+	}
+	if um.dryRun {
+		um.planChange(AuditUsersMerged, source, fmt.Sprintf("объединена с %s и удалена; %s", target, reason))
+		um.planChange(AuditUsersMerged, target, details+"; "+reason)
+		return nil
+	}
+	um.recordAudit(AuditUsersMerged, source, fmt.Sprintf("объединена с %s и удалена; %s", target, reason))
+	um.recordAudit(AuditUsersMerged, target, details+"; "+reason)
+	um.usersChanged()
+	return nil
+}
+
+// mergeAccounts проверяет, что учетные записи можно объединить, переносит данные source
+// в target и возвращает перенесенные поля
+func mergeAccounts(targetUser, sourceUser *User) ([]string, error) {
+	var taken []string
+	if targetUser.IsHoneypot || sourceUser.IsHoneypot {
+		return nil, fmt.Errorf("пользователь не найден")
+	}
+	if targetUser.PendingApproval || sourceUser.PendingApproval {
+		return nil, fmt.Errorf("регистрация ожидает одобрения, объединение невозможно")
+	}
+	if targetUser.Email != "" && sourceUser.Email != "" && !strings.EqualFold(targetUser.Email, sourceUser.Email) {
+		return nil, fmt.Errorf("адреса учетных записей различаются: %s и %s", targetUser.Email, sourceUser.Email)
+	}
+
+	// Токены доступа выданы до объединения: после него нужен новый вход
+	targetUser.TokensRevokedAt = time.Now()
+	if sourceUser.CreatedAt.Before(targetUser.CreatedAt) {
+		targetUser.CreatedAt = sourceUser.CreatedAt
+		taken = append(taken, "дата создания")
+	}
+	if sourceUser.LastLoginAt.After(targetUser.LastLoginAt) {
+		targetUser.LastLoginAt = sourceUser.LastLoginAt
+		targetUser.DormantSince = sourceUser.DormantSince
+		targetUser.DormancyWarnedAt = sourceUser.DormancyWarnedAt
+		taken = append(taken, "последний вход")
+	}
+	if targetUser.Email == "" && sourceUser.Email != "" {
+		targetUser.Email = sourceUser.Email
+		taken = append(taken, "адрес")
+	}
+	if targetUser.Schedule == nil && sourceUser.Schedule != nil {
+		targetUser.Schedule = sourceUser.Schedule
+		taken = append(taken, "расписание входа")
+	}
+	if len(sourceUser.SSHKeys) > 0 {
+		targetUser.SSHKeys = append(targetUser.SSHKeys, sourceUser.SSHKeys...)
+		taken = append(taken, "ключи SSH")
+	}
+	if len(sourceUser.CertBindings) > 0 {
+		targetUser.CertBindings = append(targetUser.CertBindings, sourceUser.CertBindings...)
+		taken = append(taken, "привязки сертификатов")
+	}
+	added := false
+	for _, group := range sourceUser.Groups {
+		if !slices.Contains(targetUser.Groups, group) {
+			targetUser.Groups = append(targetUser.Groups, group)
+			added = true
+		}
+	}
+	if added {
+		sort.Strings(targetUser.Groups)
+		taken = append(taken, "членство в группах")
+	}
+	added = false
+	for key, value := range sourceUser.Attributes {
+		if _, exists := targetUser.Attributes[key]; !exists {
+			if targetUser.Attributes == nil {
+				targetUser.Attributes = make(map[string]string)
+			}
+			targetUser.Attributes[key] = value
+			added = true
+		}
+	}
+	if added {
+		taken = append(taken, "атрибуты")
+	}
+	if targetUser.SAMLNameID == "" && sourceUser.SAMLNameID != "" {
+		targetUser.SAMLNameID = sourceUser.SAMLNameID
+		taken = append(taken, "связь с SAML")
+	}
+	return taken, nil
+}
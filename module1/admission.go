@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// admissionMaxWait - наибольшее время ожидания в очереди; дольше клиенту лучше повторить запрос
+const admissionMaxWait = 10 * time.Second
+
+// AdmissionLane - очередь допуска запросов к проверке паролей
+type AdmissionLane int
+
+// Очереди допуска: освободившаяся проверка пароля достается входу раньше регистрации,
+// поэтому всплеск регистраций не мешает входу существующих пользователей
+const (
+	LaneAuth AdmissionLane = iota
+	LaneRegister
+)
+
+// String возвращает название очереди для метрик
+func (l AdmissionLane) String() string {
+	if l == LaneAuth {
+		return "auth"
+	}
+	return "register"
+}
+
+// Admission ограничивает число запросов, ожидающих проверки пароля: bcrypt занимает
+// процессор, и без ограничения очередь растет вместе со временем ответа. Запрос, которому
+// нет места в очереди, сразу получает отказ с оценкой, когда стоит повторить.
+type Admission struct {
+	mu       sync.Mutex
+	slots    int                // Свободных мест для проверки пароля
+	limits   [2]int             // Наибольшая длина очереди по полосам
+	waiting  [2][]chan struct{} // Ожидающие запросы в порядке поступления; канал закрывается при выдаче места
+	rejected [2]uint64          // Отказов из-за переполнения очереди
+	average  time.Duration      // Среднее время обработки запроса
+}
+
+// NewAdmission создает допуск с одним местом для проверки пароля и очередями
+// длиной authQueue и registerQueue
+func NewAdmission(authQueue, registerQueue int) *Admission {
+	return &Admission{
+		slots:   1,
+		limits:  [2]int{authQueue, registerQueue},
+		average: 100 * time.Millisecond,
+	}
+}
+
+// Acquire ждет места для запроса полосы lane. Если очередь полна или запрос отменен,
+// возвращает ok = false и оценку времени, через которое стоит повторить запрос.
+// После обработки нужно вызвать release.
+func (a *Admission) Acquire(ctx context.Context, lane AdmissionLane) (release func(), retryAfter time.Duration, ok bool) {
+	a.mu.Lock()
+	if a.slots > 0 && len(a.waiting[LaneAuth]) == 0 && len(a.waiting[LaneRegister]) == 0 {
+		a.slots--
+		a.mu.Unlock()
+		return a.releaser(), 0, true
+	}
+	if len(a.waiting[lane]) >= a.limits[lane] {
+		a.rejected[lane]++
+		retryAfter = a.estimateLocked(lane)
+		a.mu.Unlock()
+		return nil, retryAfter, false
+	}
+	ready := make(chan struct{})
+	a.waiting[lane] = append(a.waiting[lane], ready)
+	a.mu.Unlock()
+
+	select {
+	case <-ready:
+		return a.releaser(), 0, true
+	case <-ctx.Done():
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		select {
+		case <-ready:
+			// Место выдано одновременно с отменой: передаем его следующему
+			a.slots++
+			a.handOffLocked()
+		default:
+			a.waiting[lane] = removeWaiter(a.waiting[lane], ready)
+		}
+		return nil, a.estimateLocked(lane), false
+	}
+}
+
+// releaser возвращает функцию освобождения места, учитывающую время обработки
+func (a *Admission) releaser() func() {
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			// Скользящее среднее: последние запросы весят больше
+			a.average = (a.average*7 + time.Since(start)) / 8
+			a.slots++
+			a.handOffLocked()
+		})
+	}
+}
+
+// handOffLocked передает свободное место первому ожидающему запросу, вход - в первую очередь
+func (a *Admission) handOffLocked() {
+	for _, lane := range []AdmissionLane{LaneAuth, LaneRegister} {
+		if a.slots == 0 || len(a.waiting[lane]) == 0 {
+			continue
+		}
+		ready := a.waiting[lane][0]
+		a.waiting[lane] = a.waiting[lane][1:]
+		a.slots--
+		close(ready)
+		return
+	}
+}
+
+// estimateLocked оценивает, через сколько освободится место для запроса полосы lane
+func (a *Admission) estimateLocked(lane AdmissionLane) time.Duration {
+	queued := len(a.waiting[LaneAuth])
+	if lane == LaneRegister {
+		queued += len(a.waiting[LaneRegister])
+	}
+	return max(time.Duration(queued+1)*a.average, time.Second)
+}
+
+// AdmissionStatus - состояние очереди для метрик
+type AdmissionStatus struct {
+	Lane     AdmissionLane
+	Queued   int
+	Limit    int
+	Rejected uint64
+}
+
+// Status возвращает состояние очередей
+func (a *Admission) Status() []AdmissionStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var status []AdmissionStatus
+	for _, lane := range []AdmissionLane{LaneAuth, LaneRegister} {
+		status = append(status, AdmissionStatus{Lane: lane, Queued: len(a.waiting[lane]), Limit: a.limits[lane], Rejected: a.rejected[lane]})
+	}
+	return status
+}
+
+// removeWaiter убирает запрос из очереди
+func removeWaiter(queue []chan struct{}, ready chan struct{}) []chan struct{} {
+	for i, waiter := range queue {
+		if waiter == ready {
+			return append(queue[:i:i], queue[i+1:]...)
+		}
+	}
+	return queue
+}
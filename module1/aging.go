@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// passwordAgeBuckets - верхние границы интервалов распределения возраста паролей, дней
+var passwordAgeBuckets = []int{30, 90, 180, 365}
+
+// PasswordAgingReport - сводка возраста паролей для панелей мониторинга
+type PasswordAgingReport struct {
+	GeneratedAt        time.Time            `json:"generated_at"`
+	MaxPasswordAgeDays int                  `json:"max_password_age_days"` // 0 - срок не ограничен
+	TotalUsers         int                  `json:"total_users"`
+	NeverChanged       []string             `json:"never_changed"` // Пароль не менялся в системе (импорт)
+	MedianAgeDays      int                  `json:"median_age_days"`
+	OldestAgeDays      int                  `json:"oldest_age_days"`
+	Distribution       []PasswordAgeBucket  `json:"distribution"`
+	RotationsByMonth   []RotationMonth      `json:"rotations_by_month"`
+	Expired            []ExpiredPassword    `json:"expired"`
+	Accounts           []PasswordAgeAccount `json:"accounts"`
+}
+
+// PasswordAgeBucket - число паролей с возрастом в интервале [FromDays, ToDays)
+type PasswordAgeBucket struct {
+	Label    string `json:"label"`
+	FromDays int    `json:"from_days"`
+	ToDays   int    `json:"to_days,omitempty"` // 0 - без верхней границы
+	Count    int    `json:"count"`
+}
+
+// RotationMonth - число учетных записей, последний раз сменивших пароль в этом месяце
+type RotationMonth struct {
+	Month string `json:"month"` // ГГГГ-ММ
+	Count int    `json:"count"`
+}
+
+// ExpiredPassword - учетная запись с паролем старше MaxPasswordAge
+type ExpiredPassword struct {
+	Username    string     `json:"username"`
+	ChangedAt   time.Time  `json:"changed_at"`
+	AgeDays     int        `json:"age_days"`
+	OverdueDays int        `json:"overdue_days"`
+	RotationDue *time.Time `json:"rotation_due,omitempty"` // Назначенная смена пароля, если есть
+}
+
+// PasswordAgeAccount - дата последней смены пароля учетной записи
+type PasswordAgeAccount struct {
+	Username  string     `json:"username"`
+	ChangedAt time.Time  `json:"changed_at"`
+	AgeDays   int        `json:"age_days"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// PasswordAgingReport строит сводку возраста паролей по полю PasswordChangedAt.
+// Учетные записи-ловушки не учитываются, записи без даты смены пароля
+// перечисляются в NeverChanged и в распределение не входят.
+func (um *UserManager) PasswordAgingReport(now time.Time) PasswordAgingReport {
+	maxAge := um.recheck.MaxPasswordAge
+	report := PasswordAgingReport{
+		GeneratedAt:        now,
+		MaxPasswordAgeDays: int(maxAge.Hours() / 24),
+		NeverChanged:       []string{},
+		RotationsByMonth:   []RotationMonth{},
+		Expired:            []ExpiredPassword{},
+		Accounts:           []PasswordAgeAccount{},
+	}
+	from := 0
+	for _, to := range passwordAgeBuckets {
+		report.Distribution = append(report.Distribution, PasswordAgeBucket{Label: fmt.Sprintf("%d-%d дн.", from, to), FromDays: from, ToDays: to})
+		from = to
+	}
+	report.Distribution = append(report.Distribution, PasswordAgeBucket{Label: fmt.Sprintf("%d+ дн.", from), FromDays: from})
+
+	months := make(map[string]int)
+	var ages []int
+	for username, user := range um.store.GetAllUsers() {
+		if user.IsHoneypot {
+			continue
+		}
+		report.TotalUsers++
+		if user.PasswordChangedAt.IsZero() {
+			report.NeverChanged = append(report.NeverChanged, username)
+			continue
+		}
+
+		age := now.Sub(user.PasswordChangedAt)
+		days := max(int(age.Hours()/24), 0)
+		ages = append(ages, days)
+		bucket := len(passwordAgeBuckets)
+		for i, to := range passwordAgeBuckets {
+			if days < to {
+				bucket = i
+				break
+			}
+		}
+		report.Distribution[bucket].Count++
+		months[user.PasswordChangedAt.Format("2006-01")]++
+
+		account := PasswordAgeAccount{Username: username, ChangedAt: user.PasswordChangedAt, AgeDays: days}
+		if maxAge > 0 {
+			expiresAt := user.PasswordChangedAt.Add(maxAge)
+			account.ExpiresAt = &expiresAt
+			if age >= maxAge {
+				expired := ExpiredPassword{
+					Username:    username,
+					ChangedAt:   user.PasswordChangedAt,
+					AgeDays:     days,
+					OverdueDays: int((age - maxAge).Hours() / 24),
+				}
+				if !user.RotationDue.IsZero() {
+					rotationDue := user.RotationDue
+					expired.RotationDue = &rotationDue
+				}
+				report.Expired = append(report.Expired, expired)
+			}
+		}
+		report.Accounts = append(report.Accounts, account)
+	}
+
+	if len(ages) > 0 {
+		sort.Ints(ages)
+		report.MedianAgeDays = ages[len(ages)/2]
+		report.OldestAgeDays = ages[len(ages)-1]
+	}
+	for month, count := range months {
+		report.RotationsByMonth = append(report.RotationsByMonth, RotationMonth{Month: month, Count: count})
+	}
+	sort.Slice(report.RotationsByMonth, func(i, j int) bool {
+		return report.RotationsByMonth[i].Month < report.RotationsByMonth[j].Month
+	})
+	sort.Strings(report.NeverChanged)
+	// Сначала самые старые пароли
+	sort.Slice(report.Expired, func(i, j int) bool {
+		if report.Expired[i].AgeDays != report.Expired[j].AgeDays {
+			return report.Expired[i].AgeDays > report.Expired[j].AgeDays
+		}
+		return report.Expired[i].Username < report.Expired[j].Username
+	})
+	sort.Slice(report.Accounts, func(i, j int) bool {
+		return report.Accounts[i].Username < report.Accounts[j].Username
+	})
+	return report
+}
@@ -0,0 +1,1098 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiPrefix - версия API. Поля и коды ответов в пределах версии не меняются,
+// поэтому на API можно построить провайдер Terraform.
+const apiPrefix = "/v1"
+
+// APIUser - учетная запись в API
+type APIUser struct {
+	Username        string            `json:"username"`
+	Email           string            `json:"email"`
+	Role            string            `json:"role"`                      // user, admin или роль из конфигурации политики
+	Disabled        bool              `json:"disabled"`                  // Отключена администратором
+	PasswordHash    string            `json:"password_hash,omitempty"`   // Только при создании, в ответах не выдается
+	Blocked         bool              `json:"blocked"`                   // Только чтение: вход по паролю заблокирован
+	LockExpiresAt   *time.Time        `json:"lock_expires_at,omitempty"` // Только чтение: когда снимется блокировка (нет - без срока)
+	Groups          []string          `json:"groups"`                    // Группы (поле отсутствует - членство не меняется)
+	Attributes      map[string]string `json:"attributes"`                // Атрибуты для правил групп (поле отсутствует - не меняются)
+	PendingApproval bool              `json:"pending_approval"`          // Только чтение: регистрация ожидает одобрения
+	CreatedAt       time.Time         `json:"created_at"`                // Только чтение
+}
+
+// newAPIUser возвращает представление учетной записи для API
+func newAPIUser(user *User) APIUser {
+	// Блокировка с истекшим сроком снимается при следующей попытке входа
+	now := time.Now()
+	attributes := map[string]string{}
+	maps.Copy(attributes, user.Attributes)
+	return APIUser{
+		Username:        user.Username,
+		Email:           user.Email,
+		Role:            userRole(user),
+		Groups:          append([]string{}, user.Groups...),
+		Attributes:      attributes,
+		Disabled:        user.DisabledByAdmin,
+		Blocked:         user.IsBlocked && !lockExpired(user, now),
+		LockExpiresAt:   optionalTime(lockExpiresAt(user, now)),
+		PendingApproval: user.PendingApproval,
+		CreatedAt:       user.CreatedAt,
+	}
+}
+
+// ETag вычисляется только по управляемым через API полям: вход пользователя
+// или блокировка после неудачных попыток не приводят к конфликту при изменении.
+// Пустые группы и атрибуты не входят в ETag, поэтому он совпадает с прежними версиями.
+func (u APIUser) ETag() string {
+	return computeETag(struct {
+		Username   string
+		Email      string
+		Role       string
+		Disabled   bool
+		Groups     []string          `json:",omitempty"`
+		Attributes map[string]string `json:",omitempty"`
+	}{u.Username, u.Email, u.Role, u.Disabled, u.Groups, u.Attributes})
+}
+
+// manifestEntry преобразует учетную запись из запроса в запись файла состояния
+func (u APIUser) manifestEntry() ManifestUser {
+	email := u.Email
+	return ManifestUser{
+		Username:     u.Username,
+		Email:        &email,
+		Role:         u.Role,
+		Groups:       u.Groups,
+		Attributes:   u.Attributes,
+		Disabled:     u.Disabled,
+		PasswordHash: u.PasswordHash,
+	}
+}
+
+// isLoopbackAddr сообщает, что адрес host:port доступен только с этого узла
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// computeETag возвращает ETag для JSON-представления значения
+func computeETag(value interface{}) string {
+	data, _ := json.Marshal(value)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// APIServer - HTTP API для управления учетными записями и политикой как кодом.
+// Запросы обрабатываются по одному: менеджер пользователей рассчитан на один поток,
+// а проверка ETag и изменение должны выполняться атомарно.
+type APIServer struct {
+	um      *UserManager
+	token   string
+	primary *ReplicationPrimary // Передача изменений репликам (nil - не ведется)
+	replica *ReplicationReplica // Экземпляр - реплика: изменения через API запрещены до назначения основным
+	cluster *Cluster            // Узел кластера Raft: изменения принимает только лидер (nil - без кластера)
+	// Очереди запросов входа и регистрации: проверка пароля занимает процессор
+	admission *Admission
+	// Пакетная проверка паролей выполняется вне очереди запросов, с собственным ограничением
+	verifier *CredentialVerifier
+	// Выдача токенов доступа после успешного входа (nil - токены не выдаются)
+	tokens *TokenIssuer
+	// Вход через корпоративный IdP по SAML 2.0 (nil - не настроен)
+	saml *SAMLServiceProvider
+	// Вход по билету Kerberos (nil - не настроен)
+	kerberos *KerberosAcceptor
+	// УЦ сертификатов клиентов: вход по сертификату (nil - сертификаты не запрашиваются)
+	clientCAs *x509.CertPool
+	// Ключи API с ограниченными правами (-api-keys)
+	apiKeys []APIKey
+	// Обратные прокси, которым доверяется X-Forwarded-For (-api-trusted-proxies)
+	trustedProxies []*net.IPNet
+	// Время запуска по монотонным часам: с ним /readyz сверяет системные часы
+	started time.Time
+	// Операции, ожидающие второго участника по правилу двух лиц: "операция логин" -> запрос
+	approvals map[string]pendingApproval
+	mu        sync.Mutex
+}
+
+// Длина очередей входа и регистрации по умолчанию
+const (
+	defaultAuthQueue     = 256
+	defaultRegisterQueue = 64
+)
+
+// NewAPIServer создает API с доступом по токену
+func NewAPIServer(um *UserManager, token string) *APIServer {
+	return &APIServer{
+		um:        um,
+		token:     token,
+		admission: NewAdmission(defaultAuthQueue, defaultRegisterQueue),
+		verifier:  NewCredentialVerifier(um, runtime.NumCPU()),
+		started:   time.Now(),
+	}
+}
+
+// ReadAPIToken читает токен API из файла
+func ReadAPIToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения токена API: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) < 32 {
+		return "", fmt.Errorf("токен API в %s короче 32 символов", path)
+	}
+	return token, nil
+}
+
+// ServeHTTP проверяет токен, ключ API или сертификат и права на запрос и передает его обработчику ресурса
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	source := AuditSource{ClientIP: s.clientIP(r), RequestID: requestID(r)}
+	source.Device, source.DeviceName = requestDevice(r)
+	w.Header().Set(requestIDHeader, source.RequestID)
+	r = r.WithContext(withAuditSource(r.Context(), source))
+	if r.URL.Path == healthPath {
+		writeAPIJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if r.URL.Path == readyPath {
+		s.handleReady(w, r)
+		return
+	}
+	if r.URL.Path == jwksPath {
+		s.handleJWKS(w, r)
+		return
+	}
+	// Адреса SAML открывает браузер пользователя, подлинность ответа IdP подтверждает подпись
+	if strings.HasPrefix(r.URL.Path, samlPrefix+"/") {
+		s.handleSAML(w, r)
+		return
+	}
+	// Вход по билету Kerberos: учетные данные пользователя передаются в Authorization
+	if r.URL.Path == negotiatePath {
+		s.handleNegotiate(w, r)
+		return
+	}
+	// Вход по сертификату: учетные данные - сертификат клиента, предъявленный при установке TLS
+	if r.URL.Path == certificatePath {
+		s.handleCertificateAuth(w, r)
+		return
+	}
+
+	// Права ролей и ключей API берутся из политики, которую PUT /policy и SIGHUP меняют
+	// под той же блокировкой
+	unlock := s.lockRequest(r)
+	principal, ok := s.apiPrincipal(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, CodeTokenRequired, "требуется токен API")
+	} else {
+		r, ok = s.authorizeAPI(w, r, principal)
+	}
+	unlock()
+	if !ok {
+		return
+	}
+
+	// Пакетная проверка не меняет учетные записи и не ждет других запросов
+	if r.URL.Path == apiPrefix+"/verify" {
+		s.handleVerify(w, r)
+		return
+	}
+	if r.URL.Path == apiPrefix+"/introspect" {
+		s.handleIntrospect(w, r)
+		return
+	}
+	// Олицетворение не меняет учетные записи: токен выдается и на реплике
+	if r.URL.Path == apiPrefix+"/impersonate" {
+		s.handleImpersonate(w, r)
+		return
+	}
+
+	// Вход и регистрация ждут проверки пароля в ограниченных очередях, вход - с приоритетом
+	if lane, limited := admissionLane(r); limited {
+		release, ok := s.admit(w, r, lane)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	defer s.lockRequest(r)()
+	// В пробном запуске запланированные изменения выводятся, а не накапливаются
+	defer func() {
+		for _, change := range s.um.DryRunPlan() {
+			fmt.Printf("Пробный запуск, %s %s: %s\n", r.Method, r.URL.Path, change)
+		}
+	}()
+
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	switch {
+	case !strings.HasPrefix(r.URL.Path, apiPrefix+"/"):
+		writeAPIError(w, http.StatusNotFound, CodeUnknownResource, "неизвестный ресурс")
+	case path == "/replication" || path == "/replication/promote":
+		s.handleReplication(w, r, path == "/replication/promote")
+	case path == "/cluster":
+		s.handleCluster(w, r)
+	case r.Method != http.MethodGet && s.replica != nil && s.replica.readOnly:
+		writeAPIError(w, http.StatusForbidden, CodeNotWritable, "реплика только для чтения: изменения принимает основной экземпляр")
+	case r.Method != http.MethodGet && s.replica != nil && !s.replica.Promoted():
+		writeAPIError(w, http.StatusServiceUnavailable, CodeNotWritable, "экземпляр - реплика: изменения принимает основной экземпляр")
+	case r.Method != http.MethodGet && s.cluster != nil:
+		s.handleClusterWrite(w, r, path)
+	default:
+		s.route(w, r, path)
+	}
+}
+
+// admit ждет места в очереди lane; если очередь заполнена, отвечает 429 с Retry-After
+func (s *APIServer) admit(w http.ResponseWriter, r *http.Request, lane AdmissionLane) (func(), bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), admissionMaxWait)
+	release, retryAfter, ok := s.admission.Acquire(ctx, lane)
+	cancel()
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		writeAPIError(w, http.StatusTooManyRequests, CodeOverloaded, "сервер перегружен, повторите запрос позже")
+		return nil, false
+	}
+	return release, true
+}
+
+// admissionLane возвращает очередь запроса, которому нужна проверка пароля
+func admissionLane(r *http.Request) (AdmissionLane, bool) {
+	if r.Method != http.MethodPost {
+		return 0, false
+	}
+	switch r.URL.Path {
+	case apiPrefix + "/auth":
+		return LaneAuth, true
+	case apiPrefix + "/register":
+		return LaneRegister, true
+	}
+	return 0, false
+}
+
+// route передает запрос обработчику ресурса
+func (s *APIServer) route(w http.ResponseWriter, r *http.Request, path string) {
+	switch {
+	case path == "/users":
+		s.handleUsers(w, r)
+	case strings.HasPrefix(path, "/users/") && !strings.Contains(path[len("/users/"):], "/"):
+		s.handleUser(w, r, path[len("/users/"):])
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/ssh-keys") && strings.Count(path, "/") == 3:
+		s.handleSSHKeys(w, r, strings.TrimSuffix(path[len("/users/"):], "/ssh-keys"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/authorized-keys") && strings.Count(path, "/") == 3:
+		s.handleAuthorizedKeys(w, r, strings.TrimSuffix(path[len("/users/"):], "/authorized-keys"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/certificates") && strings.Count(path, "/") == 3:
+		s.handleCertBindings(w, r, strings.TrimSuffix(path[len("/users/"):], "/certificates"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/unlock") && strings.Count(path, "/") == 3:
+		s.handleUserUnlock(w, r, strings.TrimSuffix(path[len("/users/"):], "/unlock"))
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/audit") && strings.Count(path, "/") == 3:
+		s.handleUserAudit(w, r, strings.TrimSuffix(path[len("/users/"):], "/audit"))
+	case path == "/groups":
+		s.handleGroups(w, r)
+	case strings.HasPrefix(path, "/groups/") && !strings.Contains(path[len("/groups/"):], "/"):
+		s.handleGroup(w, r, path[len("/groups/"):])
+	case strings.HasPrefix(path, "/groups/") && strings.Count(path, "/") == 4 && strings.Contains(path, "/members/"):
+		name, username, _ := strings.Cut(path[len("/groups/"):], "/members/")
+		s.handleGroupMember(w, r, name, username)
+	case path == "/policy":
+		s.handlePolicy(w, r)
+	case path == "/auth":
+		s.handleAuth(w, r)
+	case path == "/register":
+		s.handleRegister(w, r)
+	case path == "/report":
+		s.handleReport(w, r)
+	case path == "/report/password-age":
+		s.handlePasswordAging(w, r)
+	case path == "/metrics":
+		s.handleMetrics(w, r)
+	case path == "/errors":
+		s.handleErrorCodes(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, CodeUnknownResource, "неизвестный ресурс")
+	}
+}
+
+// handleUsers: GET - список учетных записей, POST - создание
+func (s *APIServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		group := r.URL.Query().Get("group")
+		if _, exists := s.um.Group(group); group != "" && !exists {
+			writeAPIError(w, http.StatusNotFound, CodeGroupNotFound, "группа не найдена")
+			return
+		}
+		users := []APIUser{}
+		for _, user := range s.um.store.GetAllUsers() {
+			if !user.IsHoneypot && (group == "" || s.um.inGroup(user, group)) {
+				users = append(users, newAPIUser(user))
+			}
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+		writeAPIJSON(w, http.StatusOK, users)
+	case http.MethodPost:
+		var request APIUser
+		if !readAPIJSON(w, r, &request) {
+			return
+		}
+		if s.um.store.UserExists(strings.TrimSpace(request.Username)) {
+			writeAPIError(w, http.StatusConflict, CodeUserExists, "учетная запись уже существует")
+			return
+		}
+		if !s.authorizeRole(w, r, RoleUser, request.Role) {
+			return
+		}
+		s.applyUser(w, request, http.StatusCreated)
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+	}
+}
+
+// handleUser: GET - учетная запись, PUT - изменение, DELETE - удаление.
+// PUT и DELETE с заголовком If-Match выполняются, только если учетная запись не менялась.
+func (s *APIServer) handleUser(w http.ResponseWriter, r *http.Request, username string) {
+	user, exists := s.um.store.GetUser(username)
+	if !exists || user.IsHoneypot {
+		writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+		return
+	}
+	current := newAPIUser(user)
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("ETag", current.ETag())
+		writeAPIJSON(w, http.StatusOK, current)
+	case http.MethodPut:
+		var request APIUser
+		if !readAPIJSON(w, r, &request) || !checkIfMatch(w, r, current.ETag()) {
+			return
+		}
+		if request.Username != username {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "логин в теле запроса не совпадает с адресом ресурса")
+			return
+		}
+		if !s.authorizeRole(w, r, current.Role, request.Role) {
+			return
+		}
+		s.applyUser(w, request, http.StatusOK)
+	case http.MethodDelete:
+		if !checkIfMatch(w, r, current.ETag()) || !s.authorizeRole(w, r, current.Role, current.Role) {
+			return
+		}
+		reason, ok := readAPIReason(w, r)
+		if !ok {
+			return
+		}
+		if reason, ok = s.approve(w, r, dualControlDelete, username, reason); !ok {
+			return
+		}
+		if _, err := s.um.RemoveUser(username, reason); err != nil {
+			writeAPIError(w, http.StatusConflict, CodeUserRejected, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+// applyUser создает или изменяет учетную запись так же, как файл состояния с одной записью,
+// и возвращает ее новое состояние
+func (s *APIServer) applyUser(w http.ResponseWriter, request APIUser, status int) {
+	manifest := UserManifest{Users: []ManifestUser{request.manifestEntry()}}
+	if _, err := s.um.ApplyUserManifest(manifest, "запрос API"); err != nil {
+		s.writeUserError(w, err, CodeUserRejected)
+		return
+	}
+
+	user, exists := s.um.store.GetUser(strings.TrimSpace(request.Username))
+	if !exists {
+		// Пробный запуск: учетная запись не создана
+		request.PasswordHash = ""
+		writeAPIJSON(w, status, request)
+		return
+	}
+	result := newAPIUser(user)
+	w.Header().Set("ETag", result.ETag())
+	writeAPIJSON(w, status, result)
+}
+
+// handlePolicy: GET - действующая политика, PUT - изменение. Поля, отсутствующие в запросе,
+// не меняются, как и в файле политики. Обработчики и условия использования задаются
+// только файлом политики: через API нельзя назначить запуск внешних программ.
+func (s *APIServer) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	current := s.um.CurrentPolicyConfig()
+	etag := computeETag(current)
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("ETag", etag)
+		writeAPIJSON(w, http.StatusOK, current)
+	case http.MethodPut:
+		var config PolicyConfig
+		if !readAPIJSON(w, r, &config) || !checkIfMatch(w, r, etag) {
+			return
+		}
+		if config.Hooks != nil || config.Terms != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodePolicyRejected, "hooks и terms задаются только файлом политики")
+			return
+		}
+		// Права ролей меняет только администратор: иначе policy.manage позволяло бы расширить свою роль
+		if config.Roles != nil && !s.authorizeRole(w, r, RoleAdmin, RoleAdmin) {
+			return
+		}
+		if _, err := s.um.ApplyPolicyConfig(config); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodePolicyRejected, err.Error())
+			return
+		}
+		current = s.um.CurrentPolicyConfig()
+		w.Header().Set("ETag", computeETag(current))
+		writeAPIJSON(w, http.StatusOK, current)
+	default:
+		writeMethodNotAllowed(w, "GET, PUT")
+	}
+}
+
+// APIAuthRequest - запрос проверки учетных данных
+type APIAuthRequest struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	AcceptTerms string `json:"accept_terms,omitempty"` // Принимаемая редакция условий использования
+}
+
+// APIAuthResponse - результат проверки учетных данных
+type APIAuthResponse struct {
+	Result            string           `json:"result"`
+	Code              ErrorCode        `json:"code,omitempty"` // Код ошибки из каталога /v1/errors при неудачном входе
+	Message           string           `json:"message"`
+	RetryAfter        int              `json:"retry_after,omitempty"` // Через сколько секунд вход станет возможен
+	TermsVersion      string           `json:"terms_version,omitempty"`
+	PasswordExpiresAt *time.Time       `json:"password_expires_at,omitempty"`
+	LockExpiresAt     *time.Time       `json:"lock_expires_at,omitempty"` // Когда вход снова станет возможен
+	AccessToken       string           `json:"access_token,omitempty"`    // JWT при успешном входе, если заданы ключи подписи
+	TokenType         string           `json:"token_type,omitempty"`
+	ExpiresIn         int              `json:"expires_in,omitempty"`    // Срок действия токена, секунд
+	Username          string           `json:"username,omitempty"`      // Учетная запись при успешном входе
+	ProfileSteps      []ProfileStepDue `json:"profile_steps,omitempty"` // Шаги профиля, которые пора выполнить
+}
+
+// apiAuthResults - коды результатов входа в API. Несуществующий пользователь
+// не отличается от неверного пароля, чтобы через API нельзя было перебирать логины.
+var apiAuthResults = map[AuthResult]string{
+	AuthSuccess:              "success",
+	AuthInvalidCredentials:   "invalid_credentials",
+	AuthUserNotFound:         "invalid_credentials",
+	AuthUserBlocked:          "blocked",
+	AuthOutsideSchedule:      "outside_schedule",
+	AuthPasswordExpired:      "password_expired",
+	AuthSourceBlocked:        "temporarily_blocked",
+	AuthAccountDisabled:      "disabled",
+	AuthRejectedByHook:       "rejected",
+	AuthPendingApproval:      "pending_approval",
+	AuthTermsRequired:        "terms_required",
+	AuthSAMLNotLinked:        "saml_not_linked",
+	AuthKerberosNotMapped:    "kerberos_not_mapped",
+	AuthCertificateNotMapped: "certificate_not_mapped",
+	AuthSecondFactorRequired: "second_factor_required",
+	AuthProfileRequired:      "profile_required",
+	AuthRejectedByRule:       "rejected_by_rule",
+}
+
+// handleAuth: POST /auth - проверка логина и пароля для сервиса, принимающего вход
+// пользователей. Успех - 200, отказ - 401 с кодом причины.
+func (s *APIServer) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	var request APIAuthRequest
+	if !readAPIJSON(w, r, &request) {
+		return
+	}
+
+	outcome, err := s.um.authenticate(request.Username, request.Password, request.AcceptTerms)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	s.writeAuthOutcome(w, outcome, strings.TrimSpace(request.Username))
+}
+
+// writeAuthOutcome отправляет результат входа: успех - 200 и токен доступа, если выдача
+// токенов включена, отказ - 401 с кодом причины
+func (s *APIServer) writeAuthOutcome(w http.ResponseWriter, outcome AuthOutcome, username string) {
+	if outcome.Result == AuthUserNotFound {
+		outcome = AuthOutcome{Result: AuthInvalidCredentials}
+	}
+	response := APIAuthResponse{
+		Result:            apiAuthResults[outcome.Result],
+		Code:              authErrorCodes[outcome.Result],
+		Message:           outcome.String(),
+		RetryAfter:        int(outcome.RetryAfter.Round(time.Second) / time.Second),
+		TermsVersion:      outcome.TermsVersion,
+		PasswordExpiresAt: optionalTime(outcome.PasswordExpiresAt),
+		LockExpiresAt:     optionalTime(outcome.LockedUntil),
+		ProfileSteps:      outcome.ProfileSteps,
+	}
+	if outcome.Result != AuthSuccess {
+		writeAPIJSON(w, http.StatusUnauthorized, response)
+		return
+	}
+	response.Username = username
+	if user, exists := s.um.store.GetUser(username); exists && s.tokens != nil {
+		token, claims, err := s.tokens.Issue(user, time.Now())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		response.AccessToken, response.TokenType = token, "Bearer"
+		response.ExpiresIn = int(claims.ExpiresAt - claims.IssuedAt)
+	}
+	writeAPIJSON(w, http.StatusOK, response)
+}
+
+// handleJWKS: GET /.well-known/jwks.json - открытые ключи проверки токенов доступа
+func (s *APIServer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if s.tokens == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "токены доступа не выдаются (-jwt-keys)")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeAPIJSON(w, http.StatusOK, PublicJWKS(s.tokens.keys))
+}
+
+// APIIntrospection - ответ проверки токена доступа (RFC 7662). Для недействительного
+// токена содержит только active = false, без причины.
+type APIIntrospection struct {
+	Active    bool   `json:"active"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Role      string `json:"role,omitempty"` // Текущая роль учетной записи
+	TokenType string `json:"token_type,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	ID        string `json:"jti,omitempty"`
+	// Администратор, действующий от имени пользователя (токен олицетворения)
+	Actor *TokenActor `json:"act,omitempty"`
+}
+
+// tokenRevoked сообщает, что токен выдан раньше, чем создана учетная запись, изменен ее
+// пароль или отозваны ее токены: такой токен выдан удаленной учетной записи с тем же
+// логином или до смены учетных данных. Время сравнивается с точностью до секунды (iat).
+func tokenRevoked(user *User, claims AccessClaims) bool {
+	for _, since := range []time.Time{user.CreatedAt, user.PasswordChangedAt, user.TokensRevokedAt} {
+		if claims.IssuedAt < since.Unix() {
+			return true
+		}
+	}
+	return false
+}
+
+// accessTokenUser возвращает учетную запись, для которой действует токен с верной подписью
+// и сроком: учетная запись существует, вход для нее не запрещен и токен не отозван. Токен
+// олицетворения, кроме того, действует, пока олицетворение включено, а выдавший его
+// администратор сохраняет роль и не менял пароль.
+func (um *UserManager) accessTokenUser(claims AccessClaims) (*User, bool) {
+	user, exists := um.store.GetUser(claims.Subject)
+	if !exists || user.IsHoneypot || user.IsBlocked || user.DisabledByAdmin || user.PendingApproval ||
+		tokenRevoked(user, claims) || !um.impersonationActive(claims.Actor) {
+		return nil, false
+	}
+	if claims.Actor != nil {
+		if actor, _ := um.store.GetUser(claims.Actor.Subject); tokenRevoked(actor, claims) {
+			return nil, false
+		}
+	}
+	return user, true
+}
+
+// handleIntrospect: POST /introspect - проверка токена доступа для сервисов (RFC 7662).
+// Токен передается полем формы token. Действителен токен с верной подписью и сроком,
+// для которого accessTokenUser находит учетную запись.
+func (s *APIServer) handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("некорректная форма: %v", err))
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "не указан токен (поле token)")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if s.tokens == nil {
+		writeAPIJSON(w, http.StatusOK, APIIntrospection{})
+		return
+	}
+	claims, err := s.tokens.Verify(token, time.Now())
+	if err != nil {
+		writeAPIJSON(w, http.StatusOK, APIIntrospection{})
+		return
+	}
+	// Включено ли олицетворение, решает политика: она меняется только под блокировкой API
+	defer s.lockRequest(r)()
+	user, active := s.um.accessTokenUser(claims)
+	if !active {
+		writeAPIJSON(w, http.StatusOK, APIIntrospection{})
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, APIIntrospection{
+		Active:    true,
+		Username:  user.Username,
+		Subject:   claims.Subject,
+		Role:      newAPIUser(user).Role,
+		TokenType: "Bearer",
+		Issuer:    claims.Issuer,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+		ID:        claims.ID,
+		Actor:     claims.Actor,
+	})
+}
+
+// handleVerify: POST /verify - пакетная проверка паролей для сценариев миграции.
+// Запрос - список {username, password}, ответ - результаты в том же порядке. Проверка не
+// считается попыткой входа, поэтому не ограничивается очередями и не блокирует вход.
+func (s *APIServer) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	var candidates []CredentialCandidate
+	if !readAPIJSON(w, r, &candidates) {
+		return
+	}
+	results, err := s.verifier.Verify(r.Context(), candidates)
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, results)
+}
+
+// APIRegisterRequest - запрос регистрации пользователя с паролем
+type APIRegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Invite   string `json:"invite,omitempty"` // Приглашение, если регистрация только по приглашениям
+}
+
+// handleRegister: POST /register - регистрация так же, как с консоли: пароль проверяется
+// политикой и хешируется на сервере
+func (s *APIServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	var request APIRegisterRequest
+	if !readAPIJSON(w, r, &request) {
+		return
+	}
+	// В режиме admin запрос отклоняется до проверки логина: ответ не раскрывает учетные записи
+	if s.um.RegistrationMode() == RegistrationAdmin {
+		s.writeUserError(w, errRegistrationClosed, CodeUserRejected)
+		return
+	}
+	username := strings.TrimSpace(request.Username)
+	if s.um.store.UserExists(username) {
+		writeAPIError(w, http.StatusConflict, CodeUserExists, "учетная запись уже существует")
+		return
+	}
+
+	if err := s.um.RegisterUserWithInvite(username, request.Password, request.Invite); err != nil {
+		s.writeUserError(w, err, CodeUserRejected)
+		return
+	}
+	user, _ := s.um.store.GetUser(username)
+	result := newAPIUser(user)
+	w.Header().Set("ETag", result.ETag())
+	writeAPIJSON(w, http.StatusCreated, result)
+}
+
+// handleReport: GET /report?period=30d&dormant=90d&top=5&format=json - отчет об активности
+// в формате json, csv или table
+func (s *APIServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	query := r.URL.Query()
+	param := func(name, fallback string) string {
+		if value := query.Get(name); value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	period, err := ParsePeriod(param("period", "30d"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "period: "+err.Error())
+		return
+	}
+	dormantAfter, err := ParsePeriod(param("dormant", "90d"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "dormant: "+err.Error())
+		return
+	}
+	top, err := strconv.Atoi(param("top", "5"))
+	if err != nil || top < 0 {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "top: ожидается неотрицательное число")
+		return
+	}
+	report := s.um.withoutHoneypots(s.um.ActivityReport(time.Now().Add(-period), dormantAfter, top))
+
+	switch param("format", "json") {
+	case "json":
+		writeAPIJSON(w, http.StatusOK, report)
+	case "csv":
+		output, err := report.FormatCSV()
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		io.WriteString(w, output)
+	case "table":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, report.FormatTable())
+	default:
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "format: допустимо json, csv, table")
+	}
+}
+
+// handlePasswordAging: GET /report/password-age - распределение возраста паролей, даты последней
+// смены и пароли старше MaxPasswordAge в формате JSON для панелей мониторинга
+func (s *APIServer) handlePasswordAging(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, s.um.PasswordAgingReport(time.Now()))
+}
+
+// handleMetrics: GET /metrics - учетные записи по состояниям и состояние репликации
+// в текстовом формате Prometheus
+func (s *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	metrics := s.um.UserMetrics()
+
+	var out strings.Builder
+	gauge := func(name, help string, samples ...string) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, sample := range samples {
+			out.WriteString(name + sample + "\n")
+		}
+	}
+	gauge("uas_accounts", "Учетные записи по состоянию",
+		fmt.Sprintf(`{state="total"} %d`, metrics.Total),
+		fmt.Sprintf(`{state="admin"} %d`, metrics.Admins),
+		fmt.Sprintf(`{state="blocked"} %d`, metrics.Blocked),
+		fmt.Sprintf(`{state="disabled"} %d`, metrics.Disabled),
+		fmt.Sprintf(`{state="pending_approval"} %d`, metrics.PendingApproval),
+		fmt.Sprintf(`{state="dormant"} %d`, metrics.Dormant))
+	if pepper != nil {
+		// Прежний ключ перца можно удалить из файла, когда его хешей не осталось
+		usage := s.um.PepperUsage()
+		var samples []string
+		for _, id := range append([]string{""}, pepper.IDs()...) {
+			samples = append(samples, pepperUsageSample(id, usage[id]))
+			delete(usage, id)
+		}
+		unknown := make([]string, 0, len(usage))
+		for id := range usage {
+			unknown = append(unknown, id)
+		}
+		sort.Strings(unknown)
+		for _, id := range unknown {
+			samples = append(samples, pepperUsageSample(id, usage[id]))
+		}
+		gauge("uas_password_hashes", "Хеши паролей по ключу перца (none - без перца)", samples...)
+	}
+
+	// Доля успешных входов и задержка за скользящие окна: падение после изменения
+	// конфигурации (например, слишком строгой блокировки) видно до жалоб пользователей
+	stats, now := s.um.LoginStats(), time.Now()
+	totals, latency := stats.Totals()
+	results := make([]string, 0, len(totals))
+	for result := range totals {
+		results = append(results, result)
+	}
+	sort.Strings(results)
+	fmt.Fprintf(&out, "# HELP uas_login_attempts_total Попытки входа по результату\n# TYPE uas_login_attempts_total counter\n")
+	for _, result := range results {
+		fmt.Fprintf(&out, "uas_login_attempts_total{result=\"%s\"} %d\n", result, totals[result])
+	}
+	fmt.Fprintf(&out, "# HELP uas_login_latency_seconds_total Суммарная задержка проверки входа\n# TYPE uas_login_latency_seconds_total counter\nuas_login_latency_seconds_total %g\n", latency.Seconds())
+	var ratios, quantiles []string
+	for _, window := range loginStatsWindows {
+		w := stats.Window(window, now)
+		label := strings.TrimSuffix(strings.TrimSuffix(window.String(), "0s"), "0m")
+		ratios = append(ratios, fmt.Sprintf(`{window="%s"} %g`, label, w.SuccessRatio))
+		for i, quantile := range loginQuantiles {
+			quantiles = append(quantiles, fmt.Sprintf(`{window="%s",quantile="%g"} %g`, label, quantile, w.Latency[i].Seconds()))
+		}
+	}
+	gauge("uas_login_success_ratio", "Доля успешных входов за окно (1 - попыток не было)", ratios...)
+	gauge("uas_login_success_objective", "Цель по доле успешных входов", fmt.Sprintf(" %g", stats.Objective()))
+	gauge("uas_login_latency_quantile_seconds", "Задержка проверки входа за окно по перцентилям", quantiles...)
+
+	var replication *ReplicationStatus
+	switch {
+	case s.replica != nil:
+		status := s.replica.Status()
+		replication = &status
+	case s.primary != nil:
+		status := s.primary.Status()
+		replication = &status
+	}
+	if replication != nil {
+		connected := 0
+		if replication.Connected {
+			connected = 1
+		}
+		gauge("uas_replication_seq", "Номер последнего изменения хранилища", fmt.Sprintf(" %d", replication.Seq))
+		gauge("uas_replication_connected", "Реплика получает изменения или к основному экземпляру подключены реплики", fmt.Sprintf(" %d", connected))
+		if replication.LastUpdate != nil {
+			gauge("uas_replication_last_update_seconds", "Время последнего сообщения основного экземпляра (Unix)", fmt.Sprintf(" %d", replication.LastUpdate.Unix()))
+		}
+	}
+	var queued, limits, rejected []string
+	for _, lane := range s.admission.Status() {
+		queued = append(queued, fmt.Sprintf(`{lane="%s"} %d`, lane.Lane, lane.Queued))
+		limits = append(limits, fmt.Sprintf(`{lane="%s"} %d`, lane.Lane, lane.Limit))
+		rejected = append(rejected, fmt.Sprintf(`{lane="%s"} %d`, lane.Lane, lane.Rejected))
+	}
+	gauge("uas_admission_queued", "Запросов в очереди на проверку пароля", queued...)
+	gauge("uas_admission_queue_limit", "Наибольшая длина очереди", limits...)
+	fmt.Fprintf(&out, "# HELP uas_admission_rejected_total Отказов 429 из-за переполнения очереди\n# TYPE uas_admission_rejected_total counter\n")
+	for _, sample := range rejected {
+		out.WriteString("uas_admission_rejected_total" + sample + "\n")
+	}
+	if s.cluster != nil {
+		status := s.cluster.Status()
+		leader := 0
+		if status.State == "Leader" {
+			leader = 1
+		}
+		gauge("uas_raft_leader", "Узел - лидер кластера Raft", fmt.Sprintf(" %d", leader))
+		gauge("uas_raft_applied_index", "Последняя примененная запись журнала Raft", fmt.Sprintf(" %d", status.AppliedIndex))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, out.String())
+}
+
+// pepperUsageSample формирует значение метрики хешей для ключа перца
+func pepperUsageSample(id string, count int) string {
+	if id == "" {
+		id = "none"
+	}
+	return fmt.Sprintf(`{pepper="%s"} %d`, id, count)
+}
+
+// handleReplication: GET /replication - состояние репликации,
+// POST /replication/promote - назначение реплики основным экземпляром
+func (s *APIServer) handleReplication(w http.ResponseWriter, r *http.Request, promote bool) {
+	if !promote {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, "GET")
+			return
+		}
+		switch {
+		case s.replica != nil:
+			writeAPIJSON(w, http.StatusOK, s.replica.Status())
+		case s.primary != nil:
+			writeAPIJSON(w, http.StatusOK, s.primary.Status())
+		default:
+			writeAPIJSON(w, http.StatusOK, ReplicationStatus{Role: "primary"})
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	if s.replica == nil {
+		writeAPIError(w, http.StatusConflict, CodeReplicationFailed, "экземпляр не является репликой")
+		return
+	}
+	if err := s.replica.Promote(); err != nil {
+		writeAPIError(w, http.StatusConflict, CodeReplicationFailed, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, s.replica.Status())
+}
+
+// handleCluster: GET /cluster - состояние узла кластера Raft
+func (s *APIServer) handleCluster(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method != http.MethodGet:
+		writeMethodNotAllowed(w, "GET")
+	case s.cluster == nil:
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "экземпляр запущен без кластера")
+	default:
+		writeAPIJSON(w, http.StatusOK, s.cluster.Status())
+	}
+}
+
+// handleClusterWrite выполняет изменяющий запрос на лидере кластера. Ответ отправляется
+// только после фиксации изменений большинством узлов; политика реплицируется файлом,
+// а не через API, иначе узлы разошлись бы в правилах.
+func (s *APIServer) handleClusterWrite(w http.ResponseWriter, r *http.Request, path string) {
+	if path == "/policy" {
+		writeAPIError(w, http.StatusConflict, CodePolicyRejected, "в кластере политика задается файлом -policy-config на каждом узле")
+		return
+	}
+	// Описания групп - часть политики, а членство хранится в учетных записях
+	if strings.HasPrefix(path, "/groups") && !strings.Contains(path, "/members/") {
+		writeAPIError(w, http.StatusConflict, CodeGroupRejected, "в кластере группы задаются в разделе groups файла -policy-config на каждом узле")
+		return
+	}
+	s.clusterWrite(w, func(w http.ResponseWriter) { s.route(w, r, path) })
+}
+
+// writableLogin выполняет вход вне POST /auth (SAML, Kerberos) там, где принимаются
+// изменения: вход меняет учетную запись (время входа, создание). Вызывается под s.mu.
+func (s *APIServer) writableLogin(w http.ResponseWriter, login func(http.ResponseWriter)) {
+	switch {
+	case s.replica != nil && s.replica.readOnly:
+		writeAPIError(w, http.StatusForbidden, CodeNotWritable, "реплика только для чтения: вход принимает основной экземпляр")
+	case s.replica != nil && !s.replica.Promoted():
+		writeAPIError(w, http.StatusServiceUnavailable, CodeNotWritable, "экземпляр - реплика: вход принимает основной экземпляр")
+	case s.cluster != nil:
+		s.clusterWrite(w, login)
+	default:
+		login(w)
+	}
+}
+
+// applyDormancyPolicy выполняет проход политики неактивности там, где принимаются изменения:
+// реплика получает его результат от основного экземпляра, узел кластера - от лидера
+func (s *APIServer) applyDormancyPolicy(now time.Time) (DormancyResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.um.FeatureEnabled(FeatureDormancy) || (s.replica != nil && (s.replica.readOnly || !s.replica.Promoted())) ||
+		(s.cluster != nil && !s.cluster.Writable()) {
+		return DormancyResult{}, nil
+	}
+	result := s.um.ApplyDormancyPolicy(now)
+	if s.cluster != nil {
+		return result, s.cluster.Commit()
+	}
+	return result, nil
+}
+
+// clusterWrite выполняет изменение на лидере и отправляет ответ после фиксации
+func (s *APIServer) clusterWrite(w http.ResponseWriter, handle func(http.ResponseWriter)) {
+	if !s.cluster.Writable() {
+		message := "узел кластера не лидер: изменения принимает лидер"
+		if id, addr := s.cluster.Leader(); id != "" {
+			message += fmt.Sprintf(" %s (Raft %s)", id, addr)
+		}
+		writeAPIError(w, http.StatusServiceUnavailable, CodeNotWritable, message)
+		return
+	}
+	response := &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+	handle(response)
+	if err := s.cluster.Commit(); err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, CodeNotWritable, err.Error())
+		return
+	}
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body.Bytes())
+}
+
+// bufferedResponse задерживает ответ обработчика до фиксации изменений кластером
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// checkIfMatch проверяет заголовок If-Match. Без заголовка изменение выполняется безусловно.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	w.Header().Set("ETag", etag)
+	writeAPIError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "ресурс изменен с момента чтения")
+	return false
+}
+
+// readAPIJSON разбирает тело запроса; при ошибке отвечает 400
+func readAPIJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("некорректный JSON: %v", err))
+		return false
+	}
+	return true
+}
+
+// writeAPIJSON отправляет ответ в формате JSON
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// writeMethodNotAllowed отвечает 405 со списком допустимых методов
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeAPIError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "метод не поддерживается")
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAPIToken - токен API тестового сервера
+const testAPIToken = "test-api-token-0123456789abcdef0123"
+
+// newTestAPIServer возвращает API с выдачей токенов доступа, администратором root и
+// пользователем bob
+func newTestAPIServer(t *testing.T) *APIServer {
+	t.Helper()
+	keys, _, err := LoadOrCreateKeySet(filepath.Join(t.TempDir(), "jwt.keys"), KeyJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewAPIServer(NewUserManager(), testAPIToken)
+	s.tokens = NewTokenIssuer(keys, "test", time.Hour)
+	created := time.Now().Add(-time.Hour)
+	s.um.store.SaveUser(&User{Username: "root", HashedPassword: "synthetic", IsAdmin: true, CreatedAt: created, PasswordChangedAt: created})
+	s.um.store.SaveUser(&User{Username: "bob", HashedPassword: "synthetic", CreatedAt: created, PasswordChangedAt: created})
+	return s
+}
+
+// serveTestAPI выполняет запрос к API с токеном API
+func serveTestAPI(s *APIServer, method, path, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testAPIToken)
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// introspect возвращает ответ POST /v1/introspect для токена
+func introspect(t *testing.T, s *APIServer, token string) APIIntrospection {
+	t.Helper()
+	w := serveTestAPI(s, http.MethodPost, apiPrefix+"/introspect", "application/x-www-form-urlencoded",
+		url.Values{"token": {token}}.Encode())
+	var result APIIntrospection
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("ответ introspect %d: %s", w.Code, w.Body.String())
+	}
+	return result
+}
+
+// TestImpersonationFeatureToggle проверяет олицетворение и проверку токенов одновременно
+// с переключением features.impersonation (go test -race находит гонку за политику)
+func TestImpersonationFeatureToggle(t *testing.T) {
+	s := newTestAPIServer(t)
+	root, _ := s.um.store.GetUser("root")
+	adminToken, _, err := s.tokens.Issue(root, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	impersonate := `{"actor_token": "` + adminToken + `", "username": "bob", "reason": "проверка"}`
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			enabled := i%2 == 1
+			body := `{"features": {"impersonation": ` + map[bool]string{true: "true", false: "false"}[enabled] + `}}`
+			if w := serveTestAPI(s, http.MethodPut, apiPrefix+"/policy", "application/json", body); w.Code != http.StatusOK {
+				t.Errorf("PUT /policy: %d %s", w.Code, w.Body.String())
+				return
+			}
+		}
+	}()
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				w := serveTestAPI(s, http.MethodPost, apiPrefix+"/impersonate", "application/json", impersonate)
+				if w.Code != http.StatusOK && w.Code != http.StatusForbidden {
+					t.Errorf("POST /impersonate: %d %s", w.Code, w.Body.String())
+					return
+				}
+				var issued APIImpersonationResponse
+				if w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &issued) == nil {
+					introspect(t, s, issued.AccessToken)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Последнее изменение политики включило олицетворение; после отключения токены не действуют
+	w := serveTestAPI(s, http.MethodPost, apiPrefix+"/impersonate", "application/json", impersonate)
+	var issued APIImpersonationResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &issued) != nil {
+		t.Fatalf("POST /impersonate: %d %s", w.Code, w.Body.String())
+	}
+	serveTestAPI(s, http.MethodPut, apiPrefix+"/policy", "application/json", `{"features": {"impersonation": false}}`)
+	if w := serveTestAPI(s, http.MethodPost, apiPrefix+"/impersonate", "application/json", impersonate); w.Code != http.StatusForbidden {
+		t.Errorf("олицетворение после отключения: %d", w.Code)
+	}
+	if result := introspect(t, s, issued.AccessToken); result.Active {
+		t.Errorf("токен олицетворения действует после отключения")
+	}
+}
+
+// TestIntrospectRevokedToken проверяет, что токен не действует для новой учетной записи с
+// тем же логином, после смены пароля, переименования и восстановления
+func TestIntrospectRevokedToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(um *UserManager) error
+	}{
+		{"удаление и повторная регистрация", func(um *UserManager) error {
+			if _, err := um.RemoveUser("bob", "проверка"); err != nil {
+				return err
+			}
+			return um.RegisterUser("bob", "Correct-Horse-12")
+		}},
+		{"смена пароля", func(um *UserManager) error {
+			return um.ChangePassword("bob", "Correct-Horse-23")
+		}},
+		{"переименование туда и обратно", func(um *UserManager) error {
+			if err := um.RenameUser("bob", "robert", "проверка"); err != nil {
+				return err
+			}
+			return um.RenameUser("robert", "bob", "проверка")
+		}},
+		{"восстановление удаленной", func(um *UserManager) error {
+			um.SetDeletionRetention(time.Hour)
+			if _, err := um.RemoveUser("bob", "проверка"); err != nil {
+				return err
+			}
+			return um.RestoreUser("bob", "проверка")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestAPIServer(t)
+			// iat хранится в секундах: токен выдан в одну из предыдущих секунд
+			bob, _ := s.um.store.GetUser("bob")
+			token, _, err := s.tokens.Issue(bob, time.Now().Add(-2*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !introspect(t, s, token).Active {
+				t.Fatal("токен не действует до изменения")
+			}
+			if err := tt.change(s.um); err != nil {
+				t.Fatal(err)
+			}
+			if introspect(t, s, token).Active {
+				t.Errorf("токен действует после изменения")
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	s := newTestAPIServer(t)
+	ready := func() (int, APIReadiness) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, readyPath, nil))
+		var result APIReadiness
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	if code, result := ready(); code != http.StatusOK || result.Status != "ready" {
+		t.Fatalf("GET /readyz без токена: %d %+v", code, result)
+	}
+	if result := s.readiness(time.Date(1970, 1, 1, 0, 0, 10, 0, time.UTC)); result.Checks["clock"] == "ok" {
+		t.Errorf("часы на начале эпохи прошли проверку: %+v", result)
+	}
+
+	// Очередь входа из одного места заполнена: второй запрос ждет, пока занято место проверки
+	s.admission = NewAdmission(1, 1)
+	release, _, _ := s.admission.Acquire(context.Background(), LaneAuth)
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		if release, _, ok := s.admission.Acquire(ctx, LaneAuth); ok {
+			release()
+		}
+	}()
+	for s.admission.Status()[LaneAuth].Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	code, result := ready()
+	if code != http.StatusServiceUnavailable || result.Checks["admission"] == "ok" || result.Checks["storage"] != "ok" {
+		t.Errorf("очередь заполнена: %d %+v", code, result)
+	}
+	cancel()
+	<-waiting
+	release()
+	if code, _ := ready(); code != http.StatusOK {
+		t.Errorf("очередь освобождена, а экземпляр не готов: %d", code)
+	}
+}
+
+func TestAPIReasonAndDualControl(t *testing.T) {
+	s := newTestAPIServer(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(AuditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	s.um.SetAuditLog(log)
+	const secondKey = "second-api-key-0123456789abcdef0123"
+	sum := sha256.Sum256([]byte(secondKey))
+	s.apiKeys = []APIKey{{Name: "second", TokenSHA256: hex.EncodeToString(sum[:]), Role: RoleAdmin}}
+	lastDetails := func() string {
+		last, _, err := lastAuditRecord(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return last.Details
+	}
+
+	// Причина из тела запроса записывается вместе с подписавшим запрос
+	s.um.store.Update("bob", func(user *User) error {
+		user.IsBlocked = true
+		return nil
+	})
+	if w := serveTestAPI(s, http.MethodPost, apiPrefix+"/users/bob/unlock", "application/json", `{"reason": "обращение 4521"}`); w.Code != http.StatusOK {
+		t.Fatalf("разблокировка: %d %s", w.Code, w.Body.String())
+	}
+	if details := lastDetails(); details != "запрос API (токен API): обращение 4521" {
+		t.Errorf("в журнале %q", details)
+	}
+
+	// Удаление по правилу двух лиц: повтор тем же участником не выполняет его
+	serveTestAPI(s, http.MethodPut, apiPrefix+"/policy", "application/json", `{"dual_control": ["delete"]}`)
+	deleteBob := func(token string) int {
+		r := httptest.NewRequest(http.MethodDelete, apiPrefix+"/users/bob", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set(apiReasonHeader, "увольнение")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := deleteBob(testAPIToken); code != http.StatusAccepted || !s.um.store.UserExists("bob") {
+			t.Fatalf("первый участник: %d", code)
+		}
+	}
+	if code := deleteBob(secondKey); code != http.StatusNoContent || s.um.store.UserExists("bob") {
+		t.Fatalf("второй участник: %d", code)
+	}
+	if details := lastDetails(); !strings.Contains(details, "запрос API (токен API): увольнение; подтвердил запрос API (ключ API second): увольнение") {
+		t.Errorf("в журнале %q", details)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	s := newTestAPIServer(t)
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.trustedProxies = proxies
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"без прокси", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"заголовок не от прокси", "203.0.113.5:4000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"через прокси", "192.0.2.1:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"цепочка прокси", "10.1.1.1:4000", []string{"198.51.100.7, 10.2.2.2"}, "198.51.100.7"},
+		{"подставленный клиентом адрес", "10.1.1.1:4000", []string{"1.2.3.4, 198.51.100.7"}, "198.51.100.7"},
+		{"несколько заголовков", "10.1.1.1:4000", []string{"1.2.3.4", "198.51.100.7, 10.2.2.2"}, "198.51.100.7"},
+		{"испорченный заголовок", "10.1.1.1:4000", []string{"198.51.100.7, unknown"}, "10.1.1.1"},
+		{"прокси без заголовка", "10.1.1.1:4000", nil, "10.1.1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, healthPath, nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("адрес клиента %s, ожидается %s", got, tt.want)
+			}
+		})
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("некорректная подсеть принята")
+	}
+
+	// Адрес клиента записывается в журнал вместе с отказом во входе
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(AuditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	s.um.SetAuditLog(log)
+	r := httptest.NewRequest(http.MethodPost, apiPrefix+"/auth", strings.NewReader(`{"username": "bob", "password": "wrong"}`))
+	r.Header.Set("Authorization", "Bearer "+testAPIToken)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	r.RemoteAddr = "10.1.1.1:4000"
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	last, _, err := lastAuditRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Username != "bob" || last.ClientIP != "198.51.100.7" {
+		t.Errorf("запись %+v", last)
+	}
+	if s.um.request != (AuditSource{}) {
+		t.Errorf("источник запроса остался после запроса: %+v", s.um.request)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		generate bool // Ожидается идентификатор сервера
+	}{
+		{"от клиента", "trace-4bf92f35:00f067aa", false},
+		{"без заголовка", "", true},
+		{"недопустимые символы", "id\nfake", true},
+		{"слишком длинный", strings.Repeat("a", maxRequestIDLength+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestAPIServer(t)
+			path := filepath.Join(t.TempDir(), "audit.log")
+			log, err := OpenAuditLog(AuditConfig{Path: path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer log.Close()
+			s.um.SetAuditLog(log)
+			r := httptest.NewRequest(http.MethodPost, apiPrefix+"/auth", strings.NewReader(`{"username": "bob", "password": "wrong"}`))
+			r.Header.Set("Authorization", "Bearer "+testAPIToken)
+			r.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				r.Header.Set(requestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			id := w.Header().Get(requestIDHeader)
+			if tt.generate && (id == tt.header || !validRequestID(id)) || !tt.generate && id != tt.header {
+				t.Fatalf("%s: %q", requestIDHeader, id)
+			}
+			last, _, err := lastAuditRecord(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last.RequestID != id {
+				t.Errorf("в журнале %q, в ответе %q", last.RequestID, id)
+			}
+		})
+	}
+}
+
+func TestNewDevice(t *testing.T) {
+	s := newTestAPIServer(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(AuditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	s.um.SetAuditLog(log)
+	if err := s.um.RegisterUser("carol", "Correct-Horse-12"); err != nil {
+		t.Fatal(err)
+	}
+	login := func(agent, name string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, apiPrefix+"/auth", strings.NewReader(`{"username": "carol", "password": "Correct-Horse-12"}`))
+		r.Header.Set("Authorization", "Bearer "+testAPIToken)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("User-Agent", agent)
+		r.Header.Set(deviceNameHeader, name)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("вход: %d %s", w.Code, w.Body.String())
+		}
+	}
+	newDevices := func() []AuditRecord {
+		records, err := log.RecordsFor("carol")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found []AuditRecord
+		for _, record := range records {
+			if record.Event == AuditNewDevice {
+				found = append(found, record)
+			}
+		}
+		return found
+	}
+
+	// Первый вход и повторный вход с того же устройства о новом устройстве не сообщают
+	login("Firefox/131.0", "ноутбук")
+	login("Firefox/131.0", "ноутбук")
+	if found := newDevices(); len(found) != 0 {
+		t.Fatalf("новое устройство при первом входе: %+v", found)
+	}
+	login("Firefox/131.0", "телефон\x07")
+	found := newDevices()
+	if len(found) != 1 || found[0].DeviceName != "телефон" || found[0].Device == "" ||
+		!strings.Contains(found[0].Details, "телефон") {
+		t.Fatalf("вход с нового устройства: %+v", found)
+	}
+
+	// Сверх maxKnownDevices забываются устройства, с которых давно не входили
+	carol := mustGetUser(t, s.um, "carol")
+	now := time.Now()
+	for i := 0; i < maxKnownDevices; i++ {
+		rememberDevice(carol, AuditSource{Device: strings.Repeat("0", i+1)}, now.Add(time.Duration(i+1)*time.Minute))
+	}
+	if len(carol.KnownDevices) != maxKnownDevices {
+		t.Fatalf("устройств %d", len(carol.KnownDevices))
+	}
+	for _, device := range carol.KnownDevices {
+		if device.Name == "ноутбук" {
+			t.Errorf("давнее устройство не забыто")
+		}
+	}
+}
+
+func TestFetchActivityReport(t *testing.T) {
+	s := newTestAPIServer(t)
+	s.um.store.Update("bob", func(user *User) error {
+		user.IsBlocked = true
+		return nil
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "Заблокированных учетных записей:"},
+		{"csv", "locked,bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out strings.Builder
+			if err := FetchActivityReport(addr, "", testAPIToken, "30d", "90d", tt.format, &out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("в отчете нет %q:\n%s", tt.want, out.String())
+			}
+		})
+	}
+	var out strings.Builder
+	if err := FetchActivityReport(addr, "", testAPIToken, "30d", "90d", "json", &out); err != nil {
+		t.Fatal(err)
+	}
+	var report ActivityReport
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil || len(report.LockedAccounts) != 1 {
+		t.Errorf("отчет JSON: %v\n%s", err, out.String())
+	}
+	if err := FetchActivityReport(addr, "", "wrong-token", "30d", "90d", "json", &strings.Builder{}); err == nil {
+		t.Errorf("отчет выдан без токена API")
+	}
+}
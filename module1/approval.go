@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SetRegistrationApproval включает режим, в котором новые учетные записи
+// ожидают одобрения администратором до первого входа
+func (um *UserManager) SetRegistrationApproval(required bool) {
+	um.requireApproval = required
+}
+
+// RegistrationApprovalRequired сообщает, требуется ли одобрение новых регистраций
+func (um *UserManager) RegistrationApprovalRequired() bool {
+	return um.requireApproval
+}
+
+// PendingRegistrations возвращает учетные записи, ожидающие одобрения, в порядке подачи заявок
+func (um *UserManager) PendingRegistrations() []*User {
+	var pending []*User
+	for _, user := range um.store.GetAllUsers() {
+		if user.PendingApproval {
+			pending = append(pending, user)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	return pending
+}
+
+// ApproveRegistration одобряет заявку: пользователь сможет войти в систему
+func (um *UserManager) ApproveRegistration(username, reason string) error {
+	username = strings.TrimSpace(username)
+
+	err := um.store.Update(username, func(user *User) error {
+		if !user.PendingApproval {
+			return fmt.Errorf("заявка на регистрацию '%s' не найдена", username)
+		}
+		user.PendingApproval = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	um.recordAudit(AuditRegistrationApproved, username, reason)
+	um.usersChanged()
+	return nil
+}
+
+// RejectRegistration отклоняет заявку и удаляет созданную учетную запись
+func (um *UserManager) RejectRegistration(username, reason string) error {
+	username = strings.TrimSpace(username)
+
+	user, exists := um.store.GetUser(username)
+	if !exists || !user.PendingApproval {
+		return fmt.Errorf("заявка на регистрацию '%s' не найдена", username)
+	}
+
+	if um.dryRun {
+		um.planChange(AuditRegistrationRejected, username, reason)
+		return nil
+	}
+
+	um.store.DeleteUser(username)
+	um.recordAudit(AuditRegistrationRejected, username, reason)
+	return nil
+}
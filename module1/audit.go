@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// События журнала аудита
+const (
+	AuditRegister             = "register"
+	AuditLoginSuccess         = "login_success"
+	AuditLoginFailed          = "login_failed"
+	AuditAccountBlocked       = "account_blocked"
+	AuditAccountUnlocked      = "account_unlocked"
+	AuditAccountDisabled      = "account_disabled"
+	AuditPasswordChanged      = "password_changed"
+	AuditPasswordRehash       = "password_rehashed"
+	AuditLegacyImport         = "legacy_import"
+	AuditScheduleChanged      = "schedule_changed"
+	AuditOutsideSchedule      = "login_outside_schedule"
+	AuditAccountErased        = "account_erased"
+	AuditPolicyChanged        = "policy_changed"
+	AuditRotationScheduled    = "password_rotation_scheduled"
+	AuditRotationOverdue      = "password_rotation_overdue"
+	AuditHoneypotCreated      = "honeypot_created"
+	AuditHoneypotTriggered    = "honeypot_triggered"
+	AuditDuressConfigured     = "duress_configured"
+	AuditDuressLogin          = "duress_login"
+	AuditDormancyWarned       = "dormancy_warned"
+	AuditDormancyFlagged      = "dormancy_flagged"
+	AuditDormancyBlocked      = "dormancy_disabled"
+	AuditHookRejected         = "hook_rejected"
+	AuditRegistrationApproved = "registration_approved"
+	AuditRegistrationRejected = "registration_rejected"
+	AuditInviteRedeemed       = "invite_redeemed"
+	AuditTermsAccepted        = "terms_accepted"
+	AuditUserRenamed          = "user_renamed"
+	AuditUsersMerged          = "users_merged"
+	AuditAccountRestored      = "account_restored"
+	AuditAccountPurged        = "account_purged"
+	AuditAdminGranted         = "admin_granted"
+	AuditAdminRevoked         = "admin_revoked"
+	AuditEmailChanged         = "email_changed"
+	AuditBatchVerified        = "batch_verified"
+	AuditSAMLRejected         = "saml_rejected"
+	AuditSAMLProvisioned      = "saml_provisioned"
+	AuditSAMLLinked           = "saml_linked"
+	AuditSAMLUnlinked         = "saml_unlinked"
+	AuditKerberosRejected     = "kerberos_rejected"
+	AuditSSHKeyAdded          = "ssh_key_added"
+	AuditSSHKeyRemoved        = "ssh_key_removed"
+	AuditCertificateBound     = "certificate_bound"
+	AuditCertificateUnbound   = "certificate_unbound"
+	AuditCertificateRejected  = "certificate_rejected"
+	AuditImpersonationStarted = "impersonation_started"
+	AuditImpersonationDenied  = "impersonation_denied"
+	AuditRoleChanged          = "role_changed"
+	AuditPermissionDenied     = "permission_denied"
+	AuditGroupCreated         = "group_created"
+	AuditGroupDeleted         = "group_deleted"
+	AuditGroupChanged         = "group_changed"
+	AuditGroupMemberAdded     = "group_member_added"
+	AuditGroupMemberRemoved   = "group_member_removed"
+	AuditSecondFactorRequired = "second_factor_required"
+	AuditPolicyRuleDenied     = "policy_rule_denied"
+	AuditAttributesChanged    = "attributes_changed"
+	AuditProfileStepSnoozed   = "profile_step_snoozed"
+	AuditProfileStepRequired  = "profile_step_required"
+	AuditProfileStepCompleted = "profile_step_completed"
+	AuditLogRotated           = "audit_log_rotated"
+	AuditApprovalRequested    = "approval_requested"
+	AuditNewDevice            = "new_device"
+)
+
+// auditAnchorFormat - описание начала цепочки в записи audit_log_rotated
+const auditAnchorFormat = "начало цепочки #%d %s"
+
+// AuditRecord - запись журнала аудита. Каждая запись содержит хеш предыдущей,
+// поэтому изменение или удаление любой записи разрывает цепочку.
+type AuditRecord struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Username   string    `json:"username,omitempty"`
+	Actor      string    `json:"actor,omitempty"` // Администратор, действующий от имени пользователя
+	Details    string    `json:"details,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`   // Адрес клиента запроса API
+	RequestID  string    `json:"request_id,omitempty"`  // Идентификатор запроса API (X-Request-ID)
+	Device     string    `json:"device,omitempty"`      // Хеш устройства клиента (User-Agent и X-Device-Name)
+	DeviceName string    `json:"device_name,omitempty"` // Имя устройства из X-Device-Name
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// computeHash вычисляет хеш записи (без поля Hash)
+func (r AuditRecord) computeHash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditConfig задает расположение, ротацию и хранение журнала аудита
+type AuditConfig struct {
+	Path      string        // Путь к текущему файлу журнала
+	MaxSize   int64         // Ротация при превышении размера в байтах (0 - не ограничено)
+	MaxAge    time.Duration // Ротация по времени (0 - не ограничено)
+	Retention time.Duration // Сколько хранить ротированные файлы (0 - бессрочно)
+}
+
+// DefaultAuditConfig возвращает настройки журнала по умолчанию
+func DefaultAuditConfig(path string) AuditConfig {
+	return AuditConfig{
+		Path:      path,
+		MaxSize:   10 * 1024 * 1024, // 10 МБ
+		MaxAge:    24 * time.Hour,
+		Retention: 90 * 24 * time.Hour,
+	}
+}
+
+// auditRotationLayout - формат времени в имени ротированного файла (Path + "." + время)
+const auditRotationLayout = "20060102T150405.000000000"
+
+// AuditLog - журнал аудита с ротацией и цепочкой хешей. В один журнал могут писать
+// несколько процессов (меню, serve, разовые команды): запись выполняется под блокировкой
+// файла Path + ".lock", и перед ней цепочка продолжается с последней записи на диске.
+type AuditLog struct {
+	mu       sync.Mutex
+	config   AuditConfig
+	lock     *os.File // Файл межпроцессной блокировки
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	seq      uint64
+	lastHash string
+}
+
+// OpenAuditLog открывает журнал аудита и продолжает существующую цепочку
+func OpenAuditLog(config AuditConfig) (*AuditLog, error) {
+	if strings.TrimSpace(config.Path) == "" {
+		return nil, fmt.Errorf("не указан путь к журналу аудита")
+	}
+
+	lock, err := os.OpenFile(config.Path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия журнала аудита: %v", err)
+	}
+	log := &AuditLog{config: config, lock: lock}
+
+	unlock, err := log.lockFile()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	defer unlock()
+
+	if err := log.openFile(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := log.loadTail(); err != nil {
+		log.file.Close()
+		lock.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// lockFile захватывает межпроцессную блокировку журнала и возвращает функцию ее снятия
+func (l *AuditLog) lockFile() (func(), error) {
+	if err := lockFile(l.lock); err != nil {
+		return nil, fmt.Errorf("ошибка блокировки журнала аудита: %v", err)
+	}
+	return func() { unlockFile(l.lock) }, nil
+}
+
+// loadTail продолжает цепочку с последней записи (текущий файл или последний ротированный)
+func (l *AuditLog) loadTail() error {
+	files, err := auditLogFiles(l.config.Path)
+	if err != nil {
+		return err
+	}
+	l.seq, l.lastHash = 0, ""
+	for i := len(files) - 1; i >= 0; i-- {
+		last, found, err := lastAuditRecord(files[i])
+		if err != nil {
+			return err
+		}
+		if found {
+			l.seq = last.Seq
+			l.lastHash = last.Hash
+			break
+		}
+	}
+	return nil
+}
+
+// syncWithDisk учитывает записи других процессов: если с предыдущей записи текущий файл
+// был ротирован или дописан, файл открывается заново и цепочка продолжается с его хвоста.
+// Вызывается под межпроцессной блокировкой.
+func (l *AuditLog) syncWithDisk() error {
+	opened, err := l.file.Stat()
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+	current, err := os.Stat(l.config.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+
+	rotated := err != nil || !os.SameFile(opened, current)
+	if !rotated && opened.Size() == l.size {
+		return nil // Журнал дописывается только этим процессом
+	}
+	if rotated {
+		l.file.Close()
+		if err := l.openFile(); err != nil {
+			return err
+		}
+	} else {
+		l.size = opened.Size()
+	}
+	return l.loadTail()
+}
+
+// openFile открывает текущий файл журнала на дозапись
+func (l *AuditLog) openFile() error {
+	file, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия журнала аудита: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("ошибка открытия журнала аудита: %v", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.openedAt = info.ModTime()
+	if l.size == 0 {
+		l.openedAt = time.Now()
+	}
+	return nil
+}
+
+// Record добавляет событие в журнал
+func (l *AuditLog) Record(event, username, details string) error {
+	return l.RecordActor(event, username, "", details)
+}
+
+// RecordActor добавляет в журнал событие, выполненное администратором actor от имени
+// пользователя username
+func (l *AuditLog) RecordActor(event, username, actor, details string) error {
+	return l.RecordFrom(AuditSource{}, event, username, actor, details)
+}
+
+// RecordFrom добавляет в журнал событие, вызванное запросом API из источника source
+func (l *AuditLog) RecordFrom(source AuditSource, event, username, actor, details string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	unlock, err := l.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := l.syncWithDisk(); err != nil {
+		return err
+	}
+	rotated, err := l.rotateIfNeeded()
+	if err != nil {
+		return err
+	}
+	// Новый файл начинается с записи о том, какая запись после удаления старых файлов
+	// начинает цепочку: удаление начальных записей без ротации обнаруживает проверка
+	if rotated {
+		first, err := firstAuditRecord(l.config.Path)
+		if err != nil {
+			return err
+		}
+		if err := l.append(AuditSource{}, AuditLogRotated, "", "", fmt.Sprintf(auditAnchorFormat, first.Seq, first.Hash)); err != nil {
+			return err
+		}
+	}
+	return l.append(source, event, username, actor, details)
+}
+
+// append дописывает запись в текущий файл. Вызывается под межпроцессной блокировкой.
+func (l *AuditLog) append(source AuditSource, event, username, actor, details string) error {
+	record := AuditRecord{
+		Seq:        l.seq + 1,
+		Time:       time.Now().UTC(),
+		Event:      event,
+		Username:   username,
+		Actor:      actor,
+		Details:    details,
+		ClientIP:   source.ClientIP,
+		RequestID:  source.RequestID,
+		Device:     source.Device,
+		DeviceName: source.DeviceName,
+		PrevHash:   l.lastHash,
+	}
+	record.Hash = record.computeHash()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %v", err)
+	}
+	data = append(data, '\n')
+
+	n, err := l.file.Write(data)
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %v", err)
+	}
+
+	l.size += int64(n)
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	return nil
+}
+
+// Close закрывает файл журнала
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lock.Close()
+	return l.file.Close()
+}
+
+// RecordsFor возвращает все сохранившиеся записи журнала, относящиеся к пользователю
+func (l *AuditLog) RecordsFor(username string) ([]AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	files, err := auditLogFiles(l.config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []AuditRecord{}
+	for _, file := range files {
+		err := readAuditRecords(file, func(record AuditRecord, _ int) error {
+			if record.Username == username {
+				records = append(records, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// rotateIfNeeded переименовывает текущий файл при превышении размера или возраста
+// и удаляет ротированные файлы старше срока хранения. Возвращает true после ротации.
+func (l *AuditLog) rotateIfNeeded() (bool, error) {
+	bySize := l.config.MaxSize > 0 && l.size >= l.config.MaxSize
+	byAge := l.config.MaxAge > 0 && l.size > 0 && time.Since(l.openedAt) >= l.config.MaxAge
+	if !bySize && !byAge {
+		return false, nil
+	}
+
+	if err := l.file.Close(); err != nil {
+		return false, fmt.Errorf("ошибка ротации журнала аудита: %v", err)
+	}
+
+	rotated := l.config.Path + "." + time.Now().UTC().Format(auditRotationLayout)
+	if err := os.Rename(l.config.Path, rotated); err != nil {
+		return false, fmt.Errorf("ошибка ротации журнала аудита: %v", err)
+	}
+
+	if err := l.applyRetention(); err != nil {
+		return false, err
+	}
+
+	return true, l.openFile()
+}
+
+// applyRetention удаляет ротированные файлы старше срока хранения
+func (l *AuditLog) applyRetention() error {
+	if l.config.Retention <= 0 {
+		return nil
+	}
+
+	rotated, err := rotatedAuditLogs(l.config.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range rotated {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > l.config.Retention {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("ошибка удаления старого журнала %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// rotatedAuditLogs возвращает ротированные файлы журнала. Учитываются только имена вида
+// Path + "." + время ротации: файл блокировки и посторонние файлы с тем же префиксом
+// (резервные копии, Path + ".lock") не удаляются по сроку хранения и не читаются как журнал.
+func rotatedAuditLogs(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, match := range matches {
+		stamp := strings.TrimPrefix(match, path+".")
+		if len(stamp) != len(auditRotationLayout) {
+			continue
+		}
+		if _, err := time.Parse(auditRotationLayout, stamp); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	return rotated, nil
+}
+
+// auditLogFiles возвращает файлы журнала от старых к новым (текущий файл последним)
+func auditLogFiles(path string) ([]string, error) {
+	rotated, err := rotatedAuditLogs(path)
+	if err != nil {
+		return nil, err
+	}
+	// Имена ротированных файлов содержат время, поэтому сортируются хронологически
+	sort.Strings(rotated)
+
+	files := rotated
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// lastAuditRecord возвращает последнюю запись файла журнала. Файл читается с конца
+// блоками: хвост перечитывается перед каждой записью, и время не зависит от размера файла.
+func lastAuditRecord(path string) (AuditRecord, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return AuditRecord{}, false, fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return AuditRecord{}, false, fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		size := min(offset, 4096)
+		offset -= size
+		block := make([]byte, size)
+		if _, err := file.ReadAt(block, offset); err != nil {
+			return AuditRecord{}, false, fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+		}
+		tail = append(block, tail...)
+
+		// Последняя строка целиком в прочитанном хвосте, если перед ней есть перевод строки
+		trimmed := bytes.TrimRight(tail, " \t\r\n")
+		start := bytes.LastIndexByte(trimmed, '\n')
+		if start < 0 && offset > 0 {
+			continue
+		}
+		line := trimmed[start+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			return AuditRecord{}, false, nil
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return AuditRecord{}, false, fmt.Errorf("%s: поврежденная последняя запись: %v", path, err)
+		}
+		return record, true, nil
+	}
+	return AuditRecord{}, false, nil
+}
+
+// firstAuditRecord возвращает первую сохранившуюся запись журнала
+func firstAuditRecord(path string) (AuditRecord, error) {
+	files, err := auditLogFiles(path)
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	errFound := fmt.Errorf("запись найдена")
+	for _, file := range files {
+		var first AuditRecord
+		err := readAuditRecords(file, func(record AuditRecord, _ int) error {
+			first = record
+			return errFound
+		})
+		if err == errFound {
+			return first, nil
+		}
+		if err != nil {
+			return AuditRecord{}, err
+		}
+	}
+	return AuditRecord{}, fmt.Errorf("журнал аудита %s пуст", path)
+}
+
+// readAuditRecords последовательно читает записи файла журнала
+func readAuditRecords(path string, fn func(record AuditRecord, line int) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("%s:%d: поврежденная запись: %v", path, line, err)
+		}
+		if err := fn(record, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// AuditVerifyResult - результат проверки целостности журнала
+type AuditVerifyResult struct {
+	Files   int // Проверено файлов
+	Records int // Проверено записей
+}
+
+// VerifyAuditLog проверяет цепочку хешей во всех файлах журнала. Цепочка начинается с первой
+// записи журнала либо с записи, которую последняя ротация (audit_log_rotated) назвала началом
+// после удаления старых файлов: так обнаруживается и удаление начальных записей.
+// Удаление последних записей и пересчет всей цепочки от места изменения проверка
+// не обнаруживает - для этого нужен подписанный экспорт (audit export).
+func VerifyAuditLog(path string) (AuditVerifyResult, error) {
+	var result AuditVerifyResult
+
+	files, err := auditLogFiles(path)
+	if err != nil {
+		return result, err
+	}
+	if len(files) == 0 {
+		return result, fmt.Errorf("журнал аудита %s не найден", path)
+	}
+
+	var first, prev *AuditRecord
+	var anchorSeq uint64
+	var anchorHash string
+	for _, file := range files {
+		result.Files++
+		err := readAuditRecords(file, func(record AuditRecord, line int) error {
+			if first == nil {
+				r := record
+				first = &r
+			}
+			if record.Event == AuditLogRotated {
+				if _, err := fmt.Sscanf(record.Details, auditAnchorFormat, &anchorSeq, &anchorHash); err != nil {
+					return fmt.Errorf("%s:%d: запись #%d о ротации не называет начало цепочки", file, line, record.Seq)
+				}
+			}
+			if record.computeHash() != record.Hash {
+				return fmt.Errorf("%s:%d: запись #%d изменена (хеш не совпадает)", file, line, record.Seq)
+			}
+			if prev != nil {
+				if record.PrevHash != prev.Hash {
+					return fmt.Errorf("%s:%d: запись #%d не ссылается на предыдущую (цепочка разорвана)", file, line, record.Seq)
+				}
+				if record.Seq != prev.Seq+1 {
+					return fmt.Errorf("%s:%d: пропущены записи между #%d и #%d", file, line, prev.Seq, record.Seq)
+				}
+			}
+
+			r := record
+			prev = &r
+			result.Records++
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	switch {
+	case first == nil:
+		// Журнал создан, записей еще нет
+	case anchorHash == "" && (first.Seq != 1 || first.PrevHash != ""):
+		return result, fmt.Errorf("цепочка начинается с записи #%d без записи о ротации: удалены начальные записи", first.Seq)
+	case anchorHash != "" && (first.Seq != anchorSeq || first.Hash != anchorHash):
+		return result, fmt.Errorf("цепочка начинается с записи #%d, а после последней ротации - с #%d: удалены начальные записи", first.Seq, anchorSeq)
+	}
+	return result, nil
+}
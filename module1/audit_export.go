@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuditExport - подписанный пакет записей журнала аудита за период
+type AuditExport struct {
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Records    []AuditRecord `json:"records"`
+	PublicKey  string        `json:"public_key"` // Открытый ключ Ed25519 (hex)
+	Signature  string        `json:"signature"`  // Подпись пакета без этого поля (hex)
+}
+
+// signedPayload возвращает данные, которые покрывает подпись
+func (e AuditExport) signedPayload() ([]byte, error) {
+	e.Signature = ""
+	return json.Marshal(e)
+}
+
+// KeyFingerprint возвращает отпечаток SHA-256 открытого ключа для сверки с аудитором.
+// Отпечаток не сокращается: по короткому отпечатку можно подобрать другой ключ.
+func KeyFingerprint(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteAuditPublicKey сохраняет открытый ключ подписи (hex) для передачи аудитору
+func WriteAuditPublicKey(path string, publicKey ed25519.PublicKey) error {
+	return os.WriteFile(path, []byte(hex.EncodeToString(publicKey)+"\n"), 0644)
+}
+
+// TrustedAuditKey возвращает ключ, которым должен быть подписан пакет: открытый ключ из
+// файла keyPath или ключ пакета, если его отпечаток SHA-256 равен fingerprint. Ключу из
+// самого пакета без сверки доверять нельзя: подделавший пакет подпишет его своим ключом.
+func TrustedAuditKey(export AuditExport, keyPath, fingerprint string) (ed25519.PublicKey, error) {
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения открытого ключа: %v", err)
+		}
+		publicKey, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(publicKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("файл %s не содержит открытый ключ Ed25519", keyPath)
+		}
+		return publicKey, nil
+	}
+
+	fingerprint = strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(fingerprint)))
+	if fingerprint == "" {
+		return nil, fmt.Errorf("нужен доверенный открытый ключ или отпечаток SHA-256, полученный от экспортировавшего по независимому каналу")
+	}
+	if len(fingerprint) != 2*sha256.Size {
+		return nil, fmt.Errorf("нужен полный отпечаток SHA-256 (%d шестнадцатеричных символов)", 2*sha256.Size)
+	}
+	publicKey, err := hex.DecodeString(export.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("некорректный открытый ключ в пакете")
+	}
+	if KeyFingerprint(publicKey) != fingerprint {
+		return nil, fmt.Errorf("пакет подписан другим ключом (отпечаток %s)", KeyFingerprint(publicKey))
+	}
+	return publicKey, nil
+}
+
+// LoadOrCreateSigningKey читает закрытый ключ Ed25519 из файла или создает новый.
+// Второе значение сообщает, что ключ был создан.
+func LoadOrCreateSigningKey(path string) (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, false, fmt.Errorf("файл %s не содержит ключ Ed25519", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("ошибка чтения ключа: %v", err)
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("ошибка генерации ключа: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(privateKey.Seed())+"\n"), 0600); err != nil {
+		return nil, false, fmt.Errorf("ошибка сохранения ключа: %v", err)
+	}
+	return privateKey, true, nil
+}
+
+// ExportAuditRecords отбирает записи журнала за период [from, to] и подписывает пакет.
+// Перед экспортом проверяется целостность журнала.
+func ExportAuditRecords(path string, from, to time.Time, key ed25519.PrivateKey) (AuditExport, error) {
+	if _, err := VerifyAuditLog(path); err != nil {
+		return AuditExport{}, fmt.Errorf("журнал не прошел проверку: %v", err)
+	}
+
+	files, err := auditLogFiles(path)
+	if err != nil {
+		return AuditExport{}, err
+	}
+
+	export := AuditExport{
+		From:       from.UTC(),
+		To:         to.UTC(),
+		ExportedAt: time.Now().UTC(),
+		Records:    []AuditRecord{},
+		PublicKey:  hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	for _, file := range files {
+		err := readAuditRecords(file, func(record AuditRecord, _ int) error {
+			if !record.Time.Before(from) && !record.Time.After(to) {
+				export.Records = append(export.Records, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return AuditExport{}, err
+		}
+	}
+
+	payload, err := export.signedPayload()
+	if err != nil {
+		return AuditExport{}, fmt.Errorf("ошибка формирования пакета: %v", err)
+	}
+	export.Signature = hex.EncodeToString(ed25519.Sign(key, payload))
+
+	return export, nil
+}
+
+// WriteAuditExport сохраняет пакет в файл
+func WriteAuditExport(path string, export AuditExport) error {
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка формирования пакета: %v", err)
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadAuditExport читает пакет из файла
+func ReadAuditExport(path string) (AuditExport, error) {
+	var export AuditExport
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return export, fmt.Errorf("ошибка чтения пакета: %v", err)
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return export, fmt.Errorf("файл %s не является пакетом аудита: %v", path, err)
+	}
+	return export, nil
+}
+
+// VerifyAuditExport проверяет подпись пакета, хеши записей и непрерывность цепочки.
+// Пакет должен быть подписан ключом trusted (см. TrustedAuditKey).
+func VerifyAuditExport(export AuditExport, trusted ed25519.PublicKey) error {
+	if trusted == nil {
+		return fmt.Errorf("не задан доверенный ключ подписи")
+	}
+	publicKey, err := hex.DecodeString(export.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("некорректный открытый ключ в пакете")
+	}
+	if !trusted.Equal(ed25519.PublicKey(publicKey)) {
+		return fmt.Errorf("пакет подписан другим ключом (отпечаток %s)", KeyFingerprint(publicKey))
+	}
+
+	signature, err := hex.DecodeString(export.Signature)
+	if err != nil {
+		return fmt.Errorf("некорректная подпись в пакете")
+	}
+	payload, err := export.signedPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return fmt.Errorf("подпись пакета недействительна")
+	}
+
+	for i, record := range export.Records {
+		if record.computeHash() != record.Hash {
+			return fmt.Errorf("запись #%d изменена (хеш не совпадает)", record.Seq)
+		}
+		if i > 0 {
+			prev := export.Records[i-1]
+			if record.PrevHash != prev.Hash || record.Seq != prev.Seq+1 {
+				return fmt.Errorf("цепочка прервана между записями #%d и #%d", prev.Seq, record.Seq)
+			}
+		}
+	}
+
+	return nil
+}
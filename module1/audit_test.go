@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAuditLogSharedByProcesses проверяет, что два экземпляра журнала (как меню и serve
+// в разных процессах) продолжают общую цепочку, в том числе после ротации другим экземпляром
+func TestAuditLogSharedByProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := AuditConfig{Path: path, MaxSize: 2048}
+	first, err := OpenAuditLog(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := OpenAuditLog(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	const records = 60
+	for i := 0; i < records; i++ {
+		log := first
+		if i%3 == 0 {
+			log = second
+		}
+		if err := log.Record(AuditLoginSuccess, fmt.Sprintf("user%d", i), strings.Repeat("x", 40)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := VerifyAuditLog(path)
+	if err != nil {
+		t.Fatalf("цепочка разорвана: %v", err)
+	}
+	// Каждый новый файл начинается с записи о ротации
+	if result.Records != records+result.Files-1 || result.Files < 2 {
+		t.Errorf("проверено %d записей в %d файлах, ожидается %d записей и ротация", result.Records, result.Files, records)
+	}
+}
+
+func TestAuditRetentionKeepsForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	old := time.Now().Add(-48 * time.Hour)
+	stale := path + "." + old.UTC().Format(auditRotationLayout)
+	foreign := []string{path + ".bak", path + ".20240101", path + ".lock"}
+	if err := os.WriteFile(stale, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range foreign {
+		if err := os.WriteFile(name, []byte("не журнал\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range append(foreign, stale) {
+		os.Chtimes(name, old, old)
+	}
+
+	log, err := OpenAuditLog(AuditConfig{Path: path, MaxSize: 1, Retention: time.Hour})
+	if err != nil {
+		t.Fatalf("посторонние файлы прочитаны как журнал: %v", err)
+	}
+	defer log.Close()
+	for i := 0; i < 2; i++ {
+		if err := log.Record(AuditLoginSuccess, "alice", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("ротированный файл старше срока хранения не удален")
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("удален посторонний файл %s", filepath.Base(name))
+		}
+	}
+}
+
+func TestLastAuditRecordLongFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := OpenAuditLog(AuditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	// Записи длиннее блока чтения и пустые строки в конце файла
+	for i := 0; i < 5; i++ {
+		if err := log.Record(AuditLoginFailed, "alice", strings.Repeat("д", 3000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString("\n\n")
+	file.Close()
+
+	last, found, err := lastAuditRecord(path)
+	if err != nil || !found {
+		t.Fatalf("последняя запись не найдена: %v", err)
+	}
+	if last.Seq != 5 || last.Hash != log.lastHash {
+		t.Errorf("последняя запись #%d %s, ожидается #5 %s", last.Seq, last.Hash, log.lastHash)
+	}
+}
+
+// TestAuditExportTrustedKey проверяет, что пакет принимается только с ключом или полным
+// отпечатком, полученным не из самого пакета
+func TestAuditExportTrustedKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	log, err := OpenAuditLog(AuditConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	for i := 0; i < 3; i++ {
+		log.Record(AuditLoginSuccess, "alice", "")
+	}
+	key, _, err := LoadOrCreateSigningKey(filepath.Join(dir, "audit-signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := ExportAuditRecords(path, time.Now().Add(-time.Hour), time.Now(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+	publicKeyPath := filepath.Join(dir, "audit-signing.pub")
+	if err := WriteAuditPublicKey(publicKeyPath, publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Подделка: записи изменены и пакет подписан другим ключом
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, err := ExportAuditRecords(path, time.Now().Add(-time.Hour), time.Now(), otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Records = forged.Records[1:]
+	forged.Records[0].PrevHash = ""
+	payload, _ := forged.signedPayload()
+	forged.Signature = fmt.Sprintf("%x", ed25519.Sign(otherKey, payload))
+
+	fingerprint := KeyFingerprint(publicKey)
+	tests := []struct {
+		name        string
+		export      AuditExport
+		keyPath     string
+		fingerprint string
+		want        string // Фрагмент ошибки (пусто - пакет принят)
+	}{
+		{"открытый ключ", export, publicKeyPath, "", ""},
+		{"полный отпечаток", export, "", strings.ToUpper(fingerprint), ""},
+		{"без ключа и отпечатка", export, "", "", "нужен доверенный открытый ключ"},
+		{"короткий отпечаток", export, "", fingerprint[:16], "полный отпечаток"},
+		{"подделка с открытым ключом", forged, publicKeyPath, "", "другим ключом"},
+		{"подделка с отпечатком", forged, "", fingerprint, "другим ключом"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := TrustedAuditKey(tt.export, tt.keyPath, tt.fingerprint)
+			if err == nil {
+				err = VerifyAuditExport(tt.export, trusted)
+			}
+			if tt.want == "" && err != nil {
+				t.Fatalf("пакет отклонен: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Fatalf("ошибка %v, ожидается %q", err, tt.want)
+			}
+		})
+	}
+	if err := VerifyAuditExport(forged, nil); err == nil {
+		t.Errorf("пакет принят без доверенного ключа")
+	}
+}
+
+// TestAuditLogHeadTruncation проверяет, что удаление начальных записей обнаруживается,
+// а удаление ротированных файлов по сроку хранения - нет
+func TestAuditLogHeadTruncation(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate func(files []string)
+		want     string // Фрагмент ошибки (пусто - журнал цел)
+	}{
+		{"удаление по сроку хранения", func([]string) {}, ""},
+		{"удален самый старый файл", func(files []string) { os.Remove(files[0]) }, "удалены начальные записи"},
+		{"удалена первая запись", func(files []string) {
+			data, _ := os.ReadFile(files[0])
+			os.WriteFile(files[0], data[strings.IndexByte(string(data), '\n')+1:], 0600)
+		}, "удалены начальные записи"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			log, err := OpenAuditLog(AuditConfig{Path: path, MaxSize: 1, Retention: time.Hour})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer log.Close()
+			for i := 0; i < 3; i++ {
+				log.Record(AuditLoginSuccess, "alice", "")
+			}
+			// Самый старый файл устарел: следующая ротация удалит его
+			files, _ := auditLogFiles(path)
+			old := time.Now().Add(-2 * time.Hour)
+			os.Chtimes(files[0], old, old)
+			log.Record(AuditLoginSuccess, "alice", "")
+			if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+				t.Fatal("устаревший файл не удален")
+			}
+
+			files, _ = auditLogFiles(path)
+			tt.truncate(files)
+			_, err = VerifyAuditLog(path)
+			if tt.want == "" && err != nil {
+				t.Fatalf("журнал не прошел проверку: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Fatalf("ошибка %v, ожидается %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,31 +1,98 @@
 package main
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword создает безопасный хеш пароля с использованием bcrypt
+// HashPassword создает безопасный хеш пароля с использованием bcrypt.
+// Если задан перец, пароль перед bcrypt подписывается текущим ключом.
 func HashPassword(password string) (string, error) {
 	const cost = 12
 	
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	secret := []byte(password)
+	keyID := pepper.Current()
+	if keyID != "" {
+		secret = pepperPassword(pepper.key(keyID), password)
+	}
+	
+	hashedBytes, err := bcrypt.GenerateFromPassword(secret, cost)
 	if err != nil {
 		return "", fmt.Errorf("ошибка хеширования пароля: %v", err)
 	}
 	
+	if keyID != "" {
+		return pepperPrefix + keyID + ":" + string(hashedBytes), nil
+	}
 	return string(hashedBytes), nil
 }
 
-// VerifyPassword проверяет соответствие пароля его хешу
+// VerifyPassword проверяет соответствие пароля его хешу. Хеш с перцем
+// не проходит проверку, если его ключа нет в файле ключей.
 func VerifyPassword(password, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	secret := []byte(password)
+	if keyID, bcryptHash, peppered := splitPepperedHash(hashedPassword); peppered {
+		key := pepper.key(keyID)
+		if key == nil {
+			return false
+		}
+		secret = pepperPassword(key, password)
+		hashedPassword = bcryptHash
+	}
+	
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), secret)
 	return err == nil
 }
 
 // IsPasswordSecure проверяет, является ли пароль достаточно безопасным
-func IsPasswordSecure(password string) (bool, []string) {
+func IsPasswordSecure(password string) (bool, []PolicyViolation) {
 	rules := DefaultPasswordRules()
 	return ValidatePassword(password, rules)
-}
\ No newline at end of file
+}
+
+// LegacyVerifier проверяет пароль по хешу, перенесенному из унаследованной системы
+type LegacyVerifier func(password, digest string) bool
+
+// legacyVerifiers - поддерживаемые схемы унаследованных хешей (формат "схема:хеш")
+var legacyVerifiers = map[string]LegacyVerifier{
+	"md5":   hexDigestVerifier(md5.New),
+	"sha1":  hexDigestVerifier(sha1.New),
+	"crypt": cryptVerifier, // md5-crypt, apr1, SHA-crypt (crypt.go)
+}
+
+// RegisterLegacyVerifier добавляет схему унаследованных хешей для миграции
+func RegisterLegacyVerifier(scheme string, verifier LegacyVerifier) {
+	legacyVerifiers[strings.ToLower(scheme)] = verifier
+}
+
+// hexDigestVerifier сравнивает несоленый hex-дайджест пароля за постоянное время
+func hexDigestVerifier(newHash func() hash.Hash) LegacyVerifier {
+	return func(password, digest string) bool {
+		h := newHash()
+		h.Write([]byte(password))
+		computed := hex.EncodeToString(h.Sum(nil))
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(strings.ToLower(digest))) == 1
+	}
+}
+
+// VerifyLegacyPassword проверяет пароль по унаследованному хешу вида "md5:<hex>"
+func VerifyLegacyPassword(password, legacyHash string) (bool, error) {
+	scheme, digest, found := strings.Cut(legacyHash, ":")
+	if !found {
+		return false, fmt.Errorf("некорректный формат унаследованного хеша")
+	}
+
+	verifier, ok := legacyVerifiers[strings.ToLower(scheme)]
+	if !ok {
+		return false, fmt.Errorf("неподдерживаемая схема унаследованного хеша: %s", scheme)
+	}
+
+	return verifier(password, digest), nil
+}
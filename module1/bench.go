@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Параметры сравнения алгоритмов хеширования паролей
+const (
+	benchRounds        = 3                      // Замеров на набор параметров, берется медиана
+	benchDefaultTarget = 500 * time.Millisecond // Допустимое время хеширования при входе
+	benchCurrentBcrypt = 12                     // Стоимость bcrypt в HashPassword
+)
+
+// hashBenchSet - набор параметров алгоритма хеширования для замера
+type hashBenchSet struct {
+	Algorithm string
+	Params    string
+	Memory    uint64 // Память на одно хеширование, байт
+	Threads   int    // Потоков на одно хеширование
+	Current   bool   // Набор, которым система хеширует пароли
+	hash      func(password, salt []byte) error
+}
+
+// HashBenchResult - замер набора параметров на этом оборудовании
+type HashBenchResult struct {
+	Algorithm   string
+	Params      string
+	Time        time.Duration // Медиана времени одного хеширования (0 - не замерялся)
+	Memory      uint64
+	Threads     int
+	Current     bool
+	Recommended bool // Самый стойкий набор алгоритма, укладывающийся в целевое время
+}
+
+// hashBenchSets возвращает наборы параметров от слабых к стойким для каждого алгоритма:
+// bcrypt по стоимости, scrypt и Argon2id - по рекомендациям OWASP и RFC 9106
+func hashBenchSets() []hashBenchSet {
+	var sets []hashBenchSet
+	for _, cost := range []int{10, 11, 12, 13, 14} {
+		cost := cost
+		sets = append(sets, hashBenchSet{
+			Algorithm: "bcrypt",
+			Params:    fmt.Sprintf("cost=%d", cost),
+			Memory:    4 << 10,
+			Threads:   1,
+			Current:   cost == benchCurrentBcrypt,
+			hash: func(password, _ []byte) error {
+				_, err := bcrypt.GenerateFromPassword(password, cost)
+				return err
+			},
+		})
+	}
+	for _, logN := range []uint{14, 15, 16, 17} {
+		n := 1 << logN
+		sets = append(sets, hashBenchSet{
+			Algorithm: "scrypt",
+			Params:    fmt.Sprintf("N=2^%d r=8 p=1", logN),
+			Memory:    128 * uint64(n) * 8,
+			Threads:   1,
+			hash: func(password, salt []byte) error {
+				_, err := scrypt.Key(password, salt, n, 8, 1, 32)
+				return err
+			},
+		})
+	}
+	for _, params := range []struct {
+		time    uint32
+		memory  uint32 // КиБ
+		threads uint8
+	}{
+		{2, 19 << 10, 1},
+		{1, 46 << 10, 1},
+		{3, 64 << 10, 4},
+		{4, 256 << 10, 4},
+	} {
+		params := params
+		sets = append(sets, hashBenchSet{
+			Algorithm: "argon2id",
+			Params:    fmt.Sprintf("t=%d m=%dMiB p=%d", params.time, params.memory>>10, params.threads),
+			Memory:    uint64(params.memory) << 10,
+			Threads:   int(params.threads),
+			hash: func(password, salt []byte) error {
+				argon2.IDKey(password, salt, params.time, params.memory, params.threads, 32)
+				return nil
+			},
+		})
+	}
+	return sets
+}
+
+// BenchmarkHashers замеряет время хеширования каждым набором параметров. Если набор
+// занял больше четырех целевых времен, более стойкие наборы того же алгоритма пропускаются.
+func BenchmarkHashers(target time.Duration) ([]HashBenchResult, error) {
+	password := []byte("Kq7#vLm2!Wz9pRx")
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	var results []HashBenchResult
+	skip := make(map[string]bool)
+	for _, set := range hashBenchSets() {
+		result := HashBenchResult{Algorithm: set.Algorithm, Params: set.Params, Memory: set.Memory, Threads: set.Threads, Current: set.Current}
+		if !skip[set.Algorithm] {
+			var times []time.Duration
+			for i := 0; i < benchRounds; i++ {
+				start := time.Now()
+				if err := set.hash(password, salt); err != nil {
+					return nil, fmt.Errorf("%s %s: %v", set.Algorithm, set.Params, err)
+				}
+				times = append(times, time.Since(start))
+			}
+			sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+			result.Time = times[len(times)/2]
+			skip[set.Algorithm] = result.Time > 4*target
+		}
+		results = append(results, result)
+	}
+
+	// Рекомендуется самый стойкий набор каждого алгоритма в пределах целевого времени
+	best := make(map[string]int)
+	for i, result := range results {
+		if result.Time > 0 && result.Time <= target {
+			best[result.Algorithm] = i
+		}
+	}
+	for _, i := range best {
+		results[i].Recommended = true
+	}
+	return results, nil
+}
+
+// FormatHashBench формирует таблицу сравнения и итоговую рекомендацию
+func FormatHashBench(results []HashBenchResult, target time.Duration) string {
+	var out strings.Builder
+	cpus := runtime.NumCPU()
+	fmt.Fprintf(&out, "Целевое время хеширования: %v, процессоров: %d\n\n", target, cpus)
+	// tabwriter выравнивает столбцы по символам, а не байтам: заголовки на кириллице
+	table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Алгоритм\tПараметры\tВремя\tПамять\tХешей/с\t")
+
+	var recommended *HashBenchResult
+	for i, result := range results {
+		timeText, rateText := "пропущен", "-"
+		if result.Time > 0 {
+			timeText = result.Time.Round(time.Millisecond).String()
+			// Оценка при загрузке всех процессоров; память может ограничить раньше
+			rateText = fmt.Sprintf("%.1f", float64(cpus)/float64(result.Threads)/result.Time.Seconds())
+		}
+		var marks []string
+		if result.Current {
+			marks = append(marks, "используется")
+		}
+		if result.Recommended {
+			marks = append(marks, "рекомендуется")
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Algorithm, result.Params, timeText, formatBytes(result.Memory), rateText, strings.Join(marks, ", "))
+
+		// Из подходящих наборов предпочтение - Argon2id, затем scrypt, затем bcrypt
+		if result.Recommended && (recommended == nil || hashPreference(result.Algorithm) < hashPreference(recommended.Algorithm)) {
+			recommended = &results[i]
+		}
+	}
+	table.Flush()
+
+	out.WriteString("\n")
+	if recommended == nil {
+		out.WriteString("Ни один набор не укладывается в целевое время: увеличьте его или используйте более быстрое оборудование.\n")
+	} else {
+		fmt.Fprintf(&out, "Рекомендация: %s %s (%v, %s на хеш).\n", recommended.Algorithm, recommended.Params,
+			recommended.Time.Round(time.Millisecond), formatBytes(recommended.Memory))
+	}
+	fmt.Fprintf(&out, "Система хеширует пароли bcrypt cost=%d; другие алгоритмы приведены для сравнения.\n", benchCurrentBcrypt)
+	return out.String()
+}
+
+// hashPreference возвращает порядок предпочтения алгоритма (меньше - лучше)
+func hashPreference(algorithm string) int {
+	switch algorithm {
+	case "argon2id":
+		return 0
+	case "scrypt":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// formatBytes выводит объем памяти в КиБ или МиБ
+func formatBytes(size uint64) string {
+	if size < 1<<20 {
+		return fmt.Sprintf("%d KiB", size>>10)
+	}
+	return fmt.Sprintf("%d MiB", size>>20)
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Вход в API по сертификату клиента TLS для сервисов. Сертификат привязывается к учетной
+// записи по отпечатку SHA-256 или по имени из SAN (dns, email, uri). Сертификаты клиентов
+// запрашиваются, если задан -api-client-ca: привязка по имени действует только для
+// сертификатов, выданных этим УЦ, привязка по отпечатку - для любого сертификата в сроке
+// действия. Сертификат администратора заменяет токен API, остальные учетные записи входят
+// через POST /v1/auth/certificate и получают токен доступа, как при входе по паролю.
+const certificatePath = apiPrefix + "/auth/certificate"
+
+// Виды привязки сертификата
+const (
+	CertBindSHA256 = "sha256"
+	CertBindDNS    = "dns"
+	CertBindEmail  = "email"
+	CertBindURI    = "uri"
+)
+
+// CertBinding - привязка сертификата клиента к учетной записи
+type CertBinding struct {
+	Kind    string    // sha256, dns, email или uri
+	Value   string    // Отпечаток (hex без двоеточий) или имя из SAN
+	AddedAt time.Time // Когда привязка создана
+}
+
+// String возвращает привязку в виде вид:значение, как она задается
+func (b CertBinding) String() string {
+	return b.Kind + ":" + b.Value
+}
+
+// ParseCertBinding разбирает привязку: sha256:<отпечаток> (двоеточия между байтами
+// допускаются, как в выводе openssl x509 -fingerprint -sha256), dns:<имя>, email:<адрес>
+// или uri:<адрес>
+func ParseCertBinding(value string) (CertBinding, error) {
+	kind, name, _ := strings.Cut(strings.TrimSpace(value), ":")
+	kind = strings.ToLower(kind)
+	if name == "" {
+		return CertBinding{}, fmt.Errorf("некорректная привязка %q: нужно вид:значение", value)
+	}
+	switch kind {
+	case CertBindSHA256:
+		fingerprint := strings.ToLower(strings.ReplaceAll(name, ":", ""))
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return CertBinding{}, fmt.Errorf("некорректный отпечаток SHA-256 %q", name)
+		}
+		return CertBinding{Kind: kind, Value: fingerprint}, nil
+	case CertBindDNS, CertBindEmail:
+		return CertBinding{Kind: kind, Value: strings.ToLower(name)}, nil
+	case CertBindURI:
+		if parsed, err := url.Parse(name); err != nil || parsed.Scheme == "" {
+			return CertBinding{}, fmt.Errorf("некорректный адрес URI %q", name)
+		}
+		return CertBinding{Kind: kind, Value: name}, nil
+	}
+	return CertBinding{}, fmt.Errorf("неизвестный вид привязки %q (доступно: sha256, dns, email, uri)", kind)
+}
+
+// certBindingFromFile возвращает привязку по отпечатку сертификата из файла PEM
+func certBindingFromFile(path string) (CertBinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CertBinding{}, fmt.Errorf("ошибка чтения сертификата: %v", err)
+	}
+	cert, err := parseCertificatePEM(data)
+	if err != nil {
+		return CertBinding{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return CertBinding{Kind: CertBindSHA256, Value: certFingerprint(cert)}, nil
+}
+
+// parseCertificatePEM разбирает первый сертификат PEM
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("нет сертификата PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// certFingerprint - отпечаток SHA-256 сертификата в hex
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// certBindingsOf возвращает привязки, которым соответствует сертификат. Имена из SAN
+// учитываются, только если сертификат выдан доверенным УЦ.
+func certBindingsOf(cert *x509.Certificate, trusted bool) []CertBinding {
+	bindings := []CertBinding{{Kind: CertBindSHA256, Value: certFingerprint(cert)}}
+	if !trusted {
+		return bindings
+	}
+	for _, name := range cert.DNSNames {
+		bindings = append(bindings, CertBinding{Kind: CertBindDNS, Value: strings.ToLower(name)})
+	}
+	for _, address := range cert.EmailAddresses {
+		bindings = append(bindings, CertBinding{Kind: CertBindEmail, Value: strings.ToLower(address)})
+	}
+	for _, uri := range cert.URIs {
+		bindings = append(bindings, CertBinding{Kind: CertBindURI, Value: uri.String()})
+	}
+	return bindings
+}
+
+// LoadClientCA читает сертификаты УЦ, выдающих сертификаты клиентов API
+func LoadClientCA(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения сертификатов: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("в %s нет сертификатов PEM", path)
+	}
+	return roots, nil
+}
+
+// verifyClientCertificate проверяет срок действия сертификата клиента и сообщает, выдан ли
+// он для аутентификации клиента УЦ из roots. Владение закрытым ключом проверяет TLS.
+func verifyClientCertificate(chain []*x509.Certificate, roots *x509.CertPool, now time.Time) (bool, error) {
+	if len(chain) == 0 {
+		return false, fmt.Errorf("клиент не предъявил сертификат")
+	}
+	leaf := chain[0]
+	if now.Before(leaf.NotBefore) || now.After(leaf.NotAfter) {
+		return false, fmt.Errorf("сертификат %q вне срока действия (%s - %s)", leaf.Subject.CommonName,
+			leaf.NotBefore.Format("2006-01-02"), leaf.NotAfter.Format("2006-01-02"))
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err == nil, nil
+}
+
+// BindCertificate привязывает сертификат клиента к учетной записи. Привязка может
+// принадлежать только одной учетной записи.
+func (um *UserManager) BindCertificate(username, value, reason string) (CertBinding, error) {
+	username = strings.TrimSpace(username)
+	binding, err := ParseCertBinding(value)
+	if err != nil {
+		return CertBinding{}, err
+	}
+	for _, user := range um.store.GetAllUsers() {
+		for _, existing := range user.CertBindings {
+			if existing.String() != binding.String() {
+				continue
+			}
+			if user.Username == username {
+				return CertBinding{}, fmt.Errorf("привязка %s уже задана", binding)
+			}
+			return CertBinding{}, fmt.Errorf("привязка %s задана у другой учетной записи", binding)
+		}
+	}
+
+	binding.AddedAt = time.Now()
+	err = um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		user.CertBindings = append(user.CertBindings, binding)
+		return nil
+	})
+	if err != nil {
+		return CertBinding{}, err
+	}
+	um.recordAudit(AuditCertificateBound, username, strings.TrimSpace(binding.String()+" "+reason))
+	return binding, nil
+}
+
+// UnbindCertificate удаляет привязку сертификата клиента
+func (um *UserManager) UnbindCertificate(username, value, reason string) error {
+	username = strings.TrimSpace(username)
+	binding, err := ParseCertBinding(value)
+	if err != nil {
+		return err
+	}
+	err = um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		for i, existing := range user.CertBindings {
+			if existing.String() == binding.String() {
+				user.CertBindings = append(user.CertBindings[:i:i], user.CertBindings[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("у учетной записи нет привязки %s", binding)
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditCertificateUnbound, username, strings.TrimSpace(binding.String()+" "+reason))
+	return nil
+}
+
+// CertBindings возвращает привязки сертификатов учетной записи
+func (um *UserManager) CertBindings(username string) ([]CertBinding, error) {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists || user.IsHoneypot {
+		return nil, fmt.Errorf("пользователь не найден")
+	}
+	bindings := append([]CertBinding(nil), user.CertBindings...)
+	sort.SliceStable(bindings, func(i, j int) bool { return bindings[i].AddedAt.Before(bindings[j].AddedAt) })
+	return bindings, nil
+}
+
+// certificateUser находит учетную запись, к которой привязан сертификат. Привязка по
+// отпечатку важнее привязки по имени; если имена сертификата привязаны к разным учетным
+// записям, сертификат не сопоставляется ни с одной.
+func (um *UserManager) certificateUser(cert *x509.Certificate, trusted bool) (*User, CertBinding, error) {
+	candidates := make(map[string]bool)
+	for _, binding := range certBindingsOf(cert, trusted) {
+		candidates[binding.String()] = true
+	}
+	var matched []*User
+	var matchedBinding CertBinding
+	for _, user := range um.store.GetAllUsers() {
+		var byName *CertBinding
+		for _, binding := range user.CertBindings {
+			switch {
+			case !candidates[binding.String()]:
+				continue
+			case binding.Kind == CertBindSHA256:
+				return user, binding, nil
+			case byName == nil:
+				byName = &binding
+			}
+		}
+		if byName != nil {
+			matched, matchedBinding = append(matched, user), *byName
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return nil, CertBinding{}, fmt.Errorf("сертификат %q (sha256:%s) не привязан к учетной записи", cert.Subject.CommonName, certFingerprint(cert))
+	case 1:
+		return matched[0], matchedBinding, nil
+	}
+	return nil, CertBinding{}, fmt.Errorf("имена сертификата %q привязаны к разным учетным записям", cert.Subject.CommonName)
+}
+
+// AuthenticateCertificate выполняет вход по проверенному сертификату клиента и учитывает
+// попытку в статистике входа. Возвращает результат и логин учетной записи.
+func (um *UserManager) AuthenticateCertificate(cert *x509.Certificate, trusted bool) (AuthOutcome, string, error) {
+	started := time.Now()
+	outcome, username, err := um.checkCertificate(cert, trusted)
+	um.loginStats.record(loginResultLabel(outcome, err), time.Since(started), time.Now())
+	return outcome, username, err
+}
+
+// checkCertificate сопоставляет сертификат с учетной записью и проверяет, разрешен ли вход
+func (um *UserManager) checkCertificate(cert *x509.Certificate, trusted bool) (AuthOutcome, string, error) {
+	now := time.Now()
+	if outcome, blocked := um.sourceLockout(now); blocked {
+		return outcome, "", nil
+	}
+
+	user, binding, err := um.certificateUser(cert, trusted)
+	if err != nil {
+		um.recordAudit(AuditCertificateRejected, "", err.Error())
+		return AuthOutcome{Result: AuthCertificateNotMapped}, "", nil
+	}
+
+	if outcome, allowed := um.checkLoginAllowed(user, now); !allowed {
+		return outcome, user.Username, nil
+	}
+	outcome, _, err := um.admitLogin(user, "", AuditLoginSuccess, "сертификат "+binding.String(), now)
+	return outcome, user.Username, err
+}
+
+// APICertBinding - привязка сертификата в API
+type APICertBinding struct {
+	Binding string    `json:"binding"` // вид:значение, например sha256:... или uri:spiffe://...
+	AddedAt time.Time `json:"added_at"`
+}
+
+// APICertBindingRequest - тело POST /users/<логин>/certificates: привязка или сертификат
+// PEM, который привязывается по отпечатку
+type APICertBindingRequest struct {
+	Binding     string `json:"binding,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// clientCertificate возвращает предъявленный сертификат клиента и признак того, что его
+// выдал доверенный УЦ
+func (s *APIServer) clientCertificate(r *http.Request) (*x509.Certificate, bool, error) {
+	if r.TLS == nil {
+		return nil, false, fmt.Errorf("клиент не предъявил сертификат")
+	}
+	trusted, err := verifyClientCertificate(r.TLS.PeerCertificates, s.clientCAs, time.Now())
+	if err != nil {
+		return nil, false, err
+	}
+	return r.TLS.PeerCertificates[0], trusted, nil
+}
+
+// certificatePrincipal возвращает права, которые дает сертификат запроса: сертификат
+// учетной записи с административными правами ее роли заменяет токен API
+func (s *APIServer) certificatePrincipal(r *http.Request) (Principal, bool) {
+	if s.clientCAs == nil {
+		return Principal{}, false
+	}
+	cert, trusted, err := s.clientCertificate(r)
+	if err != nil {
+		return Principal{}, false
+	}
+	user, _, err := s.um.certificateUser(cert, trusted)
+	if err != nil {
+		return Principal{}, false
+	}
+	principal := s.um.UserPrincipal(user.Username)
+	return principal, principal.Any()
+}
+
+// handleCertificateAuth: POST /auth/certificate - вход по сертификату клиента TLS.
+// Результат - как у POST /auth.
+func (s *APIServer) handleCertificateAuth(w http.ResponseWriter, r *http.Request) {
+	if s.clientCAs == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "вход по сертификату клиента не настроен (-api-client-ca)")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	cert, trusted, err := s.clientCertificate(r)
+	if err != nil {
+		s.um.recordAuditFrom(auditSourceFrom(r.Context()), AuditCertificateRejected, "", "", err.Error())
+		writeAPIError(w, http.StatusUnauthorized, CodeCertificateRejected, err.Error())
+		return
+	}
+	defer s.lockRequest(r)()
+	s.writableLogin(w, func(w http.ResponseWriter) {
+		outcome, username, err := s.um.AuthenticateCertificate(cert, trusted)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		s.writeAuthOutcome(w, outcome, username)
+	})
+}
+
+// handleCertBindings: GET - привязки сертификатов учетной записи, POST - новая привязка,
+// DELETE ?binding=вид:значение - удаление привязки
+func (s *APIServer) handleCertBindings(w http.ResponseWriter, r *http.Request, username string) {
+	switch r.Method {
+	case http.MethodGet:
+		bindings, err := s.um.CertBindings(username)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, err.Error())
+			return
+		}
+		response := []APICertBinding{}
+		for _, binding := range bindings {
+			response = append(response, APICertBinding{Binding: binding.String(), AddedAt: binding.AddedAt})
+		}
+		writeAPIJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		var request APICertBindingRequest
+		if !readAPIJSON(w, r, &request) {
+			return
+		}
+		if (request.Binding == "") == (request.Certificate == "") {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "нужно одно из полей: binding или certificate")
+			return
+		}
+		if !s.um.store.UserExists(username) {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+			return
+		}
+		value := request.Binding
+		if request.Certificate != "" {
+			cert, err := parseCertificatePEM([]byte(request.Certificate))
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "certificate: "+err.Error())
+				return
+			}
+			value = CertBindSHA256 + ":" + certFingerprint(cert)
+		}
+		binding, err := s.um.BindCertificate(username, value, request.Reason)
+		if err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodeUserRejected, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, APICertBinding{Binding: binding.String(), AddedAt: binding.AddedAt})
+	case http.MethodDelete:
+		value := r.URL.Query().Get("binding")
+		if value == "" {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "не указана привязка (binding)")
+			return
+		}
+		if !s.um.store.UserExists(username) {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+			return
+		}
+		if err := s.um.UnbindCertificate(username, value, r.URL.Query().Get("reason")); err != nil {
+			writeAPIError(w, http.StatusNotFound, CodeUnknownResource, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, "GET, POST, DELETE")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Внутренний УЦ сертификатов клиентов API (команды ca init и ca issue). Сертификат УЦ
+// задается в -api-client-ca, поэтому выданные им сертификаты сервер считает доверенными, а
+// привязка по имени uri:urn:uas:user:<логин> действует и после перевыпуска сертификата.
+const (
+	defaultClientCAValidity   = 10 * 365 * 24 * time.Hour
+	defaultClientCertValidity = 365 * 24 * time.Hour
+	clientCertURIPrefix       = "urn:uas:user:"
+)
+
+// ClientCertURI - имя из SAN, которое внутренний УЦ записывает в сертификат учетной записи
+func ClientCertURI(username string) string {
+	return clientCertURIPrefix + url.PathEscape(username)
+}
+
+// InitClientCA создает ключ и самоподписанный сертификат УЦ. Существующие файлы не
+// перезаписываются.
+func InitClientCA(certPath, keyPath string, validity time.Duration) (*x509.Certificate, error) {
+	for _, path := range []string{certPath, keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s уже существует", path)
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации ключа УЦ: %v", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "uas client CA"},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	cert, err := signCertificate(template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPEM(keyPath, key); err != nil {
+		return nil, err
+	}
+	return cert, writeCertPEM(certPath, cert)
+}
+
+// IssueClientCert выпускает сертификат клиента для учетной записи: ключ и сертификат
+// записываются в <логин>.key и <логин>.crt в каталоге dir
+func IssueClientCert(caCertPath, caKeyPath, username, dir string, validity time.Duration) (*x509.Certificate, string, error) {
+	ca, signer, err := loadClientCA(caCertPath, caKeyPath)
+	if err != nil {
+		return nil, "", err
+	}
+	base := filepath.Join(dir, username)
+	for _, path := range []string{base + ".crt", base + ".key"} {
+		if _, err := os.Stat(path); err == nil {
+			return nil, "", fmt.Errorf("%s уже существует", path)
+		}
+	}
+	uri, err := url.Parse(ClientCertURI(username))
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка генерации ключа: %v", err)
+	}
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: username},
+		URIs:        []*url.URL{uri},
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := signCertificate(template, ca, key.Public(), signer)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := writeKeyPEM(base+".key", key); err != nil {
+		return nil, "", err
+	}
+	return cert, base + ".crt", writeCertPEM(base+".crt", cert)
+}
+
+// loadClientCA читает сертификат и ключ внутреннего УЦ
+func loadClientCA(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения сертификата УЦ: %v", err)
+	}
+	cert, err := parseCertificatePEM(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", certPath, err)
+	}
+	data, err = os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка чтения ключа УЦ: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: нет ключа PEM", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", keyPath, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, nil, fmt.Errorf("%s и %s - не сертификат и ключ УЦ", certPath, keyPath)
+	}
+	return cert, signer, nil
+}
+
+// signCertificate подписывает сертификат со случайным серийным номером
+func signCertificate(template, parent *x509.Certificate, public crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации серийного номера: %v", err)
+	}
+	template.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, public, signer)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка выпуска сертификата: %v", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// writeKeyPEM записывает закрытый ключ PKCS #8, доступный только владельцу
+func writeKeyPEM(path string, key crypto.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// writeCertPEM записывает сертификат PEM
+func writeCertPEM(path string, cert *x509.Certificate) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+}
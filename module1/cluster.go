@@ -0,0 +1,653 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// Параметры кластера Raft
+const (
+	raftApplyTimeout  = 10 * time.Second // Ожидание фиксации изменения большинством узлов
+	raftDialTimeout   = 5 * time.Second  // Подключение и проверка токена между узлами
+	raftSnapshotsKept = 2                // Хранимых снимков хранилища
+	raftMaxToken      = 4096             // Наибольшая длина токена при подключении узла
+)
+
+// ClusterConfig - параметры режима высокой доступности (кластер Raft)
+type ClusterConfig struct {
+	ID     string            // Идентификатор узла
+	Peers  map[string]string // Все узлы кластера, включая этот: идентификатор -> host:port
+	Listen string            // Адрес приема подключений узлов (пусто - адрес узла из Peers)
+	Dir    string            // Каталог журнала и снимков Raft
+	CAPath string            // Сертификаты для проверки других узлов (пусто - системные)
+	Keys   *KeySet           // Ключи шифрования журнала и снимков (nil - без шифрования)
+}
+
+// ParseRaftPeers разбирает список узлов вида n1=host1:7000,n2=host2:7000
+func ParseRaftPeers(list string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, addr, ok := strings.Cut(item, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("узел %q: ожидается идентификатор=host:port", item)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("узел %s: некорректный адрес %q: %v", id, addr, err)
+		}
+		if _, exists := peers[id]; exists {
+			return nil, fmt.Errorf("узел %s указан повторно", id)
+		}
+		peers[id] = addr
+	}
+	return peers, nil
+}
+
+// clusterFormatVersion - версия формата записей журнала Raft и снимков. Увеличивается при
+// несовместимом изменении User или StoreChange; узел читает все версии не новее своей,
+// поэтому журнал и снимки, записанные до обновления, остаются читаемыми.
+//
+//	0 - записи без версии: журнал {"changes": [...]}, снимок - массив записей
+//	1 - журнал {"version": 1, "changes": [...]}, снимок {"version": 1, "users": [...]}
+const clusterFormatVersion = 1
+
+// raftCommand - запись журнала Raft: изменения хранилища, внесенные одним запросом.
+// Поле changes совпадает с форматом 0, поэтому узлы до введения версий читают новые записи.
+type raftCommand struct {
+	Version int             `json:"version"`
+	Changes json.RawMessage `json:"changes"`
+}
+
+// raftSnapshot - снимок хранилища
+type raftSnapshot struct {
+	Version int             `json:"version"`
+	Users   json.RawMessage `json:"users"`
+}
+
+// encodeRaftCommand кодирует изменения в запись журнала текущего формата
+func encodeRaftCommand(changes []StoreChange) ([]byte, error) {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raftCommand{Version: clusterFormatVersion, Changes: data})
+}
+
+// decodeRaftCommand читает изменения из записи журнала любой поддерживаемой версии
+func decodeRaftCommand(data []byte) ([]StoreChange, error) {
+	var command raftCommand
+	if err := json.Unmarshal(data, &command); err != nil {
+		return nil, err
+	}
+	if err := checkClusterFormat(command.Version); err != nil {
+		return nil, err
+	}
+	// Форматы 0 и 1 хранят StoreChange одинаково; при смене формата здесь переводятся прежние версии
+	var changes []StoreChange
+	if len(command.Changes) > 0 {
+		if err := json.Unmarshal(command.Changes, &changes); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+// encodeRaftSnapshot кодирует записи в снимок текущего формата
+func encodeRaftSnapshot(users []*User) ([]byte, error) {
+	data, err := json.Marshal(users)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raftSnapshot{Version: clusterFormatVersion, Users: data})
+}
+
+// decodeRaftSnapshot читает записи из снимка любой поддерживаемой версии
+func decodeRaftSnapshot(data []byte) ([]*User, error) {
+	snapshot := raftSnapshot{Users: data} // Формат 0: массив записей без конверта
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '[' {
+		snapshot = raftSnapshot{}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkClusterFormat(snapshot.Version); err != nil {
+		return nil, err
+	}
+	var users []*User
+	if err := json.Unmarshal(snapshot.Users, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// checkClusterFormat отклоняет данные формата новее поддерживаемого: их записал обновленный
+// узел, и прочитать их без потери полей нельзя
+func checkClusterFormat(version int) error {
+	if version < 0 || version > clusterFormatVersion {
+		return fmt.Errorf("формат версии %d не поддерживается (этот узел читает версии до %d) - обновите узел", version, clusterFormatVersion)
+	}
+	return nil
+}
+
+// clusterFSM - состояние хранилища, согласованное узлами кластера. Хранит собственную копию
+// записей: на лидере хранилище содержит и еще не зафиксированные изменения текущего запроса,
+// а снимок и откат должны строиться только из зафиксированных.
+type clusterFSM struct {
+	store   *UserStore
+	onApply func()  // Вызывается после применения изменений
+	keys    *KeySet // Ключи шифрования журнала и снимков
+
+	mu    sync.Mutex
+	users map[string]*User
+}
+
+// Apply применяет зафиксированную запись журнала к копии и к хранилищу. На лидере
+// хранилище уже содержит эти изменения, и повторная запись ничего не меняет.
+func (f *clusterFSM) Apply(entry *raft.Log) interface{} {
+	data, err := OpenStorage(f.keys, entry.Data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "кластер: запись журнала %d: %v\n", entry.Index, err)
+		return fmt.Errorf("запись журнала %d: %v", entry.Index, err)
+	}
+	changes, err := decodeRaftCommand(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "кластер: запись журнала %d: %v\n", entry.Index, err)
+		return fmt.Errorf("запись журнала %d: %v", entry.Index, err)
+	}
+
+	f.mu.Lock()
+	for _, change := range changes {
+		if change.User == nil {
+			delete(f.users, change.Username)
+		} else {
+			f.users[change.Username] = change.User.clone()
+		}
+	}
+	f.store.ApplyChanges(changes)
+	f.mu.Unlock()
+
+	if f.onApply != nil {
+		f.onApply()
+	}
+	return nil
+}
+
+// Snapshot возвращает снимок зафиксированного состояния
+func (f *clusterFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return clusterSnapshot{users: f.committed(), keys: f.keys}, nil
+}
+
+// Restore заменяет состояние снимком, полученным от лидера или прочитанным с диска
+func (f *clusterFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+	data, err := io.ReadAll(snapshot)
+	if err == nil {
+		data, err = OpenStorage(f.keys, data)
+	}
+	if err != nil {
+		return fmt.Errorf("снимок хранилища: %v", err)
+	}
+	users, err := decodeRaftSnapshot(data)
+	if err != nil {
+		return fmt.Errorf("некорректный снимок хранилища: %v", err)
+	}
+
+	f.mu.Lock()
+	f.users = make(map[string]*User, len(users))
+	for _, user := range users {
+		f.users[user.Username] = user
+	}
+	f.store.Replace(users)
+	f.mu.Unlock()
+
+	if f.onApply != nil {
+		f.onApply()
+	}
+	return nil
+}
+
+// committed возвращает копии зафиксированных записей
+func (f *clusterFSM) committed() []*User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*User, 0, len(f.users))
+	for _, user := range f.users {
+		users = append(users, user.clone())
+	}
+	return users
+}
+
+// reset возвращает хранилище к зафиксированному состоянию, отбрасывая изменения,
+// которые не удалось зафиксировать
+func (f *clusterFSM) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users := make([]*User, 0, len(f.users))
+	for _, user := range f.users {
+		users = append(users, user)
+	}
+	f.store.Replace(users)
+}
+
+// clusterSnapshot - снимок хранилища для Raft
+type clusterSnapshot struct {
+	users []*User
+	keys  *KeySet
+}
+
+// Persist сохраняет снимок в формате JSON с версией, зашифрованный при заданных ключах
+func (s clusterSnapshot) Persist(sink raft.SnapshotSink) error {
+	data, err := encodeRaftSnapshot(s.users)
+	if err == nil {
+		data, err = SealStorage(s.keys, data)
+	}
+	if err == nil {
+		_, err = sink.Write(data)
+	}
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release ничего не делает: снимок - независимая копия
+func (s clusterSnapshot) Release() {}
+
+// raftStreamLayer - соединения между узлами кластера. Подключающийся узел передает токен API
+// (длина и байты токена); соединение без верного токена закрывается. Без TLS узлы соединяются
+// только по loopback-адресам.
+type raftStreamLayer struct {
+	listener  net.Listener
+	advertise net.Addr
+	token     string
+	tlsConfig *tls.Config // Проверка других узлов при подключении (nil - без TLS)
+
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newRaftStreamLayer начинает принимать подключения узлов
+func newRaftStreamLayer(listener net.Listener, advertise net.Addr, token string, tlsConfig *tls.Config) *raftStreamLayer {
+	layer := &raftStreamLayer{
+		listener:  listener,
+		advertise: advertise,
+		token:     token,
+		tlsConfig: tlsConfig,
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+	go layer.acceptLoop()
+	return layer
+}
+
+// acceptLoop проверяет токен каждого подключения отдельно, чтобы медленный
+// или чужой клиент не задерживал подключения других узлов
+func (l *raftStreamLayer) acceptLoop() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			l.Close()
+			return
+		}
+		go func() {
+			if err := l.authenticate(conn); err != nil {
+				fmt.Fprintf(os.Stderr, "кластер: подключение %s отклонено: %v\n", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			select {
+			case l.conns <- conn:
+			case <-l.closed:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// authenticate читает и проверяет токен подключившегося узла
+func (l *raftStreamLayer) authenticate(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(raftDialTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return err
+	}
+	if length > raftMaxToken {
+		return fmt.Errorf("токен длиной %d", length)
+	}
+	token := make([]byte, length)
+	if _, err := io.ReadFull(conn, token); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(token, []byte(l.token)) != 1 {
+		return fmt.Errorf("неверный токен")
+	}
+	return nil
+}
+
+// Accept возвращает следующее подключение узла с верным токеном
+func (l *raftStreamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close прекращает прием подключений
+func (l *raftStreamLayer) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.listener.Close()
+	})
+	return nil
+}
+
+// Addr возвращает адрес узла, под которым его знают остальные
+func (l *raftStreamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// Dial подключается к узлу и передает токен
+func (l *raftStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if l.tlsConfig != nil {
+		config := l.tlsConfig.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(string(address))
+		conn, err = tls.DialWithDialer(dialer, "tcp", string(address), config)
+	} else {
+		conn, err = dialer.Dial("tcp", string(address))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(raftDialTimeout))
+	message := binary.BigEndian.AppendUint16(nil, uint16(len(l.token)))
+	if _, err := conn.Write(append(message, l.token...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+// Cluster - узел кластера Raft: хранилище пользователей согласовано всеми узлами, изменения
+// принимает только лидер. Изменения запроса API вносятся в хранилище лидера как обычно,
+// затем одной записью фиксируются большинством узлов; если фиксация не удалась,
+// хранилище возвращается к зафиксированному состоянию.
+type Cluster struct {
+	raft   *raft.Raft
+	fsm    *clusterFSM
+	logs   *raftboltdb.BoltStore
+	id     string
+	leader chan bool
+
+	mu      sync.Mutex
+	pending []StoreChange
+	ready   bool // Лидер применил все записи журнала и принимает изменения
+}
+
+// ClusterStatus - состояние узла кластера для API
+type ClusterStatus struct {
+	ID           string          `json:"id"`
+	State        string          `json:"state"` // Leader, Follower, Candidate
+	Writable     bool            `json:"writable"`
+	LeaderID     string          `json:"leader_id,omitempty"`
+	Leader       string          `json:"leader,omitempty"` // Адрес Raft лидера
+	LastIndex    uint64          `json:"last_index"`
+	AppliedIndex uint64          `json:"applied_index"`
+	Servers      []ClusterServer `json:"servers"`
+}
+
+// ClusterServer - узел в конфигурации кластера
+type ClusterServer struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// startCluster запускает узел кластера для API server. Журнал и снимки хранятся в cfg.Dir,
+// поэтому после перезапуска узел восстанавливает хранилище и догоняет остальных. При первом
+// запуске кластер создается из cfg.Peers; список на всех узлах должен совпадать.
+func startCluster(um *UserManager, server *APIServer, cfg ClusterConfig, certPath, keyPath string) (*Cluster, error) {
+	advertise, ok := cfg.Peers[cfg.ID]
+	if !ok {
+		return nil, fmt.Errorf("узла %q нет в списке узлов кластера", cfg.ID)
+	}
+	if len(cfg.Peers) < 3 {
+		fmt.Fprintf(os.Stderr, "ВНИМАНИЕ: узлов кластера %d - отказ одного узла остановит изменения\n", len(cfg.Peers))
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = advertise
+	}
+	advertiseAddr, err := net.ResolveTCPAddr("tcp", advertise)
+	if err != nil {
+		return nil, fmt.Errorf("адрес узла %s: %v", advertise, err)
+	}
+
+	listener, tlsConfig, err := raftListener(cfg, certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	layer := newRaftStreamLayer(listener, advertiseAddr, server.token, tlsConfig)
+	transport := raft.NewNetworkTransport(layer, 3, raftApplyTimeout, os.Stderr)
+
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("каталог Raft: %v", err)
+	}
+	logs, err := raftboltdb.NewBoltStore(filepath.Join(cfg.Dir, "raft.db"))
+	if err != nil {
+		transport.Close()
+		return nil, fmt.Errorf("журнал Raft: %v", err)
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.Dir, raftSnapshotsKept, os.Stderr)
+	if err != nil {
+		transport.Close()
+		logs.Close()
+		return nil, fmt.Errorf("снимки Raft: %v", err)
+	}
+
+	c := &Cluster{
+		fsm:    &clusterFSM{store: um.store, onApply: um.usersChanged, keys: cfg.Keys, users: make(map[string]*User)},
+		logs:   logs,
+		id:     cfg.ID,
+		leader: make(chan bool, 1),
+	}
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(cfg.ID)
+	config.LogOutput = os.Stderr
+	config.LogLevel = "WARN"
+	config.NotifyCh = c.leader
+
+	existing, err := raft.HasExistingState(logs, logs, snapshots)
+	if err == nil && !existing {
+		var configuration raft.Configuration
+		for id, addr := range cfg.Peers {
+			configuration.Servers = append(configuration.Servers, raft.Server{ID: raft.ServerID(id), Address: raft.ServerAddress(addr)})
+		}
+		err = raft.BootstrapCluster(config, logs, logs, snapshots, transport, configuration)
+	}
+	if err != nil {
+		transport.Close()
+		logs.Close()
+		return nil, fmt.Errorf("создание кластера: %v", err)
+	}
+
+	// Хранилище меняется только через журнал: на узле не должно остаться записей не из него
+	um.store.Replace(nil)
+	if c.raft, err = raft.NewRaft(config, c.fsm, logs, logs, snapshots, transport); err != nil {
+		transport.Close()
+		logs.Close()
+		return nil, fmt.Errorf("запуск Raft: %v", err)
+	}
+	um.store.SetChangeHandler(c.record)
+	go c.watchLeadership()
+
+	server.cluster = c
+	fmt.Printf("Кластер: узел %s, Raft %s, узлов: %d\n", cfg.ID, advertise, len(cfg.Peers))
+	if cfg.Keys != nil {
+		fmt.Printf("Кластер: журнал и снимки шифруются ключом %s\n", cfg.Keys.Current())
+	}
+	return c, nil
+}
+
+// raftListener открывает адрес приема подключений узлов. С сертификатом API соединения
+// защищены TLS, а другие узлы проверяются по caPath или системным сертификатам.
+func raftListener(cfg ClusterConfig, certPath, keyPath string) (net.Listener, *tls.Config, error) {
+	if certPath == "" {
+		addrs := []string{cfg.Listen}
+		for _, addr := range cfg.Peers {
+			addrs = append(addrs, addr)
+		}
+		for _, addr := range addrs {
+			if !isLoopbackAddr(addr) {
+				return nil, nil, fmt.Errorf("без TLS узлы кластера соединяются только по loopback-адресам, а не %s", addr)
+			}
+		}
+		listener, err := net.Listen("tcp", cfg.Listen)
+		return listener, nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка загрузки сертификата TLS: %v", err)
+	}
+	client := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAPath != "" {
+		pem, err := os.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ошибка чтения сертификатов: %v", err)
+		}
+		client.RootCAs = x509.NewCertPool()
+		if !client.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("в %s нет сертификатов PEM", cfg.CAPath)
+		}
+	}
+	listener, err := tls.Listen("tcp", cfg.Listen, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	return listener, client, err
+}
+
+// record запоминает изменение хранилища до фиксации; вызывается под блокировкой хранилища
+func (c *Cluster) record(change StoreChange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, change)
+}
+
+// Commit фиксирует изменения, внесенные в хранилище с прошлого вызова. Если фиксация
+// не удалась, хранилище возвращается к зафиксированному состоянию.
+func (c *Cluster) Commit() error {
+	c.mu.Lock()
+	changes := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := encodeRaftCommand(changes)
+	if err == nil {
+		data, err = SealStorage(c.fsm.keys, data)
+	}
+	if err == nil {
+		future := c.raft.Apply(data, raftApplyTimeout)
+		if err = future.Error(); err == nil {
+			if applyErr, failed := future.Response().(error); failed {
+				err = applyErr
+			}
+		}
+	}
+	if err != nil {
+		c.fsm.reset()
+		return fmt.Errorf("изменение не зафиксировано кластером: %v", err)
+	}
+	return nil
+}
+
+// watchLeadership разрешает изменения, когда узел стал лидером и применил весь журнал,
+// и запрещает при потере лидерства
+func (c *Cluster) watchLeadership() {
+	for leader := range c.leader {
+		if leader && c.raft.Barrier(raftApplyTimeout).Error() != nil {
+			leader = false
+		}
+		c.mu.Lock()
+		c.ready = leader
+		c.mu.Unlock()
+		if !leader {
+			c.fsm.reset()
+		}
+		fmt.Fprintf(os.Stderr, "кластер: узел %s, лидер: %v\n", c.id, leader)
+	}
+}
+
+// Writable сообщает, принимает ли узел изменения
+func (c *Cluster) Writable() bool {
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+	return ready && c.raft.State() == raft.Leader
+}
+
+// Leader возвращает идентификатор и адрес Raft текущего лидера (пусто - лидер не выбран)
+func (c *Cluster) Leader() (string, string) {
+	addr, id := c.raft.LeaderWithID()
+	return string(id), string(addr)
+}
+
+// Status возвращает состояние узла
+func (c *Cluster) Status() ClusterStatus {
+	leaderID, leader := c.Leader()
+	status := ClusterStatus{
+		ID:           c.id,
+		State:        c.raft.State().String(),
+		Writable:     c.Writable(),
+		LeaderID:     leaderID,
+		Leader:       leader,
+		LastIndex:    c.raft.LastIndex(),
+		AppliedIndex: c.raft.AppliedIndex(),
+		Servers:      []ClusterServer{},
+	}
+	if future := c.raft.GetConfiguration(); future.Error() == nil {
+		for _, server := range future.Configuration().Servers {
+			status.Servers = append(status.Servers, ClusterServer{ID: string(server.ID), Address: string(server.Address)})
+		}
+	}
+	sort.Slice(status.Servers, func(i, j int) bool { return status.Servers[i].ID < status.Servers[j].ID })
+	return status
+}
+
+// Shutdown останавливает узел и закрывает журнал
+func (c *Cluster) Shutdown() error {
+	err := c.raft.Shutdown().Error()
+	if closeErr := c.logs.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
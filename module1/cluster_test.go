@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+// memorySink - приемник снимка в памяти
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (s *memorySink) ID() string    { return "test" }
+func (s *memorySink) Cancel() error { return nil }
+func (s *memorySink) Close() error  { return nil }
+
+func newTestFSM() *clusterFSM {
+	return &clusterFSM{store: NewUserStore(), users: make(map[string]*User)}
+}
+
+func TestClusterFSMCurrentFormat(t *testing.T) {
+	data, err := encodeRaftCommand([]StoreChange{
+		{Username: "alice", User: testUser()},
+		{Username: "bob", User: &User{Username: "bob", HashedPassword: "$2a$10$hash"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte(`{"version":1,`)) {
+		t.Errorf("запись журнала без версии: %s", data)
+	}
+
+	// Узел до введения версий читает поле changes и игнорирует version
+	var legacy struct{ Changes []StoreChange }
+	if err := json.Unmarshal(data, &legacy); err != nil || len(legacy.Changes) != 2 {
+		t.Errorf("прежний формат не читает новую запись: %v", err)
+	}
+
+	fsm := newTestFSM()
+	if err, failed := fsm.Apply(&raft.Log{Index: 1, Data: data}).(error); failed {
+		t.Fatal(err)
+	}
+	snapshot, _ := fsm.Snapshot()
+	var sink memorySink
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sink.Bytes(), []byte(`{"version":1,`)) {
+		t.Errorf("снимок без версии: %.40s", sink.Bytes())
+	}
+
+	restored := newTestFSM()
+	if err := restored.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := restored.store.GetUser("alice")
+	if !reflect.DeepEqual(restored.store.Usernames(), []string{"alice", "bob"}) || !reflect.DeepEqual(alice, testUser()) {
+		t.Errorf("восстановлено %v, alice %+v", restored.store.Usernames(), alice)
+	}
+}
+
+// TestClusterFSMLegacyFormat читает журнал и снимок, записанные до введения версий
+func TestClusterFSMLegacyFormat(t *testing.T) {
+	entry, _ := json.Marshal(map[string]interface{}{
+		"changes": []StoreChange{{Username: "alice", User: testUser()}, {Username: "carol"}},
+	})
+	fsm := newTestFSM()
+	fsm.store.SaveUser(&User{Username: "carol"})
+	if err, failed := fsm.Apply(&raft.Log{Index: 1, Data: entry}).(error); failed {
+		t.Fatal(err)
+	}
+	if got := fsm.store.Usernames(); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("после записи формата 0: %v", got)
+	}
+
+	snapshot, _ := json.Marshal([]*User{testUser(), {Username: "bob"}})
+	restored := newTestFSM()
+	if err := restored.Restore(io.NopCloser(bytes.NewReader(snapshot))); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.store.Usernames(); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("после снимка формата 0: %v", got)
+	}
+}
+
+func TestClusterFSMRejectsNewerFormat(t *testing.T) {
+	fsm := newTestFSM()
+	result := fsm.Apply(&raft.Log{Index: 7, Data: []byte(`{"version":2,"changes":[{"Username":"alice"}]}`)})
+	if err, failed := result.(error); !failed || !strings.Contains(err.Error(), "обновите узел") {
+		t.Errorf("запись формата 2 применена: %v", result)
+	}
+
+	err := fsm.Restore(io.NopCloser(strings.NewReader(`{"version":2,"users":[]}`)))
+	if err == nil || !strings.Contains(err.Error(), "обновите узел") {
+		t.Errorf("снимок формата 2 прочитан: %v", err)
+	}
+}
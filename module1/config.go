@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// PolicyConfig - параметры политики из файла конфигурации. Отсутствующие поля
+// оставляют действующие значения без изменений.
+type PolicyConfig struct {
+	PasswordRules   *PasswordRules      `json:"password_rules,omitempty"`   // Правила паролей
+	MaxAttempts     *int                `json:"max_attempts,omitempty"`     // Неудачных попыток до блокировки
+	FailureWindow   *string             `json:"failure_window,omitempty"`   // Окно подсчета неудачных попыток ("15m", "0" - без ограничения)
+	HoneypotLockout *string             `json:"honeypot_lockout,omitempty"` // Блокировка входа после попытки входа в ловушку
+	Features        map[string]bool     `json:"features,omitempty"`         // Включение подсистем (false - отключена)
+	Registration    *string             `json:"registration,omitempty"`     // Режим регистрации: open, invite или admin
+	ProfileSteps    []ProfileStep       `json:"profile_steps,omitempty"`    // Шаги профиля после N входов или дней ([] - не требуются)
+	Roles           map[string][]string `json:"roles,omitempty"`            // Роли с набором прав: имя -> права (user.read, user.unlock...)
+	Groups          map[string]Group    `json:"groups,omitempty"`           // Группы пользователей: описание и аннотации (require_2fa)
+	Rules           []PolicyRule        `json:"rules,omitempty"`            // Правила входа по атрибутам ([] - не заданы)
+	Dormancy        *DormancyConfig     `json:"dormancy,omitempty"`         // Обработка учетных записей без входа
+	DualControl     []string            `json:"dual_control,omitempty"`     // Операции API по правилу двух лиц: delete, unlock ([] - нет)
+	Hooks           map[string]string   `json:"hooks,omitempty"`            // Внешние обработчики: точка вызова -> программа
+	Terms           *termsConfig        `json:"terms,omitempty"`            // Условия использования, принимаемые при входе
+}
+
+// LoadPolicyConfig читает конфигурацию политики из JSON-файла
+func LoadPolicyConfig(path string) (PolicyConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return PolicyConfig{}, fmt.Errorf("ошибка чтения конфигурации: %v", err)
+	}
+	defer file.Close()
+
+	var config PolicyConfig
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return PolicyConfig{}, fmt.Errorf("некорректный формат конфигурации: %v", err)
+	}
+	return config, nil
+}
+
+// ApplyPolicyConfig проверяет конфигурацию целиком и только затем применяет ее.
+// При любой ошибке, а также при пробном запуске действующая политика не меняется.
+func (um *UserManager) ApplyPolicyConfig(config PolicyConfig) ([]string, error) {
+	if config.PasswordRules != nil {
+		if err := validatePasswordRules(*config.PasswordRules); err != nil {
+			return nil, fmt.Errorf("password_rules: %v", err)
+		}
+	}
+	if config.MaxAttempts != nil && *config.MaxAttempts < 1 {
+		return nil, fmt.Errorf("max_attempts: должно быть не меньше 1")
+	}
+	failureWindow, err := parseConfigDuration("failure_window", config.FailureWindow, um.failureWindow)
+	if err != nil {
+		return nil, err
+	}
+	honeypotLockout, err := parseConfigDuration("honeypot_lockout", config.HoneypotLockout, um.honeypotLockout)
+	if err != nil {
+		return nil, err
+	}
+	registration := um.RegistrationMode()
+	if config.Registration != nil {
+		if registration, err = parseRegistrationMode(*config.Registration); err != nil {
+			return nil, err
+		}
+		if registration == RegistrationInvite && um.inviteKey == nil {
+			return nil, fmt.Errorf("registration: для режима invite нужен ключ подписи приглашений (флаг -invite-only)")
+		}
+	}
+	profileSteps := um.profileSteps
+	if config.ProfileSteps != nil {
+		if profileSteps, err = parseProfileSteps(config.ProfileSteps); err != nil {
+			return nil, err
+		}
+	}
+	disabledFeatures := um.disabledFeatures
+	if config.Features != nil {
+		if disabledFeatures, err = parseFeatures(config.Features); err != nil {
+			return nil, err
+		}
+	}
+
+	roles := um.roles
+	if config.Roles != nil {
+		if roles, err = parseRoles(config.Roles); err != nil {
+			return nil, err
+		}
+		for _, user := range um.store.GetAllUsers() {
+			if _, ok := roles[user.Role]; user.Role != "" && !ok {
+				return nil, fmt.Errorf("roles: роль %s назначена пользователю %s", user.Role, user.Username)
+			}
+		}
+	}
+
+	groups := um.groups
+	if config.Groups != nil {
+		if groups, err = parseGroups(config.Groups); err != nil {
+			return nil, err
+		}
+		for _, user := range um.store.GetAllUsers() {
+			for _, name := range user.Groups {
+				if _, ok := groups[name]; !ok {
+					return nil, fmt.Errorf("groups: в группе %s состоит пользователь %s", name, user.Username)
+				}
+				if groups[name].Rule != "" {
+					return nil, fmt.Errorf("groups.%s.rule: в группу назначен пользователь %s, участники группы с правилом не назначаются", name, user.Username)
+				}
+			}
+		}
+	}
+
+	policyRules := um.policyRules
+	if config.Rules != nil {
+		if policyRules, err = parsePolicyRules(config.Rules); err != nil {
+			return nil, err
+		}
+	}
+
+	dormancy := um.dormancy
+	if config.Dormancy != nil {
+		if dormancy, err = parseDormancyConfig(*config.Dormancy, um.dormancy); err != nil {
+			return nil, err
+		}
+	}
+
+	dualControl := um.dualControl
+	if config.DualControl != nil {
+		if dualControl, err = parseDualControl(config.DualControl); err != nil {
+			return nil, err
+		}
+	}
+
+	hooks := um.hooks
+	if config.Hooks != nil {
+		if hooks, err = parseHooks(config.Hooks); err != nil {
+			return nil, err
+		}
+	}
+
+	terms := um.terms
+	if config.Terms != nil {
+		if terms, err = loadTerms(*config.Terms); err != nil {
+			return nil, err
+		}
+	}
+
+	// Изменения собираются вместе с действиями по их применению, чтобы при пробном
+	// запуске сообщить о них, ничего не меняя
+	var changes []string
+	var apply []func()
+	if config.PasswordRules != nil && *config.PasswordRules != um.rules {
+		rules := *config.PasswordRules
+		apply = append(apply, func() {
+			um.rules = rules
+			um.rulesChangedAt = time.Now()
+		})
+		changes = append(changes, fmt.Sprintf("правила паролей: длина %d, классы: %s",
+			rules.Length, describeCharClasses(rules)))
+	}
+	if config.MaxAttempts != nil && *config.MaxAttempts != um.maxAttempts {
+		maxAttempts := *config.MaxAttempts
+		apply = append(apply, func() { um.maxAttempts = maxAttempts })
+		changes = append(changes, fmt.Sprintf("попыток до блокировки: %d", maxAttempts))
+	}
+	if failureWindow != um.failureWindow {
+		apply = append(apply, func() { um.SetFailureWindow(failureWindow) })
+		changes = append(changes, fmt.Sprintf("окно подсчета попыток: %v", failureWindow))
+	}
+	if honeypotLockout != um.honeypotLockout {
+		apply = append(apply, func() { um.SetHoneypotLockout(honeypotLockout) })
+		changes = append(changes, fmt.Sprintf("блокировка после ловушки: %v", honeypotLockout))
+	}
+
+	if registration != um.RegistrationMode() {
+		apply = append(apply, func() { um.registration = registration })
+		changes = append(changes, "регистрация: "+describeRegistration(registration))
+	}
+
+	if describeProfileSteps(profileSteps) != describeProfileSteps(um.profileSteps) {
+		apply = append(apply, func() { um.profileSteps = profileSteps })
+		changes = append(changes, "шаги профиля: "+describeProfileSteps(profileSteps))
+	}
+
+	if describeFeatures(disabledFeatures) != describeFeatures(um.disabledFeatures) {
+		apply = append(apply, func() { um.disabledFeatures = disabledFeatures })
+		changes = append(changes, "подсистемы: "+describeFeatures(disabledFeatures))
+	}
+
+	if describeRoles(roles) != describeRoles(um.roles) {
+		apply = append(apply, func() { um.roles = roles })
+		changes = append(changes, "роли: "+describeRoles(roles))
+	}
+
+	if describeGroups(groups) != describeGroups(um.groups) {
+		apply = append(apply, func() { um.groups = groups })
+		changes = append(changes, "группы: "+describeGroups(groups))
+	}
+
+	if describePolicyRules(policyRules) != describePolicyRules(um.policyRules) {
+		apply = append(apply, func() { um.policyRules = policyRules })
+		changes = append(changes, "правила входа: "+describePolicyRules(policyRules))
+	}
+
+	if dormancy != um.dormancy {
+		apply = append(apply, func() { um.SetDormancyPolicy(dormancy) })
+		changes = append(changes, "неактивные учетные записи: "+describeDormancy(dormancy))
+	}
+
+	if describeDualControl(dualControl) != describeDualControl(um.dualControl) {
+		apply = append(apply, func() { um.dualControl = dualControl })
+		changes = append(changes, "правило двух лиц: "+describeDualControl(dualControl))
+	}
+
+	if describeHooks(hooks) != describeHooks(um.hooks) {
+		apply = append(apply, func() { um.hooks = hooks })
+		changes = append(changes, "обработчики: "+describeHooks(hooks))
+	}
+
+	if terms != um.terms {
+		apply = append(apply, func() { um.terms = terms })
+		if terms.Version == "" {
+			changes = append(changes, "принятие условий использования не требуется")
+		} else {
+			changes = append(changes, "условия использования: версия "+terms.Version)
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	details := "конфигурация: " + strings.Join(changes, "; ")
+	if um.dryRun {
+		um.planChange(AuditPolicyChanged, "", details)
+		return changes, nil
+	}
+	for _, fn := range apply {
+		fn()
+	}
+	um.recordAudit(AuditPolicyChanged, "", details)
+	return changes, nil
+}
+
+// parseConfigDuration разбирает длительность из конфигурации (nil - текущее значение)
+func parseConfigDuration(name string, value *string, current time.Duration) (time.Duration, error) {
+	if value == nil {
+		return current, nil
+	}
+	duration, err := time.ParseDuration(strings.TrimSpace(*value))
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("%s: некорректная длительность %q", name, *value)
+	}
+	return duration, nil
+}
+
+// CurrentPolicyConfig возвращает действующую политику в формате файла конфигурации.
+// Обработчики и условия использования в нее не входят.
+func (um *UserManager) CurrentPolicyConfig() PolicyConfig {
+	rules := um.rules
+	maxAttempts := um.maxAttempts
+	failureWindow := um.failureWindow.String()
+	honeypotLockout := um.honeypotLockout.String()
+	registration := string(um.RegistrationMode())
+	features := make(map[string]bool, len(knownFeatures))
+	for feature := range knownFeatures {
+		features[string(feature)] = um.FeatureEnabled(feature)
+	}
+	roles := make(map[string][]string, len(um.roles))
+	for name, permissions := range um.roles {
+		roles[name] = permissionNames(permissions)
+	}
+	groups := make(map[string]Group, len(um.groups))
+	for name, group := range um.groups {
+		groups[name] = group.clone()
+	}
+	return PolicyConfig{
+		PasswordRules:   &rules,
+		MaxAttempts:     &maxAttempts,
+		FailureWindow:   &failureWindow,
+		HoneypotLockout: &honeypotLockout,
+		Features:        features,
+		Registration:    &registration,
+		ProfileSteps:    append([]ProfileStep(nil), um.profileSteps...),
+		Roles:           roles,
+		Groups:          groups,
+		Rules:           append([]PolicyRule{}, um.policyRules...),
+		Dormancy:        dormancyConfig(um.dormancy),
+		DualControl:     dualControlList(um.dualControl),
+	}
+}
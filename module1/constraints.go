@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TargetConstraints - ограничения системы, для которой генерируется пароль (мейнфрейм,
+// ключ Wi-Fi и т.п.). Генератор выполняет их вместе с правилами безопасности; если
+// одновременно это невозможно, генерация завершается ошибкой, а правила не ослабляются.
+type TargetConstraints struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	MaxLength       int    `json:"max_length,omitempty"`        // Наибольшая длина (0 - без ограничения)
+	SpecialChars    string `json:"special_chars,omitempty"`     // Допустимые специальные символы (пусто - стандартный набор)
+	ForbiddenChars  string `json:"forbidden_chars,omitempty"`   // Символы, которые система не принимает
+	StartWithLetter bool   `json:"start_with_letter,omitempty"` // Пароль начинается с буквы
+	NoTrailingSpace bool   `json:"no_trailing_space,omitempty"` // Пароль не заканчивается пробелом
+}
+
+// TargetProfiles - типовые ограничения целевых систем; дополняются файлом -target-profiles
+var TargetProfiles = []TargetConstraints{
+	{ID: "mainframe", Name: "Мейнфрейм (RACF)", MaxLength: 8, SpecialChars: "@#$", StartWithLetter: true},
+	{ID: "wifi-psk", Name: "Ключ Wi-Fi (WPA2-PSK)", MaxLength: 63, SpecialChars: specialChars + " ", NoTrailingSpace: true},
+	{ID: "no-ambiguous", Name: "Без похожих символов", ForbiddenChars: "Il1O0|"},
+}
+
+// FindTargetProfile ищет ограничения целевой системы по идентификатору
+func FindTargetProfile(id string) (TargetConstraints, bool) {
+	for _, profile := range TargetProfiles {
+		if profile.ID == id {
+			return profile, true
+		}
+	}
+	return TargetConstraints{}, false
+}
+
+// LoadTargetProfiles дополняет типовые ограничения целевых систем профилями из JSON-файла.
+// Профиль с тем же id заменяет типовой.
+func LoadTargetProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения ограничений целевых систем: %v", err)
+	}
+	var custom []TargetConstraints
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&custom); err != nil {
+		return fmt.Errorf("%s: некорректный формат ограничений целевых систем: %v", path, err)
+	}
+
+	merged := append([]TargetConstraints(nil), TargetProfiles...)
+	seen := make(map[string]bool)
+	for i, profile := range custom {
+		switch {
+		case profile.ID == "" || strings.ContainsAny(profile.ID, " ,"):
+			return fmt.Errorf("%s: профиль %d: id должен быть непустым и без пробелов и запятых", path, i+1)
+		case seen[profile.ID]:
+			return fmt.Errorf("%s: профиль %s задан повторно", path, profile.ID)
+		case profile.Name == "":
+			return fmt.Errorf("%s: профиль %s: не задано название", path, profile.ID)
+		case profile.MaxLength < 0:
+			return fmt.Errorf("%s: профиль %s: max_length не может быть отрицательной", path, profile.ID)
+		}
+		seen[profile.ID] = true
+
+		replaced := false
+		for j := range merged {
+			if merged[j].ID == profile.ID {
+				merged[j], replaced = profile, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, profile)
+		}
+	}
+	TargetProfiles = merged
+	return nil
+}
+
+// generatorClass - обязательный класс символов генератора с учетом ограничений цели
+type generatorClass struct {
+	name   string
+	chars  []rune // Символы, которые засчитываются в минимум класса
+	extra  []rune // Символы цели вне стандартного набора (например, пробел): только для заполнения
+	min    int
+	letter bool
+}
+
+// generatorClasses возвращает обязательные классы символов правил, из которых исключены
+// символы, запрещенные целевой системой. Специальные символы цели, которых нет в стандартном
+// наборе, проверка пароля не засчитывает, поэтому они идут только на заполнение.
+func (t TargetConstraints) generatorClasses(rules PasswordRules) ([]generatorClass, error) {
+	special := specialChars
+	if t.SpecialChars != "" {
+		special = t.SpecialChars
+	}
+	candidates := []struct {
+		required bool
+		class    generatorClass
+		set      string
+	}{
+		{rules.RequireUppercase, generatorClass{name: "заглавных букв", min: rules.MinUppercase, letter: true}, uppercaseLetters},
+		{rules.RequireLowercase, generatorClass{name: "строчных букв", min: rules.MinLowercase, letter: true}, lowercaseLetters},
+		{rules.RequireDigits, generatorClass{name: "цифр", min: rules.MinDigits}, digits},
+		{rules.RequireSpecial, generatorClass{name: "специальных символов", min: rules.MinSpecial}, special},
+	}
+
+	var classes []generatorClass
+	for _, candidate := range candidates {
+		if !candidate.required {
+			continue
+		}
+		class := candidate.class
+		for _, char := range candidate.set {
+			switch {
+			case strings.ContainsRune(t.ForbiddenChars, char):
+			case strings.ContainsRune(uppercaseLetters+lowercaseLetters+digits+specialChars, char):
+				class.chars = append(class.chars, char)
+			default:
+				class.extra = append(class.extra, char)
+			}
+		}
+		if len(class.chars) == 0 {
+			return nil, fmt.Errorf("%s: после исключения запрещенных символов не осталось %s, которых требуют правила", t.Name, class.name)
+		}
+		classes = append(classes, class)
+	}
+	return classes, nil
+}
+
+// ValidateTargetConstraints проверяет пароль по ограничениям целевой системы
+func ValidateTargetConstraints(password string, t TargetConstraints) []PolicyViolation {
+	var violations []PolicyViolation
+	runes := []rune(password)
+	if t.MaxLength > 0 && len(runes) > t.MaxLength {
+		violations = append(violations, RuleMaxLength{Max: t.MaxLength, Got: len(runes)})
+	}
+	for _, char := range t.ForbiddenChars {
+		if strings.ContainsRune(password, char) {
+			violations = append(violations, RuleForbiddenChar{Char: string(char)})
+		}
+	}
+	if t.StartWithLetter && (len(runes) == 0 || !strings.ContainsRune(uppercaseLetters+lowercaseLetters, runes[0])) {
+		violations = append(violations, RuleStartWithLetter{})
+	}
+	if t.NoTrailingSpace && strings.HasSuffix(password, " ") {
+		violations = append(violations, RuleNoTrailingSpace{})
+	}
+	return violations
+}
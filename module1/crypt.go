@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Проверка паролей по хешам crypt(3), перенесенным из /etc/shadow и htpasswd:
+// md5-crypt ($1$), apr1 ($apr1$, Apache) и SHA-crypt ($5$, $6$, спецификация У. Дреппера).
+// Хеши хранятся в LegacyHash как "crypt:<хеш>" и заменяются bcrypt при первом входе.
+
+// cryptAlphabet - алфавит base64 в crypt(3) (отличается от RFC 4648 порядком символов)
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Параметры SHA-crypt
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+)
+
+// cryptScheme описывает схему crypt(3): префикс хеша и функцию вычисления
+type cryptScheme struct {
+	prefix string
+	name   string
+	hash   func(password []byte, settings string) (string, error)
+}
+
+// cryptSchemes - поддерживаемые схемы crypt(3)
+var cryptSchemes = []cryptScheme{
+	{"$1$", "md5-crypt", func(password []byte, settings string) (string, error) {
+		return md5Crypt(password, settings, "$1$"), nil
+	}},
+	{"$apr1$", "apr1-md5", func(password []byte, settings string) (string, error) {
+		return md5Crypt(password, settings, "$apr1$"), nil
+	}},
+	{"$5$", "sha256-crypt", func(password []byte, settings string) (string, error) {
+		return shaCrypt(password, settings, "$5$", sha256.New, sha256CryptOrder)
+	}},
+	{"$6$", "sha512-crypt", func(password []byte, settings string) (string, error) {
+		return shaCrypt(password, settings, "$6$", sha512.New, sha512CryptOrder)
+	}},
+}
+
+// findCryptScheme возвращает схему crypt(3) по префиксу хеша
+func findCryptScheme(hash string) (cryptScheme, bool) {
+	for _, scheme := range cryptSchemes {
+		if strings.HasPrefix(hash, scheme.prefix) {
+			return scheme, true
+		}
+	}
+	return cryptScheme{}, false
+}
+
+// validCryptHash проверяет, что хеш относится к поддерживаемой схеме и его параметры
+// корректны: такой хеш можно перенести, а не требовать смены пароля
+func validCryptHash(hash string) bool {
+	scheme, ok := findCryptScheme(hash)
+	if !ok {
+		return false
+	}
+	// Параметры и длина закодированного хеша не зависят от пароля
+	computed, err := scheme.hash(nil, hash)
+	if err != nil || len(computed) != len(hash) {
+		return false
+	}
+	split := strings.LastIndexByte(computed, '$') + 1
+	return hash[:split] == computed[:split] && strings.Trim(hash[split:], cryptAlphabet) == ""
+}
+
+// cryptVerifier проверяет пароль по хешу crypt(3) за постоянное время
+func cryptVerifier(password, hash string) bool {
+	scheme, ok := findCryptScheme(hash)
+	if !ok {
+		return false
+	}
+	computed, err := scheme.hash([]byte(password), hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// cryptSalt выделяет соль из параметров "$префикс$[rounds=N$]соль[$хеш]" не длиннее maxLen
+func cryptSalt(settings string, maxLen int) string {
+	salt, _, _ := strings.Cut(settings, "$")
+	if len(salt) > maxLen {
+		salt = salt[:maxLen]
+	}
+	return salt
+}
+
+// cryptEncode кодирует три байта (b2 - старший) в n символов алфавита crypt(3)
+func cryptEncode(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
+
+// md5Crypt вычисляет хеш md5-crypt (magic "$1$") или apr1 (magic "$apr1$") по алгоритму
+// П.-Х. Камп: соль до 8 символов, 1000 раундов MD5
+func md5Crypt(password []byte, settings, magic string) string {
+	salt := cryptSalt(strings.TrimPrefix(settings, magic), 8)
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write([]byte(salt))
+	alternate.Write(password)
+	alt := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for length := len(password); length > 0; length -= md5.Size {
+		ctx.Write(alt[:min(length, md5.Size)])
+	}
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(password)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(password)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		cryptEncode(&out, final[group[0]], final[group[1]], final[group[2]], 4)
+	}
+	cryptEncode(&out, 0, 0, final[11], 2)
+	return out.String()
+}
+
+// Порядок байтов дайджеста при кодировании SHA-crypt: тройки (старший байт первым),
+// последняя группа - неполная
+var (
+	sha256CryptOrder = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		{-1, 31, 30},
+	}
+	sha512CryptOrder = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41}, {-1, -1, 63},
+	}
+)
+
+// shaCrypt вычисляет хеш SHA-crypt ($5$ - SHA-256, $6$ - SHA-512). Число раундов вне
+// допустимого диапазона - ошибка, как в libxcrypt (glibc молча приводит его к границе).
+func shaCrypt(password []byte, settings, magic string, newHash func() hash.Hash, order [][3]int) (string, error) {
+	settings = strings.TrimPrefix(settings, magic)
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if rest, found := strings.CutPrefix(settings, "rounds="); found {
+		value, after, _ := strings.Cut(rest, "$")
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < shaCryptMinRounds || parsed > shaCryptMaxRounds {
+			return "", fmt.Errorf("недопустимое число раундов %q", value)
+		}
+		rounds, customRounds, settings = parsed, true, after
+	}
+	salt := []byte(cryptSalt(settings, shaCryptMaxSalt))
+
+	alternate := newHash()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	alt := alternate.Sum(nil)
+	size := len(alt)
+
+	ctx := newHash()
+	ctx.Write(password)
+	ctx.Write(salt)
+	for length := len(password); length > 0; length -= size {
+		ctx.Write(alt[:min(length, size)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write(alt)
+		} else {
+			ctx.Write(password)
+		}
+	}
+	digest := ctx.Sum(nil)
+
+	// Последовательность P: хеш пароля, повторенного len(password) раз, длиной в пароль
+	repeated := newHash()
+	for range password {
+		repeated.Write(password)
+	}
+	p := repeatBytes(repeated.Sum(nil), len(password))
+
+	// Последовательность S: хеш соли, повторенной 16 + digest[0] раз, длиной в соль
+	repeated = newHash()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		repeated.Write(salt)
+	}
+	s := repeatBytes(repeated.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		round := newHash()
+		if i&1 != 0 {
+			round.Write(p)
+		} else {
+			round.Write(digest)
+		}
+		if i%3 != 0 {
+			round.Write(s)
+		}
+		if i%7 != 0 {
+			round.Write(p)
+		}
+		if i&1 != 0 {
+			round.Write(digest)
+		} else {
+			round.Write(p)
+		}
+		digest = round.Sum(digest[:0])
+	}
+
+	var out strings.Builder
+	out.WriteString(magic)
+	if customRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+	for i, group := range order {
+		var b [3]byte
+		for j, index := range group {
+			if index >= 0 {
+				b[j] = digest[index]
+			}
+		}
+		n := 4
+		if i == len(order)-1 {
+			// Неполная группа: 2 байта - 3 символа, 1 байт - 2 символа
+			n = 2
+			if group[1] >= 0 {
+				n = 3
+			}
+		}
+		cryptEncode(&out, b[0], b[1], b[2], n)
+	}
+	return out.String(), nil
+}
+
+// repeatBytes повторяет block до длины length
+func repeatBytes(block []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		out = append(out, block[:min(len(block), length-len(out))]...)
+	}
+	return out
+}
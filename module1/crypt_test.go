@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Векторы получены crypt(3) из libxcrypt (md5-crypt, SHA-crypt) и openssl passwd -apr1;
+// "This is just a test" - пример из спецификации SHA-crypt
+var cryptVectors = []struct {
+	password string
+	hash     string
+}{
+	{"password", "$1$saltstri$qQY4WxjABChYG1ccLpfkz/"},
+	{"", "$1$salt$UsdFqFVB.FsuinRDK5eE.."},
+	{"Пароль123", "$1$abcdefgh$dyfaKNlspaZJWXDumgZNj0"},
+	{"password", "$apr1$saltstri$KbmdckUzuN1qd7Gpo8DEL."},
+	{"Пароль123", "$apr1$saltstri$.N.mMypO9znGED7l7alZP."},
+	{"", "$apr1$x$tMwYqBfQwi3FYAr0aJc8M/"},
+	{"password", "$5$saltstring$OH4IDuTlsuTYPdED1gsuiRMyTAwNlRWyA6Xr3I4/dQ5"},
+	{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{"This is just a test", "$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5"},
+	{"password", "$6$saltstring$adDbXsJjcDlq2662QPgd.tkSOVmnG9Tt3oXl4HR60SusC3AGjirnDenVZp3DGwLwqy6iYKCzannhaX9DR72nN1"},
+	{"Hello world!", "$6$rounds=1000$toolongsaltstrin$sesQxVr.eO8J/1tYcwFWM0XaMVaHFLetz9ssK1oNSPUQgLRm8v3S4i6CgxB9aqGOAFFEMnB2V1gvxEm/Gv2gw/"},
+	{"Пароль 123 очень длинный пароль больше шестидесяти четырех байт!!", "$6$rounds=1200$abc$DvXJudC7cW5.p.1N/FHFLFrzcZaPjFMwIwaEaP1l4wsmlp/OBencqQulZFVVdI2j3YDBN13rC.rCjShT3EDJ5."},
+}
+
+func TestCryptVerifier(t *testing.T) {
+	for _, v := range cryptVectors {
+		if !validCryptHash(v.hash) {
+			t.Errorf("%s: хеш не признан корректным", v.hash)
+		}
+		if !cryptVerifier(v.password, v.hash) {
+			t.Errorf("%s: верный пароль %q отклонен", v.hash, v.password)
+		}
+		if cryptVerifier(v.password+"x", v.hash) {
+			t.Errorf("%s: неверный пароль принят", v.hash)
+		}
+	}
+}
+
+func TestValidCryptHashRejects(t *testing.T) {
+	for _, hash := range []string{
+		"$6$rounds=10$roundstoolow$abc",                                   // Раундов меньше минимума
+		"$6$rounds=x$salt$abc",                                            // Нечисловое число раундов
+		"$6$saltstring$short",                                             // Обрезанный хеш
+		"$1$saltstri$qQY4WxjABChYG1ccLpfkz!",                              // Символ вне алфавита
+		"$6$saltstringsaltstring$" + strings.Repeat("a", 86),              // Соль длиннее 16 символов
+		"$y$j9T$saltsaltsalt$WJhblAc/BKcuw1LHqgcyvlsjC8J4ha9Wl82.5/aQSy8", // yescrypt не поддерживается
+		"$2a$10$abc",
+	} {
+		if validCryptHash(hash) {
+			t.Errorf("%s: некорректный хеш принят", hash)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeletedAccount - удаленная учетная запись, которую еще можно восстановить
+type DeletedAccount struct {
+	Pseudonym string    // Псевдоним, под которым удаление записано в журнал аудита
+	User      *User     // Запись пользователя на момент удаления
+	DeletedAt time.Time // Когда учетная запись удалена
+	PurgeAt   time.Time // Когда запись будет удалена окончательно
+}
+
+// SetDeletionRetention задает срок хранения удаленных учетных записей (0 - удалять сразу)
+func (um *UserManager) SetDeletionRetention(retention time.Duration) {
+	um.deletionRetention = retention
+}
+
+// DeletionRetention возвращает срок хранения удаленных учетных записей
+func (um *UserManager) DeletionRetention() time.Duration {
+	return um.deletionRetention
+}
+
+// retainDeleted сохраняет удаленную учетную запись до истечения срока хранения
+func (um *UserManager) retainDeleted(user *User, pseudonym string, now time.Time) DeletedAccount {
+	if um.deleted == nil {
+		um.deleted = make(map[string]DeletedAccount)
+	}
+	account := DeletedAccount{Pseudonym: pseudonym, User: user, DeletedAt: now, PurgeAt: now.Add(um.deletionRetention)}
+	um.deleted[user.Username] = account
+	return account
+}
+
+// DeletedAccounts возвращает удаленные учетные записи, ожидающие окончательного удаления
+func (um *UserManager) DeletedAccounts() []DeletedAccount {
+	accounts := make([]DeletedAccount, 0, len(um.deleted))
+	for _, account := range um.deleted {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].DeletedAt.Before(accounts[j].DeletedAt)
+	})
+	return accounts
+}
+
+// RestoreUser восстанавливает удаленную учетную запись, если срок хранения не истек
+// и логин не занят новой учетной записью
+func (um *UserManager) RestoreUser(username, reason string) error {
+	username = strings.TrimSpace(username)
+
+	account, exists := um.deleted[username]
+	if !exists {
+		return fmt.Errorf("удаленная учетная запись '%s' не найдена", username)
+	}
+	if um.store.UserExists(username) {
+		return fmt.Errorf("логин '%s' занят новой учетной записью", username)
+	}
+
+	// Токены, выданные до удаления, после восстановления не действуют
+	account.User.TokensRevokedAt = time.Now()
+	um.store.SaveUser(account.User)
+	delete(um.deleted, username)
+
+	um.recordAudit(AuditAccountRestored, username, fmt.Sprintf("удалена как %s; %s", account.Pseudonym, reason))
+	um.usersChanged()
+	return nil
+}
+
+// PurgeDeleted окончательно удаляет учетные записи с истекшим сроком хранения
+// и возвращает их псевдонимы (при пробном запуске записи не удаляются)
+func (um *UserManager) PurgeDeleted(now time.Time) []string {
+	var purged []string
+	for username, account := range um.deleted {
+		if now.Before(account.PurgeAt) {
+			continue
+		}
+		if um.dryRun {
+			um.planChange(AuditAccountPurged, account.Pseudonym, "срок хранения удаленной учетной записи истек")
+			purged = append(purged, account.Pseudonym)
+			continue
+		}
+		delete(um.deleted, username)
+		um.recordAudit(AuditAccountPurged, account.Pseudonym, "срок хранения удаленной учетной записи истек")
+		purged = append(purged, account.Pseudonym)
+	}
+	sort.Strings(purged)
+	return purged
+}
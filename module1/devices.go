@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Устройства входа через HTTP API. Устройство определяется хешем заголовка User-Agent и
+// имени, которое клиент сообщает в заголовке X-Device-Name (сервис входа передает их от
+// браузера или приложения пользователя). Учетная запись помнит до maxKnownDevices последних
+// устройств. Вход с незнакомого устройства, когда знакомые уже есть, записывается в журнал
+// аудита (new_device) и передается обработчику new_device, например для письма пользователю.
+// Первый вход ничего не сообщает: сравнивать еще не с чем.
+
+// deviceNameHeader - заголовок имени устройства, объявленного клиентом
+const deviceNameHeader = "X-Device-Name"
+
+// maxDeviceNameLength - наибольшая длина имени устройства в символах
+const maxDeviceNameLength = 64
+
+// maxKnownDevices - сколько устройств помнит учетная запись
+const maxKnownDevices = 10
+
+// KnownDevice - устройство, с которого выполнялся вход
+type KnownDevice struct {
+	Hash      string    `json:"hash"`           // Хеш User-Agent и имени устройства
+	Name      string    `json:"name,omitempty"` // Имя из X-Device-Name
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// requestDevice возвращает хеш устройства запроса и объявленное имя. Пустой хеш - клиент
+// не передал ни User-Agent, ни имени устройства.
+func requestDevice(r *http.Request) (hash, name string) {
+	agent := r.Header.Get("User-Agent")
+	// Имя попадает в журнал аудита и письма: управляющие символы отбрасываются
+	name = strings.TrimSpace(strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, r.Header.Get(deviceNameHeader)))
+	if runes := []rune(name); len(runes) > maxDeviceNameLength {
+		name = string(runes[:maxDeviceNameLength])
+	}
+	if agent == "" && name == "" {
+		return "", ""
+	}
+	sum := sha256.Sum256([]byte(agent + "\x00" + name))
+	return hex.EncodeToString(sum[:16]), name
+}
+
+// describeDevice описывает устройство для журнала аудита
+func describeDevice(device KnownDevice) string {
+	if device.Name == "" {
+		return "устройство " + device.Hash
+	}
+	return "устройство " + device.Name + " (" + device.Hash + ")"
+}
+
+// rememberDevice отмечает вход пользователя с устройства источника source и сообщает, новое
+// ли оно: незнакомое устройство при непустом списке знакомых. Сверх maxKnownDevices
+// забываются устройства, с которых входили раньше всего.
+func rememberDevice(user *User, source AuditSource, now time.Time) (KnownDevice, bool) {
+	for i := range user.KnownDevices {
+		if user.KnownDevices[i].Hash == source.Device {
+			user.KnownDevices[i].LastSeen = now
+			return user.KnownDevices[i], false
+		}
+	}
+	device := KnownDevice{Hash: source.Device, Name: source.DeviceName, FirstSeen: now, LastSeen: now}
+	isNew := len(user.KnownDevices) > 0
+	user.KnownDevices = append(user.KnownDevices, device)
+	if len(user.KnownDevices) > maxKnownDevices {
+		sort.SliceStable(user.KnownDevices, func(i, j int) bool {
+			return user.KnownDevices[i].LastSeen.After(user.KnownDevices[j].LastSeen)
+		})
+		user.KnownDevices = user.KnownDevices[:maxKnownDevices]
+	}
+	return device, isNew
+}
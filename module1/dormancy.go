@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DormancyAction определяет действие с неактивной учетной записью
+type DormancyAction int
+
+const (
+	DormancyFlag    DormancyAction = iota // Только пометить как неактивную
+	DormancyDisable                       // Заблокировать до смены пароля
+)
+
+// dormancyInterval - как часто сервер API выполняет проход политики неактивности
+const dormancyInterval = time.Hour
+
+// dormancyActions - названия действий в файле политики
+var dormancyActions = map[string]DormancyAction{"flag": DormancyFlag, "disable": DormancyDisable}
+
+// DormancyPolicy задает правила обработки неактивных учетных записей
+type DormancyPolicy struct {
+	InactiveAfter time.Duration  // Срок без входа, после которого запись считается неактивной (0 - политика выключена)
+	WarnBefore    time.Duration  // За сколько до срока предупреждать пользователя
+	Action        DormancyAction // Что делать с неактивной записью
+}
+
+// DefaultDormancyPolicy возвращает политику по умолчанию: пометка после 90 дней без входа
+func DefaultDormancyPolicy() DormancyPolicy {
+	return DormancyPolicy{
+		InactiveAfter: 90 * 24 * time.Hour,
+		WarnBefore:    7 * 24 * time.Hour,
+		Action:        DormancyFlag,
+	}
+}
+
+// DormancyConfig - раздел dormancy файла политики. Сроки задаются как в отчете об
+// активности: "90d", "2w", "36h".
+type DormancyConfig struct {
+	InactiveAfter *string `json:"inactive_after,omitempty"` // Срок без входа ("0" - политика выключена)
+	WarnBefore    *string `json:"warn_before,omitempty"`    // За сколько до срока предупреждать
+	Action        *string `json:"action,omitempty"`         // flag или disable
+}
+
+// parseDormancyConfig проверяет раздел dormancy; отсутствующие поля берутся из current
+func parseDormancyConfig(config DormancyConfig, current DormancyPolicy) (DormancyPolicy, error) {
+	policy := current
+	if config.InactiveAfter != nil {
+		period, err := ParsePeriod(*config.InactiveAfter)
+		if err != nil {
+			return current, fmt.Errorf("dormancy.inactive_after: %v", err)
+		}
+		policy.InactiveAfter = period
+	}
+	if config.WarnBefore != nil {
+		period, err := ParsePeriod(*config.WarnBefore)
+		if err != nil {
+			return current, fmt.Errorf("dormancy.warn_before: %v", err)
+		}
+		policy.WarnBefore = period
+	}
+	if config.Action != nil {
+		action, ok := dormancyActions[strings.TrimSpace(*config.Action)]
+		if !ok {
+			return current, fmt.Errorf("dormancy.action: допустимо flag или disable")
+		}
+		policy.Action = action
+	}
+	if policy.InactiveAfter > 0 && policy.WarnBefore >= policy.InactiveAfter {
+		return current, fmt.Errorf("dormancy.warn_before: должно быть меньше inactive_after")
+	}
+	return policy, nil
+}
+
+// dormancyConfig возвращает политику в формате файла конфигурации
+func dormancyConfig(policy DormancyPolicy) *DormancyConfig {
+	inactiveAfter := formatPeriod(policy.InactiveAfter)
+	warnBefore := formatPeriod(policy.WarnBefore)
+	action := "flag"
+	if policy.Action == DormancyDisable {
+		action = "disable"
+	}
+	return &DormancyConfig{InactiveAfter: &inactiveAfter, WarnBefore: &warnBefore, Action: &action}
+}
+
+// describeDormancy описывает политику неактивности для журнала аудита
+func describeDormancy(policy DormancyPolicy) string {
+	if policy.InactiveAfter <= 0 {
+		return "выключена"
+	}
+	config := dormancyConfig(policy)
+	return fmt.Sprintf("%s без входа, предупреждение за %s, действие %s", *config.InactiveAfter, *config.WarnBefore, *config.Action)
+}
+
+// formatPeriod записывает срок в формате ParsePeriod: целые сутки - "90d"
+func formatPeriod(period time.Duration) string {
+	if period > 0 && period%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", period/(24*time.Hour))
+	}
+	return period.String()
+}
+
+// DormancyResult - итог одного прохода политики неактивности
+type DormancyResult struct {
+	Warned   []string // Скоро станут неактивными
+	Flagged  []string // Помечены как неактивные
+	Disabled []string // Заблокированы из-за неактивности
+}
+
+// Empty сообщает, что проход политики ничего не изменил
+func (r DormancyResult) Empty() bool {
+	return len(r.Warned) == 0 && len(r.Flagged) == 0 && len(r.Disabled) == 0
+}
+
+// SetDormancyPolicy задает политику обработки неактивных учетных записей
+func (um *UserManager) SetDormancyPolicy(policy DormancyPolicy) {
+	um.dormancy = policy
+}
+
+// ApplyDormancyPolicy проверяет все учетные записи и помечает или блокирует неактивные
+func (um *UserManager) ApplyDormancyPolicy(now time.Time) DormancyResult {
+	var result DormancyResult
+	if um.dormancy.InactiveAfter <= 0 {
+		return result
+	}
+
+	for username, user := range um.store.GetAllUsers() {
+		// Заявки на регистрацию не считаются неактивными учетными записями
+		if user.IsHoneypot || user.PendingApproval {
+			continue
+		}
+
+		var event string
+		um.store.Update(username, func(user *User) error {
+			event = um.applyDormancy(user, now)
+			return nil
+		})
+
+		switch event {
+		case AuditDormancyWarned:
+			result.Warned = append(result.Warned, username)
+		case AuditDormancyFlagged:
+			result.Flagged = append(result.Flagged, username)
+		case AuditDormancyBlocked:
+			result.Disabled = append(result.Disabled, username)
+		default:
+			continue
+		}
+		um.recordAudit(event, username, "")
+	}
+
+	if len(result.Disabled) > 0 {
+		um.usersChanged()
+	}
+
+	sort.Strings(result.Warned)
+	sort.Strings(result.Flagged)
+	sort.Strings(result.Disabled)
+
+	return result
+}
+
+// applyDormancy применяет политику неактивности к одной учетной записи
+// и возвращает событие аудита (пусто - запись не изменилась)
+func (um *UserManager) applyDormancy(user *User, now time.Time) string {
+	// Если пользователь ни разу не входил, отсчитываем от даты регистрации
+	lastActivity := user.LastLoginAt
+	if lastActivity.IsZero() {
+		lastActivity = user.CreatedAt
+	}
+	inactiveFor := now.Sub(lastActivity)
+
+	if inactiveFor < um.dormancy.InactiveAfter {
+		// Предупреждаем один раз, когда до срока осталось меньше WarnBefore
+		if inactiveFor >= um.dormancy.InactiveAfter-um.dormancy.WarnBefore && user.DormancyWarnedAt.IsZero() {
+			user.DormancyWarnedAt = now
+			return AuditDormancyWarned
+		}
+		return ""
+	}
+
+	if !user.DormantSince.IsZero() {
+		return ""
+	}
+
+	user.DormantSince = now
+	if um.dormancy.Action == DormancyDisable && !user.IsBlocked {
+		user.IsBlocked = true
+		user.BlockedAt = now
+		return AuditDormancyBlocked
+	}
+	return AuditDormancyFlagged
+}
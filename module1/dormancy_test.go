@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDormancyConfig(t *testing.T) {
+	text := func(value string) *string { return &value }
+	tests := []struct {
+		name   string
+		config DormancyConfig
+		want   DormancyPolicy
+		err    string
+	}{
+		{"по умолчанию", DormancyConfig{}, DefaultDormancyPolicy(), ""},
+		{"все поля", DormancyConfig{InactiveAfter: text("30d"), WarnBefore: text("2d"), Action: text("disable")},
+			DormancyPolicy{InactiveAfter: 30 * 24 * time.Hour, WarnBefore: 2 * 24 * time.Hour, Action: DormancyDisable}, ""},
+		{"выключена", DormancyConfig{InactiveAfter: text("0")},
+			DormancyPolicy{WarnBefore: 7 * 24 * time.Hour, Action: DormancyFlag}, ""},
+		{"неизвестное действие", DormancyConfig{Action: text("delete")}, DormancyPolicy{}, "dormancy.action"},
+		{"некорректный срок", DormancyConfig{InactiveAfter: text("90 дней")}, DormancyPolicy{}, "dormancy.inactive_after"},
+		{"предупреждение позже срока", DormancyConfig{InactiveAfter: text("5d")}, DormancyPolicy{}, "dormancy.warn_before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			um := NewUserManager()
+			_, err := um.ApplyPolicyConfig(PolicyConfig{Dormancy: &tt.config})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ошибка %v, ожидается %q", err, tt.err)
+				}
+				tt.want = DefaultDormancyPolicy()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if um.dormancy != tt.want {
+				t.Errorf("политика %+v, ожидается %+v", um.dormancy, tt.want)
+			}
+		})
+	}
+
+	// Действующая политика переносится в файл конфигурации без изменений
+	um := NewUserManager()
+	um.SetDormancyPolicy(DormancyPolicy{InactiveAfter: 36 * time.Hour, WarnBefore: 12 * time.Hour, Action: DormancyDisable})
+	current := um.CurrentPolicyConfig()
+	other := NewUserManager()
+	if _, err := other.ApplyPolicyConfig(PolicyConfig{Dormancy: current.Dormancy}); err != nil || other.dormancy != um.dormancy {
+		t.Errorf("политика после переноса %+v (%v), ожидается %+v", other.dormancy, err, um.dormancy)
+	}
+}
+
+func TestAPIDormancyPass(t *testing.T) {
+	s := newTestAPIServer(t)
+	s.um.SetDormancyPolicy(DormancyPolicy{InactiveAfter: 30 * time.Minute, WarnBefore: 10 * time.Minute, Action: DormancyDisable})
+	result, err := s.applyDormancyPolicy(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Disabled, ",") != "bob,root" {
+		t.Errorf("заблокированы %v, ожидаются bob и root", result.Disabled)
+	}
+
+	// Подсистема отключена - проход ничего не меняет
+	s = newTestAPIServer(t)
+	s.um.SetDormancyPolicy(DormancyPolicy{InactiveAfter: 30 * time.Minute, Action: DormancyDisable})
+	s.um.ApplyPolicyConfig(PolicyConfig{Features: map[string]bool{"dormancy": false}})
+	if result, _ := s.applyDormancyPolicy(time.Now()); !result.Empty() {
+		t.Errorf("проход выполнен при отключенной подсистеме: %+v", result)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+// PlannedChange - изменение, которое было бы внесено без пробного запуска.
+// Совпадает с записью, которая попала бы в журнал аудита.
+type PlannedChange struct {
+	Event    string
+	Username string
+	Details  string
+}
+
+// String возвращает изменение в виде строки отчета
+func (c PlannedChange) String() string {
+	text := c.Event
+	if c.Username != "" {
+		text += " " + c.Username
+	}
+	if c.Details != "" {
+		text += ": " + c.Details
+	}
+	return text
+}
+
+// SetDryRun включает пробный запуск: удаление учетных записей, массовый импорт
+// и создание, применение политики и окончательное удаление только сообщают,
+// что изменилось бы, не меняя хранилище, политику и журнал аудита
+func (um *UserManager) SetDryRun(dryRun bool) {
+	um.dryRun = dryRun
+}
+
+// DryRun сообщает, включен ли пробный запуск
+func (um *UserManager) DryRun() bool {
+	return um.dryRun
+}
+
+// DryRunPlan возвращает изменения, накопленные с прошлого вызова в пробном запуске
+func (um *UserManager) DryRunPlan() []PlannedChange {
+	plan := um.planned
+	um.planned = nil
+	return plan
+}
+
+// planChange запоминает изменение вместо записи в журнал аудита при пробном запуске
+func (um *UserManager) planChange(event, username, details string) {
+	um.planned = append(um.planned, PlannedChange{Event: event, Username: username, Details: details})
+}
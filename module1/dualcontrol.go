@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Правило двух лиц (раздел dual_control файла политики): перечисленные операции API
+// выполняются, только когда их запросили два разных участника (токен API, ключи API,
+// сертификаты клиентов). Первый запрос записывается в журнал аудита и ожидает подтверждения,
+// такой же запрос другого участника в течение dualControlTTL выполняет операцию.
+
+// Операции API, для которых можно включить правило двух лиц
+const (
+	dualControlDelete = "delete" // DELETE /users/<логин>
+	dualControlUnlock = "unlock" // POST /users/<логин>/unlock
+)
+
+// dualControlTTL - сколько запрос ожидает подтверждения вторым участником
+const dualControlTTL = 15 * time.Minute
+
+// apiReasonHeader - заголовок с причиной операции для журнала аудита (или поле reason тела)
+const apiReasonHeader = "X-Audit-Reason"
+
+// maxAPIReasonLength - наибольшая длина причины в символах
+const maxAPIReasonLength = 500
+
+// parseDualControl проверяет раздел dual_control
+func parseDualControl(operations []string) (map[string]bool, error) {
+	parsed := make(map[string]bool, len(operations))
+	for _, operation := range operations {
+		operation = strings.TrimSpace(operation)
+		if operation != dualControlDelete && operation != dualControlUnlock {
+			return nil, fmt.Errorf("dual_control: неизвестная операция %q (допустимо %s, %s)", operation, dualControlDelete, dualControlUnlock)
+		}
+		parsed[operation] = true
+	}
+	return parsed, nil
+}
+
+// dualControlList возвращает операции с правилом двух лиц по алфавиту
+func dualControlList(operations map[string]bool) []string {
+	list := []string{}
+	for operation := range operations {
+		list = append(list, operation)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// describeDualControl описывает операции с правилом двух лиц для журнала аудита
+func describeDualControl(operations map[string]bool) string {
+	if len(operations) == 0 {
+		return "нет"
+	}
+	return strings.Join(dualControlList(operations), ", ")
+}
+
+// pendingApproval - операция, ожидающая подтверждения вторым участником
+type pendingApproval struct {
+	principal string    // Кто запросил операцию
+	details   string    // Запрос и причина для журнала аудита
+	expires   time.Time // До какого момента ждать подтверждения
+}
+
+// APIApproval - ответ 202 на запрос операции, ожидающей подтверждения
+type APIApproval struct {
+	Operation   string    `json:"operation"`
+	Username    string    `json:"username"`
+	RequestedBy string    `json:"requested_by"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// readAPIReason возвращает причину операции из поля reason тела запроса или заголовка
+// X-Audit-Reason; тело необязательно. false - ответ об ошибке уже отправлен.
+func readAPIReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("некорректный JSON: %v", err))
+		return "", false
+	}
+	reason := strings.TrimSpace(body.Reason)
+	if reason == "" {
+		reason = strings.TrimSpace(r.Header.Get(apiReasonHeader))
+	}
+	if len([]rune(reason)) > maxAPIReasonLength {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("причина длиннее %d символов", maxAPIReasonLength))
+		return "", false
+	}
+	return reason, true
+}
+
+// approve возвращает описание запроса для журнала аудита: кто его подписал и причина. Если для
+// операции действует правило двух лиц, первый запрос только записывается и получает ответ 202,
+// а выполняет операцию такой же запрос другого участника. false - ответ уже отправлен.
+// Вызывается под s.mu.
+func (s *APIServer) approve(w http.ResponseWriter, r *http.Request, operation, username, reason string) (string, bool) {
+	principal := requestPrincipal(r).Name
+	details := "запрос API (" + principal + ")"
+	if reason != "" {
+		details += ": " + reason
+	}
+	if !s.um.dualControl[operation] {
+		return details, true
+	}
+
+	now := time.Now()
+	key := operation + " " + username
+	pending, found := s.approvals[key]
+	if found && now.Before(pending.expires) && pending.principal != principal {
+		delete(s.approvals, key)
+		return pending.details + "; подтвердил " + details, true
+	}
+	if !found || !now.Before(pending.expires) {
+		if s.approvals == nil {
+			s.approvals = make(map[string]pendingApproval)
+		}
+		pending = pendingApproval{principal: principal, details: details, expires: now.Add(dualControlTTL)}
+		s.approvals[key] = pending
+		s.um.recordAudit(AuditApprovalRequested, username, operation+": "+details)
+	}
+	writeAPIJSON(w, http.StatusAccepted, APIApproval{Operation: operation, Username: username, RequestedBy: pending.principal, ExpiresAt: pending.expires})
+	return "", false
+}
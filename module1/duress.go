@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DuressMode определяет, как система отвечает на вход паролем под принуждением
+type DuressMode int
+
+const (
+	DuressOff          DuressMode = iota // Пароли под принуждением не принимаются
+	DuressAppearLogin                    // Вход выглядит успешным
+	DuressAppearFailed                   // Вход выглядит как неверный пароль
+)
+
+// ParseDuressMode разбирает режим из командной строки: off, login, fail
+func ParseDuressMode(value string) (DuressMode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "off":
+		return DuressOff, nil
+	case "login":
+		return DuressAppearLogin, nil
+	case "fail":
+		return DuressAppearFailed, nil
+	default:
+		return DuressOff, fmt.Errorf("неизвестный режим пароля под принуждением: %s (off, login, fail)", value)
+	}
+}
+
+// SetDuressMode включает или выключает пароли под принуждением
+func (um *UserManager) SetDuressMode(mode DuressMode) {
+	um.duressMode = mode
+}
+
+// DuressEnabled сообщает, разрешены ли пароли под принуждением политикой
+func (um *UserManager) DuressEnabled() bool {
+	return um.duressMode != DuressOff
+}
+
+// SetDuressPassword задает пользователю пароль под принуждением.
+// Пароль должен отличаться от основного и соответствовать правилам паролей.
+func (um *UserManager) SetDuressPassword(username, duressPassword string) error {
+	if !um.DuressEnabled() {
+		return fmt.Errorf("пароли под принуждением отключены политикой")
+	}
+
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists {
+		return fmt.Errorf("пользователь не найден")
+	}
+
+	// Под принуждением пароль не меняется, но для атакующего операция выглядит успешной
+	if user.UnderDuress {
+		return nil
+	}
+
+	if VerifyPassword(duressPassword, user.HashedPassword) {
+		return fmt.Errorf("пароль под принуждением должен отличаться от основного")
+	}
+
+	isSecure, violations := ValidatePassword(duressPassword, um.rules)
+	if !isSecure {
+		return &PasswordPolicyError{Subject: "пароль", Violations: violations}
+	}
+
+	hashedPassword, err := HashPassword(duressPassword)
+	if err != nil {
+		return fmt.Errorf("ошибка при сохранении пароля: %v", err)
+	}
+
+	err = um.store.Update(user.Username, func(user *User) error {
+		user.DuressHash = hashedPassword
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditDuressConfigured, user.Username, "")
+
+	return nil
+}
+
+// checkDuress проверяет неверный основной пароль на совпадение с паролем под принуждением.
+// При совпадении записывает тревогу только в журнал аудита, чтобы ее не увидел атакующий.
+func (um *UserManager) checkDuress(user *User, password string) (AuthResult, bool) {
+	if !um.DuressEnabled() || user.DuressHash == "" || !VerifyPassword(password, user.DuressHash) {
+		return 0, false
+	}
+
+	um.store.Update(user.Username, func(user *User) error {
+		user.UnderDuress = true
+		// Вход выглядит обычным, включая отметку о последнем входе
+		if um.duressMode == DuressAppearLogin {
+			user.LastLoginAt = time.Now()
+		}
+		return nil
+	})
+	um.recordAudit(AuditDuressLogin, user.Username, "вход паролем под принуждением")
+
+	if um.duressMode == DuressAppearFailed {
+		return AuthInvalidCredentials, true
+	}
+	return AuthSuccess, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix - префикс переменных окружения с настройками программы
+const envPrefix = "UAS_"
+
+// envName возвращает переменную окружения флага: -audit-log задается переменной UAS_AUDIT_LOG
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment задает флагам значения из переменных окружения. Значение можно
+// передать и файлом: UAS_<ФЛАГ>_FILE указывает на файл со значением, например
+// смонтированный секрет Kubernetes. Вызывается до разбора командной строки, поэтому
+// флаги командной строки имеют приоритет.
+func applyEnvironment(flags *flag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(f.Name)
+		value, fromEnv := os.LookupEnv(name)
+		path, fromFile := os.LookupEnv(name + "_FILE")
+		switch {
+		case fromEnv && fromFile:
+			err = fmt.Errorf("заданы обе переменные %s и %s_FILE", name, name)
+			return
+		case fromFile:
+			data, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = fmt.Errorf("%s_FILE: %v", name, readErr)
+				return
+			}
+			// Секреты обычно сохраняются с переводом строки в конце
+			value = strings.TrimRight(string(data), "\r\n")
+		case !fromEnv:
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", name, setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// ErrorCode - стабильный код ошибки API. Коды не меняются между версиями, поэтому
+// клиентские приложения могут показывать по ним свои, в том числе переведенные, сообщения;
+// текст в поле error - только подсказка на русском языке.
+type ErrorCode string
+
+const (
+	CodeInvalidCredentials   ErrorCode = "AUTH001" // Неверный логин или пароль
+	CodeAccountLocked        ErrorCode = "AUTH002" // Вход по паролю заблокирован после неудачных попыток
+	CodeLoginSuspended       ErrorCode = "AUTH003" // Вход временно запрещен после попытки входа в ловушку
+	CodeAccountDisabled      ErrorCode = "AUTH004" // Учетная запись отключена администратором
+	CodeOutsideSchedule      ErrorCode = "AUTH005" // Вход запрещен расписанием
+	CodePasswordExpired      ErrorCode = "AUTH006" // Истек срок смены пароля
+	CodeRejectedByHook       ErrorCode = "AUTH007" // Вход запрещен внешней политикой
+	CodePendingApproval      ErrorCode = "AUTH008" // Регистрация ожидает одобрения администратором
+	CodeTermsRequired        ErrorCode = "AUTH009" // Требуется принять условия использования
+	CodeTokenRequired        ErrorCode = "AUTH010" // Нет токена API или он неверен
+	CodeSAMLNotLinked        ErrorCode = "AUTH011" // Удостоверение SAML не связано с учетной записью
+	CodeSAMLRejected         ErrorCode = "AUTH012" // Ответ IdP не прошел проверку: подпись, сроки, аудитория
+	CodeKerberosNotMapped    ErrorCode = "AUTH013" // Участник Kerberos не сопоставлен с учетной записью
+	CodeKerberosRejected     ErrorCode = "AUTH014" // Нет билета Kerberos или он не принят
+	CodeCertificateNotMapped ErrorCode = "AUTH015" // Сертификат клиента не привязан к учетной записи
+	CodeCertificateRejected  ErrorCode = "AUTH016" // Нет сертификата клиента или истек его срок
+	CodeImpersonationDenied  ErrorCode = "AUTH017" // Олицетворение отключено или запрещено для администратора и учетной записи
+	CodePermissionDenied     ErrorCode = "AUTH018" // У токена, ключа API или учетной записи нет права на запрос
+	CodeSecondFactorRequired ErrorCode = "AUTH019" // Группа пользователя или правило политики требует входа со вторым фактором, пароля недостаточно
+	CodeProfileRequired      ErrorCode = "AUTH020" // Нужно выполнить шаги профиля (profile_steps), откладывать их больше нельзя
+	CodeRejectedByRule       ErrorCode = "AUTH021" // Вход запрещен правилом политики (раздел rules)
+
+	CodePasswordPolicy ErrorCode = "PWD001" // Пароль не соответствует политике, нарушения - в details
+
+	CodeUserNotFound       ErrorCode = "USER001" // Пользователь не найден
+	CodeUserExists         ErrorCode = "USER002" // Учетная запись уже существует
+	CodeUserRejected       ErrorCode = "USER003" // Изменение учетной записи отклонено проверками
+	CodeRegistrationClosed ErrorCode = "USER004" // Самостоятельная регистрация закрыта (registration: admin)
+
+	CodeGroupNotFound ErrorCode = "GRP001" // Группа не найдена
+	CodeGroupRejected ErrorCode = "GRP002" // Изменение группы или членства отклонено
+
+	CodePolicyRejected ErrorCode = "POL001" // Изменение политики отклонено
+
+	CodeInvalidRequest     ErrorCode = "REQ001" // Некорректный запрос: JSON, параметры, поля формы
+	CodeMethodNotAllowed   ErrorCode = "REQ002" // Метод не поддерживается ресурсом
+	CodeUnknownResource    ErrorCode = "REQ003" // Неизвестный ресурс
+	CodePreconditionFailed ErrorCode = "REQ004" // Ресурс изменен с момента чтения (If-Match)
+	CodeRequestTooLarge    ErrorCode = "REQ005" // Слишком большой запрос
+
+	CodeInternal          ErrorCode = "SRV001" // Внутренняя ошибка сервера
+	CodeOverloaded        ErrorCode = "SRV002" // Сервер перегружен, запрос стоит повторить после Retry-After
+	CodeNotWritable       ErrorCode = "SRV003" // Экземпляр не принимает изменений: реплика или не лидер кластера
+	CodeNotConfigured     ErrorCode = "SRV004" // Возможность не включена при запуске (токены, кластер)
+	CodeReplicationFailed ErrorCode = "SRV005" // Ошибка репликации или перевода реплики в основной режим
+)
+
+// errorCatalogue - коды ошибок API с описанием для GET /v1/errors
+var errorCatalogue = map[ErrorCode]string{
+	CodeInvalidCredentials:   "неверный логин или пароль",
+	CodeAccountLocked:        "вход по паролю заблокирован после неудачных попыток",
+	CodeLoginSuspended:       "вход временно запрещен",
+	CodeAccountDisabled:      "учетная запись отключена администратором",
+	CodeOutsideSchedule:      "вход запрещен расписанием",
+	CodePasswordExpired:      "истек срок смены пароля",
+	CodeRejectedByHook:       "вход запрещен внешней политикой",
+	CodePendingApproval:      "регистрация ожидает одобрения администратором",
+	CodeTermsRequired:        "требуется принять условия использования",
+	CodeTokenRequired:        "требуется токен API",
+	CodeSAMLNotLinked:        "удостоверение SAML не связано с учетной записью",
+	CodeSAMLRejected:         "утверждение SAML отклонено",
+	CodeKerberosNotMapped:    "участник Kerberos не сопоставлен с учетной записью",
+	CodeKerberosRejected:     "билет Kerberos не принят",
+	CodeCertificateNotMapped: "сертификат клиента не привязан к учетной записи",
+	CodeCertificateRejected:  "сертификат клиента не принят",
+	CodeImpersonationDenied:  "олицетворение запрещено",
+	CodePermissionDenied:     "недостаточно прав",
+	CodeSecondFactorRequired: "требуется вход со вторым фактором",
+	CodeProfileRequired:      "нужно дополнить профиль",
+	CodeRejectedByRule:       "вход запрещен правилом политики",
+	CodePasswordPolicy:       "пароль не соответствует политике паролей",
+	CodeUserNotFound:         "пользователь не найден",
+	CodeUserExists:           "учетная запись уже существует",
+	CodeUserRejected:         "изменение учетной записи отклонено",
+	CodeRegistrationClosed:   "самостоятельная регистрация закрыта",
+	CodeGroupNotFound:        "группа не найдена",
+	CodeGroupRejected:        "изменение группы отклонено",
+	CodePolicyRejected:       "изменение политики отклонено",
+	CodeInvalidRequest:       "некорректный запрос",
+	CodeMethodNotAllowed:     "метод не поддерживается",
+	CodeUnknownResource:      "неизвестный ресурс",
+	CodePreconditionFailed:   "ресурс изменен с момента чтения",
+	CodeRequestTooLarge:      "слишком большой запрос",
+	CodeInternal:             "внутренняя ошибка сервера",
+	CodeOverloaded:           "сервер перегружен",
+	CodeNotWritable:          "экземпляр не принимает изменений",
+	CodeNotConfigured:        "возможность не включена",
+	CodeReplicationFailed:    "ошибка репликации",
+}
+
+// authErrorCodes - коды ошибок по результату входа. Несуществующий пользователь
+// не отличается от неверного пароля, как и в поле result.
+var authErrorCodes = map[AuthResult]ErrorCode{
+	AuthInvalidCredentials:   CodeInvalidCredentials,
+	AuthUserNotFound:         CodeInvalidCredentials,
+	AuthUserBlocked:          CodeAccountLocked,
+	AuthSourceBlocked:        CodeLoginSuspended,
+	AuthAccountDisabled:      CodeAccountDisabled,
+	AuthOutsideSchedule:      CodeOutsideSchedule,
+	AuthPasswordExpired:      CodePasswordExpired,
+	AuthRejectedByHook:       CodeRejectedByHook,
+	AuthPendingApproval:      CodePendingApproval,
+	AuthTermsRequired:        CodeTermsRequired,
+	AuthSAMLNotLinked:        CodeSAMLNotLinked,
+	AuthKerberosNotMapped:    CodeKerberosNotMapped,
+	AuthCertificateNotMapped: CodeCertificateNotMapped,
+	AuthSecondFactorRequired: CodeSecondFactorRequired,
+	AuthProfileRequired:      CodeProfileRequired,
+	AuthRejectedByRule:       CodeRejectedByRule,
+}
+
+// APIError - тело ответа с ошибкой
+type APIError struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// APIErrorCode - строка каталога кодов ошибок
+type APIErrorCode struct {
+	Code        ErrorCode `json:"code"`
+	Description string    `json:"description"`
+}
+
+// PasswordPolicyDetails - подробности ошибки PWD001
+type PasswordPolicyDetails struct {
+	Violations []APIViolation `json:"violations"`
+	Rules      PasswordRules  `json:"password_rules"` // Действующие правила, как в /v1/policy
+}
+
+// APIViolation - нарушенное требование политики паролей: код правила, значения
+// (например {"need": 12, "got": 8}) и сообщение на русском
+type APIViolation struct {
+	Rule    string          `json:"rule"`
+	Params  PolicyViolation `json:"params"`
+	Message string          `json:"message"`
+}
+
+// newAPIViolations переводит нарушения политики в представление API
+func newAPIViolations(violations []PolicyViolation) []APIViolation {
+	result := make([]APIViolation, len(violations))
+	for i, violation := range violations {
+		result[i] = APIViolation{Rule: violation.Rule(), Params: violation, Message: violation.String()}
+	}
+	return result
+}
+
+// handleErrorCodes: GET /errors - каталог кодов ошибок API
+func (s *APIServer) handleErrorCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	codes := make([]APIErrorCode, 0, len(errorCatalogue))
+	for code, description := range errorCatalogue {
+		codes = append(codes, APIErrorCode{Code: code, Description: description})
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	writeAPIJSON(w, http.StatusOK, codes)
+}
+
+// writeAPIError отправляет ошибку в виде {"code": "...", "error": "..."}
+func writeAPIError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	writeAPIJSON(w, status, APIError{Code: code, Message: message})
+}
+
+// writeUserError отправляет ошибку проверки пароля или учетной записи: нарушение политики
+// паролей - с кодом PWD001 и списком нарушений, закрытая регистрация - с кодом USER004,
+// остальные ошибки - с кодом fallback
+func (s *APIServer) writeUserError(w http.ResponseWriter, err error, fallback ErrorCode) {
+	var policyErr *PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		writeAPIJSON(w, http.StatusUnprocessableEntity, APIError{
+			Code:    CodePasswordPolicy,
+			Message: err.Error(),
+			Details: PasswordPolicyDetails{Violations: newAPIViolations(policyErr.Violations), Rules: s.um.rules},
+		})
+		return
+	}
+	if errors.Is(err, errRegistrationClosed) {
+		writeAPIError(w, http.StatusForbidden, CodeRegistrationClosed, err.Error())
+		return
+	}
+	writeAPIError(w, http.StatusUnprocessableEntity, fallback, err.Error())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportHtpasswd записывает пользователей в формате htpasswd (bcrypt) для nginx/Apache.
+// Заблокированные, ожидающие одобрения и пользователи без bcrypt-хеша не экспортируются.
+func (um *UserManager) ExportHtpasswd(w io.Writer) (int, error) {
+	users := um.store.GetAllUsers()
+
+	usernames := make([]string, 0, len(users))
+	for username, user := range users {
+		if user.IsBlocked || user.PendingApproval || !isBcryptHash(user.HashedPassword) {
+			continue
+		}
+		// Двоеточие и перевод строки нарушили бы формат файла
+		if strings.ContainsAny(username, ":\n") {
+			continue
+		}
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		if _, err := fmt.Fprintf(w, "%s:%s\n", username, users[username].HashedPassword); err != nil {
+			return 0, fmt.Errorf("ошибка записи htpasswd: %v", err)
+		}
+	}
+
+	return len(usernames), nil
+}
+
+// WriteHtpasswdFile атомарно перезаписывает файл htpasswd
+func (um *UserManager) WriteHtpasswdFile(path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".htpasswd-*")
+	if err != nil {
+		return 0, fmt.Errorf("ошибка создания файла htpasswd: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	count, err := um.ExportHtpasswd(tmp)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Chmod(0640); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("ошибка записи htpasswd: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("ошибка записи htpasswd: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("ошибка записи htpasswd: %v", err)
+	}
+
+	return count, nil
+}
+
+// SetHtpasswdSync включает автоматическую перезапись файла htpasswd при изменениях
+// (пустой путь отключает синхронизацию)
+func (um *UserManager) SetHtpasswdSync(path string) error {
+	um.htpasswdPath = path
+	if path == "" {
+		return nil
+	}
+	_, err := um.WriteHtpasswdFile(path)
+	return err
+}
+
+// usersChanged вызывается после изменения учетных записей
+func (um *UserManager) usersChanged() {
+	if um.htpasswdPath == "" {
+		return
+	}
+	if _, err := um.WriteHtpasswdFile(um.htpasswdPath); err != nil {
+		fmt.Fprintf(os.Stderr, "htpasswd: %v\n", err)
+	}
+}
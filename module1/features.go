@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Feature - подсистема, которую можно отключить в конфигурации политики
+type Feature string
+
+const (
+	FeatureActivityReport  Feature = "activity_report"  // Отчет об активности
+	FeatureImportExport    Feature = "import_export"    // Импорт и экспорт пользователей
+	FeatureLoginSchedules  Feature = "login_schedules"  // Настройка расписаний входа
+	FeaturePersonalData    Feature = "personal_data"    // Выгрузка и удаление своих данных
+	FeaturePasswordRecheck Feature = "password_recheck" // Кампания проверки паролей и проверка при входе
+	FeatureHoneypots       Feature = "honeypots"        // Создание учетных записей-ловушек
+	FeatureDormancy        Feature = "dormancy"         // Обработка неактивных учетных записей
+	FeatureImpersonation   Feature = "impersonation"    // Токены администратора от имени пользователя
+)
+
+// knownFeatures - все отключаемые подсистемы
+var knownFeatures = map[Feature]bool{
+	FeatureActivityReport:  true,
+	FeatureImportExport:    true,
+	FeatureLoginSchedules:  true,
+	FeaturePersonalData:    true,
+	FeaturePasswordRecheck: true,
+	FeatureHoneypots:       true,
+	FeatureDormancy:        true,
+	FeatureImpersonation:   true,
+}
+
+// FeatureEnabled сообщает, включена ли подсистема (по умолчанию включены все)
+func (um *UserManager) FeatureEnabled(feature Feature) bool {
+	return !um.disabledFeatures[feature]
+}
+
+// parseFeatures проверяет раздел features конфигурации и возвращает отключенные подсистемы
+func parseFeatures(features map[string]bool) (map[Feature]bool, error) {
+	disabled := make(map[Feature]bool)
+	for name, enabled := range features {
+		feature := Feature(strings.TrimSpace(name))
+		if !knownFeatures[feature] {
+			return nil, fmt.Errorf("features: неизвестная подсистема %q", name)
+		}
+		if !enabled {
+			disabled[feature] = true
+		}
+	}
+	return disabled, nil
+}
+
+// describeFeatures перечисляет отключенные подсистемы для журнала аудита
+func describeFeatures(disabled map[Feature]bool) string {
+	if len(disabled) == 0 {
+		return "все подсистемы включены"
+	}
+	names := make([]string, 0, len(disabled))
+	for feature := range disabled {
+		names = append(names, string(feature))
+	}
+	sort.Strings(names)
+	return "отключены: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Динамические группы: участники группы с правилом (поле rule) не назначаются, а
+// вычисляются при каждом обращении по атрибутам учетной записи, например
+// department == "IT" && two_fa == true. Правило может ссылаться на атрибуты,
+// заданные администратором (команда attr, поле attributes файла состояния и API), и на
+// атрибуты, которые вычисляет система (builtinAttributes). Отсутствующий атрибут равен
+// пустой строке, поэтому правило с опечаткой в имени атрибута просто никого не выбирает.
+
+// builtinAttributes - атрибуты учетной записи, которые вычисляет система; задать их нельзя
+var builtinAttributes = map[string]string{
+	"username": "логин",
+	"email":    "адрес электронной почты",
+	"role":     "роль: user, admin или роль из конфигурации",
+	"admin":    "true, если учетная запись - администратор",
+	"disabled": "true, если учетная запись отключена администратором",
+	"blocked":  "true, если вход по паролю заблокирован",
+	"two_fa":   "true, если учетная запись может войти без пароля: связана с IdP (SAML) или имеет сертификат клиента",
+}
+
+// groupRule проверяет, подходит ли учетная запись с атрибутами attributes под правило группы
+type groupRule func(attributes map[string]string) bool
+
+// ruleOperand возвращает значение операнда правила
+type ruleOperand func(attributes map[string]string) string
+
+// validAttributeKey проверяет имя атрибута: строчные латинские буквы, цифры и '_'
+func validAttributeKey(key string) error {
+	if key == "" || len(key) > 64 || key[0] < 'a' || key[0] > 'z' {
+		return fmt.Errorf("некорректное имя атрибута %q: нужна строчная латинская буква в начале", key)
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("имя атрибута %q: допустимы строчные латинские буквы, цифры и '_'", key)
+		}
+	}
+	if _, builtin := builtinAttributes[key]; builtin {
+		return fmt.Errorf("атрибут %s вычисляется системой", key)
+	}
+	if _, login := loginAttributes[key]; login {
+		return fmt.Errorf("атрибут %s вычисляется при входе", key)
+	}
+	return nil
+}
+
+// validateAttributes проверяет атрибуты учетной записи
+func validateAttributes(attributes map[string]string) error {
+	for key, value := range attributes {
+		if err := validAttributeKey(key); err != nil {
+			return err
+		}
+		if value == "" || len(value) > 256 {
+			return fmt.Errorf("атрибут %s: значение должно содержать от 1 до 256 символов", key)
+		}
+	}
+	return nil
+}
+
+// describeAttributes перечисляет атрибуты для отчета и журнала аудита
+func describeAttributes(attributes map[string]string) string {
+	if len(attributes) == 0 {
+		return "нет"
+	}
+	return describeAnnotations(attributes)
+}
+
+// userAttributes возвращает атрибуты учетной записи для проверки правил групп
+func userAttributes(user *User) map[string]string {
+	attributes := make(map[string]string, len(user.Attributes)+len(builtinAttributes))
+	for key, value := range user.Attributes {
+		attributes[key] = value
+	}
+	attributes["username"] = user.Username
+	attributes["email"] = user.Email
+	attributes["role"] = userRole(user)
+	attributes["admin"] = strconv.FormatBool(user.IsAdmin)
+	attributes["disabled"] = strconv.FormatBool(user.DisabledByAdmin)
+	attributes["blocked"] = strconv.FormatBool(user.IsBlocked)
+	attributes["two_fa"] = strconv.FormatBool(user.SAMLNameID != "" || len(user.CertBindings) > 0)
+	return attributes
+}
+
+// ruleToken - лексема правила: оператор, имя атрибута или строка в кавычках
+type ruleToken struct {
+	text    string
+	literal bool // Строка в кавычках или число
+}
+
+// tokenizeRule разбивает правило на лексемы
+func tokenizeRule(rule string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, ruleToken{text: string(c)})
+			i++
+		case strings.HasPrefix(rule[i:], "==") || strings.HasPrefix(rule[i:], "!=") ||
+			strings.HasPrefix(rule[i:], "&&") || strings.HasPrefix(rule[i:], "||") ||
+			strings.HasPrefix(rule[i:], "<=") || strings.HasPrefix(rule[i:], ">="):
+			tokens = append(tokens, ruleToken{text: rule[i : i+2]})
+			i += 2
+		case c == '!' || c == '<' || c == '>':
+			tokens = append(tokens, ruleToken{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(rule) && rule[end] != '"' {
+				if rule[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rule) {
+				return nil, fmt.Errorf("незакрытая строка в позиции %d", i+1)
+			}
+			value, err := strconv.Unquote(rule[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("некорректная строка %s", rule[i:end+1])
+			}
+			tokens = append(tokens, ruleToken{text: value, literal: true})
+			i = end + 1
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(rule) && rule[end] >= '0' && rule[end] <= '9' {
+				end++
+			}
+			tokens = append(tokens, ruleToken{text: rule[i:end], literal: true})
+			i = end
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			end := i
+			for end < len(rule) && (rule[end] >= 'a' && rule[end] <= 'z' || rule[end] >= 'A' && rule[end] <= 'Z' ||
+				rule[end] >= '0' && rule[end] <= '9' || rule[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, ruleToken{text: rule[i:end]})
+			i = end
+		default:
+			return nil, fmt.Errorf("недопустимый символ %q в позиции %d", c, i+1)
+		}
+	}
+	return tokens, nil
+}
+
+// ruleParser разбирает правило методом рекурсивного спуска:
+//
+//	выражение = и { "||" и }
+//	и         = не { "&&" не }
+//	не        = "!" не | "(" выражение ")" | операнд [ сравнение операнд ]
+//	сравнение = "==" | "!=" | "<" | "<=" | ">" | ">="
+//	операнд   = атрибут | "строка" | число | true | false
+//
+// Операнд без сравнения истинен, если его значение - true. Операторы порядка сравнивают
+// целые числа: если хотя бы одно значение не число (например, атрибут не задан), сравнение ложно.
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+// parseGroupRule проверяет правило группы и возвращает функцию отбора участников
+func parseGroupRule(rule string) (groupRule, error) {
+	tokens, err := tokenizeRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("пустое правило")
+	}
+	p := &ruleParser{tokens: tokens}
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("лишнее %q после конца выражения", p.tokens[p.pos].text)
+	}
+	return match, nil
+}
+
+// peek сообщает, что следующая лексема - оператор op
+func (p *ruleParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].literal && p.tokens[p.pos].text == op
+}
+
+func (p *ruleParser) or() (groupRule, error) {
+	left, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var right groupRule
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(attributes map[string]string) bool { return l(attributes) || right(attributes) }
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) and() (groupRule, error) {
+	left, err := p.not()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var right groupRule
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(attributes map[string]string) bool { return l(attributes) && right(attributes) }
+		}
+	}
+	return left, err
+}
+
+func (p *ruleParser) not() (groupRule, error) {
+	switch {
+	case p.peek("!"):
+		p.pos++
+		inner, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(attributes map[string]string) bool { return !inner(attributes) }, nil
+	case p.peek("("):
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("нет закрывающей скобки")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	for _, candidate := range ruleComparisons {
+		if p.peek(candidate) {
+			op = candidate
+		}
+	}
+	if op == "" {
+		return func(attributes map[string]string) bool { return left(attributes) == "true" }, nil
+	}
+	p.pos++
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==", "!=":
+		equal := op == "=="
+		return func(attributes map[string]string) bool { return (left(attributes) == right(attributes)) == equal }, nil
+	}
+	return func(attributes map[string]string) bool {
+		a, errA := strconv.Atoi(left(attributes))
+		b, errB := strconv.Atoi(right(attributes))
+		if errA != nil || errB != nil {
+			return false
+		}
+		switch op {
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		case ">":
+			return a > b
+		}
+		return a >= b
+	}, nil
+}
+
+// ruleComparisons - операторы сравнения в правилах
+var ruleComparisons = []string{"==", "!=", "<", "<=", ">", ">="}
+
+func (p *ruleParser) operand() (ruleOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("выражение оборвано")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case token.literal || token.text == "true" || token.text == "false":
+		return func(map[string]string) string { return token.text }, nil
+	case slices.Contains([]string{"(", ")", "!", "&&", "||"}, token.text) || slices.Contains(ruleComparisons, token.text):
+		return nil, fmt.Errorf("ожидался атрибут или значение, а не %q", token.text)
+	}
+	return func(attributes map[string]string) string { return attributes[token.text] }, nil
+}
+
+// SetUserAttribute задает атрибут учетной записи; пустое значение удаляет атрибут
+func (um *UserManager) SetUserAttribute(username, key, value, reason string) error {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists || user.IsHoneypot {
+		return fmt.Errorf("пользователь не найден")
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	attributes := make(map[string]string, len(user.Attributes)+1)
+	for other, existing := range user.Attributes {
+		attributes[other] = existing
+	}
+	if value == "" {
+		if _, ok := attributes[key]; !ok {
+			return fmt.Errorf("у пользователя %s нет атрибута %s", user.Username, key)
+		}
+		delete(attributes, key)
+	} else {
+		attributes[key] = value
+	}
+	return um.setUserAttributes(user.Username, attributes, reason)
+}
+
+// setUserAttributes заменяет атрибуты учетной записи
+func (um *UserManager) setUserAttributes(username string, attributes map[string]string, reason string) error {
+	if err := validateAttributes(attributes); err != nil {
+		return err
+	}
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		user.Attributes = nil
+		for key, value := range attributes {
+			if user.Attributes == nil {
+				user.Attributes = make(map[string]string, len(attributes))
+			}
+			user.Attributes[key] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditAttributesChanged, username, strings.TrimSpace("атрибуты: "+describeAttributes(attributes)+" "+reason))
+	return nil
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Группы пользователей. Описания групп входят в политику (раздел groups файла политики,
+// команды group-create и POST /v1/groups), а членство хранится в учетных записях и
+// реплицируется вместе с ними. Аннотации группы - метки для внешних систем; аннотацию
+// require_2fa учитывает сама система: участникам группы вход только паролем запрещен,
+// войти можно через IdP (SAML), по билету Kerberos или по сертификату клиента.
+// Участники группы с правилом вычисляются по атрибутам учетных записей (grouprules.go).
+const annotationRequire2FA = "require_2fa"
+
+// Group - группа пользователей
+type Group struct {
+	Description string            `json:"description,omitempty"`
+	Rule        string            `json:"rule,omitempty"`        // Правило отбора участников (пусто - участники назначаются)
+	Annotations map[string]string `json:"annotations,omitempty"` // require_2fa и произвольные метки
+	match       groupRule         // Разобранное правило (nil - участники назначаются)
+}
+
+// clone возвращает копию группы с собственной картой аннотаций
+func (g Group) clone() Group {
+	if g.Annotations != nil {
+		annotations := make(map[string]string, len(g.Annotations))
+		for key, value := range g.Annotations {
+			annotations[key] = value
+		}
+		g.Annotations = annotations
+	}
+	return g
+}
+
+// validGroupName проверяет имя группы: латиница, цифры, '.', '_' и '-' (имя входит в адрес API)
+func validGroupName(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("имя группы должно содержать от 1 до 64 символов")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return fmt.Errorf("имя группы %q: допустимы латинские буквы, цифры, '.', '_' и '-'", name)
+		}
+	}
+	return nil
+}
+
+// validateAnnotation проверяет аннотацию группы
+func validateAnnotation(key, value string) error {
+	if key == "" || len(key) > 64 || strings.ContainsAny(key, " \t=") {
+		return fmt.Errorf("некорректный ключ аннотации %q", key)
+	}
+	if len(value) > 256 {
+		return fmt.Errorf("аннотация %s: значение длиннее 256 символов", key)
+	}
+	if key == annotationRequire2FA && value != "true" && value != "false" {
+		return fmt.Errorf("аннотация %s: допустимо true или false", key)
+	}
+	return nil
+}
+
+// parseGroups проверяет раздел groups конфигурации
+func parseGroups(groups map[string]Group) (map[string]Group, error) {
+	parsed := make(map[string]Group, len(groups))
+	for name, group := range groups {
+		if err := validGroupName(name); err != nil {
+			return nil, fmt.Errorf("groups: %v", err)
+		}
+		for key, value := range group.Annotations {
+			if err := validateAnnotation(key, value); err != nil {
+				return nil, fmt.Errorf("groups.%s: %v", name, err)
+			}
+		}
+		group.match = nil
+		if group.Rule = strings.TrimSpace(group.Rule); group.Rule != "" {
+			match, err := parseGroupRule(group.Rule)
+			if err != nil {
+				return nil, fmt.Errorf("groups.%s.rule: %v", name, err)
+			}
+			group.match = match
+		}
+		parsed[name] = group.clone()
+	}
+	return parsed, nil
+}
+
+// describeGroups описывает группы для журнала аудита
+func describeGroups(groups map[string]Group) string {
+	if len(groups) == 0 {
+		return "нет"
+	}
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		var details []string
+		if description := groups[name].Description; description != "" {
+			details = append(details, fmt.Sprintf("%q", description))
+		}
+		if rule := groups[name].Rule; rule != "" {
+			details = append(details, "правило "+rule)
+		}
+		if annotations := describeAnnotations(groups[name].Annotations); annotations != "" {
+			details = append(details, annotations)
+		}
+		if len(details) > 0 {
+			names[i] += " (" + strings.Join(details, ", ") + ")"
+		}
+	}
+	return strings.Join(names, "; ")
+}
+
+// describeAnnotations перечисляет аннотации по порядку ключей
+func describeAnnotations(annotations map[string]string) string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = key + "=" + annotations[key]
+	}
+	return strings.Join(keys, ", ")
+}
+
+// GroupNames возвращает имена групп по порядку
+func (um *UserManager) GroupNames() []string {
+	names := make([]string, 0, len(um.groups))
+	for name := range um.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Group возвращает группу по имени
+func (um *UserManager) Group(name string) (Group, bool) {
+	group, ok := um.groups[name]
+	return group.clone(), ok
+}
+
+// GroupMembers возвращает логины участников группы по порядку; участники группы
+// с правилом вычисляются в момент вызова
+func (um *UserManager) GroupMembers(name string) []string {
+	var members []string
+	for _, user := range um.store.GetAllUsers() {
+		if um.inGroup(user, name) {
+			members = append(members, user.Username)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// inGroup сообщает, что пользователь состоит в группе: назначен в нее или подходит
+// под ее правило
+func (um *UserManager) inGroup(user *User, name string) bool {
+	group, exists := um.groups[name]
+	switch {
+	case !exists || user.IsHoneypot:
+		return false
+	case group.match != nil:
+		return group.match(userAttributes(user))
+	}
+	return slices.Contains(user.Groups, name)
+}
+
+// UserGroups возвращает группы пользователя по порядку, включая группы с правилом
+func (um *UserManager) UserGroups(user *User) []string {
+	var groups []string
+	for _, name := range um.GroupNames() {
+		if um.inGroup(user, name) {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// assignedMembers возвращает логины пользователей, назначенных в группу
+func (um *UserManager) assignedMembers(name string) []string {
+	var members []string
+	for _, user := range um.store.GetAllUsers() {
+		if slices.Contains(user.Groups, name) {
+			members = append(members, user.Username)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// CreateGroup создает группу
+func (um *UserManager) CreateGroup(name, description, reason string) error {
+	name = strings.TrimSpace(name)
+	if err := validGroupName(name); err != nil {
+		return err
+	}
+	if _, exists := um.groups[name]; exists {
+		return fmt.Errorf("группа %s уже существует", name)
+	}
+	um.setGroup(name, Group{Description: strings.TrimSpace(description)})
+	um.recordAudit(AuditGroupCreated, "", strings.TrimSpace(fmt.Sprintf("группа %s %s", name, reason)))
+	return nil
+}
+
+// DeleteGroup удаляет группу и исключает из нее всех участников
+func (um *UserManager) DeleteGroup(name, reason string) error {
+	if _, exists := um.groups[name]; !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	members := um.GroupMembers(name)
+	details := strings.TrimSpace(fmt.Sprintf("группа %s, участников: %d %s", name, len(members), reason))
+	if um.dryRun {
+		um.planChange(AuditGroupDeleted, "", details)
+		return nil
+	}
+	for _, username := range um.assignedMembers(name) {
+		um.store.Update(username, func(user *User) error {
+			user.Groups = slices.DeleteFunc(user.Groups, func(group string) bool { return group == name })
+			return nil
+		})
+	}
+	groups := make(map[string]Group, len(um.groups))
+	for other, group := range um.groups {
+		if other != name {
+			groups[other] = group
+		}
+	}
+	um.groups = groups
+	um.recordAudit(AuditGroupDeleted, "", details)
+	return nil
+}
+
+// SetGroupDescription меняет описание группы
+func (um *UserManager) SetGroupDescription(name, description, reason string) error {
+	group, exists := um.Group(name)
+	if !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	group.Description = strings.TrimSpace(description)
+	um.setGroup(name, group)
+	um.recordAudit(AuditGroupChanged, "", strings.TrimSpace(fmt.Sprintf("группа %s: описание %q %s", name, group.Description, reason)))
+	return nil
+}
+
+// SetGroupAnnotation задает аннотацию группы; пустое значение удаляет аннотацию
+func (um *UserManager) SetGroupAnnotation(name, key, value, reason string) error {
+	group, exists := um.Group(name)
+	if !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	change := key + " удалена"
+	if value == "" {
+		if _, ok := group.Annotations[key]; !ok {
+			return fmt.Errorf("у группы %s нет аннотации %s", name, key)
+		}
+		delete(group.Annotations, key)
+	} else {
+		if err := validateAnnotation(key, value); err != nil {
+			return err
+		}
+		if group.Annotations == nil {
+			group.Annotations = make(map[string]string)
+		}
+		group.Annotations[key] = value
+		change = key + "=" + value
+	}
+	um.setGroup(name, group)
+	um.recordAudit(AuditGroupChanged, "", strings.TrimSpace(fmt.Sprintf("группа %s: %s %s", name, change, reason)))
+	return nil
+}
+
+// SetGroupRule задает правило отбора участников группы; пустое правило делает группу
+// обычной. Правило нельзя задать группе с назначенными участниками.
+func (um *UserManager) SetGroupRule(name, rule, reason string) error {
+	group, exists := um.Group(name)
+	if !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	group.Rule, group.match = strings.TrimSpace(rule), nil
+	change := "правило удалено"
+	if group.Rule != "" {
+		match, err := parseGroupRule(group.Rule)
+		if err != nil {
+			return fmt.Errorf("правило группы %s: %v", name, err)
+		}
+		if members := um.assignedMembers(name); len(members) > 0 {
+			return fmt.Errorf("в группу %s назначены пользователи (%s): исключите их перед заданием правила", name, strings.Join(members, ", "))
+		}
+		group.match = match
+		change = "правило " + group.Rule
+	}
+	um.setGroup(name, group)
+	um.recordAudit(AuditGroupChanged, "", strings.TrimSpace(fmt.Sprintf("группа %s: %s %s", name, change, reason)))
+	return nil
+}
+
+// setGroup заменяет группу. Карта групп не меняется на месте: ее читают запросы API,
+// выполняемые без блокировки сервера.
+func (um *UserManager) setGroup(name string, group Group) {
+	groups := make(map[string]Group, len(um.groups)+1)
+	for other, existing := range um.groups {
+		groups[other] = existing
+	}
+	groups[name] = group
+	um.groups = groups
+}
+
+// AddGroupMember включает пользователя в группу
+func (um *UserManager) AddGroupMember(username, name, reason string) error {
+	username = strings.TrimSpace(username)
+	group, exists := um.groups[name]
+	if !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	if group.Rule != "" {
+		return fmt.Errorf("участники группы %s определяются правилом %s", name, group.Rule)
+	}
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if slices.Contains(user.Groups, name) {
+			return fmt.Errorf("пользователь уже в группе %s", name)
+		}
+		user.Groups = append(user.Groups, name)
+		sort.Strings(user.Groups)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditGroupMemberAdded, username, strings.TrimSpace("группа "+name+" "+reason))
+	return nil
+}
+
+// RemoveGroupMember исключает пользователя из группы
+func (um *UserManager) RemoveGroupMember(username, name, reason string) error {
+	username = strings.TrimSpace(username)
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot || !slices.Contains(user.Groups, name) {
+			return fmt.Errorf("пользователь не состоит в группе %s", name)
+		}
+		user.Groups = slices.DeleteFunc(user.Groups, func(group string) bool { return group == name })
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditGroupMemberRemoved, username, strings.TrimSpace("группа "+name+" "+reason))
+	return nil
+}
+
+// setUserGroups приводит членство пользователя к списку groups (файл состояния)
+func (um *UserManager) setUserGroups(username string, groups []string, reason string) error {
+	user, exists := um.store.GetUser(username)
+	if !exists {
+		return fmt.Errorf("пользователь не найден")
+	}
+	for _, name := range user.Groups {
+		if !slices.Contains(groups, name) {
+			if err := um.RemoveGroupMember(username, name, reason); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range groups {
+		if !slices.Contains(user.Groups, name) {
+			if err := um.AddGroupMember(username, name, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// secondFactorGroup возвращает группу пользователя с аннотацией require_2fa=true
+// (пусто - вход паролем разрешен)
+func (um *UserManager) secondFactorGroup(user *User) string {
+	for _, name := range um.UserGroups(user) {
+		if um.groups[name].Annotations[annotationRequire2FA] == "true" {
+			return name
+		}
+	}
+	return ""
+}
+
+// WriteGroupMembers выводит участников группы так же, как список пользователей
+func (um *UserManager) WriteGroupMembers(w io.Writer, name string) error {
+	if _, exists := um.groups[name]; !exists {
+		return fmt.Errorf("группа %s не найдена", name)
+	}
+	members := um.GroupMembers(name)
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "Участников группы %s: %d\n\n", name, len(members))
+	now := time.Now()
+	for _, user := range um.store.GetUsers(members) {
+		um.writeUserLine(out, user, now)
+	}
+	return out.Flush()
+}
+
+// APIGroup - группа в API
+type APIGroup struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Rule        string            `json:"rule,omitempty"` // Правило отбора участников по атрибутам
+	Annotations map[string]string `json:"annotations"`
+	Members     []string          `json:"members"` // Только чтение: участники меняются через /members/<логин> или правилом
+}
+
+// newAPIGroup возвращает представление группы для API
+func (um *UserManager) newAPIGroup(name string) APIGroup {
+	group, _ := um.Group(name)
+	result := APIGroup{Name: name, Description: group.Description, Rule: group.Rule, Annotations: group.Annotations, Members: um.GroupMembers(name)}
+	if result.Annotations == nil {
+		result.Annotations = map[string]string{}
+	}
+	if result.Members == nil {
+		result.Members = []string{}
+	}
+	return result
+}
+
+// ETag группы зависит от описания, правила и аннотаций: изменение участников не вызывает
+// конфликта
+func (g APIGroup) ETag() string {
+	return computeETag(struct {
+		Name        string
+		Description string
+		Rule        string `json:",omitempty"`
+		Annotations map[string]string
+	}{g.Name, g.Description, g.Rule, g.Annotations})
+}
+
+// applyAnnotations приводит аннотации группы к requested
+func (um *UserManager) applyAnnotations(name string, requested map[string]string, reason string) error {
+	for key, value := range requested {
+		if err := validateAnnotation(key, value); err != nil {
+			return err
+		}
+	}
+	group, _ := um.Group(name)
+	for key := range group.Annotations {
+		if _, keep := requested[key]; !keep {
+			if err := um.SetGroupAnnotation(name, key, "", reason); err != nil {
+				return err
+			}
+		}
+	}
+	for key, value := range requested {
+		if group.Annotations[key] != value && value != "" {
+			if err := um.SetGroupAnnotation(name, key, value, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleGroups: GET /groups - список групп, POST - создание
+func (s *APIServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		groups := []APIGroup{}
+		for _, name := range s.um.GroupNames() {
+			groups = append(groups, s.um.newAPIGroup(name))
+		}
+		writeAPIJSON(w, http.StatusOK, groups)
+	case http.MethodPost:
+		var request APIGroup
+		if !readAPIJSON(w, r, &request) {
+			return
+		}
+		if _, exists := s.um.Group(request.Name); exists {
+			writeAPIError(w, http.StatusConflict, CodeGroupRejected, "группа уже существует")
+			return
+		}
+		for key, value := range request.Annotations {
+			if err := validateAnnotation(key, value); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		if request.Rule != "" {
+			if _, err := parseGroupRule(request.Rule); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, "правило: "+err.Error())
+				return
+			}
+		}
+		if err := s.um.CreateGroup(request.Name, request.Description, "запрос API"); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+			return
+		}
+		if err := s.um.applyAnnotations(request.Name, request.Annotations, "запрос API"); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+			return
+		}
+		if request.Rule != "" {
+			if err := s.um.SetGroupRule(request.Name, request.Rule, "запрос API"); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		result := s.um.newAPIGroup(request.Name)
+		w.Header().Set("ETag", result.ETag())
+		writeAPIJSON(w, http.StatusCreated, result)
+	default:
+		writeMethodNotAllowed(w, "GET, POST")
+	}
+}
+
+// handleGroup: GET - группа и ее участники, PUT - описание и аннотации, DELETE - удаление
+func (s *APIServer) handleGroup(w http.ResponseWriter, r *http.Request, name string) {
+	if _, exists := s.um.Group(name); !exists {
+		writeAPIError(w, http.StatusNotFound, CodeGroupNotFound, "группа не найдена")
+		return
+	}
+	current := s.um.newAPIGroup(name)
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("ETag", current.ETag())
+		writeAPIJSON(w, http.StatusOK, current)
+	case http.MethodPut:
+		var request APIGroup
+		if !readAPIJSON(w, r, &request) || !checkIfMatch(w, r, current.ETag()) {
+			return
+		}
+		if request.Name != name {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "имя в теле запроса не совпадает с адресом ресурса")
+			return
+		}
+		if strings.TrimSpace(request.Rule) != current.Rule {
+			if err := s.um.SetGroupRule(name, request.Rule, "запрос API"); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		if request.Description != current.Description {
+			if err := s.um.SetGroupDescription(name, request.Description, "запрос API"); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		if err := s.um.applyAnnotations(name, request.Annotations, "запрос API"); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodeGroupRejected, err.Error())
+			return
+		}
+		result := s.um.newAPIGroup(name)
+		w.Header().Set("ETag", result.ETag())
+		writeAPIJSON(w, http.StatusOK, result)
+	case http.MethodDelete:
+		if !checkIfMatch(w, r, current.ETag()) {
+			return
+		}
+		if err := s.um.DeleteGroup(name, "запрос API"); err != nil {
+			writeAPIError(w, http.StatusConflict, CodeGroupRejected, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+// handleGroupMember: PUT /groups/<группа>/members/<логин> - включение в группу,
+// DELETE - исключение. Повторное включение и исключение не считаются ошибкой.
+func (s *APIServer) handleGroupMember(w http.ResponseWriter, r *http.Request, name, username string) {
+	if _, exists := s.um.Group(name); !exists {
+		writeAPIError(w, http.StatusNotFound, CodeGroupNotFound, "группа не найдена")
+		return
+	}
+	user, exists := s.um.store.GetUser(username)
+	if !exists || user.IsHoneypot {
+		writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+		return
+	}
+	member := slices.Contains(user.Groups, name)
+
+	switch r.Method {
+	case http.MethodPut:
+		if !member {
+			if err := s.um.AddGroupMember(username, name, "запрос API"); err != nil {
+				writeAPIError(w, http.StatusConflict, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if member {
+			if err := s.um.RemoveGroupMember(username, name, "запрос API"); err != nil {
+				writeAPIError(w, http.StatusConflict, CodeGroupRejected, err.Error())
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, "PUT, DELETE")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthPath - адрес проверки работоспособности API. Доступен без токена
+// и не раскрывает ничего, кроме того, что API отвечает.
+const healthPath = "/healthz"
+
+// localAPI - клиент HTTP API этого же экземпляра для служебных команд (healthcheck, promote)
+type localAPI struct {
+	baseURL string
+	client  *http.Client
+}
+
+// newLocalAPI создает клиент API по адресу addr. Адрес без хоста или с адресом "все интерфейсы"
+// заменяется на loopback. С TLS сертификат выдан на внешнее имя сервиса, поэтому вместо проверки
+// имени сервер должен предъявить именно сертификат из certPath.
+func newLocalAPI(addr, certPath string, timeout time.Duration) (*localAPI, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный адрес API %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	api := &localAPI{
+		baseURL: "http://" + net.JoinHostPort(host, port),
+		client:  &http.Client{Timeout: timeout},
+	}
+	if certPath == "" {
+		return api, nil
+	}
+
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения сертификата: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("в %s нет сертификата PEM", certPath)
+	}
+	api.baseURL = "https://" + net.JoinHostPort(host, port)
+	api.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], block.Bytes) {
+				return fmt.Errorf("сервер предъявил не тот сертификат, что указан в %s", certPath)
+			}
+			return nil
+		},
+	}}
+	return api, nil
+}
+
+// CheckHealth проверяет, что HTTP API по адресу addr отвечает
+func CheckHealth(addr, certPath string, timeout time.Duration) error {
+	api, err := newLocalAPI(addr, certPath, timeout)
+	if err != nil {
+		return err
+	}
+	response, err := api.client.Get(api.baseURL + healthPath)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("API ответил %s", response.Status)
+	}
+	return nil
+}
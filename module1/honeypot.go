@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HoneypotHit - попытка входа в учетную запись-ловушку
+type HoneypotHit struct {
+	Username string
+	Time     time.Time
+}
+
+// CreateHoneypot создает учетную запись-ловушку. Войти в нее невозможно,
+// а любая попытка входа поднимает тревогу.
+func (um *UserManager) CreateHoneypot(username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("логин не может быть пустым")
+	}
+
+	if um.store.UserExists(username) {
+		return fmt.Errorf("пользователь с логином '%s' уже существует", username)
+	}
+
+	// Запись выглядит как обычная: дата создания есть, пароля нет
+	um.store.SaveUser(&User{
+		Username:   username,
+		CreatedAt:  time.Now(),
+		IsHoneypot: true,
+	})
+	um.recordAudit(AuditHoneypotCreated, username, "")
+
+	return nil
+}
+
+// SetHoneypotLockout включает блокировку входа с консоли на заданный срок после
+// попытки входа в ловушку (0 - только тревога)
+func (um *UserManager) SetHoneypotLockout(lockout time.Duration) {
+	um.honeypotLockout = lockout
+}
+
+// SetServing отключает блокировку после ловушки: она запрещает вход в консольном сеансе,
+// в котором сработала ловушка, а в режиме serve один запрос к ловушке запретил бы вход всем
+// клиентам API. Тревога и аудит срабатываний сохраняются.
+func (um *UserManager) SetServing() {
+	um.serving = true
+}
+
+// Honeypots возвращает логины учетных записей-ловушек
+func (um *UserManager) Honeypots() []string {
+	var usernames []string
+	for username, user := range um.store.GetAllUsers() {
+		if user.IsHoneypot {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// HoneypotHits возвращает попытки входа в ловушки с момента запуска
+func (um *UserManager) HoneypotHits() []HoneypotHit {
+	return um.honeypotHits
+}
+
+// triggerHoneypot поднимает тревогу о попытке входа в ловушку и при необходимости
+// блокирует вход с консоли (не в режиме serve). Для атакующего результат не отличается от
+// неверного пароля.
+func (um *UserManager) triggerHoneypot(user *User, now time.Time) AuthResult {
+	um.honeypotHits = append(um.honeypotHits, HoneypotHit{Username: user.Username, Time: now})
+
+	details := "попытка входа в учетную запись-ловушку"
+	if um.honeypotLockout > 0 && !um.serving {
+		um.lockedUntil = now.Add(um.honeypotLockout)
+		details += fmt.Sprintf(", вход заблокирован до %s", um.lockedUntil.Format("15:04:05"))
+	}
+	um.recordAudit(AuditHoneypotTriggered, user.Username, details)
+	fmt.Fprintf(os.Stderr, "ТРЕВОГА: %s '%s'\n", details, user.Username)
+
+	return AuthInvalidCredentials
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HookPoint - точка, в которой вызывается внешний обработчик
+type HookPoint string
+
+const (
+	HookPreRegister       HookPoint = "pre_register"        // Перед созданием учетной записи
+	HookPostLogin         HookPoint = "post_login"          // После проверки пароля, до завершения входа
+	HookPrePasswordChange HookPoint = "pre_password_change" // Перед сменой пароля
+	HookNewDevice         HookPoint = "new_device"          // После входа с нового устройства (уведомление, вход не запрещает)
+)
+
+// knownHooks - все точки вызова обработчиков
+var knownHooks = map[HookPoint]bool{
+	HookPreRegister:       true,
+	HookPostLogin:         true,
+	HookPrePasswordChange: true,
+	HookNewDevice:         true,
+}
+
+// hookTimeout - сколько ждать ответа обработчика
+const hookTimeout = 5 * time.Second
+
+// HookEvent - событие, передаваемое обработчику в stdin в формате JSON.
+// Пароли обработчику не передаются.
+type HookEvent struct {
+	Event    HookPoint    `json:"event"`
+	Username string       `json:"username"`
+	Time     time.Time    `json:"time"`
+	ClientIP string       `json:"client_ip,omitempty"` // Адрес клиента запроса API
+	Device   *KnownDevice `json:"device,omitempty"`    // Устройство входа (new_device)
+}
+
+// parseHooks проверяет раздел hooks конфигурации: известные точки и доступные программы
+func parseHooks(hooks map[string]string) (map[HookPoint]string, error) {
+	parsed := make(map[HookPoint]string, len(hooks))
+	for name, command := range hooks {
+		point := HookPoint(strings.TrimSpace(name))
+		if !knownHooks[point] {
+			return nil, fmt.Errorf("hooks: неизвестная точка вызова %q", name)
+		}
+		path, err := exec.LookPath(strings.TrimSpace(command))
+		if err != nil {
+			return nil, fmt.Errorf("hooks.%s: программа недоступна: %v", name, err)
+		}
+		parsed[point] = path
+	}
+	return parsed, nil
+}
+
+// describeHooks перечисляет заданные обработчики для журнала аудита
+func describeHooks(hooks map[HookPoint]string) string {
+	if len(hooks) == 0 {
+		return "не заданы"
+	}
+	descriptions := make([]string, 0, len(hooks))
+	for point, command := range hooks {
+		descriptions = append(descriptions, fmt.Sprintf("%s=%s", point, command))
+	}
+	sort.Strings(descriptions)
+	return strings.Join(descriptions, ", ")
+}
+
+// runHook вызывает внешний обработчик точки, если он задан. Ненулевой код возврата
+// запрещает операцию, первая строка вывода обработчика считается причиной. Ошибка
+// запуска или превышение времени ожидания тоже запрещают операцию.
+func (um *UserManager) runHook(point HookPoint, username string) error {
+	reason, failed := um.callHook(HookEvent{Event: point, Username: username, Time: time.Now()})
+	if !failed {
+		return nil
+	}
+	um.recordAudit(AuditHookRejected, username, fmt.Sprintf("%s: %s", point, reason))
+	return fmt.Errorf("операция запрещена внешней политикой: %s", reason)
+}
+
+// notifyHook передает событие обработчику-уведомлению, если он задан. Результат обработчика
+// на операцию не влияет, ошибка выводится в stderr.
+func (um *UserManager) notifyHook(event HookEvent) {
+	if reason, failed := um.callHook(event); failed {
+		fmt.Fprintf(os.Stderr, "обработчик %s: %s\n", event.Event, reason)
+	}
+}
+
+// callHook запускает обработчик точки события и возвращает причину, если он завершился
+// с ошибкой: первую строку вывода или ошибку запуска
+func (um *UserManager) callHook(event HookEvent) (reason string, failed bool) {
+	command, ok := um.hooks[event.Event]
+	if !ok {
+		return "", false
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err.Error(), true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &output
+	if err := cmd.Run(); err != nil {
+		reason, _, _ := strings.Cut(strings.TrimSpace(output.String()), "\n")
+		if reason == "" {
+			reason = err.Error()
+		}
+		return reason, true
+	}
+	return "", false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// IdleLock блокирует интерактивный сеанс после периода бездействия: экран очищается,
+// а следующий ввод принимается только после повторной аутентификации
+type IdleLock struct {
+	timeout time.Duration
+	unlock  func() bool // Повторная аутентификация (false - неудача, спросить снова)
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	locked    bool
+	unlocking bool // Идет повторная аутентификация: ее собственный ввод не блокируется
+}
+
+// sessionLock - блокировка текущего интерактивного сеанса (nil - отключена)
+var sessionLock *IdleLock
+
+// NewIdleLock создает блокировку сеанса, срабатывающую через timeout после последнего ввода
+func NewIdleLock(timeout time.Duration, unlock func() bool) *IdleLock {
+	l := &IdleLock{timeout: timeout, unlock: unlock}
+	l.timer = time.AfterFunc(timeout, l.lock)
+	return l
+}
+
+// Reader возвращает ввод, который отмечает активность пользователя и перед выдачей
+// данных заблокированному сеансу требует повторной аутентификации
+func (l *IdleLock) Reader(in io.Reader) io.Reader {
+	return &idleReader{in: in, lock: l}
+}
+
+// Touch отмечает активность пользователя и откладывает блокировку
+func (l *IdleLock) Touch() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.locked && !l.unlocking {
+		l.timer.Reset(l.timeout)
+	}
+}
+
+// lock очищает экран и переводит сеанс в заблокированное состояние
+func (l *IdleLock) lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked || l.unlocking {
+		return
+	}
+	l.locked = true
+	clearScreen()
+	fmt.Printf("Сеанс заблокирован после %v бездействия. Нажмите Enter для входа.\n", l.timeout)
+}
+
+// WaitUnlock повторяет аутентификацию, пока она не пройдет, и снимает блокировку.
+// Возвращает false, если сеанс не был заблокирован - тогда прочитанный ввод можно использовать.
+func (l *IdleLock) WaitUnlock() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	if !l.locked || l.unlocking {
+		l.mu.Unlock()
+		return false
+	}
+	l.unlocking = true
+	l.mu.Unlock()
+
+	for !l.unlock() {
+	}
+
+	l.mu.Lock()
+	l.locked = false
+	l.unlocking = false
+	l.timer.Reset(l.timeout)
+	l.mu.Unlock()
+	return true
+}
+
+// idleReader - ввод с учетом блокировки по бездействию
+type idleReader struct {
+	in   io.Reader
+	lock *IdleLock
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.in.Read(p)
+		// Строка, введенная на заблокированном экране, не передается в меню
+		if err == nil && r.lock.WaitUnlock() {
+			fmt.Print("Сеанс разблокирован, повторите ввод: ")
+			continue
+		}
+		r.lock.Touch()
+		return n, err
+	}
+}
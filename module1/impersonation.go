@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Олицетворение для поддержки и отладки: администратор получает короткий токен доступа
+// от имени пользователя. Токен помечен утверждением act (RFC 8693) с логином
+// администратора, выдача и отказы попадают в журнал аудита с полем actor. Подсистема
+// отключается в конфигурации политики (features.impersonation = false); после этого уже
+// выданные токены олицетворения не проходят проверку (POST /v1/introspect).
+const impersonationMaxTTL = 15 * time.Minute
+
+// APIImpersonationRequest - тело POST /impersonate
+type APIImpersonationRequest struct {
+	ActorToken string `json:"actor_token"` // Токен доступа администратора из POST /v1/auth
+	Username   string `json:"username"`    // От чьего имени выдать токен
+	Reason     string `json:"reason"`      // Обязательно: причина попадает в журнал аудита
+}
+
+// APIImpersonationResponse - токен доступа от имени пользователя
+type APIImpersonationResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"` // Срок действия токена, секунд
+	Username    string `json:"username"`
+	Actor       string `json:"actor"` // Администратор, получивший токен
+}
+
+// activeAdmin сообщает, что username - действующий администратор
+func (um *UserManager) activeAdmin(username string) bool {
+	user, exists := um.store.GetUser(username)
+	return exists && user.IsAdmin && !user.IsHoneypot && !user.DisabledByAdmin && !user.PendingApproval
+}
+
+// checkImpersonation проверяет, может ли администратор actor действовать от имени
+// username. Олицетворять можно только действующие учетные записи без роли администратора.
+func (um *UserManager) checkImpersonation(actor, username string) (*User, error) {
+	if !um.FeatureEnabled(FeatureImpersonation) {
+		return nil, fmt.Errorf("олицетворение отключено в конфигурации (features.impersonation)")
+	}
+	if !um.activeAdmin(actor) {
+		return nil, fmt.Errorf("%s не является действующим администратором", actor)
+	}
+	user, exists := um.store.GetUser(username)
+	switch {
+	case !exists || user.IsHoneypot:
+		return nil, fmt.Errorf("пользователь не найден")
+	case user.Username == actor:
+		return nil, fmt.Errorf("нельзя олицетворять собственную учетную запись")
+	case user.IsAdmin:
+		return nil, fmt.Errorf("администраторов олицетворять нельзя")
+	case user.DisabledByAdmin || user.PendingApproval:
+		return nil, fmt.Errorf("учетная запись отключена или ожидает одобрения")
+	}
+	return user, nil
+}
+
+// impersonationActive сообщает, что токен олицетворения еще действует: подсистема включена,
+// а выдавший его администратор не лишен роли
+func (um *UserManager) impersonationActive(actor *TokenActor) bool {
+	return actor == nil || (um.FeatureEnabled(FeatureImpersonation) && um.activeAdmin(actor.Subject))
+}
+
+// handleImpersonate: POST /impersonate - токен доступа от имени пользователя для
+// администратора, подтвердившего вход своим токеном доступа
+func (s *APIServer) handleImpersonate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	if s.tokens == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "выдача токенов доступа не настроена (-jwt-keys)")
+		return
+	}
+	var request APIImpersonationRequest
+	if !readAPIJSON(w, r, &request) {
+		return
+	}
+	username, reason := strings.TrimSpace(request.Username), strings.TrimSpace(request.Reason)
+	if username == "" || reason == "" {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "нужны поля username и reason")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Проверка features.impersonation и роли администратора и выдача токена выполняются
+	// под блокировкой API, поэтому отключение в PUT /policy или по SIGHUP не пропустит
+	// запрос, начатый до него
+	defer s.lockRequest(r)()
+	now := time.Now()
+	claims, err := s.tokens.Verify(request.ActorToken, now)
+	if err == nil && claims.Actor != nil {
+		err = fmt.Errorf("токен олицетворения не подтверждает администратора")
+	}
+	if admin, exists := s.um.store.GetUser(claims.Subject); err == nil && exists && tokenRevoked(admin, claims) {
+		err = fmt.Errorf("токен выдан до смены пароля или отзыва токенов")
+	}
+	if err != nil {
+		s.um.recordActorAudit(AuditImpersonationDenied, username, "", "токен администратора: "+err.Error())
+		writeAPIError(w, http.StatusUnauthorized, CodeImpersonationDenied, "токен администратора не принят: "+err.Error())
+		return
+	}
+	user, err := s.um.checkImpersonation(claims.Subject, username)
+	if err != nil {
+		s.um.recordActorAudit(AuditImpersonationDenied, username, claims.Subject, reason+": "+err.Error())
+		writeAPIError(w, http.StatusForbidden, CodeImpersonationDenied, err.Error())
+		return
+	}
+
+	token, issued, err := s.tokens.IssueImpersonation(user, claims.Subject, now)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	s.um.recordActorAudit(AuditImpersonationStarted, user.Username, claims.Subject,
+		fmt.Sprintf("%s; токен %s до %s", reason, issued.ID, time.Unix(issued.ExpiresAt, 0).Format("2006-01-02 15:04:05")))
+	writeAPIJSON(w, http.StatusOK, APIImpersonationResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(issued.ExpiresAt - issued.IssuedAt),
+		Username:    user.Username,
+		Actor:       claims.Subject,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ImportFormat - формат файла с учетными данными для импорта
+type ImportFormat string
+
+const (
+	FormatHtpasswd ImportFormat = "htpasswd" // Apache htpasswd: "логин:хеш"
+	FormatShadow   ImportFormat = "shadow"   // /etc/shadow: "логин:хеш:..."
+)
+
+// ImportSkip - строка файла, которая не была импортирована
+type ImportSkip struct {
+	Line     int
+	Username string
+	Reason   string
+}
+
+// ImportResult - итог импорта учетных данных
+type ImportResult struct {
+	Imported    []string     // Перенесены с bcrypt-хешем без изменений
+	Migrated    []string     // Перенесены с унаследованным хешем (перехеширование при входе)
+	ForcedReset []string     // Схема хеша не поддерживается - требуется смена пароля
+	Skipped     []ImportSkip // Не импортированы
+}
+
+// ImportCredentials переносит пользователей из файла htpasswd или shadow.
+// Поддерживаемые схемы (bcrypt, {SHA}, md5-crypt, apr1, SHA-crypt) переносятся как есть,
+// для остальных учетная запись создается заблокированной до смены пароля.
+func (um *UserManager) ImportCredentials(r io.Reader, format ImportFormat) (ImportResult, error) {
+	var result ImportResult
+
+	if format != FormatHtpasswd && format != FormatShadow {
+		return result, fmt.Errorf("неизвестный формат импорта: %s", format)
+	}
+
+	// В режиме пробного запуска записи не сохраняются, поэтому повторы внутри файла
+	// отслеживаются отдельно
+	seen := make(map[string]bool)
+	changed := false
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ":")
+		if len(fields) < 2 || (format == FormatShadow && len(fields) < 3) {
+			result.Skipped = append(result.Skipped, ImportSkip{line, "", "некорректная строка"})
+			continue
+		}
+		username := strings.TrimSpace(fields[0])
+		hash := fields[1]
+
+		if username == "" {
+			result.Skipped = append(result.Skipped, ImportSkip{line, "", "пустой логин"})
+			continue
+		}
+		if um.store.UserExists(username) || seen[username] {
+			result.Skipped = append(result.Skipped, ImportSkip{line, username, "пользователь уже существует"})
+			continue
+		}
+		// В shadow "*" и "!" означают отсутствие пароля или заблокированную запись
+		if hash == "" || strings.HasPrefix(hash, "*") || strings.HasPrefix(hash, "!") {
+			result.Skipped = append(result.Skipped, ImportSkip{line, username, "у учетной записи нет пароля"})
+			continue
+		}
+
+		user := &User{
+			Username:  username,
+			CreatedAt: time.Now(),
+		}
+
+		scheme := describeImportedHash(hash)
+		switch {
+		case isBcryptHash(hash):
+			user.HashedPassword = hash
+			result.Imported = append(result.Imported, username)
+		case strings.HasPrefix(hash, "{SHA}"):
+			digest, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+			if err != nil {
+				result.Skipped = append(result.Skipped, ImportSkip{line, username, "поврежденный хеш {SHA}"})
+				continue
+			}
+			user.LegacyHash = "sha1:" + hex.EncodeToString(digest)
+			result.Migrated = append(result.Migrated, username)
+		case validCryptHash(hash):
+			user.LegacyHash = "crypt:" + hash
+			result.Migrated = append(result.Migrated, username)
+		default:
+			user.IsBlocked = true
+			user.BlockedAt = time.Now()
+			result.ForcedReset = append(result.ForcedReset, username)
+		}
+
+		seen[username] = true
+		if um.dryRun {
+			um.planChange(AuditLegacyImport, username, fmt.Sprintf("%s, схема %s", format, scheme))
+			continue
+		}
+		um.store.SaveUser(user)
+		changed = true
+		um.recordAudit(AuditLegacyImport, username, fmt.Sprintf("%s, схема %s", format, scheme))
+	}
+
+	if changed {
+		um.usersChanged()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("ошибка чтения файла импорта: %v", err)
+	}
+
+	return result, nil
+}
+
+// isBcryptHash проверяет префикс bcrypt-хеша ($2a$, $2b$, $2y$)
+func isBcryptHash(hash string) bool {
+	return len(hash) == 60 &&
+		(strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$"))
+}
+
+// describeImportedHash возвращает название схемы хеша для журнала
+func describeImportedHash(hash string) string {
+	switch {
+	case isBcryptHash(hash):
+		return "bcrypt"
+	case strings.HasPrefix(hash, "{SHA}"):
+		return "sha1"
+	case validCryptHash(hash):
+		scheme, _ := findCryptScheme(hash)
+		return scheme.name
+	case strings.HasPrefix(hash, "$1$"), strings.HasPrefix(hash, "$apr1$"),
+		strings.HasPrefix(hash, "$5$"), strings.HasPrefix(hash, "$6$"):
+		return "crypt с некорректными параметрами (не поддерживается)"
+	case strings.HasPrefix(hash, "$y$"):
+		return "yescrypt (не поддерживается)"
+	default:
+		return "неизвестная (не поддерживается)"
+	}
+}
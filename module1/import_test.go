@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportCredentialsCrypt(t *testing.T) {
+	shadow := strings.Join([]string{
+		"alice:$6$saltstring$adDbXsJjcDlq2662QPgd.tkSOVmnG9Tt3oXl4HR60SusC3AGjirnDenVZp3DGwLwqy6iYKCzannhaX9DR72nN1:19000:0:99999:7:::",
+		"bob:$1$saltstri$qQY4WxjABChYG1ccLpfkz/:19000::::::",
+		"carol:$y$j9T$saltsaltsalt$WJhblAc/BKcuw1LHqgcyvlsjC8J4ha9Wl82.5/aQSy8:19000::::::",
+		"dave:$6$rounds=10$roundstoolow$abc:19000::::::",
+		"root:*:19000::::::",
+	}, "\n")
+
+	um := NewUserManager()
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	if err := um.SetHtpasswdSync(htpasswd); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(htpasswd)
+
+	result, err := um.ImportCredentials(strings.NewReader(shadow), FormatShadow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Migrated, []string{"alice", "bob"}) || !reflect.DeepEqual(result.ForcedReset, []string{"carol", "dave"}) {
+		t.Errorf("перенесены %v, требуют смены пароля %v", result.Migrated, result.ForcedReset)
+	}
+	// Хранилище изменилось, хотя ни один хеш не bcrypt: файл htpasswd перезаписан
+	if _, err := os.Stat(htpasswd); err != nil {
+		t.Errorf("htpasswd не обновлен после импорта: %v", err)
+	}
+
+	for username, password := range map[string]string{"alice": "password", "bob": "password"} {
+		if outcome, err := um.AuthenticateUser(username, password+"x"); err != nil || outcome.Result == AuthSuccess {
+			t.Errorf("%s: неверный пароль принят (%v)", username, err)
+		}
+		if outcome, err := um.AuthenticateUser(username, password); err != nil || outcome.Result != AuthSuccess {
+			t.Fatalf("%s: вход по перенесенному хешу: %v, %v", username, outcome.Result, err)
+		}
+		user, _ := um.store.GetUser(username)
+		if user.LegacyHash != "" || !isBcryptHash(user.HashedPassword) {
+			t.Errorf("%s: хеш не заменен bcrypt после входа", username)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Invite - приглашение на регистрацию, подписанное ключом Ed25519
+type Invite struct {
+	ID        string    `json:"id"`         // Случайный идентификатор, по нему приглашение погашается
+	Email     string    `json:"email"`      // Адрес, к которому привязывается учетная запись
+	ExpiresAt time.Time `json:"expires_at"` // Срок действия
+}
+
+// IssueInvite создает подписанное приглашение для адреса email со сроком действия ttl.
+// Токен имеет вид base64url(данные).base64url(подпись).
+func IssueInvite(key ed25519.PrivateKey, email string, ttl time.Duration, now time.Time) (string, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", fmt.Errorf("некорректный адрес электронной почты: %s", email)
+	}
+	if ttl <= 0 {
+		return "", fmt.Errorf("срок действия приглашения должен быть положительным")
+	}
+
+	id := make([]byte, 8)
+	if _, err := io.ReadFull(randomSource, id); err != nil {
+		return "", fmt.Errorf("ошибка генерации приглашения: %v", err)
+	}
+
+	payload, err := json.Marshal(Invite{
+		ID:        hex.EncodeToString(id),
+		Email:     address.Address,
+		ExpiresAt: now.Add(ttl).UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signature := ed25519.Sign(key, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseInvite проверяет подпись и срок действия приглашения
+func ParseInvite(token string, publicKey ed25519.PublicKey, now time.Time) (Invite, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(strings.TrimSpace(token), ".")
+	if !found {
+		return Invite{}, fmt.Errorf("некорректный формат приглашения")
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(encodedPayload)
+	signature, err2 := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err1 != nil || err2 != nil {
+		return Invite{}, fmt.Errorf("некорректный формат приглашения")
+	}
+
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return Invite{}, fmt.Errorf("подпись приглашения недействительна")
+	}
+
+	var invite Invite
+	if err := json.Unmarshal(payload, &invite); err != nil {
+		return Invite{}, fmt.Errorf("некорректный формат приглашения")
+	}
+	if !now.Before(invite.ExpiresAt) {
+		return Invite{}, fmt.Errorf("срок действия приглашения истек %s", invite.ExpiresAt.Local().Format("2006-01-02 15:04"))
+	}
+	return invite, nil
+}
+
+// SetInviteOnly включает регистрацию только по приглашениям, подписанным ключом key.
+// Раздел registration конфигурации политики может затем сменить режим.
+func (um *UserManager) SetInviteOnly(key ed25519.PrivateKey) {
+	um.inviteKey = key
+	um.registration = RegistrationInvite
+}
+
+// InviteOnly сообщает, возможна ли регистрация только по приглашению
+func (um *UserManager) InviteOnly() bool {
+	return um.RegistrationMode() == RegistrationInvite
+}
+
+// RegisterUserWithInvite регистрирует пользователя по приглашению: адрес из приглашения
+// привязывается к учетной записи, а само приглашение погашается
+func (um *UserManager) RegisterUserWithInvite(username, password, token string) error {
+	if !um.InviteOnly() {
+		return um.RegisterUser(username, password)
+	}
+
+	invite, err := ParseInvite(token, um.inviteKey.Public().(ed25519.PublicKey), time.Now())
+	if err != nil {
+		return err
+	}
+	if um.usedInvites[invite.ID] {
+		return fmt.Errorf("приглашение уже использовано")
+	}
+
+	if err := um.registerUser(username, password, invite.Email, ""); err != nil {
+		return err
+	}
+	if um.usedInvites == nil {
+		um.usedInvites = make(map[string]bool)
+	}
+	um.usedInvites[invite.ID] = true
+	um.recordAudit(AuditInviteRedeemed, strings.TrimSpace(username), fmt.Sprintf("приглашение %s для %s", invite.ID, invite.Email))
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Токены доступа JWT (RFC 7519) подписываются Ed25519 (alg EdDSA, RFC 8037). Идентификатор
+// ключа передается в заголовке (kid), поэтому после смены ключа токены, подписанные прежним
+// ключом, проверяются по набору JWKS до истечения срока.
+const (
+	jwtAlgorithm     = "EdDSA"
+	defaultJWTIssuer = "user-auth-system"
+	defaultJWTTTL    = 15 * time.Minute
+	jwtClockSkew     = time.Minute // Допустимое расхождение часов при проверке iat
+)
+
+// jwksPath - адрес набора открытых ключей проверки токенов. Доступен без токена API
+const jwksPath = "/.well-known/jwks.json"
+
+// AccessClaims - утверждения токена доступа
+type AccessClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+	// Администратор, получивший токен от имени пользователя (олицетворение, RFC 8693)
+	Actor *TokenActor `json:"act,omitempty"`
+}
+
+// TokenActor - сторона, действующая от имени владельца токена (утверждение act)
+type TokenActor struct {
+	Subject string `json:"sub"`
+}
+
+// jwtHeader - заголовок JWT
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// TokenIssuer выдает токены доступа после успешного входа через API
+type TokenIssuer struct {
+	keys   *KeySet
+	issuer string
+	ttl    time.Duration
+}
+
+// NewTokenIssuer создает выдачу токенов со сроком действия ttl
+func NewTokenIssuer(keys *KeySet, issuer string, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{keys: keys, issuer: issuer, ttl: ttl}
+}
+
+// Issue подписывает токен доступа пользователя текущим ключом
+func (t *TokenIssuer) Issue(user *User, now time.Time) (string, AccessClaims, error) {
+	return t.issue(user, nil, t.ttl, now)
+}
+
+// IssueImpersonation подписывает токен пользователя для администратора actor. Токен
+// помечен утверждением act и действует не дольше impersonationMaxTTL.
+func (t *TokenIssuer) IssueImpersonation(user *User, actor string, now time.Time) (string, AccessClaims, error) {
+	return t.issue(user, &TokenActor{Subject: actor}, min(t.ttl, impersonationMaxTTL), now)
+}
+
+// issue подписывает токен доступа со сроком действия ttl
+func (t *TokenIssuer) issue(user *User, actor *TokenActor, ttl time.Duration, now time.Time) (string, AccessClaims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", AccessClaims{}, fmt.Errorf("ошибка генерации идентификатора токена: %v", err)
+	}
+	claims := AccessClaims{
+		Issuer:    t.issuer,
+		Subject:   user.Username,
+		Role:      userRole(user),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		ID:        hex.EncodeToString(id),
+		Actor:     actor,
+	}
+
+	keyID := t.keys.Current()
+	header, err := json.Marshal(jwtHeader{Algorithm: jwtAlgorithm, Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", AccessClaims{}, err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", AccessClaims{}, err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(ed25519.NewKeyFromSeed(t.keys.key(keyID)), []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), claims, nil
+}
+
+// Verify проверяет подпись, издателя и срок действия токена доступа
+func (t *TokenIssuer) Verify(token string, now time.Time) (AccessClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return AccessClaims{}, fmt.Errorf("токен не в формате JWT")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return AccessClaims{}, fmt.Errorf("заголовок токена: %v", err)
+	}
+	if header.Algorithm != jwtAlgorithm {
+		return AccessClaims{}, fmt.Errorf("алгоритм подписи %q не поддерживается", header.Algorithm)
+	}
+	seed := t.keys.key(header.KeyID)
+	if seed == nil {
+		return AccessClaims{}, fmt.Errorf("неизвестный ключ подписи %q", header.KeyID)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), []byte(parts[0]+"."+parts[1]), signature) {
+		return AccessClaims{}, fmt.Errorf("неверная подпись токена")
+	}
+
+	var claims AccessClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return AccessClaims{}, fmt.Errorf("утверждения токена: %v", err)
+	}
+	switch {
+	case claims.Issuer != t.issuer:
+		return AccessClaims{}, fmt.Errorf("токен выдан другим издателем: %q", claims.Issuer)
+	case now.Unix() >= claims.ExpiresAt:
+		return AccessClaims{}, fmt.Errorf("срок действия токена истек")
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(jwtClockSkew)):
+		return AccessClaims{}, fmt.Errorf("токен выдан в будущем")
+	}
+	return claims, nil
+}
+
+// decodeJWTPart декодирует часть JWT (base64url без дополнения) в JSON
+func decodeJWTPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// JWK - открытый ключ проверки токенов (RFC 7517, тип OKP по RFC 8037)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+}
+
+// JWKSet - набор открытых ключей для проверяющих токены
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS возвращает открытые ключи всех ключей набора, текущий - первым
+func PublicJWKS(keys *KeySet) JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	ids := keys.IDs()
+	for i := len(ids) - 1; i >= 0; i-- {
+		publicKey := ed25519.NewKeyFromSeed(keys.key(ids[i])).Public().(ed25519.PublicKey)
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			KeyID:     ids[i],
+			Use:       "sig",
+			Algorithm: jwtAlgorithm,
+		})
+	}
+	return set
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Вход по билету Kerberos для компьютеров в домене: HTTP Negotiate (SPNEGO, RFC 4559) на адресе
+// /v1/auth/negotiate. Билет проверяется ключом сервиса из keytab, участник user@REALM из
+// разрешенной области сопоставляется с локальной учетной записью user, после чего действуют
+// отключение, блокировка, расписание, обработчики и аудит, как при входе по паролю. Клиенты
+// без билета могут войти паролем (Basic) на том же адресе.
+const negotiatePath = apiPrefix + "/auth/negotiate"
+
+var (
+	oidSPNEGO       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	oidKerberos5    = asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
+	oidKerberos5MS  = asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2} // Идентификатор Kerberos в клиентах Windows
+	gssTokenAPReq   = []byte{0x01, 0x00}
+	gssTokenAPRep   = []byte{0x02, 0x00}
+	spnegoCompleted = asn1.Enumerated(0) // accept-completed
+)
+
+// spnegoNegTokenInit - первое сообщение SPNEGO от клиента
+type spnegoNegTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"optional,explicit,tag:1"`
+	MechToken   []byte                  `asn1:"optional,explicit,tag:2"`
+	MechListMIC []byte                  `asn1:"optional,explicit,tag:3"`
+}
+
+// spnegoNegTokenResp - ответ SPNEGO сервера
+type spnegoNegTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,tag:2"`
+}
+
+// KerberosAcceptor принимает билеты Kerberos для участников-сервисов из keytab
+type KerberosAcceptor struct {
+	keytab           *Keytab
+	realms           []string // Области, пользователи которых сопоставляются с учетными записями
+	passwordFallback bool     // Вход паролем (Basic) для клиентов без билета
+	replay           krbReplayCache
+}
+
+// NewKerberosAcceptor создает прием билетов. Пустой список областей - области ключей keytab.
+func NewKerberosAcceptor(keytab *Keytab, realms []string, passwordFallback bool) *KerberosAcceptor {
+	if len(realms) == 0 {
+		realms = keytab.Realms()
+	}
+	return &KerberosAcceptor{keytab: keytab, realms: realms, passwordFallback: passwordFallback}
+}
+
+// Accept проверяет токен из заголовка Authorization: Negotiate - SPNEGO или GSS-API Kerberos.
+// Возвращает участника и токен ответа для WWW-Authenticate (взаимная аутентификация).
+func (a *KerberosAcceptor) Accept(token []byte, now time.Time) (krbPrincipal, []byte, error) {
+	mech, inner, err := parseGSSToken(token)
+	if err != nil {
+		return krbPrincipal{}, nil, err
+	}
+	spnego := mech.Equal(oidSPNEGO)
+	if spnego {
+		if mech, inner, err = parseNegTokenInit(inner); err != nil {
+			return krbPrincipal{}, nil, err
+		}
+	}
+	if !mech.Equal(oidKerberos5) && !mech.Equal(oidKerberos5MS) {
+		return krbPrincipal{}, nil, fmt.Errorf("механизм %v не поддерживается: нужен Kerberos", mech)
+	}
+	if len(inner) < 2 || inner[0] != gssTokenAPReq[0] || inner[1] != gssTokenAPReq[1] {
+		return krbPrincipal{}, nil, fmt.Errorf("ожидается AP-REQ")
+	}
+
+	client, reply, err := verifyAPReq(inner[2:], a.keytab, &a.replay, now)
+	if err != nil {
+		return krbPrincipal{}, nil, err
+	}
+	response, err := wrapGSSToken(oidKerberos5, append(append([]byte(nil), gssTokenAPRep...), reply...))
+	if err != nil {
+		return krbPrincipal{}, nil, err
+	}
+	if spnego {
+		response, err = asn1.MarshalWithParams(spnegoNegTokenResp{NegState: spnegoCompleted, SupportedMech: mech, ResponseToken: response}, "explicit,tag:1")
+		if err != nil {
+			return krbPrincipal{}, nil, err
+		}
+	}
+	return client, response, nil
+}
+
+// Username возвращает локальный логин участника: user@REALM из разрешенной области - user.
+// Участники-сервисы (host/..., HTTP/...) и чужие области не сопоставляются.
+func (a *KerberosAcceptor) Username(principal krbPrincipal) (string, bool) {
+	if len(principal.Name) != 1 || !containsString(a.realms, principal.Realm) {
+		return "", false
+	}
+	return principal.Name[0], true
+}
+
+// parseGSSToken разбирает начальный токен GSS-API: [APPLICATION 0] { механизм, токен механизма }
+func parseGSSToken(token []byte) (asn1.ObjectIdentifier, []byte, error) {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(token, &outer); err != nil || outer.Class != asn1.ClassApplication || outer.Tag != 0 {
+		return nil, nil, fmt.Errorf("некорректный токен GSS-API")
+	}
+	var mech asn1.ObjectIdentifier
+	inner, err := asn1.Unmarshal(outer.Bytes, &mech)
+	if err != nil {
+		return nil, nil, fmt.Errorf("некорректный токен GSS-API: %v", err)
+	}
+	return mech, inner, nil
+}
+
+// wrapGSSToken собирает токен GSS-API механизма mech
+func wrapGSSToken(mech asn1.ObjectIdentifier, token []byte) ([]byte, error) {
+	oid, err := asn1.Marshal(mech)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassApplication, Tag: 0, IsCompound: true, Bytes: append(oid, token...)})
+}
+
+// parseNegTokenInit извлекает из NegTokenInit токен Kerberos. Токен сразу в первом
+// сообщении клиент отправляет для первого механизма своего списка; если это не Kerberos
+// (например, NTLM вне домена), вход по билету невозможен.
+func parseNegTokenInit(data []byte) (asn1.ObjectIdentifier, []byte, error) {
+	var init spnegoNegTokenInit
+	if _, err := asn1.UnmarshalWithParams(data, &init, "explicit,tag:0"); err != nil {
+		return nil, nil, fmt.Errorf("некорректный NegTokenInit: %v", err)
+	}
+	if len(init.MechTypes) == 0 || len(init.MechToken) == 0 {
+		return nil, nil, fmt.Errorf("клиент не передал токен механизма")
+	}
+	mech, inner, err := parseGSSToken(init.MechToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !mech.Equal(init.MechTypes[0]) {
+		return nil, nil, fmt.Errorf("токен не соответствует первому механизму клиента %v", init.MechTypes[0])
+	}
+	return mech, inner, nil
+}
+
+// AuthenticateKerberos выполняет вход проверенного участника Kerberos и учитывает попытку
+// в статистике входа. Возвращает результат и логин локальной учетной записи.
+func (um *UserManager) AuthenticateKerberos(principal krbPrincipal, acceptor *KerberosAcceptor) (AuthOutcome, string, error) {
+	started := time.Now()
+	outcome, username, err := um.checkKerberosPrincipal(principal, acceptor)
+	um.loginStats.record(loginResultLabel(outcome, err), time.Since(started), time.Now())
+	return outcome, username, err
+}
+
+// checkKerberosPrincipal сопоставляет участника с учетной записью и проверяет, разрешен ли вход
+func (um *UserManager) checkKerberosPrincipal(principal krbPrincipal, acceptor *KerberosAcceptor) (AuthOutcome, string, error) {
+	now := time.Now()
+	if outcome, blocked := um.sourceLockout(now); blocked {
+		return outcome, "", nil
+	}
+
+	username, mapped := acceptor.Username(principal)
+	user, exists := um.store.GetUser(username)
+	if !mapped || !exists {
+		um.recordAudit(AuditKerberosRejected, username, fmt.Sprintf("участник %s не сопоставлен с учетной записью", principal))
+		return AuthOutcome{Result: AuthKerberosNotMapped}, "", nil
+	}
+
+	if outcome, allowed := um.checkLoginAllowed(user, now); !allowed {
+		return outcome, username, nil
+	}
+	outcome, _, err := um.admitLogin(user, "", AuditLoginSuccess, "Kerberos: "+principal.String(), now)
+	return outcome, username, err
+}
+
+// handleNegotiate: GET или POST /auth/negotiate - вход по билету Kerberos (Authorization:
+// Negotiate) или, если разрешено, паролем (Authorization: Basic). Без учетных данных -
+// 401 с предложением способов входа. Результат - как у POST /auth.
+func (s *APIServer) handleNegotiate(w http.ResponseWriter, r *http.Request) {
+	if s.kerberos == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "вход через Kerberos не настроен (-kerberos-keytab)")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "GET, POST")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	header := r.Header.Get("Authorization")
+	scheme, credentials, _ := strings.Cut(header, " ")
+	switch {
+	case strings.EqualFold(scheme, "Negotiate") && credentials != "":
+		token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+		if err != nil {
+			s.challengeNegotiate(w, "токен Negotiate не в base64")
+			return
+		}
+		principal, response, err := s.kerberos.Accept(token, time.Now())
+		if err != nil {
+			s.um.recordAuditFrom(auditSourceFrom(r.Context()), AuditKerberosRejected, "", "", err.Error())
+			s.challengeNegotiate(w, "билет Kerberos не принят: "+err.Error())
+			return
+		}
+		defer s.lockRequest(r)()
+		s.writableLogin(w, func(w http.ResponseWriter) {
+			outcome, username, err := s.um.AuthenticateKerberos(principal, s.kerberos)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+				return
+			}
+			if outcome.Result == AuthSuccess {
+				w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(response))
+			}
+			s.writeAuthOutcome(w, outcome, username)
+		})
+	case strings.EqualFold(scheme, "Basic") && s.kerberos.passwordFallback:
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			s.challengeNegotiate(w, "некорректные учетные данные Basic")
+			return
+		}
+		release, admitted := s.admit(w, r, LaneAuth)
+		if !admitted {
+			return
+		}
+		defer release()
+		defer s.lockRequest(r)()
+		s.writableLogin(w, func(w http.ResponseWriter) {
+			outcome, err := s.um.authenticate(username, password, "")
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+				return
+			}
+			if outcome.Result != AuthSuccess {
+				w.Header().Set("WWW-Authenticate", `Basic realm="uas", charset="UTF-8"`)
+			}
+			s.writeAuthOutcome(w, outcome, strings.TrimSpace(username))
+		})
+	default:
+		s.challengeNegotiate(w, "требуется вход по билету Kerberos")
+	}
+}
+
+// challengeNegotiate отвечает 401 и предлагает войти по билету или паролем
+func (s *APIServer) challengeNegotiate(w http.ResponseWriter, message string) {
+	w.Header().Add("WWW-Authenticate", "Negotiate")
+	if s.kerberos.passwordFallback {
+		w.Header().Add("WWW-Authenticate", `Basic realm="uas", charset="UTF-8"`)
+		message += "; без билета можно войти паролем (Basic)"
+	}
+	writeAPIError(w, http.StatusUnauthorized, CodeKerberosRejected, message)
+}
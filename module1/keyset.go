@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// KeyKind - назначение набора ключей и допустимый размер ключа
+type KeyKind struct {
+	Name    string // Название в родительном падеже: "файл ключей <Name>"
+	Size    int    // Размер создаваемых ключей, байт
+	MinSize int    // Наименьший допустимый размер ключа, байт
+	MaxSize int    // Наибольший допустимый размер ключа (0 - не ограничен)
+}
+
+// Назначения наборов ключей
+var (
+	KeyPepper  = KeyKind{Name: "перца", Size: 32, MinSize: 16}
+	KeyJWT     = KeyKind{Name: "подписи JWT", Size: ed25519.SeedSize, MinSize: ed25519.SeedSize, MaxSize: ed25519.SeedSize}
+	KeyStorage = KeyKind{Name: "шифрования хранилища", Size: 32, MinSize: 32, MaxSize: 32} // AES-256
+)
+
+// keyKinds - наборы ключей, которые можно сменить командой keys rotate
+var keyKinds = map[string]KeyKind{
+	"pepper":  KeyPepper,
+	"jwt":     KeyJWT,
+	"storage": KeyStorage,
+}
+
+// KeySet - набор ключей с идентификаторами. Файл набора состоит из строк
+// "<идентификатор> <ключ в hex>"; новые подписи, хеши и шифротексты создаются последним
+// ключом файла, а прежние ключи остаются для проверки и расшифровки созданного ими.
+type KeySet struct {
+	kind    KeyKind
+	keys    map[string][]byte
+	order   []string
+	current string
+}
+
+// ParseKeySet разбирает файл набора ключей; пустые строки и строки с # пропускаются
+func ParseKeySet(data []byte, kind KeyKind) (*KeySet, error) {
+	keys := &KeySet{kind: kind, keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("строка %d: ожидается \"<идентификатор> <ключ в hex>\"", line)
+		}
+		id := fields[0]
+		if !validKeyID(id) {
+			return nil, fmt.Errorf("строка %d: идентификатор ключа может содержать только буквы, цифры, '.', '_' и '-'", line)
+		}
+		if _, exists := keys.keys[id]; exists {
+			return nil, fmt.Errorf("строка %d: ключ %s задан повторно", line, id)
+		}
+		key, err := hex.DecodeString(fields[1])
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("строка %d: ключ должен быть записан в hex", line)
+		case kind.MaxSize == kind.MinSize && len(key) != kind.MinSize:
+			return nil, fmt.Errorf("строка %d: ключ должен быть длиной %d байт", line, kind.MinSize)
+		case len(key) < kind.MinSize:
+			return nil, fmt.Errorf("строка %d: ключ должен быть не короче %d байт", line, kind.MinSize)
+		case kind.MaxSize > 0 && len(key) > kind.MaxSize:
+			return nil, fmt.Errorf("строка %d: ключ должен быть не длиннее %d байт", line, kind.MaxSize)
+		}
+		keys.keys[id] = key
+		keys.order = append(keys.order, id)
+		keys.current = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if keys.current == "" {
+		return nil, fmt.Errorf("в файле нет ключей")
+	}
+	return keys, nil
+}
+
+// LoadOrCreateKeySet читает набор ключей из файла или создает файл с одним ключом.
+// Второе значение сообщает, что файл был создан.
+func LoadOrCreateKeySet(path string, kind KeyKind) (*KeySet, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		keys, err := ParseKeySet(data, kind)
+		if err != nil {
+			return nil, false, fmt.Errorf("файл ключей %s %s: %v", kind.Name, path, err)
+		}
+		return keys, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("ошибка чтения ключей %s: %v", kind.Name, err)
+	}
+
+	if _, err := AddKey(path, kind); err != nil {
+		return nil, false, err
+	}
+	keys, _, err := LoadOrCreateKeySet(path, kind)
+	return keys, true, err
+}
+
+// AddKey дописывает в файл новый ключ, который станет текущим после перезапуска,
+// и возвращает его идентификатор. Прежние ключи остаются в файле.
+func AddKey(path string, kind KeyKind) (string, error) {
+	existing := &KeySet{keys: make(map[string][]byte)}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if existing, err = ParseKeySet(data, kind); err != nil {
+			return "", fmt.Errorf("файл ключей %s %s: %v", kind.Name, path, err)
+		}
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("ошибка чтения ключей %s: %v", kind.Name, err)
+	}
+
+	id := strconv.Itoa(len(existing.order) + 1)
+	for n := len(existing.order) + 2; existing.keys[id] != nil; n++ {
+		id = strconv.Itoa(n)
+	}
+	key := make([]byte, kind.Size)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("ошибка генерации ключа: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return "", fmt.Errorf("ошибка сохранения ключа: %v", err)
+	}
+	prefix := ""
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		prefix = "\n"
+	}
+	if _, err := fmt.Fprintf(file, "%s%s %s\n", prefix, id, hex.EncodeToString(key)); err != nil {
+		file.Close()
+		return "", fmt.Errorf("ошибка сохранения ключа: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("ошибка сохранения ключа: %v", err)
+	}
+	return id, nil
+}
+
+// Current возвращает идентификатор текущего ключа
+func (k *KeySet) Current() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// IDs возвращает идентификаторы ключей в порядке файла
+func (k *KeySet) IDs() []string {
+	if k == nil {
+		return nil
+	}
+	return append([]string(nil), k.order...)
+}
+
+// key возвращает ключ по идентификатору (nil - ключа нет)
+func (k *KeySet) key(id string) []byte {
+	if k == nil {
+		return nil
+	}
+	return k.keys[id]
+}
+
+// validKeyID проверяет, что идентификатор не нарушит формат хеша, заголовка JWT или шифротекста
+func validKeyID(id string) bool {
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return id != ""
+}
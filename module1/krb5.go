@@ -0,0 +1,606 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Проверка билетов Kerberos 5 (RFC 4120) на стороне сервиса: разбор keytab, расшифровка билета
+// и аутентификатора из AP-REQ и ответ AP-REP для взаимной аутентификации. Поддерживаются типы
+// шифрования aes128-cts-hmac-sha1-96 и aes256-cts-hmac-sha1-96 (RFC 3962) - стандарт Active
+// Directory и MIT Kerberos; устаревший RC4 не принимается.
+const (
+	krbETypeAES128 = 17
+	krbETypeAES256 = 18
+
+	krbUsageTicket        = 2  // Зашифрованная часть билета
+	krbUsageAuthenticator = 11 // Аутентификатор AP-REQ
+	krbUsageAPRepPart     = 12 // Зашифрованная часть AP-REP
+
+	krbMsgAPReq    = 14
+	krbMsgAPRep    = 15
+	krbClockSkew   = 5 * time.Minute // Допустимое расхождение часов с клиентом (RFC 4120)
+	krbHMACSize    = 12
+	krbFlagInvalid = 7 // Флаг билета INVALID: билет нужно подтвердить в KDC
+)
+
+// krbPrincipalName - имя участника Kerberos
+type krbPrincipalName struct {
+	NameType   int32    `asn1:"explicit,tag:0"`
+	NameString []string `asn1:"generalstring,explicit,tag:1"`
+}
+
+// krbEncryptedData - зашифрованные данные с типом шифрования и версией ключа
+type krbEncryptedData struct {
+	EType  int32  `asn1:"explicit,tag:0"`
+	KVNO   int    `asn1:"optional,explicit,tag:1"`
+	Cipher []byte `asn1:"explicit,tag:2"`
+}
+
+// krbEncryptionKey - ключ шифрования
+type krbEncryptionKey struct {
+	KeyType  int32  `asn1:"explicit,tag:0"`
+	KeyValue []byte `asn1:"explicit,tag:1"`
+}
+
+// krbAPReq - запрос аутентификации клиента (APPLICATION 14)
+type krbAPReq struct {
+	PVNO          int              `asn1:"explicit,tag:0"`
+	MsgType       int              `asn1:"explicit,tag:1"`
+	APOptions     asn1.BitString   `asn1:"explicit,tag:2"`
+	Ticket        asn1.RawValue    `asn1:"explicit,tag:3"` // Элемент [3], билет - в Bytes
+	Authenticator krbEncryptedData `asn1:"explicit,tag:4"`
+}
+
+// krbTicket - билет сервиса (APPLICATION 1)
+type krbTicket struct {
+	TktVNO  int              `asn1:"explicit,tag:0"`
+	Realm   string           `asn1:"generalstring,explicit,tag:1"`
+	SName   krbPrincipalName `asn1:"explicit,tag:2"`
+	EncPart krbEncryptedData `asn1:"explicit,tag:3"`
+}
+
+// krbEncTicketPart - расшифрованная часть билета (APPLICATION 3)
+type krbEncTicketPart struct {
+	Flags             asn1.BitString   `asn1:"explicit,tag:0"`
+	Key               krbEncryptionKey `asn1:"explicit,tag:1"`
+	CRealm            string           `asn1:"generalstring,explicit,tag:2"`
+	CName             krbPrincipalName `asn1:"explicit,tag:3"`
+	Transited         asn1.RawValue    `asn1:"explicit,tag:4"`
+	AuthTime          time.Time        `asn1:"generalized,explicit,tag:5"`
+	StartTime         time.Time        `asn1:"generalized,optional,explicit,tag:6"`
+	EndTime           time.Time        `asn1:"generalized,explicit,tag:7"`
+	RenewTill         time.Time        `asn1:"generalized,optional,explicit,tag:8"`
+	CAddr             asn1.RawValue    `asn1:"optional,explicit,tag:9"`
+	AuthorizationData asn1.RawValue    `asn1:"optional,explicit,tag:10"`
+}
+
+// krbAuthenticator - расшифрованный аутентификатор (APPLICATION 2)
+type krbAuthenticator struct {
+	VNO               int              `asn1:"explicit,tag:0"`
+	CRealm            string           `asn1:"generalstring,explicit,tag:1"`
+	CName             krbPrincipalName `asn1:"explicit,tag:2"`
+	Checksum          asn1.RawValue    `asn1:"optional,explicit,tag:3"`
+	Cusec             int              `asn1:"explicit,tag:4"`
+	CTime             time.Time        `asn1:"generalized,explicit,tag:5"`
+	SubKey            krbEncryptionKey `asn1:"optional,explicit,tag:6"`
+	SeqNumber         int64            `asn1:"optional,explicit,tag:7"`
+	AuthorizationData asn1.RawValue    `asn1:"optional,explicit,tag:8"`
+}
+
+// krbAPRep - ответ сервиса при взаимной аутентификации (APPLICATION 15)
+type krbAPRep struct {
+	PVNO    int              `asn1:"explicit,tag:0"`
+	MsgType int              `asn1:"explicit,tag:1"`
+	EncPart krbEncryptedData `asn1:"explicit,tag:2"`
+}
+
+// krbEncAPRepPart - зашифрованная часть AP-REP (APPLICATION 27)
+type krbEncAPRepPart struct {
+	CTime time.Time `asn1:"generalized,explicit,tag:0"`
+	Cusec int       `asn1:"explicit,tag:1"`
+}
+
+// KeytabEntry - ключ участника-сервиса из keytab
+type KeytabEntry struct {
+	Realm      string
+	Components []string // Например, ["HTTP", "uas.example.com"]
+	KVNO       uint32
+	EType      int32
+	Key        []byte
+}
+
+// Principal возвращает имя участника вида HTTP/uas.example.com@REALM
+func (e KeytabEntry) Principal() string {
+	return strings.Join(e.Components, "/") + "@" + e.Realm
+}
+
+// Keytab - ключи сервиса, выданные KDC (ktpass, ktutil, kadmin ktadd)
+type Keytab struct {
+	entries []KeytabEntry
+}
+
+// LoadKeytab читает keytab в формате MIT версии 2 (0x0502)
+func LoadKeytab(path string) (*Keytab, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения keytab: %v", err)
+	}
+	keytab, err := parseKeytab(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(keytab.entries) == 0 {
+		return nil, fmt.Errorf("%s: нет ключей aes128-cts-hmac-sha1-96 или aes256-cts-hmac-sha1-96", path)
+	}
+	return keytab, nil
+}
+
+// parseKeytab разбирает keytab; ключи неподдерживаемых типов шифрования пропускаются
+func parseKeytab(data []byte) (*Keytab, error) {
+	if len(data) < 2 || data[0] != 0x05 || data[1] != 0x02 {
+		return nil, fmt.Errorf("неподдерживаемый формат keytab (нужна версия 0x0502)")
+	}
+	keytab := &Keytab{}
+	data = data[2:]
+	for len(data) >= 4 {
+		size := int32(binary.BigEndian.Uint32(data))
+		data = data[4:]
+		if size < 0 {
+			// Удаленная запись: место, оставленное в файле
+			if int(-size) > len(data) {
+				return nil, fmt.Errorf("запись keytab обрезана")
+			}
+			data = data[-size:]
+			continue
+		}
+		if int(size) > len(data) {
+			return nil, fmt.Errorf("запись keytab обрезана")
+		}
+		entry, err := parseKeytabEntry(data[:size])
+		if err != nil {
+			return nil, err
+		}
+		data = data[size:]
+		if entry.EType == krbETypeAES128 || entry.EType == krbETypeAES256 {
+			keytab.entries = append(keytab.entries, entry)
+		}
+	}
+	return keytab, nil
+}
+
+// parseKeytabEntry разбирает одну запись keytab
+func parseKeytabEntry(data []byte) (KeytabEntry, error) {
+	var entry KeytabEntry
+	broken := fmt.Errorf("некорректная запись keytab")
+	readCounted := func() (string, bool) {
+		if len(data) < 2 || int(binary.BigEndian.Uint16(data))+2 > len(data) {
+			return "", false
+		}
+		length := int(binary.BigEndian.Uint16(data))
+		value := string(data[2 : 2+length])
+		data = data[2+length:]
+		return value, true
+	}
+
+	if len(data) < 2 {
+		return entry, broken
+	}
+	components := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	realm, ok := readCounted()
+	if !ok {
+		return entry, broken
+	}
+	entry.Realm = realm
+	for i := 0; i < components; i++ {
+		component, ok := readCounted()
+		if !ok {
+			return entry, broken
+		}
+		entry.Components = append(entry.Components, component)
+	}
+	// Тип имени, время создания, 8-битная версия ключа, тип ключа
+	if len(data) < 4+4+1+2 {
+		return entry, broken
+	}
+	entry.KVNO = uint32(data[8])
+	entry.EType = int32(binary.BigEndian.Uint16(data[9:]))
+	data = data[11:]
+	key, ok := readCounted()
+	if !ok {
+		return entry, broken
+	}
+	entry.Key = []byte(key)
+	// Полная 32-битная версия ключа, если записана
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) != 0 {
+		entry.KVNO = binary.BigEndian.Uint32(data)
+	}
+	return entry, nil
+}
+
+// Realms возвращает области Kerberos ключей keytab
+func (k *Keytab) Realms() []string {
+	var realms []string
+	for _, entry := range k.entries {
+		if !containsString(realms, entry.Realm) {
+			realms = append(realms, entry.Realm)
+		}
+	}
+	return realms
+}
+
+// Principals возвращает участников-сервисов keytab
+func (k *Keytab) Principals() []string {
+	var principals []string
+	for _, entry := range k.entries {
+		if !containsString(principals, entry.Principal()) {
+			principals = append(principals, entry.Principal())
+		}
+	}
+	return principals
+}
+
+// key ищет ключ участника-сервиса; kvno 0 - последняя версия ключа
+func (k *Keytab) key(realm string, sname []string, etype int32, kvno uint32) ([]byte, bool) {
+	var found *KeytabEntry
+	for i, entry := range k.entries {
+		if entry.Realm != realm || entry.EType != etype || !krbNameEqual(entry.Components, sname) {
+			continue
+		}
+		if kvno != 0 && entry.KVNO == kvno {
+			return entry.Key, true
+		}
+		if kvno == 0 && (found == nil || entry.KVNO > found.KVNO) {
+			found = &k.entries[i]
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+	return found.Key, true
+}
+
+// containsString сообщает, есть ли строка в списке
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// krbPrincipal - проверенный участник-клиент
+type krbPrincipal struct {
+	Realm string
+	Name  []string
+}
+
+func (p krbPrincipal) String() string {
+	return strings.Join(p.Name, "/") + "@" + p.Realm
+}
+
+// krbReplayCache помнит принятые аутентификаторы в пределах допустимого расхождения часов:
+// перехваченный AP-REQ нельзя предъявить повторно
+type krbReplayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// check запоминает аутентификатор и сообщает, что он уже предъявлялся
+func (c *krbReplayCache) check(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	for seenKey, at := range c.seen {
+		if now.Sub(at) > 2*krbClockSkew {
+			delete(c.seen, seenKey)
+		}
+	}
+	if _, replayed := c.seen[key]; replayed {
+		return true
+	}
+	c.seen[key] = now
+	return false
+}
+
+// verifyAPReq проверяет AP-REQ ключом из keytab и возвращает клиента и AP-REP
+func verifyAPReq(data []byte, keytab *Keytab, replay *krbReplayCache, now time.Time) (krbPrincipal, []byte, error) {
+	var request krbAPReq
+	if _, err := asn1.UnmarshalWithParams(data, &request, "application,explicit,tag:14"); err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("некорректный AP-REQ: %v", err)
+	}
+	if request.PVNO != 5 || request.MsgType != krbMsgAPReq {
+		return krbPrincipal{}, nil, fmt.Errorf("ожидается AP-REQ Kerberos 5")
+	}
+	var ticket krbTicket
+	if _, err := asn1.UnmarshalWithParams(request.Ticket.Bytes, &ticket, "application,explicit,tag:1"); err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("некорректный билет: %v", err)
+	}
+
+	serviceKey, ok := keytab.key(ticket.Realm, ticket.SName.NameString, ticket.EncPart.EType, uint32(ticket.EncPart.KVNO))
+	if !ok {
+		return krbPrincipal{}, nil, fmt.Errorf("в keytab нет ключа %s@%s (тип шифрования %d, версия %d)",
+			strings.Join(ticket.SName.NameString, "/"), ticket.Realm, ticket.EncPart.EType, ticket.EncPart.KVNO)
+	}
+	plain, err := krbDecrypt(serviceKey, krbUsageTicket, ticket.EncPart.Cipher)
+	if err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("билет не расшифрован ключом keytab: %v", err)
+	}
+	var part krbEncTicketPart
+	if _, err := asn1.UnmarshalWithParams(plain, &part, "application,explicit,tag:3"); err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("некорректная часть билета: %v", err)
+	}
+	if part.Key.KeyType != krbETypeAES128 && part.Key.KeyType != krbETypeAES256 {
+		return krbPrincipal{}, nil, fmt.Errorf("тип сеансового ключа %d не поддерживается", part.Key.KeyType)
+	}
+
+	start := part.AuthTime
+	if !part.StartTime.IsZero() {
+		start = part.StartTime
+	}
+	switch {
+	case part.Flags.At(krbFlagInvalid) == 1:
+		return krbPrincipal{}, nil, fmt.Errorf("билет помечен как недействительный")
+	case now.Add(krbClockSkew).Before(start):
+		return krbPrincipal{}, nil, fmt.Errorf("билет еще не действует")
+	case now.Add(-krbClockSkew).After(part.EndTime):
+		return krbPrincipal{}, nil, fmt.Errorf("срок действия билета истек")
+	}
+
+	plain, err = krbDecrypt(part.Key.KeyValue, krbUsageAuthenticator, request.Authenticator.Cipher)
+	if err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("аутентификатор не расшифрован сеансовым ключом: %v", err)
+	}
+	var authenticator krbAuthenticator
+	if _, err := asn1.UnmarshalWithParams(plain, &authenticator, "application,explicit,tag:2"); err != nil {
+		return krbPrincipal{}, nil, fmt.Errorf("некорректный аутентификатор: %v", err)
+	}
+	client := krbPrincipal{Realm: part.CRealm, Name: part.CName.NameString}
+	if authenticator.CRealm != part.CRealm || !krbNameEqual(authenticator.CName.NameString, part.CName.NameString) {
+		return krbPrincipal{}, nil, fmt.Errorf("аутентификатор выдан не владельцу билета")
+	}
+	if skew := now.Sub(authenticator.CTime); skew > krbClockSkew || skew < -krbClockSkew {
+		return krbPrincipal{}, nil, fmt.Errorf("расхождение часов с клиентом больше %v", krbClockSkew)
+	}
+	if replay.check(fmt.Sprintf("%s %d %d", client, authenticator.CTime.UnixNano(), authenticator.Cusec), now) {
+		return krbPrincipal{}, nil, fmt.Errorf("аутентификатор уже предъявлялся")
+	}
+
+	reply, err := krbAPRepFor(part.Key.KeyValue, authenticator)
+	if err != nil {
+		return krbPrincipal{}, nil, err
+	}
+	return client, reply, nil
+}
+
+// krbAPRepFor создает AP-REP: клиент убеждается, что билет расшифрован владельцем ключа сервиса
+func krbAPRepFor(sessionKey []byte, authenticator krbAuthenticator) ([]byte, error) {
+	part, err := asn1.MarshalWithParams(krbEncAPRepPart{CTime: authenticator.CTime.UTC(), Cusec: authenticator.Cusec}, "application,explicit,tag:27")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания AP-REP: %v", err)
+	}
+	cipher, err := krbEncrypt(sessionKey, krbUsageAPRepPart, part)
+	if err != nil {
+		return nil, err
+	}
+	etype := int32(krbETypeAES256)
+	if len(sessionKey) == 16 {
+		etype = krbETypeAES128
+	}
+	reply := krbAPRep{PVNO: 5, MsgType: krbMsgAPRep, EncPart: krbEncryptedData{EType: etype, Cipher: cipher}}
+	return asn1.MarshalWithParams(reply, "application,explicit,tag:15")
+}
+
+// krbUsageKeys выводит ключи шифрования (Ke) и целостности (Ki) для номера использования
+// ключа (RFC 3961, 5.3)
+func krbUsageKeys(key []byte, usage uint32) (ke, ki []byte, err error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, nil, fmt.Errorf("длина ключа AES %d байт", len(key))
+	}
+	constant := binary.BigEndian.AppendUint32(nil, usage)
+	ke = krbDeriveKey(key, append(constant, 0xAA))
+	ki = krbDeriveKey(key, append(constant, 0x55))
+	return ke, ki, nil
+}
+
+// krbDeriveKey - DK(key, constant) для AES: n-fold константы до размера блока
+// и шифрование по цепочке до длины ключа
+func krbDeriveKey(key, constant []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	input := nfold(constant, aes.BlockSize)
+	derived := make([]byte, 0, len(key)+aes.BlockSize)
+	for len(derived) < len(key) {
+		output := make([]byte, aes.BlockSize)
+		block.Encrypt(output, input)
+		derived = append(derived, output...)
+		input = output
+	}
+	return derived[:len(key)]
+}
+
+// nfold растягивает или сжимает строку до size байт (RFC 3961, 5.1): копии входа, каждая
+// сдвинута циклически вправо еще на 13 бит, складываются с переносом по кругу
+func nfold(in []byte, size int) []byte {
+	inBits, outBits := len(in)*8, size*8
+	a, b := inBits, outBits
+	for b != 0 {
+		a, b = b, a%b
+	}
+	lcm := inBits / a * outBits
+
+	bit := func(i int) byte {
+		return in[i/8] >> (7 - i%8) & 1
+	}
+	stream := make([]byte, lcm/8)
+	for copyIndex := 0; copyIndex < lcm/inBits; copyIndex++ {
+		rotation := 13 * copyIndex
+		for i := 0; i < inBits; i++ {
+			position := copyIndex*inBits + i
+			stream[position/8] |= bit(((i-rotation)%inBits+inBits)%inBits) << (7 - position%8)
+		}
+	}
+
+	sum := make([]int, size)
+	for offset := 0; offset < len(stream); offset += size {
+		for i := 0; i < size; i++ {
+			sum[i] += int(stream[offset+i])
+		}
+	}
+	// Перенос по кругу, пока он есть
+	for carry := true; carry; {
+		carry = false
+		for i := size - 1; i >= 0; i-- {
+			if sum[i] > 0xFF {
+				next := (i - 1 + size) % size
+				sum[next] += sum[i] >> 8
+				sum[i] &= 0xFF
+				carry = true
+			}
+		}
+	}
+	out := make([]byte, size)
+	for i, value := range sum {
+		out[i] = byte(value)
+	}
+	return out
+}
+
+// krbDecrypt расшифровывает данные aes-cts-hmac-sha1-96 (RFC 3962) и проверяет HMAC
+func krbDecrypt(key []byte, usage uint32, ciphertext []byte) ([]byte, error) {
+	ke, ki, err := krbUsageKeys(key, usage)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aes.BlockSize+krbHMACSize {
+		return nil, fmt.Errorf("шифротекст слишком короткий")
+	}
+	mac := ciphertext[len(ciphertext)-krbHMACSize:]
+	plain, err := ctsDecrypt(ke, ciphertext[:len(ciphertext)-krbHMACSize])
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha1.New, ki)
+	h.Write(plain)
+	if subtle.ConstantTimeCompare(h.Sum(nil)[:krbHMACSize], mac) != 1 {
+		return nil, fmt.Errorf("неверный ключ или данные изменены")
+	}
+	// Первый блок - случайный заполнитель
+	return plain[aes.BlockSize:], nil
+}
+
+// krbEncrypt шифрует данные aes-cts-hmac-sha1-96 со случайным заполнителем
+func krbEncrypt(key []byte, usage uint32, plaintext []byte) ([]byte, error) {
+	ke, ki, err := krbUsageKeys(key, usage)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, aes.BlockSize, aes.BlockSize+len(plaintext))
+	if _, err := rand.Read(plain); err != nil {
+		return nil, fmt.Errorf("ошибка генерации заполнителя: %v", err)
+	}
+	plain = append(plain, plaintext...)
+	ciphertext := ctsEncrypt(ke, plain)
+	h := hmac.New(sha1.New, ki)
+	h.Write(plain)
+	return append(ciphertext, h.Sum(nil)[:krbHMACSize]...), nil
+}
+
+// ctsEncrypt - AES-CBC с нулевым вектором и кражей шифротекста: два последних блока
+// меняются местами, последний обрезается до длины открытого текста (RFC 3962, 5)
+func ctsEncrypt(key, plain []byte) []byte {
+	block, _ := aes.NewCipher(key)
+	if len(plain) <= aes.BlockSize {
+		padded := make([]byte, aes.BlockSize)
+		copy(padded, plain)
+		block.Encrypt(padded, padded)
+		return padded
+	}
+	blocks := (len(plain) + aes.BlockSize - 1) / aes.BlockSize
+	padded := make([]byte, blocks*aes.BlockSize)
+	copy(padded, plain)
+	chain := make([]byte, aes.BlockSize)
+	for i := 0; i < blocks; i++ {
+		current := padded[i*aes.BlockSize : (i+1)*aes.BlockSize]
+		for j := range current {
+			current[j] ^= chain[j]
+		}
+		block.Encrypt(current, current)
+		chain = current
+	}
+	last := len(plain) - (blocks-1)*aes.BlockSize
+	out := append([]byte(nil), padded[:(blocks-2)*aes.BlockSize]...)
+	out = append(out, padded[(blocks-1)*aes.BlockSize:]...)
+	return append(out, padded[(blocks-2)*aes.BlockSize:(blocks-2)*aes.BlockSize+last]...)
+}
+
+// ctsDecrypt расшифровывает результат ctsEncrypt
+func ctsDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, _ := aes.NewCipher(key)
+	if len(ciphertext) < aes.BlockSize {
+		return nil, fmt.Errorf("шифротекст короче блока")
+	}
+	if len(ciphertext) == aes.BlockSize {
+		plain := make([]byte, aes.BlockSize)
+		block.Decrypt(plain, ciphertext)
+		return plain, nil
+	}
+	blocks := (len(ciphertext) + aes.BlockSize - 1) / aes.BlockSize
+	last := len(ciphertext) - (blocks-1)*aes.BlockSize
+	plain := make([]byte, 0, len(ciphertext))
+
+	chain := make([]byte, aes.BlockSize)
+	for i := 0; i < blocks-2; i++ {
+		current := ciphertext[i*aes.BlockSize : (i+1)*aes.BlockSize]
+		decrypted := make([]byte, aes.BlockSize)
+		block.Decrypt(decrypted, current)
+		for j := range decrypted {
+			decrypted[j] ^= chain[j]
+		}
+		plain = append(plain, decrypted...)
+		chain = current
+	}
+
+	// Последний полный блок CBC стоит перед обрезанным предпоследним
+	final := ciphertext[(blocks-2)*aes.BlockSize : (blocks-1)*aes.BlockSize]
+	partial := ciphertext[(blocks-1)*aes.BlockSize:]
+	decrypted := make([]byte, aes.BlockSize)
+	block.Decrypt(decrypted, final)
+	previous := append(append([]byte(nil), partial...), decrypted[last:]...)
+	tail := make([]byte, last)
+	for j := range tail {
+		tail[j] = decrypted[j] ^ partial[j]
+	}
+	block.Decrypt(decrypted, previous)
+	for j := range decrypted {
+		decrypted[j] ^= chain[j]
+	}
+	plain = append(plain, decrypted...)
+	return append(plain, tail...), nil
+}
+
+// krbNameEqual сравнивает имена участников по составляющим
+func krbNameEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// unhex разбирает шестнадцатеричную строку с пробелами, как в RFC
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("некорректная строка %q: %v", s, err)
+	}
+	return data
+}
+
+// RFC 3961, приложение A.1
+func TestNFold(t *testing.T) {
+	tests := []struct {
+		bits int
+		in   string
+		want string
+	}{
+		{64, "012345", "be072631276b1955"},
+		{56, "password", "78a07b6caf85fa"},
+		{64, "Rough Consensus, and Running Code", "bb6ed30870b7f0e0"},
+		{168, "password", "59e4a8ca7c0385c3c37b3f6d2000247cb6e6bd5b3e"},
+		{192, "MASSACHVSETTS INSTITVTE OF TECHNOLOGY", "db3b0d8f0b061e603282b308a50841229ad798fab9540c1b"},
+		{168, "Q", "518a54a215a8452a518a54a215a8452a518a54a215"},
+		{168, "ba", "fb25d531ae8974499f52fd92ea9857c4ba24cf297e"},
+		{64, "kerberos", "6b65726265726f73"},
+		{128, "kerberos", "6b65726265726f737b9b5b2b93132b93"},
+		{168, "kerberos", "8372c236344e5f1550cd0747e15d62ca7a5a3bcea4"},
+		{256, "kerberos", "6b65726265726f737b9b5b2b93132b935c9bdcdad95c9899c4cae4dee6d6cae4"},
+	}
+	for _, test := range tests {
+		if got := hex.EncodeToString(nfold([]byte(test.in), test.bits/8)); got != test.want {
+			t.Errorf("%d-fold(%q) = %s, ожидалось %s", test.bits, test.in, got, test.want)
+		}
+	}
+}
+
+// RFC 3962, приложение B: ключ AES = DK(PBKDF2(пароль, соль), "kerberos")
+func TestKrbDeriveKey(t *testing.T) {
+	const password, salt = "password", "ATHENA.MIT.EDUraeburn"
+	tests := []struct {
+		iterations int
+		tkey       string // Результат PBKDF2
+		key        string
+	}{
+		{1, "cd ed b5 28 1b b2 f8 01 56 5a 11 22 b2 56 35 15",
+			"42 26 3c 6e 89 f4 fc 28 b8 df 68 ee 09 79 9f 15"},
+		{1, "cd ed b5 28 1b b2 f8 01 56 5a 11 22 b2 56 35 15 0a d1 f7 a0 4b b9 f3 a3 33 ec c0 e2 e1 f7 08 37",
+			"fe 69 7b 52 bc 0d 3c e1 44 32 ba 03 6a 92 e6 5b bb 52 28 09 90 a2 fa 27 88 39 98 d7 2a f3 01 61"},
+		{2, "01 db ee 7f 4a 9e 24 3e 98 8b 62 c7 3c da 93 5d",
+			"c6 51 bf 29 e2 30 0a c2 7f a4 69 d6 93 bd da 13"},
+		{2, "01 db ee 7f 4a 9e 24 3e 98 8b 62 c7 3c da 93 5d a0 53 78 b9 32 44 ec 8f 48 a9 9e 61 ad 79 9d 86",
+			"a2 e1 6d 16 b3 60 69 c1 35 d5 e9 d2 e2 5f 89 61 02 68 56 18 b9 59 14 b4 67 c6 76 22 22 58 24 ff"},
+		{1200, "5c 08 eb 61 fd f7 1e 4e 4e c3 cf 6b a1 f5 51 2b",
+			"4c 01 cd 46 d6 32 d0 1e 6d be 23 0a 01 ed 64 2a"},
+		{1200, "5c 08 eb 61 fd f7 1e 4e 4e c3 cf 6b a1 f5 51 2b a7 e5 2d db c5 e5 14 2f 70 8a 31 e2 e6 2b 1e 13",
+			"55 a6 ac 74 0a d1 7b 48 46 94 10 51 e1 e8 b0 a7 54 8d 93 b0 ab 30 a8 bc 3f f1 62 80 38 2b 8c 2a"},
+	}
+	for _, test := range tests {
+		tkey, want := unhex(t, test.tkey), unhex(t, test.key)
+		if got := pbkdf2.Key([]byte(password), []byte(salt), test.iterations, len(tkey), sha1.New); !bytes.Equal(got, tkey) {
+			t.Fatalf("PBKDF2, %d итераций: вектор RFC записан с ошибкой", test.iterations)
+		}
+		if got := krbDeriveKey(tkey, []byte("kerberos")); !bytes.Equal(got, want) {
+			t.Errorf("DK, %d итераций, %d бит: %x, ожидалось %x", test.iterations, len(tkey)*8, got, want)
+		}
+	}
+}
+
+// RFC 3962, приложение B: AES-128-CTS с нулевым вектором
+func TestCTS(t *testing.T) {
+	key := []byte("chicken teriyaki")
+	tests := []struct {
+		plain  string
+		cipher string
+	}{
+		{"I would like the ", "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{"I would like the General Gau's ", "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{"I would like the General Gau's C", "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+		{"I would like the General Gau's Chicken, please,",
+			"97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+		{"I would like the General Gau's Chicken, please, ",
+			"97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd839312523a78662d5be7fcbcc98ebf5a8"},
+		{"I would like the General Gau's Chicken, please, and wonton soup.",
+			"97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a84807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+	}
+	for _, test := range tests {
+		want := unhex(t, test.cipher)
+		if got := ctsEncrypt(key, []byte(test.plain)); !bytes.Equal(got, want) {
+			t.Errorf("шифрование %d байт: %x, ожидалось %x", len(test.plain), got, want)
+		}
+		plain, err := ctsDecrypt(key, want)
+		if err != nil || string(plain) != test.plain {
+			t.Errorf("расшифровка %d байт: %q, %v", len(test.plain), plain, err)
+		}
+	}
+}
+
+func TestKrbEncryptRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	for _, size := range []int{0, 1, 15, 16, 17, 100} {
+		plain := bytes.Repeat([]byte{'x'}, size)
+		cipher, err := krbEncrypt(key, krbUsageAuthenticator, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := krbDecrypt(key, krbUsageAuthenticator, cipher); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("%d байт: %q, %v", size, got, err)
+		}
+		if _, err := krbDecrypt(key, krbUsageTicket, cipher); err == nil {
+			t.Errorf("%d байт: расшифровано ключом другого использования", size)
+		}
+		cipher[0] ^= 1
+		if _, err := krbDecrypt(key, krbUsageAuthenticator, cipher); err == nil {
+			t.Errorf("%d байт: измененный шифротекст принят", size)
+		}
+	}
+}
+
+// krbTestRequest - параметры AP-REQ для проверки verifyAPReq
+type krbTestRequest struct {
+	serviceKey []byte
+	sessionKey []byte
+	start, end time.Time
+	ctime      time.Time
+	cusec      int
+	flags      asn1.BitString
+}
+
+// marshal собирает AP-REQ так, как его отправляет клиент: билет зашифрован ключом сервиса,
+// аутентификатор - сеансовым ключом из билета
+func (r krbTestRequest) marshal(t *testing.T) []byte {
+	t.Helper()
+	client := krbPrincipalName{NameType: 1, NameString: []string{"alice"}}
+	if r.flags.BitLength == 0 {
+		r.flags = asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+	}
+	part, err := asn1.MarshalWithParams(krbEncTicketPart{
+		Flags:  r.flags,
+		Key:    krbEncryptionKey{KeyType: krbETypeAES256, KeyValue: r.sessionKey},
+		CRealm: "EXAMPLE.COM",
+		CName:  client,
+		// Элемент [4] с пустым TransitedEncoding: RawValue кодируется как есть, без тега поля
+		Transited: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true,
+			Bytes: []byte{0x30, 0x09, 0xa0, 0x03, 0x02, 0x01, 0x01, 0xa1, 0x02, 0x04, 0x00}},
+		AuthTime: r.start.UTC(),
+		EndTime:  r.end.UTC(),
+	}, "application,explicit,tag:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketCipher, err := krbEncrypt(r.serviceKey, krbUsageTicket, part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := asn1.MarshalWithParams(krbTicket{
+		TktVNO:  5,
+		Realm:   "EXAMPLE.COM",
+		SName:   krbPrincipalName{NameType: 2, NameString: []string{"HTTP", "uas.example.com"}},
+		EncPart: krbEncryptedData{EType: krbETypeAES256, KVNO: 3, Cipher: ticketCipher},
+	}, "application,explicit,tag:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := asn1.MarshalWithParams(krbAuthenticator{
+		VNO:    5,
+		CRealm: "EXAMPLE.COM",
+		CName:  client,
+		Cusec:  r.cusec,
+		CTime:  r.ctime.UTC().Truncate(time.Second),
+	}, "application,explicit,tag:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticatorCipher, err := krbEncrypt(r.sessionKey, krbUsageAuthenticator, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := asn1.MarshalWithParams(krbAPReq{
+		PVNO:          5,
+		MsgType:       krbMsgAPReq,
+		APOptions:     asn1.BitString{Bytes: make([]byte, 4), BitLength: 32},
+		Ticket:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: ticket},
+		Authenticator: krbEncryptedData{EType: krbETypeAES256, Cipher: authenticatorCipher},
+	}, "application,explicit,tag:14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request
+}
+
+func TestVerifyAPReq(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	serviceKey := bytes.Repeat([]byte{0x11}, 32)
+	keytab := &Keytab{entries: []KeytabEntry{{
+		Realm: "EXAMPLE.COM", Components: []string{"HTTP", "uas.example.com"}, KVNO: 3, EType: krbETypeAES256, Key: serviceKey,
+	}}}
+	valid := krbTestRequest{
+		serviceKey: serviceKey,
+		sessionKey: bytes.Repeat([]byte{0x22}, 32),
+		start:      now.Add(-time.Hour),
+		end:        now.Add(9 * time.Hour),
+		ctime:      now,
+		cusec:      1,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*krbTestRequest)
+		reject string // Фрагмент ошибки; пусто - билет принимается
+	}{
+		{"действующий билет", func(*krbTestRequest) {}, ""},
+		{"билет истек", func(r *krbTestRequest) { r.end = now.Add(-krbClockSkew - time.Minute) }, "истек"},
+		{"билет еще не действует", func(r *krbTestRequest) { r.start = now.Add(krbClockSkew + time.Minute) }, "еще не действует"},
+		{"билет помечен INVALID", func(r *krbTestRequest) { r.flags = asn1.BitString{Bytes: []byte{0x01, 0, 0, 0}, BitLength: 32} }, "недействительный"},
+		{"чужой ключ сервиса", func(r *krbTestRequest) { r.serviceKey = bytes.Repeat([]byte{0x33}, 32) }, "не расшифрован ключом keytab"},
+		{"часы клиента отстают", func(r *krbTestRequest) { r.ctime = now.Add(-krbClockSkew - time.Minute) }, "расхождение часов"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := valid
+			request.cusec = i + 1
+			test.modify(&request)
+			client, reply, err := verifyAPReq(request.marshal(t), keytab, &krbReplayCache{}, now)
+			if test.reject != "" {
+				if err == nil || !strings.Contains(err.Error(), test.reject) {
+					t.Fatalf("ожидался отказ %q, получено %v", test.reject, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.String() != "alice@EXAMPLE.COM" || len(reply) == 0 {
+				t.Fatalf("участник %s, AP-REP %d байт", client, len(reply))
+			}
+		})
+	}
+}
+
+func TestVerifyAPReqReplay(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	serviceKey := bytes.Repeat([]byte{0x11}, 32)
+	keytab := &Keytab{entries: []KeytabEntry{{
+		Realm: "EXAMPLE.COM", Components: []string{"HTTP", "uas.example.com"}, KVNO: 3, EType: krbETypeAES256, Key: serviceKey,
+	}}}
+	request := krbTestRequest{
+		serviceKey: serviceKey,
+		sessionKey: bytes.Repeat([]byte{0x22}, 32),
+		start:      now.Add(-time.Hour),
+		end:        now.Add(time.Hour),
+		ctime:      now,
+	}.marshal(t)
+
+	var replay krbReplayCache
+	if _, _, err := verifyAPReq(request, keytab, &replay, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verifyAPReq(request, keytab, &replay, now.Add(time.Second)); err == nil || !strings.Contains(err.Error(), "уже предъявлялся") {
+		t.Fatalf("повтор аутентификатора: %v", err)
+	}
+}
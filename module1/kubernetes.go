@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Пути секретов внутри контейнера в манифесте Kubernetes
+const (
+	k8sTokenDir = "/run/secrets/uas-api-token"
+	k8sTLSDir   = "/run/secrets/uas-tls"
+	k8sDataDir  = "/var/lib/uas"
+)
+
+// k8sManagedFlags - флаги, значения которых задает сам манифест
+var k8sManagedFlags = map[string]bool{
+	"api-addr":       true,
+	"api-token-file": true,
+	"api-tls-cert":   true,
+	"api-tls-key":    true,
+	"data-dir":       true,
+}
+
+// WriteKubernetesManifest выводит пример манифеста Kubernetes для режима serve: Deployment
+// и Service, а в комментарии - команды создания секретов с токеном API и сертификатом. Флаги, заданные при вызове, переносятся
+// в переменные окружения контейнера; относительные пути (журнал аудита, ключ приглашений)
+// указывают в каталог данных. Проба жизнеспособности обращается к /healthz, готовности - к /readyz. Пользователи хранятся в памяти, поэтому реплика одна.
+func WriteKubernetesManifest(w io.Writer, flags *flag.FlagSet, image string) {
+	var env []string
+	flags.Visit(func(f *flag.Flag) {
+		if !k8sManagedFlags[f.Name] {
+			env = append(env, fmt.Sprintf("            - name: %s\n              value: %q\n", envName(f.Name), f.Value.String()))
+		}
+	})
+	sort.Strings(env)
+
+	fmt.Fprintf(w, `# Токен API: kubectl create secret generic uas-api-token --from-literal=api-token="$(openssl rand -hex 32)"
+# Сертификат: kubectl create secret tls uas-tls --cert=tls.crt --key=tls.key
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: user-auth-system
+spec:
+  # Пользователи хранятся в памяти процесса: несколько реплик разошлись бы в состоянии
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: user-auth-system
+  template:
+    metadata:
+      labels:
+        app: user-auth-system
+    spec:
+      containers:
+        - name: user-auth-system
+          image: %s
+          args: ["serve"]
+          env:
+            - name: %s
+              value: %q
+            - name: %s
+              value: "0.0.0.0:8443"
+            - name: %s
+              value: %q
+            - name: %s
+              value: %q
+            - name: %s
+              value: %q
+%s          ports:
+            - name: api
+              containerPort: 8443
+          livenessProbe:
+            httpGet:
+              path: %s
+              port: api
+              scheme: HTTPS
+          readinessProbe:
+            httpGet:
+              path: %s
+              port: api
+              scheme: HTTPS
+          volumeMounts:
+            - name: api-token
+              mountPath: %s
+              readOnly: true
+            - name: tls
+              mountPath: %s
+              readOnly: true
+            - name: data
+              mountPath: %s
+          securityContext:
+            runAsNonRoot: true
+            runAsUser: 65532
+            readOnlyRootFilesystem: true
+            allowPrivilegeEscalation: false
+      volumes:
+        - name: api-token
+          secret:
+            secretName: uas-api-token
+        - name: tls
+          secret:
+            secretName: uas-tls
+        - name: data
+          emptyDir: {}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: user-auth-system
+spec:
+  selector:
+    app: user-auth-system
+  ports:
+    - name: api
+      port: 443
+      targetPort: api
+`,
+		image,
+		envName("data-dir"), k8sDataDir,
+		envName("api-addr"),
+		envName("api-token-file"), k8sTokenDir+"/api-token",
+		envName("api-tls-cert"), k8sTLSDir+"/tls.crt",
+		envName("api-tls-key"), k8sTLSDir+"/tls.key",
+		strings.Join(env, ""),
+		healthPath, readyPath,
+		k8sTokenDir, k8sTLSDir, k8sDataDir)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UnlockUser снимает блокировку учетной записи без смены пароля (разблокировка администратором).
+// Снимает как блокировку входа по паролю, так и отключение учетной записи.
+func (um *UserManager) UnlockUser(username, reason string) error {
+	username = strings.TrimSpace(username)
+
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if !user.IsBlocked {
+			return fmt.Errorf("учетная запись не заблокирована")
+		}
+		user.IsBlocked = false
+		user.DisabledByAdmin = false
+		user.BlockedAt = time.Time{}
+		user.LockExpiresAt = time.Time{}
+		user.FailedAttempts = 0
+		user.FailedAt = nil
+		user.DormantSince = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	um.recordAudit(AuditAccountUnlocked, username, reason)
+	um.usersChanged()
+	return nil
+}
+
+// DisableUser полностью отключает учетную запись: вход и самостоятельное
+// восстановление через смену пароля запрещены до разблокировки администратором
+func (um *UserManager) DisableUser(username, reason string) error {
+	username = strings.TrimSpace(username)
+
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if user.DisabledByAdmin {
+			return fmt.Errorf("учетная запись уже отключена")
+		}
+		if !user.IsBlocked {
+			user.BlockedAt = time.Now()
+		}
+		user.IsBlocked = true
+		user.DisabledByAdmin = true
+		// Отключение не снимается по истечении срока блокировки
+		user.LockExpiresAt = time.Time{}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	um.recordAudit(AuditAccountDisabled, username, reason)
+	um.usersChanged()
+	return nil
+}
+
+// lockExpired сообщает, что срок блокировки после неудачных попыток истек
+func lockExpired(user *User, now time.Time) bool {
+	return user.IsBlocked && !user.DisabledByAdmin && !user.LockExpiresAt.IsZero() && !now.Before(user.LockExpiresAt)
+}
+
+// LockExpiresAt возвращает, когда снимется блокировка входа после неудачных попыток
+// (нулевое значение - учетная запись не заблокирована или заблокирована без срока)
+func (um *UserManager) LockExpiresAt(username string) time.Time {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists {
+		return time.Time{}
+	}
+	return lockExpiresAt(user, time.Now())
+}
+
+// lockExpiresAt возвращает срок действующей блокировки после неудачных попыток
+// (нулевое значение - блокировки нет, она без срока или срок уже истек)
+func lockExpiresAt(user *User, now time.Time) time.Time {
+	if !user.IsBlocked || user.DisabledByAdmin || lockExpired(user, now) {
+		return time.Time{}
+	}
+	return user.LockExpiresAt
+}
+
+// releaseExpiredLock снимает блокировку с истекшим сроком; вызывается при попытке входа.
+// Возвращает false, если блокировку уже сняли или продлили.
+func (um *UserManager) releaseExpiredLock(username string) bool {
+	released := false
+	um.store.Update(username, func(user *User) error {
+		if !lockExpired(user, time.Now()) {
+			return nil
+		}
+		user.IsBlocked = false
+		user.BlockedAt = time.Time{}
+		user.LockExpiresAt = time.Time{}
+		user.FailedAttempts = 0
+		user.FailedAt = nil
+		released = true
+		return nil
+	})
+	if released {
+		um.recordAudit(AuditAccountUnlocked, username, "истек срок блокировки")
+		um.usersChanged()
+	}
+	return released
+}
+
+// FormatCountdown выводит оставшееся время в виде "4:05" или "1:02:03"
+func FormatCountdown(remaining time.Duration) string {
+	seconds := int((max(remaining, 0) + time.Second - 1) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -1,242 +1,2421 @@
+// DNS разрешается встроенным резолвером Go и без cgo: собранная с CGO_ENABLED=0
+// программа статическая и работает в образе scratch
+//go:debug netdns=go
+
 package main
 
-import (
-	"bufio"
-	"fmt"
-	"os"
-	"strconv"
-	"strings"
-	"syscall"
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	auditPath := flag.String("audit-log", "audit.log", "путь к журналу аудита (пустая строка - аудит отключен)")
+	htpasswdPath := flag.String("htpasswd", "", "файл htpasswd, перезаписываемый при каждом изменении пользователей")
+	failureWindow := flag.Duration("failure-window", 15*time.Minute, "окно подсчета неудачных попыток входа (0 - без ограничения по времени)")
+	lockoutDuration := flag.Duration("lockout-duration", 0, "срок блокировки входа после превышения лимита неудачных попыток (например 15m, 0 - до разблокировки)")
+	duress := flag.String("duress", "off", "пароли под принуждением: off, login (вход выглядит успешным), fail (вход выглядит неудачным)")
+	honeypotLockout := flag.Duration("honeypot-lockout", 0, "блокировка входа с консоли после попытки входа в ловушку (например 15m, 0 - только тревога; в режиме serve не действует)")
+	registrationApproval := flag.Bool("registration-approval", false, "новые учетные записи ожидают одобрения администратором до первого входа")
+	inviteOnly := flag.Bool("invite-only", false, "регистрация только по подписанным приглашениям (режим invite; раздел registration политики может его сменить)")
+	inviteKeyPath := flag.String("invite-key", "invite-signing.key", "файл ключа подписи приглашений (создается при первом использовании)")
+	targetProfilesPath := flag.String("target-profiles", "", "свои ограничения целевых систем для генератора паролей (JSON, профиль с тем же id заменяет типовой)")
+	attackerProfilesPath := flag.String("attacker-profiles", "", "свои профили атакующего для оценки стойкости в формате attacker_profiles.json (профиль с тем же id заменяет типовой)")
+	pepperPath := flag.String("pepper-file", "", "файл ключей перца для хешей паролей (пусто - без перца, создается при первом использовании)")
+	inviteTTL := flag.Duration("invite-ttl", 72*time.Hour, "срок действия выдаваемых приглашений")
+	expiryWarningDays := flag.Int("password-expiry-warning", 14, "за сколько дней до истечения срока пароля предупреждать при входе")
+	loginObjective := flag.Float64("login-objective", defaultLoginObjective, "цель по доле успешных входов за окно статистики (stats, /v1/metrics), от 0 до 1")
+	deletionRetention := flag.Duration("deletion-retention", 0, "срок, в течение которого удаленную учетную запись можно восстановить (0 - удалять сразу)")
+	adminSession := flag.Bool("admin-session", true, "административные пункты меню доступны только после входа администратора")
+	idleTimeout := flag.Duration("idle-timeout", 15*time.Minute, "блокировка интерактивного сеанса после бездействия (0 - не блокировать)")
+	policyConfig := flag.String("policy-config", "", "JSON-файл политики (правила паролей, лимит попыток), перечитывается по SIGHUP")
+	themeName := flag.String("theme", "auto", "оформление вывода: auto, unicode, ascii (только ASCII), plain (без рамок и значков)")
+	apiAddr := flag.String("api-addr", "127.0.0.1:8080", "адрес HTTP API для команды serve")
+	apiTokenPath := flag.String("api-token-file", "api-token", "файл токена доступа к HTTP API (не короче 32 символов)")
+	apiTLSCert := flag.String("api-tls-cert", "", "сертификат TLS для HTTP API (без него API доступен только по loopback)")
+	apiTLSKey := flag.String("api-tls-key", "", "закрытый ключ TLS для HTTP API")
+	apiClientCA := flag.String("api-client-ca", "", "сертификаты УЦ клиентов API: вход по сертификату клиента и файл сертификата УЦ для команд ca (пусто - выключен)")
+	apiClientCAKey := flag.String("api-client-ca-key", "", "закрытый ключ внутреннего УЦ для команд ca init и ca issue")
+	apiKeysPath := flag.String("api-keys", "", "файл ключей HTTP API с ограниченными правами (JSON, пусто - только токен API)")
+	apiTrustedProxies := flag.String("api-trusted-proxies", "", "обратные прокси перед HTTP API, которым доверяется X-Forwarded-For: подсети CIDR или адреса через запятую")
+	apiAuthQueue := flag.Int("api-auth-queue", defaultAuthQueue, "HTTP API: запросов входа в очереди, сверх - ответ 429")
+	apiRegisterQueue := flag.Int("api-register-queue", defaultRegisterQueue, "HTTP API: запросов регистрации в очереди, сверх - ответ 429")
+	jwtKeysPath := flag.String("jwt-keys", "", "файл ключей подписи токенов доступа JWT, выдаваемых POST /v1/auth (пусто - токены не выдаются)")
+	jwtTTL := flag.Duration("jwt-ttl", defaultJWTTTL, "срок действия токенов доступа JWT")
+	jwtIssuer := flag.String("jwt-issuer", defaultJWTIssuer, "издатель (iss) токенов доступа JWT")
+	samlConfigPath := flag.String("saml-config", "", "настройки входа через корпоративный IdP по SAML 2.0, JSON (пусто - вход через SAML выключен)")
+	kerberosKeytab := flag.String("kerberos-keytab", "", "keytab сервиса (HTTP/<хост>) для входа по билету Kerberos на /v1/auth/negotiate (пусто - выключен)")
+	kerberosRealms := flag.String("kerberos-realms", "", "области Kerberos, пользователи которых входят под своим логином, через запятую (пусто - области keytab)")
+	kerberosFallback := flag.Bool("kerberos-password-fallback", false, "вход паролем (Basic) на /v1/auth/negotiate для клиентов без билета Kerberos, без токена API")
+	apiVerifyWorkers := flag.Int("api-verify-workers", runtime.NumCPU(), "HTTP API: параллельных проверок паролей в POST /v1/verify")
+	replicateFrom := flag.String("replicate-from", "", "резервный экземпляр: адрес репликации основного (host:port), изменения через API запрещены до promote")
+	replicationListen := flag.String("replication-listen", "", "адрес приема реплик (host:port); TLS - сертификат API")
+	replicationCA := flag.String("replication-ca", "", "сертификаты PEM для проверки основного экземпляра (по умолчанию системные)")
+	readOnly := flag.Bool("read-only", false, "реплика только для чтения с -replicate-from: отчеты и метрики без изменений и promote")
+	raftID := flag.String("raft-id", "", "кластер Raft: идентификатор этого узла из -raft-peers")
+	raftPeers := flag.String("raft-peers", "", "кластер Raft: все узлы, включая этот (n1=host1:7000,n2=host2:7000,n3=host3:7000)")
+	raftListen := flag.String("raft-listen", "", "кластер Raft: адрес приема подключений узлов (по умолчанию адрес узла из -raft-peers)")
+	raftDir := flag.String("raft-dir", "raft", "кластер Raft: каталог журнала и снимков хранилища")
+	raftCA := flag.String("raft-ca", "", "кластер Raft: сертификаты PEM для проверки других узлов (по умолчанию системные)")
+	storageKeysPath := flag.String("storage-keys", "", "кластер Raft: файл ключей шифрования журнала и снимков (пусто - без шифрования)")
+	dataDir := flag.String("data-dir", "", "каталог для журнала аудита, ключа приглашений, htpasswd и каталога Raft, заданных относительными путями")
+	dryRun := flag.Bool("dry-run", false, "пробный запуск: удаление, массовый импорт и создание, применение политики и окончательное удаление только показывают изменения")
+	seed := flag.String("deterministic-seed", "", "детерминированная генерация паролей для проверок (небезопасно, только для тестов)")
+	if err := applyEnvironment(flag.CommandLine); err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		os.Exit(2)
+	}
+	flag.Parse()
+
+	if *dataDir != "" {
+		for _, path := range []*string{auditPath, inviteKeyPath, pepperPath, jwtKeysPath, storageKeysPath, htpasswdPath, raftDir} {
+			if *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(*dataDir, *path)
+			}
+		}
+	}
+
+	outputTheme, err := ParseTheme(*themeName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		os.Exit(2)
+	}
+	SetTheme(outputTheme)
+
+	if *seed != "" {
+		SetRandomSource(NewSeededRandom(*seed))
+		fmt.Fprintln(os.Stderr, "ВНИМАНИЕ: детерминированный режим - сгенерированные пароли предсказуемы")
+	}
+
+	if *targetProfilesPath != "" {
+		if err := LoadTargetProfiles(*targetProfilesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *attackerProfilesPath != "" {
+		if err := LoadAttackerProfiles(*attackerProfilesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *loginObjective <= 0 || *loginObjective > 1 {
+		fmt.Fprintf(os.Stderr, "ошибка: -login-objective должна быть от 0 до 1, получено %g\n", *loginObjective)
+		os.Exit(2)
+	}
+
+	if args := flag.Args(); len(args) == 2 && args[0] == "invite" {
+		os.Exit(issueInvite(*inviteKeyPath, args[1], *inviteTTL))
+	}
+	keyPaths := map[string]string{"pepper": *pepperPath, "jwt": *jwtKeysPath, "storage": *storageKeysPath}
+	if args := flag.Args(); len(args) == 3 && args[0] == "keys" && args[1] == "rotate" {
+		os.Exit(rotateKey(args[2], keyPaths))
+	}
+	if args := flag.Args(); len(args) == 2 && args[0] == "pepper" && args[1] == "rotate" {
+		os.Exit(rotateKey("pepper", keyPaths))
+	}
+	if args := flag.Args(); len(args) == 2 && args[0] == "keys" && args[1] == "jwks" {
+		os.Exit(printJWKS(*jwtKeysPath))
+	}
+	if args := flag.Args(); len(args) == 1 && args[0] == "healthcheck" {
+		if err := CheckHealth(*apiAddr, *apiTLSCert, 3*time.Second); err != nil {
+			fmt.Fprintf(os.Stderr, "API недоступен: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args := flag.Args(); len(args) == 1 && args[0] == "promote" {
+		os.Exit(promoteReplica(*apiAddr, *apiTLSCert, *apiTokenPath))
+	}
+	if args := flag.Args(); len(args) >= 2 && args[0] == "audit" {
+		os.Exit(runAuditCommand(args[1:], *auditPath))
+	}
+	if args := flag.Args(); len(args) >= 2 && args[0] == "ca" {
+		os.Exit(runClientCA(args[1:], *apiClientCA, *apiClientCAKey))
+	}
+	// Отчет для cron и скриптов: в stdout только отчет
+	if args := flag.Args(); len(args) >= 1 && args[0] == "report" {
+		os.Exit(runReportCommand(args[1:], *apiAddr, *apiTLSCert, *apiTokenPath))
+	}
+	// AuthorizedKeysCommand для sshd: в stdout только строки ключей
+	if args := flag.Args(); len(args) == 2 && args[0] == "authorized-keys" {
+		os.Exit(printAuthorizedKeys(*apiAddr, *apiTLSCert, *apiTokenPath, args[1]))
+	}
+	if *readOnly && *replicateFrom == "" {
+		fmt.Fprintln(os.Stderr, "ошибка: -read-only задается вместе с -replicate-from")
+		os.Exit(2)
+	}
+	if args := flag.Args(); (*replicateFrom != "" || *replicationListen != "") && !(len(args) == 1 && args[0] == "serve") {
+		fmt.Fprintln(os.Stderr, "ошибка: репликация работает только в режиме serve")
+		os.Exit(2)
+	}
+	var cluster ClusterConfig
+	if *raftID != "" || *raftPeers != "" {
+		peers, err := ParseRaftPeers(*raftPeers)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "ошибка: -raft-peers: %v\n", err)
+		case *raftID == "" || len(peers) == 0:
+			fmt.Fprintln(os.Stderr, "ошибка: для кластера нужны -raft-id и -raft-peers")
+		case *replicateFrom != "" || *replicationListen != "":
+			fmt.Fprintln(os.Stderr, "ошибка: кластер Raft и репликация на резервный экземпляр не совмещаются")
+		case !(len(flag.Args()) == 1 && flag.Args()[0] == "serve"):
+			fmt.Fprintln(os.Stderr, "ошибка: кластер Raft работает только в режиме serve")
+		default:
+			cluster = ClusterConfig{ID: *raftID, Peers: peers, Listen: *raftListen, Dir: *raftDir, CAPath: *raftCA}
+		}
+		if cluster.ID == "" {
+			os.Exit(2)
+		}
+	}
+	if *storageKeysPath != "" {
+		if cluster.ID == "" {
+			fmt.Fprintln(os.Stderr, "ошибка: -storage-keys шифрует журнал и снимки кластера Raft и задается вместе с -raft-id")
+			os.Exit(2)
+		}
+		keys, created, err := LoadOrCreateKeySet(*storageKeysPath, KeyStorage)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			os.Exit(1)
+		}
+		if created {
+			fmt.Fprintf(os.Stderr, "Создан файл ключей шифрования хранилища %s - без него журнал и снимки не прочитать\n", *storageKeysPath)
+		}
+		cluster.Keys = keys
+	}
+	if args := flag.Args(); len(args) > 0 && len(args) <= 2 && args[0] == "kubernetes-manifest" {
+		image := "user-auth-system:latest"
+		if len(args) == 2 {
+			image = args[1]
+		}
+		WriteKubernetesManifest(os.Stdout, flag.CommandLine, image)
+		os.Exit(0)
+	}
+	if args := flag.Args(); len(args) > 1 && len(args) <= 3 && args[0] == "bench" && args[1] == "compare" {
+		target := benchDefaultTarget
+		if len(args) == 3 {
+			parsed, err := time.ParseDuration(args[2])
+			if err != nil || parsed <= 0 {
+				fmt.Fprintf(os.Stderr, "ошибка: неверное целевое время %q\n", args[2])
+				os.Exit(2)
+			}
+			target = parsed
+		}
+		fmt.Println("=== СРАВНЕНИЕ АЛГОРИТМОВ ХЕШИРОВАНИЯ ===")
+		results, err := BenchmarkHashers(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка замера: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(FormatHashBench(results, target))
+		os.Exit(0)
+	}
+	if args := flag.Args(); len(args) > 0 {
+		switch strings.Join(args, " ") {
+		case "selftest bruteforce":
+			os.Exit(runBruteForceSelfTest(SelfTestConfig{
+				FailureWindow:   *failureWindow,
+				HoneypotLockout: *honeypotLockout,
+			}))
+		case "shell", "serve":
+			// Консоль и API запускаются после настройки менеджера пользователей
+		default:
+			if len(args) == 2 && args[0] == "apply" {
+				// Файл состояния применяется после настройки менеджера пользователей
+				break
+			}
+			if len(args) <= 3 && args[0] == "analyze" && args[1] == "policy" {
+				// Запас оценивается для политики после чтения -policy-config
+				break
+			}
+			fmt.Fprintf(os.Stderr, "неизвестная команда: %s (доступно: invite <email>, selftest bruteforce, shell, serve, healthcheck, promote, authorized-keys <логин>, report [-since 30d] [-dormant-after 90d] [-format table|json|csv], ca init [срок], ca issue <логин> [срок], apply <файл>, analyze policy [вероятность], audit verify, audit export, audit verify-export <пакет>, kubernetes-manifest [образ], bench compare [время], keys rotate <pepper|jwt|storage>, keys jwks)\n", strings.Join(args, " "))
+			os.Exit(2)
+		}
+	}
+
+	fmt.Println("=== СИСТЕМА УПРАВЛЕНИЯ ПОЛЬЗОВАТЕЛЯМИ ===")
+	fmt.Println("Версия 1.0")
+	fmt.Println()
+
+	userManager := NewUserManager()
+	if *dryRun {
+		userManager.SetDryRun(true)
+		fmt.Println("ПРОБНЫЙ ЗАПУСК: удаление, импорт и применение политики не вносят изменений")
+		fmt.Println()
+	}
+
+	if *auditPath != "" {
+		auditLog, err := OpenAuditLog(DefaultAuditConfig(*auditPath))
+		if err != nil {
+			fmt.Printf(" Журнал аудита недоступен: %v\n\n", err)
+		} else {
+			defer auditLog.Close()
+			userManager.SetAuditLog(auditLog)
+		}
+	}
+
+	if err := userManager.SetHtpasswdSync(*htpasswdPath); err != nil {
+		fmt.Printf(" Синхронизация htpasswd недоступна: %v\n\n", err)
+	}
+
+	userManager.SetFailureWindow(*failureWindow)
+	userManager.SetLockoutDuration(*lockoutDuration)
+	userManager.SetHoneypotLockout(*honeypotLockout)
+	userManager.SetRegistrationApproval(*registrationApproval)
+	userManager.SetDeletionRetention(*deletionRetention)
+	userManager.SetPasswordExpiryWarning(time.Duration(*expiryWarningDays) * 24 * time.Hour)
+	userManager.LoginStats().SetObjective(*loginObjective)
+
+	if *inviteOnly {
+		inviteKey, _, err := LoadOrCreateSigningKey(*inviteKeyPath)
+		if err != nil {
+			fmt.Printf(" Регистрация по приглашениям недоступна: %v\n\n", err)
+			return
+		}
+		userManager.SetInviteOnly(inviteKey)
+	}
+
+	if *pepperPath != "" {
+		keys, created, err := LoadOrCreateKeySet(*pepperPath, KeyPepper)
+		if err != nil {
+			fmt.Printf(" Перец недоступен: %v\n\n", err)
+			return
+		}
+		if created {
+			fmt.Printf(" Создан файл ключей перца %s - сохраните его копию: без него пароли не проверить\n\n", *pepperPath)
+		}
+		SetPepper(keys)
+	}
+
+	duressMode, err := ParseDuressMode(*duress)
+	if err != nil {
+		fmt.Printf(" %v\n\n", err)
+	}
+	userManager.SetDuressMode(duressMode)
+
+	if *policyConfig != "" {
+		reloadPolicyConfig(userManager, *policyConfig)
+	}
+
+	session.RequireAdmin = *adminSession
+	if args := flag.Args(); len(args) == 1 && args[0] == "shell" {
+		os.Exit(runShell(userManager))
+	}
+	if args := flag.Args(); len(args) == 2 && args[0] == "apply" {
+		os.Exit(applyUserManifest(userManager, args[1]))
+	}
+	if args := flag.Args(); len(args) > 1 && len(args) <= 3 && args[0] == "analyze" && args[1] == "policy" {
+		probability := defaultMarginProbability
+		if len(args) == 3 {
+			parsed, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || parsed <= 0 || parsed > 1 {
+				fmt.Fprintf(os.Stderr, "ошибка: неверная вероятность подбора %q (ожидается число от 0 до 1)\n", args[2])
+				os.Exit(2)
+			}
+			probability = parsed
+		}
+		fmt.Println("=== ЗАПАС СТОЙКОСТИ ПОЛИТИКИ ПАРОЛЕЙ ===")
+		margin := userManager.PolicyMargin(probability)
+		fmt.Print(FormatPolicyMargin(margin, userManager.PolicyMarginHeat(probability)))
+		if len(margin.Below()) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Конфигурация перечитывается по SIGHUP в меню (перед следующим действием) и в режиме
+	// serve (между запросами API); консоль и разовые команды читают ее только при запуске
+	reload := make(chan os.Signal, 1)
+	if *policyConfig != "" {
+		signal.Notify(reload, syscall.SIGHUP)
+	}
+	var tokens *TokenIssuer
+	if *jwtKeysPath != "" {
+		keys, created, err := LoadOrCreateKeySet(*jwtKeysPath, KeyJWT)
+		if err != nil {
+			fmt.Printf(" Выдача токенов доступа недоступна: %v\n\n", err)
+			return
+		}
+		if created {
+			fmt.Printf(" Создан файл ключей подписи JWT %s\n\n", *jwtKeysPath)
+		}
+		tokens = NewTokenIssuer(keys, *jwtIssuer, *jwtTTL)
+	}
+	if args := flag.Args(); len(args) == 1 && args[0] == "serve" {
+		var saml *SAMLServiceProvider
+		if *samlConfigPath != "" {
+			provider, err := LoadSAMLConfig(*samlConfigPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+				os.Exit(2)
+			}
+			saml = provider
+		}
+		var kerberos *KerberosAcceptor
+		if *kerberosKeytab != "" {
+			keytab, err := LoadKeytab(*kerberosKeytab)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+				os.Exit(2)
+			}
+			var realms []string
+			for _, realm := range strings.Split(*kerberosRealms, ",") {
+				if realm = strings.TrimSpace(realm); realm != "" {
+					realms = append(realms, realm)
+				}
+			}
+			kerberos = NewKerberosAcceptor(keytab, realms, *kerberosFallback)
+			fmt.Printf("Вход по билету Kerberos: %s\n", strings.Join(keytab.Principals(), ", "))
+		}
+		var clientCAs *x509.CertPool
+		if *apiClientCA != "" {
+			roots, err := LoadClientCA(*apiClientCA)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: -api-client-ca: %v\n", err)
+				os.Exit(2)
+			}
+			clientCAs = roots
+		}
+		var apiKeys []APIKey
+		if *apiKeysPath != "" {
+			keys, err := LoadAPIKeys(*apiKeysPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: -api-keys: %v\n", err)
+				os.Exit(2)
+			}
+			for _, key := range keys {
+				if _, ok := userManager.RolePermissions(key.Role); !ok {
+					fmt.Fprintf(os.Stderr, "ошибка: -api-keys: ключ %s: неизвестная роль %q\n", key.Name, key.Role)
+					os.Exit(2)
+				}
+			}
+			apiKeys = keys
+		}
+		trustedProxies, err := ParseTrustedProxies(*apiTrustedProxies)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: -api-trusted-proxies: %v\n", err)
+			os.Exit(2)
+		}
+		os.Exit(serveAPI(userManager, *apiAddr, *apiTokenPath, *apiTLSCert, *apiTLSKey,
+			ReplicationConfig{From: *replicateFrom, Listen: *replicationListen, CAPath: *replicationCA, ReadOnly: *readOnly}, cluster,
+			NewAdmission(max(*apiAuthQueue, 0), max(*apiRegisterQueue, 0)), *apiVerifyWorkers, tokens, saml, kerberos, clientCAs, apiKeys,
+			trustedProxies, reload, *policyConfig))
+	}
+
+	// Блокировка по бездействию действует только при вводе с терминала
+	input := io.Reader(os.Stdin)
+	if *idleTimeout > 0 && stdinIsTerminal() {
+		sessionLock = NewIdleLock(*idleTimeout, func() bool { return unlockSession(userManager) })
+		input = sessionLock.Reader(os.Stdin)
+	}
+	scanner := bufio.NewScanner(input)
+	stdinLines = scanner
+
+	for {
+		select {
+		case <-reload:
+			reloadPolicyConfig(userManager, *policyConfig)
+		default:
+		}
+		if userManager.FeatureEnabled(FeatureDormancy) {
+			reportDormancy(userManager.ApplyDormancyPolicy(time.Now()))
+		}
+		for _, pseudonym := range userManager.PurgeDeleted(time.Now()) {
+			if userManager.DryRun() {
+				fmt.Printf("  Удаленная учетная запись %s была бы удалена окончательно\n\n", pseudonym)
+				continue
+			}
+			fmt.Printf("  Удаленная учетная запись %s удалена окончательно\n\n", pseudonym)
+		}
+		showMainMenu()
+		
+		fmt.Print("Выберите действие (1-19): ")
+		if !scanner.Scan() {
+			break
+		}
+		
+		choice := strings.TrimSpace(scanner.Text())
+		fmt.Println()
+
+		if feature, gated := menuFeatures[choice]; gated && !userManager.FeatureEnabled(feature) {
+			fmt.Println(" Эта функция отключена в конфигурации политики.")
+		} else if perm, gated := menuPermissions[choice]; gated && !requirePermission(userManager, scanner, perm) {
+			fmt.Printf(" Действие требует права %s.\n", perm)
+		} else {
+			switch choice {
+			case "1":
+				registerUser(userManager, scanner)
+			case "2":
+				authenticateUser(userManager, scanner)
+			case "3":
+				changeUserPassword(userManager, scanner)
+			case "4":
+				showUserStatus(userManager, scanner)
+			case "5":
+				showAllUsers(userManager, scanner)
+			case "6":
+				generatePasswordDemo(scanner)
+			case "7":
+				showPasswordRules(userManager, scanner)
+			case "8":
+				showActivityReport(userManager, scanner)
+			case "9":
+				auditMenu(*auditPath, scanner)
+			case "10":
+				importExportMenu(userManager, scanner)
+			case "11":
+				setLoginSchedule(userManager, scanner)
+			case "12":
+				personalDataMenu(userManager, scanner)
+			case "13":
+				recheckPasswords(userManager)
+			case "14":
+				honeypotMenu(userManager, scanner)
+			case "15":
+				lockoutMenu(userManager, scanner)
+			case "16":
+				approvalsMenu(userManager, scanner)
+			case "17":
+				renameMergeMenu(userManager, scanner)
+			case "18":
+				deletedAccountsMenu(userManager, scanner)
+			case "19":
+				fmt.Println("Спасибо за использование системы!")
+				return
+			default:
+				fmt.Println(" Неверный выбор. Пожалуйста, выберите от 1 до 19.")
+			}
+		}
+
+		fmt.Println()
+		fmt.Print("Нажмите Enter для продолжения...")
+		scanner.Scan()
+		fmt.Println()
+	}
+}
+
+// menuPermissions - административные пункты меню и необходимые для них права: при
+// -admin-session пункты скрыты и доступны только после входа пользователя с правом
+var menuPermissions = map[string]Permission{
+	"5": PermUserRead, "8": PermUserRead, "9": PermAuditRead, "10": PermUserWrite,
+	"11": PermUserWrite, "13": PermUserWrite, "14": PermPolicyManage, "15": PermUserUnlock,
+	"16": PermUserWrite, "17": PermUserWrite, "18": PermUserWrite,
+}
+
+// menuFeatures - пункты меню, которые можно отключить в разделе features конфигурации
+var menuFeatures = map[string]Feature{
+	"8":  FeatureActivityReport,
+	"10": FeatureImportExport,
+	"11": FeatureLoginSchedules,
+	"12": FeaturePersonalData,
+	"13": FeaturePasswordRecheck,
+	"14": FeatureHoneypots,
+}
+
+// issueInvite выдает приглашение на регистрацию и возвращает код завершения
+func issueInvite(keyPath, email string, ttl time.Duration) int {
+	key, created, err := LoadOrCreateSigningKey(keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	if created {
+		fmt.Fprintf(os.Stderr, "Создан ключ подписи приглашений: %s\n", keyPath)
+	}
+
+	token, err := IssueInvite(key, email, ttl, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Приглашение для %s действительно до %s\n", email, time.Now().Add(ttl).Format("2006-01-02 15:04"))
+	fmt.Println(token)
+	return 0
+}
+
+// rotateKey дописывает новый ключ в набор kind (pepper, jwt, storage). Новый ключ действует
+// после перезапуска, прежние остаются для проверки и расшифровки созданного ими.
+func rotateKey(kind string, paths map[string]string) int {
+	keyKind, ok := keyKinds[kind]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ошибка: неизвестный набор ключей %q (доступно: pepper, jwt, storage)\n", kind)
+		return 2
+	}
+	flagNames := map[string]string{"pepper": "-pepper-file", "jwt": "-jwt-keys", "storage": "-storage-keys"}
+	path := paths[kind]
+	if path == "" {
+		fmt.Fprintf(os.Stderr, "ошибка: файл ключей %s не задан (%s)\n", keyKind.Name, flagNames[kind])
+		return 2
+	}
+	id, err := AddKey(path, keyKind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	fmt.Printf("В %s добавлен ключ %s %s; он действует после перезапуска.\n", path, keyKind.Name, id)
+	switch kind {
+	case "pepper":
+		fmt.Println("Хеши с прежними ключами пересчитываются при следующем входе. Прежний ключ можно")
+		fmt.Println("удалить из файла, когда метрика uas_password_hashes покажет 0 его хешей.")
+	case "jwt":
+		fmt.Println("Токены, подписанные прежними ключами, действуют до истечения срока: опубликуйте")
+		fmt.Println("обновленный набор (keys jwks) до перезапуска. Прежний ключ можно удалить через -jwt-ttl.")
+	case "storage":
+		fmt.Println("Новые записи журнала и снимки шифруются новым ключом. Скопируйте файл на все узлы")
+		fmt.Println("до перезапуска; прежние ключи нужны для чтения уже записанного журнала и снимков.")
+	}
+	return 0
+}
+
+// printJWKS выводит открытые ключи проверки токенов доступа в формате JWKS
+func printJWKS(path string) int {
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "ошибка: файл ключей подписи JWT не задан (-jwt-keys)")
+		return 2
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	keys, err := ParseKeySet(data, KeyJWT)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: файл ключей подписи JWT %s: %v\n", path, err)
+		return 1
+	}
+	output, err := json.MarshalIndent(PublicJWKS(keys), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(output))
+	return 0
+}
+
+// runBruteForceSelfTest проверяет реакцию на подбор паролей при текущих параметрах
+// и возвращает код завершения (1 - поведение расходится с политикой)
+func runBruteForceSelfTest(config SelfTestConfig) int {
+	fmt.Println("=== САМОПРОВЕРКА ЗАЩИТЫ ОТ ПОДБОРА ПАРОЛЕЙ ===")
+	fmt.Printf("Окно подсчета неудачных попыток: %v, блокировка после ловушки: %v\n\n", config.FailureWindow, config.HoneypotLockout)
+
+	checks, err := RunBruteForceSelfTest(config)
+	if err != nil {
+		fmt.Printf(" Ошибка самопроверки: %v\n", err)
+		return 1
+	}
+	fmt.Print(FormatSelfTest(checks))
+
+	for _, check := range checks {
+		if !check.Passed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// applyUserManifest применяет файл состояния учетных записей, выводит изменения
+// и возвращает код завершения
+func applyUserManifest(userManager *UserManager, path string) int {
+	manifest, err := LoadUserManifest(path)
+	if err == nil {
+		var result ManifestResult
+		result, err = userManager.ApplyUserManifest(manifest, path)
+		if err == nil || len(result.Changes) > 0 {
+			fmt.Print(FormatManifestResult(result, userManager.DryRun()))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// serveAPI запускает HTTP API и возвращает код завершения. Без TLS API слушает только
+// loopback-адреса: токен доступа передается в каждом запросе.
+func serveAPI(userManager *UserManager, addr, tokenPath, certPath, keyPath string, replication ReplicationConfig, cluster ClusterConfig, admission *Admission, verifyWorkers int, tokens *TokenIssuer, saml *SAMLServiceProvider, kerberos *KerberosAcceptor, clientCAs *x509.CertPool, apiKeys []APIKey, trustedProxies []*net.IPNet, reload <-chan os.Signal, policyPath string) int {
+	token, err := ReadAPIToken(tokenPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	userManager.SetServing()
+	server := NewAPIServer(userManager, token)
+	server.admission = admission
+	server.verifier = NewCredentialVerifier(userManager, verifyWorkers)
+	server.tokens = tokens
+	server.saml = saml
+	server.kerberos = kerberos
+	server.clientCAs = clientCAs
+	server.apiKeys = apiKeys
+	server.trustedProxies = trustedProxies
+
+	if (certPath == "") != (keyPath == "") {
+		fmt.Fprintln(os.Stderr, "ошибка: -api-tls-cert и -api-tls-key задаются вместе")
+		return 2
+	}
+	if clientCAs != nil && certPath == "" {
+		fmt.Fprintln(os.Stderr, "ошибка: вход по сертификату клиента (-api-client-ca) работает только с -api-tls-cert")
+		return 2
+	}
+	if certPath == "" && !isLoopbackAddr(addr) {
+		fmt.Fprintf(os.Stderr, "ошибка: без TLS API доступен только по loopback-адресу, а не %s\n", addr)
+		return 2
+	}
+	if replication.From != "" || replication.Listen != "" {
+		if err := startReplication(userManager, server, replication, certPath, keyPath); err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: репликация: %v\n", err)
+			return 1
+		}
+	}
+	if cluster.ID != "" {
+		node, err := startCluster(userManager, server, cluster, certPath, keyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: кластер: %v\n", err)
+			return 1
+		}
+		defer node.Shutdown()
+	}
+
+	fmt.Printf("HTTP API: %s%s (Ctrl+C - остановка)\n", addr, apiPrefix)
+	httpServer := &http.Server{Addr: addr, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	if clientCAs != nil {
+		// Сертификат не обязателен: проверка цепочки и привязки выполняется для каждого запроса
+		httpServer.TLSConfig = &tls.Config{ClientAuth: tls.RequestClientCert, MinVersion: tls.VersionTLS12}
+	}
+	failed := make(chan error, 1)
+	go func() {
+		if certPath != "" {
+			failed <- httpServer.ListenAndServeTLS(certPath, keyPath)
+		} else {
+			failed <- httpServer.ListenAndServe()
+		}
+	}()
+
+	// SIGTERM - штатная остановка контейнера: начатые запросы завершаются
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	dormancy := time.NewTicker(dormancyInterval)
+	defer dormancy.Stop()
+	for {
+		select {
+		case now := <-dormancy.C:
+			result, err := server.applyDormancyPolicy(now)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: политика неактивности: %v\n", err)
+			}
+			reportDormancy(result)
+		case err := <-failed:
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		case <-reload:
+			// Обработчики API выполняются под s.mu: политика меняется между запросами
+			server.mu.Lock()
+			reloadPolicyConfig(userManager, policyPath)
+			server.mu.Unlock()
+		case <-stop:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка остановки: %v\n", err)
+				return 1
+			}
+			fmt.Println("HTTP API остановлен.")
+			return 0
+		}
+	}
+}
+
+// runAuditCommand выполняет команды audit без меню: проверку журнала, экспорт подписанного
+// пакета и его проверку. Код 1 - журнал или пакет не прошел проверку либо пакет не создан,
+// 2 - ошибка в аргументах.
+func runAuditCommand(args []string, auditPath string) int {
+	flags := flag.NewFlagSet("audit "+args[0], flag.ContinueOnError)
+	switch args[0] {
+	case "verify":
+		if flags.Parse(args[1:]) != nil || flags.NArg() != 0 {
+			return 2
+		}
+		if auditPath == "" {
+			fmt.Fprintln(os.Stderr, "ошибка: журнал аудита отключен (-audit-log)")
+			return 2
+		}
+		result, err := VerifyAuditLog(auditPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Нарушена целостность журнала: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Журнал не изменялся: проверено записей - %d, файлов - %d\n", result.Records, result.Files)
+	case "export":
+		from := flags.String("from", "", "начало периода, ГГГГ-ММ-ДД (по умолчанию 30 дней назад)")
+		to := flags.String("to", "", "конец периода включительно, ГГГГ-ММ-ДД (по умолчанию сейчас)")
+		keyPath := flags.String("key", "audit-signing.key", "файл ключа подписи (создается, если его нет)")
+		outPath := flags.String("out", "audit-export.json", "файл пакета")
+		publicKeyPath := flags.String("public-key", "", "файл для открытого ключа, который передается аудитору")
+		if flags.Parse(args[1:]) != nil || flags.NArg() != 0 {
+			return 2
+		}
+		if auditPath == "" {
+			fmt.Fprintln(os.Stderr, "ошибка: журнал аудита отключен (-audit-log)")
+			return 2
+		}
+		start, end := time.Now().AddDate(0, 0, -30), time.Now()
+		for _, value := range []string{*from, *to} {
+			if _, err := time.ParseInLocation("2006-01-02", value, time.Local); value != "" && err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка: некорректная дата %q (ГГГГ-ММ-ДД)\n", value)
+				return 2
+			}
+		}
+		if *from != "" {
+			start, _ = time.ParseInLocation("2006-01-02", *from, time.Local)
+		}
+		if *to != "" {
+			day, _ := time.ParseInLocation("2006-01-02", *to, time.Local)
+			end = day.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+
+		key, created, err := LoadOrCreateSigningKey(*keyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		}
+		if created {
+			fmt.Fprintf(os.Stderr, "Создан новый ключ подписи: %s\n", *keyPath)
+		}
+		export, err := ExportAuditRecords(auditPath, start, end, key)
+		if err == nil {
+			err = WriteAuditExport(*outPath, export)
+		}
+		publicKey := key.Public().(ed25519.PublicKey)
+		if err == nil && *publicKeyPath != "" {
+			err = WriteAuditPublicKey(*publicKeyPath, publicKey)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Экспортировано записей: %d в %s\n", len(export.Records), *outPath)
+		fmt.Printf("Отпечаток ключа подписи (SHA-256): %s\n", KeyFingerprint(publicKey))
+	case "verify-export":
+		trustedKey := flags.String("trusted-key", "", "файл открытого ключа, полученный от экспортировавшего")
+		fingerprint := flags.String("fingerprint", "", "полный отпечаток SHA-256 ключа, полученный по независимому каналу")
+		if flags.Parse(args[1:]) != nil || flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "использование: audit verify-export (-trusted-key <файл> | -fingerprint <sha256>) <пакет>")
+			return 2
+		}
+		if (*trustedKey == "") == (*fingerprint == "") {
+			fmt.Fprintln(os.Stderr, "ошибка: задайте -trusted-key или -fingerprint: ключу из самого пакета доверять нельзя")
+			return 2
+		}
+		export, err := ReadAuditExport(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		}
+		trusted, err := TrustedAuditKey(export, *trustedKey, *fingerprint)
+		if err == nil {
+			err = VerifyAuditExport(export, trusted)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Пакет не прошел проверку: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Подпись действительна, записей: %d, период: %s - %s\n", len(export.Records),
+			export.From.Local().Format("2006-01-02 15:04:05"), export.To.Local().Format("2006-01-02 15:04:05"))
+	default:
+		fmt.Fprintln(os.Stderr, "ошибка: доступно: audit verify, audit export [-from ГГГГ-ММ-ДД] [-to ГГГГ-ММ-ДД], audit verify-export (-trusted-key <файл> | -fingerprint <sha256>) <пакет>")
+		return 2
+	}
+	return 0
+}
+
+// promoteReplica назначает основной реплику, запущенную на этом узле, и возвращает код завершения
+func promoteReplica(addr, certPath, tokenPath string) int {
+	token, err := ReadAPIToken(tokenPath)
+	if err == nil {
+		var status ReplicationStatus
+		if status, err = PromoteReplica(addr, certPath, token); err == nil {
+			fmt.Printf("Экземпляр назначен основным, последнее полученное изменение: %d\n", status.Seq)
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+	return 1
+}
+
+// printAuthorizedKeys выводит действующие ключи SSH пользователя, полученные от API.
+// Ошибка - код 1: sshd тогда не принимает ни одного ключа.
+func printAuthorizedKeys(addr, certPath, tokenPath, username string) int {
+	token, err := ReadAPIToken(tokenPath)
+	if err == nil {
+		err = FetchAuthorizedKeys(addr, certPath, token, username, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runReportCommand выводит отчет об активности, полученный от API, как пункт меню "8"
+// и GET /v1/report. Учетные записи хранятся в памяти сервера, поэтому команде нужен
+// запущенный serve. Сообщения и ошибки выводятся в stderr.
+func runReportCommand(args []string, addr, certPath, tokenPath string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	since := flags.String("since", "30d", "период отчета (например 30d, 2w, 12h)")
+	dormantAfter := flags.String("dormant-after", "90d", "считать неактивными учетные записи без входа дольше")
+	format := flags.String("format", "table", "формат: table, json, csv")
+	if flags.Parse(args) != nil || flags.NArg() != 0 {
+		return 2
+	}
+	for _, period := range []struct{ name, value string }{{"since", *since}, {"dormant-after", *dormantAfter}} {
+		if _, err := ParsePeriod(period.value); err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: -%s: %v\n", period.name, err)
+			return 2
+		}
+	}
+	switch *format {
+	case "table", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "ошибка: -format: допустимо table, json, csv, получено %q\n", *format)
+		return 2
+	}
+
+	token, err := ReadAPIToken(tokenPath)
+	if err == nil {
+		err = FetchActivityReport(addr, certPath, token, *since, *dormantAfter, *format, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runClientCA выполняет команды внутреннего УЦ сертификатов клиентов: ca init [срок] и
+// ca issue <логин> [срок]
+func runClientCA(args []string, certPath, keyPath string) int {
+	if certPath == "" || keyPath == "" {
+		fmt.Fprintln(os.Stderr, "ошибка: файлы УЦ не заданы (-api-client-ca и -api-client-ca-key)")
+		return 2
+	}
+	period := func(index int, fallback time.Duration) (time.Duration, bool) {
+		if len(args) <= index {
+			return fallback, true
+		}
+		validity, err := ParsePeriod(args[index])
+		if err != nil || validity <= 0 {
+			fmt.Fprintf(os.Stderr, "ошибка: некорректный срок действия %q (например, 365d)\n", args[index])
+			return 0, false
+		}
+		return validity, true
+	}
+
+	switch {
+	case args[0] == "init" && len(args) <= 2:
+		validity, ok := period(1, defaultClientCAValidity)
+		if !ok {
+			return 2
+		}
+		cert, err := InitClientCA(certPath, keyPath, validity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Создан УЦ сертификатов клиентов: %s (действует до %s), ключ %s.\n", certPath, cert.NotAfter.Format("2006-01-02"), keyPath)
+		fmt.Println("Храните ключ УЦ отдельно от сервера API: серверу нужен только сертификат (-api-client-ca).")
+	case args[0] == "issue" && (len(args) == 2 || len(args) == 3):
+		validity, ok := period(2, defaultClientCertValidity)
+		if !ok {
+			return 2
+		}
+		cert, path, err := IssueClientCert(certPath, keyPath, args[1], ".", validity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ошибка: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Выпущен сертификат %s (действует до %s), ключ %s.\n", path, cert.NotAfter.Format("2006-01-02"), strings.TrimSuffix(path, ".crt")+".key")
+		fmt.Printf("Привязка к учетной записи (консоль: cert-bind, API: POST /v1/users/%s/certificates):\n", args[1])
+		fmt.Printf("  uri:%s - действует и для перевыпущенных сертификатов\n", ClientCertURI(args[1]))
+		fmt.Printf("  sha256:%s - только этот сертификат\n", certFingerprint(cert))
+	default:
+		fmt.Fprintln(os.Stderr, "ошибка: доступно: ca init [срок], ca issue <логин> [срок]")
+		return 2
+	}
+	return 0
+}
+
+// reloadPolicyConfig применяет файл политики. Ошибочная конфигурация отклоняется
+// целиком, и продолжает действовать прежняя политика.
+func reloadPolicyConfig(userManager *UserManager, path string) {
+	config, err := LoadPolicyConfig(path)
+	if err == nil {
+		var changes []string
+		changes, err = userManager.ApplyPolicyConfig(config)
+		if err == nil && reportDryRun(userManager) {
+			return
+		}
+		for _, change := range changes {
+			fmt.Printf("  Политика обновлена: %s\n", change)
+		}
+	}
+	if err != nil {
+		fmt.Printf(" Конфигурация %s отклонена, действует прежняя политика: %v\n", path, err)
+	}
+	fmt.Println()
+}
+
+// unlockSession запрашивает пароль пользователя, последним вошедшего через меню,
+// после блокировки сеанса по бездействию. До первого входа блокировка только очищает экран.
+// Ввод читается напрямую из os.Stdin: сканер меню в этот момент ожидает строку.
+func unlockSession(userManager *UserManager) bool {
+	if session.Username == "" {
+		return true
+	}
+
+	fmt.Printf("Логин: %s\n", session.Username)
+	fmt.Print("Пароль: ")
+	password, err := readPassword()
+	if err == io.EOF {
+		fmt.Println("\nСеанс завершен.")
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return false
+	}
+
+	outcome, err := userManager.AuthenticateUser(session.Username, password)
+	if err != nil || outcome.Result != AuthSuccess {
+		fmt.Println(" Неверный пароль. Сеанс остается заблокированным.")
+		return false
+	}
+	return true
+}
+
+// reportDormancy выводит итог прохода политики неактивных учетных записей
+// reportDryRun выводит изменения, которые внесло бы последнее действие, и сообщает,
+// был ли это пробный запуск
+func reportDryRun(userManager *UserManager) bool {
+	if !userManager.DryRun() {
+		return false
+	}
+
+	plan := userManager.DryRunPlan()
+	if len(plan) == 0 {
+		fmt.Println("Пробный запуск: изменений нет.")
+		fmt.Println()
+		return true
+	}
+	fmt.Printf("Пробный запуск, изменений было бы %d:\n", len(plan))
+	for _, change := range plan {
+		fmt.Printf("  "+theme.Bullet+" %s\n", change)
+	}
+	fmt.Println()
+	return true
+}
+
+func reportDormancy(result DormancyResult) {
+	if result.Empty() {
+		return
+	}
+
+	for _, username := range result.Warned {
+		fmt.Printf("  Учетная запись '%s' скоро будет признана неактивной - требуется вход\n", username)
+	}
+	for _, username := range result.Flagged {
+		fmt.Printf("  Учетная запись '%s' помечена как неактивная\n", username)
+	}
+	for _, username := range result.Disabled {
+		fmt.Printf("  Учетная запись '%s' заблокирована из-за неактивности\n", username)
+	}
+	fmt.Println()
+}
+
+func showMainMenu() {
+	var items []string
+	hidden := false
+	for i, title := range mainMenuItems {
+		if perm, gated := menuPermissions[strconv.Itoa(i+1)]; gated && !session.Can(perm) {
+			hidden = true
+			continue
+		}
+		items = append(items, fmt.Sprintf("%d. %s", i+1, title))
+	}
+	if hidden {
+		items = append(items, "", "Прочие пункты - для пользователей с административными правами")
+	}
+	printBox("ГЛАВНОЕ МЕНЮ", items...)
+}
+
+// mainMenuItems - пункты главного меню по порядку номеров
+var mainMenuItems = []string{
+	"Регистрация пользователя",
+	"Вход в систему",
+	"Смена пароля (разблокировка)",
+	"Статус пользователя",
+	"Список всех пользователей",
+	"Генерация безопасного пароля",
+	"Правила создания паролей",
+	"Отчет об активности",
+	"Журнал аудита",
+	"Импорт/экспорт пользователей",
+	"Расписание входа пользователя",
+	"Мои данные (выгрузка/удаление)",
+	"Проверка паролей пользователей",
+	"Учетные записи-ловушки",
+	"Учетные записи и права (админ.)",
+	"Заявки на регистрацию (админ.)",
+	"Переименование/объединение (админ.)",
+	"Удаленные учетные записи (админ.)",
+	"Выход",
+}
+
+// requirePermission проверяет, что у сеанса есть право perm (пусто - хотя бы одно
+// административное право), и иначе запрашивает вход пользователя с этим правом. Пока
+// администраторов нет, предлагает создать первого.
+func requirePermission(userManager *UserManager, scanner *bufio.Scanner, perm Permission) bool {
+	if session.Can(perm) {
+		return true
+	}
+	if !userManager.HasAdmins() {
+		return createFirstAdmin(userManager, scanner)
+	}
+
+	switch perm {
+	case "":
+		fmt.Println(" Требуется вход пользователя с административными правами.")
+	case permAdmin:
+		fmt.Println(" Требуется вход администратора.")
+	default:
+		fmt.Printf(" Требуется вход пользователя с правом %s.\n", perm)
+	}
+	fmt.Print("Логин: ")
+	if !scanner.Scan() {
+		return false
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Пароль: ")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return false
+	}
+
+	outcome, err := userManager.AuthenticateUser(username, password)
+	if err != nil {
+		fmt.Printf(" Ошибка при входе: %v\n", err)
+		return false
+	}
+	if outcome.Result != AuthSuccess {
+		fmt.Printf(" %s\n", outcome)
+		return false
+	}
+	principal := userManager.UserPrincipal(username)
+	if perm == "" && !principal.Any() {
+		fmt.Println(" У пользователя нет административных прав.")
+		return false
+	}
+	if perm != "" {
+		if err := userManager.Authorize(principal, perm, "консоль"); err != nil {
+			fmt.Printf(" %v\n", err)
+			return false
+		}
+	}
+
+	session.SignIn(username, principal)
+	fmt.Printf(" Вход пользователя '%s' (роль %s) выполнен.\n\n", username, describeRole(userManager.UserRole(username)))
+	return true
+}
+
+// createFirstAdmin создает первую учетную запись администратора и открывает ее сеанс
+func createFirstAdmin(userManager *UserManager, scanner *bufio.Scanner) bool {
+	fmt.Println(" Администраторов еще нет. Создайте учетную запись администратора.")
+	fmt.Print("Логин администратора: ")
+	if !scanner.Scan() {
+		return false
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Пароль: ")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return false
+	}
+
+	if err := userManager.CreateFirstAdmin(username, password); err != nil {
+		fmt.Printf(" Ошибка при создании администратора: %v\n", err)
+		return false
+	}
+
+	session.SignIn(username, userManager.UserPrincipal(username))
+	fmt.Printf(theme.Success+"Администратор '%s' создан, вход выполнен.\n\n", username)
+	return true
+}
+
+func registerUser(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== РЕГИСТРАЦИЯ НОВОГО ПОЛЬЗОВАТЕЛЯ ===")
+
+	// В режиме admin учетную запись создает пользователь с правом user.write
+	byAdmin := userManager.RegistrationMode() == RegistrationAdmin
+	if byAdmin && !requirePermission(userManager, scanner, PermUserWrite) {
+		fmt.Println(" Самостоятельная регистрация закрыта: учетные записи создает администратор.")
+		return
+	}
+	
+	// Ввод логина
+	fmt.Print("Введите логин: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	if username == "" {
+		fmt.Println(" Логин не может быть пустым.")
+		return
+	}
+
+	// Ввод пароля
+	fmt.Print("Введите пароль: ")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return
+	}
+
+	// Попытка регистрации
+	if byAdmin {
+		err = userManager.CreateUser(username, password, session.Username)
+	} else if userManager.InviteOnly() {
+		fmt.Print("Приглашение: ")
+		if !scanner.Scan() {
+			return
+		}
+		err = userManager.RegisterUserWithInvite(username, password, scanner.Text())
+	} else {
+		err = userManager.RegisterUser(username, password)
+	}
+	if err != nil {
+		fmt.Printf(" Ошибка регистрации: %v\n", err)
+		return
+	}
+
+	if userManager.RegistrationApprovalRequired() && !byAdmin {
+		fmt.Printf(theme.Success+"Заявка на регистрацию '%s' принята. Вход станет возможен после одобрения администратором.\n", username)
+	} else {
+		fmt.Printf(theme.Success+"Пользователь '%s' успешно зарегистрирован!\n", username)
+	}
+	fmt.Print(AnalyzeStrength(password).Format())
+}
+
+func authenticateUser(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== ВХОД В СИСТЕМУ ===")
+	
+	// Ввод логина
+	fmt.Print("Логин: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	if username == "" {
+		fmt.Println(" Логин не может быть пустым.")
+		return
+	}
+
+	// Ввод пароля
+	fmt.Print("Пароль: ")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return
+	}
+
+	// Попытка аутентификации
+	outcome, err := userManager.AuthenticateUser(username, password)
+	if err == nil && outcome.Result == AuthTermsRequired {
+		if !acceptTerms(userManager.Terms(), scanner) {
+			fmt.Println(" Без принятия условий использования вход невозможен.")
+			return
+		}
+		outcome, err = userManager.AuthenticateAcceptingTerms(username, password, outcome.TermsVersion)
+	}
+	if err == nil && outcome.Result == AuthProfileRequired && completeProfile(userManager, scanner, username, outcome.ProfileSteps, true) {
+		// Пароль уже проверен: после заполнения профиля вход повторяется
+		outcome, err = userManager.AuthenticateUser(username, password)
+	}
+	if err != nil {
+		fmt.Printf(" Ошибка при входе: %v\n", err)
+		return
+	}
+
+	switch outcome.Result {
+	case AuthSuccess:
+		fmt.Printf(" Добро пожаловать, %s!\n", username)
+		session.SignIn(username, userManager.UserPrincipal(username))
+		if !outcome.PasswordExpiresAt.IsZero() {
+			warnPasswordExpiry(userManager, scanner, username, outcome)
+		}
+		if len(outcome.ProfileSteps) > 0 {
+			completeProfile(userManager, scanner, username, outcome.ProfileSteps, false)
+		}
+	case AuthUserNotFound:
+		fmt.Println(" Пользователь не найден.")
+	case AuthInvalidCredentials:
+		fmt.Println(" Неверный логин или пароль.")
+		fmt.Printf("   Осталось попыток до блокировки входа: %d\n", outcome.RemainingAttempts)
+		// Показываем статус после неудачной попытки
+		if status, err := userManager.GetUserStatus(username); err == nil {
+			fmt.Println("\n Текущий статус:")
+			fmt.Print(status)
+		}
+	case AuthUserBlocked:
+		fmt.Println("	Вход по паролю заблокирован после превышения лимита неудачных попыток входа.")
+		if !outcome.LockedUntil.IsZero() {
+			fmt.Printf("   Блокировка снимется в %s (через %s).\n", outcome.LockedUntil.Format("15:04:05"), FormatCountdown(outcome.RetryAfter))
+		}
+		if session.RequireAdmin {
+			fmt.Println("   Для восстановления обратитесь к администратору.")
+		} else {
+			fmt.Println("   Для восстановления смените пароль (опция 3) или обратитесь к администратору.")
+		}
+	case AuthAccountDisabled:
+		fmt.Println(" Учетная запись отключена администратором.")
+	case AuthRejectedByHook:
+		fmt.Println(" Вход запрещен внешней политикой.")
+	case AuthPendingApproval:
+		fmt.Println(" Заявка на регистрацию еще не одобрена администратором.")
+	case AuthOutsideSchedule:
+		fmt.Println(" Вход в это время запрещен расписанием учетной записи.")
+		if !outcome.LockedUntil.IsZero() {
+			fmt.Printf("   Ближайшее разрешенное время входа: %s\n", outcome.LockedUntil.Format("2006-01-02 15:04"))
+		}
+	case AuthPasswordExpired:
+		// Пароль уже проверен, поэтому сменить его можно сразу
+		fmt.Println(" Истек срок принудительной смены пароля.")
+		fmt.Print("Сменить пароль сейчас? (да/нет): ")
+		if !scanner.Scan() || !isYes(scanner.Text()) {
+			fmt.Println("   Вход возможен только после смены пароля.")
+			return
+		}
+		fmt.Println()
+		changePassword(userManager, username)
+	case AuthSourceBlocked:
+		fmt.Printf(" Вход временно запрещен. Повторите попытку через %v.\n", outcome.RetryAfter.Round(time.Second))
+	case AuthTermsRequired:
+		fmt.Println(" Условия использования изменились. Повторите вход.")
+	case AuthSecondFactorRequired:
+		fmt.Println(" Группа учетной записи или правило политики требует второго фактора: вход только паролем запрещен.")
+		fmt.Println("   Войдите через корпоративный IdP (SAML), Kerberos или сертификат клиента.")
+	case AuthProfileRequired:
+		fmt.Println(" Вход возможен только после того, как профиль будет дополнен.")
+	case AuthRejectedByRule:
+		fmt.Println(" Вход запрещен правилом политики.")
+	}
+}
+
+// completeProfile предлагает выполнить шаги профиля, которые пора выполнить. После успешного
+// входа (required = false) любой шаг можно отложить пустым вводом; при отказе во входе
+// обязательны шаги, которые откладывать больше нельзя. Возвращает true, если обязательные
+// шаги выполнены.
+func completeProfile(userManager *UserManager, scanner *bufio.Scanner, username string, steps []ProfileStepDue, required bool) bool {
+	completed := true
+	for _, step := range steps {
+		optional := !required || step.SnoozesLeft > 0
+		later := ""
+		if optional {
+			later = fmt.Sprintf(" (Enter - напомнить позже, отложить можно еще %d раз)", step.SnoozesLeft)
+		}
+		switch step.Step {
+		case ProfileStepEmail:
+			fmt.Printf("\n Укажите адрес электронной почты%s: ", later)
+			if !scanner.Scan() || strings.TrimSpace(scanner.Text()) == "" {
+				completed = completed && optional
+				continue
+			}
+			if err := userManager.CompleteProfileEmail(username, scanner.Text()); err != nil {
+				fmt.Printf(" Ошибка: %v\n", err)
+				completed = completed && optional
+				continue
+			}
+			fmt.Println(theme.Success + "Адрес сохранен.")
+		case ProfileStepTwoFA:
+			fmt.Println("\n Подключите второй фактор: свяжите учетную запись с корпоративным IdP (SAML)")
+			fmt.Println("   или получите сертификат клиента у администратора.")
+			if optional {
+				fmt.Printf("   Напоминание отложено, отложить можно еще %d раз.\n", step.SnoozesLeft)
+			}
+			completed = completed && optional
+		}
+	}
+	return completed
+}
+
+// acceptTerms показывает условия использования и спрашивает согласие пользователя
+func acceptTerms(terms TermsDocument, scanner *bufio.Scanner) bool {
+	fmt.Printf("\n Условия использования обновлены (версия %s).\n", terms.Version)
+	if terms.Text != "" {
+		fmt.Println(terms.Text)
+	}
+	fmt.Print("\nПринимаете условия? (да/нет): ")
+	if !scanner.Scan() {
+		return false
+	}
+	return isYes(scanner.Text())
+}
+
+// isYes сообщает, является ли ответ согласием
+func isYes(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "да" || answer == "д" || answer == "yes" || answer == "y"
+}
+
+// approvalsMenu - просмотр, одобрение и отклонение заявок на регистрацию
+func approvalsMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== ЗАЯВКИ НА РЕГИСТРАЦИЮ ===")
+	if !userManager.RegistrationApprovalRequired() {
+		fmt.Println("Одобрение регистраций выключено (флаг -registration-approval).")
+	}
+
+	pending := userManager.PendingRegistrations()
+	if len(pending) == 0 {
+		fmt.Println("Нет заявок, ожидающих одобрения.")
+		return
+	}
+	for _, user := range pending {
+		fmt.Printf("  "+theme.Bullet+" %-30s заявка от %s\n", user.Username, user.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+
+	fmt.Println()
+	fmt.Println("1. Одобрить")
+	fmt.Println("2. Отклонить (учетная запись будет удалена)")
+	fmt.Print("Выберите действие (1-2, Enter - выход): ")
+	if !scanner.Scan() {
+		return
+	}
+	action := strings.TrimSpace(scanner.Text())
+	if action != "1" && action != "2" {
+		return
+	}
+
+	fmt.Print("Логин заявителя: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Причина (для журнала аудита): ")
+	if !scanner.Scan() {
+		return
+	}
+	reason := strings.TrimSpace(scanner.Text())
+
+	if action == "1" {
+		if err := userManager.ApproveRegistration(username, reason); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		fmt.Printf(theme.Success+"Регистрация '%s' одобрена\n", username)
+		return
+	}
+
+	if err := userManager.RejectRegistration(username, reason); err != nil {
+		fmt.Printf(" Ошибка: %v\n", err)
+		return
+	}
+	if reportDryRun(userManager) {
+		return
+	}
+	fmt.Printf(theme.Success+"Заявка '%s' отклонена, учетная запись удалена\n", username)
+}
+
+// lockoutMenu - разблокировка и отключение учетных записей администратором
+func lockoutMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== УЧЕТНЫЕ ЗАПИСИ И ПРАВА ===")
+	fmt.Println("1. Разблокировать (без смены пароля)")
+	fmt.Println("2. Отключить учетную запись")
+	fmt.Println("3. Назначить администратором")
+	fmt.Println("4. Снять права администратора")
+	fmt.Println("5. Назначить роль")
+	fmt.Print("Выберите действие (1-5): ")
+	if !scanner.Scan() {
+		return
+	}
+	action := strings.TrimSpace(scanner.Text())
+	// Разблокировка доступна с правом user.unlock, с которым открыт пункт меню
+	perm, ok := map[string]Permission{"1": PermUserUnlock, "2": PermUserWrite, "3": permAdmin, "4": permAdmin, "5": permAdmin}[action]
+	if !ok {
+		fmt.Println(" Неверный выбор.")
+		return
+	}
+	if !requirePermission(userManager, scanner, perm) {
+		fmt.Printf(" Действие требует права %s.\n", perm)
+		return
+	}
+
+	fmt.Print("Логин пользователя: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	var role string
+	if action == "5" {
+		fmt.Printf("Роль (%s): ", strings.Join(append([]string{RoleUser, RoleAdmin}, userManager.RoleNames()...), ", "))
+		if !scanner.Scan() {
+			return
+		}
+		role = strings.TrimSpace(scanner.Text())
+	}
+
+	fmt.Print("Причина (для журнала аудита): ")
+	if !scanner.Scan() {
+		return
+	}
+	reason := strings.TrimSpace(scanner.Text())
+
+	switch action {
+	case "1":
+		if err := userManager.UnlockUser(username, reason); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		fmt.Printf(theme.Success+"Учетная запись '%s' разблокирована\n", username)
+		return
+	case "3", "4":
+		admin := action == "3"
+		if err := userManager.SetAdmin(username, admin, reason); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		if username == session.Username {
+			session.SignIn(username, userManager.UserPrincipal(username))
+		}
+		if admin {
+			fmt.Printf(theme.Success+"Пользователь '%s' назначен администратором\n", username)
+		} else {
+			fmt.Printf(theme.Success+"У пользователя '%s' сняты права администратора\n", username)
+		}
+		return
+	case "5":
+		if err := userManager.SetRole(username, role, reason); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		if username == session.Username {
+			session.SignIn(username, userManager.UserPrincipal(username))
+		}
+		fmt.Printf(theme.Success+"Пользователю '%s' назначена роль %s\n", username, describeRole(userManager.UserRole(username)))
+		return
+	}
+
+	if err := userManager.DisableUser(username, reason); err != nil {
+		fmt.Printf(" Ошибка: %v\n", err)
+		return
+	}
+	fmt.Printf(theme.Success+"Учетная запись '%s' отключена. Вход и смена пароля запрещены до разблокировки.\n", username)
+}
+
+// renameMergeMenu - переименование учетной записи и объединение дубликатов
+func renameMergeMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== ПЕРЕИМЕНОВАНИЕ И ОБЪЕДИНЕНИЕ ===")
+	fmt.Println("1. Переименовать учетную запись")
+	fmt.Println("2. Объединить дубликат с основной учетной записью")
+	fmt.Print("Выберите действие (1-2): ")
+	if !scanner.Scan() {
+		return
+	}
+	action := strings.TrimSpace(scanner.Text())
+	if action != "1" && action != "2" {
+		fmt.Println(" Неверный выбор.")
+		return
+	}
+
+	first, second := "Текущий логин: ", "Новый логин: "
+	if action == "2" {
+		first, second = "Основная учетная запись: ", "Дубликат (будет удален): "
+	}
+	fmt.Print(first)
+	if !scanner.Scan() {
+		return
+	}
+	firstName := strings.TrimSpace(scanner.Text())
+	fmt.Print(second)
+	if !scanner.Scan() {
+		return
+	}
+	secondName := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Причина (для журнала аудита): ")
+	if !scanner.Scan() {
+		return
+	}
+	reason := strings.TrimSpace(scanner.Text())
+
+	if action == "1" {
+		if err := userManager.RenameUser(firstName, secondName, reason); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		fmt.Printf(theme.Success+"Учетная запись '%s' переименована в '%s'\n", firstName, secondName)
+		return
+	}
+
+	if err := userManager.MergeUsers(firstName, secondName, reason); err != nil {
+		fmt.Printf(" Ошибка: %v\n", err)
+		return
+	}
+	if reportDryRun(userManager) {
+		return
+	}
+	fmt.Printf(theme.Success+"Учетная запись '%s' объединена с '%s' и удалена\n", secondName, firstName)
+}
+
+// deletedAccountsMenu - просмотр и восстановление удаленных учетных записей
+func deletedAccountsMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== УДАЛЕННЫЕ УЧЕТНЫЕ ЗАПИСИ ===")
+	if userManager.DeletionRetention() == 0 {
+		fmt.Println("Удаленные учетные записи не хранятся (флаг -deletion-retention).")
+		return
+	}
+
+	accounts := userManager.DeletedAccounts()
+	if len(accounts) == 0 {
+		fmt.Println("Удаленных учетных записей нет.")
+		return
+	}
+	for _, account := range accounts {
+		fmt.Printf("  "+theme.Bullet+" %-20s удалена %s, окончательное удаление %s\n", account.User.Username,
+			account.DeletedAt.Format("2006-01-02 15:04"), account.PurgeAt.Format("2006-01-02 15:04"))
+	}
+
+	fmt.Print("\nЛогин для восстановления (пусто - выход): ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+	if username == "" {
+		return
+	}
+
+	fmt.Print("Причина (для журнала аудита): ")
+	if !scanner.Scan() {
+		return
+	}
+	reason := strings.TrimSpace(scanner.Text())
+
+	if err := userManager.RestoreUser(username, reason); err != nil {
+		fmt.Printf(" Ошибка: %v\n", err)
+		return
+	}
+	fmt.Printf(theme.Success+"Учетная запись '%s' восстановлена\n", username)
+}
+
+// honeypotMenu - создание учетных записей-ловушек и просмотр срабатываний
+func honeypotMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== УЧЕТНЫЕ ЗАПИСИ-ЛОВУШКИ ===")
+	fmt.Println("1. Создать ловушку")
+	fmt.Println("2. Список ловушек и срабатываний")
+	fmt.Print("Выберите действие (1-2): ")
+	if !scanner.Scan() {
+		return
+	}
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		fmt.Print("Логин ловушки (например admin, backup): ")
+		if !scanner.Scan() {
+			return
+		}
+		username := strings.TrimSpace(scanner.Text())
+		if err := userManager.CreateHoneypot(username); err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+			return
+		}
+		fmt.Printf(theme.Success+"Ловушка '%s' создана. Войти в нее невозможно, любая попытка поднимет тревогу.\n", username)
+	case "2":
+		honeypots := userManager.Honeypots()
+		if len(honeypots) == 0 {
+			fmt.Println("Ловушки не созданы")
+			return
+		}
+		fmt.Printf("Ловушки: %s\n", strings.Join(honeypots, ", "))
+
+		hits := userManager.HoneypotHits()
+		if len(hits) == 0 {
+			fmt.Println("Срабатываний не было")
+			return
+		}
+		fmt.Printf("\n Срабатывания (%d):\n", len(hits))
+		for _, hit := range hits {
+			fmt.Printf("   "+theme.Bullet+" %s  %s\n", hit.Time.Format("2006-01-02 15:04:05"), hit.Username)
+		}
+	default:
+		fmt.Println(" Неверный выбор.")
+	}
+}
+
+// recheckPasswords запускает кампанию проверки паролей существующих пользователей
+func recheckPasswords(userManager *UserManager) {
+	fmt.Println("=== ПРОВЕРКА ПАРОЛЕЙ ПОЛЬЗОВАТЕЛЕЙ ===")
+
+	result := userManager.RunPasswordRecheck(time.Now())
+	fmt.Printf("Проверено учетных записей: %d\n", result.Checked)
+	fmt.Printf("Смена пароля уже назначена: %d\n", result.Pending)
+
+	if len(result.Scheduled) == 0 {
+		fmt.Println(theme.Success + "Новых учетных записей, требующих смены пароля, не найдено")
+		return
+	}
+
+	fmt.Printf("\n Назначена смена пароля (%d):\n", len(result.Scheduled))
+	for _, notice := range result.Scheduled {
+		fmt.Printf("   "+theme.Bullet+" %-20s до %s - %s\n", notice.Username, notice.Due.Format("2006-01-02"), notice.Reason)
+	}
+	fmt.Println("\n Пользователи получат уведомление при входе; после срока вход возможен только после смены пароля.")
+	fmt.Println("   Стойкость паролей проверяется при следующем успешном входе каждого пользователя.")
+}
+
+// warnPasswordExpiry предупреждает о скором истечении срока пароля и предлагает сменить его сразу
+func warnPasswordExpiry(userManager *UserManager, scanner *bufio.Scanner, username string, outcome AuthOutcome) {
+	days := int(math.Ceil(time.Until(outcome.PasswordExpiresAt).Hours() / 24))
+	fmt.Println()
+	printBox("",
+		fmt.Sprintf("  Пароль истекает через %d дн.", days),
+		"Срок: "+outcome.PasswordExpiresAt.Format("2006-01-02"),
+	)
+	fmt.Printf("Причина: %s\n", outcome.ExpiryReason)
+
+	fmt.Print("Сменить пароль сейчас? (да/нет): ")
+	if !scanner.Scan() {
+		return
+	}
+	if !isYes(scanner.Text()) {
+		fmt.Println("   Сменить пароль можно позже (пункт 3).")
+		return
+	}
+	fmt.Println()
+	changePassword(userManager, username)
+}
+
+func changeUserPassword(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== СМЕНА ПАРОЛЯ (РАЗБЛОКИРОВКА) ===")
+	
+	// Ввод логина
+	fmt.Print("Логин пользователя: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	if username == "" {
+		fmt.Println(" Логин не может быть пустым.")
+		return
+	}
+	if !session.CanManage(username, PermUserWrite) && !requirePermission(userManager, scanner, PermUserWrite) {
+		fmt.Println(" Сменить чужой пароль может только пользователь с правом user.write.")
+		return
+	}
+
+	changePassword(userManager, username)
+}
+
+// changePassword запрашивает новый пароль пользователя и меняет его
+func changePassword(userManager *UserManager, username string) {
+	// Ввод нового пароля
+	fmt.Print("Новый пароль: ")
+	newPassword, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return
+	}
+
+	// Подтверждение пароля
+	fmt.Print("Подтвердите новый пароль: ")
+	confirmPassword, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return
+	}
+
+	if newPassword != confirmPassword {
+		fmt.Println(" Пароли не совпадают.")
+		return
+	}
+
+	// Попытка смены пароля
+	err = userManager.ChangePassword(username, newPassword)
+	if err != nil {
+		fmt.Printf(" Ошибка при смене пароля: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Пароль для пользователя '%s' успешно изменен!\n", username)
+	fmt.Println("   Пользователь разблокирован и может войти в систему.")
+	fmt.Print(AnalyzeStrength(newPassword).Format())
+}
+
+func showUserStatus(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== СТАТУС ПОЛЬЗОВАТЕЛЯ ===")
+	
+	fmt.Print("Введите логин пользователя: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	if username == "" {
+		fmt.Println(" Логин не может быть пустым.")
+		return
+	}
+	if !session.CanManage(username, PermUserRead) && !requirePermission(userManager, scanner, PermUserRead) {
+		fmt.Println(" Статус чужой учетной записи доступен только пользователю с правом user.read.")
+		return
+	}
+
+	status, err := userManager.GetUserStatus(username)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Println("\n Статус пользователя:")
+	fmt.Print(status)
+
+	// В терминале срок блокировки отсчитывается до снятия или до нажатия Enter
+	if expiresAt := userManager.LockExpiresAt(username); !expiresAt.IsZero() && stdinIsTerminal() && stdoutIsTerminal() {
+		showLockCountdown(expiresAt, scanner)
+	}
+}
+
+// showLockCountdown обновляет оставшееся время блокировки раз в секунду, пока
+// пользователь не нажмет Enter; по истечении срока сообщает, что вход снова возможен
+func showLockCountdown(expiresAt time.Time, scanner *bufio.Scanner) {
+	pressed := make(chan struct{})
+	go func() {
+		scanner.Scan()
+		close(pressed)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		remaining := time.Until(expiresAt)
+		if remaining <= 0 {
+			fmt.Print("\r Срок блокировки истек, вход снова возможен. Enter - продолжить")
+			<-pressed
+			return
+		}
+		// Пробелы в конце стирают остаток более длинной предыдущей строки
+		fmt.Printf("\r До снятия блокировки: %s (Enter - продолжить)   ", FormatCountdown(remaining))
+		select {
+		case <-pressed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func setLoginSchedule(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== РАСПИСАНИЕ ВХОДА ===")
+
+	fmt.Print("Логин пользователя: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Дни недели (1-5, 1,3,6; 1 - Пн; Enter - любые, \"-\" - снять расписание): ")
+	if !scanner.Scan() {
+		return
+	}
+	daysStr := strings.TrimSpace(scanner.Text())
+	if daysStr == "-" {
+		if err := userManager.SetLoginSchedule(username, nil); err != nil {
+			fmt.Printf(" %v\n", err)
+			return
+		}
+		fmt.Println(theme.Success + "Ограничения по расписанию сняты")
+		return
+	}
+	weekdays, err := ParseWeekdays(daysStr)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Print("Время входа (например 09:00-18:00; Enter - круглосуточно): ")
+	if !scanner.Scan() {
+		return
+	}
+	start, end, err := ParseTimeRange(scanner.Text())
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	schedule := &LoginSchedule{Weekdays: weekdays, StartTime: start, EndTime: end}
+
+	fmt.Print("Действует с (ГГГГ-ММ-ДД; Enter - без ограничения): ")
+	if !scanner.Scan() {
+		return
+	}
+	if value := strings.TrimSpace(scanner.Text()); value != "" {
+		if schedule.ValidFrom, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+			fmt.Printf(" Некорректная дата: %s\n", value)
+			return
+		}
+	}
+
+	fmt.Print("Действует по (ГГГГ-ММ-ДД включительно; Enter - без ограничения): ")
+	if !scanner.Scan() {
+		return
+	}
+	if value := strings.TrimSpace(scanner.Text()); value != "" {
+		if schedule.ValidUntil, err = time.ParseInLocation("2006-01-02", value, time.Local); err != nil {
+			fmt.Printf(" Некорректная дата: %s\n", value)
+			return
+		}
+	}
+
+	if err := userManager.SetLoginSchedule(username, schedule); err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+	fmt.Printf(theme.Success+"Расписание входа для '%s': %s\n", username, schedule.String())
+}
+
+func personalDataMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== МОИ ДАННЫЕ ===")
+
+	// Доступ к данным только после подтверждения личности паролем
+	fmt.Print("Логин: ")
+	if !scanner.Scan() {
+		return
+	}
+	username := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Пароль: ")
+	password, err := readPassword()
+	if err != nil {
+		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		return
+	}
+
+	outcome, err := userManager.AuthenticateUser(username, password)
+	if err != nil {
+		fmt.Printf(" Ошибка при входе: %v\n", err)
+		return
+	}
+	if outcome.Result != AuthSuccess {
+		fmt.Printf(" %s\n", outcome)
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("1. Выгрузить все мои данные (JSON)")
+	fmt.Println("2. Удалить мою учетную запись")
+	if userManager.DuressEnabled() {
+		fmt.Println("3. Задать пароль под принуждением")
+	}
+	fmt.Print("Выберите действие: ")
+	if !scanner.Scan() {
+		return
+	}
+	fmt.Println()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		export, err := userManager.ExportUserData(username)
+		if err != nil {
+			fmt.Printf(" %v\n", err)
+			return
+		}
+		data, err := export.JSON()
+		if err != nil {
+			fmt.Printf(" %v\n", err)
+			return
+		}
+
+		fmt.Print("Файл для сохранения (Enter - вывести на экран): ")
+		if !scanner.Scan() {
+			return
+		}
+		if path := strings.TrimSpace(scanner.Text()); path != "" {
+			if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+				fmt.Printf(" Ошибка сохранения: %v\n", err)
+				return
+			}
+			fmt.Printf(theme.Success+"Данные сохранены в %s\n", path)
+			return
+		}
+		fmt.Print(data)
+	case "2":
+		if retention := userManager.DeletionRetention(); retention > 0 {
+			fmt.Printf("Восстановить учетную запись сможет только администратор в течение %v.\n", retention)
+		} else {
+			fmt.Println("Удаление необратимо.")
+		}
+		fmt.Printf("Для подтверждения введите логин '%s': ", username)
+		if !scanner.Scan() {
+			return
+		}
+		if strings.TrimSpace(scanner.Text()) != username {
+			fmt.Println(" Удаление отменено.")
+			return
+		}
+
+		if _, err := userManager.EraseUser(username); err != nil {
+			fmt.Printf(" %v\n", err)
+			return
+		}
+		if reportDryRun(userManager) {
+			return
+		}
+		fmt.Println(theme.Success + "Учетная запись удалена.")
+		fmt.Println("   Записи журнала аудита с вашим логином будут удалены по истечении срока хранения журнала.")
+	case "3":
+		if !userManager.DuressEnabled() {
+			fmt.Println(" Неверный выбор.")
+			return
+		}
+		fmt.Println("Вход этим паролем будет выглядеть обычным, но поднимет скрытую тревогу.")
+		fmt.Print("Пароль под принуждением: ")
+		duressPassword, err := readPassword()
+		if err != nil {
+			fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+			return
+		}
+		if err := userManager.SetDuressPassword(username, duressPassword); err != nil {
+			fmt.Printf(" %v\n", err)
+			return
+		}
+		fmt.Println(theme.Success + "Пароль под принуждением сохранен.")
+	default:
+		fmt.Println(" Неверный выбор.")
+	}
+}
+
+// usersPageSize - число пользователей на странице списка
+const usersPageSize = 50
+
+func showAllUsers(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== СПИСОК ВСЕХ ПОЛЬЗОВАТЕЛЕЙ ===")
+	for offset := 0; ; offset += usersPageSize {
+		total, err := userManager.WriteUsersStatus(os.Stdout, offset, usersPageSize)
+		if err != nil {
+			fmt.Printf(" Ошибка вывода: %v\n", err)
+			return
+		}
+		if offset+usersPageSize >= total {
+			fmt.Println()
+			return
+		}
+
+		fmt.Printf("-- показано %d из %d. Enter - следующая страница, q - выход: ", offset+usersPageSize, total)
+		if !scanner.Scan() || strings.EqualFold(strings.TrimSpace(scanner.Text()), "q") {
+			return
+		}
+	}
+}
+
+func showActivityReport(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== ОТЧЕТ ОБ АКТИВНОСТИ ===")
+
+	fmt.Print("Период отчета (например 30d, 2w, 12h; по умолчанию 30d): ")
+	if !scanner.Scan() {
+		return
+	}
+	periodStr := strings.TrimSpace(scanner.Text())
+	if periodStr == "" {
+		periodStr = "30d"
+	}
+	period, err := ParsePeriod(periodStr)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Print("Считать неактивными после (по умолчанию 90d): ")
+	if !scanner.Scan() {
+		return
+	}
+	dormantStr := strings.TrimSpace(scanner.Text())
+	if dormantStr == "" {
+		dormantStr = "90d"
+	}
+	dormantAfter, err := ParsePeriod(dormantStr)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Print("Формат (table/json/csv, по умолчанию table): ")
+	if !scanner.Scan() {
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(scanner.Text()))
 
-	"golang.org/x/term"
-)
+	report := userManager.ActivityReport(time.Now().Add(-period), dormantAfter, 5)
+
+	var output string
+	switch format {
+	case "", "table":
+		output = report.FormatTable()
+	case "json":
+		output, err = report.FormatJSON()
+	case "csv":
+		output, err = report.FormatCSV()
+	default:
+		fmt.Printf(" Неизвестный формат: %s\n", format)
+		return
+	}
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
 
-func main() {
-	fmt.Println("=== СИСТЕМА УПРАВЛЕНИЯ ПОЛЬЗОВАТЕЛЯМИ ===")
-	fmt.Println("Версия 1.0")
 	fmt.Println()
+	fmt.Print(output)
+}
 
-	userManager := NewUserManager()
-	scanner := bufio.NewScanner(os.Stdin)
+func auditMenu(path string, scanner *bufio.Scanner) {
+	fmt.Println("=== ЖУРНАЛ АУДИТА ===")
 
-	for {
-		showMainMenu()
-		
-		fmt.Print("Выберите действие (1-8): ")
-		if !scanner.Scan() {
-			break
-		}
-		
-		choice := strings.TrimSpace(scanner.Text())
-		fmt.Println()
+	if path == "" {
+		fmt.Println(" Журнал аудита отключен.")
+		return
+	}
 
-		switch choice {
-		case "1":
-			registerUser(userManager, scanner)
-		case "2":
-			authenticateUser(userManager, scanner)
-		case "3":
-			changeUserPassword(userManager, scanner)
-		case "4":
-			showUserStatus(userManager, scanner)
-		case "5":
-			showAllUsers(userManager)
-		case "6":
-			generatePasswordDemo()
-		case "7":
-			showPasswordRules()
-		case "8":
-			fmt.Println("Спасибо за использование системы!")
-			return
-		default:
-			fmt.Println(" Неверный выбор. Пожалуйста, выберите от 1 до 8.")
-		}
+	fmt.Println("1. Проверить целостность журнала")
+	fmt.Println("2. Экспортировать подписанные записи за период")
+	fmt.Println("3. Проверить экспортированный пакет")
+	fmt.Print("Выберите действие (1-3): ")
+	if !scanner.Scan() {
+		return
+	}
+	fmt.Println()
 
-		fmt.Println()
-		fmt.Print("Нажмите Enter для продолжения...")
-		scanner.Scan()
-		fmt.Println()
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		verifyAuditLog(path)
+	case "2":
+		exportAuditLog(path, scanner)
+	case "3":
+		verifyAuditExport(scanner)
+	default:
+		fmt.Println(" Неверный выбор.")
 	}
 }
 
-func showMainMenu() {
-	fmt.Println("┌─────────────────────────────────────────┐")
-	fmt.Println("│              ГЛАВНОЕ МЕНЮ               │")
-	fmt.Println("├─────────────────────────────────────────┤")
-	fmt.Println("│ 1. Регистрация пользователя             │")
-	fmt.Println("│ 2. Вход в систему                       │")
-	fmt.Println("│ 3. Смена пароля (разблокировка)         │")
-	fmt.Println("│ 4. Статус пользователя                  │")
-	fmt.Println("│ 5. Список всех пользователей            │")
-	fmt.Println("│ 6. Генерация безопасного пароля         │")
-	fmt.Println("│ 7. Правила создания паролей             │")
-	fmt.Println("│ 8. Выход                                │")
-	fmt.Println("└─────────────────────────────────────────┘")
+func verifyAuditLog(path string) {
+	result, err := VerifyAuditLog(path)
+	if err != nil {
+		fmt.Printf(" Нарушена целостность журнала: %v\n", err)
+		return
+	}
+
+	fmt.Printf(theme.Success+"Журнал не изменялся: проверено записей - %d, файлов - %d\n", result.Records, result.Files)
 }
 
-func registerUser(userManager *UserManager, scanner *bufio.Scanner) {
-	fmt.Println("=== РЕГИСТРАЦИЯ НОВОГО ПОЛЬЗОВАТЕЛЯ ===")
-	
-	// Ввод логина
-	fmt.Print("Введите логин: ")
+func exportAuditLog(path string, scanner *bufio.Scanner) {
+	fmt.Print("Начало периода (ГГГГ-ММ-ДД, по умолчанию 30 дней назад): ")
 	if !scanner.Scan() {
 		return
 	}
-	username := strings.TrimSpace(scanner.Text())
+	from := time.Now().AddDate(0, 0, -30)
+	if value := strings.TrimSpace(scanner.Text()); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			fmt.Printf(" Некорректная дата: %s\n", value)
+			return
+		}
+		from = parsed
+	}
 
-	if username == "" {
-		fmt.Println(" Логин не может быть пустым.")
+	fmt.Print("Конец периода (ГГГГ-ММ-ДД включительно, по умолчанию сейчас): ")
+	if !scanner.Scan() {
 		return
 	}
+	to := time.Now()
+	if value := strings.TrimSpace(scanner.Text()); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			fmt.Printf(" Некорректная дата: %s\n", value)
+			return
+		}
+		to = parsed.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
 
-	// Ввод пароля
-	fmt.Print("Введите пароль: ")
-	password, err := readPassword()
+	fmt.Print("Файл ключа подписи (по умолчанию audit-signing.key): ")
+	if !scanner.Scan() {
+		return
+	}
+	keyPath := strings.TrimSpace(scanner.Text())
+	if keyPath == "" {
+		keyPath = "audit-signing.key"
+	}
+
+	fmt.Print("Файл пакета (по умолчанию audit-export.json): ")
+	if !scanner.Scan() {
+		return
+	}
+	outPath := strings.TrimSpace(scanner.Text())
+	if outPath == "" {
+		outPath = "audit-export.json"
+	}
+
+	key, created, err := LoadOrCreateSigningKey(keyPath)
 	if err != nil {
-		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		fmt.Printf(" %v\n", err)
 		return
 	}
+	if created {
+		fmt.Printf(" Создан новый ключ подписи: %s\n", keyPath)
+	}
 
-	// Попытка регистрации
-	err = userManager.RegisterUser(username, password)
+	export, err := ExportAuditRecords(path, from, to, key)
 	if err != nil {
-		fmt.Printf(" Ошибка регистрации: %v\n", err)
+		fmt.Printf(" Ошибка экспорта: %v\n", err)
+		return
+	}
+	if err := WriteAuditExport(outPath, export); err != nil {
+		fmt.Printf(" %v\n", err)
 		return
 	}
 
-	fmt.Printf("✅ Пользователь '%s' успешно зарегистрирован!\n", username)
+	fmt.Printf(theme.Success+"Экспортировано записей: %d в %s\n", len(export.Records), outPath)
+	fmt.Printf("   Отпечаток ключа подписи (SHA-256): %s\n", KeyFingerprint(key.Public().(ed25519.PublicKey)))
+	fmt.Println("   Передайте отпечаток или открытый ключ аудитору по независимому каналу")
 }
 
-func authenticateUser(userManager *UserManager, scanner *bufio.Scanner) {
-	fmt.Println("=== ВХОД В СИСТЕМУ ===")
-	
-	// Ввод логина
-	fmt.Print("Логин: ")
+func verifyAuditExport(scanner *bufio.Scanner) {
+	fmt.Print("Файл пакета: ")
 	if !scanner.Scan() {
 		return
 	}
-	username := strings.TrimSpace(scanner.Text())
+	export, err := ReadAuditExport(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
 
-	if username == "" {
-		fmt.Println(" Логин не может быть пустым.")
+	fmt.Print("Файл открытого ключа или отпечаток SHA-256, полученный по независимому каналу: ")
+	if !scanner.Scan() {
 		return
 	}
+	keyPath, fingerprint := "", strings.TrimSpace(scanner.Text())
+	if _, err := os.Stat(fingerprint); err == nil {
+		keyPath, fingerprint = fingerprint, ""
+	}
 
-	// Ввод пароля
-	fmt.Print("Пароль: ")
-	password, err := readPassword()
+	trusted, err := TrustedAuditKey(export, keyPath, fingerprint)
+	if err == nil {
+		err = VerifyAuditExport(export, trusted)
+	}
 	if err != nil {
-		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		fmt.Printf(" Пакет не прошел проверку: %v\n", err)
 		return
 	}
 
-	// Попытка аутентификации
-	result, err := userManager.AuthenticateUser(username, password)
-	if err != nil {
-		fmt.Printf(" Ошибка при входе: %v\n", err)
+	fmt.Printf(theme.Success+"Подпись действительна, записей: %d\n", len(export.Records))
+	fmt.Printf("   Период: %s - %s\n", export.From.Local().Format("2006-01-02 15:04:05"), export.To.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("   Отпечаток ключа: %s\n", KeyFingerprint(trusted))
+}
+
+func importExportMenu(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Println("=== ИМПОРТ/ЭКСПОРТ ПОЛЬЗОВАТЕЛЕЙ ===")
+
+	fmt.Println("1. Импорт из htpasswd или /etc/shadow")
+	fmt.Println("2. Экспорт в htpasswd (bcrypt)")
+	fmt.Println("3. Массовое создание учетных записей (пароли в файл менеджера паролей)")
+	fmt.Print("Выберите действие (1-3): ")
+	if !scanner.Scan() {
 		return
 	}
+	fmt.Println()
 
-	switch result {
-	case AuthSuccess:
-		fmt.Printf(" Добро пожаловать, %s!\n", username)
-	case AuthUserNotFound:
-		fmt.Println(" Пользователь не найден.")
-	case AuthInvalidCredentials:
-		fmt.Println(" Неверный логин или пароль.")
-		// Показываем статус после неудачной попытки
-		if status, err := userManager.GetUserStatus(username); err == nil {
-			fmt.Println("\n Текущий статус:")
-			fmt.Print(status)
-		}
-	case AuthUserBlocked:
-		fmt.Println("	Пользователь заблокирован после превышения лимита неудачных попыток входа.")
-		fmt.Println("   Для разблокировки используйте опцию смены пароля.")
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		importUsers(userManager, scanner)
+	case "2":
+		exportHtpasswd(userManager, scanner)
+	case "3":
+		provisionUsers(userManager, scanner)
+	default:
+		fmt.Println(" Неверный выбор.")
 	}
 }
 
-func changeUserPassword(userManager *UserManager, scanner *bufio.Scanner) {
-	fmt.Println("=== СМЕНА ПАРОЛЯ (РАЗБЛОКИРОВКА) ===")
-	
-	// Ввод логина
-	fmt.Print("Логин пользователя: ")
+func exportHtpasswd(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Print("Путь к файлу htpasswd: ")
 	if !scanner.Scan() {
 		return
 	}
-	username := strings.TrimSpace(scanner.Text())
+	path := strings.TrimSpace(scanner.Text())
+	if path == "" {
+		fmt.Println(" Путь не может быть пустым.")
+		return
+	}
 
-	if username == "" {
-		fmt.Println(" Логин не может быть пустым.")
+	count, err := userManager.WriteHtpasswdFile(path)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
 		return
 	}
 
-	// Ввод нового пароля
-	fmt.Print("Новый пароль: ")
-	newPassword, err := readPassword()
+	fmt.Printf(theme.Success+"Экспортировано пользователей: %d в %s\n", count, path)
+	fmt.Println("   Заблокированные пользователи и пользователи без bcrypt-хеша пропущены")
+}
+
+func provisionUsers(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Print("Файл со списком логинов (по одному в строке): ")
+	if !scanner.Scan() {
+		return
+	}
+	listPath := strings.TrimSpace(scanner.Text())
+
+	fmt.Print("Формат для менеджера паролей (keepass/bitwarden/1password): ")
+	if !scanner.Scan() {
+		return
+	}
+	format, err := ParseVaultFormat(scanner.Text())
 	if err != nil {
-		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		fmt.Printf(" %v\n", err)
 		return
 	}
 
-	// Подтверждение пароля
-	fmt.Print("Подтвердите новый пароль: ")
-	confirmPassword, err := readPassword()
+	fmt.Print("Файл для учетных данных: ")
+	if !scanner.Scan() {
+		return
+	}
+	vaultPath := strings.TrimSpace(scanner.Text())
+	if vaultPath == "" {
+		fmt.Println(" Путь не может быть пустым.")
+		return
+	}
+
+	list, err := os.Open(listPath)
 	if err != nil {
-		fmt.Printf(" Ошибка при вводе пароля: %v\n", err)
+		fmt.Printf(" Не удалось открыть файл: %v\n", err)
 		return
 	}
+	defer list.Close()
 
-	if newPassword != confirmPassword {
-		fmt.Println(" Пароли не совпадают.")
+	// В пробном запуске пароли не выдаются, и файл не создается
+	if userManager.DryRun() {
+		result, err := userManager.ProvisionUsers(list)
+		if err != nil {
+			fmt.Printf(" Ошибка: %v\n", err)
+		}
+		reportDryRun(userManager)
+		for _, skip := range result.Skipped {
+			fmt.Printf("  Пропущено: строка %d (%s): %s\n", skip.Line, skip.Username, skip.Reason)
+		}
 		return
 	}
 
-	// Попытка смены пароля
-	err = userManager.ChangePassword(username, newPassword)
+	// Файл с паролями создается до изменения учетных записей, чтобы пароли не потерялись
+	vault, err := os.OpenFile(vaultPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		fmt.Printf(" Ошибка при смене пароля: %v\n", err)
+		fmt.Printf(" Не удалось создать файл: %v\n", err)
 		return
 	}
+	defer vault.Close()
 
-	fmt.Printf("Пароль для пользователя '%s' успешно изменен!\n", username)
-	fmt.Println("   Пользователь разблокирован и может войти в систему.")
+	result, err := userManager.ProvisionUsers(list)
+	if err != nil {
+		fmt.Printf(" Ошибка: %v\n", err)
+	}
+	if err := WriteVault(vault, format, "Система управления пользователями", result.Created); err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Printf("\n"+theme.Success+"Создано учетных записей: %d, учетные данные записаны в %s\n", len(result.Created), vaultPath)
+	fmt.Println("   Пароли временные: при первом входе пользователям потребуется их сменить.")
+	fmt.Println("   Передайте файл пользователям защищенным каналом и удалите его после импорта.")
+	if len(result.Skipped) > 0 {
+		fmt.Printf("   Пропущено: %d\n", len(result.Skipped))
+		for _, skip := range result.Skipped {
+			fmt.Printf("     "+theme.Bullet+" строка %d (%s): %s\n", skip.Line, skip.Username, skip.Reason)
+		}
+	}
 }
 
-func showUserStatus(userManager *UserManager, scanner *bufio.Scanner) {
-	fmt.Println("=== СТАТУС ПОЛЬЗОВАТЕЛЯ ===")
-	
-	fmt.Print("Введите логин пользователя: ")
+func importUsers(userManager *UserManager, scanner *bufio.Scanner) {
+	fmt.Print("Формат файла (htpasswd/shadow): ")
 	if !scanner.Scan() {
 		return
 	}
-	username := strings.TrimSpace(scanner.Text())
+	format := ImportFormat(strings.ToLower(strings.TrimSpace(scanner.Text())))
 
-	if username == "" {
-		fmt.Println(" Логин не может быть пустым.")
+	fmt.Print("Путь к файлу: ")
+	if !scanner.Scan() {
 		return
 	}
+	path := strings.TrimSpace(scanner.Text())
 
-	status, err := userManager.GetUserStatus(username)
+	file, err := os.Open(path)
 	if err != nil {
-		fmt.Printf(" %v\n", err)
+		fmt.Printf(" Не удалось открыть файл: %v\n", err)
 		return
 	}
+	defer file.Close()
 
-	fmt.Println("\n Статус пользователя:")
-	fmt.Print(status)
-}
+	result, err := userManager.ImportCredentials(file, format)
+	if err != nil {
+		fmt.Printf(" Ошибка импорта: %v\n", err)
+		return
+	}
+	if reportDryRun(userManager) {
+		for _, skip := range result.Skipped {
+			fmt.Printf("  Пропущено: строка %d: %s\n", skip.Line, skip.Reason)
+		}
+		return
+	}
 
-func showAllUsers(userManager *UserManager) {
-	fmt.Println("=== СПИСОК ВСЕХ ПОЛЬЗОВАТЕЛЕЙ ===")
-	status := userManager.GetAllUsersStatus()
-	fmt.Println(status)
+	fmt.Printf("\n"+theme.Success+"Перенесено с bcrypt: %d\n", len(result.Imported))
+	fmt.Printf("   Перенесено с унаследованным хешем (перехеширование при входе): %d\n", len(result.Migrated))
+	fmt.Printf("   Требуют смены пароля (схема не поддерживается): %d\n", len(result.ForcedReset))
+	for _, username := range result.ForcedReset {
+		fmt.Printf("     "+theme.Bullet+" %s\n", username)
+	}
+	if len(result.Skipped) > 0 {
+		fmt.Printf("   Пропущено строк: %d\n", len(result.Skipped))
+		for _, skip := range result.Skipped {
+			if skip.Username != "" {
+				fmt.Printf("     "+theme.Bullet+" строка %d (%s): %s\n", skip.Line, skip.Username, skip.Reason)
+			} else {
+				fmt.Printf("     "+theme.Bullet+" строка %d: %s\n", skip.Line, skip.Reason)
+			}
+		}
+	}
 }
 
-func generatePasswordDemo() {
+func generatePasswordDemo(scanner *bufio.Scanner) {
 	fmt.Println("=== ГЕНЕРАЦИЯ БЕЗОПАСНОГО ПАРОЛЯ ===")
 	
-	scanner := bufio.NewScanner(os.Stdin)
-	
 	fmt.Print("Введите желаемую длину пароля (минимум 12, по умолчанию 16): ")
 	scanner.Scan()
 	lengthStr := strings.TrimSpace(scanner.Text())
@@ -250,11 +2429,30 @@ func generatePasswordDemo() {
 		}
 	}
 
+	ids := make([]string, len(TargetProfiles))
+	for i, profile := range TargetProfiles {
+		ids[i] = profile.ID
+	}
+	fmt.Printf("Целевая система (%s; Enter - без ограничений): ", strings.Join(ids, ", "))
+	scanner.Scan()
+	var target TargetConstraints
+	if id := strings.TrimSpace(scanner.Text()); id != "" {
+		if profile, ok := FindTargetProfile(id); ok {
+			target = profile
+		} else {
+			fmt.Printf("  Неизвестная целевая система %q, пароли без ограничений\n", id)
+		}
+	}
+	if target.MaxLength > 0 && length > target.MaxLength {
+		length = target.MaxLength
+		fmt.Printf("  Длина уменьшена до %d: больше не допускает %s\n", length, target.Name)
+	}
+
 	// Генерируем несколько вариантов паролей
 	fmt.Printf("\n Сгенерированные пароли (длина: %d символов):\n\n", length)
 	
 	for i := 1; i <= 5; i++ {
-		password, err := GenerateSecurePassword(length)
+		password, err := GenerateSecurePasswordFor(length, target)
 		if err != nil {
 			fmt.Printf(" Ошибка при генерации пароля: %v\n", err)
 			return
@@ -262,63 +2460,120 @@ func generatePasswordDemo() {
 		fmt.Printf("%d. %s\n", i, password)
 	}
 
-	fmt.Println("\n💡 Рекомендации:")
-	fmt.Println("   • Сохраните выбранный пароль в безопасном месте")
-	fmt.Println("   • Не используйте один пароль для разных аккаунтов")
-	fmt.Println("   • Регулярно меняйте пароли")
+	// Все варианты одной длины и из одного алфавита - оценка общая
+	if password, err := GenerateSecurePasswordFor(length, target); err == nil {
+		fmt.Println()
+		fmt.Print(AnalyzeStrength(password).Format())
+	}
+
+	fmt.Println("\n" + theme.Hint + "Рекомендации:")
+	fmt.Println("   " + theme.Bullet + " Сохраните выбранный пароль в безопасном месте")
+	fmt.Println("   " + theme.Bullet + " Не используйте один пароль для разных аккаунтов")
+	fmt.Println("   " + theme.Bullet + " Регулярно меняйте пароли")
 }
 
-func showPasswordRules() {
+func showPasswordRules(userManager *UserManager, scanner *bufio.Scanner) {
 	fmt.Println("=== ПРАВИЛА СОЗДАНИЯ БЕЗОПАСНЫХ ПАРОЛЕЙ ===")
 	
-	rules := DefaultPasswordRules()
+	rules := userManager.PasswordRules()
 	
 	fmt.Printf(" Требования к паролям в системе:\n\n")
-	fmt.Printf("• Минимальная длина: %d символов\n", rules.Length)
+	fmt.Printf(theme.Bullet+" Минимальная длина: %d символов\n", rules.Length)
 	if rules.RequireUppercase {
-		fmt.Printf("• Заглавные буквы (A-Z): минимум %d\n", rules.MinUppercase)
+		fmt.Printf(theme.Bullet+" Заглавные буквы (A-Z): минимум %d\n", rules.MinUppercase)
 	}
 	if rules.RequireLowercase {
-		fmt.Printf("• Строчные буквы (a-z): минимум %d\n", rules.MinLowercase)
+		fmt.Printf(theme.Bullet+" Строчные буквы (a-z): минимум %d\n", rules.MinLowercase)
 	}
 	if rules.RequireDigits {
-		fmt.Printf("• Цифры (0-9): минимум %d\n", rules.MinDigits)
+		fmt.Printf(theme.Bullet+" Цифры (0-9): минимум %d\n", rules.MinDigits)
 	}
 	if rules.RequireSpecial {
-		fmt.Printf("• Специальные символы (!@#$%%^&*()_+-=[]{}|;:,.<>?): минимум %d\n", rules.MinSpecial)
+		fmt.Printf(theme.Bullet+" Специальные символы (!@#$%%^&*()_+-=[]{}|;:,.<>?): минимум %d\n", rules.MinSpecial)
 	}
 
 	fmt.Println("\n Принципы безопасности:")
-	fmt.Println("   • Используйте уникальные пароли для каждого аккаунта")
-	fmt.Println("   • Избегайте словарных слов и личной информации")
-	fmt.Println("   • Используйте комбинации разных типов символов")
-	fmt.Println("   • Регулярно обновляйте пароли")
-	fmt.Println("   • Используйте менеджеры паролей для хранения")
+	fmt.Println("   " + theme.Bullet + " Используйте уникальные пароли для каждого аккаунта")
+	fmt.Println("   " + theme.Bullet + " Избегайте словарных слов и личной информации")
+	fmt.Println("   " + theme.Bullet + " Используйте комбинации разных типов символов")
+	fmt.Println("   " + theme.Bullet + " Регулярно обновляйте пароли")
+	fmt.Println("   " + theme.Bullet + " Используйте менеджеры паролей для хранения")
+
+	margin := userManager.PolicyMargin(defaultMarginProbability)
+	fmt.Printf("\n Запас стойкости (срок пароля %.0f дн, P = %g):\n", margin.Lifetime.Hours()/24, margin.Probability)
+	for _, profile := range margin.Profiles {
+		verdict := ""
+		if profile.Margin < 0 {
+			verdict = " - ниже порога"
+		}
+		fmt.Printf("   "+theme.Bullet+" %-40s %+.1f порядков%s\n", profile.Profile.Name+":", profile.Margin, verdict)
+	}
 
 	fmt.Println("\n Примеры надежных паролей:")
 	for i := 1; i <= 3; i++ {
-		if password, err := GenerateSecurePassword(12); err == nil {
+		if password, err := GeneratePassword(rules); err == nil {
 			fmt.Printf("   %d. %s\n", i, password)
 		}
 	}
+
+	fmt.Print("\nПрименить правила из результата анализа стойкости (путь к JSON, Enter - пропустить): ")
+	if !scanner.Scan() {
+		return
+	}
+	if path := strings.TrimSpace(scanner.Text()); path != "" {
+		if !requirePermission(userManager, scanner, PermPolicyManage) {
+			fmt.Println(" Менять правила может только пользователь с правом policy.manage.")
+			return
+		}
+		applyAnalysisRules(userManager, scanner, path)
+	}
 }
 
-// readPassword безопасно читает пароль без отображения символов на экране
-func readPassword() (string, error) {
-	fd := int(syscall.Stdin)
-	if !term.IsTerminal(fd) {
-		scanner := bufio.NewScanner(os.Stdin)
-		if scanner.Scan() {
-			return scanner.Text(), nil
+// applyAnalysisRules задает правила паролей по результату "password_analysis.go -format json"
+func applyAnalysisRules(userManager *UserManager, scanner *bufio.Scanner, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf(" Не удалось открыть файл: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	result, err := LoadAnalysisResult(file)
+	if err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+
+	fmt.Printf("\n Нижняя граница S*: %.2e\n", result.LowerBound)
+	for _, combo := range result.Combinations {
+		fmt.Printf("   A = %3d, L = %2d  %s\n", combo.AlphabetSize, combo.MinLength, combo.AlphabetName)
+	}
+
+	fmt.Print("Мощность алфавита для политики (по умолчанию 95): ")
+	if !scanner.Scan() {
+		return
+	}
+	alphabetSize := 95
+	if value := strings.TrimSpace(scanner.Text()); value != "" {
+		if alphabetSize, err = strconv.Atoi(value); err != nil {
+			fmt.Printf(" Некорректное число: %s\n", value)
+			return
 		}
-		return "", scanner.Err()
 	}
 
-	bytePassword, err := term.ReadPassword(fd)
+	rules, err := RulesFromAnalysis(result, alphabetSize)
 	if err != nil {
-		return "", err
+		fmt.Printf(" %v\n", err)
+		return
+	}
+	if err := userManager.SetPasswordRules(rules); err != nil {
+		fmt.Printf(" %v\n", err)
+		return
+	}
+	if reportDryRun(userManager) {
+		return
 	}
-	fmt.Println() 
 
-	return string(bytePassword), nil
-}
\ No newline at end of file
+	fmt.Printf(theme.Success+"Новая политика: длина не менее %d, классы символов: %s\n", rules.Length, describeCharClasses(rules))
+	fmt.Println("   Политика применяется при регистрации и смене пароля")
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Роли учетных записей в файле состояния
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// UserManifest - желаемое состояние учетных записей (go run . apply users.yaml).
+// Применение идемпотентно: повторное применение того же файла ничего не меняет.
+type UserManifest struct {
+	Users           []ManifestUser `yaml:"users"`
+	DisableUnlisted bool           `yaml:"disable_unlisted"` // Отключать учетные записи, которых нет в файле
+}
+
+// ManifestUser - учетная запись в файле состояния
+type ManifestUser struct {
+	Username     string   `yaml:"username"`
+	Email        *string  `yaml:"email"`         // Адрес (поле отсутствует - адрес не меняется)
+	Role         string   `yaml:"role"`          // user (по умолчанию), admin или роль из конфигурации политики
+	Disabled     bool     `yaml:"disabled"`      // Учетная запись отключена администратором
+	PasswordHash string   `yaml:"password_hash"` // bcrypt-хеш пароля, используется только при создании
+	Require2FA   *bool    `yaml:"require_2fa"`   // Второй фактор в системе не реализован: допустимо только false
+	Groups       []string `yaml:"groups"`        // Группы (поле отсутствует - членство не меняется)
+	// Атрибуты для правил групп (поле отсутствует - атрибуты не меняются)
+	Attributes map[string]string `yaml:"attributes"`
+}
+
+// ManifestChange - изменение учетной записи при применении файла состояния
+type ManifestChange struct {
+	Action   string   // "+" - создана, "~" - изменена, "-" - отключена
+	Username string   // Логин
+	Details  []string // Что изменилось
+}
+
+// String возвращает изменение в виде строки отчета
+func (c ManifestChange) String() string {
+	return fmt.Sprintf("%s %s: %s", c.Action, c.Username, strings.Join(c.Details, "; "))
+}
+
+// ManifestResult - итог применения файла состояния
+type ManifestResult struct {
+	Changes   []ManifestChange // Изменения по логинам в алфавитном порядке
+	Unchanged int              // Учетных записей уже в нужном состоянии
+}
+
+// LoadUserManifest читает файл состояния учетных записей в формате YAML
+func LoadUserManifest(path string) (UserManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return UserManifest{}, fmt.Errorf("ошибка чтения файла состояния: %v", err)
+	}
+	defer file.Close()
+
+	var manifest UserManifest
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil {
+		return UserManifest{}, fmt.Errorf("некорректный формат файла состояния: %v", err)
+	}
+	return manifest, nil
+}
+
+// validate проверяет файл состояния целиком до внесения изменений: роли и группы должны
+// быть заданы в конфигурации политики
+func (m UserManifest) validate(um *UserManager) error {
+	seen := make(map[string]bool)
+	for i, entry := range m.Users {
+		username := strings.TrimSpace(entry.Username)
+		if username == "" {
+			return fmt.Errorf("users[%d]: логин не может быть пустым", i)
+		}
+		if seen[username] {
+			return fmt.Errorf("users[%d]: логин '%s' указан повторно", i, username)
+		}
+		seen[username] = true
+
+		if _, ok := um.RolePermissions(entry.Role); !ok {
+			return fmt.Errorf("%s: неизвестная роль '%s' (допустимо: %s, %s и роли из конфигурации политики)", username, entry.Role, RoleUser, RoleAdmin)
+		}
+		if entry.PasswordHash != "" && !isBcryptHash(entry.PasswordHash) {
+			return fmt.Errorf("%s: password_hash должен быть bcrypt-хешем", username)
+		}
+		if entry.Require2FA != nil && *entry.Require2FA {
+			return fmt.Errorf("%s: require_2fa: двухфакторная аутентификация не поддерживается", username)
+		}
+		for _, group := range entry.Groups {
+			definition, exists := um.Group(group)
+			if !exists {
+				return fmt.Errorf("%s: groups: группа '%s' не найдена", username, group)
+			}
+			if definition.Rule != "" {
+				return fmt.Errorf("%s: groups: участники группы '%s' определяются правилом", username, group)
+			}
+		}
+		if err := validateAttributes(entry.Attributes); err != nil {
+			return fmt.Errorf("%s: attributes: %v", username, err)
+		}
+	}
+	return nil
+}
+
+// ApplyUserManifest приводит учетные записи к состоянию из файла: создает недостающие,
+// меняет адрес, роль и отключение существующих, одобряет заявки на регистрацию из файла и,
+// если задано disable_unlisted, отключает отсутствующие в файле. Пароли существующих учетных
+// записей не меняются; новая учетная запись без password_hash заблокирована до смены пароля
+// администратором. Файл проверяется целиком до внесения изменений, а при пробном запуске
+// изменения только возвращаются.
+func (um *UserManager) ApplyUserManifest(manifest UserManifest, source string) (ManifestResult, error) {
+	var result ManifestResult
+	if err := manifest.validate(um); err != nil {
+		return result, err
+	}
+	if um.HasAdmins() && !um.manifestKeepsAdmin(manifest) {
+		return result, fmt.Errorf("после применения не останется ни одного действующего администратора (role: %s)", RoleAdmin)
+	}
+	reason := "файл состояния " + source
+
+	// Права снимаются после назначения новых администраторов, иначе снятие прав
+	// с последнего действующего администратора было бы отклонено
+	var steps, revokes []func() error
+	listed := make(map[string]bool)
+	for _, entry := range manifest.Users {
+		username := strings.TrimSpace(entry.Username)
+		listed[username] = true
+		role := manifestRole(entry)
+
+		user, exists := um.store.GetUser(username)
+		if !exists {
+			change, step := um.manifestCreate(username, entry, reason)
+			result.Changes = append(result.Changes, change)
+			steps = append(steps, step)
+			continue
+		}
+		if user.IsHoneypot {
+			return ManifestResult{}, fmt.Errorf("%s: логин занят учетной записью-ловушкой", username)
+		}
+
+		change := ManifestChange{Action: "~", Username: username}
+		if user.PendingApproval {
+			change.Details = append(change.Details, "заявка на регистрацию одобрена")
+			steps = append(steps, func() error { return um.ApproveRegistration(username, reason) })
+		}
+		if entry.Email != nil && *entry.Email != user.Email {
+			email := *entry.Email
+			change.Details = append(change.Details, fmt.Sprintf("адрес: %q -> %q", user.Email, email))
+			steps = append(steps, func() error { return um.setEmail(username, email, reason) })
+		}
+		if previous := userRole(user); role != previous {
+			change.Details = append(change.Details, "роль: "+describeRole(previous)+" -> "+describeRole(role))
+			step := func() error { return um.SetRole(username, role, reason) }
+			if previous == RoleAdmin {
+				revokes = append(revokes, step)
+			} else {
+				steps = append(steps, step)
+			}
+		}
+		if groups := manifestGroups(entry); groups != nil && !slices.Equal(groups, user.Groups) {
+			change.Details = append(change.Details, fmt.Sprintf("группы: %s -> %s", describeMembership(user.Groups), describeMembership(groups)))
+			steps = append(steps, func() error { return um.setUserGroups(username, groups, reason) })
+		}
+		if entry.Attributes != nil && !maps.Equal(entry.Attributes, user.Attributes) {
+			change.Details = append(change.Details, fmt.Sprintf("атрибуты: %s -> %s", describeAttributes(user.Attributes), describeAttributes(entry.Attributes)))
+			steps = append(steps, func() error { return um.setUserAttributes(username, entry.Attributes, reason) })
+		}
+		if entry.Disabled && !user.DisabledByAdmin {
+			change.Details = append(change.Details, "отключена")
+			steps = append(steps, func() error { return um.DisableUser(username, reason) })
+		}
+		if !entry.Disabled && user.DisabledByAdmin {
+			change.Details = append(change.Details, "включена")
+			if user.HashedPassword == "" && user.LegacyHash == "" {
+				change.Details = append(change.Details, "без пароля: заблокирована до смены пароля администратором")
+				steps = append(steps, func() error { return um.enableWithoutPassword(username, reason) })
+			} else {
+				steps = append(steps, func() error { return um.UnlockUser(username, reason) })
+			}
+		}
+
+		if len(change.Details) == 0 {
+			result.Unchanged++
+			continue
+		}
+		result.Changes = append(result.Changes, change)
+	}
+
+	if manifest.DisableUnlisted {
+		for username, user := range um.store.GetAllUsers() {
+			if listed[username] || user.IsHoneypot {
+				continue
+			}
+			if user.DisabledByAdmin {
+				result.Unchanged++
+				continue
+			}
+			username := username
+			result.Changes = append(result.Changes, ManifestChange{Action: "-", Username: username, Details: []string{"отключена: нет в файле состояния"}})
+			steps = append(steps, func() error { return um.DisableUser(username, reason) })
+		}
+	}
+
+	sort.Slice(result.Changes, func(i, j int) bool {
+		return result.Changes[i].Username < result.Changes[j].Username
+	})
+	if um.dryRun {
+		return result, nil
+	}
+
+	for _, step := range append(steps, revokes...) {
+		if err := step(); err != nil {
+			return result, fmt.Errorf("файл состояния применен частично: %v", err)
+		}
+	}
+	if len(result.Changes) > 0 {
+		um.usersChanged()
+	}
+	return result, nil
+}
+
+// manifestKeepsAdmin сообщает, останется ли после применения файла состояния
+// хотя бы один не отключенный администратор
+func (um *UserManager) manifestKeepsAdmin(manifest UserManifest) bool {
+	listed := make(map[string]bool)
+	for _, entry := range manifest.Users {
+		listed[strings.TrimSpace(entry.Username)] = true
+		if entry.Role == RoleAdmin && !entry.Disabled {
+			return true
+		}
+	}
+	if manifest.DisableUnlisted {
+		return false
+	}
+	for username, user := range um.store.GetAllUsers() {
+		if !listed[username] && user.IsAdmin && !user.DisabledByAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestCreate описывает создание учетной записи из файла состояния
+func (um *UserManager) manifestCreate(username string, entry ManifestUser, reason string) (ManifestChange, func() error) {
+	change := ManifestChange{Action: "+", Username: username, Details: []string{"создана", "роль: " + describeRole(manifestRole(entry))}}
+	if entry.Email != nil && *entry.Email != "" {
+		change.Details = append(change.Details, fmt.Sprintf("адрес: %q", *entry.Email))
+	}
+	if groups := manifestGroups(entry); len(groups) > 0 {
+		change.Details = append(change.Details, "группы: "+describeMembership(groups))
+	}
+	if len(entry.Attributes) > 0 {
+		change.Details = append(change.Details, "атрибуты: "+describeAttributes(entry.Attributes))
+	}
+	if entry.PasswordHash == "" {
+		change.Details = append(change.Details, "без пароля: заблокирована до смены пароля администратором")
+	}
+	if entry.Disabled {
+		change.Details = append(change.Details, "отключена")
+	}
+
+	return change, func() error {
+		now := time.Now()
+		user := &User{
+			Username:        username,
+			HashedPassword:  entry.PasswordHash,
+			CreatedAt:       now,
+			IsAdmin:         entry.Role == RoleAdmin,
+			IsBlocked:       entry.PasswordHash == "" || entry.Disabled,
+			DisabledByAdmin: entry.Disabled,
+		}
+		if role := manifestRole(entry); role != RoleAdmin && role != RoleUser {
+			user.Role = role
+		}
+		if entry.Email != nil {
+			user.Email = *entry.Email
+		}
+		if entry.PasswordHash != "" {
+			user.PasswordChangedAt = now
+		}
+		if user.IsBlocked {
+			user.BlockedAt = now
+		}
+
+		um.store.SaveUser(user)
+		um.recordAudit(AuditRegister, username, reason)
+		if err := um.setUserGroups(username, manifestGroups(entry), reason); err != nil {
+			return err
+		}
+		if len(entry.Attributes) > 0 {
+			if err := um.setUserAttributes(username, entry.Attributes, reason); err != nil {
+				return err
+			}
+		}
+		if user.IsAdmin {
+			um.recordAudit(AuditAdminGranted, username, reason)
+		}
+		return nil
+	}
+}
+
+// setEmail меняет адрес учетной записи
+func (um *UserManager) setEmail(username, email, reason string) error {
+	err := um.store.Update(username, func(user *User) error {
+		user.Email = email
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditEmailChanged, username, reason)
+	return nil
+}
+
+// enableWithoutPassword снимает отключение с учетной записи, у которой нет пароля:
+// вход остается заблокированным до смены пароля администратором
+func (um *UserManager) enableWithoutPassword(username, reason string) error {
+	err := um.store.Update(username, func(user *User) error {
+		user.DisabledByAdmin = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditAccountUnlocked, username, reason+"; без пароля")
+	return nil
+}
+
+// manifestRole возвращает роль записи файла состояния (по умолчанию user)
+func manifestRole(entry ManifestUser) string {
+	if entry.Role == "" {
+		return RoleUser
+	}
+	return entry.Role
+}
+
+// manifestGroups возвращает группы записи файла состояния по порядку без повторов
+// (nil - поле отсутствует, членство не меняется)
+func manifestGroups(entry ManifestUser) []string {
+	if entry.Groups == nil {
+		return nil
+	}
+	groups := []string{}
+	for _, group := range entry.Groups {
+		if group = strings.TrimSpace(group); !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// describeMembership возвращает список групп для отчета
+func describeMembership(groups []string) string {
+	if len(groups) == 0 {
+		return "нет"
+	}
+	return strings.Join(groups, ", ")
+}
+
+// describeRole возвращает название роли для отчета
+func describeRole(role string) string {
+	switch role {
+	case RoleAdmin:
+		return "администратор"
+	case RoleUser:
+		return "пользователь"
+	}
+	return role
+}
+
+// FormatManifestResult формирует отчет о применении файла состояния
+func FormatManifestResult(result ManifestResult, dryRun bool) string {
+	var sb strings.Builder
+	if dryRun {
+		sb.WriteString("Пробный запуск, изменения не внесены.\n")
+	}
+
+	created, updated, disabled := 0, 0, 0
+	for _, change := range result.Changes {
+		sb.WriteString(change.String() + "\n")
+		switch change.Action {
+		case "+":
+			created++
+		case "~":
+			updated++
+		case "-":
+			disabled++
+		}
+	}
+	fmt.Fprintf(&sb, "Создано: %d, изменено: %d, отключено: %d, без изменений: %d\n", created, updated, disabled, result.Unchanged)
+	return sb.String()
+}
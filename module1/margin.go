@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Запас стойкости политики - на сколько порядков число паролей, удовлетворяющих правилам,
+// превышает нижнюю границу S* = V·T/P из анализа стойкости (модуль 2): V - скорость перебора
+// профиля атакующего, T - срок действия пароля, P - допустимая вероятность подбора за этот срок.
+// Отрицательный запас означает, что политика ниже порога для профиля.
+const defaultMarginProbability = 1e-6
+
+// marginHeatSpan - сколько длин до и после действующей рассматривается в тепловой карте
+const marginHeatSpan = 4
+
+// ProfileMargin - запас стойкости политики против одного профиля атакующего
+type ProfileMargin struct {
+	Profile    AttackerProfile
+	LowerBound float64 // S* = V·T/P
+	Margin     float64 // log10(S/S*), порядков величины
+}
+
+// PolicyMargin - оценка запаса стойкости правил паролей
+type PolicyMargin struct {
+	Rules        PasswordRules
+	AlphabetSize int
+	SearchSpace  float64       // Число паролей минимальной длины, удовлетворяющих правилам
+	Lifetime     time.Duration // Срок действия пароля T
+	Probability  float64       // Допустимая вероятность подбора P
+	Profiles     []ProfileMargin
+}
+
+// MarginHeatRow - строка тепловой карты: набор классов символов при разных длинах
+type MarginHeatRow struct {
+	Classes      string
+	AlphabetSize int
+	Cells        []MarginHeatCell
+}
+
+// MarginHeatCell - клетка тепловой карты
+type MarginHeatCell struct {
+	Length    int
+	Valid     bool // Минимальные количества символов укладываются в длину
+	Withstood int  // Профилей, против которых запас не отрицательный
+	Active    bool // Действующая политика
+}
+
+// charClassSet - сочетание обязательных классов символов строки тепловой карты
+type charClassSet struct {
+	upper, lower, digits, special bool
+}
+
+// marginClassSets - сочетания классов, которые задает RulesFromAnalysis, от слабых к сильным
+var marginClassSets = []charClassSet{
+	{digits: true},
+	{lower: true},
+	{lower: true, digits: true},
+	{upper: true, lower: true},
+	{upper: true, lower: true, digits: true},
+	{upper: true, lower: true, digits: true, special: true},
+}
+
+// AnalyzePolicyMargin оценивает запас стойкости правил для каждого профиля атакующего
+func AnalyzePolicyMargin(rules PasswordRules, lifetime time.Duration, probability float64) PolicyMargin {
+	alphabet, space := policySearchSpace(rules)
+	margin := PolicyMargin{
+		Rules:        rules,
+		AlphabetSize: alphabet,
+		SearchSpace:  space,
+		Lifetime:     lifetime,
+		Probability:  probability,
+	}
+	for _, profile := range AttackerProfiles {
+		lowerBound := math.Ceil(profile.GuessesPerSecond * lifetime.Seconds() / probability)
+		margin.Profiles = append(margin.Profiles, ProfileMargin{
+			Profile:    profile,
+			LowerBound: lowerBound,
+			Margin:     math.Log10(space / lowerBound),
+		})
+	}
+	return margin
+}
+
+// Below возвращает профили, против которых запаса политики не хватает
+func (m PolicyMargin) Below() []AttackerProfile {
+	var below []AttackerProfile
+	for _, profile := range m.Profiles {
+		if profile.Margin < 0 {
+			below = append(below, profile.Profile)
+		}
+	}
+	return below
+}
+
+// policySearchSpace считает пароли длины rules.Length из символов обязательных классов,
+// в которых каждого класса не меньше минимума. Атакующий, знающий политику, начинает
+// перебор с них, поэтому это число - нижняя оценка пространства перебора.
+func policySearchSpace(rules PasswordRules) (int, float64) {
+	type charClass struct {
+		size, min int
+	}
+	var classes []charClass
+	if rules.RequireUppercase {
+		classes = append(classes, charClass{len(uppercaseLetters), rules.MinUppercase})
+	}
+	if rules.RequireLowercase {
+		classes = append(classes, charClass{len(lowercaseLetters), rules.MinLowercase})
+	}
+	if rules.RequireDigits {
+		classes = append(classes, charClass{len(digits), rules.MinDigits})
+	}
+	if rules.RequireSpecial {
+		classes = append(classes, charClass{len(specialChars), rules.MinSpecial})
+	}
+
+	// ways[n] - строки длины n из уже учтенных классов с соблюдением минимумов;
+	// k символов нового класса расставляются по C(n+k, k) позициям
+	length := rules.Length
+	ways := make([]float64, length+1)
+	ways[0] = 1
+	alphabet := 0
+	for _, class := range classes {
+		alphabet += class.size
+		next := make([]float64, length+1)
+		for n := 0; n <= length; n++ {
+			if ways[n] == 0 {
+				continue
+			}
+			binomial, power := 1.0, 1.0
+			for k := 0; n+k <= length; k++ {
+				if k > 0 {
+					binomial = binomial * float64(n+k) / float64(k)
+					power *= float64(class.size)
+				}
+				if k >= class.min {
+					next[n+k] += ways[n] * binomial * power
+				}
+			}
+		}
+		ways = next
+	}
+	if alphabet == 0 {
+		return 0, 0
+	}
+	return alphabet, ways[length]
+}
+
+// PolicyMarginHeat строит тепловую карту запаса по сочетаниям классов символов и длинам
+// вокруг действующей политики. Минимальные количества классов, которые требует политика,
+// сохраняются, остальные классы требуются хотя бы по одному символу, как в RulesFromAnalysis.
+func PolicyMarginHeat(rules PasswordRules, lifetime time.Duration, probability float64) []MarginHeatRow {
+	active := charClassSet{rules.RequireUppercase, rules.RequireLowercase, rules.RequireDigits, rules.RequireSpecial}
+	sets := marginClassSets
+	found := false
+	for _, set := range sets {
+		found = found || set == active
+	}
+	if !found {
+		sets = append(append([]charClassSet(nil), sets...), active)
+	}
+
+	minLength := rules.Length - marginHeatSpan
+	if minLength < 4 {
+		minLength = 4
+	}
+	var rows []MarginHeatRow
+	for _, set := range sets {
+		variant := withCharClasses(rules, set)
+		row := MarginHeatRow{Classes: describeCharClasses(variant)}
+		row.AlphabetSize, _ = policySearchSpace(variant)
+		for length := minLength; length <= rules.Length+marginHeatSpan; length++ {
+			variant.Length = length
+			cell := MarginHeatCell{Length: length, Active: set == active && length == rules.Length}
+			if validatePasswordRules(variant) == nil {
+				cell.Valid = true
+				margin := AnalyzePolicyMargin(variant, lifetime, probability)
+				cell.Withstood = len(margin.Profiles) - len(margin.Below())
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// withCharClasses возвращает правила с заданным сочетанием обязательных классов
+func withCharClasses(rules PasswordRules, set charClassSet) PasswordRules {
+	minimum := func(required, activeRequired bool, activeMin int) int {
+		switch {
+		case !required:
+			return 0
+		case activeRequired:
+			return activeMin
+		default:
+			return 1
+		}
+	}
+	return PasswordRules{
+		Length:           rules.Length,
+		RequireUppercase: set.upper,
+		RequireLowercase: set.lower,
+		RequireDigits:    set.digits,
+		RequireSpecial:   set.special,
+		MinUppercase:     minimum(set.upper, rules.RequireUppercase, rules.MinUppercase),
+		MinLowercase:     minimum(set.lower, rules.RequireLowercase, rules.MinLowercase),
+		MinDigits:        minimum(set.digits, rules.RequireDigits, rules.MinDigits),
+		MinSpecial:       minimum(set.special, rules.RequireSpecial, rules.MinSpecial),
+	}
+}
+
+// passwordLifetime возвращает срок действия пароля для оценки запаса: MaxPasswordAge
+// кампании проверки, а если срок не ограничен - срок по умолчанию
+func (um *UserManager) passwordLifetime() time.Duration {
+	if um.recheck.MaxPasswordAge > 0 {
+		return um.recheck.MaxPasswordAge
+	}
+	return DefaultRecheckCampaign().MaxPasswordAge
+}
+
+// PolicyMargin оценивает запас стойкости действующих правил паролей
+func (um *UserManager) PolicyMargin(probability float64) PolicyMargin {
+	return AnalyzePolicyMargin(um.rules, um.passwordLifetime(), probability)
+}
+
+// PolicyMarginHeat строит тепловую карту запаса вокруг действующих правил паролей
+func (um *UserManager) PolicyMarginHeat(probability float64) []MarginHeatRow {
+	return PolicyMarginHeat(um.rules, um.passwordLifetime(), probability)
+}
+
+// FormatProfileMargins выводит запас политики по профилям атакующего
+func FormatProfileMargins(m PolicyMargin) string {
+	var out strings.Builder
+	table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Профиль атакующего\tS*\tЗапас, порядков\tИтог\t")
+	for _, profile := range m.Profiles {
+		verdict := "достаточно"
+		if profile.Margin < 0 {
+			verdict = "НИЖЕ ПОРОГА"
+		}
+		fmt.Fprintf(table, "%s\t%.2e\t%+.1f\t%s\t\n", profile.Profile.Name, profile.LowerBound, profile.Margin, verdict)
+	}
+	table.Flush()
+	return out.String()
+}
+
+// FormatPolicyMargin формирует отчет о запасе стойкости с тепловой картой
+func FormatPolicyMargin(m PolicyMargin, heat []MarginHeatRow) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Политика: длина не менее %d, классы: %s\n", m.Rules.Length, describeCharClasses(m.Rules))
+	fmt.Fprintf(&out, "Паролей по правилам: S = %.2e (алфавит %d символов, с учетом минимальных количеств)\n", m.SearchSpace, m.AlphabetSize)
+	fmt.Fprintf(&out, "Срок действия пароля T: %.0f дн, допустимая вероятность подбора P: %g\n\n", m.Lifetime.Hours()/24, m.Probability)
+	out.WriteString(FormatProfileMargins(m))
+
+	if len(heat) > 0 {
+		fmt.Fprintf(&out, "\nТепловая карта: профилей (из %d), против которых хватает запаса; [ ] - действующая политика\n", len(m.Profiles))
+		table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+		fmt.Fprint(table, "Классы\tA\t")
+		for _, cell := range heat[0].Cells {
+			fmt.Fprintf(table, "L=%d\t", cell.Length)
+		}
+		fmt.Fprintln(table)
+		for _, row := range heat {
+			fmt.Fprintf(table, "%s\t%d\t", row.Classes, row.AlphabetSize)
+			for _, cell := range row.Cells {
+				text := "-"
+				if cell.Valid {
+					text = fmt.Sprint(cell.Withstood)
+				}
+				if cell.Active {
+					text = "[" + text + "]"
+				}
+				fmt.Fprintf(table, "%s\t", text)
+			}
+			fmt.Fprintln(table)
+		}
+		table.Flush()
+		out.WriteString("Прочерк - минимальные количества символов не укладываются в длину\n")
+	}
+
+	out.WriteString("\n")
+	if below := m.Below(); len(below) > 0 {
+		names := make([]string, len(below))
+		for i, profile := range below {
+			names[i] = profile.Name
+		}
+		fmt.Fprintf(&out, "Политика ниже порога для профилей: %s.\n", strings.Join(names, "; "))
+	} else {
+		out.WriteString("Запаса политики хватает против всех профилей атакующего.\n")
+	}
+	return out.String()
+}
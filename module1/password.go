@@ -45,6 +45,12 @@ const (
 
 // GeneratePassword генерирует безопасный пароль согласно заданным правилам
 func GeneratePassword(rules PasswordRules) (string, error) {
+	return GeneratePasswordFor(rules, TargetConstraints{})
+}
+
+// GeneratePasswordFor генерирует пароль, который одновременно соответствует правилам
+// безопасности и ограничениям целевой системы
+func GeneratePasswordFor(rules PasswordRules, target TargetConstraints) (string, error) {
 	if rules.Length < 4 {
 		return "", fmt.Errorf("длина пароля должна быть минимум 4 символа")
 	}
@@ -54,68 +60,63 @@ func GeneratePassword(rules PasswordRules) (string, error) {
 	if minRequired > rules.Length {
 		return "", fmt.Errorf("сумма минимальных требований (%d) превышает длину пароля (%d)", minRequired, rules.Length)
 	}
+	if target.MaxLength > 0 && rules.Length > target.MaxLength {
+		return "", fmt.Errorf("%s: правила требуют не менее %d символов, а система допускает не более %d", target.Name, rules.Length, target.MaxLength)
+	}
 
-	var password []rune
+	classes, err := target.generatorClasses(rules)
+	if err != nil {
+		return "", err
+	}
+	if len(classes) == 0 {
+		return "", fmt.Errorf("не выбран ни один набор символов")
+	}
 	var remainingLength = rules.Length
 
-	// Добавляем обязательные символы каждого типа
-	if rules.RequireUppercase && rules.MinUppercase > 0 {
-		chars, err := generateCharsFromSet(uppercaseLetters, rules.MinUppercase)
-		if err != nil {
-			return "", err
+	// Первая буква выбирается отдельно и засчитывается в минимум своего класса
+	var first []rune
+	if target.StartWithLetter {
+		var letters []rune
+		for _, class := range classes {
+			if class.letter {
+				letters = append(letters, class.chars...)
+			}
 		}
-		password = append(password, chars...)
-		remainingLength -= rules.MinUppercase
-	}
-
-	if rules.RequireLowercase && rules.MinLowercase > 0 {
-		chars, err := generateCharsFromSet(lowercaseLetters, rules.MinLowercase)
-		if err != nil {
-			return "", err
+		if len(letters) == 0 {
+			return "", fmt.Errorf("%s: пароль должен начинаться с буквы, а правила не включают буквы", target.Name)
 		}
-		password = append(password, chars...)
-		remainingLength -= rules.MinLowercase
-	}
-
-	if rules.RequireDigits && rules.MinDigits > 0 {
-		chars, err := generateCharsFromSet(digits, rules.MinDigits)
+		chars, err := generateCharsFromSet(string(letters), 1)
 		if err != nil {
 			return "", err
 		}
-		password = append(password, chars...)
-		remainingLength -= rules.MinDigits
+		first = chars
+		for i := range classes {
+			if classes[i].letter && classes[i].min > 0 && strings.ContainsRune(string(classes[i].chars), first[0]) {
+				classes[i].min--
+			}
+		}
+		remainingLength--
 	}
 
-	if rules.RequireSpecial && rules.MinSpecial > 0 {
-		chars, err := generateCharsFromSet(specialChars, rules.MinSpecial)
+	// Добавляем обязательные символы каждого типа
+	var password []rune
+	var allChars []rune
+	for _, class := range classes {
+		allChars = append(append(allChars, class.chars...), class.extra...)
+		if class.min == 0 {
+			continue
+		}
+		chars, err := generateCharsFromSet(string(class.chars), class.min)
 		if err != nil {
 			return "", err
 		}
 		password = append(password, chars...)
-		remainingLength -= rules.MinSpecial
+		remainingLength -= class.min
 	}
 
 	// Заполняем оставшуюся длину случайными символами из всех доступных наборов
 	if remainingLength > 0 {
-		allChars := ""
-		if rules.RequireUppercase {
-			allChars += uppercaseLetters
-		}
-		if rules.RequireLowercase {
-			allChars += lowercaseLetters
-		}
-		if rules.RequireDigits {
-			allChars += digits
-		}
-		if rules.RequireSpecial {
-			allChars += specialChars
-		}
-
-		if allChars == "" {
-			return "", fmt.Errorf("не выбран ни один набор символов")
-		}
-
-		chars, err := generateCharsFromSet(allChars, remainingLength)
+		chars, err := generateCharsFromSet(string(allChars), remainingLength)
 		if err != nil {
 			return "", err
 		}
@@ -127,7 +128,26 @@ func GeneratePassword(rules PasswordRules) (string, error) {
 		return "", err
 	}
 
-	return string(password), nil
+	// Пробел в конце меняется местами со случайным символом, отличным от пробела
+	if target.NoTrailingSpace && len(password) > 0 && password[len(password)-1] == ' ' {
+		var candidates []int
+		for i, char := range password[:len(password)-1] {
+			if char != ' ' {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", fmt.Errorf("%s: пароль не должен заканчиваться пробелом, а других символов нет", target.Name)
+		}
+		randomIndex, err := rand.Int(randomSource, big.NewInt(int64(len(candidates))))
+		if err != nil {
+			return "", fmt.Errorf("ошибка генерации случайного числа: %v", err)
+		}
+		j := candidates[randomIndex.Int64()]
+		password[j], password[len(password)-1] = password[len(password)-1], password[j]
+	}
+
+	return string(append(first, password...)), nil
 }
 
 // generateCharsFromSet генерирует заданное количество случайных символов из набора
@@ -137,7 +157,7 @@ func generateCharsFromSet(charset string, count int) ([]rune, error) {
 	charsetLen := big.NewInt(int64(len(charsetRunes)))
 
 	for i := 0; i < count; i++ {
-		randomIndex, err := rand.Int(rand.Reader, charsetLen)
+		randomIndex, err := rand.Int(randomSource, charsetLen)
 		if err != nil {
 			return nil, fmt.Errorf("ошибка генерации случайного числа: %v", err)
 		}
@@ -151,7 +171,7 @@ func generateCharsFromSet(charset string, count int) ([]rune, error) {
 func shuffleRunes(runes []rune) error {
 	n := len(runes)
 	for i := n - 1; i > 0; i-- {
-		randomIndex, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		randomIndex, err := rand.Int(randomSource, big.NewInt(int64(i+1)))
 		if err != nil {
 			return fmt.Errorf("ошибка генерации случайного числа для перемешивания: %v", err)
 		}
@@ -161,13 +181,14 @@ func shuffleRunes(runes []rune) error {
 	return nil
 }
 
-// ValidatePassword проверяет, соответствует ли пароль заданным правилам
-func ValidatePassword(password string, rules PasswordRules) (bool, []string) {
-	var errors []string
+// ValidatePassword проверяет, соответствует ли пароль заданным правилам, и возвращает
+// нарушенные требования с необходимыми и фактическими значениями
+func ValidatePassword(password string, rules PasswordRules) (bool, []PolicyViolation) {
+	var violations []PolicyViolation
 
 	// Проверка длины
 	if len(password) < rules.Length {
-		errors = append(errors, fmt.Sprintf("пароль должен содержать минимум %d символов", rules.Length))
+		violations = append(violations, RuleMinLength{Need: rules.Length, Got: len(password)})
 	}
 
 	// Подсчет символов каждого типа
@@ -188,26 +209,32 @@ func ValidatePassword(password string, rules PasswordRules) (bool, []string) {
 
 	// Проверка требований
 	if rules.RequireUppercase && uppercaseCount < rules.MinUppercase {
-		errors = append(errors, fmt.Sprintf("пароль должен содержать минимум %d заглавных букв", rules.MinUppercase))
+		violations = append(violations, RuleMinUppercase{Need: rules.MinUppercase, Got: uppercaseCount})
 	}
 
 	if rules.RequireLowercase && lowercaseCount < rules.MinLowercase {
-		errors = append(errors, fmt.Sprintf("пароль должен содержать минимум %d строчных букв", rules.MinLowercase))
+		violations = append(violations, RuleMinLowercase{Need: rules.MinLowercase, Got: lowercaseCount})
 	}
 
 	if rules.RequireDigits && digitCount < rules.MinDigits {
-		errors = append(errors, fmt.Sprintf("пароль должен содержать минимум %d цифр", rules.MinDigits))
+		violations = append(violations, RuleMinDigits{Need: rules.MinDigits, Got: digitCount})
 	}
 
 	if rules.RequireSpecial && specialCount < rules.MinSpecial {
-		errors = append(errors, fmt.Sprintf("пароль должен содержать минимум %d специальных символов", rules.MinSpecial))
+		violations = append(violations, RuleMinSpecial{Need: rules.MinSpecial, Got: specialCount})
 	}
 
-	return len(errors) == 0, errors
+	return len(violations) == 0, violations
 }
 
 // GenerateSecurePassword создает пароль с максимальными настройками безопасности
 func GenerateSecurePassword(length int) (string, error) {
+	return GenerateSecurePasswordFor(length, TargetConstraints{})
+}
+
+// GenerateSecurePasswordFor создает пароль с максимальными настройками безопасности
+// для целевой системы с ограничениями
+func GenerateSecurePasswordFor(length int, target TargetConstraints) (string, error) {
 	if length < 12 {
 		length = 12 // Минимальная безопасная длина
 	}
@@ -224,5 +251,5 @@ func GenerateSecurePassword(length int) (string, error) {
 		MinSpecial:       2,
 	}
 
-	return GeneratePassword(rules)
+	return GeneratePasswordFor(rules, target)
 }
\ No newline at end of file
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// chiSquareCritical возвращает критическое значение хи-квадрат для уровня значимости 0.001
+// (приближение Уилсона-Хилферти)
+func chiSquareCritical(degrees int) float64 {
+	const z = 3.090 // Квантиль нормального распределения для 0.999
+	k := float64(degrees)
+	term := 1 - 2/(9*k) + z*math.Sqrt(2/(9*k))
+	return k * term * term * term
+}
+
+// allowedChars возвращает символы, которые генератор может выбрать по правилам
+func allowedChars(rules PasswordRules) string {
+	allowed := ""
+	if rules.RequireUppercase {
+		allowed += uppercaseLetters
+	}
+	if rules.RequireLowercase {
+		allowed += lowercaseLetters
+	}
+	if rules.RequireDigits {
+		allowed += digits
+	}
+	if rules.RequireSpecial {
+		allowed += specialChars
+	}
+	return allowed
+}
+
+func TestGeneratePasswordSatisfiesRules(t *testing.T) {
+	const samples = 2000
+	tests := []struct {
+		name  string
+		rules PasswordRules
+	}{
+		{"правила по умолчанию", DefaultPasswordRules()},
+		{"длинный пароль", PasswordRules{Length: 64, RequireUppercase: true, RequireLowercase: true, RequireDigits: true, RequireSpecial: true, MinUppercase: 1, MinLowercase: 1, MinDigits: 1, MinSpecial: 1}},
+		{"только цифры", PasswordRules{Length: 8, RequireDigits: true, MinDigits: 8}},
+		{"буквы без минимумов", PasswordRules{Length: 16, RequireUppercase: true, RequireLowercase: true}},
+		{"минимумы на всю длину", PasswordRules{Length: 8, RequireUppercase: true, RequireLowercase: true, RequireDigits: true, RequireSpecial: true, MinUppercase: 2, MinLowercase: 2, MinDigits: 2, MinSpecial: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed := allowedChars(tt.rules)
+			for i := 0; i < samples; i++ {
+				password, err := GeneratePassword(tt.rules)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if length := utf8.RuneCountInString(password); length != tt.rules.Length {
+					t.Fatalf("пароль %q длиной %d вместо %d", password, length, tt.rules.Length)
+				}
+				if valid, violations := ValidatePassword(password, tt.rules); !valid {
+					t.Fatalf("пароль %q: %s", password, strings.Join(violationMessages(violations), "; "))
+				}
+				for _, r := range password {
+					if !strings.ContainsRune(allowed, r) {
+						t.Fatalf("пароль %q содержит недопустимый символ %q", password, r)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGenerateCharsUniform проверяет равновероятность выбора символов набора по критерию
+// хи-квадрат: смещение при взятии остатка от деления проявится как превышение критического значения
+func TestGenerateCharsUniform(t *testing.T) {
+	const samples = 40000
+	tests := []struct {
+		name    string
+		charset string
+	}{
+		{"заглавные буквы", uppercaseLetters},
+		{"строчные буквы", lowercaseLetters},
+		{"цифры", digits},
+		{"специальные символы", specialChars},
+		{"все наборы", uppercaseLetters + lowercaseLetters + digits + specialChars},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runes := []rune(tt.charset)
+			chars, err := generateCharsFromSet(tt.charset, samples)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make(map[rune]int, len(runes))
+			for _, r := range chars {
+				counts[r]++
+			}
+
+			expected := float64(samples) / float64(len(runes))
+			chiSquare := 0.0
+			for _, r := range runes {
+				diff := float64(counts[r]) - expected
+				chiSquare += diff * diff / expected
+			}
+			if critical := chiSquareCritical(len(runes) - 1); chiSquare > critical {
+				t.Errorf("распределение неравномерно: хи-квадрат %.1f > %.1f по %d образцам", chiSquare, critical, samples)
+			}
+		})
+	}
+}
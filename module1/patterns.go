@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PatternKind - тип предсказуемого фрагмента пароля
+type PatternKind string
+
+const (
+	PatternDictionary PatternKind = "словарное слово"
+	PatternDate       PatternKind = "дата"
+	PatternRepeat     PatternKind = "повтор"
+	PatternSequence   PatternKind = "последовательность"
+	PatternKeyboard   PatternKind = "клавиатурный ряд"
+)
+
+// PatternMatch - найденный фрагмент пароля и число попыток, за которое его угадывает атакующий
+type PatternMatch struct {
+	Kind    PatternKind
+	Token   string
+	Start   int // Индекс первого символа (в рунах)
+	End     int // Индекс символа после фрагмента
+	Guesses float64
+}
+
+// commonPasswords - популярные пароли и слова в порядке частоты (ранг = позиция + 1)
+var commonPasswords = []string{
+	"password", "qwerty", "admin", "welcome", "letmein", "monkey", "dragon",
+	"football", "iloveyou", "login", "master", "sunshine", "princess", "shadow",
+	"secret", "summer", "winter", "spring", "autumn", "hello", "freedom",
+	"whatever", "trustno", "baseball", "superman", "batman", "starwars",
+	"michael", "charlie", "jordan", "pass", "love", "user", "root", "test",
+	"guest", "access", "flower", "computer", "internet", "service", "system",
+	"parol", "privet", "qazwsx", "zaq", "god", "angel", "money", "family",
+}
+
+// leetSubstitutions - замены символов в стиле l33t
+var leetSubstitutions = map[rune]rune{
+	'@': 'a', '4': 'a', '3': 'e', '1': 'i', '!': 'i',
+	'0': 'o', '$': 's', '5': 's', '7': 't', '+': 't',
+}
+
+// keyboardRows - ряды клавиатуры QWERTY для поиска клавиатурных прогулок
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// FindPatterns возвращает все найденные в пароле предсказуемые фрагменты
+func FindPatterns(password string) []PatternMatch {
+	runes := []rune(password)
+
+	var matches []PatternMatch
+	matches = append(matches, dictionaryMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	return matches
+}
+
+// dictionaryMatches ищет словарные слова, в том числе с заглавными буквами и l33t-заменами
+func dictionaryMatches(runes []rune) []PatternMatch {
+	var matches []PatternMatch
+
+	for i := 0; i < len(runes); i++ {
+		for j := i + 3; j <= len(runes); j++ {
+			token := runes[i:j]
+
+			plain := make([]rune, len(token))
+			hasUpper, hasLeet := false, false
+			for k, r := range token {
+				if unicode.IsUpper(r) {
+					hasUpper = true
+				}
+				if sub, ok := leetSubstitutions[r]; ok {
+					r = sub
+					hasLeet = true
+				}
+				plain[k] = unicode.ToLower(r)
+			}
+
+			for rank, word := range commonPasswords {
+				if string(plain) != word {
+					continue
+				}
+				guesses := float64(rank + 1)
+				if hasUpper {
+					guesses *= 2
+				}
+				if hasLeet {
+					guesses *= 2
+				}
+				matches = append(matches, PatternMatch{PatternDictionary, string(token), i, j, guesses})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// dateMatches ищет годы (1900-2039) и даты вида ДДММГГГГ, ДД.ММ.ГГГГ
+func dateMatches(runes []rune) []PatternMatch {
+	var matches []PatternMatch
+
+	for i := 0; i+4 <= len(runes); i++ {
+		if year, ok := parseDigits(runes[i : i+4]); ok && year >= 1900 && year <= 2039 {
+			matches = append(matches, PatternMatch{PatternDate, string(runes[i : i+4]), i, i + 4, 140})
+		}
+	}
+
+	for i := 0; i+8 <= len(runes); i++ {
+		for _, withSeparators := range []bool{false, true} {
+			length := 8
+			if withSeparators {
+				length = 10
+			}
+			if i+length > len(runes) {
+				continue
+			}
+			token := runes[i : i+length]
+
+			digits := token
+			if withSeparators {
+				if !isDateSeparator(token[2]) || token[2] != token[5] {
+					continue
+				}
+				digits = append(append(append([]rune{}, token[0:2]...), token[3:5]...), token[6:10]...)
+			}
+
+			day, okDay := parseDigits(digits[0:2])
+			month, okMonth := parseDigits(digits[2:4])
+			year, okYear := parseDigits(digits[4:8])
+			if okDay && okMonth && okYear && day >= 1 && day <= 31 && month >= 1 && month <= 12 && year >= 1900 && year <= 2039 {
+				matches = append(matches, PatternMatch{PatternDate, string(token), i, i + length, 31 * 12 * 140})
+			}
+		}
+	}
+	return matches
+}
+
+// isDateSeparator проверяет разделитель частей даты
+func isDateSeparator(r rune) bool {
+	return r == '.' || r == '-' || r == '/'
+}
+
+// parseDigits переводит последовательность цифр в число
+func parseDigits(runes []rune) (int, bool) {
+	value := 0
+	for _, r := range runes {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+		value = value*10 + int(r-'0')
+	}
+	return value, true
+}
+
+// repeatMatches ищет повторы одного символа ("aaa", "1111")
+func repeatMatches(runes []rune) []PatternMatch {
+	var matches []PatternMatch
+
+	for i := 0; i < len(runes); {
+		j := i + 1
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		if j-i >= 3 {
+			guesses := float64(passwordAlphabetSize(string(runes[i])) * (j - i))
+			matches = append(matches, PatternMatch{PatternRepeat, string(runes[i:j]), i, j, guesses})
+		}
+		i = j
+	}
+	return matches
+}
+
+// sequenceMatches ищет возрастающие и убывающие последовательности ("abcd", "4321")
+func sequenceMatches(runes []rune) []PatternMatch {
+	var matches []PatternMatch
+
+	for i := 0; i+1 < len(runes); {
+		delta := runes[i+1] - runes[i]
+		if delta != 1 && delta != -1 {
+			i++
+			continue
+		}
+
+		j := i + 2
+		for j < len(runes) && runes[j]-runes[j-1] == delta {
+			j++
+		}
+		if j-i >= 3 {
+			guesses := float64(passwordAlphabetSize(string(runes[i])) * (j - i) * 2)
+			matches = append(matches, PatternMatch{PatternSequence, string(runes[i:j]), i, j, guesses})
+		}
+		i = j - 1
+	}
+	return matches
+}
+
+// keyboardMatches ищет фрагменты рядов клавиатуры длиной от 4 символов ("qwer", "lkjh")
+func keyboardMatches(runes []rune) []PatternMatch {
+	var matches []PatternMatch
+	lower := []rune(strings.ToLower(string(runes)))
+
+	for i := 0; i < len(lower); i++ {
+		for j := len(lower); j >= i+4; j-- {
+			token := string(lower[i:j])
+			if !onKeyboardRow(token) {
+				continue
+			}
+			guesses := float64(len(keyboardRows) * 10 * (j - i) * 2)
+			matches = append(matches, PatternMatch{PatternKeyboard, string(runes[i:j]), i, j, guesses})
+			break
+		}
+	}
+	return matches
+}
+
+// onKeyboardRow проверяет, что строка идет подряд по одному ряду клавиатуры в любом направлении
+func onKeyboardRow(token string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, token) || strings.Contains(reverseString(row), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// reverseString переворачивает строку
+func reverseString(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// estimateGuesses находит разбиение пароля на фрагменты с минимальным числом попыток.
+// Символы вне шаблонов перебираются по алфавиту пароля.
+func estimateGuesses(password string, matches []PatternMatch) (float64, []PatternMatch) {
+	runes := []rune(password)
+	alphabet := float64(passwordAlphabetSize(password))
+
+	// best[i] - минимум попыток для первых i символов, used[i] - последний фрагмент разбиения
+	best := make([]float64, len(runes)+1)
+	used := make([]*PatternMatch, len(runes)+1)
+	prev := make([]int, len(runes)+1)
+	best[0] = 1
+
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] * alphabet
+		prev[i] = i - 1
+		for k := range matches {
+			match := &matches[k]
+			if match.End != i {
+				continue
+			}
+			if guesses := best[match.Start] * match.Guesses; guesses < best[i] {
+				best[i] = guesses
+				used[i] = match
+				prev[i] = match.Start
+			}
+		}
+	}
+
+	var chosen []PatternMatch
+	for i := len(runes); i > 0; i = prev[i] {
+		if used[i] != nil {
+			chosen = append([]PatternMatch{*used[i]}, chosen...)
+		}
+	}
+	return best[len(runes)], chosen
+}
+
+// describePatterns возвращает список найденных фрагментов для вывода пользователю
+func describePatterns(matches []PatternMatch) string {
+	parts := make([]string, 0, len(matches))
+	for _, match := range matches {
+		parts = append(parts, fmt.Sprintf("%s \"%s\"", match.Kind, match.Token))
+	}
+	return strings.Join(parts, ", ")
+}
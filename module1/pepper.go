@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// Перец - секретный ключ сервера: пароль подписывается HMAC-SHA256 с ключом до bcrypt,
+// поэтому хеши из утекшей базы без файла ключей не подобрать. Хеш с перцем имеет вид
+// "pepper:<ключ>:<bcrypt>"; идентификатор ключа позволяет сменить ключ, не сбрасывая
+// пароли: хеш со старым ключом пересчитывается текущим при следующем успешном входе.
+const pepperPrefix = "pepper:"
+
+// pepper - ключи перца, заданные при запуске (nil - перец не используется)
+var pepper *KeySet
+
+// SetPepper включает перец для HashPassword и VerifyPassword
+func SetPepper(keys *KeySet) {
+	pepper = keys
+}
+
+// pepperPassword подписывает пароль ключом. Результат в base64 (44 символа) не содержит
+// нулевых байтов и укладывается в ограничение bcrypt в 72 байта при любой длине пароля.
+func pepperPassword(key []byte, password string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	sum := mac.Sum(nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sum)))
+	base64.StdEncoding.Encode(encoded, sum)
+	return encoded
+}
+
+// splitPepperedHash разбирает хеш вида "pepper:<ключ>:<bcrypt>"
+func splitPepperedHash(hash string) (keyID, bcryptHash string, ok bool) {
+	rest, found := strings.CutPrefix(hash, pepperPrefix)
+	if !found {
+		return "", "", false
+	}
+	return strings.Cut(rest, ":")
+}
+
+// PepperKeyID возвращает идентификатор ключа перца хеша (пусто - хеш без перца)
+func PepperKeyID(hash string) string {
+	keyID, _, _ := splitPepperedHash(hash)
+	return keyID
+}
+
+// NeedsRepepper сообщает, что хеш создан без перца или не текущим ключом
+// и его стоит пересчитать при следующем успешном входе
+func NeedsRepepper(hash string) bool {
+	current := pepper.Current()
+	return current != "" && hash != "" && PepperKeyID(hash) != current
+}
+
+// repepperPassword пересчитывает хеш пароля текущим ключом перца после успешного входа.
+// Ошибка не мешает входу: хеш будет пересчитан при следующем входе.
+func (um *UserManager) repepperPassword(user *User, password string) {
+	previous := PepperKeyID(user.HashedPassword)
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return
+	}
+	changed := false
+	um.store.Update(user.Username, func(stored *User) error {
+		// Пароль могли сменить, пока проверялся старый хеш
+		if stored.HashedPassword == user.HashedPassword {
+			stored.HashedPassword = hashedPassword
+			changed = true
+		}
+		return nil
+	})
+	if !changed {
+		return
+	}
+	details := "хеш пересчитан с ключом перца " + pepper.Current()
+	if previous != "" {
+		details += " вместо " + previous
+	}
+	um.recordAudit(AuditPasswordRehash, user.Username, details)
+	um.usersChanged()
+}
+
+// PepperUsage считает хеши паролей (включая пароли под принуждением) по ключам перца;
+// ключ "" - хеши без перца. Ключ можно удалить из файла, когда им не создан ни один хеш.
+func (um *UserManager) PepperUsage() map[string]int {
+	usage := make(map[string]int)
+	for _, user := range um.store.GetAllUsers() {
+		for _, hash := range []string{user.HashedPassword, user.DuressHash} {
+			if isBcryptHash(hash) || strings.HasPrefix(hash, pepperPrefix) {
+				usage[PepperKeyID(hash)]++
+			}
+		}
+	}
+	return usage
+}
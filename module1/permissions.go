@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Права доступа. Роль - именованный набор прав: встроенная admin дает все права, включая
+// назначение ролей, user - никаких административных прав, остальные роли задаются в разделе
+// roles конфигурации политики. Права проверяет менеджер (Authorize) для запросов API -
+// по токену, ключу API или сертификату - и для административной консоли.
+type Permission string
+
+const (
+	PermUserRead     Permission = "user.read"     // Просмотр учетных записей, отчетов и метрик
+	PermUserWrite    Permission = "user.write"    // Создание, изменение и удаление учетных записей
+	PermUserUnlock   Permission = "user.unlock"   // Разблокировка без смены пароля (входит в user.write)
+	PermPolicyManage Permission = "policy.manage" // Политика паролей, ловушки, репликация и кластер
+	PermAuditRead    Permission = "audit.read"    // Журнал аудита
+	PermAuthVerify   Permission = "auth.verify"   // Проверка паролей и токенов доступа сервисами
+
+	// permAdmin - действия только для роли admin (назначение ролей); в роли не включается
+	permAdmin Permission = "admin"
+)
+
+// knownPermissions - права, которые можно включать в роли и ключи API
+var knownPermissions = map[Permission]bool{
+	PermUserRead:     true,
+	PermUserWrite:    true,
+	PermUserUnlock:   true,
+	PermPolicyManage: true,
+	PermAuditRead:    true,
+	PermAuthVerify:   true,
+}
+
+// Principal - тот, чьи права проверяются: пользователь консоли или сертификата, токен
+// или ключ API
+type Principal struct {
+	Name        string
+	Admin       bool // Все права, включая назначение ролей
+	Permissions map[Permission]bool
+}
+
+// fullAccess - права токена API из -api-token-file
+func fullAccess(name string) Principal {
+	return Principal{Name: name, Admin: true}
+}
+
+// Has сообщает, есть ли право perm; user.write включает user.unlock
+func (p Principal) Has(perm Permission) bool {
+	return p.Admin || p.Permissions[perm] || (perm == PermUserUnlock && p.Permissions[PermUserWrite])
+}
+
+// Any сообщает, есть ли хотя бы одно право
+func (p Principal) Any() bool {
+	return p.Admin || len(p.Permissions) > 0
+}
+
+// parseRoles проверяет раздел roles конфигурации: имя роли -> список прав
+func parseRoles(roles map[string][]string) (map[string][]Permission, error) {
+	parsed := make(map[string][]Permission, len(roles))
+	for name, permissions := range roles {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			return nil, fmt.Errorf("roles: пустое имя роли")
+		case name == RoleAdmin || name == RoleUser:
+			return nil, fmt.Errorf("roles: встроенную роль %q переопределить нельзя", name)
+		}
+		list, err := parsePermissions(permissions)
+		if err != nil {
+			return nil, fmt.Errorf("roles.%s: %v", name, err)
+		}
+		parsed[name] = list
+	}
+	return parsed, nil
+}
+
+// parsePermissions проверяет список прав и возвращает его без повторов по порядку
+func parsePermissions(names []string) ([]Permission, error) {
+	seen := make(map[Permission]bool)
+	var list []Permission
+	for _, name := range names {
+		perm := Permission(strings.TrimSpace(name))
+		if !knownPermissions[perm] {
+			return nil, fmt.Errorf("неизвестное право %q (доступно: %s)", name, describePermissions(allPermissions()))
+		}
+		if !seen[perm] {
+			seen[perm] = true
+			list = append(list, perm)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list, nil
+}
+
+// permissionNames возвращает названия прав
+func permissionNames(list []Permission) []string {
+	names := make([]string, len(list))
+	for i, perm := range list {
+		names[i] = string(perm)
+	}
+	return names
+}
+
+// describePermissions перечисляет права через запятую
+func describePermissions(list []Permission) string {
+	if len(list) == 0 {
+		return "нет прав"
+	}
+	return strings.Join(permissionNames(list), ", ")
+}
+
+// describeRoles описывает роли конфигурации для журнала аудита
+func describeRoles(roles map[string][]Permission) string {
+	if len(roles) == 0 {
+		return "только встроенные"
+	}
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s (%s)", name, describePermissions(roles[name]))
+	}
+	return strings.Join(names, "; ")
+}
+
+// RolePermissions возвращает права роли; admin - все права
+func (um *UserManager) RolePermissions(role string) ([]Permission, bool) {
+	switch role {
+	case RoleAdmin:
+		return allPermissions(), true
+	case "", RoleUser:
+		return nil, true
+	}
+	permissions, ok := um.roles[role]
+	return permissions, ok
+}
+
+// RoleNames возвращает имена ролей из конфигурации политики по порядку
+func (um *UserManager) RoleNames() []string {
+	names := make([]string, 0, len(um.roles))
+	for name := range um.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// allPermissions возвращает все известные права по порядку
+func allPermissions() []Permission {
+	var list []Permission
+	for perm := range knownPermissions {
+		list = append(list, perm)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list
+}
+
+// userRole возвращает роль учетной записи: admin, роль из конфигурации или user
+func userRole(user *User) string {
+	switch {
+	case user.IsAdmin:
+		return RoleAdmin
+	case user.Role != "":
+		return user.Role
+	}
+	return RoleUser
+}
+
+// UserRole возвращает роль учетной записи (пусто - учетной записи нет)
+func (um *UserManager) UserRole(username string) string {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists {
+		return ""
+	}
+	return userRole(user)
+}
+
+// principalFor возвращает права роли role для name
+func (um *UserManager) principalFor(name, role string, extra []Permission) Principal {
+	principal := Principal{Name: name, Admin: role == RoleAdmin, Permissions: make(map[Permission]bool)}
+	permissions := um.roles[role]
+	for _, perm := range append(append([]Permission(nil), permissions...), extra...) {
+		principal.Permissions[perm] = true
+	}
+	return principal
+}
+
+// UserPrincipal возвращает права пользователя по его роли. У отключенной учетной записи,
+// заявки на регистрацию и ловушки прав нет.
+func (um *UserManager) UserPrincipal(username string) Principal {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists || user.IsHoneypot || user.DisabledByAdmin || user.PendingApproval {
+		return Principal{Name: username}
+	}
+	return um.principalFor(user.Username, userRole(user), nil)
+}
+
+// Authorize проверяет право perm на действие action. Отказ записывается в журнал аудита.
+func (um *UserManager) Authorize(principal Principal, perm Permission, action string) error {
+	if principal.Has(perm) {
+		return nil
+	}
+	required := string(perm)
+	if perm == permAdmin {
+		required = "роль " + RoleAdmin
+	}
+	um.recordAudit(AuditPermissionDenied, principal.Name, fmt.Sprintf("%s: требуется %s", action, required))
+	return fmt.Errorf("недостаточно прав: требуется %s", required)
+}
+
+// SetRole назначает учетной записи роль: admin, user или роль из конфигурации. Снять роль
+// admin с последнего администратора нельзя.
+func (um *UserManager) SetRole(username, role, reason string) error {
+	username, role = strings.TrimSpace(username), strings.TrimSpace(role)
+	if role == "" {
+		role = RoleUser
+	}
+	if _, ok := um.RolePermissions(role); !ok {
+		return fmt.Errorf("неизвестная роль %q", role)
+	}
+	user, exists := um.store.GetUser(username)
+	if !exists || user.IsHoneypot {
+		return fmt.Errorf("пользователь не найден")
+	}
+	previous := userRole(user)
+	switch {
+	case previous == role:
+		return fmt.Errorf("у пользователя уже роль %s", role)
+	case previous == RoleAdmin && um.adminCount() == 1:
+		return fmt.Errorf("нельзя снять права с последнего администратора")
+	}
+
+	err := um.store.Update(username, func(user *User) error {
+		user.IsAdmin = role == RoleAdmin
+		user.Role = ""
+		if role != RoleAdmin && role != RoleUser {
+			user.Role = role
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	details := strings.TrimSpace(fmt.Sprintf("роль: %s -> %s %s", previous, role, reason))
+	switch {
+	case role == RoleAdmin:
+		um.recordAudit(AuditAdminGranted, username, details)
+	case previous == RoleAdmin:
+		um.recordAudit(AuditAdminRevoked, username, details)
+	default:
+		um.recordAudit(AuditRoleChanged, username, details)
+	}
+	return nil
+}
+
+// APIKey - ключ доступа к API с ограниченными правами (файл -api-keys). В файле хранится
+// только хеш SHA-256 ключа.
+type APIKey struct {
+	Name        string       `json:"name"`
+	TokenSHA256 string       `json:"token_sha256"`          // Хеш ключа в hex: echo -n "$KEY" | sha256sum
+	Role        string       `json:"role,omitempty"`        // Права роли из конфигурации политики или admin
+	Permissions []Permission `json:"permissions,omitempty"` // Права сверх роли
+}
+
+// LoadAPIKeys читает ключи API из JSON-файла
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ключей API: %v", err)
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("некорректный формат ключей API: %v", err)
+	}
+	names := make(map[string]bool)
+	for i, key := range keys {
+		if key.Name == "" || names[key.Name] {
+			return nil, fmt.Errorf("ключ API %d: имя пустое или повторяется", i+1)
+		}
+		names[key.Name] = true
+		if digest, err := hex.DecodeString(key.TokenSHA256); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("ключ API %s: token_sha256 - хеш SHA-256 в hex", key.Name)
+		}
+		if keys[i].Permissions, err = parsePermissions(permissionNames(key.Permissions)); err != nil {
+			return nil, fmt.Errorf("ключ API %s: %v", key.Name, err)
+		}
+		if key.Role == "" && len(key.Permissions) == 0 {
+			return nil, fmt.Errorf("ключ API %s: нужна роль или права", key.Name)
+		}
+		keys[i].TokenSHA256 = strings.ToLower(key.TokenSHA256)
+	}
+	return keys, nil
+}
+
+// findAPIKey возвращает ключ API, хеш которого совпадает с хешем token
+func findAPIKey(keys []APIKey, token string) (APIKey, bool) {
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(key.TokenSHA256)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// APIKeyPrincipal возвращает права ключа API: права его роли и перечисленные в ключе.
+// Роль, удаленная из конфигурации, прав не дает.
+func (um *UserManager) APIKeyPrincipal(key APIKey) Principal {
+	return um.principalFor("ключ API "+key.Name, key.Role, key.Permissions)
+}
+
+// principalKey - ключ контекста запроса API, в котором хранятся права подписавшего его
+type principalKey struct{}
+
+// requestPrincipal возвращает права, с которыми выполняется запрос API
+func requestPrincipal(r *http.Request) Principal {
+	principal, _ := r.Context().Value(principalKey{}).(Principal)
+	return principal
+}
+
+// apiPrincipal определяет, кто подписал запрос: токен API дает все права, ключ API - права
+// ключа, сертификат клиента - права роли привязанной учетной записи
+func (s *APIServer) apiPrincipal(r *http.Request) (Principal, bool) {
+	const bearer = "Bearer "
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, bearer) {
+		token := strings.TrimPrefix(header, bearer)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return fullAccess("токен API"), true
+		}
+		if key, ok := findAPIKey(s.apiKeys, token); ok {
+			return s.um.APIKeyPrincipal(key), true
+		}
+	}
+	return s.certificatePrincipal(r)
+}
+
+// apiPermission возвращает право, необходимое для запроса (пусто - достаточно подписи)
+func apiPermission(method, path string) Permission {
+	switch {
+	case path == "/errors":
+		return ""
+	case path == "/auth" || path == "/verify" || path == "/introspect":
+		return PermAuthVerify
+	case path == "/policy" || path == "/replication" || path == "/replication/promote" || path == "/cluster":
+		return PermPolicyManage
+	case path == "/register" || path == "/impersonate":
+		return PermUserWrite
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/authorized-keys"):
+		return PermAuthVerify
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/audit"):
+		return PermAuditRead
+	case strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/unlock"):
+		return PermUserUnlock
+	case method != http.MethodGet && (path == "/groups" || strings.HasPrefix(path, "/groups/") && !strings.Contains(path, "/members/")):
+		// Аннотации групп меняют правила входа участников
+		return PermPolicyManage
+	case method == http.MethodGet:
+		return PermUserRead
+	}
+	return PermUserWrite
+}
+
+// authorizeAPI проверяет права запроса и сохраняет их в контексте для обработчиков
+func (s *APIServer) authorizeAPI(w http.ResponseWriter, r *http.Request, principal Principal) (*http.Request, bool) {
+	perm := apiPermission(r.Method, strings.TrimPrefix(r.URL.Path, apiPrefix))
+	if perm != "" {
+		if err := s.um.Authorize(principal, perm, r.Method+" "+r.URL.Path); err != nil {
+			writeAPIError(w, http.StatusForbidden, CodePermissionDenied, err.Error())
+			return nil, false
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)), true
+}
+
+// authorizeRole проверяет изменение учетной записи с ролью current на роль requested:
+// назначать роли и менять учетные записи администраторов может только администратор,
+// иначе право user.write позволило бы выдать себе любые права
+func (s *APIServer) authorizeRole(w http.ResponseWriter, r *http.Request, current, requested string) bool {
+	if requested == "" {
+		requested = RoleUser
+	}
+	if current != RoleAdmin && requested == current {
+		return true
+	}
+	if err := s.um.Authorize(requestPrincipal(r), permAdmin, "роль "+current+" -> "+requested); err != nil {
+		writeAPIError(w, http.StatusForbidden, CodePermissionDenied, err.Error())
+		return false
+	}
+	return true
+}
+
+// handleUserUnlock: POST /users/<логин>/unlock - разблокировка без смены пароля
+// (право user.unlock, например у роли службы поддержки)
+func (s *APIServer) handleUserUnlock(w http.ResponseWriter, r *http.Request, username string) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	user, exists := s.um.store.GetUser(username)
+	if !exists || user.IsHoneypot {
+		writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+		return
+	}
+	if !user.IsBlocked {
+		writeAPIError(w, http.StatusConflict, CodeUserRejected, "учетная запись не заблокирована")
+		return
+	}
+	reason, ok := readAPIReason(w, r)
+	if !ok {
+		return
+	}
+	if reason, ok = s.approve(w, r, dualControlUnlock, username, reason); !ok {
+		return
+	}
+	if err := s.um.UnlockUser(username, reason); err != nil {
+		writeAPIError(w, http.StatusConflict, CodeUserRejected, err.Error())
+		return
+	}
+	user, _ = s.um.store.GetUser(username)
+	result := newAPIUser(user)
+	w.Header().Set("ETag", result.ETag())
+	writeAPIJSON(w, http.StatusOK, result)
+}
+
+// handleUserAudit: GET /users/<логин>/audit - записи журнала аудита об учетной записи
+func (s *APIServer) handleUserAudit(w http.ResponseWriter, r *http.Request, username string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	if s.um.audit == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "журнал аудита отключен")
+		return
+	}
+	records, err := s.um.audit.RecordsFor(username)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, records)
+}
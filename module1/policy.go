@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// AnalysisResult - результат количественной оценки стойкости паролей
+// (вывод "go run password_analysis.go -format json" из модуля 2)
+type AnalysisResult struct {
+	LowerBound   float64               `json:"lower_bound"` // Нижняя граница S*
+	Combinations []AnalysisCombination `json:"combinations"`
+}
+
+// AnalysisCombination - подходящая пара алфавит/длина из результата анализа
+type AnalysisCombination struct {
+	AlphabetSize int    `json:"alphabet_size"`
+	AlphabetName string `json:"alphabet_name"`
+	MinLength    int    `json:"min_length"`
+}
+
+// LoadAnalysisResult читает результат анализа в формате JSON
+func LoadAnalysisResult(r io.Reader) (AnalysisResult, error) {
+	var result AnalysisResult
+	if err := json.NewDecoder(r).Decode(&result); err != nil {
+		return result, fmt.Errorf("некорректный результат анализа: %v", err)
+	}
+	if result.LowerBound < 1 {
+		return result, fmt.Errorf("в результате анализа отсутствует нижняя граница S*")
+	}
+	return result, nil
+}
+
+// RulesFromAnalysis строит правила паролей по нижней границе S* и выбранному алфавиту:
+// длина - минимальная L, при которой A^L >= S*, а обязательные классы символов
+// соответствуют составу алфавита
+func RulesFromAnalysis(result AnalysisResult, alphabetSize int) (PasswordRules, error) {
+	rules := PasswordRules{}
+
+	switch alphabetSize {
+	case 10:
+		rules.RequireDigits = true
+	case 26:
+		rules.RequireLowercase = true
+	case 36:
+		rules.RequireLowercase, rules.RequireDigits = true, true
+	case 52:
+		rules.RequireUppercase, rules.RequireLowercase = true, true
+	case 62:
+		rules.RequireUppercase, rules.RequireLowercase, rules.RequireDigits = true, true, true
+	case 95:
+		rules.RequireUppercase, rules.RequireLowercase, rules.RequireDigits, rules.RequireSpecial = true, true, true, true
+	default:
+		return rules, fmt.Errorf("алфавит мощностью %d не соответствует классам символов системы (10, 26, 36, 52, 62, 95)", alphabetSize)
+	}
+
+	length := int(math.Ceil(math.Log(result.LowerBound) / math.Log(float64(alphabetSize))))
+	if length < 4 {
+		length = 4 // Минимальная длина, которую поддерживает генератор
+	}
+	rules.Length = length
+
+	// Требуем хотя бы один символ каждого класса, входящего в алфавит
+	if rules.RequireUppercase {
+		rules.MinUppercase = 1
+	}
+	if rules.RequireLowercase {
+		rules.MinLowercase = 1
+	}
+	if rules.RequireDigits {
+		rules.MinDigits = 1
+	}
+	if rules.RequireSpecial {
+		rules.MinSpecial = 1
+	}
+
+	return rules, nil
+}
+
+// PasswordRules возвращает действующие правила паролей
+func (um *UserManager) PasswordRules() PasswordRules {
+	return um.rules
+}
+
+// SetPasswordRules задает правила паролей для регистрации и смены пароля
+func (um *UserManager) SetPasswordRules(rules PasswordRules) error {
+	if err := validatePasswordRules(rules); err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("длина %d, классы: %s", rules.Length, describeCharClasses(rules))
+	if um.dryRun {
+		um.planChange(AuditPolicyChanged, "", details)
+		return nil
+	}
+
+	um.rules = rules
+	um.rulesChangedAt = time.Now()
+	um.recordAudit(AuditPolicyChanged, "", details)
+	return nil
+}
+
+// validatePasswordRules проверяет, что по правилам можно составить пароль
+func validatePasswordRules(rules PasswordRules) error {
+	minRequired := rules.MinUppercase + rules.MinLowercase + rules.MinDigits + rules.MinSpecial
+	if rules.Length < 4 {
+		return fmt.Errorf("длина пароля должна быть минимум 4 символа")
+	}
+	if minRequired > rules.Length {
+		return fmt.Errorf("сумма минимальных требований (%d) превышает длину пароля (%d)", minRequired, rules.Length)
+	}
+	if !rules.RequireUppercase && !rules.RequireLowercase && !rules.RequireDigits && !rules.RequireSpecial {
+		return fmt.Errorf("не выбран ни один набор символов")
+	}
+	return nil
+}
+
+// describeCharClasses перечисляет обязательные классы символов
+func describeCharClasses(rules PasswordRules) string {
+	classes := ""
+	if rules.RequireUppercase {
+		classes += "A-Z "
+	}
+	if rules.RequireLowercase {
+		classes += "a-z "
+	}
+	if rules.RequireDigits {
+		classes += "0-9 "
+	}
+	if rules.RequireSpecial {
+		classes += "спецсимволы "
+	}
+	if classes == "" {
+		return "нет"
+	}
+	return classes[:len(classes)-1]
+}
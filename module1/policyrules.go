@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Правила политики входа (раздел rules файла политики): условие на языке правил динамических
+// групп (grouprules.go) и действие, которое выполняется при входе, если условие истинно.
+// Кроме атрибутов учетной записи, в условии доступны атрибуты входа (loginAttributes),
+// например: role == "contractor" && (hour < 9 || hour >= 18) - deny,
+// failed_attempts > 0 - require_2fa. Правила проверяются по порядку, срабатывает первое
+// подходящее правило с нужным действием.
+
+// Действия правил политики
+const (
+	ruleActionDeny       = "deny"        // Вход запрещен любым способом
+	ruleActionRequire2FA = "require_2fa" // Вход только паролем запрещен, как в группе с аннотацией require_2fa
+)
+
+// loginAttributes - атрибуты, которые вычисляются в момент входа; задать их нельзя
+var loginAttributes = map[string]string{
+	"hour":            "час входа по местному времени, 0-23",
+	"weekday":         "день недели входа: 1 - понедельник, 7 - воскресенье",
+	"failed_attempts": "неудачных попыток входа в окне подсчета до этой попытки",
+}
+
+// PolicyRule - правило политики входа
+type PolicyRule struct {
+	Name   string    `json:"name"`
+	When   string    `json:"when"`   // Условие на языке правил групп
+	Action string    `json:"action"` // deny или require_2fa
+	match  groupRule // Разобранное условие
+}
+
+// parsePolicyRules проверяет раздел rules конфигурации
+func parsePolicyRules(rules []PolicyRule) ([]PolicyRule, error) {
+	parsed := make([]PolicyRule, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if rule.Name = strings.TrimSpace(rule.Name); rule.Name == "" || len(rule.Name) > 64 {
+			return nil, fmt.Errorf("rules[%d].name: имя правила должно содержать от 1 до 64 символов", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rules: правило %s задано дважды", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Action != ruleActionDeny && rule.Action != ruleActionRequire2FA {
+			return nil, fmt.Errorf("rules.%s.action: допустимо %s или %s", rule.Name, ruleActionDeny, ruleActionRequire2FA)
+		}
+		rule.When = strings.TrimSpace(rule.When)
+		match, err := parseGroupRule(rule.When)
+		if err != nil {
+			return nil, fmt.Errorf("rules.%s.when: %v", rule.Name, err)
+		}
+		rule.match = match
+		parsed = append(parsed, rule)
+	}
+	return parsed, nil
+}
+
+// describePolicyRules описывает правила для журнала аудита
+func describePolicyRules(rules []PolicyRule) string {
+	if len(rules) == 0 {
+		return "нет"
+	}
+	described := make([]string, len(rules))
+	for i, rule := range rules {
+		described[i] = fmt.Sprintf("%s (%s: %s)", rule.Name, rule.Action, rule.When)
+	}
+	return strings.Join(described, "; ")
+}
+
+// userLoginAttributes возвращает атрибуты учетной записи вместе с атрибутами входа в момент now
+func (um *UserManager) userLoginAttributes(user *User, now time.Time) map[string]string {
+	attributes := userAttributes(user)
+	attributes["hour"] = strconv.Itoa(now.Hour())
+	attributes["weekday"] = strconv.Itoa((int(now.Weekday())+6)%7 + 1)
+	attributes["failed_attempts"] = strconv.Itoa(len(um.failuresInWindow(user, now)))
+	return attributes
+}
+
+// matchPolicyRule возвращает первое правило с действием action, условие которого истинно
+// для входа пользователя в момент now
+func (um *UserManager) matchPolicyRule(user *User, action string, now time.Time) (PolicyRule, bool) {
+	if len(um.policyRules) == 0 {
+		return PolicyRule{}, false
+	}
+	attributes := um.userLoginAttributes(user, now)
+	for _, rule := range um.policyRules {
+		if rule.Action == action && rule.match(attributes) {
+			return rule, true
+		}
+	}
+	return PolicyRule{}, false
+}
+
+// WritePolicyRuleTest выводит, какие правила сработали бы при входе пользователя в момент
+// now, ничего не меняя и не записывая в журнал аудита. Непустое условие when проверяется
+// вместо правил из конфигурации.
+func (um *UserManager) WritePolicyRuleTest(w io.Writer, username string, now time.Time, when string) error {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists || user.IsHoneypot {
+		return fmt.Errorf("пользователь не найден")
+	}
+	rules := um.policyRules
+	if when = strings.TrimSpace(when); when != "" {
+		match, err := parseGroupRule(when)
+		if err != nil {
+			return fmt.Errorf("условие: %v", err)
+		}
+		rules = []PolicyRule{{Name: "проверяемое", When: when, match: match}}
+	}
+
+	attributes := um.userLoginAttributes(user, now)
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "Вход %s в %s, атрибуты:\n", user.Username, now.Format("2006-01-02 15:04"))
+	for _, key := range keys {
+		fmt.Fprintf(out, "  %s = %q\n", key, attributes[key])
+	}
+	if len(rules) == 0 {
+		fmt.Fprintln(out, "Правила политики не заданы")
+		return out.Flush()
+	}
+
+	verdict := "правила вход не ограничивают"
+	decided := map[string]bool{}
+	for _, rule := range rules {
+		matched := rule.match(attributes)
+		result := "не выполнено"
+		if matched {
+			result = "выполнено"
+		}
+		action := ""
+		if rule.Action != "" {
+			action = " -> " + rule.Action
+		}
+		fmt.Fprintf(out, "%s: %s - %s%s\n", rule.Name, rule.When, result, action)
+		if !matched || rule.Action == "" || decided[rule.Action] {
+			continue
+		}
+		decided[rule.Action] = true
+		switch {
+		case rule.Action == ruleActionDeny:
+			verdict = "вход запрещен правилом " + rule.Name
+		case !decided[ruleActionDeny]:
+			verdict = "вход только паролем запрещен правилом " + rule.Name
+		}
+	}
+	if when == "" {
+		fmt.Fprintln(out, "Итог: "+verdict)
+	}
+	return out.Flush()
+}
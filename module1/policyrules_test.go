@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupRuleComparisons(t *testing.T) {
+	attributes := map[string]string{"hour": "20", "failed_attempts": "0", "role": "contractor", "level": "x"}
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{`hour >= 18`, true},
+		{`hour > 20`, false},
+		{`hour <= 20 && hour < 21`, true},
+		{`role == "contractor" && (hour < 9 || hour >= 18)`, true},
+		{`failed_attempts > 0`, false},
+		{`10 < hour`, true},
+		{`level > 1`, false},   // Не число - сравнение ложно
+		{`missing < 1`, false}, // Отсутствующий атрибут - тоже
+		{`!(level >= 1)`, true},
+		{`role != "admin"`, true},
+	}
+	for _, tt := range tests {
+		match, err := parseGroupRule(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.rule, err)
+		}
+		if got := match(attributes); got != tt.want {
+			t.Errorf("%s = %v, ожидается %v", tt.rule, got, tt.want)
+		}
+	}
+
+	for _, rule := range []string{`hour >`, `hour < < 9`, `hour => 9`, `>= 9`} {
+		if _, err := parseGroupRule(rule); err == nil {
+			t.Errorf("правило %q принято", rule)
+		}
+	}
+}
+
+func TestParsePolicyRulesRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []PolicyRule
+		want  string
+	}{
+		{"без имени", []PolicyRule{{When: "admin", Action: ruleActionDeny}}, "rules[0].name"},
+		{"повтор имени", []PolicyRule{{Name: "a", When: "admin", Action: ruleActionDeny}, {Name: "a", When: "blocked", Action: ruleActionDeny}}, "дважды"},
+		{"неизвестное действие", []PolicyRule{{Name: "a", When: "admin", Action: "allow"}}, "rules.a.action"},
+		{"пустое условие", []PolicyRule{{Name: "a", Action: ruleActionDeny}}, "rules.a.when"},
+		{"ошибка в условии", []PolicyRule{{Name: "a", When: "hour >", Action: ruleActionDeny}}, "rules.a.when"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			um := NewUserManager()
+			_, err := um.ApplyPolicyConfig(PolicyConfig{Rules: tt.rules})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ошибка %v, ожидается %q", err, tt.want)
+			}
+			if len(um.policyRules) != 0 {
+				t.Errorf("правила применены несмотря на ошибку")
+			}
+		})
+	}
+}
+
+// newRulesManager возвращает менеджер с правилами из примеров: подрядчикам вход вне 9-18
+// запрещен, после неудачной попытки нужен второй фактор
+func newRulesManager(t *testing.T) *UserManager {
+	t.Helper()
+	um := NewUserManager()
+	_, err := um.ApplyPolicyConfig(PolicyConfig{Rules: []PolicyRule{
+		{Name: "contractor-hours", When: `kind == "contractor" && (hour < 9 || hour >= 18)`, Action: ruleActionDeny},
+		{Name: "after-failure", When: "failed_attempts > 0", Action: ruleActionRequire2FA},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := HashPassword("Correct-Horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	um.store.SaveUser(&User{Username: "carol", HashedPassword: hash, Attributes: map[string]string{"kind": "contractor"}})
+	um.store.SaveUser(&User{Username: "dave", HashedPassword: hash})
+	return um
+}
+
+func TestPolicyRulesMatch(t *testing.T) {
+	um := newRulesManager(t)
+	carol, _ := um.store.GetUser("carol")
+	dave, _ := um.store.GetUser("dave")
+	day := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		user   *User
+		hour   int
+		action string
+		want   string // Сработавшее правило (пусто - ни одно)
+	}{
+		{"подрядчик днем", carol, 10, ruleActionDeny, ""},
+		{"подрядчик вечером", carol, 18, ruleActionDeny, "contractor-hours"},
+		{"подрядчик ночью", carol, 3, ruleActionDeny, "contractor-hours"},
+		{"сотрудник вечером", dave, 20, ruleActionDeny, ""},
+		{"без неудачных попыток", dave, 10, ruleActionRequire2FA, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, found := um.matchPolicyRule(tt.user, tt.action, day.Add(time.Duration(tt.hour)*time.Hour))
+			if found != (tt.want != "") || rule.Name != tt.want {
+				t.Errorf("сработало правило %q, ожидается %q", rule.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRulesAtLogin(t *testing.T) {
+	um := newRulesManager(t)
+
+	// Без неудачных попыток пароля достаточно
+	if outcome, err := um.AuthenticateUser("dave", "Correct-Horse-1"); err != nil || outcome.Result != AuthSuccess {
+		t.Fatalf("вход dave: %v (%v)", outcome, err)
+	}
+	// После неудачной попытки правило требует второго фактора, и счетчик не сбрасывается
+	um.AuthenticateUser("dave", "wrong")
+	for i := 0; i < 2; i++ {
+		if outcome, _ := um.AuthenticateUser("dave", "Correct-Horse-1"); outcome.Result != AuthSecondFactorRequired {
+			t.Fatalf("вход dave после неудачи: %v", outcome)
+		}
+	}
+
+	// Запрещающее правило действует до проверки пароля и для ключей SSH
+	um.store.Update("carol", func(user *User) error {
+		user.SSHKeys = []SSHKey{{Fingerprint: "SHA256:test"}}
+		return nil
+	})
+	_, denied := um.matchPolicyRule(mustGetUser(t, um, "carol"), ruleActionDeny, time.Now())
+	outcome, _ := um.AuthenticateUser("carol", "wrong")
+	if denied != (outcome.Result == AuthRejectedByRule) {
+		t.Errorf("вход carol: %v, правило запрещает: %v", outcome, denied)
+	}
+	if keys := um.AuthorizedKeys("carol", time.Now()); denied != (len(keys) == 0) {
+		t.Errorf("ключей SSH для carol: %d, правило запрещает: %v", len(keys), denied)
+	}
+	if carol := mustGetUser(t, um, "carol"); denied && carol.FailedAttempts != 0 {
+		t.Errorf("отказ по правилу засчитан как неудачная попытка")
+	}
+}
+
+func mustGetUser(t *testing.T, um *UserManager, username string) *User {
+	t.Helper()
+	user, exists := um.store.GetUser(username)
+	if !exists {
+		t.Fatalf("пользователь %s не найден", username)
+	}
+	return user
+}
+
+func TestWritePolicyRuleTest(t *testing.T) {
+	um := newRulesManager(t)
+	evening := time.Date(2026, 10, 16, 20, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		username string
+		at       time.Time
+		when     string
+		want     []string
+	}{
+		{"подрядчик вечером", "carol", evening, "", []string{`hour = "20"`, `weekday = "5"`, "contractor-hours: ", "выполнено -> deny", "Итог: вход запрещен правилом contractor-hours"}},
+		{"подрядчик днем", "carol", evening.Add(-8 * time.Hour), "", []string{"Итог: правила вход не ограничивают"}},
+		{"произвольное условие", "dave", evening, "weekday >= 6", []string{"проверяемое: weekday >= 6 - не выполнено"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := um.WritePolicyRuleTest(&out, tt.username, tt.at, tt.when); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("в выводе нет %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+
+	if err := um.WritePolicyRuleTest(&strings.Builder{}, "carol", evening, "hour >"); err == nil {
+		t.Errorf("некорректное условие принято")
+	}
+	if outcome, _ := um.AuthenticateUser("dave", "Correct-Horse-1"); outcome.Result != AuthSuccess {
+		t.Errorf("проверка правил изменила состояние: %v", outcome)
+	}
+}
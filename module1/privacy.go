@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// UserDataExport - все данные, которые система хранит о пользователе
+type UserDataExport struct {
+	ExportedAt  time.Time       `json:"exported_at"`
+	Profile     UserProfileData `json:"profile"`
+	AuditEvents []AuditRecord   `json:"audit_events"`
+}
+
+// UserProfileData - данные учетной записи без секретов (хеш пароля не выгружается)
+type UserProfileData struct {
+	Username           string            `json:"username"`
+	Email              string            `json:"email,omitempty"`
+	CreatedAt          time.Time         `json:"created_at"`
+	LastLoginAt        *time.Time        `json:"last_login_at,omitempty"`
+	FailedAttempts     int               `json:"failed_attempts"`
+	IsBlocked          bool              `json:"is_blocked"`
+	Disabled           bool              `json:"disabled_by_admin"`
+	BlockedAt          *time.Time        `json:"blocked_at,omitempty"`
+	DormantSince       *time.Time        `json:"dormant_since,omitempty"`
+	LoginSchedule      string            `json:"login_schedule,omitempty"`
+	TermsVersion       string            `json:"accepted_terms_version,omitempty"`
+	TermsAccepted      *time.Time        `json:"terms_accepted_at,omitempty"`
+	HasPassword        bool              `json:"has_password"`
+	Admin              bool              `json:"admin"`
+	Role               string            `json:"role,omitempty"` // Роль из конфигурации политики
+	SAMLNameID         string            `json:"saml_name_id,omitempty"`
+	SSHKeys            []string          `json:"ssh_keys,omitempty"` // Строки открытых ключей SSH
+	Groups             []string          `json:"groups,omitempty"`
+	Attributes         map[string]string `json:"attributes,omitempty"`          // Атрибуты для правил групп
+	ClientCertificates []string          `json:"client_certificates,omitempty"` // Привязки сертификатов клиента (вид:значение)
+	Devices            []KnownDevice     `json:"devices,omitempty"`             // Устройства, с которых выполнялся вход через API
+}
+
+// optionalTime возвращает nil для нулевого времени, чтобы не выгружать пустые даты
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ExportUserData собирает данные пользователя и события аудита, в которых он упоминается
+func (um *UserManager) ExportUserData(username string) (UserDataExport, error) {
+	username = strings.TrimSpace(username)
+
+	user, exists := um.store.GetUser(username)
+	if !exists {
+		return UserDataExport{}, fmt.Errorf("пользователь не найден")
+	}
+
+	export := UserDataExport{
+		ExportedAt: time.Now(),
+		Profile: UserProfileData{
+			Username:       user.Username,
+			Email:          user.Email,
+			CreatedAt:      user.CreatedAt,
+			LastLoginAt:    optionalTime(user.LastLoginAt),
+			FailedAttempts: user.FailedAttempts,
+			IsBlocked:      user.IsBlocked,
+			Disabled:       user.DisabledByAdmin,
+			BlockedAt:      optionalTime(user.BlockedAt),
+			DormantSince:   optionalTime(user.DormantSince),
+			TermsVersion:   user.AcceptedTermsVersion,
+			TermsAccepted:  optionalTime(user.TermsAcceptedAt),
+			HasPassword:    user.HashedPassword != "" || user.LegacyHash != "",
+			Admin:          user.IsAdmin,
+			Role:           user.Role,
+			Groups:         user.Groups,
+			Attributes:     user.Attributes,
+			SAMLNameID:     user.SAMLNameID,
+			Devices:        user.KnownDevices,
+		},
+		AuditEvents: []AuditRecord{},
+	}
+	if user.Schedule != nil {
+		export.Profile.LoginSchedule = user.Schedule.String()
+	}
+	for _, key := range user.SSHKeys {
+		export.Profile.SSHKeys = append(export.Profile.SSHKeys, key.AuthorizedKeysLine())
+	}
+	for _, binding := range user.CertBindings {
+		export.Profile.ClientCertificates = append(export.Profile.ClientCertificates, binding.String())
+	}
+
+	if um.audit != nil {
+		events, err := um.audit.RecordsFor(username)
+		if err != nil {
+			return UserDataExport{}, err
+		}
+		export.AuditEvents = events
+	}
+
+	return export, nil
+}
+
+// JSON возвращает выгрузку в формате JSON
+func (e UserDataExport) JSON() (string, error) {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("ошибка формирования JSON: %v", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// EraseUser удаляет учетную запись по запросу пользователя (право на забвение).
+// Записи журнала аудита не изменяются, чтобы не разорвать цепочку хешей:
+// факт удаления записывается под псевдонимом, а старые записи удаляются
+// вместе с ротированными файлами по истечении срока хранения.
+func (um *UserManager) EraseUser(username string) (string, error) {
+	return um.eraseUser(username, "учетная запись удалена по запросу пользователя")
+}
+
+// RemoveUser удаляет учетную запись по решению администратора. Запись в журнал аудита
+// и хранение для восстановления - как при удалении по запросу пользователя.
+func (um *UserManager) RemoveUser(username, reason string) (string, error) {
+	if um.adminCount() == 1 && um.IsAdmin(username) {
+		return "", fmt.Errorf("нельзя удалить последнего администратора")
+	}
+	return um.eraseUser(username, "учетная запись удалена администратором: "+reason)
+}
+
+// eraseUser удаляет учетную запись и записывает details в журнал аудита под псевдонимом
+func (um *UserManager) eraseUser(username, details string) (string, error) {
+	username = strings.TrimSpace(username)
+
+	user, exists := um.store.GetUser(username)
+	if !exists {
+		return "", fmt.Errorf("пользователь не найден")
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := io.ReadFull(randomSource, suffix); err != nil {
+		return "", fmt.Errorf("ошибка генерации псевдонима: %v", err)
+	}
+	pseudonym := "erased-" + hex.EncodeToString(suffix)
+
+	// Под принуждением учетная запись не удаляется, но для атакующего удаление выглядит успешным
+	if user.UnderDuress {
+		um.recordAudit(AuditDuressLogin, username, "попытка удаления учетной записи под принуждением")
+		return pseudonym, nil
+	}
+
+	if um.dryRun {
+		um.planChange(AuditAccountErased, username, details+", в журнал под псевдонимом "+pseudonym)
+		return pseudonym, nil
+	}
+
+	um.store.DeleteUser(username)
+	if um.deletionRetention > 0 {
+		account := um.retainDeleted(user, pseudonym, time.Now())
+		details += fmt.Sprintf(", восстановление возможно до %s", account.PurgeAt.Format("2006-01-02 15:04"))
+	}
+	um.recordAudit(AuditAccountErased, pseudonym, details)
+	um.usersChanged()
+
+	return pseudonym, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Постепенное заполнение профиля: раздел profile_steps политики требует от пользователя
+// после заданного числа входов или дней с регистрации выполнить дополнительные шаги -
+// указать адрес электронной почты или подключить второй фактор. Каждый вход с
+// невыполненным шагом откладывает его, пока не исчерпан лимит snoozes; после этого
+// вход отклоняется (AuthProfileRequired), пока шаг не выполнен. Число входов и
+// отложенных напоминаний хранится в учетной записи.
+const (
+	ProfileStepEmail = "email"  // Указать адрес электронной почты (пользователь - при входе в меню)
+	ProfileStepTwoFA = "two_fa" // Подключить второй фактор: связь с IdP (SAML) или сертификат клиента (администратор)
+)
+
+// ProfileStep - шаг профиля в конфигурации политики
+type ProfileStep struct {
+	Step        string `json:"step"`                   // email или two_fa
+	AfterLogins int    `json:"after_logins,omitempty"` // Требовать после стольких успешных входов
+	AfterDays   int    `json:"after_days,omitempty"`   // Требовать через столько дней после регистрации
+	Snoozes     int    `json:"snoozes,omitempty"`      // Сколько раз можно отложить (0 - сразу обязателен)
+}
+
+// ProfileStepDue - шаг профиля, который пользователю пора выполнить
+type ProfileStepDue struct {
+	Step        string `json:"step"`
+	SnoozesLeft int    `json:"snoozes_left"` // Сколько раз еще можно отложить (0 - без шага вход не выполняется)
+}
+
+// parseProfileSteps проверяет раздел profile_steps конфигурации
+func parseProfileSteps(steps []ProfileStep) ([]ProfileStep, error) {
+	parsed := make([]ProfileStep, 0, len(steps))
+	seen := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		step.Step = strings.TrimSpace(step.Step)
+		switch {
+		case step.Step != ProfileStepEmail && step.Step != ProfileStepTwoFA:
+			return nil, fmt.Errorf("profile_steps: неизвестный шаг %q (допустимо: %s, %s)", step.Step, ProfileStepEmail, ProfileStepTwoFA)
+		case seen[step.Step]:
+			return nil, fmt.Errorf("profile_steps: шаг %s задан дважды", step.Step)
+		case step.AfterLogins < 0 || step.AfterDays < 0 || step.Snoozes < 0:
+			return nil, fmt.Errorf("profile_steps.%s: значения не могут быть отрицательными", step.Step)
+		}
+		seen[step.Step] = true
+		parsed = append(parsed, step)
+	}
+	return parsed, nil
+}
+
+// describeProfileSteps описывает шаги профиля для журнала аудита
+func describeProfileSteps(steps []ProfileStep) string {
+	if len(steps) == 0 {
+		return "нет"
+	}
+	described := make([]string, 0, len(steps))
+	for _, step := range steps {
+		var when []string
+		if step.AfterLogins > 0 {
+			when = append(when, fmt.Sprintf("после %d входов", step.AfterLogins))
+		}
+		if step.AfterDays > 0 {
+			when = append(when, fmt.Sprintf("через %d дн.", step.AfterDays))
+		}
+		if len(when) == 0 {
+			when = append(when, "сразу")
+		}
+		described = append(described, fmt.Sprintf("%s %s, отложить %d раз", step.Step, strings.Join(when, " или "), step.Snoozes))
+	}
+	return strings.Join(described, "; ")
+}
+
+// profileStepDone сообщает, что шаг профиля уже выполнен
+func profileStepDone(user *User, step string) bool {
+	switch step {
+	case ProfileStepEmail:
+		return user.Email != ""
+	case ProfileStepTwoFA:
+		return userAttributes(user)["two_fa"] == "true"
+	}
+	return true
+}
+
+// profileStepReached сообщает, что пользователю пора выполнить шаг: набрано число входов
+// или прошло число дней с регистрации
+func profileStepReached(user *User, step ProfileStep, now time.Time) bool {
+	if step.AfterLogins == 0 && step.AfterDays == 0 {
+		return true
+	}
+	return step.AfterLogins > 0 && user.LoginCount >= step.AfterLogins ||
+		step.AfterDays > 0 && !now.Before(user.CreatedAt.AddDate(0, 0, step.AfterDays))
+}
+
+// dueProfileSteps возвращает шаги, которые пользователю пора выполнить, с учетом того, что
+// текущий вход отложит их еще раз, и шаги, которые откладывать больше нельзя
+func (um *UserManager) dueProfileSteps(user *User, now time.Time) (due []ProfileStepDue, required []string) {
+	for _, step := range um.profileSteps {
+		if profileStepDone(user, step.Step) || !profileStepReached(user, step, now) {
+			continue
+		}
+		used := user.ProfileSnoozes[step.Step]
+		if used >= step.Snoozes {
+			required = append(required, step.Step)
+			due = append(due, ProfileStepDue{Step: step.Step})
+			continue
+		}
+		due = append(due, ProfileStepDue{Step: step.Step, SnoozesLeft: step.Snoozes - used - 1})
+	}
+	return due, required
+}
+
+// snoozeProfileSteps учитывает вход: увеличивает счетчик входов и откладывает шаги due.
+// Вызывается внутри обновления записи хранилища.
+func snoozeProfileSteps(user *User, due []ProfileStepDue) {
+	user.LoginCount++
+	for step := range user.ProfileSnoozes {
+		if profileStepDone(user, step) {
+			delete(user.ProfileSnoozes, step)
+		}
+	}
+	for _, step := range due {
+		if user.ProfileSnoozes == nil {
+			user.ProfileSnoozes = make(map[string]int)
+		}
+		user.ProfileSnoozes[step.Step]++
+	}
+}
+
+// CompleteProfileEmail выполняет шаг email: сохраняет адрес, указанный пользователем
+// после проверки пароля
+func (um *UserManager) CompleteProfileEmail(username, email string) error {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return fmt.Errorf("некорректный адрес электронной почты: %s", email)
+	}
+	err = um.store.Update(strings.TrimSpace(username), func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		user.Email = address.Address
+		delete(user.ProfileSnoozes, ProfileStepEmail)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditProfileStepCompleted, strings.TrimSpace(username), "шаг "+ProfileStepEmail+": "+address.Address)
+	return nil
+}
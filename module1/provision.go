@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ProvisionedCredential - учетная запись, созданная администратором, и ее временный пароль
+type ProvisionedCredential struct {
+	Username string
+	Password string
+}
+
+// ProvisionResult - итог массового создания учетных записей
+type ProvisionResult struct {
+	Created []ProvisionedCredential // Созданы со сгенерированным паролем
+	Skipped []ImportSkip            // Не созданы
+}
+
+// ProvisionUsers создает учетные записи по списку логинов (по одному в строке) с паролями,
+// сгенерированными по текущим правилам. Пароли временные: пользователю сразу назначается
+// смена пароля, а в системе хранится только bcrypt-хеш.
+func (um *UserManager) ProvisionUsers(r io.Reader) (ProvisionResult, error) {
+	var result ProvisionResult
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		username := strings.TrimSpace(scanner.Text())
+		if username == "" || strings.HasPrefix(username, "#") {
+			continue
+		}
+
+		if um.dryRun {
+			// Пароли не генерируются: учетные записи только проверяются и попадают в план
+			if um.store.UserExists(username) || seen[username] {
+				result.Skipped = append(result.Skipped, ImportSkip{line, username, fmt.Sprintf("пользователь с логином '%s' уже существует", username)})
+				continue
+			}
+			seen[username] = true
+			result.Created = append(result.Created, ProvisionedCredential{Username: username})
+			um.planChange(AuditRegister, username, "учетная запись с временным паролем")
+			continue
+		}
+
+		password, err := GeneratePassword(um.rules)
+		if err != nil {
+			return result, fmt.Errorf("ошибка генерации пароля: %v", err)
+		}
+		if err := um.registerUser(username, password, "", ""); err != nil {
+			result.Skipped = append(result.Skipped, ImportSkip{line, username, err.Error()})
+			continue
+		}
+		um.scheduleRotation(username, "временный пароль, выданный администратором", time.Now())
+		result.Created = append(result.Created, ProvisionedCredential{Username: username, Password: password})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("ошибка чтения списка логинов: %v", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// randomSource - источник случайности для генерации паролей и псевдонимов.
+// В рабочем режиме это crypto/rand; детерминированный источник подставляется только для проверок.
+var randomSource io.Reader = rand.Reader
+
+// SetRandomSource подменяет источник случайности (nil возвращает crypto/rand)
+func SetRandomSource(source io.Reader) {
+	if source == nil {
+		source = rand.Reader
+	}
+	randomSource = source
+}
+
+// seededReader выдает детерминированный поток байтов SHA-256(seed || номер блока)
+type seededReader struct {
+	seed    []byte
+	counter uint64
+	buffer  []byte
+}
+
+// NewSeededRandom возвращает детерминированный источник случайности: одинаковое начальное
+// значение дает одинаковые пароли. Непригоден для рабочего режима - пароли предсказуемы.
+func NewSeededRandom(seed string) io.Reader {
+	return &seededReader{seed: []byte(seed)}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		if len(r.buffer) == 0 {
+			block := make([]byte, len(r.seed)+8)
+			copy(block, r.seed)
+			binary.BigEndian.PutUint64(block[len(r.seed):], r.counter)
+			sum := sha256.Sum256(block)
+			r.buffer = sum[:]
+			r.counter++
+		}
+		copied := copy(p[n:], r.buffer)
+		r.buffer = r.buffer[copied:]
+		n += copied
+	}
+	return len(p), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readyPath - адрес проверки готовности принимать запросы. Доступен без токена, как healthPath:
+// балансировщик и Kubernetes снимают с экземпляра нагрузку, пока проверка не пройдена.
+const readyPath = "/readyz"
+
+// readinessClockJump - наибольший перевод системных часов с запуска: время входа, сроки
+// блокировок и токенов считаются по системным часам
+const readinessClockJump = time.Minute
+
+// readinessEarliest - раньше этой даты системные часы не выставлены (сброс к началу эпохи)
+var readinessEarliest = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// APIReadiness - ответ GET /readyz
+type APIReadiness struct {
+	Status string            `json:"status"` // ready или not_ready
+	Checks map[string]string `json:"checks"` // Проверка (storage, clock, admission) -> ok или причина
+}
+
+// readiness проверяет, что хранилище доступно, часы исправны и очереди входа не заполнены.
+// Блокировка s.mu не нужна: проверка не должна ждать проверки паролей.
+func (s *APIServer) readiness(now time.Time) APIReadiness {
+	result := APIReadiness{Status: "ready", Checks: map[string]string{}}
+	check := func(name, problem string) {
+		if problem == "" {
+			result.Checks[name] = "ok"
+			return
+		}
+		result.Checks[name] = problem
+		result.Status = "not_ready"
+	}
+
+	storage := ""
+	switch {
+	case s.cluster != nil:
+		// Ведомый узел отвечает на чтение и называет лидера при изменениях
+		if id, _ := s.cluster.Leader(); id == "" {
+			storage = "кластер Raft без лидера"
+		}
+	case s.replica != nil && !s.replica.Promoted():
+		if !s.replica.Status().Connected {
+			storage = "нет соединения с основным экземпляром"
+		}
+	}
+	check("storage", storage)
+
+	clock := ""
+	jump := now.Round(0).Sub(s.started.Round(0)) - now.Sub(s.started)
+	switch {
+	case now.Before(readinessEarliest):
+		clock = fmt.Sprintf("системные часы не выставлены: %s", now.UTC().Format(time.RFC3339))
+	case jump > readinessClockJump || jump < -readinessClockJump:
+		clock = fmt.Sprintf("системные часы переведены на %v с запуска: перезапустите экземпляр", jump.Round(time.Second))
+	}
+	check("clock", clock)
+
+	admission := ""
+	for _, lane := range s.admission.Status() {
+		if lane.Limit > 0 && lane.Queued >= lane.Limit {
+			admission = fmt.Sprintf("очередь %s заполнена (%d)", lane.Lane, lane.Queued)
+			break
+		}
+	}
+	check("admission", admission)
+	return result
+}
+
+// handleReady: GET /readyz - готовность принимать запросы (503 - проверка не пройдена)
+func (s *APIServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	result := s.readiness(time.Now())
+	status := http.StatusOK
+	if result.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	writeAPIJSON(w, status, result)
+}
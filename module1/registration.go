@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Режим регистрации (раздел registration конфигурации политики) определяет, кто создает
+// учетные записи с паролем через пункт меню "1" и POST /v1/register: open - любой,
+// invite - только по подписанному приглашению (ключ загружается флагом -invite-only),
+// admin - только пользователь с правом user.write, самостоятельная регистрация закрыта.
+// Файл состояния, импорт и POST /v1/users работают в любом режиме: ими пользуются
+// администраторы.
+type RegistrationMode string
+
+const (
+	RegistrationOpen   RegistrationMode = "open"
+	RegistrationInvite RegistrationMode = "invite"
+	RegistrationAdmin  RegistrationMode = "admin"
+)
+
+// errRegistrationClosed - самостоятельная регистрация закрыта (режим admin)
+var errRegistrationClosed = errors.New("самостоятельная регистрация закрыта: учетные записи создает администратор")
+
+// parseRegistrationMode разбирает режим регистрации из конфигурации
+func parseRegistrationMode(value string) (RegistrationMode, error) {
+	switch mode := RegistrationMode(strings.TrimSpace(value)); mode {
+	case RegistrationOpen, RegistrationInvite, RegistrationAdmin:
+		return mode, nil
+	}
+	return "", fmt.Errorf("registration: неизвестный режим %q (допустимо: %s, %s, %s)", value, RegistrationOpen, RegistrationInvite, RegistrationAdmin)
+}
+
+// describeRegistration описывает режим регистрации для отчета
+func describeRegistration(mode RegistrationMode) string {
+	switch mode {
+	case RegistrationInvite:
+		return "только по приглашениям"
+	case RegistrationAdmin:
+		return "только администратором"
+	}
+	return "открыта"
+}
+
+// RegistrationMode возвращает действующий режим регистрации
+func (um *UserManager) RegistrationMode() RegistrationMode {
+	if um.registration == "" {
+		return RegistrationOpen
+	}
+	return um.registration
+}
+
+// CreateUser создает учетную запись с паролем от имени пользователя actor с правом
+// user.write: так регистрируют пользователей в режиме admin. Пароль проверяется политикой,
+// одобрение регистрации не требуется.
+func (um *UserManager) CreateUser(username, password, actor string) error {
+	return um.registerUser(username, password, "", actor)
+}
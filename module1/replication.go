@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Параметры протокола репликации
+const (
+	replicationBuffer    = 1024             // Изменений в очереди реплики; при переполнении реплика отключается
+	replicationHeartbeat = 5 * time.Second  // Интервал проверочных сообщений основного экземпляра
+	replicationTimeout   = 15 * time.Second // Основной экземпляр считается недоступным без сообщений
+	replicationRetry     = 2 * time.Second  // Пауза перед повторным подключением реплики
+)
+
+// replicationMessage - сообщение протокола репликации. Сообщения передаются в формате JSON
+// по одному в строке: реплика отправляет auth, основной экземпляр отвечает снимком
+// хранилища (snapshot) и затем передает изменения (put, delete) и проверочные ping.
+type replicationMessage struct {
+	Type     string  `json:"type"`
+	Seq      uint64  `json:"seq,omitempty"`   // Номер последнего изменения, вошедшего в сообщение
+	Token    string  `json:"token,omitempty"` // auth: токен API
+	Users    []*User `json:"users,omitempty"` // snapshot: все записи
+	User     *User   `json:"user,omitempty"`  // put: новое состояние записи
+	Username string  `json:"username,omitempty"`
+}
+
+// ReplicationPrimary передает изменения хранилища резервным экземплярам (журнал изменений
+// только дописывается). Реплика получает снимок хранилища и затем все изменения по порядку.
+type ReplicationPrimary struct {
+	store *UserStore
+	token string
+
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[chan replicationMessage]bool
+}
+
+// NewReplicationPrimary начинает записывать изменения хранилища для реплик
+func NewReplicationPrimary(store *UserStore, token string) *ReplicationPrimary {
+	p := &ReplicationPrimary{
+		store:       store,
+		token:       token,
+		subscribers: make(map[chan replicationMessage]bool),
+	}
+	store.SetChangeHandler(p.append)
+	return p
+}
+
+// append передает изменение подключенным репликам. Вызывается под блокировкой хранилища,
+// поэтому не ждет: отставшая реплика отключается и при переподключении получает новый снимок.
+func (p *ReplicationPrimary) append(change StoreChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	message := replicationMessage{Type: "put", Seq: p.seq, User: change.User}
+	if change.User == nil {
+		message = replicationMessage{Type: "delete", Seq: p.seq, Username: change.Username}
+	}
+	for subscriber := range p.subscribers {
+		select {
+		case subscriber <- message:
+		default:
+			delete(p.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Serve принимает подключения реплик, пока listener не будет закрыт
+func (p *ReplicationPrimary) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go p.serveReplica(conn)
+	}
+}
+
+// serveReplica проверяет токен реплики и передает ей снимок и изменения
+func (p *ReplicationPrimary) serveReplica(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+	var auth replicationMessage
+	if err := json.NewDecoder(conn).Decode(&auth); err != nil || auth.Type != "auth" ||
+		subtle.ConstantTimeCompare([]byte(auth.Token), []byte(p.token)) != 1 {
+		fmt.Fprintf(os.Stderr, "репликация: подключение %s отклонено\n", conn.RemoteAddr())
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	subscriber := make(chan replicationMessage, replicationBuffer)
+	var snapshot replicationMessage
+	p.store.Snapshot(func(users []*User) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		snapshot = replicationMessage{Type: "snapshot", Seq: p.seq, Users: users}
+		p.subscribers[subscriber] = true
+	})
+	defer p.unsubscribe(subscriber)
+	fmt.Fprintf(os.Stderr, "репликация: подключена реплика %s\n", conn.RemoteAddr())
+
+	encoder := json.NewEncoder(conn)
+	send := func(message replicationMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+		return encoder.Encode(message)
+	}
+	if send(snapshot) != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case message, ok := <-subscriber:
+			if !ok {
+				fmt.Fprintf(os.Stderr, "репликация: реплика %s отстала и отключена\n", conn.RemoteAddr())
+				return
+			}
+			err = send(message)
+		case <-heartbeat.C:
+			err = send(replicationMessage{Type: "ping"})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "репликация: реплика %s отключилась: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// Status возвращает состояние основного экземпляра
+func (p *ReplicationPrimary) Status() ReplicationStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ReplicationStatus{Role: "primary", Connected: len(p.subscribers) > 0, Seq: p.seq, Replicas: len(p.subscribers)}
+}
+
+// unsubscribe прекращает передачу изменений реплике
+func (p *ReplicationPrimary) unsubscribe(subscriber chan replicationMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.subscribers[subscriber] {
+		delete(p.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// ReplicationStatus - состояние репликации для API
+type ReplicationStatus struct {
+	Role       string     `json:"role"`                  // primary или replica
+	Primary    string     `json:"primary,omitempty"`     // Адрес основного экземпляра (реплика)
+	Connected  bool       `json:"connected"`             // Реплика получает изменения
+	Seq        uint64     `json:"seq"`                   // Номер последнего полученного изменения
+	LastUpdate *time.Time `json:"last_update,omitempty"` // Когда получено последнее сообщение
+	Replicas   int        `json:"replicas,omitempty"`    // Подключено реплик (основной экземпляр)
+	ReadOnly   bool       `json:"read_only,omitempty"`   // Реплика только для чтения: назначить основной нельзя
+}
+
+// ReplicationReplica - резервный экземпляр: повторяет хранилище основного и принимает
+// изменения только от него, пока не будет назначен основным (Promote)
+type ReplicationReplica struct {
+	store     *UserStore
+	addr      string
+	token     string
+	tlsConfig *tls.Config // nil - соединение без TLS (только loopback)
+	onApply   func()      // Вызывается после применения изменений
+	readOnly  bool        // Реплика для отчетов: изменения через API и promote запрещены
+
+	mu         sync.Mutex
+	promoted   bool
+	connected  bool
+	seq        uint64
+	lastUpdate time.Time
+	conn       net.Conn
+	onPromote  func()
+}
+
+// NewReplicationReplica создает реплику основного экземпляра addr. Без caPath соединение
+// с loopback-адресом устанавливается без TLS, с остальными - с проверкой по системным
+// корневым сертификатам.
+func NewReplicationReplica(store *UserStore, addr, token, caPath string) (*ReplicationReplica, error) {
+	r := &ReplicationReplica{store: store, addr: addr, token: token}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный адрес основного экземпляра %q: %v", addr, err)
+	}
+	if caPath != "" {
+		pem, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения сертификатов: %v", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в %s нет сертификатов PEM", caPath)
+		}
+		r.tlsConfig = &tls.Config{RootCAs: roots, ServerName: host, MinVersion: tls.VersionTLS12}
+	} else if !isLoopbackAddr(addr) {
+		r.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	return r, nil
+}
+
+// Run получает изменения от основного экземпляра и переподключается при обрыве,
+// пока реплика не будет назначена основной
+func (r *ReplicationReplica) Run() {
+	for !r.Promoted() {
+		err := r.follow()
+		r.mu.Lock()
+		r.connected = false
+		r.conn = nil
+		r.mu.Unlock()
+		if r.Promoted() {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "репликация: нет связи с %s: %v\n", r.addr, err)
+		time.Sleep(replicationRetry)
+	}
+}
+
+// follow подключается к основному экземпляру и применяет изменения до обрыва соединения
+func (r *ReplicationReplica) follow() error {
+	dialer := &net.Dialer{Timeout: replicationTimeout}
+	var conn net.Conn
+	var err error
+	if r.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.addr, r.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", r.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mu.Lock()
+	if r.promoted {
+		r.mu.Unlock()
+		return nil
+	}
+	r.conn = conn
+	r.mu.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(replicationTimeout))
+	if err := json.NewEncoder(conn).Encode(replicationMessage{Type: "auth", Token: r.token}); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(replicationTimeout))
+		var message replicationMessage
+		if err := decoder.Decode(&message); err != nil {
+			return err
+		}
+		if err := r.apply(message); err != nil {
+			return err
+		}
+	}
+}
+
+// apply применяет сообщение основного экземпляра к хранилищу
+func (r *ReplicationReplica) apply(message replicationMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.promoted {
+		return fmt.Errorf("реплика назначена основной")
+	}
+
+	switch message.Type {
+	case "snapshot":
+		r.store.Replace(message.Users)
+		r.connected = true
+		fmt.Fprintf(os.Stderr, "репликация: получен снимок с %s, записей: %d\n", r.addr, len(message.Users))
+	case "put":
+		if message.User == nil {
+			return fmt.Errorf("изменение %d без записи", message.Seq)
+		}
+		r.store.SaveUser(message.User)
+	case "delete":
+		r.store.DeleteUser(message.Username)
+	case "ping":
+	default:
+		return fmt.Errorf("неизвестное сообщение %q", message.Type)
+	}
+
+	if message.Seq != 0 {
+		r.seq = message.Seq
+	}
+	r.lastUpdate = time.Now()
+	if message.Type != "ping" && r.onApply != nil {
+		r.onApply()
+	}
+	return nil
+}
+
+// Promote прекращает репликацию и делает экземпляр основным: хранилище становится
+// доступным для изменений. Основной экземпляр к этому моменту должен быть остановлен,
+// иначе его последующие изменения будут потеряны.
+func (r *ReplicationReplica) Promote() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.readOnly {
+		return fmt.Errorf("реплика только для чтения не назначается основной")
+	}
+	if r.promoted {
+		return fmt.Errorf("экземпляр уже основной")
+	}
+	r.promoted = true
+	r.connected = false
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	fmt.Fprintf(os.Stderr, "репликация: экземпляр назначен основным, последнее изменение %d\n", r.seq)
+	if r.onPromote != nil {
+		r.onPromote()
+	}
+	return nil
+}
+
+// Promoted сообщает, назначена ли реплика основной
+func (r *ReplicationReplica) Promoted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.promoted
+}
+
+// Status возвращает состояние репликации
+func (r *ReplicationReplica) Status() ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := ReplicationStatus{Role: "replica", Primary: r.addr, Connected: r.connected, Seq: r.seq, LastUpdate: optionalTime(r.lastUpdate), ReadOnly: r.readOnly}
+	if r.promoted {
+		status.Role = "primary"
+	}
+	return status
+}
+
+// ReplicationConfig - параметры репликации режима serve
+type ReplicationConfig struct {
+	From     string // Адрес репликации основного экземпляра (пусто - экземпляр основной)
+	Listen   string // Адрес приема реплик (пусто - реплики не принимаются)
+	CAPath   string // Сертификаты для проверки основного экземпляра
+	ReadOnly bool   // Реплика только для отчетов и мониторинга, без promote
+}
+
+// startReplication настраивает репликацию для API server. Основной экземпляр сразу начинает
+// принимать реплики; реплика получает изменения от cfg.From, а реплики принимает только
+// после назначения основной, когда ее хранилище перестает повторять прежний основной экземпляр.
+func startReplication(um *UserManager, server *APIServer, cfg ReplicationConfig, certPath, keyPath string) error {
+	if cfg.ReadOnly && (cfg.From == "" || cfg.Listen != "") {
+		return fmt.Errorf("реплика только для чтения задается -replicate-from и не принимает реплики")
+	}
+	var listener net.Listener
+	var err error
+	switch {
+	case cfg.Listen == "":
+	case certPath != "":
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			return fmt.Errorf("ошибка загрузки сертификата TLS: %v", err)
+		}
+		listener, err = tls.Listen("tcp", cfg.Listen, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	case !isLoopbackAddr(cfg.Listen):
+		return fmt.Errorf("без TLS реплики принимаются только по loopback-адресу, а не %s", cfg.Listen)
+	default:
+		listener, err = net.Listen("tcp", cfg.Listen)
+	}
+	if err != nil {
+		return err
+	}
+
+	servePrimary := func() {
+		server.primary = NewReplicationPrimary(um.store, server.token)
+		go server.primary.Serve(listener)
+		fmt.Printf("Репликация: прием реплик на %s\n", cfg.Listen)
+	}
+	if cfg.From == "" {
+		if listener != nil {
+			servePrimary()
+		}
+		return nil
+	}
+
+	replica, err := NewReplicationReplica(um.store, cfg.From, server.token, cfg.CAPath)
+	if err != nil {
+		return err
+	}
+	replica.onApply = um.usersChanged
+	replica.readOnly = cfg.ReadOnly
+	if listener != nil {
+		replica.onPromote = servePrimary
+	}
+	server.replica = replica
+	go replica.Run()
+	if cfg.ReadOnly {
+		fmt.Printf("Репликация: реплика %s только для чтения (отчеты и мониторинг)\n", cfg.From)
+	} else {
+		fmt.Printf("Репликация: реплика %s, изменения через API - после promote\n", cfg.From)
+	}
+	return nil
+}
+
+// PromoteReplica назначает основной реплику, запущенную с HTTP API по адресу addr
+func PromoteReplica(addr, certPath, token string) (ReplicationStatus, error) {
+	var status ReplicationStatus
+	api, err := newLocalAPI(addr, certPath, replicationTimeout)
+	if err != nil {
+		return status, err
+	}
+	request, err := http.NewRequest(http.MethodPost, api.baseURL+apiPrefix+"/replication/promote", nil)
+	if err != nil {
+		return status, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		return status, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(response.Body).Decode(&apiError)
+		return status, fmt.Errorf("API ответил %s: %s", response.Status, apiError.Error)
+	}
+	err = json.NewDecoder(response.Body).Decode(&status)
+	return status, err
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActivityReport содержит сводку активности учетных записей за период
+type ActivityReport struct {
+	Since            time.Time             `json:"since"`
+	GeneratedAt      time.Time             `json:"generated_at"`
+	DormantAfterDays int                   `json:"dormant_after_days"`
+	TotalUsers       int                   `json:"total_users"`
+	NewRegistrations []string              `json:"new_registrations"`
+	LockedAccounts   []string              `json:"locked_accounts"`
+	DormantAccounts  []string              `json:"dormant_accounts"`
+	TopFailed        []FailedAttemptsEntry `json:"top_failed_attempts"`
+}
+
+// FailedAttemptsEntry - строка рейтинга учетных записей по неудачным попыткам входа
+type FailedAttemptsEntry struct {
+	Username       string `json:"username"`
+	FailedAttempts int    `json:"failed_attempts"`
+}
+
+// ActivityReport формирует отчет об активности начиная с момента since.
+// Неактивными считаются учетные записи без входа дольше dormantAfter.
+func (um *UserManager) ActivityReport(since time.Time, dormantAfter time.Duration, topN int) ActivityReport {
+	now := time.Now()
+	users := um.store.GetAllUsers()
+
+	report := ActivityReport{
+		Since:            since,
+		GeneratedAt:      now,
+		DormantAfterDays: int(dormantAfter.Hours() / 24),
+		TotalUsers:       len(users),
+		NewRegistrations: []string{},
+		LockedAccounts:   []string{},
+		DormantAccounts:  []string{},
+		TopFailed:        []FailedAttemptsEntry{},
+	}
+
+	for username, user := range users {
+		if !user.CreatedAt.Before(since) {
+			report.NewRegistrations = append(report.NewRegistrations, username)
+		}
+
+		if user.IsBlocked {
+			report.LockedAccounts = append(report.LockedAccounts, username)
+		}
+
+		// Если пользователь ни разу не входил, отсчитываем от даты регистрации
+		lastActivity := user.LastLoginAt
+		if lastActivity.IsZero() {
+			lastActivity = user.CreatedAt
+		}
+		if now.Sub(lastActivity) >= dormantAfter {
+			report.DormantAccounts = append(report.DormantAccounts, username)
+		}
+
+		if user.FailedAttempts > 0 {
+			report.TopFailed = append(report.TopFailed, FailedAttemptsEntry{
+				Username:       username,
+				FailedAttempts: user.FailedAttempts,
+			})
+		}
+	}
+
+	sort.Strings(report.NewRegistrations)
+	sort.Strings(report.LockedAccounts)
+	sort.Strings(report.DormantAccounts)
+	sort.Slice(report.TopFailed, func(i, j int) bool {
+		if report.TopFailed[i].FailedAttempts != report.TopFailed[j].FailedAttempts {
+			return report.TopFailed[i].FailedAttempts > report.TopFailed[j].FailedAttempts
+		}
+		return report.TopFailed[i].Username < report.TopFailed[j].Username
+	})
+	if len(report.TopFailed) > topN {
+		report.TopFailed = report.TopFailed[:topN]
+	}
+
+	return report
+}
+
+// withoutHoneypots убирает из отчета учетные записи-ловушки: в API они не видны
+func (um *UserManager) withoutHoneypots(report ActivityReport) ActivityReport {
+	honeypots := make(map[string]bool)
+	for username, user := range um.store.GetAllUsers() {
+		if user.IsHoneypot {
+			honeypots[username] = true
+		}
+	}
+	if len(honeypots) == 0 {
+		return report
+	}
+
+	filter := func(usernames []string) []string {
+		kept := []string{}
+		for _, username := range usernames {
+			if !honeypots[username] {
+				kept = append(kept, username)
+			}
+		}
+		return kept
+	}
+	report.TotalUsers -= len(honeypots)
+	report.NewRegistrations = filter(report.NewRegistrations)
+	report.LockedAccounts = filter(report.LockedAccounts)
+	report.DormantAccounts = filter(report.DormantAccounts)
+	topFailed := []FailedAttemptsEntry{}
+	for _, entry := range report.TopFailed {
+		if !honeypots[entry.Username] {
+			topFailed = append(topFailed, entry)
+		}
+	}
+	report.TopFailed = topFailed
+	return report
+}
+
+// UserMetrics - число учетных записей по состояниям для мониторинга (без ловушек)
+type UserMetrics struct {
+	Total           int
+	Admins          int
+	Blocked         int
+	Disabled        int
+	PendingApproval int
+	Dormant         int
+}
+
+// UserMetrics подсчитывает учетные записи по состояниям
+func (um *UserManager) UserMetrics() UserMetrics {
+	var metrics UserMetrics
+	for _, user := range um.store.GetAllUsers() {
+		if user.IsHoneypot {
+			continue
+		}
+		metrics.Total++
+		if user.IsAdmin {
+			metrics.Admins++
+		}
+		if user.IsBlocked {
+			metrics.Blocked++
+		}
+		if user.DisabledByAdmin {
+			metrics.Disabled++
+		}
+		if user.PendingApproval {
+			metrics.PendingApproval++
+		}
+		if !user.DormantSince.IsZero() {
+			metrics.Dormant++
+		}
+	}
+	return metrics
+}
+
+// FormatTable возвращает отчет в виде текстовой таблицы
+func (r ActivityReport) FormatTable() string {
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("Отчет с %s по %s\n", r.Since.Format("2006-01-02 15:04:05"), r.GeneratedAt.Format("2006-01-02 15:04:05")))
+	out.WriteString(fmt.Sprintf("Всего пользователей: %d\n\n", r.TotalUsers))
+
+	out.WriteString(fmt.Sprintf("%-40s %d\n", "Новых регистраций:", len(r.NewRegistrations)))
+	out.WriteString(fmt.Sprintf("%-40s %d\n", "Заблокированных учетных записей:", len(r.LockedAccounts)))
+	out.WriteString(fmt.Sprintf("%-40s %d\n", fmt.Sprintf("Неактивных (более %d дн.):", r.DormantAfterDays), len(r.DormantAccounts)))
+
+	writeList := func(title string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		out.WriteString(fmt.Sprintf("\n%s\n", title))
+		for _, name := range names {
+			out.WriteString(fmt.Sprintf("  "+theme.Bullet+" %s\n", name))
+		}
+	}
+	writeList("Новые регистрации:", r.NewRegistrations)
+	writeList("Заблокированные:", r.LockedAccounts)
+	writeList("Неактивные:", r.DormantAccounts)
+
+	if len(r.TopFailed) > 0 {
+		out.WriteString("\nБольше всего неудачных попыток входа:\n")
+		for i, entry := range r.TopFailed {
+			out.WriteString(fmt.Sprintf("  %d. %-30s %d\n", i+1, entry.Username, entry.FailedAttempts))
+		}
+	}
+
+	return out.String()
+}
+
+// FormatJSON возвращает отчет в формате JSON
+func (r ActivityReport) FormatJSON() (string, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("ошибка формирования JSON: %v", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// FormatCSV возвращает отчет в формате CSV (раздел, пользователь, значение)
+func (r ActivityReport) FormatCSV() (string, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	records := [][]string{{"section", "username", "value"}}
+	for _, name := range r.NewRegistrations {
+		records = append(records, []string{"new_registration", name, ""})
+	}
+	for _, name := range r.LockedAccounts {
+		records = append(records, []string{"locked", name, ""})
+	}
+	for _, name := range r.DormantAccounts {
+		records = append(records, []string{"dormant", name, ""})
+	}
+	for _, entry := range r.TopFailed {
+		records = append(records, []string{"failed_attempts", entry.Username, strconv.Itoa(entry.FailedAttempts)})
+	}
+
+	if err := w.WriteAll(records); err != nil {
+		return "", fmt.Errorf("ошибка формирования CSV: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// ParsePeriod разбирает длительность вида "30d", "2w" или "12h"
+func ParsePeriod(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("период не указан")
+	}
+
+	unit := value[len(value)-1]
+	if unit == 'd' || unit == 'w' {
+		count, err := strconv.Atoi(value[:len(value)-1])
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("некорректный период: %s", value)
+		}
+		days := count
+		if unit == 'w' {
+			days *= 7
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	period, err := time.ParseDuration(value)
+	if err != nil || period < 0 {
+		return 0, fmt.Errorf("некорректный период: %s", value)
+	}
+	return period, nil
+}
+
+// FetchActivityReport запрашивает у API отчет об активности (команда report) за период since
+// в формате format и копирует его в out без изменений
+func FetchActivityReport(addr, certPath, token, since, dormantAfter, format string, out io.Writer) error {
+	api, err := newLocalAPI(addr, certPath, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"period": {since}, "dormant": {dormantAfter}, "format": {format}}
+	request, err := http.NewRequest(http.MethodGet, api.baseURL+apiPrefix+"/report?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(response.Body).Decode(&apiError)
+		return fmt.Errorf("API ответил %s: %s", response.Status, apiError.Error)
+	}
+	_, err = io.Copy(out, response.Body)
+	return err
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Источник запроса API - адрес клиента и идентификатор запроса - записывается в журнал аудита
+// вместе с событиями, которые запрос вызвал. За обратным прокси (-api-trusted-proxies) адрес клиента берется
+// из X-Forwarded-For: заголовок просматривается справа налево, адреса доверенных прокси
+// пропускаются, первый недоверенный адрес считается адресом клиента. Левее него значения
+// мог подставить сам клиент, поэтому им не доверяем.
+//
+// Идентификатор запроса из заголовка X-Request-ID (или выданный сервером, если заголовка нет
+// или он некорректен) возвращается в ответе и записывается в журнал аудита: по нему запрос в
+// журнале прокси или клиента находится в журнале аудита.
+
+// requestIDHeader - заголовок идентификатора запроса
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength - наибольшая длина идентификатора запроса от клиента
+const maxRequestIDLength = 128
+
+// AuditSource - сведения о запросе API для записей журнала аудита
+type AuditSource struct {
+	ClientIP   string
+	RequestID  string
+	Device     string // Хеш устройства (devices.go)
+	DeviceName string
+}
+
+// auditSourceKey - ключ источника запроса в контексте запроса
+type auditSourceKey struct{}
+
+// withAuditSource возвращает контекст с источником запроса
+func withAuditSource(ctx context.Context, source AuditSource) context.Context {
+	return context.WithValue(ctx, auditSourceKey{}, source)
+}
+
+// auditSourceFrom возвращает источник запроса из контекста (пустой вне запроса API)
+func auditSourceFrom(ctx context.Context) AuditSource {
+	source, _ := ctx.Value(auditSourceKey{}).(AuditSource)
+	return source
+}
+
+// ParseTrustedProxies разбирает список доверенных прокси через запятую: подсети CIDR
+// или отдельные адреса
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("некорректный адрес %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("некорректная подсеть %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trustedProxy сообщает, входит ли адрес в доверенные прокси
+func (s *APIServer) trustedProxy(ip net.IP) bool {
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP возвращает адрес клиента: адрес соединения, а если соединение пришло от
+// доверенного прокси - первый справа недоверенный адрес из X-Forwarded-For
+func (s *APIServer) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustedProxy(ip) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// Испорченный заголовок: дальше цепочке прокси верить нельзя
+			break
+		}
+		ip = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// validRequestID проверяет идентификатор запроса от клиента: он попадает в журнал аудита,
+// поэтому допустимы только буквы латиницы, цифры и символы . _ - :
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID возвращает идентификатор запроса из X-Request-ID или новый случайный
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// lockRequest захватывает блокировку API и связывает с запросом r записи аудита, которые
+// сделаны под ней. Возвращает функцию снятия блокировки.
+func (s *APIServer) lockRequest(r *http.Request) func() {
+	s.mu.Lock()
+	s.um.request = auditSourceFrom(r.Context())
+	return func() {
+		s.um.request = AuditSource{}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetAdmin назначает пользователю права администратора или снимает их.
+// Снять права с последнего администратора нельзя: административные пункты меню
+// стали бы недоступны.
+func (um *UserManager) SetAdmin(username string, admin bool, reason string) error {
+	username = strings.TrimSpace(username)
+
+	if !admin && um.adminCount() == 1 && um.IsAdmin(username) {
+		return fmt.Errorf("нельзя снять права с последнего администратора")
+	}
+
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if user.IsAdmin == admin {
+			if admin {
+				return fmt.Errorf("пользователь уже администратор")
+			}
+			return fmt.Errorf("пользователь не администратор")
+		}
+		user.IsAdmin = admin
+		user.Role = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if admin {
+		um.recordAudit(AuditAdminGranted, username, reason)
+	} else {
+		um.recordAudit(AuditAdminRevoked, username, reason)
+	}
+	return nil
+}
+
+// CreateFirstAdmin создает учетную запись первого администратора. Регистрацию по приглашениям
+// и одобрение заявок пока некому обслуживать, поэтому учетная запись создается и одобряется напрямую.
+func (um *UserManager) CreateFirstAdmin(username, password string) error {
+	if um.HasAdmins() {
+		return fmt.Errorf("администратор уже назначен")
+	}
+	if err := um.registerUser(username, password, "", ""); err != nil {
+		return err
+	}
+	if um.RegistrationApprovalRequired() {
+		if err := um.ApproveRegistration(username, "первый администратор"); err != nil {
+			return err
+		}
+	}
+	return um.SetAdmin(username, true, "первый администратор")
+}
+
+// IsAdmin сообщает, есть ли у пользователя права администратора
+func (um *UserManager) IsAdmin(username string) bool {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	return exists && user.IsAdmin
+}
+
+// HasAdmins сообщает, назначен ли в системе хотя бы один администратор
+func (um *UserManager) HasAdmins() bool {
+	return um.adminCount() > 0
+}
+
+// adminCount возвращает число администраторов
+func (um *UserManager) adminCount() int {
+	count := 0
+	for _, user := range um.store.GetAllUsers() {
+		if user.IsAdmin {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// RecheckCampaign задает правила кампании по проверке паролей существующих пользователей
+type RecheckCampaign struct {
+	MaxPasswordAge time.Duration // Пароль старше этого срока требует смены (0 - не проверять)
+	GracePeriod    time.Duration // Срок на смену пароля после уведомления
+	MinScore       int           // Минимальная оценка стойкости, проверяется при входе
+}
+
+// DefaultRecheckCampaign возвращает правила по умолчанию: пароль не старше года, 14 дней на смену
+func DefaultRecheckCampaign() RecheckCampaign {
+	return RecheckCampaign{
+		MaxPasswordAge: 365 * 24 * time.Hour,
+		GracePeriod:    14 * 24 * time.Hour,
+		MinScore:       2,
+	}
+}
+
+// RotationNotice - уведомление пользователя о принудительной смене пароля
+type RotationNotice struct {
+	Username string
+	Reason   string
+	Due      time.Time
+}
+
+// RecheckResult - итог кампании проверки паролей
+type RecheckResult struct {
+	Scheduled []RotationNotice // Назначена смена пароля
+	Pending   int              // Смена уже назначена ранее
+	Checked   int              // Проверено учетных записей
+}
+
+// SetRecheckCampaign задает правила проверки паролей
+func (um *UserManager) SetRecheckCampaign(campaign RecheckCampaign) {
+	um.recheck = campaign
+}
+
+// RunPasswordRecheck проверяет все учетные записи и назначает смену пароля тем,
+// чей пароль задан до изменения политики, хранится в унаследованном виде
+// или не менялся дольше допустимого срока. Сам пароль здесь недоступен -
+// его стойкость проверяется при следующем входе.
+func (um *UserManager) RunPasswordRecheck(now time.Time) RecheckResult {
+	var result RecheckResult
+
+	for _, user := range um.store.GetAllUsers() {
+		if user.IsHoneypot {
+			continue
+		}
+		result.Checked++
+		if !user.RotationDue.IsZero() {
+			result.Pending++
+			continue
+		}
+
+		if reason := um.rotationReason(user, now); reason != "" {
+			if notice, scheduled := um.scheduleRotation(user.Username, reason, now); scheduled {
+				result.Scheduled = append(result.Scheduled, notice)
+			}
+		}
+	}
+
+	sort.Slice(result.Scheduled, func(i, j int) bool {
+		return result.Scheduled[i].Username < result.Scheduled[j].Username
+	})
+	return result
+}
+
+// rotationReason возвращает причину принудительной смены пароля (пусто - смена не нужна)
+func (um *UserManager) rotationReason(user *User, now time.Time) string {
+	// Импортированные записи без смены пароля в системе считаются не менявшими его
+	changedAt := user.PasswordChangedAt
+	switch {
+	case user.LegacyHash != "":
+		return "пароль хранится в унаследованном хеше"
+	case !um.rulesChangedAt.IsZero() && changedAt.Before(um.rulesChangedAt):
+		return "пароль задан до изменения политики паролей"
+	case changedAt.IsZero():
+		return "пароль ни разу не менялся в системе"
+	case um.recheck.MaxPasswordAge > 0 && now.Sub(changedAt) >= um.recheck.MaxPasswordAge:
+		return fmt.Sprintf("пароль не менялся более %d дн.", int(um.recheck.MaxPasswordAge.Hours()/24))
+	default:
+		return ""
+	}
+}
+
+// scheduleRotation назначает смену пароля и уведомляет пользователя через журнал аудита.
+// Если смена уже назначена, существующий срок не переносится.
+func (um *UserManager) scheduleRotation(username, reason string, now time.Time) (RotationNotice, bool) {
+	notice := RotationNotice{Username: username, Reason: reason, Due: now.Add(um.recheck.GracePeriod)}
+	scheduled := false
+
+	um.store.Update(username, func(user *User) error {
+		if !user.RotationDue.IsZero() {
+			return nil
+		}
+		user.RotationDue = notice.Due
+		user.RotationReason = reason
+		scheduled = true
+		return nil
+	})
+	if scheduled {
+		um.recordAudit(AuditRotationScheduled, username, fmt.Sprintf("%s, срок до %s", reason, notice.Due.Format("2006-01-02")))
+	}
+
+	return notice, scheduled
+}
+
+// recheckOnLogin проверяет пароль, известный в момент успешного входа, по текущей политике
+func (um *UserManager) recheckOnLogin(user *User, password string, now time.Time) {
+	if !user.RotationDue.IsZero() {
+		return
+	}
+
+	if valid, _ := ValidatePassword(password, um.rules); !valid {
+		um.scheduleRotation(user.Username, "пароль не соответствует текущей политике", now)
+		return
+	}
+	if strength := AnalyzeStrength(password); strength.Score < um.recheck.MinScore {
+		um.scheduleRotation(user.Username, fmt.Sprintf("слабый пароль (%s)", strengthLabels[strength.Score]), now)
+	}
+}
+
+// SetPasswordExpiryWarning задает, за сколько до истечения срока пароля предупреждать при входе
+// (0 - предупреждать только о назначенной принудительной смене)
+func (um *UserManager) SetPasswordExpiryWarning(warning time.Duration) {
+	um.expiryWarning = warning
+}
+
+// expiryWarningFor возвращает срок пароля и причину смены, если о них нужно предупредить при входе.
+// О назначенной смене пароля предупреждение выводится всегда, об истечении срока действия
+// пароля (MaxPasswordAge кампании проверки) - только когда до него осталось меньше expiryWarning.
+func (um *UserManager) expiryWarningFor(user *User, now time.Time) (time.Time, string) {
+	if !user.RotationDue.IsZero() {
+		return user.RotationDue, user.RotationReason
+	}
+	if um.recheck.MaxPasswordAge == 0 || user.PasswordChangedAt.IsZero() {
+		return time.Time{}, ""
+	}
+
+	expiresAt := user.PasswordChangedAt.Add(um.recheck.MaxPasswordAge)
+	if expiresAt.Sub(now) > um.expiryWarning {
+		return time.Time{}, ""
+	}
+	return expiresAt, fmt.Sprintf("срок действия пароля - %d дн.", int(um.recheck.MaxPasswordAge.Hours()/24))
+}
+
+// PendingRotation возвращает уведомление о назначенной смене пароля пользователя
+func (um *UserManager) PendingRotation(username string) (RotationNotice, bool) {
+	user, exists := um.store.GetUser(username)
+	if !exists || user.RotationDue.IsZero() {
+		return RotationNotice{}, false
+	}
+	return RotationNotice{Username: user.Username, Reason: user.RotationReason, Due: user.RotationDue}, true
+}
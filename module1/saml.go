@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Вход через корпоративный поставщик удостоверений (IdP) по SAML 2.0: система выступает
+// поставщиком услуг (SP). Подписанное утверждение IdP заменяет проверку пароля, а отключение,
+// блокировка, расписание, условия использования, обработчики и аудит действуют как при входе
+// по паролю. Поддерживаются привязки HTTP-Redirect (запрос входа) и HTTP-POST (ответ IdP).
+const (
+	samlProtocolNS      = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlAssertionNS     = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlMetadataNS      = "urn:oasis:names:tc:SAML:2.0:metadata"
+	samlStatusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer          = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlBindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlNameIDFormat    = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	samlClockSkew       = 2 * time.Minute  // Допустимое расхождение часов с IdP
+	samlRequestTTL      = 10 * time.Minute // Сколько ждать ответа IdP на запрос входа
+	samlMaxResponseSize = 1 << 20
+)
+
+// Адреса SP. Доступны без токена API: их открывает браузер пользователя
+const (
+	samlPrefix       = apiPrefix + "/saml"
+	samlMetadataPath = samlPrefix + "/metadata"
+	samlLoginPath    = samlPrefix + "/login"
+	samlACSPath      = samlPrefix + "/acs"
+)
+
+// Способы сопоставления удостоверений SAML с локальными учетными записями
+const (
+	SAMLProvisionLinked = "linked" // Вход только в учетные записи, заранее связанные командой saml-link
+	SAMLProvisionAuto   = "auto"   // Учетная запись без пароля создается при первом входе
+)
+
+// SAMLConfig - настройки поставщика услуг SAML (файл -saml-config)
+type SAMLConfig struct {
+	EntityID          string `json:"sp_entity_id"`                  // Идентификатор этой системы у IdP
+	ACSURL            string `json:"acs_url"`                       // Внешний адрес .../v1/saml/acs
+	IdPEntityID       string `json:"idp_entity_id"`                 // Издатель утверждений
+	IdPSSOURL         string `json:"idp_sso_url"`                   // Адрес входа IdP (HTTP-Redirect)
+	IdPCertificate    string `json:"idp_certificate"`               // Сертификат подписи IdP в PEM (путь относительно файла настроек)
+	UsernameAttribute string `json:"username_attribute,omitempty"`  // Атрибут с логином (пусто - NameID)
+	Provisioning      string `json:"provisioning,omitempty"`        // linked (по умолчанию) или auto
+	AllowIdPInitiated bool   `json:"allow_idp_initiated,omitempty"` // Принимать ответы без запроса входа от SP
+}
+
+// SAMLServiceProvider проверяет ответы IdP и помнит выданные запросы входа и принятые
+// утверждения: ответ на чужой или устаревший запрос и повтор утверждения отклоняются
+type SAMLServiceProvider struct {
+	config   SAMLConfig
+	cert     *x509.Certificate
+	mu       sync.Mutex
+	requests map[string]time.Time // Ожидающие ответа запросы входа: ID -> срок ожидания
+	seen     map[string]time.Time // Принятые утверждения: ID -> срок действия
+}
+
+// SAMLIdentity - удостоверение из проверенного утверждения IdP
+type SAMLIdentity struct {
+	NameID     string
+	Attributes map[string][]string
+}
+
+// Username возвращает логин: значение атрибута attribute или NameID, если атрибут не задан
+func (id SAMLIdentity) Username(attribute string) string {
+	if attribute == "" {
+		return id.NameID
+	}
+	if values := id.Attributes[attribute]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+// LoadSAMLConfig читает настройки SP и сертификат подписи IdP
+func LoadSAMLConfig(path string) (*SAMLServiceProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения настроек SAML: %v", err)
+	}
+	var config SAMLConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: некорректный формат настроек SAML: %v", path, err)
+	}
+	if config.Provisioning == "" {
+		config.Provisioning = SAMLProvisionLinked
+	}
+	switch {
+	case config.EntityID == "" || config.IdPEntityID == "":
+		return nil, fmt.Errorf("%s: не заданы sp_entity_id и idp_entity_id", path)
+	case !strings.HasPrefix(config.ACSURL, "https://") && !strings.HasPrefix(config.ACSURL, "http://"):
+		return nil, fmt.Errorf("%s: acs_url должен быть адресом http(s)", path)
+	case !strings.HasPrefix(config.IdPSSOURL, "https://") && !strings.HasPrefix(config.IdPSSOURL, "http://"):
+		return nil, fmt.Errorf("%s: idp_sso_url должен быть адресом http(s)", path)
+	case config.Provisioning != SAMLProvisionLinked && config.Provisioning != SAMLProvisionAuto:
+		return nil, fmt.Errorf("%s: provisioning должен быть %s или %s", path, SAMLProvisionLinked, SAMLProvisionAuto)
+	case config.IdPCertificate == "":
+		return nil, fmt.Errorf("%s: не задан сертификат подписи IdP (idp_certificate)", path)
+	}
+
+	certPath := config.IdPCertificate
+	if !filepath.IsAbs(certPath) {
+		certPath = filepath.Join(filepath.Dir(path), certPath)
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения сертификата IdP: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: нет сертификата в формате PEM", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: некорректный сертификат: %v", certPath, err)
+	}
+	return &SAMLServiceProvider{
+		config:   config,
+		cert:     cert,
+		requests: make(map[string]time.Time),
+		seen:     make(map[string]time.Time),
+	}, nil
+}
+
+// Metadata возвращает метаданные SP для регистрации в IdP
+func (sp *SAMLServiceProvider) Metadata() []byte {
+	var out bytes.Buffer
+	out.WriteString(xml.Header)
+	fmt.Fprintf(&out, `<md:EntityDescriptor xmlns:md="%s" entityID="%s">`+"\n", samlMetadataNS, xmlEscape(sp.config.EntityID))
+	fmt.Fprintf(&out, `  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">`+"\n", samlProtocolNS)
+	fmt.Fprintf(&out, "    <md:NameIDFormat>%s</md:NameIDFormat>\n", samlNameIDFormat)
+	fmt.Fprintf(&out, `    <md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>`+"\n", samlBindingPOST, xmlEscape(sp.config.ACSURL))
+	out.WriteString("  </md:SPSSODescriptor>\n</md:EntityDescriptor>\n")
+	return out.Bytes()
+}
+
+// AuthnRequestURL создает запрос входа и возвращает адрес IdP для перенаправления браузера
+// (привязка HTTP-Redirect); relayState IdP вернет вместе с ответом
+func (sp *SAMLServiceProvider) AuthnRequestURL(relayState string, now time.Time) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("ошибка генерации идентификатора запроса: %v", err)
+	}
+	id := "_" + hex.EncodeToString(random)
+	request := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`+
+		`<saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`,
+		samlProtocolNS, samlAssertionNS, id, now.UTC().Format("2006-01-02T15:04:05Z"),
+		xmlEscape(sp.config.IdPSSOURL), xmlEscape(sp.config.ACSURL), samlBindingPOST, xmlEscape(sp.config.EntityID))
+
+	var deflated bytes.Buffer
+	writer, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	writer.Write([]byte(request))
+	writer.Close()
+	query := url.Values{"SAMLRequest": {base64.StdEncoding.EncodeToString(deflated.Bytes())}}
+	if relayState != "" {
+		query.Set("RelayState", relayState)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.expire(now)
+	sp.requests[id] = now.Add(samlRequestTTL)
+
+	separator := "?"
+	if strings.Contains(sp.config.IdPSSOURL, "?") {
+		separator = "&"
+	}
+	return sp.config.IdPSSOURL + separator + query.Encode(), nil
+}
+
+// expire забывает просроченные запросы и утверждения; вызывается под sp.mu
+func (sp *SAMLServiceProvider) expire(now time.Time) {
+	for id, until := range sp.requests {
+		if now.After(until) {
+			delete(sp.requests, id)
+		}
+	}
+	for id, until := range sp.seen {
+		if now.After(until.Add(samlClockSkew)) {
+			delete(sp.seen, id)
+		}
+	}
+}
+
+// ParseResponse проверяет ответ IdP (base64 из поля SAMLResponse) и возвращает удостоверение.
+// Принимается один незашифрованный Assertion, подписанный сам или в составе подписанного
+// Response, с действующими сроками, аудиторией этой системы и подтверждением bearer для ACS.
+func (sp *SAMLServiceProvider) ParseResponse(encoded string, now time.Time) (SAMLIdentity, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(encoded), ""))
+	if err != nil {
+		return SAMLIdentity{}, fmt.Errorf("ответ не в base64: %v", err)
+	}
+	response, err := parseXMLTree(data)
+	if err != nil {
+		return SAMLIdentity{}, err
+	}
+	if response.space != samlProtocolNS || response.local != "Response" {
+		return SAMLIdentity{}, fmt.Errorf("ожидается samlp:Response, а не %s", response.local)
+	}
+
+	// Одинаковые ID позволили бы подложить неподписанный элемент вместо подписанного
+	ids := make(map[string]bool)
+	duplicate := false
+	response.walk(func(node *xmlNode) {
+		if id := node.attr("ID"); id != "" {
+			duplicate = duplicate || ids[id]
+			ids[id] = true
+		}
+	})
+	if duplicate {
+		return SAMLIdentity{}, fmt.Errorf("в ответе повторяются идентификаторы элементов")
+	}
+
+	if destination := response.attr("Destination"); destination != "" && destination != sp.config.ACSURL {
+		return SAMLIdentity{}, fmt.Errorf("ответ предназначен для %s", destination)
+	}
+	if issuer := response.child(samlAssertionNS, "Issuer"); issuer != nil && issuer.text() != sp.config.IdPEntityID {
+		return SAMLIdentity{}, fmt.Errorf("ответ выдан неизвестным IdP %s", issuer.text())
+	}
+	status := response.child(samlProtocolNS, "Status")
+	var statusCode *xmlNode
+	if status != nil {
+		statusCode = status.child(samlProtocolNS, "StatusCode")
+	}
+	if statusCode == nil || statusCode.attr("Value") != samlStatusSuccess {
+		value := "не указан"
+		if statusCode != nil {
+			value = statusCode.attr("Value")
+		}
+		return SAMLIdentity{}, fmt.Errorf("IdP не подтвердил вход: статус %s", value)
+	}
+	if len(response.childNodes(samlAssertionNS, "EncryptedAssertion")) > 0 {
+		return SAMLIdentity{}, fmt.Errorf("зашифрованные утверждения не поддерживаются")
+	}
+	assertions := response.childNodes(samlAssertionNS, "Assertion")
+	if len(assertions) != 1 {
+		return SAMLIdentity{}, fmt.Errorf("ответ должен содержать одно утверждение, а не %d", len(assertions))
+	}
+	assertion := assertions[0]
+
+	responseSigned := response.child(dsigNS, "Signature") != nil
+	if responseSigned {
+		if err := verifyEnvelopedSignature(response, sp.cert); err != nil {
+			return SAMLIdentity{}, fmt.Errorf("подпись ответа: %v", err)
+		}
+	}
+	if assertion.child(dsigNS, "Signature") != nil {
+		if err := verifyEnvelopedSignature(assertion, sp.cert); err != nil {
+			return SAMLIdentity{}, fmt.Errorf("подпись утверждения: %v", err)
+		}
+	} else if !responseSigned {
+		return SAMLIdentity{}, fmt.Errorf("ни ответ, ни утверждение не подписаны")
+	}
+
+	identity, validUntil, err := sp.checkAssertion(assertion, response.attr("InResponseTo"), now)
+	if err != nil {
+		return SAMLIdentity{}, err
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.expire(now)
+	inResponseTo := response.attr("InResponseTo")
+	switch {
+	case inResponseTo == "" && !sp.config.AllowIdPInitiated:
+		return SAMLIdentity{}, fmt.Errorf("вход по инициативе IdP не разрешен (allow_idp_initiated)")
+	case inResponseTo != "" && sp.requests[inResponseTo].IsZero():
+		return SAMLIdentity{}, fmt.Errorf("ответ на неизвестный или устаревший запрос входа")
+	case !sp.seen[assertion.attr("ID")].IsZero():
+		return SAMLIdentity{}, fmt.Errorf("утверждение уже использовано")
+	}
+	delete(sp.requests, inResponseTo)
+	sp.seen[assertion.attr("ID")] = validUntil
+	return identity, nil
+}
+
+// checkAssertion проверяет издателя, подтверждение субъекта и условия утверждения.
+// Возвращает удостоверение и момент, до которого утверждение действительно.
+func (sp *SAMLServiceProvider) checkAssertion(assertion *xmlNode, inResponseTo string, now time.Time) (SAMLIdentity, time.Time, error) {
+	if assertion.attr("ID") == "" {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("у утверждения нет ID")
+	}
+	if issuer := assertion.child(samlAssertionNS, "Issuer"); issuer == nil || issuer.text() != sp.config.IdPEntityID {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("утверждение выдано не %s", sp.config.IdPEntityID)
+	}
+
+	subject := assertion.child(samlAssertionNS, "Subject")
+	if subject == nil || subject.child(samlAssertionNS, "NameID") == nil || subject.child(samlAssertionNS, "NameID").text() == "" {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("в утверждении нет NameID")
+	}
+	identity := SAMLIdentity{NameID: subject.child(samlAssertionNS, "NameID").text(), Attributes: make(map[string][]string)}
+
+	// Подтверждение bearer: ответ адресован этому ACS и еще действует
+	var validUntil time.Time
+	for _, confirmation := range subject.childNodes(samlAssertionNS, "SubjectConfirmation") {
+		data := confirmation.child(samlAssertionNS, "SubjectConfirmationData")
+		if confirmation.attr("Method") != samlBearer || data == nil || data.attr("Recipient") != sp.config.ACSURL {
+			continue
+		}
+		if data.attr("InResponseTo") != "" && data.attr("InResponseTo") != inResponseTo {
+			continue
+		}
+		notOnOrAfter, err := parseSAMLTime(data.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(samlClockSkew)) {
+			continue
+		}
+		validUntil = notOnOrAfter
+		break
+	}
+	if validUntil.IsZero() {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("нет действующего подтверждения bearer для %s", sp.config.ACSURL)
+	}
+
+	conditions := assertion.child(samlAssertionNS, "Conditions")
+	if conditions == nil {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("в утверждении нет Conditions")
+	}
+	if value := conditions.attr("NotBefore"); value != "" {
+		notBefore, err := parseSAMLTime(value)
+		if err != nil || now.Add(samlClockSkew).Before(notBefore) {
+			return SAMLIdentity{}, time.Time{}, fmt.Errorf("утверждение еще не действует")
+		}
+	}
+	if value := conditions.attr("NotOnOrAfter"); value != "" {
+		notOnOrAfter, err := parseSAMLTime(value)
+		if err != nil || !now.Before(notOnOrAfter.Add(samlClockSkew)) {
+			return SAMLIdentity{}, time.Time{}, fmt.Errorf("срок действия утверждения истек")
+		}
+		if notOnOrAfter.After(validUntil) {
+			validUntil = notOnOrAfter
+		}
+	}
+	restrictions := conditions.childNodes(samlAssertionNS, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return SAMLIdentity{}, time.Time{}, fmt.Errorf("утверждение не ограничено аудиторией")
+	}
+	for _, restriction := range restrictions {
+		allowed := false
+		for _, audience := range restriction.childNodes(samlAssertionNS, "Audience") {
+			allowed = allowed || audience.text() == sp.config.EntityID
+		}
+		if !allowed {
+			return SAMLIdentity{}, time.Time{}, fmt.Errorf("утверждение предназначено не для %s", sp.config.EntityID)
+		}
+	}
+
+	for _, statement := range assertion.childNodes(samlAssertionNS, "AttributeStatement") {
+		for _, attribute := range statement.childNodes(samlAssertionNS, "Attribute") {
+			name := attribute.attr("Name")
+			for _, value := range attribute.childNodes(samlAssertionNS, "AttributeValue") {
+				identity.Attributes[name] = append(identity.Attributes[name], value.text())
+			}
+		}
+	}
+	return identity, validUntil, nil
+}
+
+// parseSAMLTime разбирает время xs:dateTime
+func parseSAMLTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// xmlEscape экранирует текст для вставки в XML
+func xmlEscape(text string) string {
+	var out bytes.Buffer
+	xml.EscapeText(&out, []byte(text))
+	return out.String()
+}
+
+// AuthenticateSAML выполняет вход по проверенному удостоверению SAML и учитывает попытку
+// в статистике входа. Возвращает результат и логин локальной учетной записи.
+func (um *UserManager) AuthenticateSAML(identity SAMLIdentity, config SAMLConfig) (AuthOutcome, string, error) {
+	started := time.Now()
+	outcome, username, err := um.checkSAMLIdentity(identity, config)
+	um.loginStats.record(loginResultLabel(outcome, err), time.Since(started), time.Now())
+	return outcome, username, err
+}
+
+// checkSAMLIdentity сопоставляет удостоверение с учетной записью: сначала по связи с NameID,
+// затем, в режиме auto, создает учетную запись с логином из утверждения. Существующая
+// учетная запись без связи не занимается: иначе IdP мог бы войти в чужую локальную запись.
+func (um *UserManager) checkSAMLIdentity(identity SAMLIdentity, config SAMLConfig) (AuthOutcome, string, error) {
+	now := time.Now()
+	if outcome, blocked := um.sourceLockout(now); blocked {
+		return outcome, "", nil
+	}
+
+	user := um.samlLinkedUser(identity.NameID)
+	if user == nil {
+		username := identity.Username(config.UsernameAttribute)
+		switch {
+		case username == "":
+			um.recordAudit(AuditSAMLRejected, "", fmt.Sprintf("NameID %s: нет атрибута %s", identity.NameID, config.UsernameAttribute))
+			return AuthOutcome{Result: AuthSAMLNotLinked}, "", nil
+		case config.Provisioning != SAMLProvisionAuto || um.store.UserExists(username):
+			um.recordAudit(AuditSAMLRejected, username, fmt.Sprintf("NameID %s не связан с учетной записью", identity.NameID))
+			return AuthOutcome{Result: AuthSAMLNotLinked}, "", nil
+		}
+		if err := um.runHook(HookPreRegister, username); err != nil {
+			return AuthOutcome{Result: AuthRejectedByHook}, "", nil
+		}
+		user = &User{
+			Username:        username,
+			CreatedAt:       now,
+			SAMLNameID:      identity.NameID,
+			PendingApproval: um.requireApproval,
+		}
+		um.store.SaveUser(user)
+		details := fmt.Sprintf("IdP %s, NameID %s", config.IdPEntityID, identity.NameID)
+		if user.PendingApproval {
+			details += ", ожидает одобрения администратором"
+		}
+		um.recordAudit(AuditSAMLProvisioned, username, details)
+		um.usersChanged()
+	}
+
+	if outcome, allowed := um.checkLoginAllowed(user, now); !allowed {
+		return outcome, user.Username, nil
+	}
+	details := fmt.Sprintf("SAML: IdP %s, NameID %s", config.IdPEntityID, identity.NameID)
+	outcome, _, err := um.admitLogin(user, "", AuditLoginSuccess, details, now)
+	return outcome, user.Username, err
+}
+
+// samlLinkedUser возвращает учетную запись, связанную с NameID (nil - нет связи)
+func (um *UserManager) samlLinkedUser(nameID string) *User {
+	if nameID == "" {
+		return nil
+	}
+	for _, user := range um.store.GetAllUsers() {
+		if user.SAMLNameID == nameID {
+			return user
+		}
+	}
+	return nil
+}
+
+// LinkSAML связывает учетную запись с удостоверением SAML (NameID у IdP); пустой nameID
+// снимает связь. Одно удостоверение связывается только с одной учетной записью.
+func (um *UserManager) LinkSAML(username, nameID, reason string) error {
+	username = strings.TrimSpace(username)
+	nameID = strings.TrimSpace(nameID)
+	if linked := um.samlLinkedUser(nameID); linked != nil && linked.Username != username {
+		return fmt.Errorf("удостоверение %s уже связано с учетной записью %s", nameID, linked.Username)
+	}
+
+	var previous string
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		if nameID == "" && user.SAMLNameID == "" {
+			return fmt.Errorf("учетная запись не связана с SAML")
+		}
+		previous = user.SAMLNameID
+		user.SAMLNameID = nameID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if nameID == "" {
+		um.recordAudit(AuditSAMLUnlinked, username, strings.TrimSpace("NameID "+previous+" "+reason))
+	} else {
+		um.recordAudit(AuditSAMLLinked, username, strings.TrimSpace("NameID "+nameID+" "+reason))
+	}
+	return nil
+}
+
+// handleSAML обслуживает адреса SP: метаданные, начало входа и прием ответа IdP
+func (s *APIServer) handleSAML(w http.ResponseWriter, r *http.Request) {
+	if s.saml == nil {
+		writeAPIError(w, http.StatusNotFound, CodeNotConfigured, "вход через SAML не настроен (-saml-config)")
+		return
+	}
+	switch r.URL.Path {
+	case samlMetadataPath:
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, "GET")
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		w.Write(s.saml.Metadata())
+	case samlLoginPath:
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, "GET")
+			return
+		}
+		target, err := s.saml.AuthnRequestURL(r.URL.Query().Get("RelayState"), time.Now())
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	case samlACSPath:
+		s.handleSAMLACS(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, CodeUnknownResource, "неизвестный ресурс")
+	}
+}
+
+// handleSAMLACS: POST /saml/acs - ответ IdP (привязка HTTP-POST, поле формы SAMLResponse).
+// Результат входа - как у POST /auth, с токеном доступа, если выдача токенов включена.
+func (s *APIServer) handleSAMLACS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, samlMaxResponseSize)
+	if err := r.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("некорректная форма: %v", err))
+		return
+	}
+	encoded := r.PostForm.Get("SAMLResponse")
+	if encoded == "" {
+		writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "не указан ответ IdP (поле SAMLResponse)")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	defer s.lockRequest(r)()
+	login := func(w http.ResponseWriter) {
+		identity, err := s.saml.ParseResponse(encoded, time.Now())
+		if err != nil {
+			s.um.recordAudit(AuditSAMLRejected, "", err.Error())
+			writeAPIError(w, http.StatusUnauthorized, CodeSAMLRejected, "утверждение SAML отклонено: "+err.Error())
+			return
+		}
+		outcome, username, err := s.um.AuthenticateSAML(identity, s.saml.config)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		s.writeAuthOutcome(w, outcome, username)
+	}
+	s.writableLogin(w, login)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExclusiveC14N(t *testing.T) {
+	tests := []struct {
+		name      string
+		document  string
+		path      []string // Локальные имена от корня до канонизируемого элемента
+		inclusive string   // PrefixList элемента InclusiveNamespaces
+		want      string
+	}{
+		{
+			name:     "пример 2.2 спецификации: объявления предков не наследуются",
+			document: `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n0:local>`,
+			path:     []string{"elem2"},
+			want:     `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+		{
+			name:     "пример 2.2 спецификации: атрибуты xml: предков не наследуются",
+			document: `<n2:pdu xmlns:n1="http://example.com" xmlns:n2="http://foo.example" xml:lang="fr" xml:space="retain"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"/></n1:elem2></n2:pdu>`,
+			path:     []string{"elem2"},
+			want:     `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en"><n3:stuff xmlns:n3="ftp://example.org"></n3:stuff></n1:elem2>`,
+		},
+		{
+			name:     "порядок объявлений и атрибутов, экранирование",
+			document: `<a xmlns:p="urn:p" p:c="3" b="2" xmlns="urn:x" a='1&lt;"&#9;'>t&gt;&amp;"</a>`,
+			want:     `<a xmlns="urn:x" xmlns:p="urn:p" a="1&lt;&quot;&#x9;" b="2" p:c="3">t&gt;&amp;"</a>`,
+		},
+		{
+			name:     "отмена пространства имен по умолчанию",
+			document: `<a xmlns="urn:x"><b xmlns=""><c/></b></a>`,
+			want:     `<a xmlns="urn:x"><b xmlns=""><c></c></b></a>`,
+		},
+		{
+			name:     "без пространства имен xmlns=\"\" не выводится",
+			document: `<r xmlns="urn:x"><a xmlns=""><b/></a></r>`,
+			path:     []string{"a"},
+			want:     `<a><b></b></a>`,
+		},
+		{
+			name:     "повторное объявление того же URI не выводится",
+			document: `<p:a xmlns:p="urn:p"><p:b xmlns:p="urn:p"/><p:c xmlns:p="urn:other"/></p:a>`,
+			want:     `<p:a xmlns:p="urn:p"><p:b></p:b><p:c xmlns:p="urn:other"></p:c></p:a>`,
+		},
+		{
+			name:     "префикс только в значении атрибута отбрасывается",
+			document: `<r xmlns:xs="urn:xs" xmlns:p="urn:p"><p:v t="xs:string">x</p:v></r>`,
+			path:     []string{"v"},
+			want:     `<p:v xmlns:p="urn:p" t="xs:string">x</p:v>`,
+		},
+		{
+			name:      "InclusiveNamespaces сохраняет префикс",
+			document:  `<r xmlns:xs="urn:xs" xmlns:p="urn:p"><p:v t="xs:string">x</p:v></r>`,
+			path:      []string{"v"},
+			inclusive: "xs",
+			want:      `<p:v xmlns:p="urn:p" xmlns:xs="urn:xs" t="xs:string">x</p:v>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := parseXMLTree([]byte(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, local := range test.path {
+				var next *xmlNode
+				for _, child := range node.children {
+					if child.node != nil && child.node.local == local {
+						next = child.node
+					}
+				}
+				if next == nil {
+					t.Fatalf("нет элемента %s", local)
+				}
+				node = next
+			}
+			var method *xmlNode
+			if test.inclusive != "" {
+				method, err = parseXMLTree([]byte(`<Transform><ec:InclusiveNamespaces xmlns:ec="` + excC14NAlgorithm + `" PrefixList="` + test.inclusive + `"/></Transform>`))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := string(exclusiveC14N(node, method, nil)); got != test.want {
+				t.Errorf("\nполучено  %s\nожидалось %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseXMLTreeRejectsDTD(t *testing.T) {
+	_, err := parseXMLTree([]byte(`<!DOCTYPE r [<!ENTITY e "x">]><r>&e;</r>`))
+	if err == nil {
+		t.Fatal("DTD принята")
+	}
+}
+
+// testSAMLResponse - ответ IdP из testdata/saml: утверждение подписано ключом, сертификат
+// которого лежит рядом; канонизация при подписи выполнена libxml2 (generate.sh)
+func testSAMLResponse(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/saml/response.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// testSAMLProvider создает SP, который принимает ответы тестового IdP
+func testSAMLProvider(t *testing.T, cert *x509.Certificate) *SAMLServiceProvider {
+	t.Helper()
+	if cert == nil {
+		data, err := os.ReadFile("testdata/saml/idp.crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			t.Fatal("testdata/saml/idp.crt: нет сертификата")
+		}
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &SAMLServiceProvider{
+		config: SAMLConfig{
+			EntityID:          "https://uas.example.com",
+			ACSURL:            "https://uas.example.com/v1/saml/acs",
+			IdPEntityID:       "https://idp.example.com",
+			AllowIdPInitiated: true,
+		},
+		cert:     cert,
+		requests: make(map[string]time.Time),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// samlTestNow - момент внутри срока действия тестового утверждения
+var samlTestNow = time.Date(2025, 3, 1, 12, 1, 0, 0, time.UTC)
+
+// cutElement возвращает текст элемента, который начинается с open и заканчивается close
+func cutElement(t *testing.T, document, open, close string) string {
+	t.Helper()
+	start := strings.Index(document, open)
+	end := strings.Index(document[start+1:], close)
+	if start < 0 || end < 0 {
+		t.Fatalf("нет элемента %s", open)
+	}
+	return document[start : start+1+end+len(close)]
+}
+
+func TestSAMLParseResponse(t *testing.T) {
+	response := testSAMLResponse(t)
+	assertion := cutElement(t, response, "<saml:Assertion ", "</saml:Assertion>")
+	signature := cutElement(t, assertion, "<ds:Signature ", "</ds:Signature>")
+	forged := strings.ReplaceAll(assertion, "alice", "mallory")
+	unsigned := strings.Replace(forged, signature, "", 1)
+
+	tests := []struct {
+		name     string
+		response string
+		reject   string // Фрагмент ошибки; пусто - ответ принимается
+	}{
+		{"подписанное утверждение", response, ""},
+		{"изменен NameID", strings.Replace(response, "alice@example.com", "mallory@example.com", 1), "хеш не совпадает"},
+		{"изменен атрибут", strings.Replace(response, ">alice</saml:AttributeValue>", ">admin</saml:AttributeValue>", 1), "хеш не совпадает"},
+		{"изменена подпись SignedInfo", strings.Replace(response, `URI="#_assertion1"`, `URI="#_assertion1" Id="x"`, 1), "неверная подпись"},
+		{"подпись удалена", strings.Replace(response, signature, "", 1), "не подписаны"},
+		{
+			// Подписанное утверждение спрятано в Extensions, рядом - поддельное с тем же ID
+			"повторяющийся ID",
+			strings.Replace(response, assertion, "<samlp:Extensions>"+assertion+"</samlp:Extensions>"+forged, 1),
+			"повторяются идентификаторы",
+		},
+		{
+			// Подписанное утверждение вынесено из проверяемого места, вместо него - неподписанное
+			"утверждение вынесено из подписанного элемента",
+			strings.Replace(response, assertion, "<samlp:Extensions>"+assertion+"</samlp:Extensions>"+
+				strings.Replace(unsigned, `ID="_assertion1"`, `ID="_forged"`, 1), 1),
+			"не подписаны",
+		},
+		{
+			// Подпись из исходного утверждения перенесена в поддельное с другим ID
+			"подпись перенесена в другое утверждение",
+			strings.Replace(response, assertion, "<samlp:Extensions>"+strings.Replace(assertion, signature, "", 1)+"</samlp:Extensions>"+
+				strings.Replace(forged, `ID="_assertion1"`, `ID="_forged"`, 1), 1),
+			"подпись относится не к элементу",
+		},
+		{
+			"второе неподписанное утверждение",
+			strings.Replace(response, "</samlp:Response>", strings.Replace(unsigned, `ID="_assertion1"`, `ID="_forged"`, 1)+"</samlp:Response>", 1),
+			"одно утверждение",
+		},
+		{
+			"неподписанное утверждение внутри подписанного",
+			strings.Replace(response, "</saml:Assertion>", strings.Replace(unsigned, `ID="_assertion1"`, `ID="_forged"`, 1)+"</saml:Assertion>", 1),
+			"хеш не совпадает",
+		},
+		{"ответ другому SP", strings.Replace(response, `Destination="https://uas.example.com/v1/saml/acs"`, `Destination="https://evil.example.com/acs"`, 1), "предназначен"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := testSAMLProvider(t, nil).ParseResponse(base64.StdEncoding.EncodeToString([]byte(test.response)), samlTestNow)
+			if test.reject != "" {
+				if err == nil || !strings.Contains(err.Error(), test.reject) {
+					t.Fatalf("ожидался отказ %q, получено %v", test.reject, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.NameID != "alice@example.com" || identity.Username("uid") != "alice" ||
+				strings.Join(identity.Attributes["department"], ",") != `R&D "Lab"` {
+				t.Fatalf("удостоверение %+v", identity)
+			}
+		})
+	}
+}
+
+func TestSAMLParseResponseChecks(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(testSAMLResponse(t)))
+
+	sp := testSAMLProvider(t, nil)
+	if _, err := sp.ParseResponse(encoded, samlTestNow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sp.ParseResponse(encoded, samlTestNow); err == nil || !strings.Contains(err.Error(), "уже использовано") {
+		t.Errorf("повтор утверждения: %v", err)
+	}
+
+	if _, err := testSAMLProvider(t, nil).ParseResponse(encoded, samlTestNow.Add(10*time.Minute)); err == nil {
+		t.Error("принято просроченное утверждение")
+	}
+
+	sp = testSAMLProvider(t, nil)
+	sp.config.AllowIdPInitiated = false
+	if _, err := sp.ParseResponse(encoded, samlTestNow); err == nil || !strings.Contains(err.Error(), "allow_idp_initiated") {
+		t.Errorf("вход по инициативе IdP: %v", err)
+	}
+
+	sp = testSAMLProvider(t, nil)
+	sp.config.EntityID = "https://other.example.com"
+	if _, err := sp.ParseResponse(encoded, samlTestNow); err == nil || !strings.Contains(err.Error(), "предназначено не для") {
+		t.Errorf("чужая аудитория: %v", err)
+	}
+
+	// Сертификат другого IdP
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "other"}, NotAfter: samlTestNow.AddDate(1, 0, 0)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testSAMLProvider(t, other).ParseResponse(encoded, samlTestNow); err == nil {
+		t.Error("принята подпись, не соответствующая сертификату IdP")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoginSchedule ограничивает время, когда пользователь может войти в систему
+type LoginSchedule struct {
+	Weekdays   []time.Weekday // Разрешенные дни недели (пусто - любой день)
+	StartTime  int            // Начало разрешенного интервала, минут от полуночи
+	EndTime    int            // Конец интервала, минут от полуночи (StartTime == EndTime - весь день)
+	ValidFrom  time.Time      // Учетная запись действует с этой даты (нулевое значение - без ограничения)
+	ValidUntil time.Time      // Учетная запись действует до этой даты включительно
+}
+
+// weekdayNames - сокращенные названия дней недели
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "Пн",
+	time.Tuesday:   "Вт",
+	time.Wednesday: "Ср",
+	time.Thursday:  "Чт",
+	time.Friday:    "Пт",
+	time.Saturday:  "Сб",
+	time.Sunday:    "Вс",
+}
+
+// Allows проверяет, разрешен ли вход в момент t
+func (s *LoginSchedule) Allows(t time.Time) bool {
+	if !s.ValidFrom.IsZero() && t.Before(s.ValidFrom) {
+		return false
+	}
+	if !s.ValidUntil.IsZero() && !t.Before(s.ValidUntil.AddDate(0, 0, 1)) {
+		return false
+	}
+
+	if len(s.Weekdays) > 0 {
+		allowed := false
+		for _, day := range s.Weekdays {
+			if t.Weekday() == day {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if s.StartTime == s.EndTime {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if s.StartTime < s.EndTime {
+		return minute >= s.StartTime && minute < s.EndTime
+	}
+	// Интервал через полночь, например 22:00-06:00
+	return minute >= s.StartTime || minute < s.EndTime
+}
+
+// NextAllowed возвращает ближайший момент не раньше t, когда вход разрешен.
+// Если расписание больше не допускает входа (срок действия истек), возвращает false.
+func (s *LoginSchedule) NextAllowed(t time.Time) (time.Time, bool) {
+	if s.Allows(t) {
+		return t, true
+	}
+
+	start := t.Truncate(time.Minute).Add(time.Minute)
+	if !s.ValidFrom.IsZero() && start.Before(s.ValidFrom) {
+		start = s.ValidFrom
+	}
+
+	// Недельный цикл повторяется, поэтому достаточно просмотреть 8 суток поминутно
+	for candidate := start; candidate.Before(start.AddDate(0, 0, 8)); candidate = candidate.Add(time.Minute) {
+		if s.Allows(candidate) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// String возвращает описание расписания для вывода статуса
+func (s *LoginSchedule) String() string {
+	var parts []string
+
+	if len(s.Weekdays) > 0 {
+		days := make([]string, len(s.Weekdays))
+		for i, day := range s.Weekdays {
+			days[i] = weekdayNames[day]
+		}
+		parts = append(parts, strings.Join(days, ","))
+	} else {
+		parts = append(parts, "ежедневно")
+	}
+
+	if s.StartTime != s.EndTime {
+		parts = append(parts, fmt.Sprintf("%02d:%02d-%02d:%02d", s.StartTime/60, s.StartTime%60, s.EndTime/60, s.EndTime%60))
+	} else {
+		parts = append(parts, "круглосуточно")
+	}
+
+	if !s.ValidFrom.IsZero() {
+		parts = append(parts, "с "+s.ValidFrom.Format("2006-01-02"))
+	}
+	if !s.ValidUntil.IsZero() {
+		parts = append(parts, "по "+s.ValidUntil.Format("2006-01-02"))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// SetLoginSchedule задает расписание входа пользователя (nil снимает ограничения)
+func (um *UserManager) SetLoginSchedule(username string, schedule *LoginSchedule) error {
+	username = strings.TrimSpace(username)
+
+	if schedule != nil && !schedule.ValidFrom.IsZero() && !schedule.ValidUntil.IsZero() &&
+		schedule.ValidUntil.Before(schedule.ValidFrom) {
+		return fmt.Errorf("дата окончания раньше даты начала")
+	}
+
+	err := um.store.Update(username, func(user *User) error {
+		user.Schedule = schedule
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	details := "ограничения сняты"
+	if schedule != nil {
+		details = schedule.String()
+	}
+	um.recordAudit(AuditScheduleChanged, username, details)
+
+	return nil
+}
+
+// ParseWeekdays разбирает список дней недели вида "1-5" или "1,3,6" (1 - понедельник, 7 - воскресенье)
+func ParseWeekdays(value string) ([]time.Weekday, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	seen := make(map[time.Weekday]bool)
+	var days []time.Weekday
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			last = first
+		}
+
+		from, err1 := strconv.Atoi(strings.TrimSpace(first))
+		to, err2 := strconv.Atoi(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil || from < 1 || to > 7 || from > to {
+			return nil, fmt.Errorf("некорректные дни недели: %s", part)
+		}
+
+		for n := from; n <= to; n++ {
+			day := time.Weekday(n % 7) // 7 - воскресенье (time.Sunday == 0)
+			if !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// ParseTimeRange разбирает интервал вида "09:00-18:00" в минуты от полуночи
+func ParseTimeRange(value string) (int, int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, 0, nil
+	}
+
+	first, last, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("некорректный интервал времени: %s", value)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(first))
+	if err != nil {
+		return 0, 0, fmt.Errorf("некорректное время: %s", first)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(last))
+	if err != nil {
+		return 0, 0, fmt.Errorf("некорректное время: %s", last)
+	}
+
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
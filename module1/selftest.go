@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SelfTestCheck - результат проверки одного сценария самопроверки
+type SelfTestCheck struct {
+	Scenario string // Проверяемый сценарий
+	Expected string // Ожидаемое по политике поведение
+	Observed string // Фактическое поведение
+	Passed   bool   // Поведение соответствует политике
+}
+
+// SelfTestConfig - параметры защиты, с которыми проверяются сценарии
+type SelfTestConfig struct {
+	FailureWindow   time.Duration // Окно подсчета неудачных попыток
+	HoneypotLockout time.Duration // Блокировка входа после попытки входа в ловушку
+}
+
+// RunBruteForceSelfTest прогоняет типовые сценарии подбора паролей против временного
+// экземпляра в памяти (без журнала аудита и htpasswd) и сравнивает реакцию с политикой
+func RunBruteForceSelfTest(config SelfTestConfig) ([]SelfTestCheck, error) {
+	var checks []SelfTestCheck
+	for _, scenario := range []func(SelfTestConfig) (SelfTestCheck, error){
+		selfTestSingleAccount,
+		selfTestSpraying,
+		selfTestLowAndSlow,
+	} {
+		check, err := scenario(config)
+		if err != nil {
+			return checks, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// newSelfTestManager создает временный менеджер с заданными параметрами и пользователями
+func newSelfTestManager(config SelfTestConfig, usernames ...string) (*UserManager, map[string]string, error) {
+	um := NewUserManager()
+	um.SetFailureWindow(config.FailureWindow)
+	um.SetHoneypotLockout(config.HoneypotLockout)
+
+	passwords := make(map[string]string, len(usernames))
+	for _, username := range usernames {
+		password, err := GeneratePassword(um.rules)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := um.RegisterUser(username, password); err != nil {
+			return nil, nil, err
+		}
+		passwords[username] = password
+	}
+	return um, passwords, nil
+}
+
+// selfTestSingleAccount - подбор пароля одной учетной записи подряд
+func selfTestSingleAccount(config SelfTestConfig) (SelfTestCheck, error) {
+	um, passwords, err := newSelfTestManager(config, "victim")
+	if err != nil {
+		return SelfTestCheck{}, err
+	}
+
+	check := SelfTestCheck{
+		Scenario: "подбор пароля одной учетной записи",
+		Expected: fmt.Sprintf("вход по паролю блокируется после %d неудачных попыток", um.maxAttempts),
+	}
+
+	blockedAt := 0
+	for attempt := 1; attempt <= um.maxAttempts+1 && blockedAt == 0; attempt++ {
+		outcome, err := um.AuthenticateUser("victim", fmt.Sprintf("wrong-%d", attempt))
+		if err != nil {
+			return SelfTestCheck{}, err
+		}
+		if outcome.Result == AuthUserBlocked {
+			blockedAt = attempt
+		}
+	}
+
+	// После блокировки не должен проходить даже верный пароль
+	outcome, err := um.AuthenticateUser("victim", passwords["victim"])
+	if err != nil {
+		return SelfTestCheck{}, err
+	}
+
+	switch {
+	case blockedAt == 0:
+		check.Observed = fmt.Sprintf("блокировка не сработала за %d попыток", um.maxAttempts+1)
+	case outcome.Result != AuthUserBlocked:
+		check.Observed = fmt.Sprintf("блокировка после %d попыток, но верный пароль принят (%s)", blockedAt, outcome)
+	default:
+		check.Observed = fmt.Sprintf("заблокирован после %d попыток, верный пароль отклонен", blockedAt)
+		check.Passed = blockedAt == um.maxAttempts
+	}
+	return check, nil
+}
+
+// selfTestSpraying - перебор одного распространенного пароля по многим учетным записям,
+// среди которых есть ловушка
+func selfTestSpraying(config SelfTestConfig) (SelfTestCheck, error) {
+	targets := []string{"alice", "bob", "admin", "carol"}
+	um, _, err := newSelfTestManager(config, "alice", "bob", "carol")
+	if err != nil {
+		return SelfTestCheck{}, err
+	}
+	if err := um.CreateHoneypot("admin"); err != nil {
+		return SelfTestCheck{}, err
+	}
+
+	check := SelfTestCheck{
+		Scenario: "перебор одного пароля по учетным записям",
+		Expected: "тревога при попытке входа в ловушку",
+	}
+	if config.HoneypotLockout > 0 {
+		check.Expected += fmt.Sprintf(", затем вход запрещен на %v", config.HoneypotLockout)
+	}
+
+	var sourceBlocked []string
+	for _, username := range targets {
+		outcome, err := um.AuthenticateUser(username, "Password123!")
+		if err != nil {
+			return SelfTestCheck{}, err
+		}
+		if outcome.Result == AuthSourceBlocked {
+			sourceBlocked = append(sourceBlocked, username)
+		}
+	}
+
+	alerted := len(um.HoneypotHits()) > 0
+	check.Observed = "тревога не поднята"
+	if alerted {
+		check.Observed = "тревога поднята"
+	}
+	if len(sourceBlocked) > 0 {
+		check.Observed += ", вход запрещен для: " + strings.Join(sourceBlocked, ", ")
+	}
+
+	// Ловушка - третья цель, блокировка должна остановить только оставшуюся
+	check.Passed = alerted && (len(sourceBlocked) > 0) == (config.HoneypotLockout > 0)
+	return check, nil
+}
+
+// selfTestLowAndSlow - медленный подбор: между попытками проходит больше времени,
+// чем длина окна подсчета. Прошедшее время имитируется сдвигом меток неудачных попыток.
+func selfTestLowAndSlow(config SelfTestConfig) (SelfTestCheck, error) {
+	um, _, err := newSelfTestManager(config, "victim")
+	if err != nil {
+		return SelfTestCheck{}, err
+	}
+
+	check := SelfTestCheck{Scenario: "медленный подбор (реже окна подсчета)"}
+	if config.FailureWindow > 0 {
+		check.Expected = fmt.Sprintf("не обнаруживается: попытки реже чем раз в %v не накапливаются", config.FailureWindow)
+	} else {
+		check.Expected = fmt.Sprintf("вход по паролю блокируется после %d неудачных попыток", um.maxAttempts)
+	}
+
+	blocked := false
+	attempts := um.maxAttempts + 1
+	for attempt := 1; attempt <= attempts && !blocked; attempt++ {
+		outcome, err := um.AuthenticateUser("victim", fmt.Sprintf("wrong-%d", attempt))
+		if err != nil {
+			return SelfTestCheck{}, err
+		}
+		blocked = outcome.Result == AuthUserBlocked
+
+		um.store.Update("victim", func(user *User) error {
+			for i := range user.FailedAt {
+				user.FailedAt[i] = user.FailedAt[i].Add(-config.FailureWindow - time.Minute)
+			}
+			return nil
+		})
+	}
+
+	if blocked {
+		check.Observed = "вход по паролю заблокирован"
+	} else {
+		check.Observed = fmt.Sprintf("не заблокирован после %d попыток", attempts)
+	}
+	check.Passed = blocked == (config.FailureWindow <= 0)
+	return check, nil
+}
+
+// FormatSelfTest возвращает результаты проверки в виде текста
+func FormatSelfTest(checks []SelfTestCheck) string {
+	var out strings.Builder
+	for _, check := range checks {
+		mark := "OK  "
+		if !check.Passed {
+			mark = "FAIL"
+		}
+		out.WriteString(fmt.Sprintf("[%s] %s\n", mark, check.Scenario))
+		out.WriteString(fmt.Sprintf("       ожидается: %s\n", check.Expected))
+		out.WriteString(fmt.Sprintf("       получено:  %s\n", check.Observed))
+	}
+	return out.String()
+}
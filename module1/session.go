@@ -0,0 +1,36 @@
+package main
+
+// ConsoleSession - сеанс интерактивного меню
+type ConsoleSession struct {
+	RequireAdmin bool      // Административные пункты меню доступны только пользователям с правами на них
+	Username     string    // Пользователь, последним вошедший через меню (пусто - вход не выполнялся)
+	Principal    Principal // Права роли вошедшего пользователя
+}
+
+// session - текущий сеанс интерактивного меню
+var session ConsoleSession
+
+// SignIn закрепляет сеанс за вошедшим пользователем
+func (s *ConsoleSession) SignIn(username string, principal Principal) {
+	s.Username = username
+	s.Principal = principal
+}
+
+// AdminAccess сообщает, доступны ли сеансу действия только для администратора
+func (s *ConsoleSession) AdminAccess() bool {
+	return !s.RequireAdmin || s.Principal.Admin
+}
+
+// Can сообщает, есть ли у сеанса право perm (пусто - хотя бы одно административное право)
+func (s *ConsoleSession) Can(perm Permission) bool {
+	if perm == "" {
+		return !s.RequireAdmin || s.Principal.Any()
+	}
+	return !s.RequireAdmin || s.Principal.Has(perm)
+}
+
+// CanManage сообщает, может ли сеанс выполнить действие с правом perm над учетной записью
+// username: со своей учетной записью - всегда, с чужими - только при наличии права
+func (s *ConsoleSession) CanManage(username string, perm Permission) bool {
+	return s.Can(perm) || (s.Username != "" && s.Username == username)
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// shellCommand - команда административной консоли (go run . shell)
+type shellCommand struct {
+	Args    string // Аргументы для справки
+	Help    string
+	Users   bool // Первый аргумент - логин, дополняется по Tab
+	Changes bool // Команда меняет учетную запись: после выполнения выводится подтверждение
+	// Право, необходимое для команды (например, роли службы поддержки доступна только unlock)
+	Permission Permission
+	Run        func(sh *adminShell, args []string) error
+}
+
+// shellCommands - команды административной консоли
+var shellCommands = map[string]shellCommand{
+	"help": {Help: "список команд"},
+	"list": {Permission: PermUserRead, Args: "[группа]", Help: "список пользователей (участников группы)", Run: func(sh *adminShell, args []string) error {
+		if len(args) > 0 {
+			return sh.um.WriteGroupMembers(sh.out, args[0])
+		}
+		_, err := sh.um.WriteUsersStatus(sh.out, 0, 0)
+		fmt.Fprintln(sh.out)
+		return err
+	}},
+	"status": {Permission: PermUserRead, Args: "<логин>", Help: "статус пользователя", Users: true, Run: func(sh *adminShell, args []string) error {
+		status, err := sh.um.GetUserStatus(args[0])
+		if err == nil {
+			fmt.Fprint(sh.out, status)
+		}
+		return err
+	}},
+	"passwd": {Permission: PermUserWrite, Args: "<логин>", Help: "сменить пароль (разблокировка)", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		password, err := sh.readPassword("Новый пароль: ")
+		if err != nil {
+			return err
+		}
+		confirm, err := sh.readPassword("Подтвердите новый пароль: ")
+		if err != nil {
+			return err
+		}
+		if password != confirm {
+			return fmt.Errorf("пароли не совпадают")
+		}
+		return sh.um.ChangePassword(args[0], password)
+	}},
+	"unlock": {Permission: PermUserUnlock, Args: "<логин> [причина]", Help: "разблокировать без смены пароля", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		return sh.um.UnlockUser(args[0], strings.Join(args[1:], " "))
+	}},
+	"disable": {Permission: PermUserWrite, Args: "<логин> [причина]", Help: "отключить учетную запись", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		return sh.um.DisableUser(args[0], strings.Join(args[1:], " "))
+	}},
+	"grant-admin": {Permission: permAdmin, Args: "<логин> [причина]", Help: "назначить администратором", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		return sh.um.SetAdmin(args[0], true, strings.Join(args[1:], " "))
+	}},
+	"revoke-admin": {Permission: permAdmin, Args: "<логин> [причина]", Help: "снять права администратора", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		return sh.um.SetAdmin(args[0], false, strings.Join(args[1:], " "))
+	}},
+	"saml-link": {Permission: PermUserWrite, Args: "<логин> <NameID> [причина]", Help: "связать с удостоверением корпоративного IdP (SAML)", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указан NameID")
+		}
+		return sh.um.LinkSAML(args[0], args[1], strings.Join(args[2:], " "))
+	}},
+	"saml-unlink": {Permission: PermUserWrite, Args: "<логин> [причина]", Help: "снять связь с удостоверением SAML", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		return sh.um.LinkSAML(args[0], "", strings.Join(args[1:], " "))
+	}},
+	"ssh-keys": {Permission: PermUserRead, Args: "<логин>", Help: "открытые ключи SSH учетной записи", Users: true, Run: func(sh *adminShell, args []string) error {
+		keys, err := sh.um.SSHKeys(args[0])
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			fmt.Fprintln(sh.out, "Ключей SSH нет")
+		}
+		now := time.Now()
+		for _, key := range keys {
+			status := "без срока"
+			switch {
+			case key.Expired(now):
+				status = "срок истек " + key.ExpiresAt.Format("2006-01-02")
+			case !key.ExpiresAt.IsZero():
+				status = "до " + key.ExpiresAt.Format("2006-01-02")
+			}
+			fmt.Fprintf(sh.out, "%s %s %s (%s)\n", key.Fingerprint, key.Type, key.Comment, status)
+		}
+		return nil
+	}},
+	"ssh-key-add": {Permission: PermUserWrite, Args: "<логин> <срок: 90d, 2025-12-31 или -> <ключ>", Help: "зарегистрировать открытый ключ SSH", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 3 {
+			return fmt.Errorf("не указаны срок действия и ключ")
+		}
+		expiresAt, err := parseSSHKeyExpiry(args[1], time.Now())
+		if err != nil {
+			return err
+		}
+		key, err := sh.um.AddSSHKey(args[0], strings.Join(args[2:], " "), expiresAt, "")
+		if err == nil {
+			fmt.Fprintf(sh.out, "Ключ %s зарегистрирован\n", key.Fingerprint)
+		}
+		return err
+	}},
+	"ssh-key-remove": {Permission: PermUserWrite, Args: "<логин> <отпечаток> [причина]", Help: "удалить открытый ключ SSH", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указан отпечаток ключа")
+		}
+		return sh.um.RemoveSSHKey(args[0], args[1], strings.Join(args[2:], " "))
+	}},
+	"certs": {Permission: PermUserRead, Args: "<логин>", Help: "сертификаты клиента, привязанные к учетной записи", Users: true, Run: func(sh *adminShell, args []string) error {
+		bindings, err := sh.um.CertBindings(args[0])
+		if err != nil {
+			return err
+		}
+		if len(bindings) == 0 {
+			fmt.Fprintln(sh.out, "Привязанных сертификатов нет")
+		}
+		for _, binding := range bindings {
+			fmt.Fprintf(sh.out, "%s (с %s)\n", binding, binding.AddedAt.Format("2006-01-02"))
+		}
+		return nil
+	}},
+	"cert-bind": {Permission: PermUserWrite, Args: "<логин> <sha256:..., dns:..., email:..., uri:... или файл .crt> [причина]", Help: "привязать сертификат клиента для входа в API", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указана привязка")
+		}
+		value := args[1]
+		if _, err := os.Stat(value); err == nil {
+			binding, err := certBindingFromFile(value)
+			if err != nil {
+				return err
+			}
+			value = binding.String()
+		}
+		binding, err := sh.um.BindCertificate(args[0], value, strings.Join(args[2:], " "))
+		if err == nil {
+			fmt.Fprintf(sh.out, "Привязан сертификат %s\n", binding)
+		}
+		return err
+	}},
+	"cert-unbind": {Permission: PermUserWrite, Args: "<логин> <привязка> [причина]", Help: "удалить привязку сертификата клиента", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указана привязка")
+		}
+		return sh.um.UnbindCertificate(args[0], args[1], strings.Join(args[2:], " "))
+	}},
+	"rename": {Permission: PermUserWrite, Args: "<логин> <новый логин> [причина]", Help: "переименовать учетную запись", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указан новый логин")
+		}
+		if err := sh.um.RenameUser(args[0], args[1], strings.Join(args[2:], " ")); err != nil {
+			return err
+		}
+		if session.Username == args[0] {
+			session.Username = args[1]
+		}
+		return nil
+	}},
+	"stats": {Permission: PermUserRead, Help: "доля успешных входов и задержка входа за 5 минут и час", Run: func(sh *adminShell, args []string) error {
+		fmt.Fprint(sh.out, FormatLoginStats(sh.um.LoginStats(), time.Now()))
+		return nil
+	}},
+	"password-age": {Permission: PermUserRead, Help: "возраст паролей и просроченные пароли (JSON)", Run: func(sh *adminShell, args []string) error {
+		data, err := json.MarshalIndent(sh.um.PasswordAgingReport(time.Now()), "", "  ")
+		if err == nil {
+			fmt.Fprintln(sh.out, string(data))
+		}
+		return err
+	}},
+	"apply": {Permission: permAdmin, Args: "<файл>", Help: "применить файл состояния учетных записей (YAML)", Run: func(sh *adminShell, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("использование: apply <файл>")
+		}
+		manifest, err := LoadUserManifest(args[0])
+		if err != nil {
+			return err
+		}
+		result, err := sh.um.ApplyUserManifest(manifest, args[0])
+		if err == nil || len(result.Changes) > 0 {
+			fmt.Fprint(sh.out, FormatManifestResult(result, sh.um.DryRun()))
+		}
+		return err
+	}},
+	"role": {Permission: permAdmin, Args: "<логин> <роль> [причина]", Help: "назначить роль: user, admin или роль из конфигурации", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указана роль")
+		}
+		if err := sh.um.SetRole(args[0], args[1], strings.Join(args[2:], " ")); err != nil {
+			return err
+		}
+		if session.Username == args[0] {
+			session.SignIn(args[0], sh.um.UserPrincipal(args[0]))
+		}
+		return nil
+	}},
+	"roles": {Permission: PermUserRead, Help: "роли и их права", Run: func(sh *adminShell, args []string) error {
+		fmt.Fprintf(sh.out, "%s: все права, назначение ролей\n%s: нет прав\n", RoleAdmin, RoleUser)
+		for _, name := range sh.um.RoleNames() {
+			permissions, _ := sh.um.RolePermissions(name)
+			fmt.Fprintf(sh.out, "%s: %s\n", name, describePermissions(permissions))
+		}
+		return nil
+	}},
+	"groups": {Permission: PermUserRead, Help: "группы, их аннотации и число участников", Run: func(sh *adminShell, args []string) error {
+		for _, name := range sh.um.GroupNames() {
+			group, _ := sh.um.Group(name)
+			line := fmt.Sprintf("%s: участников %d", name, len(sh.um.GroupMembers(name)))
+			if group.Description != "" {
+				line += ", " + group.Description
+			}
+			if group.Rule != "" {
+				line += ", правило: " + group.Rule
+			}
+			if annotations := describeAnnotations(group.Annotations); annotations != "" {
+				line += " (" + annotations + ")"
+			}
+			fmt.Fprintln(sh.out, line)
+		}
+		return nil
+	}},
+	"group-create": {Permission: PermPolicyManage, Args: "<группа> [описание]", Help: "создать группу", Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("использование: group-create <группа> [описание]")
+		}
+		return sh.um.CreateGroup(args[0], strings.Join(args[1:], " "), "")
+	}},
+	"group-delete": {Permission: PermPolicyManage, Args: "<группа> [причина]", Help: "удалить группу и членство в ней", Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("использование: group-delete <группа> [причина]")
+		}
+		return sh.um.DeleteGroup(args[0], strings.Join(args[1:], " "))
+	}},
+	"group-annotate": {Permission: PermPolicyManage, Args: "<группа> <ключ>[=<значение>]", Help: "задать аннотацию группы (без значения - удалить)", Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("использование: group-annotate <группа> <ключ>[=<значение>]")
+		}
+		key, value, _ := strings.Cut(args[1], "=")
+		return sh.um.SetGroupAnnotation(args[0], key, value, "")
+	}},
+	"group-rule": {Permission: PermPolicyManage, Args: "<группа> [правило]", Help: "отбирать участников группы по атрибутам (без правила - назначать вручную)", Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("использование: group-rule <группа> [правило, например department == \"IT\" && two_fa == true]")
+		}
+		return sh.um.SetGroupRule(args[0], strings.Join(args[1:], " "), "")
+	}},
+	"attr": {Permission: PermUserWrite, Args: "<логин> <атрибут>[=<значение>] [причина]", Help: "задать атрибут для правил групп (без значения - удалить)", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указан атрибут")
+		}
+		key, value, _ := strings.Cut(args[1], "=")
+		return sh.um.SetUserAttribute(args[0], key, value, strings.Join(args[2:], " "))
+	}},
+	"rule-test": {Permission: PermUserRead, Args: "<логин> [ЧЧ:ММ|ГГГГ-ММ-ДДTЧЧ:ММ] [условие]", Help: "проверить правила входа без входа (с условием - только его)", Users: true, Run: func(sh *adminShell, args []string) error {
+		at := time.Now()
+		rest := args[1:]
+		if len(rest) > 0 {
+			if parsed, ok := parseRuleTestTime(rest[0], at); ok {
+				at, rest = parsed, rest[1:]
+			}
+		}
+		return sh.um.WritePolicyRuleTest(sh.out, args[0], at, strings.Join(rest, " "))
+	}},
+	"group-add": {Permission: PermUserWrite, Args: "<логин> <группа> [причина]", Help: "добавить пользователя в группу", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указана группа")
+		}
+		return sh.um.AddGroupMember(args[0], args[1], strings.Join(args[2:], " "))
+	}},
+	"group-remove": {Permission: PermUserWrite, Args: "<логин> <группа> [причина]", Help: "исключить пользователя из группы", Users: true, Changes: true, Run: func(sh *adminShell, args []string) error {
+		if len(args) < 2 {
+			return fmt.Errorf("не указана группа")
+		}
+		return sh.um.RemoveGroupMember(args[0], args[1], strings.Join(args[2:], " "))
+	}},
+	"exit": {Help: "выйти из консоли"},
+}
+
+// parseRuleTestTime разбирает время проверки правил: ЧЧ:ММ (сегодня) или ГГГГ-ММ-ДДTЧЧ:ММ
+func parseRuleTestTime(value string, now time.Time) (time.Time, bool) {
+	if clock, err := time.ParseInLocation("15:04", value, time.Local); err == nil {
+		return time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local), true
+	}
+	if at, err := time.ParseInLocation("2006-01-02T15:04", value, time.Local); err == nil {
+		return at, true
+	}
+	return time.Time{}, false
+}
+
+// adminShell - административная консоль с историей команд и дополнением по Tab
+type adminShell struct {
+	um           *UserManager
+	out          io.Writer
+	readLine     func() (string, error)
+	readPassword func(prompt string) (string, error)
+}
+
+// runShell запускает административную консоль и возвращает код завершения.
+// При вводе с терминала доступны история (стрелки вверх/вниз) и дополнение команд
+// и логинов по Tab; из канала команды читаются построчно, что позволяет передавать сценарии.
+func runShell(um *UserManager) int {
+	scanner := bufio.NewScanner(os.Stdin)
+	stdinLines = scanner
+	if !requirePermission(um, scanner, "") {
+		fmt.Println(" Консоль доступна только пользователям с административными правами.")
+		return 1
+	}
+
+	sh := &adminShell{um: um}
+	fd := stdinFD()
+	if !term.IsTerminal(fd) {
+		sh.out = os.Stdout
+		sh.readLine = func() (string, error) {
+			if scanner.Scan() {
+				return scanner.Text(), nil
+			}
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		sh.readPassword = func(prompt string) (string, error) {
+			fmt.Print(prompt)
+			return sh.readLine()
+		}
+		return sh.run()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: не удалось перевести терминал в построчный режим: %v\n", err)
+		return 1
+	}
+	defer term.Restore(fd, state)
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "admin> ")
+	if width, height, err := term.GetSize(fd); err == nil && width > 0 {
+		terminal.SetSize(width, height)
+	}
+	terminal.AutoCompleteCallback = sh.complete
+
+	sh.out = terminal
+	sh.readLine = terminal.ReadLine
+	sh.readPassword = terminal.ReadPassword
+	return sh.run()
+}
+
+// run выполняет команды до exit или конца ввода
+func (sh *adminShell) run() int {
+	fmt.Fprintln(sh.out, "Административная консоль. help - список команд, Tab - дополнение, exit - выход.")
+	for {
+		line, err := sh.readLine()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(sh.out, "ошибка ввода: %v\n", err)
+			return 1
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name, args := fields[0], fields[1:]
+		switch name {
+		case "exit", "quit":
+			return 0
+		case "help":
+			sh.help()
+			continue
+		}
+
+		command, ok := shellCommands[name]
+		if !ok {
+			fmt.Fprintf(sh.out, "неизвестная команда: %s (help - список команд)\n", name)
+			continue
+		}
+		if !session.Can(command.Permission) {
+			fmt.Fprintf(sh.out, "ошибка: %v\n", sh.um.Authorize(session.Principal, command.Permission, "консоль: "+name))
+			continue
+		}
+		if command.Users && len(args) == 0 {
+			fmt.Fprintf(sh.out, "использование: %s %s\n", name, command.Args)
+			continue
+		}
+		if err := command.Run(sh, args); err != nil {
+			fmt.Fprintf(sh.out, "ошибка: %v\n", err)
+			continue
+		}
+		if command.Changes {
+			fmt.Fprintln(sh.out, "готово")
+		}
+	}
+}
+
+// help выводит список команд
+func (sh *adminShell) help() {
+	for _, name := range shellCommandNames() {
+		command := shellCommands[name]
+		fmt.Fprintf(sh.out, "  %-40s %s\n", strings.TrimSpace(name+" "+command.Args), command.Help)
+	}
+}
+
+// complete дополняет по Tab имя команды в первом слове и логин во втором.
+// Если вариантов несколько, строка дополняется до общего начала, а при повторном
+// нажатии варианты выводятся списком.
+func (sh *adminShell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head := line[:pos]
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+
+	var candidates []string
+	switch fields := strings.Fields(head[:start]); len(fields) {
+	case 0:
+		candidates = shellCommandNames()
+	case 1:
+		if shellCommands[fields[0]].Users {
+			candidates = sh.um.store.Usernames()
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+
+	completion := commonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+	} else if completion == word {
+		fmt.Fprintln(sh.out, strings.Join(matches, "  "))
+		return "", 0, false
+	}
+	return head[:start] + completion + line[pos:], start + len(completion), true
+}
+
+// shellCommandNames возвращает имена команд по алфавиту
+func shellCommandNames() []string {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commonPrefix возвращает общее начало строк
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
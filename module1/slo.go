@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Скользящая статистика входа: доля успешных входов и задержка проверки за последние
+// минуты и час. Резкое падение доли успешных входов после изменения конфигурации
+// (например, слишком строгой блокировки) видно в stats и в /v1/metrics.
+const (
+	defaultLoginObjective = 0.9 // Цель по доле успешных входов за окно
+	loginStatsRetention   = time.Hour
+	loginStatsLimit       = 100000 // Наибольшее число хранимых попыток входа
+)
+
+// loginStatsWindows - окна скользящей статистики
+var loginStatsWindows = []time.Duration{5 * time.Minute, time.Hour}
+
+// loginQuantiles - перцентили задержки входа
+var loginQuantiles = []float64{0.5, 0.95, 0.99}
+
+// loginAttempt - попытка входа в скользящем окне
+type loginAttempt struct {
+	at      time.Time
+	result  string
+	latency time.Duration
+}
+
+// LoginStats собирает результаты и задержку попыток входа с момента запуска
+type LoginStats struct {
+	mu        sync.Mutex
+	objective float64
+	attempts  []loginAttempt   // Попытки за loginStatsRetention, по времени
+	totals    map[string]int64 // Попытки с момента запуска по результату
+	latency   time.Duration    // Суммарная задержка с момента запуска
+}
+
+// LoginWindowStats - статистика входа за окно
+type LoginWindowStats struct {
+	Window       time.Duration
+	Attempts     int
+	Successes    int
+	SuccessRatio float64         // 1, если попыток не было
+	Failures     map[string]int  // Неудачные попытки по результату
+	Latency      []time.Duration // Задержка по перцентилям loginQuantiles
+	BelowTarget  bool            // Доля успешных входов ниже цели
+}
+
+// loginResultLabel возвращает код результата входа для статистики (как в API)
+func loginResultLabel(outcome AuthOutcome, err error) string {
+	if err != nil {
+		return "error"
+	}
+	return apiAuthResults[outcome.Result]
+}
+
+// record учитывает попытку входа
+func (s *LoginStats) record(result string, latency time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.totals == nil {
+		s.totals = make(map[string]int64)
+	}
+	s.totals[result]++
+	s.latency += latency
+	s.attempts = append(s.attempts, loginAttempt{at: now, result: result, latency: latency})
+	s.trim(now)
+}
+
+// trim отбрасывает попытки старше loginStatsRetention и сверх loginStatsLimit
+func (s *LoginStats) trim(now time.Time) {
+	keep := sort.Search(len(s.attempts), func(i int) bool {
+		return now.Sub(s.attempts[i].at) < loginStatsRetention
+	})
+	keep = max(keep, len(s.attempts)-loginStatsLimit)
+	if keep > 0 {
+		s.attempts = append(s.attempts[:0], s.attempts[keep:]...)
+	}
+}
+
+// SetObjective задает цель по доле успешных входов (0 - цель по умолчанию)
+func (s *LoginStats) SetObjective(objective float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objective = objective
+}
+
+// Objective возвращает цель по доле успешных входов
+func (s *LoginStats) Objective() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objective == 0 {
+		return defaultLoginObjective
+	}
+	return s.objective
+}
+
+// Window считает статистику за последние window
+func (s *LoginStats) Window(window time.Duration, now time.Time) LoginWindowStats {
+	objective := s.Objective()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := LoginWindowStats{Window: window, SuccessRatio: 1, Failures: make(map[string]int)}
+	var latencies []time.Duration
+	for _, attempt := range s.attempts {
+		if now.Sub(attempt.at) >= window {
+			continue
+		}
+		stats.Attempts++
+		latencies = append(latencies, attempt.latency)
+		if attempt.result == apiAuthResults[AuthSuccess] {
+			stats.Successes++
+		} else {
+			stats.Failures[attempt.result]++
+		}
+	}
+	if stats.Attempts > 0 {
+		stats.SuccessRatio = float64(stats.Successes) / float64(stats.Attempts)
+		stats.BelowTarget = stats.SuccessRatio < objective
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, quantile := range loginQuantiles {
+		stats.Latency = append(stats.Latency, latencyQuantile(latencies, quantile))
+	}
+	return stats
+}
+
+// Totals возвращает число попыток входа по результату и суммарную задержку с момента запуска
+func (s *LoginStats) Totals() (map[string]int64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[string]int64, len(s.totals))
+	for result, count := range s.totals {
+		totals[result] = count
+	}
+	return totals, s.latency
+}
+
+// latencyQuantile возвращает перцентиль отсортированных задержек по ближайшему рангу
+func latencyQuantile(sorted []time.Duration, quantile float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(quantile*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// LoginStats возвращает статистику входа менеджера пользователей
+func (um *UserManager) LoginStats() *LoginStats {
+	return &um.loginStats
+}
+
+// FormatLoginStats выводит статистику входа по окнам
+func FormatLoginStats(stats *LoginStats, now time.Time) string {
+	var out strings.Builder
+	objective := stats.Objective()
+	fmt.Fprintf(&out, "Цель по доле успешных входов: %.1f%%\n", objective*100)
+	table := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Окно\tПопыток\tУспешных\tДоля\tp50\tp95\tp99\tНеудачные\t")
+	for _, window := range loginStatsWindows {
+		w := stats.Window(window, now)
+		ratio := "-"
+		if w.Attempts > 0 {
+			ratio = fmt.Sprintf("%.1f%%", w.SuccessRatio*100)
+			if w.BelowTarget {
+				ratio += " НИЖЕ ЦЕЛИ"
+			}
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%s\t", formatLoginWindow(window), w.Attempts, w.Successes, ratio)
+		for _, latency := range w.Latency {
+			if w.Attempts == 0 {
+				fmt.Fprint(table, "-\t")
+				continue
+			}
+			fmt.Fprintf(table, "%s\t", latency.Round(time.Millisecond))
+		}
+		fmt.Fprintf(table, "%s\t\n", formatLoginFailures(w.Failures))
+	}
+	table.Flush()
+
+	totals, latency := stats.Totals()
+	var attempts int64
+	for _, count := range totals {
+		attempts += count
+	}
+	if attempts > 0 {
+		fmt.Fprintf(&out, "С момента запуска: %d попыток, успешных %d, средняя задержка %s\n",
+			attempts, totals[apiAuthResults[AuthSuccess]], (latency / time.Duration(attempts)).Round(time.Millisecond))
+	}
+	return out.String()
+}
+
+// formatLoginWindow выводит окно как "5 мин" или "1 ч"
+func formatLoginWindow(window time.Duration) string {
+	if window%time.Hour == 0 {
+		return fmt.Sprintf("%d ч", window/time.Hour)
+	}
+	return fmt.Sprintf("%d мин", window/time.Minute)
+}
+
+// formatLoginFailures выводит неудачные попытки по результату, начиная с частых
+func formatLoginFailures(failures map[string]int) string {
+	if len(failures) == 0 {
+		return "-"
+	}
+	results := make([]string, 0, len(failures))
+	for result := range failures {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if failures[results[i]] != failures[results[j]] {
+			return failures[results[i]] > failures[results[j]]
+		}
+		return results[i] < results[j]
+	})
+	parts := make([]string, len(results))
+	for i, result := range results {
+		parts[i] = fmt.Sprintf("%s=%d", result, failures[result])
+	}
+	return strings.Join(parts, ", ")
+}
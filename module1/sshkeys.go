@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Открытые ключи SSH пользователей. sshd получает действующие ключи через AuthorizedKeysCommand
+// (команда authorized-keys или GET /v1/users/<логин>/authorized-keys), поэтому отключение
+// учетной записи, ожидание одобрения, расписание входа и срок действия ключа действуют и для SSH.
+const minSSHRSABits = 2048
+
+// SSHKey - открытый ключ SSH учетной записи
+type SSHKey struct {
+	Type        string    // ssh-ed25519, ecdsa-sha2-nistp256, ssh-rsa, ...
+	Key         string    // Ключ в base64, как в authorized_keys
+	Comment     string    // Комментарий из строки ключа (обычно user@host)
+	Fingerprint string    // SHA256:... - как выводит ssh-keygen -l
+	AddedAt     time.Time // Когда ключ зарегистрирован
+	ExpiresAt   time.Time // Когда ключ перестает действовать (пусто - без срока)
+}
+
+// AuthorizedKeysLine возвращает строку ключа в формате authorized_keys
+func (k SSHKey) AuthorizedKeysLine() string {
+	return strings.TrimSpace(k.Type + " " + k.Key + " " + k.Comment)
+}
+
+// Expired сообщает, что срок действия ключа истек
+func (k SSHKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// ParseSSHKey разбирает строку открытого ключа (формат authorized_keys, без параметров).
+// DSA и RSA короче 2048 бит не принимаются.
+func ParseSSHKey(line string) (SSHKey, error) {
+	publicKey, comment, options, rest, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(line)))
+	if err != nil {
+		return SSHKey{}, fmt.Errorf("некорректный открытый ключ SSH: %v", err)
+	}
+	if len(options) > 0 || len(strings.TrimSpace(string(rest))) > 0 {
+		return SSHKey{}, fmt.Errorf("нужна одна строка ключа без параметров authorized_keys")
+	}
+	switch publicKey.Type() {
+	case ssh.KeyAlgoDSA:
+		return SSHKey{}, fmt.Errorf("ключи DSA не принимаются")
+	case ssh.KeyAlgoRSA:
+		if cryptoKey, ok := publicKey.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minSSHRSABits {
+				return SSHKey{}, fmt.Errorf("ключ RSA %d бит, нужно не меньше %d", rsaKey.N.BitLen(), minSSHRSABits)
+			}
+		}
+	}
+	fields := strings.Fields(string(ssh.MarshalAuthorizedKey(publicKey)))
+	return SSHKey{
+		Type:        fields[0],
+		Key:         fields[1],
+		Comment:     comment,
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+	}, nil
+}
+
+// AddSSHKey регистрирует открытый ключ учетной записи; expiresAt пусто - без срока.
+// Ключ может принадлежать только одной учетной записи.
+func (um *UserManager) AddSSHKey(username, line string, expiresAt time.Time, reason string) (SSHKey, error) {
+	username = strings.TrimSpace(username)
+	key, err := ParseSSHKey(line)
+	if err != nil {
+		return SSHKey{}, err
+	}
+	now := time.Now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return SSHKey{}, fmt.Errorf("срок действия ключа уже истек")
+	}
+	key.AddedAt, key.ExpiresAt = now, expiresAt
+
+	for _, user := range um.store.GetAllUsers() {
+		for _, existing := range user.SSHKeys {
+			if existing.Fingerprint != key.Fingerprint {
+				continue
+			}
+			if user.Username == username {
+				return SSHKey{}, fmt.Errorf("ключ %s уже зарегистрирован", key.Fingerprint)
+			}
+			return SSHKey{}, fmt.Errorf("ключ %s зарегистрирован у другой учетной записи", key.Fingerprint)
+		}
+	}
+
+	err = um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		user.SSHKeys = append(user.SSHKeys, key)
+		return nil
+	})
+	if err != nil {
+		return SSHKey{}, err
+	}
+	um.recordAudit(AuditSSHKeyAdded, username, strings.TrimSpace(describeSSHKey(key)+" "+reason))
+	return key, nil
+}
+
+// RemoveSSHKey удаляет ключ учетной записи по отпечатку
+func (um *UserManager) RemoveSSHKey(username, fingerprint, reason string) error {
+	username = strings.TrimSpace(username)
+	fingerprint = strings.TrimSpace(fingerprint)
+	var removed SSHKey
+	err := um.store.Update(username, func(user *User) error {
+		if user.IsHoneypot {
+			return fmt.Errorf("пользователь не найден")
+		}
+		for i, key := range user.SSHKeys {
+			if key.Fingerprint == fingerprint {
+				removed = key
+				user.SSHKeys = append(user.SSHKeys[:i:i], user.SSHKeys[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("у учетной записи нет ключа %s", fingerprint)
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditSSHKeyRemoved, username, strings.TrimSpace(describeSSHKey(removed)+" "+reason))
+	return nil
+}
+
+// SSHKeys возвращает зарегистрированные ключи учетной записи, включая просроченные
+func (um *UserManager) SSHKeys(username string) ([]SSHKey, error) {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	if !exists || user.IsHoneypot {
+		return nil, fmt.Errorf("пользователь не найден")
+	}
+	keys := append([]SSHKey(nil), user.SSHKeys...)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].AddedAt.Before(keys[j].AddedAt) })
+	return keys, nil
+}
+
+// AuthorizedKeys возвращает ключи, с которыми sshd может впустить пользователя сейчас: без
+// просроченных и пустой список, если учетная запись отключена, ожидает одобрения или вход
+// запрещен расписанием или правилом политики. Блокировка после неудачных попыток относится к паролю и ключи не
+// отключает. Неизвестный пользователь и ловушка - пустой список.
+func (um *UserManager) AuthorizedKeys(username string, now time.Time) []SSHKey {
+	user, exists := um.store.GetUser(strings.TrimSpace(username))
+	switch {
+	case !exists || user.IsHoneypot || user.DisabledByAdmin || user.PendingApproval:
+		return nil
+	case user.Schedule != nil && !user.Schedule.Allows(now):
+		return nil
+	}
+	if _, denied := um.matchPolicyRule(user, ruleActionDeny, now); denied {
+		return nil
+	}
+	var keys []SSHKey
+	for _, key := range user.SSHKeys {
+		if !key.Expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseSSHKeyExpiry разбирает срок действия ключа: период от now (90d, 12w), дата
+// (2025-12-31, ключ действует до начала этого дня) или "-" - без срока
+func parseSSHKeyExpiry(value string, now time.Time) (time.Time, error) {
+	if value == "-" {
+		return time.Time{}, nil
+	}
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	period, err := ParsePeriod(value)
+	if err != nil || period == 0 {
+		return time.Time{}, fmt.Errorf("некорректный срок действия %q: нужен период (90d), дата (2025-12-31) или -", value)
+	}
+	return now.Add(period), nil
+}
+
+// describeSSHKey - описание ключа для журнала аудита
+func describeSSHKey(key SSHKey) string {
+	description := key.Type + " " + key.Fingerprint
+	if key.Comment != "" {
+		description += " (" + key.Comment + ")"
+	}
+	if !key.ExpiresAt.IsZero() {
+		description += ", действует до " + key.ExpiresAt.Format("2006-01-02 15:04")
+	}
+	return description
+}
+
+// APISSHKey - открытый ключ SSH в API
+type APISSHKey struct {
+	PublicKey   string     `json:"public_key"` // Строка ключа в формате authorized_keys
+	Fingerprint string     `json:"fingerprint"`
+	Comment     string     `json:"comment,omitempty"`
+	AddedAt     time.Time  `json:"added_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Expired     bool       `json:"expired"`
+}
+
+// APISSHKeyRequest - тело POST /users/<логин>/ssh-keys
+type APISSHKeyRequest struct {
+	PublicKey string     `json:"public_key"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Без срока, если не задан
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// newAPISSHKey возвращает представление ключа для API
+func newAPISSHKey(key SSHKey, now time.Time) APISSHKey {
+	return APISSHKey{
+		PublicKey:   key.AuthorizedKeysLine(),
+		Fingerprint: key.Fingerprint,
+		Comment:     key.Comment,
+		AddedAt:     key.AddedAt,
+		ExpiresAt:   optionalTime(key.ExpiresAt),
+		Expired:     key.Expired(now),
+	}
+}
+
+// handleSSHKeys: GET - ключи учетной записи, POST - регистрация ключа,
+// DELETE ?fingerprint=SHA256:... - удаление ключа
+func (s *APIServer) handleSSHKeys(w http.ResponseWriter, r *http.Request, username string) {
+	now := time.Now()
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.um.SSHKeys(username)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, err.Error())
+			return
+		}
+		response := []APISSHKey{}
+		for _, key := range keys {
+			response = append(response, newAPISSHKey(key, now))
+		}
+		writeAPIJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		var request APISSHKeyRequest
+		if !readAPIJSON(w, r, &request) {
+			return
+		}
+		if !s.um.store.UserExists(username) {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+			return
+		}
+		var expiresAt time.Time
+		if request.ExpiresAt != nil {
+			expiresAt = *request.ExpiresAt
+		}
+		key, err := s.um.AddSSHKey(username, request.PublicKey, expiresAt, request.Reason)
+		if err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, CodeUserRejected, err.Error())
+			return
+		}
+		writeAPIJSON(w, http.StatusCreated, newAPISSHKey(key, now))
+	case http.MethodDelete:
+		// "+" из base64 в неэкранированном адресе приходит пробелом, а пробелов в отпечатке нет
+		fingerprint := strings.ReplaceAll(r.URL.Query().Get("fingerprint"), " ", "+")
+		if fingerprint == "" {
+			writeAPIError(w, http.StatusBadRequest, CodeInvalidRequest, "не указан отпечаток ключа (fingerprint)")
+			return
+		}
+		if !s.um.store.UserExists(username) {
+			writeAPIError(w, http.StatusNotFound, CodeUserNotFound, "пользователь не найден")
+			return
+		}
+		if err := s.um.RemoveSSHKey(username, fingerprint, r.URL.Query().Get("reason")); err != nil {
+			writeAPIError(w, http.StatusNotFound, CodeUnknownResource, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, "GET, POST, DELETE")
+	}
+}
+
+// handleAuthorizedKeys: GET /users/<логин>/authorized-keys - действующие ключи в формате
+// authorized_keys для AuthorizedKeysCommand. Если войти нельзя, ответ пустой.
+func (s *APIServer) handleAuthorizedKeys(w http.ResponseWriter, r *http.Request, username string) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	for _, key := range s.um.AuthorizedKeys(username, time.Now()) {
+		fmt.Fprintln(w, key.AuthorizedKeysLine())
+	}
+}
+
+// FetchAuthorizedKeys запрашивает у API действующие ключи пользователя (команда authorized-keys)
+func FetchAuthorizedKeys(addr, certPath, token, username string, out io.Writer) error {
+	api, err := newLocalAPI(addr, certPath, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodGet, api.baseURL+apiPrefix+"/users/"+url.PathEscape(username)+"/authorized-keys", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("API ответил %s", response.Status)
+	}
+	_, err = io.Copy(out, response.Body)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// storageCipherPrefix начинает зашифрованные данные хранилища: "uas-enc:<ключ>:" и затем
+// nonce и шифротекст AES-256-GCM. Данные без префикса читаются как есть, поэтому шифрование
+// можно включить для уже работающего кластера.
+const storageCipherPrefix = "uas-enc:"
+
+// SealStorage шифрует данные хранилища текущим ключом (без ключей данные не меняются)
+func SealStorage(keys *KeySet, plaintext []byte) ([]byte, error) {
+	keyID := keys.Current()
+	if keyID == "" {
+		return plaintext, nil
+	}
+	aead, err := storageAEAD(keys.key(keyID))
+	if err != nil {
+		return nil, err
+	}
+	header := []byte(storageCipherPrefix + keyID + ":")
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("ошибка генерации nonce: %v", err)
+	}
+	sealed := append(append(header, nonce...), aead.Seal(nil, nonce, plaintext, header)...)
+	return sealed, nil
+}
+
+// OpenStorage расшифровывает данные хранилища ключом, указанным в данных
+func OpenStorage(keys *KeySet, data []byte) ([]byte, error) {
+	rest, found := bytes.CutPrefix(data, []byte(storageCipherPrefix))
+	if !found {
+		return data, nil
+	}
+	keyID, sealed, found := bytes.Cut(rest, []byte(":"))
+	if !found {
+		return nil, fmt.Errorf("некорректный заголовок зашифрованных данных")
+	}
+	key := keys.key(string(keyID))
+	if key == nil {
+		return nil, fmt.Errorf("нет ключа шифрования хранилища %s", keyID)
+	}
+	aead, err := storageAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("зашифрованные данные повреждены")
+	}
+	header := data[:len(data)-len(sealed)]
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("зашифрованные данные повреждены или ключ %s неверен", keyID)
+	}
+	return plaintext, nil
+}
+
+// storageAEAD создает шифр AES-256-GCM
+func storageAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
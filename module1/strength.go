@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// AttackerProfile - модель атакующего со скоростью перебора
+type AttackerProfile struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	GuessesPerSecond float64 `json:"guesses_per_second"`
+	Description      string  `json:"description,omitempty"`
+}
+
+// attackerProfilesData - типовые профили атакующего. Файл ведется в модуле 2 и общий
+// для анализа стойкости и оценки стойкости паролей; здесь - его копия.
+//
+//go:generate cp ../module2/attacker_profiles.json attacker_profiles.json
+//go:embed attacker_profiles.json
+var attackerProfilesData []byte
+
+// AttackerProfiles - профили атакующего для оценки стойкости: типовые и добавленные
+// файлом -attacker-profiles
+var AttackerProfiles = mustParseAttackerProfiles(attackerProfilesData)
+
+// mustParseAttackerProfiles разбирает встроенный файл профилей
+func mustParseAttackerProfiles(data []byte) []AttackerProfile {
+	profiles, err := ParseAttackerProfiles(data)
+	if err != nil {
+		panic("attacker_profiles.json: " + err.Error())
+	}
+	return profiles
+}
+
+// ParseAttackerProfiles разбирает файл профилей атакующего в формате attacker_profiles.json
+func ParseAttackerProfiles(data []byte) ([]AttackerProfile, error) {
+	var profiles []AttackerProfile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("некорректный формат профилей атакующего: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, profile := range profiles {
+		switch {
+		case profile.ID == "" || strings.ContainsAny(profile.ID, " ,"):
+			return nil, fmt.Errorf("профиль %d: id должен быть непустым и без пробелов и запятых", i+1)
+		case seen[profile.ID]:
+			return nil, fmt.Errorf("профиль %s задан повторно", profile.ID)
+		case profile.Name == "":
+			return nil, fmt.Errorf("профиль %s: не задано название", profile.ID)
+		case !(profile.GuessesPerSecond > 0) || math.IsInf(profile.GuessesPerSecond, 0):
+			return nil, fmt.Errorf("профиль %s: скорость guesses_per_second должна быть положительной", profile.ID)
+		}
+		seen[profile.ID] = true
+	}
+	return profiles, nil
+}
+
+// LoadAttackerProfiles добавляет профили из файла к типовым: профиль с тем же id
+// заменяет типовой, остальные добавляются в конец
+func LoadAttackerProfiles(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения профилей атакующего: %v", err)
+	}
+	custom, err := ParseAttackerProfiles(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	merged := append([]AttackerProfile(nil), AttackerProfiles...)
+	for _, profile := range custom {
+		replaced := false
+		for i := range merged {
+			if merged[i].ID == profile.ID {
+				merged[i], replaced = profile, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, profile)
+		}
+	}
+	AttackerProfiles = merged
+	return nil
+}
+
+// CrackEstimate - оценка времени подбора пароля для одного профиля
+type CrackEstimate struct {
+	Profile AttackerProfile
+	Seconds float64 // Среднее время подбора (половина числа попыток)
+}
+
+// PasswordStrength - оценка стойкости пароля с учетом предсказуемых фрагментов
+type PasswordStrength struct {
+	AlphabetSize int
+	Combinations float64        // Размер пространства полного перебора A^L
+	Guesses      float64        // Число попыток с учетом шаблонов (не больше A^L)
+	Score        int            // Оценка 0-4 по числу попыток
+	Patterns     []PatternMatch // Фрагменты, использованные в оценке
+	Estimates    []CrackEstimate
+}
+
+// strengthLabels - названия оценок стойкости
+var strengthLabels = []string{"очень слабый", "слабый", "средний", "надежный", "очень надежный"}
+
+// passwordAlphabetSize оценивает мощность алфавита по классам символов пароля
+func passwordAlphabetSize(password string) int {
+	var hasUpper, hasLower, hasDigit, hasSpecial, hasOther bool
+	for _, char := range password {
+		switch {
+		case char >= 'A' && char <= 'Z':
+			hasUpper = true
+		case char >= 'a' && char <= 'z':
+			hasLower = true
+		case char >= '0' && char <= '9':
+			hasDigit = true
+		case char < unicode.MaxASCII && unicode.IsPrint(char):
+			hasSpecial = true
+		default:
+			hasOther = true
+		}
+	}
+
+	size := 0
+	if hasUpper {
+		size += 26
+	}
+	if hasLower {
+		size += 26
+	}
+	if hasDigit {
+		size += 10
+	}
+	if hasSpecial {
+		size += 33
+	}
+	if hasOther {
+		size += 66 // Кириллица в обоих регистрах
+	}
+	return size
+}
+
+// AnalyzeStrength оценивает стойкость пароля: словарные слова, даты, повторы,
+// последовательности и клавиатурные ряды уменьшают число попыток перебора
+func AnalyzeStrength(password string) PasswordStrength {
+	alphabet := passwordAlphabetSize(password)
+	combinations := math.Pow(float64(alphabet), float64(len([]rune(password))))
+	guesses, patterns := estimateGuesses(password, FindPatterns(password))
+	if guesses > combinations {
+		guesses = combinations
+	}
+
+	strength := PasswordStrength{
+		AlphabetSize: alphabet,
+		Combinations: combinations,
+		Guesses:      guesses,
+		Score:        strengthScore(guesses),
+		Patterns:     patterns,
+	}
+	for _, profile := range AttackerProfiles {
+		strength.Estimates = append(strength.Estimates, CrackEstimate{
+			Profile: profile,
+			Seconds: guesses / 2 / profile.GuessesPerSecond,
+		})
+	}
+	return strength
+}
+
+// strengthScore переводит число попыток в оценку 0-4
+func strengthScore(guesses float64) int {
+	switch {
+	case guesses < 1e3:
+		return 0
+	case guesses < 1e6:
+		return 1
+	case guesses < 1e8:
+		return 2
+	case guesses < 1e10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// FormatCrackTime переводит секунды в удобочитаемую длительность
+func FormatCrackTime(seconds float64) string {
+	switch {
+	case seconds < 1:
+		return "мгновенно"
+	case seconds < 60:
+		return fmt.Sprintf("%.0f сек", seconds)
+	case seconds < 3600:
+		return fmt.Sprintf("%.0f мин", seconds/60)
+	case seconds < 24*3600:
+		return fmt.Sprintf("%.1f ч", seconds/3600)
+	case seconds < 365*24*3600:
+		return fmt.Sprintf("%.0f дн", seconds/(24*3600))
+	case seconds < 1e6*365*24*3600:
+		return fmt.Sprintf("%.0f лет", seconds/(365*24*3600))
+	default:
+		return fmt.Sprintf("%.2e лет", seconds/(365*24*3600))
+	}
+}
+
+// Format возвращает оценку в виде текстового блока для индикатора стойкости
+func (s PasswordStrength) Format() string {
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf(" Стойкость пароля: %s (%d/4)\n", strengthLabels[s.Score], s.Score))
+	out.WriteString(fmt.Sprintf("   Полный перебор: A = %d, S = %.2e; с учетом шаблонов: %.2e попыток\n", s.AlphabetSize, s.Combinations, s.Guesses))
+	if len(s.Patterns) > 0 {
+		out.WriteString(fmt.Sprintf("   Найдены шаблоны: %s\n", describePatterns(s.Patterns)))
+	}
+	out.WriteString(" Оценка времени подбора:\n")
+	for _, estimate := range s.Estimates {
+		out.WriteString(fmt.Sprintf("   "+theme.Bullet+" %-40s %s\n", estimate.Profile.Name+":", FormatCrackTime(estimate.Seconds)))
+	}
+	return out.String()
+}
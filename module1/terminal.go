@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"golang.org/x/term"
+)
+
+// stdinLines - построчное чтение стандартного ввода, общее для меню и паролей: сканер
+// читает ввод с опережением, и отдельный сканер для пароля терял бы строки, переданные через канал
+var stdinLines *bufio.Scanner
+
+// readPassword безопасно читает пароль без отображения символов на экране.
+// Если стандартный ввод не является терминалом, строка читается как есть.
+// Режим терминала восстанавливается и при панике, и при прерывании ввода (Ctrl+C):
+// в последнем случае программа завершается, как при обычном прерывании.
+func readPassword() (string, error) {
+	fd := stdinFD()
+	if !term.IsTerminal(fd) {
+		if hint := pipedTerminalHint(); hint != "" {
+			fmt.Fprintf(os.Stderr, "\n ВНИМАНИЕ: %s\n", hint)
+		}
+		if stdinLines == nil {
+			stdinLines = bufio.NewScanner(os.Stdin)
+		}
+		if stdinLines.Scan() {
+			return stdinLines.Text(), nil
+		}
+		return "", stdinLines.Err()
+	}
+
+	state, err := term.GetState(fd)
+	if err != nil {
+		return "", fmt.Errorf("не удалось получить режим терминала: %v", err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			term.Restore(fd, state)
+			panic(r)
+		}
+	}()
+
+	stop := restoreOnInterrupt(fd, state)
+	bytePassword, err := term.ReadPassword(fd)
+	stop()
+	if err != nil {
+		return "", err
+	}
+	fmt.Println()
+
+	// Пароль, введенный на заблокированном по бездействию экране, не используется
+	if sessionLock.WaitUnlock() {
+		fmt.Print("Сеанс разблокирован, повторите ввод пароля: ")
+		return readPassword()
+	}
+	sessionLock.Touch()
+
+	return string(bytePassword), nil
+}
+
+// stdinIsTerminal сообщает, подключен ли стандартный ввод к терминалу
+func stdinIsTerminal() bool {
+	return term.IsTerminal(stdinFD())
+}
+
+// stdoutIsTerminal сообщает, выводится ли программа в терминал
+func stdoutIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// restoreOnInterrupt до вызова возвращаемой функции перехватывает сигналы прерывания:
+// режим терминала восстанавливается, и программа завершается с кодом 130
+func restoreOnInterrupt(fd int, state *term.State) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, interruptSignals...)
+
+	go func() {
+		select {
+		case <-signals:
+			term.Restore(fd, state)
+			fmt.Println("\nВвод прерван.")
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// interruptSignals - сигналы, прерывающие ввод пароля
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT}
+
+// stdinFD возвращает дескриптор стандартного ввода
+func stdinFD() int {
+	return int(syscall.Stdin)
+}
+
+// pipedTerminalHint возвращает предупреждение для ввода пароля не из терминала.
+// В Unix это перенаправленный ввод, и предупреждать не о чем.
+func pipedTerminalHint() string {
+	return ""
+}
+
+// clearScreen очищает экран терминала вместе с буфером прокрутки
+func clearScreen() {
+	fmt.Print("\033[H\033[2J\033[3J")
+}
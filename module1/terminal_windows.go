@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// interruptSignals - сигналы, прерывающие ввод пароля. Ctrl+C и Ctrl+Break
+// консоли Windows доставляются как os.Interrupt.
+var interruptSignals = []os.Signal{os.Interrupt}
+
+// stdinFD возвращает описатель консоли стандартного ввода. syscall.Stdin в Windows -
+// описатель, полученный при запуске; os.Stdin.Fd() всегда соответствует текущему вводу.
+func stdinFD() int {
+	return int(os.Stdin.Fd())
+}
+
+// pipedTerminalHint возвращает предупреждение для ввода пароля не из консоли.
+// Терминалы MSYS2/Git Bash (mintty) подключают программу через канал, а не консоль,
+// поэтому скрыть вводимый пароль невозможно.
+func pipedTerminalHint() string {
+	if os.Getenv("MSYSTEM") != "" && os.Getenv("TERM") != "" {
+		return "терминал mintty не поддерживает скрытый ввод, пароль будет виден; запустите программу через winpty или в консоли Windows"
+	}
+	return ""
+}
+
+// clearScreen очищает окно консоли. Классическая консоль Windows не обрабатывает
+// управляющие последовательности ANSI, поэтому используется команда cls.
+func clearScreen() {
+	cmd := exec.Command("cmd", "/c", "cls")
+	cmd.Stdout = os.Stdout
+	cmd.Run()
+}
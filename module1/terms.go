@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TermsDocument - действующая редакция условий использования
+type TermsDocument struct {
+	Version string // Версия документа (пусто - принятие условий не требуется)
+	Text    string // Текст, показываемый пользователю перед принятием
+}
+
+// termsConfig - раздел terms файла политики
+type termsConfig struct {
+	Version string `json:"version"` // Версия документа, ее смена требует повторного принятия
+	File    string `json:"file"`    // Файл с текстом условий
+}
+
+// loadTerms читает текст условий использования из раздела конфигурации
+func loadTerms(config termsConfig) (TermsDocument, error) {
+	version := strings.TrimSpace(config.Version)
+	if version == "" {
+		return TermsDocument{}, nil
+	}
+
+	terms := TermsDocument{Version: version}
+	if config.File != "" {
+		text, err := os.ReadFile(config.File)
+		if err != nil {
+			return TermsDocument{}, fmt.Errorf("terms.file: %v", err)
+		}
+		terms.Text = strings.TrimSpace(string(text))
+	}
+	return terms, nil
+}
+
+// Terms возвращает действующую редакцию условий использования
+func (um *UserManager) Terms() TermsDocument {
+	return um.terms
+}
+
+// termsAccepted проверяет, принял ли пользователь действующую редакцию условий
+func (um *UserManager) termsAccepted(user *User) bool {
+	return um.terms.Version == "" || user.AcceptedTermsVersion == um.terms.Version
+}
+
+// AuthenticateAcceptingTerms выполняет вход с принятием условий использования версии version.
+// Принятие записывается, только если пароль верен и version совпадает с действующей редакцией.
+func (um *UserManager) AuthenticateAcceptingTerms(username, password, version string) (AuthOutcome, error) {
+	return um.authenticate(username, password, version)
+}
+
+// recordTermsAcceptance сохраняет принятие действующей редакции условий
+func (um *UserManager) recordTermsAcceptance(username string, now time.Time) error {
+	err := um.store.Update(username, func(user *User) error {
+		user.AcceptedTermsVersion = um.terms.Version
+		user.TermsAcceptedAt = now
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	um.recordAudit(AuditTermsAccepted, username, fmt.Sprintf("версия %s, %s", um.terms.Version, now.Format(time.RFC3339)))
+	return nil
+}
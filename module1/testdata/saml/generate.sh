@@ -0,0 +1,58 @@
+#!/bin/sh
+# Создает ответ IdP с подписанным утверждением для saml_test.go. Канонизацию выполняет
+# libxml2 (xmllint --exc-c14n), подпись - openssl, поэтому тест сверяет xmldsig.go с
+# независимой реализацией. Закрытый ключ IdP удаляется после подписи.
+set -eu
+cd "$(dirname "$0")"
+work=$(mktemp -d)
+trap 'rm -rf "$work"' EXIT
+
+openssl req -x509 -newkey rsa:2048 -nodes -sha256 -days 36500 -subj /CN=idp.example.com \
+	-keyout "$work/idp.key" -out idp.crt 2>/dev/null
+
+SAML=urn:oasis:names:tc:SAML:2.0:assertion
+DS=http://www.w3.org/2000/09/xmldsig#
+
+# Утверждение без подписи; @NS@ - объявление пространства имен saml, @SIGNATURE@ - место подписи
+cat > "$work/assertion.tmpl" <<'XML'
+<saml:Assertion@NS@ ID="_assertion1" IssueInstant="2025-03-01T12:00:00Z" Version="2.0">
+    <saml:Issuer>https://idp.example.com</saml:Issuer>@SIGNATURE@
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified">alice@example.com</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData NotOnOrAfter="2025-03-01T12:05:00Z" Recipient="https://uas.example.com/v1/saml/acs"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="2025-03-01T11:59:00Z" NotOnOrAfter="2025-03-01T12:05:00Z">
+      <saml:AudienceRestriction>
+        <saml:Audience>https://uas.example.com</saml:Audience>
+      </saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="uid">
+        <saml:AttributeValue xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="xs:string">alice</saml:AttributeValue>
+      </saml:Attribute>
+      <saml:Attribute Name="department">
+        <saml:AttributeValue>R&amp;D "Lab"</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+XML
+
+# Хеш: утверждение без подписи (преобразование enveloped-signature), объявления - на нем самом
+sed -e "s|@NS@| xmlns:saml=\"$SAML\"|" -e 's|@SIGNATURE@||' "$work/assertion.tmpl" > "$work/digest.xml"
+digest=$(xmllint --exc-c14n "$work/digest.xml" | openssl dgst -sha256 -binary | openssl base64 -A)
+
+signed_info="<ds:SignedInfo@DSNS@><ds:CanonicalizationMethod Algorithm=\"http://www.w3.org/2001/10/xml-exc-c14n#\"/><ds:SignatureMethod Algorithm=\"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256\"/><ds:Reference URI=\"#_assertion1\"><ds:Transforms><ds:Transform Algorithm=\"http://www.w3.org/2000/09/xmldsig#enveloped-signature\"/><ds:Transform Algorithm=\"http://www.w3.org/2001/10/xml-exc-c14n#\"/></ds:Transforms><ds:DigestMethod Algorithm=\"http://www.w3.org/2001/04/xmlenc#sha256\"/><ds:DigestValue>$digest</ds:DigestValue></ds:Reference></ds:SignedInfo>"
+echo "$signed_info" | sed "s|@DSNS@| xmlns:ds=\"$DS\"|" > "$work/signedinfo.xml"
+value=$(xmllint --exc-c14n "$work/signedinfo.xml" | openssl dgst -sha256 -sign "$work/idp.key" | openssl base64 -A)
+
+# В ответе пространства имен объявлены на корне: канонизация должна перенести их на подписанные элементы
+signature="<ds:Signature xmlns:ds=\"$DS\">$(echo "$signed_info" | sed 's|@DSNS@||')<ds:SignatureValue>$value</ds:SignatureValue></ds:Signature>"
+{
+	printf '<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="%s" ID="_response1" Version="2.0" IssueInstant="2025-03-01T12:00:00Z" Destination="https://uas.example.com/v1/saml/acs">\n' "$SAML"
+	printf '  <saml:Issuer>https://idp.example.com</saml:Issuer>\n'
+	printf '  <samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>\n  '
+	sed -e 's|@NS@||' -e "s|@SIGNATURE@|$signature|" "$work/assertion.tmpl"
+	printf '</samlp:Response>\n'
+} > response.xml
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// Theme задает символы оформления консольного вывода. Все символы рамки и маркер
+// списка занимают ровно одну позицию, поэтому таблицы выравниваются одинаково во всех темах.
+type Theme struct {
+	Name    string
+	Border  bool   // Рисовать рамку вокруг меню и уведомлений
+	Lines   string // Символы рамки: горизонталь, вертикаль, углы ┌ ┐ └ ┘ и соединения ├ ┤
+	Bullet  string // Маркер элемента списка
+	Success string // Префикс сообщения об успешном действии
+	Hint    string // Префикс рекомендаций
+}
+
+// Встроенные темы: unicode - псевдографика и эмодзи, ascii - только символы ASCII,
+// plain - без рамок и значков (для dumb-терминалов и перенаправления в файл)
+var themes = map[string]Theme{
+	"unicode": {Name: "unicode", Border: true, Lines: "─│┌┐└┘├┤", Bullet: "•", Success: "✅ ", Hint: "💡 "},
+	"ascii":   {Name: "ascii", Border: true, Lines: "-|++++++", Bullet: "*", Success: "[OK] ", Hint: "[i] "},
+	"plain":   {Name: "plain", Border: false, Lines: "        ", Bullet: "-", Success: "", Hint: ""},
+}
+
+// boxWidth - ширина содержимого рамки в символах
+const boxWidth = 39
+
+// theme - текущая тема вывода
+var theme = themes["unicode"]
+
+// SetTheme задает тему консольного вывода
+func SetTheme(t Theme) {
+	theme = t
+}
+
+// ParseTheme возвращает тему по имени (auto - определить по терминалу)
+func ParseTheme(name string) (Theme, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "auto" {
+		return DetectTheme(), nil
+	}
+	t, ok := themes[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("неизвестная тема оформления: %s (доступно: auto, unicode, ascii, plain)", name)
+	}
+	return t, nil
+}
+
+// DetectTheme выбирает тему по окружению: plain для TERM=dumb, ascii для кодировки
+// локали, отличной от UTF-8, и для классической консоли Windows, иначе unicode
+func DetectTheme() Theme {
+	if os.Getenv("TERM") == "dumb" {
+		return themes["plain"]
+	}
+	// Windows Terminal выставляет WT_SESSION; в conhost шрифты и кодовые страницы
+	// часто не содержат псевдографики и эмодзи
+	if runtime.GOOS == "windows" && os.Getenv("WT_SESSION") == "" {
+		return themes["ascii"]
+	}
+	// Первая заданная переменная локали определяет кодировку, как в setlocale
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		value := strings.ToLower(os.Getenv(name))
+		if value == "" {
+			continue
+		}
+		if strings.Contains(value, "utf-8") || strings.Contains(value, "utf8") {
+			return themes["unicode"]
+		}
+		return themes["ascii"]
+	}
+	return themes["unicode"]
+}
+
+// line возвращает символ рамки с номером i в порядке поля Lines
+func (t Theme) line(i int) string {
+	return string([]rune(t.Lines)[i])
+}
+
+// printBox выводит заголовок (может быть пустым) и строки в рамке текущей темы.
+// Строки длиннее boxWidth выводятся как есть и сдвигают правую границу.
+func printBox(title string, lines ...string) {
+	if !theme.Border {
+		if title != "" {
+			fmt.Println(title)
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	horizontal := strings.Repeat(theme.line(0), boxWidth+2)
+	vertical := theme.line(1)
+	fmt.Println(theme.line(2) + horizontal + theme.line(3))
+	if title != "" {
+		left := max((boxWidth+2-utf8.RuneCountInString(title))/2, 0)
+		fmt.Printf("%s%*s%-*s%s\n", vertical, left, "", boxWidth+2-left, title, vertical)
+		fmt.Println(theme.line(6) + horizontal + theme.line(7))
+	}
+	for _, line := range lines {
+		fmt.Printf("%s %-*s %s\n", vertical, boxWidth, line, vertical)
+	}
+	fmt.Println(theme.line(4) + horizontal + theme.line(5))
+}
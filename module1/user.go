@@ -1,23 +1,150 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
 // User представляет структуру пользователя в системе
 type User struct {
-	Username        string    // Логин пользователя
-	HashedPassword  string    // Хеш пароля с использованием bcrypt
-	FailedAttempts  int       // Счетчик неудачных попыток входа
-	IsBlocked       bool      // Статус блокировки пользователя
-	CreatedAt       time.Time // Время создания аккаунта
-	LastLoginAt     time.Time // Время последнего входа
-	BlockedAt       time.Time // Время блокировки (если заблокирован)
+	Username             string            // Логин пользователя
+	Email                string            // Адрес электронной почты (из приглашения, может быть пустым)
+	HashedPassword       string            // Хеш пароля с использованием bcrypt
+	LegacyHash           string            // Хеш из унаследованной системы ("md5:<hex>"), заменяется bcrypt при первом входе
+	FailedAttempts       int               // Счетчик неудачных попыток входа
+	FailedAt             []time.Time       // Время неудачных попыток в окне подсчета
+	IsBlocked            bool              // Статус блокировки пользователя (вход по паролю запрещен)
+	DisabledByAdmin      bool              // Учетная запись отключена администратором: восстановление сменой пароля запрещено
+	CreatedAt            time.Time         // Время создания аккаунта
+	LastLoginAt          time.Time         // Время последнего входа
+	BlockedAt            time.Time         // Время блокировки (если заблокирован)
+	LockExpiresAt        time.Time         // Когда снимается блокировка после неудачных попыток (пусто - до разблокировки)
+	DormantSince         time.Time         // С какого момента учетная запись считается неактивной
+	DormancyWarnedAt     time.Time         // Когда пользователь предупрежден о скорой неактивности
+	Schedule             *LoginSchedule    // Ограничение времени входа (nil - без ограничений)
+	PasswordChangedAt    time.Time         // Когда пароль задан или последний раз изменен
+	TokensRevokedAt      time.Time         // Токены доступа, выданные раньше, не действуют (переименование, объединение, восстановление)
+	RotationDue          time.Time         // Срок принудительной смены пароля (пусто - не назначена)
+	RotationReason       string            // Причина принудительной смены пароля
+	IsHoneypot           bool              // Учетная запись-ловушка: вход невозможен, попытки поднимают тревогу
+	DuressHash           string            // Хеш пароля под принуждением (пусто - не задан)
+	UnderDuress          bool              // Последний вход выполнен паролем под принуждением
+	PendingApproval      bool              // Регистрация ожидает одобрения администратором
+	AcceptedTermsVersion string            // Принятая редакция условий использования
+	TermsAcceptedAt      time.Time         // Когда условия приняты
+	IsAdmin              bool              // Администратор: доступны административные пункты меню
+	Role                 string            // Роль из конфигурации политики с набором прав (пусто - user)
+	Groups               []string          // Группы, в которых состоит пользователь, по порядку
+	Attributes           map[string]string // Атрибуты для правил групп (department, location...), задает администратор
+	LoginCount           int               // Число успешных входов (для шагов профиля)
+	ProfileSnoozes       map[string]int    // Сколько раз отложен каждый шаг профиля
+	SAMLNameID           string            // Удостоверение у корпоративного IdP (NameID), с которым связана запись
+	SSHKeys              []SSHKey          // Открытые ключи SSH для входа через sshd (AuthorizedKeysCommand)
+	CertBindings         []CertBinding     // Сертификаты клиента TLS, по которым учетная запись входит в API
+	KnownDevices         []KnownDevice     // Устройства, с которых выполнялся вход через API (devices.go)
 }
 
-// UserStore представляет хранилище пользователей (в памяти)
+// clone возвращает глубокую копию пользователя
+func (u *User) clone() *User {
+	copied := *u
+	copied.FailedAt = append([]time.Time(nil), u.FailedAt...)
+	copied.SSHKeys = append([]SSHKey(nil), u.SSHKeys...)
+	copied.CertBindings = append([]CertBinding(nil), u.CertBindings...)
+	copied.KnownDevices = append([]KnownDevice(nil), u.KnownDevices...)
+	copied.Groups = append([]string(nil), u.Groups...)
+	if u.ProfileSnoozes != nil {
+		copied.ProfileSnoozes = make(map[string]int, len(u.ProfileSnoozes))
+		for step, count := range u.ProfileSnoozes {
+			copied.ProfileSnoozes[step] = count
+		}
+	}
+	if u.Attributes != nil {
+		copied.Attributes = make(map[string]string, len(u.Attributes))
+		for key, value := range u.Attributes {
+			copied.Attributes[key] = value
+		}
+	}
+	if u.Schedule != nil {
+		schedule := *u.Schedule
+		schedule.Weekdays = append([]time.Weekday(nil), u.Schedule.Weekdays...)
+		copied.Schedule = &schedule
+	}
+	return &copied
+}
+
+// UserStore представляет хранилище пользователей (в памяти).
+// Записи выдаются и принимаются копиями: изменить хранимого пользователя можно только через SaveUser или Update.
 type UserStore struct {
-	users map[string]*User // map[username]*User
+	mu       sync.RWMutex
+	users    map[string]*User  // map[username]*User
+	onChange func(StoreChange) // Вызывается под блокировкой после каждого изменения (nil - не вызывается)
+}
+
+// StoreChange - изменение одной записи хранилища
+type StoreChange struct {
+	Username string // Логин измененной записи
+	User     *User  // Новое состояние записи (nil - запись удалена)
+}
+
+// SetChangeHandler задает функцию, получающую изменения хранилища в порядке их внесения.
+// Функция вызывается под блокировкой хранилища и не должна обращаться к нему или блокироваться.
+func (s *UserStore) SetChangeHandler(fn func(StoreChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
+
+// changed сообщает об изменении записи; вызывается под блокировкой хранилища
+func (s *UserStore) changed(username string) {
+	if s.onChange == nil {
+		return
+	}
+	change := StoreChange{Username: username}
+	if user, exists := s.users[username]; exists {
+		change.User = user.clone()
+	}
+	s.onChange(change)
+}
+
+// Snapshot передает функции копии всех записей. Пока функция выполняется, хранилище
+// не меняется, поэтому вместе со снимком можно начать получать изменения без пропусков.
+func (s *UserStore) Snapshot(fn func(users []*User)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user.clone())
+	}
+	fn(users)
+}
+
+// Replace заменяет содержимое хранилища копиями переданных записей
+func (s *UserStore) Replace(users []*User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = make(map[string]*User, len(users))
+	for _, user := range users {
+		s.users[user.Username] = user.clone()
+	}
+}
+
+// ApplyChanges вносит изменения, полученные от другого узла, одним действием.
+// Обработчик изменений не вызывается: изменения уже записаны в журнал.
+func (s *UserStore) ApplyChanges(changes []StoreChange) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, change := range changes {
+		if change.User == nil {
+			delete(s.users, change.Username)
+		} else {
+			s.users[change.Username] = change.User.clone()
+		}
+	}
 }
 
 // NewUserStore создает новое хранилище пользователей
@@ -27,24 +154,148 @@ func NewUserStore() *UserStore {
 	}
 }
 
-// GetUser возвращает пользователя по логину
+// GetUser возвращает копию пользователя по логину
 func (s *UserStore) GetUser(username string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	user, exists := s.users[username]
-	return user, exists
+	if !exists {
+		return nil, false
+	}
+	return user.clone(), true
 }
 
-// SaveUser сохраняет пользователя в хранилище
+// SaveUser сохраняет копию пользователя в хранилище
 func (s *UserStore) SaveUser(user *User) {
-	s.users[user.Username] = user
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.Username] = user.clone()
+	s.changed(user.Username)
+}
+
+// Update изменяет пользователя под блокировкой хранилища. Функция получает копию
+// записи; копия заменяет запись, только если функция вернула nil.
+func (s *UserStore) Update(username string, fn func(user *User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("пользователь не найден")
+	}
+
+	updated := user.clone()
+	if err := fn(updated); err != nil {
+		return err
+	}
+	s.users[username] = updated.clone()
+	s.changed(username)
+	return nil
+}
+
+// Rename переносит запись пользователя под новый логин под блокировкой хранилища
+func (s *UserStore) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[oldName]
+	if !exists {
+		return fmt.Errorf("пользователь не найден")
+	}
+	if _, taken := s.users[newName]; taken {
+		return fmt.Errorf("пользователь с логином '%s' уже существует", newName)
+	}
+
+	renamed := user.clone()
+	renamed.Username = newName
+	renamed.TokensRevokedAt = time.Now() // Токены выданы прежнему логину
+	s.users[newName] = renamed
+	delete(s.users, oldName)
+	s.changed(newName)
+	s.changed(oldName)
+	return nil
+}
+
+// Merge переносит данные записи source в запись target и удаляет source под блокировкой хранилища.
+// Функция получает копии обеих записей; изменения применяются, только если она вернула nil.
+func (s *UserStore) Merge(target, source string, fn func(target, source *User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targetUser, exists := s.users[target]
+	if !exists {
+		return fmt.Errorf("пользователь '%s' не найден", target)
+	}
+	sourceUser, exists := s.users[source]
+	if !exists {
+		return fmt.Errorf("пользователь '%s' не найден", source)
+	}
+
+	merged := targetUser.clone()
+	if err := fn(merged, sourceUser.clone()); err != nil {
+		return err
+	}
+	s.users[target] = merged.clone()
+	delete(s.users, source)
+	s.changed(target)
+	s.changed(source)
+	return nil
+}
+
+// DeleteUser удаляет пользователя из хранилища
+func (s *UserStore) DeleteUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[username]; exists {
+		delete(s.users, username)
+		s.changed(username)
+	}
 }
 
 // UserExists проверяет, существует ли пользователь с данным логином
 func (s *UserStore) UserExists(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	_, exists := s.users[username]
 	return exists
 }
 
-// GetAllUsers возвращает список всех пользователей (для отладки)
+// GetAllUsers возвращает копии всех пользователей
 func (s *UserStore) GetAllUsers() map[string]*User {
-	return s.users
-}
\ No newline at end of file
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make(map[string]*User, len(s.users))
+	for username, user := range s.users {
+		users[username] = user.clone()
+	}
+	return users
+}
+
+// Usernames возвращает отсортированный список логинов без копирования записей
+func (s *UserStore) Usernames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	usernames := make([]string, 0, len(s.users))
+	for username := range s.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// GetUsers возвращает копии пользователей с указанными логинами в том же порядке.
+// Логины, удаленные после получения списка, пропускаются.
+func (s *UserStore) GetUsers(usernames []string) []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(usernames))
+	for _, username := range usernames {
+		if user, exists := s.users[username]; exists {
+			users = append(users, user.clone())
+		}
+	}
+	return users
+}
@@ -1,22 +1,89 @@
 package main
 
 import (
+	"bufio"
+	"crypto/ed25519"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 )
 
 // UserManager управляет операциями с пользователями
 type UserManager struct {
-	store        *UserStore
-	maxAttempts  int // Максимальное количество неудачных попыток входа
+	store             *UserStore
+	rules             PasswordRules             // Правила паролей для регистрации и смены пароля
+	maxAttempts       int                       // Максимальное количество неудачных попыток входа
+	failureWindow     time.Duration             // Окно подсчета неудачных попыток (0 - без ограничения по времени)
+	lockoutDuration   time.Duration             // Срок блокировки после неудачных попыток (0 - до разблокировки)
+	dormancy          DormancyPolicy            // Политика обработки неактивных учетных записей
+	recheck           RecheckCampaign           // Правила проверки паролей существующих пользователей
+	rulesChangedAt    time.Time                 // Когда последний раз изменялась политика паролей
+	audit             *AuditLog                 // Журнал аудита (nil - аудит отключен)
+	htpasswdPath      string                    // Файл htpasswd, перезаписываемый при изменениях (пусто - отключено)
+	honeypotLockout   time.Duration             // Блокировка входа после попытки входа в ловушку (0 - выключена)
+	honeypotHits      []HoneypotHit             // Попытки входа в ловушки с момента запуска
+	lockedUntil       time.Time                 // До какого момента вход с консоли запрещен
+	serving           bool                      // Менеджер обслуживает API (serve): блокировки после ловушки нет
+	duressMode        DuressMode                // Режим паролей под принуждением (по умолчанию выключены)
+	disabledFeatures  map[Feature]bool          // Подсистемы, отключенные в конфигурации
+	hooks             map[HookPoint]string      // Внешние обработчики по точкам вызова
+	requireApproval   bool                      // Новые учетные записи ожидают одобрения администратором
+	inviteKey         ed25519.PrivateKey        // Ключ подписи приглашений (nil - регистрация без приглашений)
+	registration      RegistrationMode          // Режим регистрации (пусто - open)
+	usedInvites       map[string]bool           // Погашенные приглашения по идентификатору
+	terms             TermsDocument             // Условия использования, которые нужно принять для входа
+	deletionRetention time.Duration             // Срок хранения удаленных учетных записей (0 - удалять сразу)
+	deleted           map[string]DeletedAccount // Удаленные учетные записи по логину до окончательного удаления
+	expiryWarning     time.Duration             // За сколько до истечения срока пароля предупреждать при входе
+	dryRun            bool                      // Пробный запуск: разрушающие операции только сообщают об изменениях
+	planned           []PlannedChange           // Изменения, которые внес бы пробный запуск
+	loginStats        LoginStats                // Скользящая статистика входа
+	roles             map[string][]Permission   // Роли из конфигурации политики и их права
+	groups            map[string]Group          // Группы пользователей по имени
+	profileSteps      []ProfileStep             // Шаги профиля, которые требуются после входов или дней
+	policyRules       []PolicyRule              // Правила входа по атрибутам (policyrules.go)
+	dualControl       map[string]bool           // Операции API, требующие второго участника (dualcontrol.go)
+	request           AuditSource               // Источник запроса API под блокировкой API (requestsource.go)
 }
 
 // NewUserManager создает новый менеджер пользователей
 func NewUserManager() *UserManager {
 	return &UserManager{
-		store:       NewUserStore(),
-		maxAttempts: 3, // После 3 неудачных попыток пользователь блокируется
+		store:         NewUserStore(),
+		rules:         DefaultPasswordRules(),
+		maxAttempts:   3,                // После 3 неудачных попыток пользователь блокируется,
+		failureWindow: 15 * time.Minute, // если они совершены в течение 15 минут
+		dormancy:      DefaultDormancyPolicy(),
+		recheck:       DefaultRecheckCampaign(),
+	}
+}
+
+// SetAuditLog подключает журнал аудита
+func (um *UserManager) SetAuditLog(log *AuditLog) {
+	um.audit = log
+}
+
+// recordAudit записывает событие в журнал аудита, если он подключен.
+// Ошибка записи не прерывает операцию, но выводится в stderr.
+func (um *UserManager) recordAudit(event, username, details string) {
+	um.recordActorAudit(event, username, "", details)
+}
+
+// recordActorAudit записывает событие, выполненное администратором от имени пользователя
+func (um *UserManager) recordActorAudit(event, username, actor, details string) {
+	um.recordAuditFrom(um.request, event, username, actor, details)
+}
+
+// recordAuditFrom записывает событие запроса API из источника source. Вне блокировки API
+// источник передается явно: um.request в это время принадлежит другому запросу.
+func (um *UserManager) recordAuditFrom(source AuditSource, event, username, actor, details string) {
+	if um.audit == nil {
+		return
+	}
+	if err := um.audit.RecordFrom(source, event, username, actor, details); err != nil {
+		fmt.Fprintf(os.Stderr, "аудит: %v\n", err)
 	}
 }
 
@@ -28,6 +95,19 @@ const (
 	AuthInvalidCredentials
 	AuthUserBlocked
 	AuthUserNotFound
+	AuthOutsideSchedule
+	AuthPasswordExpired
+	AuthSourceBlocked
+	AuthAccountDisabled
+	AuthRejectedByHook
+	AuthPendingApproval
+	AuthTermsRequired
+	AuthSAMLNotLinked
+	AuthKerberosNotMapped
+	AuthCertificateNotMapped
+	AuthSecondFactorRequired
+	AuthProfileRequired
+	AuthRejectedByRule
 )
 
 // String возвращает строковое представление результата аутентификации
@@ -38,9 +118,35 @@ func (r AuthResult) String() string {
 	case AuthInvalidCredentials:
 		return "Неверный логин или пароль"
 	case AuthUserBlocked:
-		return "Пользователь заблокирован"
+		return "Вход по паролю заблокирован"
 	case AuthUserNotFound:
 		return "Пользователь не найден"
+	case AuthOutsideSchedule:
+		return "Вход запрещен расписанием"
+	case AuthPasswordExpired:
+		return "Истек срок смены пароля"
+	case AuthSourceBlocked:
+		return "Вход временно запрещен"
+	case AuthAccountDisabled:
+		return "Учетная запись отключена администратором"
+	case AuthRejectedByHook:
+		return "Вход запрещен внешней политикой"
+	case AuthPendingApproval:
+		return "Регистрация ожидает одобрения администратором"
+	case AuthTermsRequired:
+		return "Требуется принять условия использования"
+	case AuthSAMLNotLinked:
+		return "Учетная запись не связана с удостоверением SAML"
+	case AuthKerberosNotMapped:
+		return "Участник Kerberos не сопоставлен с учетной записью"
+	case AuthCertificateNotMapped:
+		return "Сертификат клиента не привязан к учетной записи"
+	case AuthSecondFactorRequired:
+		return "Группа или правило политики требует входа со вторым фактором"
+	case AuthProfileRequired:
+		return "Перед входом нужно дополнить профиль"
+	case AuthRejectedByRule:
+		return "Вход запрещен правилом политики"
 	default:
 		return "Неизвестная ошибка"
 	}
@@ -48,6 +154,18 @@ func (r AuthResult) String() string {
 
 // RegisterUser регистрирует нового пользователя
 func (um *UserManager) RegisterUser(username, password string) error {
+	switch um.RegistrationMode() {
+	case RegistrationInvite:
+		return fmt.Errorf("регистрация возможна только по приглашению")
+	case RegistrationAdmin:
+		return errRegistrationClosed
+	}
+	return um.registerUser(username, password, "", "")
+}
+
+// registerUser создает учетную запись после всех проверок (email - адрес из приглашения,
+// actor - администратор, создающий учетную запись; пусто - самостоятельная регистрация)
+func (um *UserManager) registerUser(username, password, email, actor string) error {
 	// Проверяем, что логин не пустой
 	username = strings.TrimSpace(username)
 	if username == "" {
@@ -60,10 +178,13 @@ func (um *UserManager) RegisterUser(username, password string) error {
 	}
 
 	// Проверяем безопасность пароля
-	isSecure, errors := IsPasswordSecure(password)
+	isSecure, violations := ValidatePassword(password, um.rules)
 	if !isSecure {
-		return fmt.Errorf("пароль не соответствует требованиям безопасности:\n- %s", 
-			strings.Join(errors, "\n- "))
+		return &PasswordPolicyError{Subject: "пароль", Violations: violations}
+	}
+
+	if err := um.runHook(HookPreRegister, username); err != nil {
+		return err
 	}
 
 	// Хешируем пароль
@@ -74,62 +195,367 @@ func (um *UserManager) RegisterUser(username, password string) error {
 
 	// Создаем нового пользователя
 	user := &User{
-		Username:       username,
-		HashedPassword: hashedPassword,
-		FailedAttempts: 0,
-		IsBlocked:      false,
-		CreatedAt:      time.Now(),
-		LastLoginAt:    time.Time{}, // Будет установлено при первом входе
-		BlockedAt:      time.Time{},
+		Username:          username,
+		Email:             email,
+		HashedPassword:    hashedPassword,
+		FailedAttempts:    0,
+		IsBlocked:         false,
+		CreatedAt:         time.Now(),
+		LastLoginAt:       time.Time{}, // Будет установлено при первом входе
+		BlockedAt:         time.Time{},
+		PasswordChangedAt: time.Now(),
+		PendingApproval:   um.requireApproval && actor == "",
 	}
 
 	// Сохраняем пользователя
 	um.store.SaveUser(user)
+	if user.PendingApproval {
+		um.recordAudit(AuditRegister, username, "ожидает одобрения администратором")
+		return nil
+	}
+	if actor != "" {
+		um.recordActorAudit(AuditRegister, username, actor, "создана администратором")
+	} else {
+		um.recordAudit(AuditRegister, username, "")
+	}
+	um.usersChanged()
 	
 	return nil
 }
 
+// AuthOutcome - результат аутентификации с подробностями, по которым интерфейс
+// может подсказать пользователю дальнейшие действия без разбора текста
+type AuthOutcome struct {
+	Result            AuthResult       // Код результата
+	RemainingAttempts int              // Сколько неудачных попыток осталось до блокировки входа по паролю
+	RetryAfter        time.Duration    // Через сколько вход снова станет возможен (0 - не ограничено временем)
+	LockedUntil       time.Time        // До какого момента вход запрещен (нулевое значение - до разблокировки)
+	TermsVersion      string           // Редакция условий использования, которую нужно принять
+	PasswordExpiresAt time.Time        // Когда истекает пароль, если срок близок (нулевое значение - предупреждать не нужно)
+	ExpiryReason      string           // Почему пароль нужно сменить
+	ProfileSteps      []ProfileStepDue // Шаги профиля, которые пора выполнить
+}
+
+// String возвращает строковое представление результата аутентификации
+func (o AuthOutcome) String() string {
+	return o.Result.String()
+}
+
 // AuthenticateUser проверяет учетные данные пользователя
-func (um *UserManager) AuthenticateUser(username, password string) (AuthResult, error) {
+func (um *UserManager) AuthenticateUser(username, password string) (AuthOutcome, error) {
+	return um.authenticate(username, password, "")
+}
+
+// authenticate проверяет учетные данные и учитывает попытку в статистике входа;
+// acceptTerms - версия условий использования, которую пользователь принимает
+// при этом входе (пусто - не принимает)
+func (um *UserManager) authenticate(username, password, acceptTerms string) (AuthOutcome, error) {
+	started := time.Now()
+	outcome, err := um.checkCredentials(username, password, acceptTerms)
+	um.loginStats.record(loginResultLabel(outcome, err), time.Since(started), time.Now())
+	return outcome, err
+}
+
+// checkCredentials проверяет учетные данные
+func (um *UserManager) checkCredentials(username, password, acceptTerms string) (AuthOutcome, error) {
 	username = strings.TrimSpace(username)
+	now := time.Now()
+
+	// После попытки входа в ловушку вход временно запрещен
+	if outcome, blocked := um.sourceLockout(now); blocked {
+		return outcome, nil
+	}
 	
 	// Находим пользователя
 	user, exists := um.store.GetUser(username)
 	if !exists {
-		return AuthUserNotFound, nil
+		return AuthOutcome{Result: AuthUserNotFound}, nil
 	}
 
-	// Проверяем, заблокирован ли пользователь
-	if user.IsBlocked {
-		return AuthUserBlocked, nil
+	if outcome, allowed := um.checkLoginAllowed(user, now); !allowed {
+		return outcome, nil
 	}
 
 	// Проверяем пароль
-	if VerifyPassword(password, user.HashedPassword) {
-		// Успешная аутентификация - сбрасываем счетчик неудачных попыток
-		user.FailedAttempts = 0
-		user.LastLoginAt = time.Now()
-		um.store.SaveUser(user)
+	passwordValid, err := um.verifyUserPassword(user, password)
+	if err != nil {
+		return AuthOutcome{Result: AuthInvalidCredentials}, err
+	}
+
+	if passwordValid {
+		// Срок назначенной смены пароля истек - вход только после смены пароля
+		if !user.RotationDue.IsZero() && time.Now().After(user.RotationDue) {
+			um.recordAudit(AuditRotationOverdue, username, user.RotationReason)
+			return AuthOutcome{Result: AuthPasswordExpired}, nil
+		}
+
+		// Группа требует второго фактора: одного пароля недостаточно
+		if group := um.secondFactorGroup(user); group != "" {
+			um.recordAudit(AuditSecondFactorRequired, username, "группа "+group)
+			return AuthOutcome{Result: AuthSecondFactorRequired}, nil
+		}
+		if rule, found := um.matchPolicyRule(user, ruleActionRequire2FA, now); found {
+			um.recordAudit(AuditSecondFactorRequired, username, "правило "+rule.Name)
+			return AuthOutcome{Result: AuthSecondFactorRequired}, nil
+		}
+
+		admittedOutcome, admitted, err := um.admitLogin(user, acceptTerms, AuditLoginSuccess, "", now)
+		if !admitted {
+			return admittedOutcome, err
+		}
+		if um.FeatureEnabled(FeaturePasswordRecheck) {
+			um.recheckOnLogin(user, password, time.Now())
+		}
+
+		// Проверка при входе могла назначить смену пароля - срок берется из актуальной записи
+		outcome := AuthOutcome{Result: AuthSuccess, ProfileSteps: admittedOutcome.ProfileSteps}
+		if current, exists := um.store.GetUser(username); exists {
+			outcome.PasswordExpiresAt, outcome.ExpiryReason = um.expiryWarningFor(current, now)
+		}
 		
-		return AuthSuccess, nil
+		return outcome, nil
 	} else {
-		// Неверный пароль - увеличиваем счетчик неудачных попыток
-		user.FailedAttempts++
-		
-		// Проверяем, нужно ли блокировать пользователя
-		if user.FailedAttempts >= um.maxAttempts {
-			user.IsBlocked = true
-			user.BlockedAt = time.Now()
+		// Пароль под принуждением - не считается неудачной попыткой,
+		// но остаток попыток выглядит так же, как после неверного пароля
+		if result, duress := um.checkDuress(user, password); duress {
+			outcome := AuthOutcome{Result: result}
+			if result == AuthInvalidCredentials {
+				outcome.RemainingAttempts = max(um.maxAttempts-len(um.failuresInWindow(user, now))-1, 1)
+			}
+			return outcome, nil
+		}
+
+		// Неверный пароль - увеличиваем счетчик неудачных попыток.
+		// Счетчик меняется под блокировкой, чтобы параллельные попытки не терялись.
+		var attempts int
+		var blocked bool
+		var lockExpiresAt time.Time
+		err := um.store.Update(username, func(user *User) error {
+			now := time.Now()
+			user.FailedAt = append(um.failuresInWindow(user, now), now)
+			user.FailedAttempts = len(user.FailedAt)
+			
+			// Проверяем, нужно ли блокировать пользователя
+			if user.FailedAttempts >= um.maxAttempts && !user.IsBlocked {
+				user.IsBlocked = true
+				user.BlockedAt = time.Now()
+				if um.lockoutDuration > 0 {
+					user.LockExpiresAt = user.BlockedAt.Add(um.lockoutDuration)
+				}
+				blocked = true
+			}
+			attempts = user.FailedAttempts
+			lockExpiresAt = user.LockExpiresAt
+			return nil
+		})
+		if err != nil {
+			return AuthOutcome{Result: AuthUserNotFound}, nil
 		}
 		
-		um.store.SaveUser(user)
+		um.recordAudit(AuditLoginFailed, username, fmt.Sprintf("попытка %d/%d", attempts, um.maxAttempts))
+		if blocked {
+			um.recordAudit(AuditAccountBlocked, username, "превышен лимит неудачных попыток")
+			um.usersChanged()
+		}
 		
-		if user.IsBlocked {
-			return AuthUserBlocked, nil
+		if attempts >= um.maxAttempts {
+			outcome := AuthOutcome{Result: AuthUserBlocked}
+			if !lockExpiresAt.IsZero() {
+				outcome.RetryAfter = time.Until(lockExpiresAt)
+				outcome.LockedUntil = lockExpiresAt
+			}
+			return outcome, nil
 		}
 		
-		return AuthInvalidCredentials, nil
+		return AuthOutcome{Result: AuthInvalidCredentials, RemainingAttempts: um.maxAttempts - attempts}, nil
+	}
+}
+
+// sourceLockout сообщает, запрещен ли вход после попытки входа в ловушку
+func (um *UserManager) sourceLockout(now time.Time) (AuthOutcome, bool) {
+	if now.Before(um.lockedUntil) {
+		return AuthOutcome{
+			Result:      AuthSourceBlocked,
+			RetryAfter:  um.lockedUntil.Sub(now),
+			LockedUntil: um.lockedUntil,
+		}, true
+	}
+	return AuthOutcome{}, false
+}
+
+// checkLoginAllowed проверяет ограничения входа, которые не зависят от способа подтверждения
+// личности (пароль или утверждение SAML): ловушку, отключение и блокировку, одобрение
+// регистрации, расписание и правила политики. allowed = false - вход запрещен с результатом outcome.
+func (um *UserManager) checkLoginAllowed(user *User, now time.Time) (outcome AuthOutcome, allowed bool) {
+	// Учетная запись-ловушка: поднимаем тревогу, пароль не проверяем.
+	// Для атакующего ответ выглядит как первая неудачная попытка обычного пользователя.
+	if user.IsHoneypot {
+		return AuthOutcome{
+			Result:            um.triggerHoneypot(user, now),
+			RemainingAttempts: um.maxAttempts - 1,
+		}, false
+	}
+
+	// Проверяем, заблокирован ли пользователь
+	if user.DisabledByAdmin {
+		return AuthOutcome{Result: AuthAccountDisabled}, false
+	}
+	if lockExpired(user, now) {
+		if um.releaseExpiredLock(user.Username) {
+			user.IsBlocked = false
+		}
+	}
+	if user.IsBlocked {
+		outcome := AuthOutcome{Result: AuthUserBlocked}
+		if !user.LockExpiresAt.IsZero() {
+			outcome.RetryAfter = user.LockExpiresAt.Sub(now)
+			outcome.LockedUntil = user.LockExpiresAt
+		}
+		return outcome, false
+	}
+	if user.PendingApproval {
+		return AuthOutcome{Result: AuthPendingApproval}, false
+	}
+
+	// Проверяем расписание входа (до проверки пароля, попытка не считается неудачной)
+	if user.Schedule != nil && !user.Schedule.Allows(now) {
+		um.recordAudit(AuditOutsideSchedule, user.Username, user.Schedule.String())
+		outcome := AuthOutcome{Result: AuthOutsideSchedule}
+		if next, ok := user.Schedule.NextAllowed(now); ok {
+			outcome.RetryAfter = next.Sub(now)
+			outcome.LockedUntil = next
+		}
+		return outcome, false
+	}
+
+	// Правила политики тоже проверяются до пароля
+	if rule, found := um.matchPolicyRule(user, ruleActionDeny, now); found {
+		um.recordAudit(AuditPolicyRuleDenied, user.Username, "правило "+rule.Name)
+		return AuthOutcome{Result: AuthRejectedByRule}, false
+	}
+	return AuthOutcome{}, true
+}
+
+// admitLogin завершает вход после подтверждения личности: проверяет принятие условий
+// использования и внешнюю политику, сбрасывает счетчик неудачных попыток и записывает
+// событие event в журнал аудита. admitted = false - вход отклонен с результатом outcome.
+func (um *UserManager) admitLogin(user *User, acceptTerms, event, details string, now time.Time) (outcome AuthOutcome, admitted bool, err error) {
+	username := user.Username
+
+	// Действующая редакция условий использования должна быть принята до входа
+	if !um.termsAccepted(user) {
+		if acceptTerms != um.terms.Version {
+			return AuthOutcome{Result: AuthTermsRequired, TermsVersion: um.terms.Version}, false, nil
+		}
+		if err := um.recordTermsAcceptance(username, now); err != nil {
+			return AuthOutcome{Result: AuthInvalidCredentials}, false, err
+		}
+	}
+
+	// Шаги профиля, которые больше нельзя откладывать, выполняются до входа
+	due, required := um.dueProfileSteps(user, now)
+	if len(required) > 0 {
+		um.recordAudit(AuditProfileStepRequired, username, "шаги: "+strings.Join(required, ", "))
+		return AuthOutcome{Result: AuthProfileRequired, ProfileSteps: due}, false, nil
+	}
+
+	// Внешняя политика может отклонить вход после подтверждения личности
+	if err := um.runHook(HookPostLogin, username); err != nil {
+		return AuthOutcome{Result: AuthRejectedByHook}, false, nil
+	}
+
+	// Успешная аутентификация - сбрасываем счетчик неудачных попыток
+	var device KnownDevice
+	newDevice := false
+	err = um.store.Update(username, func(user *User) error {
+		user.FailedAttempts = 0
+		user.FailedAt = nil
+		user.LastLoginAt = time.Now()
+		user.DormantSince = time.Time{}
+		user.DormancyWarnedAt = time.Time{}
+		user.UnderDuress = false
+		snoozeProfileSteps(user, due)
+		if um.request.Device != "" {
+			device, newDevice = rememberDevice(user, um.request, now)
+		}
+		return nil
+	})
+	if err != nil {
+		return AuthOutcome{Result: AuthUserNotFound}, false, nil
+	}
+	um.recordAudit(event, username, details)
+	if newDevice {
+		um.recordAudit(AuditNewDevice, username, describeDevice(device))
+		um.notifyHook(HookEvent{Event: HookNewDevice, Username: username, Time: now, ClientIP: um.request.ClientIP, Device: &device})
+	}
+	for _, step := range due {
+		um.recordAudit(AuditProfileStepSnoozed, username, fmt.Sprintf("шаг %s, осталось отложить: %d", step.Step, step.SnoozesLeft))
 	}
+	return AuthOutcome{Result: AuthSuccess, ProfileSteps: due}, true, nil
+}
+
+// verifyUserPassword проверяет пароль по bcrypt-хешу или, для перенесенных
+// пользователей, по унаследованному хешу с заменой его на bcrypt при успехе.
+// Хеш без перца или со старым ключом перца пересчитывается текущим ключом.
+func (um *UserManager) verifyUserPassword(user *User, password string) (bool, error) {
+	if user.LegacyHash == "" {
+		if !VerifyPassword(password, user.HashedPassword) {
+			return false, nil
+		}
+		if NeedsRepepper(user.HashedPassword) {
+			um.repepperPassword(user, password)
+		}
+		return true, nil
+	}
+
+	valid, err := VerifyLegacyPassword(password, user.LegacyHash)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return false, fmt.Errorf("ошибка перехеширования пароля: %v", err)
+	}
+	err = um.store.Update(user.Username, func(user *User) error {
+		user.HashedPassword = hashedPassword
+		user.LegacyHash = ""
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	um.recordAudit(AuditPasswordRehash, user.Username, "унаследованный хеш заменен на bcrypt")
+	um.usersChanged()
+
+	return true, nil
+}
+
+// ImportLegacyUser добавляет пользователя из унаследованной системы с хешем вида "md5:<hex>".
+// Пароль будет перехеширован bcrypt при первом успешном входе.
+func (um *UserManager) ImportLegacyUser(username, legacyHash string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return fmt.Errorf("логин не может быть пустым")
+	}
+
+	if um.store.UserExists(username) {
+		return fmt.Errorf("пользователь с логином '%s' уже существует", username)
+	}
+
+	scheme, _, _ := strings.Cut(legacyHash, ":")
+	if _, ok := legacyVerifiers[strings.ToLower(scheme)]; !ok {
+		return fmt.Errorf("неподдерживаемая схема унаследованного хеша: %s", scheme)
+	}
+
+	um.store.SaveUser(&User{
+		Username:   username,
+		LegacyHash: legacyHash,
+		CreatedAt:  time.Now(),
+	})
+	um.recordAudit(AuditLegacyImport, username, "схема "+scheme)
+
+	return nil
 }
 
 // ChangePassword изменяет пароль пользователя (для разблокировки)
@@ -142,11 +568,25 @@ func (um *UserManager) ChangePassword(username, newPassword string) error {
 		return fmt.Errorf("пользователь не найден")
 	}
 
+	// Сброс пароля ловушки - такой же признак атаки, как и попытка входа
+	if user.IsHoneypot {
+		um.triggerHoneypot(user, time.Now())
+		return fmt.Errorf("ошибка при изменении пароля")
+	}
+
+	// Отключенную администратором учетную запись нельзя восстановить сменой пароля
+	if user.DisabledByAdmin {
+		return fmt.Errorf("учетная запись отключена администратором, обратитесь к администратору")
+	}
+
+	if err := um.runHook(HookPrePasswordChange, username); err != nil {
+		return err
+	}
+
 	// Проверяем безопасность нового пароля
-	isSecure, errors := IsPasswordSecure(newPassword)
+	isSecure, violations := ValidatePassword(newPassword, um.rules)
 	if !isSecure {
-		return fmt.Errorf("новый пароль не соответствует требованиям безопасности:\n- %s", 
-			strings.Join(errors, "\n- "))
+		return &PasswordPolicyError{Subject: "новый пароль", Violations: violations}
 	}
 
 	// Хешируем новый пароль
@@ -156,12 +596,26 @@ func (um *UserManager) ChangePassword(username, newPassword string) error {
 	}
 
 	// Обновляем пароль и разблокируем пользователя
-	user.HashedPassword = hashedPassword
-	user.FailedAttempts = 0
-	user.IsBlocked = false
-	user.BlockedAt = time.Time{}
+	err = um.store.Update(username, func(user *User) error {
+		user.HashedPassword = hashedPassword
+		user.LegacyHash = ""
+		user.FailedAttempts = 0
+		user.FailedAt = nil
+		user.IsBlocked = false
+		user.BlockedAt = time.Time{}
+		user.LockExpiresAt = time.Time{}
+		user.DormantSince = time.Time{}
+		user.PasswordChangedAt = time.Now()
+		user.RotationDue = time.Time{}
+		user.RotationReason = ""
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 	
-	um.store.SaveUser(user)
+	um.recordAudit(AuditPasswordChanged, username, "")
+	um.usersChanged()
 	
 	return nil
 }
@@ -178,6 +632,35 @@ func (um *UserManager) GetUserStatus(username string) (string, error) {
 	var status strings.Builder
 	status.WriteString(fmt.Sprintf("Пользователь: %s\n", user.Username))
 	status.WriteString(fmt.Sprintf("Создан: %s\n", user.CreatedAt.Format("2006-01-02 15:04:05")))
+	if user.Email != "" {
+		status.WriteString(fmt.Sprintf("Email: %s\n", user.Email))
+	}
+	if user.IsAdmin {
+		status.WriteString("Роль: администратор\n")
+	} else if user.Role != "" {
+		status.WriteString(fmt.Sprintf("Роль: %s\n", user.Role))
+	}
+	if groups := um.UserGroups(user); len(groups) > 0 {
+		status.WriteString(fmt.Sprintf("Группы: %s\n", strings.Join(groups, ", ")))
+	}
+	if len(user.Attributes) > 0 {
+		status.WriteString(fmt.Sprintf("Атрибуты: %s\n", describeAttributes(user.Attributes)))
+	}
+	if user.SAMLNameID != "" {
+		status.WriteString(fmt.Sprintf("Вход через SAML: %s\n", user.SAMLNameID))
+	}
+	if len(user.SSHKeys) > 0 {
+		active := 0
+		for _, key := range user.SSHKeys {
+			if !key.Expired(time.Now()) {
+				active++
+			}
+		}
+		status.WriteString(fmt.Sprintf("Ключи SSH: %d, действующих %d\n", len(user.SSHKeys), active))
+	}
+	if len(user.CertBindings) > 0 {
+		status.WriteString(fmt.Sprintf("Привязанные сертификаты клиента: %d\n", len(user.CertBindings)))
+	}
 	
 	if !user.LastLoginAt.IsZero() {
 		status.WriteString(fmt.Sprintf("Последний вход: %s\n", user.LastLoginAt.Format("2006-01-02 15:04:05")))
@@ -185,39 +668,133 @@ func (um *UserManager) GetUserStatus(username string) (string, error) {
 		status.WriteString("Последний вход: никогда\n")
 	}
 	
-	if user.IsBlocked {
-		status.WriteString(fmt.Sprintf("Статус: ЗАБЛОКИРОВАН (с %s)\n", user.BlockedAt.Format("2006-01-02 15:04:05")))
-		status.WriteString("Для разблокировки необходимо сменить пароль\n")
+	if user.AcceptedTermsVersion != "" {
+		status.WriteString(fmt.Sprintf("Условия использования: версия %s принята %s\n", user.AcceptedTermsVersion, user.TermsAcceptedAt.Format("2006-01-02 15:04:05")))
+	}
+
+	if user.Schedule != nil {
+		status.WriteString(fmt.Sprintf("Расписание входа: %s\n", user.Schedule.String()))
+	}
+
+	if user.LegacyHash != "" {
+		status.WriteString("Хеш пароля: унаследованный, будет заменен на bcrypt при следующем входе\n")
+	}
+
+	if !user.DormantSince.IsZero() {
+		status.WriteString(fmt.Sprintf("Неактивен с: %s\n", user.DormantSince.Format("2006-01-02 15:04:05")))
+	}
+
+	if !user.RotationDue.IsZero() {
+		status.WriteString(fmt.Sprintf("Требуется смена пароля до %s: %s\n", user.RotationDue.Format("2006-01-02"), user.RotationReason))
+	}
+
+	if user.PendingApproval {
+		status.WriteString(fmt.Sprintf("Статус: ОЖИДАЕТ ОДОБРЕНИЯ (заявка от %s)\n", user.CreatedAt.Format("2006-01-02 15:04:05")))
+	} else if user.DisabledByAdmin {
+		status.WriteString(fmt.Sprintf("Статус: ОТКЛЮЧЕН АДМИНИСТРАТОРОМ (с %s)\n", user.BlockedAt.Format("2006-01-02 15:04:05")))
+		status.WriteString("Вход и смена пароля запрещены, доступна только разблокировка администратором\n")
+	} else if user.IsBlocked && lockExpired(user, time.Now()) {
+		status.WriteString("Статус: срок блокировки истек, вход снова возможен\n")
+	} else if user.IsBlocked {
+		status.WriteString(fmt.Sprintf("Статус: ЗАБЛОКИРОВАН ВХОД ПО ПАРОЛЮ (с %s)\n", user.BlockedAt.Format("2006-01-02 15:04:05")))
+		if !user.LockExpiresAt.IsZero() {
+			status.WriteString(fmt.Sprintf("Блокировка снимется в %s (через %s)\n",
+				user.LockExpiresAt.Format("2006-01-02 15:04:05"), FormatCountdown(time.Until(user.LockExpiresAt))))
+		}
+		status.WriteString("Доступно восстановление: смена пароля или разблокировка администратором\n")
 	} else {
 		status.WriteString("Статус: активен\n")
-		if user.FailedAttempts > 0 {
-			status.WriteString(fmt.Sprintf("Неудачные попытки входа: %d/%d\n", user.FailedAttempts, um.maxAttempts))
+		if failures := len(um.failuresInWindow(user, time.Now())); failures > 0 {
+			status.WriteString(fmt.Sprintf("Неудачные попытки входа: %d/%d", failures, um.maxAttempts))
+			if um.failureWindow > 0 {
+				status.WriteString(fmt.Sprintf(" за последние %v", um.failureWindow))
+			}
+			status.WriteString("\n")
 		}
 	}
 
 	return status.String(), nil
 }
 
-// GetAllUsersStatus возвращает статус всех пользователей
+// GetAllUsersStatus возвращает статус всех пользователей в порядке логинов
 func (um *UserManager) GetAllUsersStatus() string {
-	users := um.store.GetAllUsers()
-	
-	if len(users) == 0 {
-		return "В системе нет зарегистрированных пользователей"
+	var status strings.Builder
+	um.WriteUsersStatus(&status, 0, 0)
+	return status.String()
+}
+
+// WriteUsersStatus выводит в w страницу списка пользователей, упорядоченного по логину,
+// начиная с offset (limit 0 - до конца списка), и возвращает общее число пользователей.
+// Копируются только записи выводимой страницы.
+func (um *UserManager) WriteUsersStatus(w io.Writer, offset, limit int) (int, error) {
+	usernames := um.store.Usernames()
+	total := len(usernames)
+
+	if total == 0 {
+		_, err := io.WriteString(w, "В системе нет зарегистрированных пользователей")
+		return 0, err
 	}
 
-	var status strings.Builder
-	status.WriteString(fmt.Sprintf("Всего пользователей в системе: %d\n\n", len(users)))
-	
-	for username, user := range users {
-		status.WriteString(fmt.Sprintf("• %s", username))
-		if user.IsBlocked {
-			status.WriteString(" [ЗАБЛОКИРОВАН]")
-		} else if user.FailedAttempts > 0 {
-			status.WriteString(fmt.Sprintf(" [%d неудачных попыток]", user.FailedAttempts))
-		}
-		status.WriteString("\n")
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
 	}
 
-	return status.String()
-}
\ No newline at end of file
+	out := bufio.NewWriter(w)
+	if offset == 0 {
+		fmt.Fprintf(out, "Всего пользователей в системе: %d\n\n", total)
+	}
+
+	now := time.Now()
+	for _, user := range um.store.GetUsers(usernames[offset:end]) {
+		um.writeUserLine(out, user, now)
+	}
+
+	return total, out.Flush()
+}
+
+// writeUserLine выводит строку списка пользователей: логин и состояние учетной записи
+func (um *UserManager) writeUserLine(out *bufio.Writer, user *User, now time.Time) {
+	out.WriteString(theme.Bullet + " ")
+	out.WriteString(user.Username)
+	if user.PendingApproval {
+		out.WriteString(" [ОЖИДАЕТ ОДОБРЕНИЯ]")
+	} else if user.DisabledByAdmin {
+		out.WriteString(" [ОТКЛЮЧЕН]")
+	} else if user.IsBlocked {
+		out.WriteString(" [ЗАБЛОКИРОВАН]")
+	} else if !user.DormantSince.IsZero() {
+		out.WriteString(" [НЕАКТИВЕН]")
+	} else if failures := len(um.failuresInWindow(user, now)); failures > 0 {
+		fmt.Fprintf(out, " [%d неудачных попыток]", failures)
+	}
+	out.WriteByte('\n')
+}
+
+// SetFailureWindow задает окно подсчета неудачных попыток входа:
+// блокировка наступает после maxAttempts неудач в пределах окна (0 - без ограничения по времени)
+func (um *UserManager) SetFailureWindow(window time.Duration) {
+	um.failureWindow = window
+}
+
+// SetLockoutDuration задает срок блокировки входа после превышения лимита неудачных
+// попыток (0 - до смены пароля или разблокировки администратором)
+func (um *UserManager) SetLockoutDuration(duration time.Duration) {
+	um.lockoutDuration = max(duration, 0)
+}
+
+// failuresInWindow возвращает неудачные попытки пользователя, попадающие в окно подсчета
+func (um *UserManager) failuresInWindow(user *User, now time.Time) []time.Time {
+	if um.failureWindow <= 0 {
+		return user.FailedAt
+	}
+
+	var recent []time.Time
+	for _, failedAt := range user.FailedAt {
+		if now.Sub(failedAt) < um.failureWindow {
+			recent = append(recent, failedAt)
+		}
+	}
+	return recent
+}
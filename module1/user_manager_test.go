@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// benchmarkUsers - размер хранилища для измерения вывода списка пользователей
+const benchmarkUsers = 100000
+
+// newListingManager заполняет менеджер синтетическими пользователями (без хеширования паролей):
+// каждый десятый заблокирован, каждый десятый со сдвигом - с неудачной попыткой входа
+func newListingManager(users int) *UserManager {
+	um := NewUserManager()
+	now := time.Now()
+	for i := 0; i < users; i++ {
+		user := &User{
+			Username:       fmt.Sprintf("user%06d", (i*7919)%users), // Порядок вставки не совпадает с порядком логинов
+			HashedPassword: "synthetic",
+			CreatedAt:      now,
+		}
+		switch i % 10 {
+		case 0:
+			user.IsBlocked = true
+			user.BlockedAt = now
+		case 1:
+			user.FailedAt = []time.Time{now}
+			user.FailedAttempts = 1
+		}
+		um.store.SaveUser(user)
+	}
+	return um
+}
+
+func TestWriteUsersStatusOrder(t *testing.T) {
+	const users, pageSize = 1000, 50
+	um := newListingManager(users)
+
+	var full strings.Builder
+	total, err := um.WriteUsersStatus(&full, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != users {
+		t.Fatalf("всего %d пользователей вместо %d", total, users)
+	}
+	lines := strings.Split(strings.TrimSpace(full.String()), "\n")[2:]
+	if len(lines) != users {
+		t.Fatalf("выведено %d строк вместо %d", len(lines), users)
+	}
+	for i := 1; i < len(lines); i++ {
+		if lines[i-1] >= lines[i] {
+			t.Fatalf("порядок нарушен: %q перед %q", lines[i-1], lines[i])
+		}
+	}
+
+	var page strings.Builder
+	if _, err := um.WriteUsersStatus(&page, users/2, pageSize); err != nil {
+		t.Fatal(err)
+	}
+	pageLines := strings.Split(strings.TrimSuffix(page.String(), "\n"), "\n")
+	if len(pageLines) != pageSize || pageLines[0] != lines[users/2] {
+		t.Errorf("страница с %d: %d строк, первая %q", users/2, len(pageLines), pageLines[0])
+	}
+}
+
+func BenchmarkWriteUsersStatusFull(b *testing.B) {
+	um := newListingManager(benchmarkUsers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := um.WriteUsersStatus(io.Discard, 0, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteUsersStatusPage(b *testing.B) {
+	um := newListingManager(benchmarkUsers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := um.WriteUsersStatus(io.Discard, benchmarkUsers/2, usersPageSize); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserStatus(b *testing.B) {
+	um := newListingManager(benchmarkUsers)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := um.GetUserStatus(fmt.Sprintf("user%06d", i%benchmarkUsers)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testUser возвращает пользователя, у которого заполнены все срезы, словари и указатели
+func testUser() *User {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	return &User{
+		Username:       "alice",
+		HashedPassword: "$2a$10$hash",
+		FailedAt:       []time.Time{now.Add(-time.Minute), now},
+		SSHKeys:        []SSHKey{{Type: "ssh-ed25519", Key: "AAAA", Comment: "alice@host", AddedAt: now}},
+		CertBindings:   []CertBinding{{Kind: "dns", Value: "alice.example.com", AddedAt: now}},
+		KnownDevices:   []KnownDevice{{Hash: "0123", Name: "ноутбук", FirstSeen: now, LastSeen: now}},
+		Groups:         []string{"ops", "dev"},
+		ProfileSnoozes: map[string]int{ProfileStepEmail: 1},
+		Attributes:     map[string]string{"department": "IT"},
+		Schedule:       &LoginSchedule{Weekdays: []time.Weekday{time.Monday, time.Friday}, StartTime: 9 * 60, EndTime: 18 * 60},
+	}
+}
+
+// mutateUser изменяет на месте каждый срез, словарь и указатель записи
+func mutateUser(user *User) {
+	user.FailedAt[0] = time.Time{}
+	user.FailedAt = append(user.FailedAt, time.Time{})
+	user.SSHKeys[0].Comment = "mallory@host"
+	user.CertBindings[0].Value = "mallory.example.com"
+	user.KnownDevices[0].LastSeen = time.Time{}
+	user.Groups[0] = "admins"
+	user.ProfileSnoozes[ProfileStepEmail] = 99
+	user.ProfileSnoozes[ProfileStepTwoFA] = 1
+	user.Attributes["department"] = "Sales"
+	user.Attributes["location"] = "HQ"
+	user.Schedule.StartTime = 0
+	user.Schedule.Weekdays[0] = time.Sunday
+}
+
+// TestUserCloneCoversReferenceFields проверяет, что clone копирует каждое поле-ссылку:
+// новое поле-срез или словарь без копирования в clone сделает тест красным
+func TestUserCloneCoversReferenceFields(t *testing.T) {
+	original := testUser()
+	copied := original.clone()
+	value, copiedValue := reflect.ValueOf(original).Elem(), reflect.ValueOf(copied).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		switch field.Type.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Pointer:
+		default:
+			continue
+		}
+		if value.Field(i).IsNil() {
+			t.Errorf("testUser не заполняет поле %s", field.Name)
+			continue
+		}
+		if value.Field(i).Pointer() == copiedValue.Field(i).Pointer() {
+			t.Errorf("clone не копирует поле %s", field.Name)
+		}
+	}
+	if !reflect.DeepEqual(original, copied) {
+		t.Errorf("копия отличается от оригинала")
+	}
+}
+
+func TestUserStoreReturnsCopies(t *testing.T) {
+	readers := []struct {
+		name string
+		read func(store *UserStore) *User
+	}{
+		{"GetUser", func(store *UserStore) *User {
+			user, _ := store.GetUser("alice")
+			return user
+		}},
+		{"GetAllUsers", func(store *UserStore) *User { return store.GetAllUsers()["alice"] }},
+		{"GetUsers", func(store *UserStore) *User { return store.GetUsers([]string{"alice"})[0] }},
+		{"Snapshot", func(store *UserStore) *User {
+			var user *User
+			store.Snapshot(func(users []*User) { user = users[0] })
+			return user
+		}},
+	}
+	for _, reader := range readers {
+		t.Run(reader.name, func(t *testing.T) {
+			store := NewUserStore()
+			store.SaveUser(testUser())
+			mutateUser(reader.read(store))
+			if stored, _ := store.GetUser("alice"); !reflect.DeepEqual(stored, testUser()) {
+				t.Errorf("изменение копии изменило хранимую запись:\n%+v", stored)
+			}
+		})
+	}
+}
+
+func TestUserStoreKeepsCopies(t *testing.T) {
+	t.Run("SaveUser", func(t *testing.T) {
+		store := NewUserStore()
+		user := testUser()
+		store.SaveUser(user)
+		mutateUser(user)
+		if stored, _ := store.GetUser("alice"); !reflect.DeepEqual(stored, testUser()) {
+			t.Errorf("изменение сохраненной записи изменило хранилище:\n%+v", stored)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := NewUserStore()
+		store.SaveUser(testUser())
+		var kept *User
+		store.Update("alice", func(user *User) error {
+			kept = user
+			return nil
+		})
+		mutateUser(kept)
+		if stored, _ := store.GetUser("alice"); !reflect.DeepEqual(stored, testUser()) {
+			t.Errorf("запись, переданная в Update, связана с хранилищем:\n%+v", stored)
+		}
+	})
+
+	t.Run("Update с ошибкой", func(t *testing.T) {
+		store := NewUserStore()
+		store.SaveUser(testUser())
+		store.Update("alice", func(user *User) error {
+			mutateUser(user)
+			return errors.New("откат")
+		})
+		if stored, _ := store.GetUser("alice"); !reflect.DeepEqual(stored, testUser()) {
+			t.Errorf("отмененное Update изменило запись:\n%+v", stored)
+		}
+	})
+
+	t.Run("обработчик изменений", func(t *testing.T) {
+		store := NewUserStore()
+		var changes []StoreChange
+		store.SetChangeHandler(func(change StoreChange) { changes = append(changes, change) })
+		store.SaveUser(testUser())
+		mutateUser(changes[0].User)
+		if stored, _ := store.GetUser("alice"); !reflect.DeepEqual(stored, testUser()) {
+			t.Errorf("изменение, переданное обработчику, связано с хранилищем:\n%+v", stored)
+		}
+	})
+
+	t.Run("Replace и ApplyChanges", func(t *testing.T) {
+		store := NewUserStore()
+		replaced, applied := testUser(), testUser()
+		applied.Username = "bob"
+		store.Replace([]*User{replaced})
+		store.ApplyChanges([]StoreChange{{Username: "bob", User: applied}})
+		mutateUser(replaced)
+		mutateUser(applied)
+		alice, _ := store.GetUser("alice")
+		bob, _ := store.GetUser("bob")
+		expected := testUser()
+		expected.Username = "bob"
+		if !reflect.DeepEqual(alice, testUser()) || !reflect.DeepEqual(bob, expected) {
+			t.Errorf("переданные записи связаны с хранилищем:\n%+v\n%+v", alice, bob)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// VaultFormat - формат файла для импорта учетных данных в менеджер паролей
+type VaultFormat string
+
+const (
+	VaultKeePass   VaultFormat = "keepass"   // KeePass 2 XML
+	VaultBitwarden VaultFormat = "bitwarden" // Bitwarden JSON (незашифрованный экспорт)
+	Vault1Password VaultFormat = "1password" // 1Password CSV
+)
+
+// ParseVaultFormat разбирает название формата менеджера паролей
+func ParseVaultFormat(value string) (VaultFormat, error) {
+	format := VaultFormat(strings.ToLower(strings.TrimSpace(value)))
+	switch format {
+	case VaultKeePass, VaultBitwarden, Vault1Password:
+		return format, nil
+	default:
+		return "", fmt.Errorf("неизвестный формат менеджера паролей: %s (доступно: keepass, bitwarden, 1password)", value)
+	}
+}
+
+// keepassFile - структура KeePass 2 XML, достаточная для импорта
+type keepassFile struct {
+	XMLName xml.Name `xml:"KeePassFile"`
+	Group   struct {
+		Name    string         `xml:"Name"`
+		Entries []keepassEntry `xml:"Entry"`
+	} `xml:"Root>Group"`
+}
+
+type keepassEntry struct {
+	Strings []keepassString `xml:"String"`
+}
+
+type keepassString struct {
+	Key   string `xml:"Key"`
+	Value struct {
+		Protect string `xml:"ProtectInMemory,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	} `xml:"Value"`
+}
+
+// bitwardenExport - незашифрованный экспорт Bitwarden
+type bitwardenExport struct {
+	Encrypted bool            `json:"encrypted"`
+	Items     []bitwardenItem `json:"items"`
+}
+
+type bitwardenItem struct {
+	Type  int    `json:"type"` // 1 - логин
+	Name  string `json:"name"`
+	Notes string `json:"notes"`
+	Login struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"login"`
+}
+
+// WriteVault записывает учетные данные в формате менеджера паролей.
+// title - название системы, под которым записи появятся в хранилище.
+func WriteVault(w io.Writer, format VaultFormat, title string, credentials []ProvisionedCredential) error {
+	name := func(c ProvisionedCredential) string { return title + ": " + c.Username }
+	const notes = "Временный пароль: смените его при первом входе"
+
+	switch format {
+	case VaultKeePass:
+		var file keepassFile
+		file.Group.Name = title
+		for _, c := range credentials {
+			entry := keepassEntry{Strings: make([]keepassString, 4)}
+			for i, field := range [][2]string{{"Title", name(c)}, {"UserName", c.Username}, {"Password", c.Password}, {"Notes", notes}} {
+				entry.Strings[i].Key = field[0]
+				entry.Strings[i].Value.Text = field[1]
+			}
+			entry.Strings[2].Value.Protect = "True"
+			file.Group.Entries = append(file.Group.Entries, entry)
+		}
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return fmt.Errorf("ошибка записи KeePass XML: %v", err)
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(file); err != nil {
+			return fmt.Errorf("ошибка записи KeePass XML: %v", err)
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+
+	case VaultBitwarden:
+		export := bitwardenExport{Items: []bitwardenItem{}}
+		for _, c := range credentials {
+			item := bitwardenItem{Type: 1, Name: name(c), Notes: notes}
+			item.Login.Username = c.Username
+			item.Login.Password = c.Password
+			export.Items = append(export.Items, item)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(export); err != nil {
+			return fmt.Errorf("ошибка записи Bitwarden JSON: %v", err)
+		}
+		return nil
+
+	case Vault1Password:
+		writer := csv.NewWriter(w)
+		records := [][]string{{"title", "website", "username", "password", "notes"}}
+		for _, c := range credentials {
+			records = append(records, []string{name(c), "", c.Username, c.Password, notes})
+		}
+		if err := writer.WriteAll(records); err != nil {
+			return fmt.Errorf("ошибка записи 1Password CSV: %v", err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("неизвестный формат менеджера паролей: %s", format)
+	}
+}
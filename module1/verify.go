@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxVerifyBatch - наибольшее число учетных данных в одном пакете проверки
+const maxVerifyBatch = 1000
+
+// Результаты проверки учетных данных
+const (
+	VerifyMatch      = "match"       // Пароль подходит
+	VerifyMismatch   = "mismatch"    // Пароль не подходит
+	VerifyNotFound   = "not_found"   // Учетной записи нет
+	VerifyNoPassword = "no_password" // У учетной записи нет пароля
+	VerifyError      = "error"       // Хеш не удалось проверить
+	VerifyCanceled   = "canceled"    // Проверка прервана: клиент отключился
+)
+
+// CredentialCandidate - учетные данные для проверки
+type CredentialCandidate struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// CredentialResult - результат проверки одних учетных данных
+type CredentialResult struct {
+	Username string `json:"username"`
+	Result   string `json:"result"`
+	Scheme   string `json:"scheme,omitempty"` // bcrypt, bcrypt+pepper:<ключ> или схема унаследованного хеша
+	Error    string `json:"error,omitempty"`
+}
+
+// CredentialVerifier проверяет пароли пакетами, например после импорта учетных записей
+// из другой системы. Проверка не меняет учетные записи: неудачи не считаются попытками
+// входа и не блокируют вход, а унаследованные хеши не заменяются на bcrypt. Число
+// одновременных проверок ограничено для всех пакетов вместе.
+type CredentialVerifier struct {
+	um    *UserManager
+	slots chan struct{}
+}
+
+// NewCredentialVerifier создает проверку пакетов с workers параллельными проверками
+func NewCredentialVerifier(um *UserManager, workers int) *CredentialVerifier {
+	return &CredentialVerifier{um: um, slots: make(chan struct{}, max(workers, 1))}
+}
+
+// Verify проверяет учетные данные и возвращает результаты в том же порядке
+func (v *CredentialVerifier) Verify(ctx context.Context, candidates []CredentialCandidate) ([]CredentialResult, error) {
+	if len(candidates) > maxVerifyBatch {
+		return nil, fmt.Errorf("в пакете больше %d учетных данных", maxVerifyBatch)
+	}
+
+	results := make([]CredentialResult, len(candidates))
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		select {
+		case v.slots <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(candidates); j++ {
+				results[j] = CredentialResult{Username: strings.TrimSpace(candidates[j].Username), Result: VerifyCanceled}
+			}
+			wg.Wait()
+			return results, nil
+		}
+		wg.Add(1)
+		go func(i int, candidate CredentialCandidate) {
+			defer wg.Done()
+			defer func() { <-v.slots }()
+			results[i] = v.verifyOne(candidate)
+		}(i, candidate)
+	}
+	wg.Wait()
+
+	matched := 0
+	for _, result := range results {
+		if result.Result == VerifyMatch {
+			matched++
+		}
+	}
+	v.um.recordAuditFrom(auditSourceFrom(ctx), AuditBatchVerified, "", "", fmt.Sprintf("проверено %d, подошло %d", len(results), matched))
+	return results, nil
+}
+
+// verifyOne проверяет одни учетные данные по копии учетной записи
+func (v *CredentialVerifier) verifyOne(candidate CredentialCandidate) CredentialResult {
+	username := strings.TrimSpace(candidate.Username)
+	result := CredentialResult{Username: username}
+
+	user, exists := v.um.store.GetUser(username)
+	switch {
+	case !exists || user.IsHoneypot:
+		result.Result = VerifyNotFound
+	case user.LegacyHash != "":
+		scheme, _, _ := strings.Cut(user.LegacyHash, ":")
+		result.Scheme = strings.ToLower(scheme)
+		valid, err := VerifyLegacyPassword(candidate.Password, user.LegacyHash)
+		switch {
+		case err != nil:
+			result.Result, result.Error = VerifyError, err.Error()
+		case valid:
+			result.Result = VerifyMatch
+		default:
+			result.Result = VerifyMismatch
+		}
+	case user.HashedPassword == "":
+		result.Result = VerifyNoPassword
+	default:
+		result.Scheme = "bcrypt"
+		if keyID := PepperKeyID(user.HashedPassword); keyID != "" {
+			result.Scheme = "bcrypt+pepper:" + keyID
+		}
+		result.Result = VerifyMismatch
+		if VerifyPassword(candidate.Password, user.HashedPassword) {
+			result.Result = VerifyMatch
+		}
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolicyViolation - нарушенное требование политики паролей. Значение хранит данные
+// нарушения, а не готовый текст, поэтому клиент API может показать его на любом языке
+// или рядом с полем формы; String возвращает сообщение на русском для консоли и журналов.
+type PolicyViolation interface {
+	Rule() string // Код правила: min_length, min_uppercase, ..., max_length, forbidden_char, ...
+	String() string
+}
+
+// RuleMinLength - пароль короче минимальной длины
+type RuleMinLength struct {
+	Need int `json:"need"`
+	Got  int `json:"got"`
+}
+
+// RuleMinUppercase - заглавных букв меньше минимума
+type RuleMinUppercase struct {
+	Need int `json:"need"`
+	Got  int `json:"got"`
+}
+
+// RuleMinLowercase - строчных букв меньше минимума
+type RuleMinLowercase struct {
+	Need int `json:"need"`
+	Got  int `json:"got"`
+}
+
+// RuleMinDigits - цифр меньше минимума
+type RuleMinDigits struct {
+	Need int `json:"need"`
+	Got  int `json:"got"`
+}
+
+// RuleMinSpecial - специальных символов меньше минимума
+type RuleMinSpecial struct {
+	Need int `json:"need"`
+	Got  int `json:"got"`
+}
+
+// RuleMaxLength - пароль длиннее, чем допускает целевая система
+type RuleMaxLength struct {
+	Max int `json:"max"`
+	Got int `json:"got"`
+}
+
+// RuleForbiddenChar - пароль содержит символ, который целевая система не принимает
+type RuleForbiddenChar struct {
+	Char string `json:"char"`
+}
+
+// RuleStartWithLetter - целевая система требует начинать пароль с буквы
+type RuleStartWithLetter struct{}
+
+// RuleNoTrailingSpace - целевая система не принимает пробел в конце пароля
+type RuleNoTrailingSpace struct{}
+
+func (RuleMinLength) Rule() string       { return "min_length" }
+func (RuleMinUppercase) Rule() string    { return "min_uppercase" }
+func (RuleMinLowercase) Rule() string    { return "min_lowercase" }
+func (RuleMinDigits) Rule() string       { return "min_digits" }
+func (RuleMinSpecial) Rule() string      { return "min_special" }
+func (RuleMaxLength) Rule() string       { return "max_length" }
+func (RuleForbiddenChar) Rule() string   { return "forbidden_char" }
+func (RuleStartWithLetter) Rule() string { return "start_with_letter" }
+func (RuleNoTrailingSpace) Rule() string { return "no_trailing_space" }
+
+func (v RuleMinLength) String() string {
+	return fmt.Sprintf("пароль должен содержать минимум %d символов", v.Need)
+}
+
+func (v RuleMinUppercase) String() string {
+	return fmt.Sprintf("пароль должен содержать минимум %d заглавных букв", v.Need)
+}
+
+func (v RuleMinLowercase) String() string {
+	return fmt.Sprintf("пароль должен содержать минимум %d строчных букв", v.Need)
+}
+
+func (v RuleMinDigits) String() string {
+	return fmt.Sprintf("пароль должен содержать минимум %d цифр", v.Need)
+}
+
+func (v RuleMinSpecial) String() string {
+	return fmt.Sprintf("пароль должен содержать минимум %d специальных символов", v.Need)
+}
+
+func (v RuleMaxLength) String() string {
+	return fmt.Sprintf("пароль должен содержать не более %d символов", v.Max)
+}
+
+func (v RuleForbiddenChar) String() string {
+	return fmt.Sprintf("пароль не должен содержать символ %q", v.Char)
+}
+
+func (RuleStartWithLetter) String() string {
+	return "пароль должен начинаться с буквы"
+}
+
+func (RuleNoTrailingSpace) String() string {
+	return "пароль не должен заканчиваться пробелом"
+}
+
+// violationMessages возвращает сообщения о нарушениях на русском
+func violationMessages(violations []PolicyViolation) []string {
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		messages[i] = violation.String()
+	}
+	return messages
+}
+
+// PasswordPolicyError - пароль не соответствует правилам; Violations - нарушенные требования
+type PasswordPolicyError struct {
+	Subject    string // Какой пароль проверялся: "пароль", "новый пароль"
+	Violations []PolicyViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("%s не соответствует требованиям безопасности:\n- %s", e.Subject, strings.Join(violationMessages(e.Violations), "\n- "))
+}
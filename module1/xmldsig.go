@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Проверка подписей XML-DSig в утверждениях SAML: исключающая канонизация
+// (Exclusive XML Canonicalization 1.0 без комментариев), подпись, вложенная
+// в подписанный элемент, ключи RSA и ECDSA с SHA-256 и SHA-512. SHA-1 не принимается.
+const (
+	dsigNS            = "http://www.w3.org/2000/09/xmldsig#"
+	xmlNamespaceURI   = "http://www.w3.org/XML/1998/namespace"
+	excC14NAlgorithm  = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	envelopedSigAlgo  = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	digestSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	digestSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+	signatureRSA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	signatureRSA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	signatureECDSA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	signatureECDSA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+// xmlDigestMethods - поддерживаемые алгоритмы хеширования подписанного элемента
+var xmlDigestMethods = map[string]crypto.Hash{
+	digestSHA256: crypto.SHA256,
+	digestSHA512: crypto.SHA512,
+}
+
+// xmlSignatureMethod - алгоритм подписи: хеш и тип ключа
+type xmlSignatureMethod struct {
+	hash  crypto.Hash
+	ecdsa bool
+}
+
+// xmlSignatureMethods - поддерживаемые алгоритмы подписи
+var xmlSignatureMethods = map[string]xmlSignatureMethod{
+	signatureRSA256:   {crypto.SHA256, false},
+	signatureRSA512:   {crypto.SHA512, false},
+	signatureECDSA256: {crypto.SHA256, true},
+	signatureECDSA512: {crypto.SHA512, true},
+}
+
+// xmlNode - элемент документа с исходными префиксами имен: они нужны для канонизации,
+// а encoding/xml при обычном разборе их теряет
+type xmlNode struct {
+	parent   *xmlNode
+	prefix   string            // Префикс имени ("" - пространство имен по умолчанию)
+	local    string            // Локальное имя
+	space    string            // URI пространства имен элемента
+	declared map[string]string // Объявления пространств имен на элементе: префикс -> URI ("" - по умолчанию)
+	attrs    []xmlAttr         // Атрибуты без объявлений пространств имен
+	children []xmlContent
+}
+
+// xmlAttr - атрибут элемента
+type xmlAttr struct {
+	prefix string
+	local  string
+	space  string
+	value  string
+}
+
+// xmlContent - дочерний элемент или текст
+type xmlContent struct {
+	node *xmlNode
+	text string
+}
+
+// parseXMLTree разбирает документ. DTD не принимается: утверждению SAML она не нужна,
+// а объявления сущностей - известный способ атак на разбор XML.
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root, current *xmlNode
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("некорректный XML: %v", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			if current == nil && root != nil {
+				return nil, fmt.Errorf("некорректный XML: несколько корневых элементов")
+			}
+			node := &xmlNode{parent: current, prefix: token.Name.Space, local: token.Name.Local, declared: make(map[string]string)}
+			for _, attr := range token.Attr {
+				switch {
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					node.declared[""] = attr.Value
+				case attr.Name.Space == "xmlns":
+					node.declared[attr.Name.Local] = attr.Value
+				default:
+					node.attrs = append(node.attrs, xmlAttr{prefix: attr.Name.Space, local: attr.Name.Local, value: attr.Value})
+				}
+			}
+			if current == nil {
+				root = node
+			} else {
+				current.children = append(current.children, xmlContent{node: node})
+			}
+			current = node
+		case xml.EndElement:
+			if current == nil || token.Name.Space != current.prefix || token.Name.Local != current.local {
+				return nil, fmt.Errorf("некорректный XML: закрывающий тег %s не соответствует открывающему", token.Name.Local)
+			}
+			current = current.parent
+		case xml.CharData:
+			if current != nil {
+				current.children = append(current.children, xmlContent{text: string(token)})
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("DTD и объявления сущностей не допускаются")
+		}
+	}
+	if root == nil || current != nil {
+		return nil, fmt.Errorf("некорректный XML: документ не завершен")
+	}
+	return root, root.resolve()
+}
+
+// resolve определяет URI пространств имен элемента, атрибутов и потомков
+func (n *xmlNode) resolve() error {
+	space, ok := n.lookup(n.prefix)
+	if !ok {
+		return fmt.Errorf("некорректный XML: не объявлен префикс %s", n.prefix)
+	}
+	n.space = space
+	for i := range n.attrs {
+		if n.attrs[i].prefix == "" {
+			continue // Атрибуты без префикса не принадлежат пространству имен
+		}
+		if n.attrs[i].space, ok = n.lookup(n.attrs[i].prefix); !ok {
+			return fmt.Errorf("некорректный XML: не объявлен префикс %s", n.attrs[i].prefix)
+		}
+	}
+	for _, child := range n.children {
+		if child.node != nil {
+			if err := child.node.resolve(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lookup возвращает URI пространства имен префикса в области видимости элемента
+func (n *xmlNode) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlNamespaceURI, true
+	}
+	for node := n; node != nil; node = node.parent {
+		if space, ok := node.declared[prefix]; ok {
+			return space, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// attr возвращает значение атрибута без пространства имен
+func (n *xmlNode) attr(name string) string {
+	for _, attr := range n.attrs {
+		if attr.space == "" && attr.local == name {
+			return attr.value
+		}
+	}
+	return ""
+}
+
+// child возвращает первый дочерний элемент с указанным именем
+func (n *xmlNode) child(space, local string) *xmlNode {
+	for _, child := range n.childNodes(space, local) {
+		return child
+	}
+	return nil
+}
+
+// childNodes возвращает дочерние элементы с указанным именем
+func (n *xmlNode) childNodes(space, local string) []*xmlNode {
+	var nodes []*xmlNode
+	for _, child := range n.children {
+		if child.node != nil && child.node.space == space && child.node.local == local {
+			nodes = append(nodes, child.node)
+		}
+	}
+	return nodes
+}
+
+// text возвращает текст элемента без начальных и конечных пробелов
+func (n *xmlNode) text() string {
+	var text strings.Builder
+	for _, child := range n.children {
+		if child.node == nil {
+			text.WriteString(child.text)
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// walk обходит элемент и всех его потомков
+func (n *xmlNode) walk(fn func(node *xmlNode)) {
+	fn(n)
+	for _, child := range n.children {
+		if child.node != nil {
+			child.node.walk(fn)
+		}
+	}
+}
+
+// canonicalize записывает элемент в исключающей канонической форме. Объявление
+// пространства имен выводится на элементе, который его использует (или для префиксов
+// inclusive - на каждом, где оно в области видимости), если ближайший выведенный предок
+// не объявил тот же URI. Элемент exclude (вложенная подпись) пропускается.
+func (n *xmlNode) canonicalize(out *bytes.Buffer, rendered map[string]string, inclusive map[string]bool, exclude *xmlNode) {
+	used := map[string]bool{n.prefix: true}
+	for _, attr := range n.attrs {
+		if attr.prefix != "" && attr.prefix != "xml" {
+			used[attr.prefix] = true
+		}
+	}
+	for prefix := range inclusive {
+		if _, inScope := n.lookup(prefix); inScope {
+			used[prefix] = true
+		}
+	}
+
+	var prefixes []string
+	scope := make(map[string]string, len(rendered)+len(used))
+	for prefix, space := range rendered {
+		scope[prefix] = space
+	}
+	for prefix := range used {
+		space, _ := n.lookup(prefix)
+		if current, ok := rendered[prefix]; ok && current == space || !ok && prefix == "" && space == "" {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+		scope[prefix] = space
+	}
+	sort.Strings(prefixes)
+
+	out.WriteString("<" + qualifiedName(n.prefix, n.local))
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			out.WriteString(` xmlns="` + escapeC14NAttr(scope[prefix]) + `"`)
+		} else {
+			out.WriteString(" xmlns:" + prefix + `="` + escapeC14NAttr(scope[prefix]) + `"`)
+		}
+	}
+	attrs := append([]xmlAttr(nil), n.attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+	for _, attr := range attrs {
+		out.WriteString(" " + qualifiedName(attr.prefix, attr.local) + `="` + escapeC14NAttr(attr.value) + `"`)
+	}
+	out.WriteString(">")
+
+	for _, child := range n.children {
+		switch {
+		case child.node == nil:
+			out.WriteString(escapeC14NText(child.text))
+		case child.node != exclude:
+			child.node.canonicalize(out, scope, inclusive, exclude)
+		}
+	}
+	out.WriteString("</" + qualifiedName(n.prefix, n.local) + ">")
+}
+
+// qualifiedName возвращает имя с префиксом
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// escapeC14NText экранирует текст по правилам канонизации
+func escapeC14NText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;").Replace(text)
+}
+
+// escapeC14NAttr экранирует значение атрибута по правилам канонизации
+func escapeC14NAttr(value string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;").Replace(value)
+}
+
+// exclusiveC14N возвращает каноническую форму элемента; method - элемент
+// CanonicalizationMethod или Transform, в котором может быть список InclusiveNamespaces
+func exclusiveC14N(n *xmlNode, method *xmlNode, exclude *xmlNode) []byte {
+	inclusive := make(map[string]bool)
+	if method != nil {
+		if list := method.child(excC14NAlgorithm, "InclusiveNamespaces"); list != nil {
+			for _, prefix := range strings.Fields(list.attr("PrefixList")) {
+				if prefix == "#default" {
+					prefix = ""
+				}
+				inclusive[prefix] = true
+			}
+		}
+	}
+	var out bytes.Buffer
+	n.canonicalize(&out, map[string]string{}, inclusive, exclude)
+	return out.Bytes()
+}
+
+// verifyEnvelopedSignature проверяет подпись XML-DSig, вложенную в элемент signed, открытым
+// ключом сертификата cert. Подпись должна ссылаться на сам элемент по его атрибуту ID:
+// иначе подписанным мог бы оказаться другой фрагмент документа. Сертификат из KeyInfo
+// подписи не используется - только заданный в настройках.
+func verifyEnvelopedSignature(signed *xmlNode, cert *x509.Certificate) error {
+	signatures := signed.childNodes(dsigNS, "Signature")
+	if len(signatures) != 1 {
+		return fmt.Errorf("элемент %s не подписан", signed.local)
+	}
+	signature := signatures[0]
+	signedInfo := signature.child(dsigNS, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("в подписи нет SignedInfo")
+	}
+	c14nMethod := signedInfo.child(dsigNS, "CanonicalizationMethod")
+	if c14nMethod == nil || c14nMethod.attr("Algorithm") != excC14NAlgorithm {
+		return fmt.Errorf("канонизация подписи не поддерживается (нужна %s)", excC14NAlgorithm)
+	}
+	signatureMethod := signedInfo.child(dsigNS, "SignatureMethod")
+	if signatureMethod == nil {
+		return fmt.Errorf("в подписи нет SignatureMethod")
+	}
+	method, ok := xmlSignatureMethods[signatureMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("алгоритм подписи %s не поддерживается", signatureMethod.attr("Algorithm"))
+	}
+
+	references := signedInfo.childNodes(dsigNS, "Reference")
+	if len(references) != 1 {
+		return fmt.Errorf("подпись должна содержать одну ссылку, а не %d", len(references))
+	}
+	reference := references[0]
+	if id := signed.attr("ID"); id == "" || reference.attr("URI") != "#"+id {
+		return fmt.Errorf("подпись относится не к элементу %s", signed.local)
+	}
+	var transform *xmlNode
+	if transforms := reference.child(dsigNS, "Transforms"); transforms != nil {
+		for _, t := range transforms.childNodes(dsigNS, "Transform") {
+			switch t.attr("Algorithm") {
+			case envelopedSigAlgo:
+			case excC14NAlgorithm:
+				transform = t
+			default:
+				return fmt.Errorf("преобразование %s не поддерживается", t.attr("Algorithm"))
+			}
+		}
+	}
+	if transform == nil {
+		return fmt.Errorf("ссылка подписи должна использовать канонизацию %s", excC14NAlgorithm)
+	}
+
+	digestMethod := reference.child(dsigNS, "DigestMethod")
+	digestValue := reference.child(dsigNS, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return fmt.Errorf("в ссылке подписи нет DigestMethod или DigestValue")
+	}
+	digestHash, ok := xmlDigestMethods[digestMethod.attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("алгоритм хеширования %s не поддерживается", digestMethod.attr("Algorithm"))
+	}
+	expected, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(digestValue.text()), ""))
+	if err != nil {
+		return fmt.Errorf("некорректный DigestValue: %v", err)
+	}
+	if subtle.ConstantTimeCompare(hashXML(digestHash, exclusiveC14N(signed, transform, signature)), expected) != 1 {
+		return fmt.Errorf("подписанный элемент изменен: хеш не совпадает")
+	}
+
+	signatureValue := signature.child(dsigNS, "SignatureValue")
+	if signatureValue == nil {
+		return fmt.Errorf("в подписи нет SignatureValue")
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(signatureValue.text()), ""))
+	if err != nil {
+		return fmt.Errorf("некорректный SignatureValue: %v", err)
+	}
+	hashed := hashXML(method.hash, exclusiveC14N(signedInfo, c14nMethod, nil))
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if method.ecdsa {
+			return fmt.Errorf("алгоритм подписи не соответствует ключу RSA")
+		}
+		if err := rsa.VerifyPKCS1v15(key, method.hash, hashed, value); err != nil {
+			return fmt.Errorf("неверная подпись")
+		}
+	case *ecdsa.PublicKey:
+		// Подпись ECDSA в XML-DSig - r и s одинаковой длины подряд
+		if !method.ecdsa || len(value) == 0 || len(value)%2 != 0 {
+			return fmt.Errorf("алгоритм подписи не соответствует ключу ECDSA")
+		}
+		r := new(big.Int).SetBytes(value[:len(value)/2])
+		s := new(big.Int).SetBytes(value[len(value)/2:])
+		if !ecdsa.Verify(key, hashed, r, s) {
+			return fmt.Errorf("неверная подпись")
+		}
+	default:
+		return fmt.Errorf("тип ключа сертификата не поддерживается")
+	}
+	return nil
+}
+
+// hashXML вычисляет хеш канонической формы
+func hashXML(hash crypto.Hash, data []byte) []byte {
+	switch hash {
+	case crypto.SHA512:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
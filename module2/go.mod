@@ -2,4 +2,7 @@ module ib2-password-security
 
 go 1.21
 
-require golang.org/x/crypto v0.15.0
+require (
+	github.com/jung-kurt/gofpdf v1.16.2
+	golang.org/x/crypto v0.15.0
+)
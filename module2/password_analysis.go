@@ -1,54 +1,140 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+
+	"github.com/jung-kurt/gofpdf"
 )
 
 // Структура для хранения исходных данных варианта
 type PasswordTask struct {
-	Variant     int     // Номер варианта
-	Probability float64 // Вероятность подбора пароля (P)
-	Speed       float64 // Скорость перебора в единицах времени (V)
-	SpeedUnit   string  // Единица измерения скорости
-	Time        float64 // Максимальный срок действия пароля (T)
-	TimeUnit    string  // Единица измерения времени
+	Variant     int     `json:"variant,omitempty"` // Номер варианта
+	Probability float64 `json:"probability"`       // Вероятность подбора пароля (P)
+	Speed       float64 `json:"speed"`             // Скорость перебора в единицах времени (V)
+	SpeedUnit   string  `json:"speed_unit"`        // Единица измерения скорости
+	Time        float64 `json:"time"`              // Максимальный срок действия пароля (T)
+	TimeUnit    string  `json:"time_unit"`         // Единица измерения времени
 }
 
 // Структура для результатов расчёта
 type PasswordAnalysis struct {
-	Task           PasswordTask
-	SpeedPerMinute float64 // Скорость в паролях/минуту
-	TimeInMinutes  float64 // Время в минутах
-	LowerBound     float64 // Нижняя граница S*
-	Combinations   []AlphabetCombination
+	Task           PasswordTask          `json:"task"`
+	SpeedPerMinute float64               `json:"speed_per_minute"` // Скорость в паролях/минуту
+	TimeInMinutes  float64               `json:"time_in_minutes"`  // Время в минутах
+	LowerBound     *big.Int              `json:"lower_bound"`      // Нижняя граница S*
+	Combinations   []AlphabetCombination `json:"combinations"`
+	Sweep          []SweepRow            `json:"sweep,omitempty"` // Таблица L × A (заполняется по запросу)
+}
+
+// Строка таблицы L × A для одного алфавита
+type SweepRow struct {
+	AlphabetSize int         `json:"alphabet_size"`
+	AlphabetName string      `json:"alphabet_name"`
+	Cells        []SweepCell `json:"cells"`
+}
+
+// Ячейка таблицы L × A
+type SweepCell struct {
+	Length           int      `json:"length"`             // Длина пароля L
+	TotalPasswords   *big.Int `json:"total_passwords"`    // S = A^L
+	Probability      float64  `json:"probability"`        // Вероятность подбора за срок T: V*T/S
+	CrackTimeMinutes BigFloat `json:"crack_time_minutes"` // Время полного перебора S/V
+	Sufficient       bool     `json:"sufficient"`         // S >= S*
 }
 
 // Структура для комбинаций алфавита и длины
 type AlphabetCombination struct {
-	AlphabetSize    int     // Мощность алфавита A
-	AlphabetName    string  // Описание алфавита
-	MinLength       int     // Минимальная длина L
-	TotalPasswords  float64 // Общее количество паролей S = A^L
-	SecurityMargin  float64 // Запас безопасности
+	AlphabetSize   int      `json:"alphabet_size"`   // Мощность алфавита A
+	AlphabetName   string   `json:"alphabet_name"`   // Описание алфавита
+	MinLength      int      `json:"min_length"`      // Минимальная длина L
+	TotalPasswords *big.Int `json:"total_passwords"` // Общее количество паролей S = A^L
+	SecurityMargin BigFloat `json:"security_margin"` // Запас безопасности S/S*
+	Charset        string   `json:"-"`               // Символы алфавита (пусто, если задана только мощность)
+}
+
+// Модель атакующего: название и скорость перебора
+type AttackerProfile struct {
+	ID             string  `json:"id"`
+	Name           string  `json:"name"`
+	SpeedPerMinute float64 `json:"speed_per_minute"` // Паролей в минуту
+	Description    string  `json:"description,omitempty"`
+}
+
+// Профиль атакующего в файле профилей (общий формат с модулем 1)
+type attackerPreset struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	GuessesPerSecond float64 `json:"guesses_per_second"`
+	Description      string  `json:"description,omitempty"`
+}
+
+// Оценка времени подбора конкретного пароля
+type CrackEstimate struct {
+	Profile          AttackerProfile `json:"profile"`
+	AlphabetSize     int             `json:"alphabet_size"`      // Мощность алфавита по классам символов
+	Length           int             `json:"length"`             // Длина пароля L
+	TotalPasswords   *big.Int        `json:"total_passwords"`    // S = A^L
+	CrackTimeMinutes BigFloat        `json:"crack_time_minutes"` // Среднее время подбора S/(2V)
 }
 
+// Вещественное число без ограничения порядка: A^L для длинных паролей не помещается в float64.
+// В JSON выводится числом в экспоненциальной записи.
+type BigFloat struct {
+	*big.Float
+}
+
+// Точность вычислений с BigFloat, бит
+const bigPrecision = 256
+
+// Наибольшая длина пароля, для которой подбирается алфавит
+const maxPasswordLength = 64
+
+// Типовые профили атакующего. Файл общий для анализа и оценки стойкости в модуле 1:
+// профили меняются только в нем, копия в модуле 1 обновляется командой go generate
+//
+//go:embed attacker_profiles.json
+var attackerProfilesData []byte
+
+// Профили атакующего: типовые и добавленные флагом -attacker-profiles
+var attackerProfiles = mustParseAttackerProfiles(attackerProfilesData)
+
+// Наборы символов алфавитов
+const (
+	upperChars   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	lowerChars   = "abcdefghijklmnopqrstuvwxyz"
+	digitChars   = "0123456789"
+	printableSet = " !\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~" // Пробел и 32 спецсимвола ASCII
+)
+
 // Предопределённые алфавиты
 var alphabets = []struct {
-	Size int
-	Name string
+	Size    int
+	Name    string
+	Charset string
 }{
-	{26, "Только строчные английские буквы (a-z)"},
-	{52, "Английские буквы (A-Z, a-z)"},
-	{62, "Английские буквы + цифры (A-Z, a-z, 0-9)"},
-	{95, "Полный ASCII набор (буквы, цифры, спецсимволы)"},
-	{36, "Строчные английские буквы + цифры (a-z, 0-9)"},
-	{10, "Только цифры (0-9)"},
+	{26, "Только строчные английские буквы (a-z)", lowerChars},
+	{52, "Английские буквы (A-Z, a-z)", upperChars + lowerChars},
+	{62, "Английские буквы + цифры (A-Z, a-z, 0-9)", upperChars + lowerChars + digitChars},
+	{95, "Полный ASCII набор (буквы, цифры, спецсимволы)", upperChars + lowerChars + digitChars + printableSet},
+	{36, "Строчные английские буквы + цифры (a-z, 0-9)", lowerChars + digitChars},
+	{10, "Только цифры (0-9)", digitChars},
 }
 
-// Таблица вариантов заданий
-var variants = []PasswordTask{
+// Опубликованная часть таблицы вариантов заданий
+var baseVariants = []PasswordTask{
 	{1, 1e-4, 15, "паролей/мин", 2, "недели"},
 	{2, 1e-5, 3, "паролей/мин", 10, "дней"},
 	{3, 1e-6, 10, "паролей/мин", 5, "дней"},
@@ -59,20 +145,226 @@ var variants = []PasswordTask{
 	{8, 1e-7, 15, "паролей/мин", 20, "дней"},
 	{9, 1e-4, 3, "паролей/мин", 15, "дней"},
 	{10, 1e-5, 10, "паролей/мин", 1, "неделя"},
-	// ... можно добавить остальные варианты
+}
+
+// Число вариантов в полной таблице
+const variantCount = 30
+
+// Таблица вариантов заданий (заменяется флагом -variants-csv)
+var variants = generateVariants(variantCount)
+
+// Шрифты TrueType с кириллицей, которые ищутся для экспорта в PDF
+var pdfFontCandidates = []string{
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+	"/usr/share/fonts/truetype/liberation/LiberationSans-Regular.ttf",
+	"/usr/share/fonts/liberation-sans/LiberationSans-Regular.ttf",
+	"/Library/Fonts/Arial Unicode.ttf",
+	"/System/Library/Fonts/Supplemental/Arial.ttf",
+	`C:\Windows\Fonts\arial.ttf`,
+}
+
+// Решение варианта для экспорта в Markdown и PDF
+type Solution struct {
+	Title  string
+	Inputs [][2]string // Исходные данные: параметр и значение
+	Steps  []string    // Шаги расчета
+	Table  [][]string  // Пары алфавит/длина, первая строка - заголовок
+	Choice string      // Выбранные A и L
 }
 
 func main() {
+	probability := flag.Float64("p", 0, "вероятность подбора пароля P (например 1e-6)")
+	speed := flag.Float64("v", 0, "скорость перебора V")
+	speedUnit := flag.String("speed-unit", "паролей/мин", "единица скорости (паролей/мин, паролей в час, паролей/день, passwords/s, guesses per hour)")
+	lifetime := flag.Float64("t", 0, "срок действия пароля T")
+	timeUnit := flag.String("time-unit", "дней", "единица времени (минут, часов, дней, недель, месяцев, лет; min, h, d, wk, mo, yr)")
+	variantNum := flag.Int("variant", 0, "номер варианта из таблицы вместо P, V, T")
+	alphabetSize := flag.Int("alphabet", 0, "мощность своего алфавита A (добавляется к стандартным)")
+	charset := flag.String("charset", "", "свой набор символов (мощность считается по уникальным символам)")
+	sweep := flag.Bool("sweep", false, "вывести полную таблицу L × A и график времени перебора")
+	minLength := flag.Int("min-length", 4, "минимальная длина L в таблице")
+	maxLength := flag.Int("max-length", 16, "максимальная длина L в таблице")
+	format := flag.String("format", "table", "формат вывода: table или json")
+	check := flag.String("check", "", "оценить время подбора указанного пароля для типовых атакующих")
+	variantsCSV := flag.String("variants-csv", "", "таблица вариантов из CSV: номер, P, V, единица скорости, T, единица времени")
+	listVariants := flag.Bool("list-variants", false, "вывести таблицу вариантов")
+	export := flag.String("export", "", "сохранить решение в Markdown (.md) или PDF (.pdf); без параметров расчета - решения всех вариантов")
+	pdfFont := flag.String("pdf-font", "", "шрифт TrueType с кириллицей для PDF (по умолчанию ищутся DejaVu Sans, Liberation Sans, Arial)")
+	profilesPath := flag.String("attacker-profiles", "", "свои профили атакующего в формате attacker_profiles.json (профиль с тем же id заменяет типовой)")
+	attacker := flag.String("attacker", "", "скорость перебора V из профиля атакующего (id из -list-profiles) вместо -v")
+	listProfiles := flag.Bool("list-profiles", false, "вывести профили атакующего")
+	flag.Parse()
+
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "неизвестный формат вывода: %s\n", *format)
+		os.Exit(2)
+	}
+
+	if *profilesPath != "" {
+		custom, err := loadAttackerProfiles(*profilesPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		attackerProfiles = mergeAttackerProfiles(attackerProfiles, custom)
+	}
+
+	if *listProfiles {
+		if *format == "json" {
+			data, err := json.MarshalIndent(attackerProfiles, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка формирования JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printAttackerProfiles()
+		return
+	}
+
+	if *variantsCSV != "" {
+		loaded, err := loadVariantsCSV(*variantsCSV)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		variants = loaded
+	}
+
+	if *listVariants {
+		if *format == "json" {
+			data, err := json.MarshalIndent(variants, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка формирования JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printVariants()
+		return
+	}
+
+	if *check != "" {
+		estimates := estimateCrackTimes(*check)
+		if *format == "json" {
+			data, err := json.MarshalIndent(estimates, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ошибка формирования JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		printCrackEstimates(estimates)
+		return
+	}
+
+	if *minLength < 1 || *maxLength < *minLength {
+		fmt.Fprintln(os.Stderr, "некорректный диапазон длин для таблицы")
+		os.Exit(2)
+	}
+
+	// Свой алфавит добавляется к стандартным
+	if *charset != "" {
+		unique := uniqueRunes(*charset)
+		addCustomAlphabet(len([]rune(unique)), fmt.Sprintf("Свой набор символов: %s", truncate(unique, 20)), unique)
+	} else if *alphabetSize > 1 {
+		addCustomAlphabet(*alphabetSize, fmt.Sprintf("Свой алфавит (A = %d)", *alphabetSize), "")
+	} else if *alphabetSize != 0 {
+		fmt.Fprintln(os.Stderr, "мощность алфавита должна быть больше 1")
+		os.Exit(2)
+	}
+
+	// Параметры заданы флагами - считаем без диалога
+	if *variantNum != 0 || *probability != 0 || *speed != 0 || *lifetime != 0 || *attacker != "" {
+		task := PasswordTask{
+			Probability: *probability,
+			Speed:       *speed,
+			SpeedUnit:   *speedUnit,
+			Time:        *lifetime,
+			TimeUnit:    *timeUnit,
+		}
+		if *variantNum != 0 {
+			found, ok := findVariant(*variantNum)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "вариант %d не найден в таблице\n", *variantNum)
+				os.Exit(2)
+			}
+			task = found
+		}
+		if *attacker != "" {
+			// Скорость профиля заменяет V варианта: тот же вариант против другого атакующего
+			profile, ok := findAttackerProfile(*attacker)
+			switch {
+			case !ok:
+				fmt.Fprintf(os.Stderr, "профиль атакующего %q не найден (список: -list-profiles)\n", *attacker)
+				os.Exit(2)
+			case *speed != 0:
+				fmt.Fprintln(os.Stderr, "скорость задается либо -v, либо -attacker")
+				os.Exit(2)
+			}
+			task.Speed, task.SpeedUnit = profile.SpeedPerMinute/60, "паролей/с"
+		}
+
+		if err := validateTask(task); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+
+		analysis := analyzePasswordSecurity(task)
+		if *sweep {
+			analysis.Sweep = sweepAlphabets(analysis, *minLength, *maxLength)
+		}
+		if *export != "" {
+			exportSolutions(*export, *pdfFont, []PasswordAnalysis{analysis})
+			return
+		}
+		if *format == "json" {
+			if err := printJSON(analysis); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		printTask(task)
+		printResults(analysis)
+		if *sweep {
+			printSweep(analysis)
+			printCrackTimeChart(analysis)
+		}
+		return
+	}
+
+	// Экспорт без параметров расчета - решения всех вариантов таблицы
+	if *export != "" {
+		var analyses []PasswordAnalysis
+		for _, task := range variants {
+			analyses = append(analyses, analyzePasswordSecurity(task))
+		}
+		exportSolutions(*export, *pdfFont, analyses)
+		return
+	}
+
 	fmt.Println("=== КОЛИЧЕСТВЕННАЯ ОЦЕНКА СТОЙКОСТИ ПАРОЛЕЙ ===")
 	fmt.Println()
 
 	// Выбор варианта
-	var variantNum int
-	fmt.Print("Введите номер варианта (1-30): ")
-	fmt.Scanf("%d", &variantNum)
+	var choice int
+	fmt.Printf("Введите номер варианта (%d-%d) или 0 для своих параметров: ", variants[0].Variant, variants[len(variants)-1].Variant)
+	fmt.Scanf("%d", &choice)
 
-	if variantNum < 1 || variantNum > len(variants) {
-		fmt.Printf("❌ Вариант %d не найден в таблице\n", variantNum)
+	if choice == 0 {
+		customCalculation()
+		return
+	}
+
+	task, ok := findVariant(choice)
+	if !ok {
+		fmt.Printf("❌ Вариант %d не найден в таблице\n", choice)
 		fmt.Println("Доступные варианты:")
 		for _, v := range variants {
 			fmt.Printf("Вариант %d: P=%.0e, V=%.0f %s, T=%.0f %s\n", 
@@ -81,11 +373,7 @@ func main() {
 		return
 	}
 
-	task := variants[variantNum-1]
-	fmt.Printf("\n📋 Выбран вариант %d:\n", task.Variant)
-	fmt.Printf("   P = %.0e (вероятность подбора)\n", task.Probability)
-	fmt.Printf("   V = %.0f %s (скорость перебора)\n", task.Speed, task.SpeedUnit)
-	fmt.Printf("   T = %.0f %s (срок действия пароля)\n", task.Time, task.TimeUnit)
+	printTask(task)
 
 	// Выполняем анализ
 	analysis := analyzePasswordSecurity(task)
@@ -97,18 +385,58 @@ func main() {
 	generatePasswordExample(analysis)
 }
 
+// Вывод исходных данных задачи
+func printTask(task PasswordTask) {
+	if task.Variant > 0 {
+		fmt.Printf("\n📋 Выбран вариант %d:\n", task.Variant)
+	} else {
+		fmt.Println("\n📋 Исходные данные:")
+	}
+	fmt.Printf("   P = %.0e (вероятность подбора)\n", task.Probability)
+	fmt.Printf("   V = %g %s (скорость перебора)\n", task.Speed, task.SpeedUnit)
+	fmt.Printf("   T = %g %s (срок действия пароля)\n", task.Time, task.TimeUnit)
+}
+
+// Проверка корректности исходных данных
+func validateTask(task PasswordTask) error {
+	if task.Probability <= 0 || task.Probability >= 1 {
+		return fmt.Errorf("вероятность P должна быть в интервале (0, 1)")
+	}
+	if task.Speed <= 0 {
+		return fmt.Errorf("скорость перебора V должна быть положительной")
+	}
+	if task.Time <= 0 {
+		return fmt.Errorf("срок действия T должен быть положительным")
+	}
+	if _, err := parseSpeedUnit(task.SpeedUnit); err != nil {
+		return err
+	}
+	if _, err := parseTimeUnit(task.TimeUnit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Вывод результатов анализа в формате JSON
+func printJSON(analysis PasswordAnalysis) error {
+	data, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка формирования JSON: %v", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
 // Функция анализа безопасности пароля
 func analyzePasswordSecurity(task PasswordTask) PasswordAnalysis {
 	analysis := PasswordAnalysis{Task: task}
 	
-	// Конвертируем скорость в пароли/минуту
-	analysis.SpeedPerMinute = convertToPerMinute(task.Speed, task.SpeedUnit)
-	
-	// Конвертируем время в минуты
-	analysis.TimeInMinutes = convertToMinutes(task.Time, task.TimeUnit)
+	// Конвертируем скорость в пароли/минуту и время в минуты (единицы проверены validateTask)
+	analysis.SpeedPerMinute, _ = convertToPerMinute(task.Speed, task.SpeedUnit)
+	analysis.TimeInMinutes, _ = convertToMinutes(task.Time, task.TimeUnit)
 	
 	// Вычисляем нижнюю границу S*
-	analysis.LowerBound = math.Ceil((analysis.SpeedPerMinute * analysis.TimeInMinutes) / task.Probability)
+	analysis.LowerBound = lowerBound(task)
 	
 	// Ищем подходящие комбинации алфавита и длины
 	analysis.Combinations = findAlphabetCombinations(analysis.LowerBound)
@@ -116,56 +444,316 @@ func analyzePasswordSecurity(task PasswordTask) PasswordAnalysis {
 	return analysis
 }
 
-// Конвертация скорости в пароли/минуту
-func convertToPerMinute(speed float64, unit string) float64 {
+// Добавление своего алфавита к стандартным
+func addCustomAlphabet(size int, name, charset string) {
+	alphabets = append(alphabets, struct {
+		Size    int
+		Name    string
+		Charset string
+	}{size, name, charset})
+}
+
+// Уникальные символы набора в порядке первого появления
+func uniqueRunes(charset string) string {
+	seen := make(map[rune]bool)
+	var unique []rune
+	for _, r := range charset {
+		if !seen[r] {
+			seen[r] = true
+			unique = append(unique, r)
+		}
+	}
+	return string(unique)
+}
+
+// Обрезка строки до заданного числа символов
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit]) + "..."
+}
+
+// Полная таблица L × A: для каждого алфавита и длины - S, вероятность подбора и время перебора
+func sweepAlphabets(analysis PasswordAnalysis, minLength, maxLength int) []SweepRow {
+	var rows []SweepRow
+
+	for _, alphabet := range alphabets {
+		row := SweepRow{AlphabetSize: alphabet.Size, AlphabetName: alphabet.Name}
+		for length := minLength; length <= maxLength; length++ {
+			total := passwordCount(alphabet.Size, length)
+			probability, _ := bigQuo(bigFromFloat(analysis.SpeedPerMinute*analysis.TimeInMinutes), bigFromInt(total)).Float64()
+
+			row.Cells = append(row.Cells, SweepCell{
+				Length:           length,
+				TotalPasswords:   total,
+				Probability:      math.Min(1, probability),
+				CrackTimeMinutes: bigQuo(bigFromInt(total), bigFromFloat(analysis.SpeedPerMinute)),
+				Sufficient:       total.Cmp(analysis.LowerBound) >= 0,
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// Вывод таблицы L × A с вероятностью подбора за срок T
+func printSweep(analysis PasswordAnalysis) {
+	if len(analysis.Sweep) == 0 {
+		return
+	}
+
+	fmt.Println("\n ВЕРОЯТНОСТЬ ПОДБОРА ЗА СРОК T (строки - A, столбцы - L):")
+	fmt.Printf("   %5s", "A \\ L")
+	for _, cell := range analysis.Sweep[0].Cells {
+		fmt.Printf(" %8d", cell.Length)
+	}
+	fmt.Println()
+
+	for _, row := range analysis.Sweep {
+		fmt.Printf("   %5d", row.AlphabetSize)
+		for _, cell := range row.Cells {
+			mark := " "
+			if cell.Sufficient {
+				mark = "*"
+			}
+			fmt.Printf(" %7.0e%s", cell.Probability, mark)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("   * - вероятность не превышает P = %.0e\n", analysis.Task.Probability)
+}
+
+// Вывод графика времени полного перебора в логарифмическом масштабе
+func printCrackTimeChart(analysis PasswordAnalysis) {
+	const width = 50
+
+	for _, row := range analysis.Sweep {
+		if len(row.Cells) == 0 {
+			continue
+		}
+
+		// Масштаб по десятичному логарифму времени перебора от 1 минуты до максимума в строке
+		maxLog := math.Max(row.Cells[len(row.Cells)-1].CrackTimeMinutes.Log10(), 1)
+		lifetimeMark := int(math.Round(math.Log10(math.Max(analysis.TimeInMinutes, 1)) / maxLog * width))
+
+		fmt.Printf("\n ВРЕМЯ ПОЛНОГО ПЕРЕБОРА: %s (A = %d)\n", row.AlphabetName, row.AlphabetSize)
+		for _, cell := range row.Cells {
+			bar := int(math.Round(math.Max(cell.CrackTimeMinutes.Log10(), 0) / maxLog * width))
+			line := []rune(strings.Repeat("█", bar) + strings.Repeat(" ", width-bar))
+			if lifetimeMark >= 0 && lifetimeMark < width {
+				if line[lifetimeMark] == ' ' {
+					line[lifetimeMark] = '│'
+				} else {
+					line[lifetimeMark] = '┃'
+				}
+			}
+			fmt.Printf("   L=%2d %s %s\n", cell.Length, string(line), formatMinutes(cell.CrackTimeMinutes))
+		}
+		fmt.Printf("   │ - срок действия пароля T (%g %s)\n", analysis.Task.Time, analysis.Task.TimeUnit)
+	}
+}
+
+// Перевод минут в удобочитаемую длительность
+func formatMinutes(duration BigFloat) string {
+	minutes, _ := duration.Float64()
 	switch {
-	case strings.Contains(unit, "мин"):
-		return speed
-	case strings.Contains(unit, "день"):
-		return speed / (24 * 60) // паролей в день -> паролей в минуту
-	case strings.Contains(unit, "час"):
-		return speed / 60 // паролей в час -> паролей в минуту
+	case minutes < 60:
+		return fmt.Sprintf("%.1f мин", minutes)
+	case minutes < 24*60:
+		return fmt.Sprintf("%.1f ч", minutes/60)
+	case minutes < 365*24*60:
+		return fmt.Sprintf("%.1f дн", minutes/(24*60))
 	default:
-		return speed // по умолчанию считаем что уже в минутах
+		return bigQuo(duration, bigFromFloat(365*24*60)).Text('e', 2) + " лет"
+	}
+}
+
+// Мощность алфавита по классам символов, встречающимся в пароле
+func passwordAlphabetSize(password string) int {
+	classes := []string{upperChars, lowerChars, digitChars, printableSet}
+	used := make([]bool, len(classes))
+	other := false
+
+	for _, r := range password {
+		found := false
+		for i, class := range classes {
+			if strings.ContainsRune(class, r) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			other = true
+		}
+	}
+
+	size := 0
+	for i, class := range classes {
+		if used[i] {
+			size += len(class)
+		}
+	}
+	if other {
+		size += 66 // Кириллица в обоих регистрах
+	}
+	return size
+}
+
+// Оценка времени подбора пароля полным перебором для всех типовых атакующих
+func estimateCrackTimes(password string) []CrackEstimate {
+	alphabet := passwordAlphabetSize(password)
+	length := len([]rune(password))
+	total := passwordCount(alphabet, length)
+
+	var estimates []CrackEstimate
+	for _, profile := range attackerProfiles {
+		estimates = append(estimates, CrackEstimate{
+			Profile:          profile,
+			AlphabetSize:     alphabet,
+			Length:           length,
+			TotalPasswords:   total,
+			CrackTimeMinutes: bigQuo(bigFromInt(total), bigFromFloat(2*profile.SpeedPerMinute)),
+		})
+	}
+	return estimates
+}
+
+// Вывод оценок времени подбора пароля
+func printCrackEstimates(estimates []CrackEstimate) {
+	if len(estimates) == 0 {
+		return
+	}
+
+	fmt.Println("=== ОЦЕНКА ВРЕМЕНИ ПОДБОРА ПАРОЛЯ ===")
+	fmt.Printf("Мощность алфавита A = %d, длина L = %d, S = A^L = %s\n\n",
+		estimates[0].AlphabetSize, estimates[0].Length, formatScientific(estimates[0].TotalPasswords))
+	fmt.Printf("%-40s %18s %16s\n", "Атакующий", "Скорость, пар/мин", "Среднее время")
+	for _, estimate := range estimates {
+		fmt.Printf("%-40s %18.2e %16s\n", estimate.Profile.Name, estimate.Profile.SpeedPerMinute, formatMinutes(estimate.CrackTimeMinutes))
+	}
+}
+
+// Единицы времени и их длительность в секундах. Перечислены русские и английские названия
+// во всех употребительных формах и сокращения; "m" не принимается - это и минута, и месяц.
+var timeUnits = []struct {
+	Seconds int64
+	Names   []string
+}{
+	{1, []string{"с", "сек", "секунда", "секунды", "секунд", "секунду", "s", "sec", "secs", "second", "seconds"}},
+	{60, []string{"мин", "минута", "минуты", "минут", "минуту", "min", "mins", "minute", "minutes"}},
+	{60 * 60, []string{"ч", "час", "часа", "часов", "h", "hr", "hrs", "hour", "hours"}},
+	{24 * 60 * 60, []string{"д", "дн", "день", "дня", "дней", "сут", "сутки", "суток", "d", "day", "days"}},
+	{7 * 24 * 60 * 60, []string{"нед", "неделя", "недели", "недель", "неделю", "w", "wk", "wks", "week", "weeks"}},
+	{30 * 24 * 60 * 60, []string{"мес", "месяц", "месяца", "месяцев", "mo", "mos", "month", "months"}}, // примерно 30 дней
+	{365 * 24 * 60 * 60, []string{"г", "год", "года", "лет", "y", "yr", "yrs", "year", "years"}},
+}
+
+// Что перебирается: допустимые слова перед единицей времени в единице скорости
+var speedSubjects = []string{"", "п", "пар", "пароль", "пароля", "паролей", "попытка", "попытки", "попыток",
+	"password", "passwords", "pwd", "guess", "guesses", "try", "tries", "attempt", "attempts"}
+
+// Приведение названия единицы к виду из таблицы: нижний регистр, без точки сокращения
+func normalizeUnit(unit string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(unit)), ".")
+}
+
+// Длительность единицы времени в секундах
+func parseTimeUnit(unit string) (int64, error) {
+	name := normalizeUnit(unit)
+	for _, timeUnit := range timeUnits {
+		for _, known := range timeUnit.Names {
+			if name == known {
+				return timeUnit.Seconds, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("неизвестная единица времени %q (например: мин, ч, дней, недель, месяцев, min, h, d, wk, mo)", unit)
+}
+
+// Длительность единицы времени в единице скорости: "паролей/мин", "паролей в час",
+// "passwords per day", "guesses/s"
+func parseSpeedUnit(unit string) (int64, error) {
+	name := normalizeUnit(unit)
+	subject, period, found := "", "", false
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		subject, period, found = strings.TrimSpace(name[:index]), name[index+1:], true
+	} else {
+		words := strings.Fields(name)
+		for i, word := range words {
+			if word == "в" || word == "per" || word == "a" {
+				subject, period, found = strings.Join(words[:i], " "), strings.Join(words[i+1:], " "), true
+				break
+			}
+		}
 	}
+	if !found {
+		return 0, fmt.Errorf("неизвестная единица скорости %q (ожидается вид паролей/мин, паролей в час, passwords per day)", unit)
+	}
+
+	known := false
+	for _, word := range speedSubjects {
+		known = known || subject == word
+	}
+	if !known {
+		return 0, fmt.Errorf("неизвестная единица скорости %q: перебираются пароли или попытки, а не %q", unit, subject)
+	}
+	seconds, err := parseTimeUnit(period)
+	if err != nil {
+		return 0, fmt.Errorf("единица скорости %q: %v", unit, err)
+	}
+	return seconds, nil
+}
+
+// Конвертация скорости в пароли/минуту
+func convertToPerMinute(speed float64, unit string) (float64, error) {
+	seconds, err := parseSpeedUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+	return speed * 60 / float64(seconds), nil
 }
 
 // Конвертация времени в минуты
-func convertToMinutes(time float64, unit string) float64 {
-	switch {
-	case strings.Contains(unit, "мин"):
-		return time
-	case strings.Contains(unit, "час"):
-		return time * 60
-	case strings.Contains(unit, "день") || strings.Contains(unit, "дн"):
-		return time * 24 * 60
-	case strings.Contains(unit, "неделя") || strings.Contains(unit, "нед"):
-		return time * 7 * 24 * 60
-	case strings.Contains(unit, "месяц"):
-		return time * 30 * 24 * 60 // примерно 30 дней
-	default:
-		return time
+func convertToMinutes(time float64, unit string) (float64, error) {
+	seconds, err := parseTimeUnit(unit)
+	if err != nil {
+		return 0, err
 	}
+	return time * float64(seconds) / 60, nil
 }
 
 // Поиск подходящих комбинаций алфавита и длины
-func findAlphabetCombinations(lowerBound float64) []AlphabetCombination {
+func findAlphabetCombinations(lowerBound *big.Int) []AlphabetCombination {
 	var combinations []AlphabetCombination
 	
 	for _, alphabet := range alphabets {
-		// Находим минимальную длину для данного алфавита
-		minLength := int(math.Ceil(math.Log(lowerBound) / math.Log(float64(alphabet.Size))))
+		// Находим минимальную длину для данного алфавита: оценка по логарифму
+		// уточняется точным сравнением A^L с S*
+		minLength := int(math.Ceil(bigFromInt(lowerBound).Log10() / math.Log10(float64(alphabet.Size))))
+		if minLength < 1 {
+			minLength = 1
+		}
+		for minLength > 1 && passwordCount(alphabet.Size, minLength-1).Cmp(lowerBound) >= 0 {
+			minLength--
+		}
+		for minLength <= maxPasswordLength && passwordCount(alphabet.Size, minLength).Cmp(lowerBound) < 0 {
+			minLength++
+		}
 		
-		if minLength > 0 && minLength <= 20 { // разумные ограничения на длину
-			totalPasswords := math.Pow(float64(alphabet.Size), float64(minLength))
-			securityMargin := totalPasswords / lowerBound
+		if minLength <= maxPasswordLength { // разумные ограничения на длину
+			totalPasswords := passwordCount(alphabet.Size, minLength)
 			
 			combination := AlphabetCombination{
 				AlphabetSize:   alphabet.Size,
 				AlphabetName:   alphabet.Name,
 				MinLength:      minLength,
 				TotalPasswords: totalPasswords,
-				SecurityMargin: securityMargin,
+				SecurityMargin: bigQuo(bigFromInt(totalPasswords), bigFromInt(lowerBound)),
+				Charset:        alphabet.Charset,
 			}
 			
 			combinations = append(combinations, combination)
@@ -182,7 +770,7 @@ func printResults(analysis PasswordAnalysis) {
 	fmt.Printf("   Время действия: %.0f минут (%.2f дней)\n", 
 		analysis.TimeInMinutes, analysis.TimeInMinutes/(24*60))
 	
-	fmt.Printf("\n Нижняя граница S*: %.2e\n", analysis.LowerBound)
+	fmt.Printf("\n Нижняя граница S*: %s\n", formatScientific(analysis.LowerBound))
 	fmt.Printf("   (минимальное количество возможных паролей)\n")
 	
 	fmt.Println("\n РЕКОМЕНДУЕМЫЕ ПАРАМЕТРЫ ПАРОЛЕЙ:")
@@ -192,23 +780,16 @@ func printResults(analysis PasswordAnalysis) {
 	fmt.Println("├─────┼──────────────────────────────────────────┼────────┼─────────────┼─────────────┤")
 	
 	for _, combo := range analysis.Combinations {
-		fmt.Printf("│ %3d │ %-40s │ %6d │ %11.2e │ %11.2f │\n",
+		fmt.Printf("│ %3d │ %-40s │ %6d │ %11s │ %11.2f │\n",
 			combo.AlphabetSize,
 			combo.AlphabetName,
 			combo.MinLength,
-			combo.TotalPasswords,
+			formatScientific(combo.TotalPasswords),
 			combo.SecurityMargin)
 	}
 	fmt.Println("└─────┴──────────────────────────────────────────┴────────┴─────────────┴─────────────┘")
 	
-	if len(analysis.Combinations) > 0 {
-		best := analysis.Combinations[0]
-		for _, combo := range analysis.Combinations {
-			if combo.MinLength < best.MinLength {
-				best = combo
-			}
-		}
-		
+	if best, ok := optimalCombination(analysis); ok {
 		fmt.Printf("\n ОПТИМАЛЬНЫЙ ВЫБОР:\n")
 		fmt.Printf("   Алфавит: %s (A = %d)\n", best.AlphabetName, best.AlphabetSize)
 		fmt.Printf("   Минимальная длина пароля: %d символов\n", best.MinLength)
@@ -216,16 +797,38 @@ func printResults(analysis PasswordAnalysis) {
 	}
 }
 
+// Оптимальная комбинация - наименьшая длина; при равной длине - первый алфавит таблицы
+func optimalCombination(analysis PasswordAnalysis) (AlphabetCombination, bool) {
+	if len(analysis.Combinations) == 0 {
+		return AlphabetCombination{}, false
+	}
+	best := analysis.Combinations[0]
+	for _, combo := range analysis.Combinations {
+		if combo.MinLength < best.MinLength {
+			best = combo
+		}
+	}
+	return best, true
+}
+
 // Демонстрация генерации пароля
 func generatePasswordExample(analysis PasswordAnalysis) {
-	if len(analysis.Combinations) == 0 {
+	// Генерировать можно только для алфавитов с известным набором символов
+	var candidates []AlphabetCombination
+	for _, combo := range analysis.Combinations {
+		if combo.Charset != "" {
+			candidates = append(candidates, combo)
+		}
+	}
+
+	if len(candidates) == 0 {
 		fmt.Println(" Не удалось найти подходящие параметры для генерации")
 		return
 	}
 	
 	// Выбираем оптимальную комбинацию
-	best := analysis.Combinations[0]
-	for _, combo := range analysis.Combinations {
+	best := candidates[0]
+	for _, combo := range candidates {
 		if combo.AlphabetSize == 62 { // предпочитаем буквы + цифры
 			best = combo
 			break
@@ -237,7 +840,11 @@ func generatePasswordExample(analysis PasswordAnalysis) {
 	
 	// Генерируем несколько примеров паролей
 	for i := 1; i <= 5; i++ {
-		password := generateSecurePassword(best.AlphabetSize, best.MinLength)
+		password, err := generateSecurePassword(best.Charset, best.MinLength)
+		if err != nil {
+			fmt.Printf(" Ошибка при генерации пароля: %v\n", err)
+			return
+		}
 		fmt.Printf("   %d. %s\n", i, password)
 	}
 	
@@ -249,37 +856,25 @@ func generatePasswordExample(analysis PasswordAnalysis) {
 		analysis.Task.Time, analysis.Task.TimeUnit)
 }
 
-// Простой генератор паролей для демонстрации
-func generateSecurePassword(alphabetSize, length int) string {
-	var charset string
-	
-	switch alphabetSize {
-	case 10:
-		charset = "0123456789"
-	case 26:
-		charset = "abcdefghijklmnopqrstuvwxyz"
-	case 36:
-		charset = "abcdefghijklmnopqrstuvwxyz0123456789"
-	case 52:
-		charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	case 62:
-		charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	case 95:
-		charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
-	default:
-		charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+// Генератор паролей из символов алфавита на основе crypto/rand.
+// rand.Int дает равномерное распределение без смещения по модулю.
+func generateSecurePassword(charset string, length int) (string, error) {
+	charsetRunes := []rune(charset)
+	if len(charsetRunes) == 0 {
+		return "", fmt.Errorf("пустой набор символов")
 	}
-	
-	// Простая псевдослучайная генерация (для демонстрации)
-	password := make([]byte, length)
+	charsetLen := big.NewInt(int64(len(charsetRunes)))
+
+	password := make([]rune, length)
 	for i := 0; i < length; i++ {
-		// Используем простую формулу для демонстрации
-		// В реальной системе следует использовать crypto/rand
-		idx := (i*17 + 42) % len(charset)
-		password[i] = charset[idx]
+		randomIndex, err := rand.Int(rand.Reader, charsetLen)
+		if err != nil {
+			return "", fmt.Errorf("ошибка генерации случайного числа: %v", err)
+		}
+		password[i] = charsetRunes[randomIndex.Int64()]
 	}
 	
-	return string(password)
+	return string(password), nil
 }
 
 // Функция для интерактивного расчёта произвольных параметров
@@ -312,7 +907,441 @@ func customCalculation() {
 		Time:        T,
 		TimeUnit:    timeUnit,
 	}
+
+	if err := validateTask(task); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
 	
 	analysis := analyzePasswordSecurity(task)
 	printResults(analysis)
-}
\ No newline at end of file
+
+	fmt.Println("\n=== ГЕНЕРАТОР ПАРОЛЕЙ ===")
+	generatePasswordExample(analysis)
+}
+// Полная таблица вариантов: P повторяется с периодом 4 (1e-4 ... 1e-7), скорость V берется
+// из опубликованного варианта с тем же номером по модулю 10, а срок T - со сдвигом на 3 строки
+// в каждом следующем десятке. Варианты 1-10 совпадают с опубликованными, наборы P, V, T не повторяются.
+func generateVariants(count int) []PasswordTask {
+	tasks := make([]PasswordTask, 0, count)
+	for i := 0; i < count; i++ {
+		speed := baseVariants[i%len(baseVariants)]
+		lifetime := baseVariants[(i+i/len(baseVariants)*3)%len(baseVariants)]
+		tasks = append(tasks, PasswordTask{
+			Variant:     i + 1,
+			Probability: math.Pow(10, -float64(4+i%4)),
+			Speed:       speed.Speed,
+			SpeedUnit:   speed.SpeedUnit,
+			Time:        lifetime.Time,
+			TimeUnit:    lifetime.TimeUnit,
+		})
+	}
+	return tasks
+}
+
+// Поиск варианта по номеру
+func findVariant(number int) (PasswordTask, bool) {
+	for _, task := range variants {
+		if task.Variant == number {
+			return task, true
+		}
+	}
+	return PasswordTask{}, false
+}
+
+// Вывод таблицы вариантов
+func printVariants() {
+	fmt.Println(" ТАБЛИЦА ВАРИАНТОВ:")
+	fmt.Printf("   %-8s %-8s %-20s %-12s\n", "Вариант", "P", "V", "T")
+	for _, v := range variants {
+		fmt.Printf("   %-8d %-8.0e %-20s %-12s\n", v.Variant, v.Probability,
+			fmt.Sprintf("%g %s", v.Speed, v.SpeedUnit), fmt.Sprintf("%g %s", v.Time, v.TimeUnit))
+	}
+}
+
+// Чтение таблицы вариантов из CSV. Столбцы: номер, P, V, единица скорости, T, единица времени.
+// Строка заголовка необязательна; файлы с разделителем ";" и десятичной запятой (Excel) тоже читаются.
+func loadVariantsCSV(path string) ([]PasswordTask, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения таблицы вариантов: %v", err)
+	}
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	if strings.Contains(firstLine, ";") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+
+	parseNumber := func(value string) (float64, error) {
+		return strconv.ParseFloat(strings.Replace(strings.TrimSpace(value), ",", ".", 1), 64)
+	}
+
+	var tasks []PasswordTask
+	seen := make(map[int]bool)
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("таблица вариантов %s: %v", path, err)
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			if row == 1 {
+				continue // Заголовок
+			}
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: некорректный номер варианта %q", path, row, record[0])
+		}
+		task := PasswordTask{Variant: number, SpeedUnit: strings.TrimSpace(record[3]), TimeUnit: strings.TrimSpace(record[5])}
+		if task.Probability, err = parseNumber(record[1]); err != nil {
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: некорректная вероятность P %q", path, row, record[1])
+		}
+		if task.Speed, err = parseNumber(record[2]); err != nil {
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: некорректная скорость V %q", path, row, record[2])
+		}
+		if task.Time, err = parseNumber(record[4]); err != nil {
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: некорректный срок T %q", path, row, record[4])
+		}
+		switch {
+		case number < 1:
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: номер варианта должен быть положительным", path, row)
+		case seen[number]:
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: вариант %d задан повторно", path, row, number)
+		}
+		if err := validateTask(task); err != nil {
+			return nil, fmt.Errorf("таблица вариантов %s, строка %d: %v", path, row, err)
+		}
+		seen[number] = true
+		tasks = append(tasks, task)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("таблица вариантов %s не содержит вариантов", path)
+	}
+	return tasks, nil
+}
+
+// Решение варианта: исходные данные, шаги расчета, таблица A/L и выбор
+func buildSolution(analysis PasswordAnalysis) Solution {
+	task := analysis.Task
+	solution := Solution{Title: "Исходные данные"}
+	if task.Variant > 0 {
+		solution.Title = fmt.Sprintf("Вариант %d", task.Variant)
+	}
+	solution.Inputs = [][2]string{
+		{"P (вероятность подбора)", fmt.Sprintf("%.0e", task.Probability)},
+		{"V (скорость перебора)", fmt.Sprintf("%g %s", task.Speed, task.SpeedUnit)},
+		{"T (срок действия пароля)", fmt.Sprintf("%g %s", task.Time, task.TimeUnit)},
+	}
+	speedStep := fmt.Sprintf("Скорость перебора в паролях в минуту: V = %g %s = %.4g паролей/мин.",
+		task.Speed, task.SpeedUnit, analysis.SpeedPerMinute)
+	if seconds, _ := parseSpeedUnit(task.SpeedUnit); seconds == 60 {
+		speedStep = fmt.Sprintf("Скорость перебора задана в паролях в минуту: V = %g.", task.Speed)
+	}
+	solution.Steps = []string{
+		speedStep,
+		fmt.Sprintf("Срок действия пароля в минутах: T = %g %s = %.0f мин.", task.Time, task.TimeUnit, analysis.TimeInMinutes),
+		fmt.Sprintf("Нижняя граница числа паролей (с округлением вверх): S* = V · T / P = %.4g · %.0f / %.0e = %s.",
+			analysis.SpeedPerMinute, analysis.TimeInMinutes, task.Probability, formatScientific(analysis.LowerBound)),
+		"Для алфавита мощности A минимальная длина L - наименьшее целое, не меньшее ln S* / ln A; тогда S = A^L ≥ S*, запас - S / S*.",
+	}
+	solution.Table = [][]string{{"A", "Алфавит", "L", "S = A^L", "Запас S/S*"}}
+	for _, combo := range analysis.Combinations {
+		solution.Table = append(solution.Table, []string{
+			strconv.Itoa(combo.AlphabetSize),
+			combo.AlphabetName,
+			strconv.Itoa(combo.MinLength),
+			formatScientific(combo.TotalPasswords),
+			fmt.Sprintf("%.2f", combo.SecurityMargin),
+		})
+	}
+	if best, ok := optimalCombination(analysis); ok {
+		solution.Choice = fmt.Sprintf("Выбран алфавит \"%s\" (A = %d) и длина L = %d: S = %s ≥ S* = %s, запас %.2f раз.",
+			best.AlphabetName, best.AlphabetSize, best.MinLength, formatScientific(best.TotalPasswords),
+			formatScientific(analysis.LowerBound), best.SecurityMargin)
+	} else {
+		solution.Choice = fmt.Sprintf("Ни один алфавит не дает длину пароля до %d символов.", maxPasswordLength)
+	}
+	return solution
+}
+
+// Сохранение решений в файл; формат определяется расширением
+func exportSolutions(path, fontPath string, analyses []PasswordAnalysis) {
+	var solutions []Solution
+	for _, analysis := range analyses {
+		solutions = append(solutions, buildSolution(analysis))
+	}
+
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md":
+		var file *os.File
+		if file, err = os.Create(path); err == nil {
+			writeSolutionsMarkdown(file, solutions)
+			err = file.Close()
+		}
+	case ".pdf":
+		err = writeSolutionsPDF(path, fontPath, solutions)
+	default:
+		fmt.Fprintf(os.Stderr, "формат экспорта определяется расширением файла: .md или .pdf (%s)\n", path)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка экспорта решения: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Решение сохранено в %s (вариантов: %d)\n", path, len(solutions))
+}
+
+// Решения в формате Markdown
+func writeSolutionsMarkdown(w io.Writer, solutions []Solution) {
+	fmt.Fprintln(w, "# Количественная оценка стойкости паролей")
+	for _, solution := range solutions {
+		fmt.Fprintf(w, "\n## %s\n\n", solution.Title)
+		fmt.Fprintln(w, "| Параметр | Значение |")
+		fmt.Fprintln(w, "|---|---|")
+		for _, input := range solution.Inputs {
+			fmt.Fprintf(w, "| %s | %s |\n", input[0], input[1])
+		}
+
+		fmt.Fprint(w, "\n### Решение\n\n")
+		for i, step := range solution.Steps {
+			fmt.Fprintf(w, "%d. %s\n", i+1, step)
+		}
+
+		fmt.Fprintln(w)
+		for i, row := range solution.Table {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+			if i == 0 {
+				fmt.Fprintln(w, "|---:|---|---:|---:|---:|")
+			}
+		}
+		fmt.Fprintf(w, "\n**Ответ.** %s\n", solution.Choice)
+	}
+}
+
+// Решения в формате PDF. Встроенные шрифты PDF не содержат кириллицы,
+// поэтому в документ встраивается шрифт TrueType.
+func writeSolutionsPDF(path, fontPath string, solutions []Solution) error {
+	if fontPath == "" {
+		for _, candidate := range pdfFontCandidates {
+			if _, err := os.Stat(candidate); err == nil {
+				fontPath = candidate
+				break
+			}
+		}
+		if fontPath == "" {
+			return fmt.Errorf("не найден шрифт с кириллицей, укажите его флагом -pdf-font")
+		}
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	font, err := os.ReadFile(fontPath)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения шрифта: %v", err)
+	}
+	pdf.AddUTF8FontFromBytes("text", "", font)
+	widths := []float64{12, 88, 12, 32, 36}
+	for _, solution := range solutions {
+		pdf.AddPage()
+		pdf.SetFont("text", "", 16)
+		pdf.MultiCell(0, 9, solution.Title, "", "L", false)
+		pdf.Ln(2)
+
+		pdf.SetFont("text", "", 10)
+		for _, input := range solution.Inputs {
+			pdf.CellFormat(60, 7, input[0], "1", 0, "L", false, 0, "")
+			pdf.CellFormat(60, 7, input[1], "1", 1, "L", false, 0, "")
+		}
+
+		pdf.Ln(4)
+		pdf.SetFont("text", "", 13)
+		pdf.MultiCell(0, 8, "Решение", "", "L", false)
+		pdf.SetFont("text", "", 10)
+		for i, step := range solution.Steps {
+			pdf.MultiCell(0, 6, fmt.Sprintf("%d. %s", i+1, step), "", "L", false)
+		}
+
+		pdf.Ln(3)
+		pdf.SetFont("text", "", 9)
+		for i, row := range solution.Table {
+			for j, cell := range row {
+				align := "R"
+				if j == 1 {
+					align = "L"
+				}
+				pdf.CellFormat(widths[j], 6, cell, "1", 0, align, i == 0, 0, "")
+			}
+			pdf.Ln(-1)
+		}
+
+		pdf.Ln(4)
+		pdf.SetFont("text", "", 11)
+		pdf.MultiCell(0, 6, "Ответ. "+solution.Choice, "", "L", false)
+	}
+	return pdf.OutputFileAndClose(path)
+}
+
+// Точная нижняя граница S* = ⌈V·T/P⌉: V, T и P берутся в десятичной записи, единицы -
+// в целых секундах, поэтому округление вверх не срабатывает на погрешности float64
+func lowerBound(task PasswordTask) *big.Int {
+	speedSeconds, _ := parseSpeedUnit(task.SpeedUnit)
+	timeSeconds, _ := parseTimeUnit(task.TimeUnit)
+	bound := new(big.Rat).Mul(decimalRat(task.Speed), decimalRat(task.Time))
+	bound.Mul(bound, new(big.Rat).SetInt64(timeSeconds))
+	bound.Quo(bound, new(big.Rat).SetInt64(speedSeconds))
+	bound.Quo(bound, decimalRat(task.Probability))
+
+	quotient, remainder := new(big.Int).QuoRem(bound.Num(), bound.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
+
+// Число в кратчайшей десятичной записи как точная дробь (1e-06 - ровно одна миллионная)
+func decimalRat(value float64) *big.Rat {
+	rat, _ := new(big.Rat).SetString(strconv.FormatFloat(value, 'g', -1, 64))
+	return rat
+}
+
+// Число паролей S = A^L
+func passwordCount(alphabet, length int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(int64(alphabet)), big.NewInt(int64(length)), nil)
+}
+
+// BigFloat из целого числа
+func bigFromInt(value *big.Int) BigFloat {
+	return BigFloat{new(big.Float).SetPrec(bigPrecision).SetInt(value)}
+}
+
+// BigFloat из float64
+func bigFromFloat(value float64) BigFloat {
+	return BigFloat{new(big.Float).SetPrec(bigPrecision).SetFloat64(value)}
+}
+
+// Частное a/b
+func bigQuo(a, b BigFloat) BigFloat {
+	return BigFloat{new(big.Float).SetPrec(bigPrecision).Quo(a.Float, b.Float)}
+}
+
+// Десятичный логарифм (для нуля - минус бесконечность)
+func (f BigFloat) Log10() float64 {
+	if f.Sign() <= 0 {
+		return math.Inf(-1)
+	}
+	mantissa := new(big.Float)
+	exponent := f.MantExp(mantissa) // f = mantissa · 2^exponent, 0.5 <= mantissa < 1
+	value, _ := mantissa.Float64()
+	return math.Log10(value) + float64(exponent)*math.Log10(2)
+}
+
+// MarshalJSON выводит число в экспоненциальной записи без потери порядка
+func (f BigFloat) MarshalJSON() ([]byte, error) {
+	if f.Float == nil {
+		return []byte("null"), nil
+	}
+	return []byte(f.Text('g', 17)), nil
+}
+
+// Число паролей в экспоненциальной записи: 7.35e+11
+func formatScientific(value *big.Int) string {
+	return bigFromInt(value).Text('e', 2)
+}
+
+// Разбор встроенного файла профилей атакующего
+func mustParseAttackerProfiles(data []byte) []AttackerProfile {
+	profiles, err := parseAttackerProfiles(data)
+	if err != nil {
+		panic("attacker_profiles.json: " + err.Error())
+	}
+	return profiles
+}
+
+// Разбор файла профилей атакующего: список объектов с id, name, guesses_per_second и description
+func parseAttackerProfiles(data []byte) ([]AttackerProfile, error) {
+	var presets []attackerPreset
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&presets); err != nil {
+		return nil, fmt.Errorf("некорректный формат профилей атакующего: %v", err)
+	}
+
+	var profiles []AttackerProfile
+	seen := make(map[string]bool)
+	for i, preset := range presets {
+		switch {
+		case preset.ID == "" || strings.ContainsAny(preset.ID, " ,"):
+			return nil, fmt.Errorf("профиль %d: id должен быть непустым и без пробелов и запятых", i+1)
+		case seen[preset.ID]:
+			return nil, fmt.Errorf("профиль %s задан повторно", preset.ID)
+		case preset.Name == "":
+			return nil, fmt.Errorf("профиль %s: не задано название", preset.ID)
+		case !(preset.GuessesPerSecond > 0) || math.IsInf(preset.GuessesPerSecond, 0):
+			return nil, fmt.Errorf("профиль %s: скорость guesses_per_second должна быть положительной", preset.ID)
+		}
+		seen[preset.ID] = true
+		profiles = append(profiles, AttackerProfile{
+			ID:             preset.ID,
+			Name:           preset.Name,
+			SpeedPerMinute: preset.GuessesPerSecond * 60,
+			Description:    preset.Description,
+		})
+	}
+	return profiles, nil
+}
+
+// Чтение своих профилей атакующего из файла
+func loadAttackerProfiles(path string) ([]AttackerProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения профилей атакующего: %v", err)
+	}
+	profiles, err := parseAttackerProfiles(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return profiles, nil
+}
+
+// Свои профили заменяют типовые с тем же id, остальные добавляются в конец
+func mergeAttackerProfiles(base, custom []AttackerProfile) []AttackerProfile {
+	merged := append([]AttackerProfile(nil), base...)
+	for _, profile := range custom {
+		replaced := false
+		for i := range merged {
+			if merged[i].ID == profile.ID {
+				merged[i], replaced = profile, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, profile)
+		}
+	}
+	return merged
+}
+
+// Поиск профиля атакующего по id
+func findAttackerProfile(id string) (AttackerProfile, bool) {
+	for _, profile := range attackerProfiles {
+		if profile.ID == id {
+			return profile, true
+		}
+	}
+	return AttackerProfile{}, false
+}
+
+// Вывод профилей атакующего
+func printAttackerProfiles() {
+	fmt.Println(" ПРОФИЛИ АТАКУЮЩЕГО:")
+	fmt.Printf("   %-20s %-40s %14s\n", "id", "Атакующий", "Паролей/с")
+	for _, profile := range attackerProfiles {
+		fmt.Printf("   %-20s %-40s %14.3g\n", profile.ID, profile.Name, profile.SpeedPerMinute/60)
+		if profile.Description != "" {
+			fmt.Printf("   %-20s %s\n", "", profile.Description)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// chiSquareCritical - критическое значение хи-квадрат для df степеней свободы и уровня
+// значимости, которому соответствует квантиль z нормального распределения
+// (приближение Уилсона-Хилферти)
+func chiSquareCritical(df int, z float64) float64 {
+	k := float64(df)
+	return k * math.Pow(1-2/(9*k)+z*math.Sqrt(2/(9*k)), 3)
+}
+
+// Уровень значимости 1e-6: тест ложно падает реже раза на миллион запусков на алфавит
+const chiSquareZ = 4.753
+
+func TestGenerateSecurePasswordDistribution(t *testing.T) {
+	const samples, length = 2000, 50
+	for _, alphabet := range alphabets {
+		t.Run(alphabet.Name, func(t *testing.T) {
+			counts := make(map[rune]int, alphabet.Size)
+			for i := 0; i < samples; i++ {
+				password, err := generateSecurePassword(alphabet.Charset, length)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if utf8.RuneCountInString(password) != length {
+					t.Fatalf("длина %d вместо %d", utf8.RuneCountInString(password), length)
+				}
+				for _, r := range password {
+					if !strings.ContainsRune(alphabet.Charset, r) {
+						t.Fatalf("символ %q не из алфавита", r)
+					}
+					counts[r]++
+				}
+			}
+
+			// Каждый символ алфавита встречается с вероятностью 1/size
+			expected := float64(samples*length) / float64(alphabet.Size)
+			chi := 0.0
+			for _, r := range alphabet.Charset {
+				diff := float64(counts[r]) - expected
+				chi += diff * diff / expected
+			}
+			if critical := chiSquareCritical(alphabet.Size-1, chiSquareZ); chi > critical {
+				t.Errorf("распределение символов неравномерно: хи-квадрат %.1f > %.1f", chi, critical)
+			}
+		})
+	}
+}
+
+func TestGenerateSecurePasswordUnique(t *testing.T) {
+	const samples = 10000
+	for _, alphabet := range alphabets {
+		// Вероятность совпадения двух из 10 000 паролей длины 12 и больше меньше 1e-4
+		length := 12
+		if alphabet.Size <= 10 {
+			length = 20
+		}
+		seen := make(map[string]bool, samples)
+		for i := 0; i < samples; i++ {
+			password, err := generateSecurePassword(alphabet.Charset, length)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[password] {
+				t.Fatalf("%s: пароль %q сгенерирован повторно", alphabet.Name, password)
+			}
+			seen[password] = true
+		}
+	}
+}
+
+func TestGenerateSecurePasswordCustomCharset(t *testing.T) {
+	const charset = "абвгд"
+	password, err := generateSecurePassword(charset, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range charset {
+		if !strings.ContainsRune(password, r) {
+			t.Errorf("символ %q не встретился в 200 символах", r)
+		}
+	}
+	if strings.Trim(password, charset) != "" {
+		t.Errorf("пароль %q содержит символы не из набора", password)
+	}
+
+	if _, err := generateSecurePassword("", 8); err == nil {
+		t.Error("пустой набор символов принят")
+	}
+}
+
+func TestAlphabetCharsetsMatchSize(t *testing.T) {
+	for _, alphabet := range alphabets {
+		if got := utf8.RuneCountInString(uniqueRunes(alphabet.Charset)); got != alphabet.Size {
+			t.Errorf("%s: %d различных символов вместо %d", alphabet.Name, got, alphabet.Size)
+		}
+	}
+}
@@ -2,15 +2,39 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"math"
 	"math/big"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/jung-kurt/gofpdf"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
 )
 
 // Структура пользователя с поддержкой 2FA
@@ -18,22 +42,156 @@ type User2FA struct {
 	Username     string    // Логин пользователя
 	PasswordHash string    // Хеш пароля
 	TotpSecret   string    // Секретный ключ для TOTP
+	OTPAlgorithm string    // Алгоритм кодов: totp (по умолчанию), steam, yandex
+	YubiKeyID    string    // Публичный идентификатор YubiKey (пусто - не подключен)
+	FIDO2CredID  string    // Идентификатор учетных данных FIDO2 в base64 (пусто - не подключен)
+	FIDO2Key     string    // Открытый ключ учетных данных FIDO2 (PEM, ES256)
 	BackupCodes  []string  // Резервные коды
 	Is2FAEnabled bool      // Включена ли двухфакторная аутентификация
+	PendingActivation bool // 2FA подготовлена администратором и ждет подтверждения кодом при первом входе
 	CreatedAt    time.Time // Время создания аккаунта
 	LastLogin    time.Time // Время последнего входа
 }
 
-// Хранилище пользователей
+// clone возвращает глубокую копию пользователя
+func (u *User2FA) clone() *User2FA {
+	copied := *u
+	copied.BackupCodes = append([]string{}, u.BackupCodes...)
+	return &copied
+}
+
+// Хранилище пользователей. Записи выдаются и принимаются копиями:
+// изменить хранимого пользователя можно только через Update.
 type User2FAStore struct {
+	mu    sync.RWMutex
 	users map[string]*User2FA
 }
 
+// GetUser возвращает копию пользователя по логину
+func (s *User2FAStore) GetUser(username string) (*User2FA, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, exists := s.users[username]
+	if !exists {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+// CreateUser добавляет пользователя, если логин еще не занят
+func (s *User2FAStore) CreateUser(user *User2FA) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[user.Username]; exists {
+		return fmt.Errorf("пользователь уже существует")
+	}
+	s.users[user.Username] = user.clone()
+	return nil
+}
+
+// Update изменяет пользователя под блокировкой хранилища. Функция получает копию
+// записи; копия заменяет запись, только если функция вернула nil.
+func (s *User2FAStore) Update(username string, fn func(user *User2FA) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, exists := s.users[username]
+	if !exists {
+		return fmt.Errorf("пользователь не найден")
+	}
+
+	updated := user.clone()
+	if err := fn(updated); err != nil {
+		return err
+	}
+	s.users[username] = updated.clone()
+	return nil
+}
+
+// Clock - источник текущего времени (позволяет подменять время в тестах)
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock возвращает системное время
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Формат резервных кодов
+type BackupCodeFormat int
+
+const (
+	BackupCodeAlphanumeric BackupCodeFormat = iota // Заглавные буквы и цифры
+	BackupCodeDigits                               // Только цифры
+)
+
+// Названия форматов резервных кодов для флага -backup-code-format
+var backupCodeFormats = map[string]BackupCodeFormat{
+	"alnum":  BackupCodeAlphanumeric,
+	"digits": BackupCodeDigits,
+}
+
+// ParseBackupCodeFormat возвращает формат резервных кодов по названию
+func ParseBackupCodeFormat(name string) (BackupCodeFormat, error) {
+	format, ok := backupCodeFormats[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("неизвестный формат резервных кодов %q (alnum или digits)", name)
+	}
+	return format, nil
+}
+
+// charset возвращает набор символов кода (пустая строка - неизвестный формат)
+func (f BackupCodeFormat) charset() string {
+	switch f {
+	case BackupCodeAlphanumeric:
+		return "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	case BackupCodeDigits:
+		return "0123456789"
+	}
+	return ""
+}
+
+// Границы политики резервных кодов
+const (
+	maxBackupCodes      = 100 // Больше кодов - больше шансов угадать любой из них
+	maxBackupCodeLength = 64
+	minBackupCodeBits   = 30 // Стойкость одного кода: alnum - от 6 символов, digits - от 10
+)
+
+// Политика выпуска и использования резервных кодов
+type BackupCodePolicy struct {
+	Count     int              // Количество резервных кодов
+	Length    int              // Длина кода без разделителей
+	Format    BackupCodeFormat // Набор символов кода
+	GroupSize int              // Размер группы символов, разделяемых дефисом (0 - без групп)
+	MultiUse  bool             // Код можно использовать повторно
+	WarnBelow int              // Предупреждать, когда осталось меньше кодов
+}
+
+// DefaultBackupCodePolicy возвращает политику по умолчанию: 10 одноразовых кодов по 8 символов
+func DefaultBackupCodePolicy() BackupCodePolicy {
+	return BackupCodePolicy{
+		Count:     10,
+		Length:    8,
+		Format:    BackupCodeAlphanumeric,
+		GroupSize: 0,
+		MultiUse:  false,
+		WarnBelow: 3,
+	}
+}
+
 // Менеджер двухфакторной аутентификации
 type TwoFactorAuth struct {
 	store         *User2FAStore
+	clock         Clock // Источник времени для TOTP и отметок входа
+	random        io.Reader // Источник случайности для секретов и резервных кодов
 	codeLifetime  int // Время жизни TOTP кода в секундах
-	backupCodes   int // Количество резервных кодов
+	backupPolicy  BackupCodePolicy // Политика резервных кодов
+	yubico        *YubicoValidator // Сервер проверки Yubico OTP (nil - YubiKey недоступен)
+	fido2         *FIDO2Tools      // Утилиты libfido2 (nil - не установлены)
 }
 
 // Результат аутентификации
@@ -45,17 +203,68 @@ type AuthResult2FA struct {
 }
 
 func main() {
-	fmt.Println("=== СИСТЕМА ДВУХФАКТОРНОЙ АУТЕНТИФИКАЦИИ ===")
-	fmt.Println()
+	yubicoURL := flag.String("yubico-url", yubiCloudURL, "сервер проверки Yubico OTP (YubiCloud или собственный, протокол 2.0)")
+	yubicoClientID := flag.String("yubico-client-id", "", "идентификатор клиента сервера проверки (пусто - YubiKey отключен)")
+	yubicoAPIKey := flag.String("yubico-api-key", "", "ключ API сервера проверки в base64 для подписи запросов и ответов")
+	fido2Device := flag.String("fido2-device", "", "устройство FIDO2, например /dev/hidraw0 (по умолчанию первое из fido2-token -L)")
+	pdfFont := flag.String("pdf-font", "", "шрифт TrueType с кириллицей для листов подключения 2FA (по умолчанию ищутся DejaVu Sans, Liberation Sans, Arial)")
+	defaultBackup := DefaultBackupCodePolicy()
+	backupCount := flag.Int("backup-codes", defaultBackup.Count, fmt.Sprintf("количество резервных кодов (1-%d)", maxBackupCodes))
+	backupLength := flag.Int("backup-code-length", defaultBackup.Length, "длина резервного кода без дефисов (alnum - от 6, digits - от 10)")
+	backupFormat := flag.String("backup-code-format", "alnum", "символы резервных кодов: alnum (заглавные буквы и цифры) или digits")
+	backupGroup := flag.Int("backup-code-group", defaultBackup.GroupSize, "размер групп символов кода, разделяемых дефисом (0 - без групп)")
+	backupMultiUse := flag.Bool("backup-code-multi-use", defaultBackup.MultiUse, "резервный код можно использовать повторно")
+	backupWarn := flag.Int("backup-code-warn", defaultBackup.WarnBelow, "предупреждать, когда одноразовых кодов осталось меньше")
+	flag.Parse()
 
 	// Инициализация системы
 	auth := NewTwoFactorAuth()
+	format, err := ParseBackupCodeFormat(*backupFormat)
+	if err == nil {
+		err = auth.SetBackupCodePolicy(BackupCodePolicy{
+			Count:     *backupCount,
+			Length:    *backupLength,
+			Format:    format,
+			GroupSize: *backupGroup,
+			MultiUse:  *backupMultiUse,
+			WarnBelow: *backupWarn,
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ошибка: политика резервных кодов: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Диагностика часов без меню - для проверки сервера из сценариев и мониторинга
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "totp-diag" || len(args) > 2 {
+			fmt.Fprintf(os.Stderr, "неизвестная команда: %s (доступно: totp-diag [NTP-сервер])\n", strings.Join(args, " "))
+			os.Exit(2)
+		}
+		server := ""
+		if len(args) == 2 {
+			server = args[1]
+		}
+		os.Exit(reportClockSkew(auth, server))
+	}
+
+	fmt.Println("=== СИСТЕМА ДВУХФАКТОРНОЙ АУТЕНТИФИКАЦИИ ===")
+	fmt.Println()
+	if *yubicoClientID != "" {
+		validator, err := NewYubicoValidator(*yubicoURL, *yubicoClientID, *yubicoAPIKey)
+		if err != nil {
+			fmt.Printf("❌ YubiKey недоступен: %v\n\n", err)
+		} else {
+			auth.yubico = validator
+		}
+	}
+	auth.fido2 = NewFIDO2Tools(*fido2Device)
 	scanner := bufio.NewScanner(os.Stdin)
 
 	for {
 		showMenu()
 		
-		fmt.Print("Выберите действие (1-8): ")
+		fmt.Print("Выберите действие (1-13): ")
 		if !scanner.Scan() {
 			break
 		}
@@ -79,10 +288,20 @@ func main() {
 		case "7":
 			demonstrate2FA()
 		case "8":
+			diagnoseClock(auth, scanner)
+		case "9":
+			transferTOTP(auth, scanner)
+		case "10":
+			enrollYubiKey(auth, scanner)
+		case "11":
+			enrollFIDO2(auth, scanner)
+		case "12":
+			bulkEnroll2FA(auth, scanner, *pdfFont)
+		case "13":
 			fmt.Println("Спасибо за использование системы 2FA!")
 			return
 		default:
-			fmt.Println("❌ Неверный выбор. Пожалуйста, выберите от 1 до 8.")
+			fmt.Println("❌ Неверный выбор. Пожалуйста, выберите от 1 до 13.")
 		}
 
 		fmt.Println()
@@ -97,9 +316,44 @@ func NewTwoFactorAuth() *TwoFactorAuth {
 		store: &User2FAStore{
 			users: make(map[string]*User2FA),
 		},
+		clock:        systemClock{},
+		random:       rand.Reader,
 		codeLifetime: 30, // 30 секунд для TOTP
-		backupCodes:  10, // 10 резервных кодов
+		backupPolicy: DefaultBackupCodePolicy(),
+	}
+}
+
+// SetBackupCodePolicy задает политику резервных кодов
+func (auth *TwoFactorAuth) SetBackupCodePolicy(policy BackupCodePolicy) error {
+	charset := policy.Format.charset()
+	if charset == "" {
+		return fmt.Errorf("неизвестный формат резервных кодов: %d", policy.Format)
+	}
+	if policy.Count < 1 || policy.Count > maxBackupCodes {
+		return fmt.Errorf("количество резервных кодов должно быть от 1 до %d", maxBackupCodes)
+	}
+	minLength := int(math.Ceil(minBackupCodeBits / math.Log2(float64(len(charset)))))
+	if policy.Length < minLength || policy.Length > maxBackupCodeLength {
+		return fmt.Errorf("длина резервного кода в этом формате должна быть от %d до %d символов", minLength, maxBackupCodeLength)
+	}
+	if policy.GroupSize < 0 || policy.GroupSize >= policy.Length {
+		return fmt.Errorf("размер группы должен быть от 0 до %d (меньше длины кода)", policy.Length-1)
+	}
+	if policy.WarnBelow < 0 || policy.WarnBelow > policy.Count {
+		return fmt.Errorf("порог предупреждения должен быть от 0 до количества кодов (%d)", policy.Count)
+	}
+
+	auth.backupPolicy = policy
+	return nil
+}
+
+// SetRandomSource подменяет источник случайности для секретов и резервных кодов
+// (например, детерминированным в тестах; nil возвращает crypto/rand)
+func (auth *TwoFactorAuth) SetRandomSource(random io.Reader) {
+	if random == nil {
+		random = rand.Reader
 	}
+	auth.random = random
 }
 
 func showMenu() {
@@ -113,7 +367,12 @@ func showMenu() {
 	fmt.Println("│ 5. Сгенерировать резервные коды             │")
 	fmt.Println("│ 6. Информация о пользователе                │")
 	fmt.Println("│ 7. Демонстрация алгоритма TOTP              │")
-	fmt.Println("│ 8. Выход                                    │")
+	fmt.Println("│ 8. Диагностика часов (TOTP)                 │")
+	fmt.Println("│ 9. Экспорт/импорт 2FA (Aegis, andOTP)       │")
+	fmt.Println("│ 10. Подключить YubiKey (Yubico OTP)         │")
+	fmt.Println("│ 11. Подключить ключ безопасности FIDO2      │")
+	fmt.Println("│ 12. Массовое подключение 2FA (для админ.)   │")
+	fmt.Println("│ 13. Выход                                   │")
 	fmt.Println("└─────────────────────────────────────────────┘")
 }
 
@@ -132,7 +391,7 @@ func registerUser2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 		return
 	}
 
-	if _, exists := auth.store.users[username]; exists {
+	if _, exists := auth.store.GetUser(username); exists {
 		fmt.Println("❌ Пользователь уже существует")
 		return
 	}
@@ -159,11 +418,14 @@ func registerUser2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 		TotpSecret:   "",
 		BackupCodes:  []string{},
 		Is2FAEnabled: false,
-		CreatedAt:    time.Now(),
+		CreatedAt:    auth.clock.Now(),
 		LastLogin:    time.Time{},
 	}
 
-	auth.store.users[username] = user
+	if err := auth.store.CreateUser(user); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
 	fmt.Printf("✅ Пользователь '%s' успешно зарегистрирован!\n", username)
 	fmt.Println("💡 Рекомендуется включить двухфакторную аутентификацию (пункт 3)")
 }
@@ -192,13 +454,26 @@ func loginUser2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 	// Если 2FA отключена, вход успешен
 	if !result.RequiresTOTP {
 		fmt.Printf("✅ Добро пожаловать, %s!\n", username)
-		result.User.LastLogin = time.Now()
+		auth.recordLogin(username)
+		return
+	}
+
+	// 2FA подготовлена администратором: первый вход подтверждает ее кодом из приложения
+	if result.User.PendingActivation {
+		confirmPending2FA(auth, result.User, scanner)
 		return
 	}
 
 	// Второй фактор - TOTP код
 	fmt.Println("🔐 Требуется код двухфакторной аутентификации")
-	fmt.Print("Введите 6-значный код или резервный код: ")
+	if result.User.FIDO2CredID != "" {
+		fmt.Println("   Нажмите Enter и коснитесь ключа безопасности FIDO2")
+	}
+	if result.User.YubiKeyID != "" {
+		fmt.Print("Коснитесь YubiKey, введите код из приложения или резервный код: ")
+	} else {
+		fmt.Print("Введите 6-значный код или резервный код: ")
+	}
 	if !scanner.Scan() {
 		return
 	}
@@ -207,7 +482,9 @@ func loginUser2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 	// Проверяем TOTP код или резервный код
 	if auth.verifySecondFactor(result.User, code) {
 		fmt.Printf("✅ Добро пожаловать, %s!\n", username)
-		result.User.LastLogin = time.Now()
+		if user := auth.recordLogin(username); user != nil {
+			warnLowBackupCodes(auth, user)
+		}
 	} else {
 		fmt.Println("❌ Неверный код аутентификации")
 	}
@@ -227,12 +504,9 @@ func enable2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 		return
 	}
 
-	// Генерируем секретный ключ
-	secret := generateTOTPSecret()
-	user.TotpSecret = secret
-
-	// Генерируем резервные коды
-	user.BackupCodes = generateBackupCodesList(auth.backupCodes)
+	// Генерируем секретный ключ и резервные коды (сохраняются после подтверждения)
+	secret := generateTOTPSecret(auth.random)
+	backupCodes := generateBackupCodesList(auth.random, auth.backupPolicy)
 
 	fmt.Printf("🔑 Секретный ключ TOTP: %s\n", secret)
 	fmt.Println("📱 Добавьте этот ключ в ваше приложение аутентификатор")
@@ -241,7 +515,7 @@ func enable2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 
 	// Показываем резервные коды
 	fmt.Println("🆘 РЕЗЕРВНЫЕ КОДЫ (сохраните в безопасном месте!):")
-	for i, code := range user.BackupCodes {
+	for i, code := range backupCodes {
 		fmt.Printf("   %2d. %s\n", i+1, code)
 	}
 	fmt.Println()
@@ -253,14 +527,24 @@ func enable2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 	}
 	code := strings.TrimSpace(scanner.Text())
 
-	if auth.verifyTOTPCode(secret, code) {
-		user.Is2FAEnabled = true
-		fmt.Println("✅ Двухфакторная аутентификация успешно включена!")
-	} else {
+	if !auth.verifyOTPCode(OTPTotp, secret, code) {
 		fmt.Println("❌ Неверный код. 2FA не была включена.")
-		user.TotpSecret = ""
-		user.BackupCodes = []string{}
+		return
+	}
+
+	err := auth.store.Update(user.Username, func(user *User2FA) error {
+		user.TotpSecret = secret
+		user.OTPAlgorithm = OTPTotp
+		user.BackupCodes = backupCodes
+		user.Is2FAEnabled = true
+		user.PendingActivation = false
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
 	}
+	fmt.Println("✅ Двухфакторная аутентификация успешно включена!")
 }
 
 // Отключение 2FA
@@ -272,11 +556,12 @@ func disable2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 		return
 	}
 
-	if !user.Is2FAEnabled {
+	if !user.Is2FAEnabled && !user.PendingActivation {
 		fmt.Println("ℹ️  Двухфакторная аутентификация не включена")
 		return
 	}
 
+	// Подготовленную администратором 2FA можно отменить кодом с выданного листа
 	fmt.Print("Введите текущий код 2FA для подтверждения: ")
 	if !scanner.Scan() {
 		return
@@ -284,9 +569,21 @@ func disable2FA(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 	code := strings.TrimSpace(scanner.Text())
 
 	if auth.verifySecondFactor(user, code) {
-		user.Is2FAEnabled = false
-		user.TotpSecret = ""
-		user.BackupCodes = []string{}
+		err := auth.store.Update(user.Username, func(user *User2FA) error {
+			user.Is2FAEnabled = false
+			user.PendingActivation = false
+			user.TotpSecret = ""
+			user.OTPAlgorithm = ""
+			user.YubiKeyID = ""
+			user.FIDO2CredID = ""
+			user.FIDO2Key = ""
+			user.BackupCodes = []string{}
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
 		fmt.Println("✅ Двухфакторная аутентификация отключена")
 	} else {
 		fmt.Println("❌ Неверный код. 2FA не была отключена.")
@@ -307,10 +604,18 @@ func generateBackupCodes(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 		return
 	}
 
-	user.BackupCodes = generateBackupCodesList(auth.backupCodes)
+	backupCodes := generateBackupCodesList(auth.random, auth.backupPolicy)
+	err := auth.store.Update(user.Username, func(user *User2FA) error {
+		user.BackupCodes = backupCodes
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
 	
 	fmt.Println("🆘 НОВЫЕ РЕЗЕРВНЫЕ КОДЫ:")
-	for i, code := range user.BackupCodes {
+	for i, code := range backupCodes {
 		fmt.Printf("   %2d. %s\n", i+1, code)
 	}
 	fmt.Println()
@@ -338,8 +643,22 @@ func showUserInfo(auth *TwoFactorAuth, scanner *bufio.Scanner) {
 
 	if user.Is2FAEnabled {
 		fmt.Println("🔐 Двухфакторная аутентификация: ✅ ВКЛЮЧЕНА")
-		fmt.Printf("🔑 Секретный ключ: %s\n", user.TotpSecret)
+		if user.TotpSecret != "" {
+			fmt.Printf("🔑 Секретный ключ: %s\n", user.TotpSecret)
+		}
+		if user.YubiKeyID != "" {
+			fmt.Printf("🔐 YubiKey: %s\n", user.YubiKeyID)
+		}
+		if user.FIDO2CredID != "" {
+			fmt.Println("🔐 Ключ безопасности FIDO2: подключен")
+		}
+		if user.OTPAlgorithm != "" && user.OTPAlgorithm != OTPTotp {
+			fmt.Printf("📱 Алгоритм кодов: %s\n", user.OTPAlgorithm)
+		}
 		fmt.Printf("🆘 Резервных кодов: %d\n", len(user.BackupCodes))
+		warnLowBackupCodes(auth, user)
+	} else if user.PendingActivation {
+		fmt.Println("🔐 Двухфакторная аутентификация: ⏳ ОЖИДАЕТ ПОДТВЕРЖДЕНИЯ (введите код из приложения при входе)")
 	} else {
 		fmt.Println("🔐 Двухфакторная аутентификация: ❌ ОТКЛЮЧЕНА")
 	}
@@ -350,7 +669,7 @@ func demonstrate2FA() {
 	fmt.Println("=== ДЕМОНСТРАЦИЯ АЛГОРИТМА TOTP ===")
 	
 	// Генерируем тестовый секрет
-	secret := generateTOTPSecret()
+	secret := generateTOTPSecret(rand.Reader)
 	fmt.Printf("🔑 Тестовый секрет: %s\n", secret)
 	fmt.Println()
 
@@ -376,119 +695,2149 @@ func demonstrate2FA() {
 	fmt.Println("\n🔍 Алгоритм TOTP:")
 	fmt.Println("   1. Берем текущее время Unix")
 	fmt.Println("   2. Делим на интервал (30 сек)")
-	fmt.Println("   3. Вычисляем HMAC-SHA256 от секрета и времени")
-	fmt.Println("   4. Извлекаем 6-значный код")
+	fmt.Println("   3. Вычисляем HMAC-SHA1 от номера интервала на ключе-секрете (RFC 6238)")
+	fmt.Println("   4. Динамическим усечением извлекаем 6-значный код")
 	fmt.Println("   5. Код действителен только в текущем интервале")
 }
 
-// Функции аутентификации
+// Диагностика расхождения часов с NTP-сервером
+func diagnoseClock(auth *TwoFactorAuth, scanner *bufio.Scanner) {
+	fmt.Println("=== ДИАГНОСТИКА ЧАСОВ ===")
 
-func (auth *TwoFactorAuth) authenticateFirstFactor(username, password string) AuthResult2FA {
-	user, exists := auth.store.users[username]
-	if !exists {
-		return AuthResult2FA{false, "Пользователь не найден", false, nil}
+	fmt.Printf("NTP-сервер (по умолчанию %s): ", defaultNTPServer)
+	if !scanner.Scan() {
+		return
 	}
+	reportClockSkew(auth, strings.TrimSpace(scanner.Text()))
+}
 
-	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+// defaultNTPServer - NTP-сервер для диагностики часов по умолчанию
+const defaultNTPServer = "pool.ntp.org"
+
+// reportClockSkew сравнивает локальные часы с NTP-сервером (пустой - pool.ntp.org), выводит
+// смещение относительно окна проверки TOTP и возвращает код завершения для totp-diag:
+// 0 - в пределах окна, 1 - коды будут отклоняться или сервер недоступен
+func reportClockSkew(auth *TwoFactorAuth, server string) int {
+	if server == "" {
+		server = defaultNTPServer
+	}
+
+	offset, err := queryNTPOffset(server, auth.clock)
 	if err != nil {
-		return AuthResult2FA{false, "Неверный пароль", false, nil}
+		fmt.Printf("❌ Не удалось получить время от %s: %v\n", server, err)
+		return 1
 	}
 
-	return AuthResult2FA{true, "Первый фактор пройден", user.Is2FAEnabled, user}
-}
+	// Коды принимаются в окне ±1 интервал, поэтому допустимое расхождение - один интервал
+	window := time.Duration(auth.codeLifetime) * time.Second
+	skew := offset
+	if skew < 0 {
+		skew = -skew
+	}
 
-func (auth *TwoFactorAuth) verifySecondFactor(user *User2FA, code string) bool {
-	// Проверяем TOTP код
-	if len(code) == 6 && auth.verifyTOTPCode(user.TotpSecret, code) {
-		return true
+	fmt.Printf("🕒 Локальное время: %s\n", auth.clock.Now().Format("2006-01-02 15:04:05"))
+	fmt.Printf("📡 Смещение относительно %s: %v\n", server, offset.Round(time.Millisecond))
+	fmt.Printf("🔍 Окно проверки TOTP: ±%v\n", window)
+
+	switch {
+	case skew > window:
+		fmt.Println("⚠️  Расхождение превышает окно проверки - коды TOTP будут отклоняться!")
+		fmt.Println("   Синхронизируйте системные часы (NTP)")
+		return 1
+	case skew > window/2:
+		fmt.Println("⚠️  Расхождение близко к границе окна проверки, рекомендуется синхронизация")
+	default:
+		fmt.Println("✅ Часы синхронизированы, расхождение в допустимых пределах")
 	}
+	return 0
+}
 
-	// Проверяем резервные коды
-	for i, backupCode := range user.BackupCodes {
-		if code == backupCode {
-			// Удаляем использованный резервный код
-			user.BackupCodes = append(user.BackupCodes[:i], user.BackupCodes[i+1:]...)
-			return true
-		}
+// Экспорт и импорт секрета TOTP в форматах Aegis и andOTP
+func transferTOTP(auth *TwoFactorAuth, scanner *bufio.Scanner) {
+	fmt.Println("=== ЭКСПОРТ/ИМПОРТ 2FA ===")
+	fmt.Println("1. Экспорт секрета TOTP (зашифрованный файл Aegis или andOTP)")
+	fmt.Println("2. Импорт секрета TOTP из Aegis или andOTP")
+	fmt.Println("3. Перенос секрета вручную (TOTP, Steam Guard, Яндекс Ключ)")
+	fmt.Print("Выберите действие (1-3): ")
+	if !scanner.Scan() {
+		return
+	}
+	action := strings.TrimSpace(scanner.Text())
+	if action != "1" && action != "2" && action != "3" {
+		fmt.Println("❌ Неверный выбор")
+		return
 	}
 
-	return false
+	user := authenticateUser(auth, scanner)
+	if user == nil {
+		return
+	}
+	switch action {
+	case "1":
+		exportTOTP(auth, user, scanner)
+	case "2":
+		importTOTP(auth, user, scanner)
+	case "3":
+		enterTOTPSecret(auth, user, scanner)
+	}
 }
 
-// Функции генерации и проверки TOTP
+func exportTOTP(auth *TwoFactorAuth, user *User2FA, scanner *bufio.Scanner) {
+	if !user.Is2FAEnabled {
+		fmt.Println("❌ Двухфакторная аутентификация не включена")
+		return
+	}
+	if user.TotpSecret == "" {
+		fmt.Println("❌ Вторым фактором подключен только аппаратный ключ - секрета для экспорта нет")
+		return
+	}
 
-func generateTOTPSecret() string {
-	// Генерируем 16-байтный случайный секрет
-	bytes := make([]byte, 16)
-	for i := range bytes {
-		randomBig, _ := rand.Int(rand.Reader, big.NewInt(256))
-		bytes[i] = byte(randomBig.Int64())
+	// Секрет выдается только после подтверждения вторым фактором
+	fmt.Print("Текущий код 2FA: ")
+	if !scanner.Scan() {
+		return
+	}
+	if !auth.verifySecondFactor(user, strings.TrimSpace(scanner.Text())) {
+		fmt.Println("❌ Неверный код")
+		return
 	}
-	
-	// Конвертируем в hex строку
-	secret := fmt.Sprintf("%x", bytes)
-	return secret
-}
 
-func generateTOTPCode(secret string, timestamp time.Time) string {
-	// Упрощенный алгоритм TOTP для демонстрации
-	timeCounter := timestamp.Unix() / 30 // 30-секундные интервалы
-	
-	// Создаем хеш на основе секрета и времени
-	hasher := sha256.New()
-	hasher.Write([]byte(secret))
-	hasher.Write([]byte(fmt.Sprintf("%d", timeCounter)))
-	hash := hasher.Sum(nil)
-	
-	// Извлекаем 6-значный код
-	code := 0
-	for i := 0; i < 4; i++ {
-		code = (code << 8) | int(hash[i])
+	fmt.Print("Формат (aegis/andotp): ")
+	if !scanner.Scan() {
+		return
 	}
-	code = code % 1000000
-	
-	return fmt.Sprintf("%06d", code)
+	format := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	if format != "aegis" && format != "andotp" {
+		fmt.Println("❌ Неизвестный формат")
+		return
+	}
+
+	fmt.Print("Пароль для шифрования файла: ")
+	password := readPasswordSimple(scanner)
+	if password == "" {
+		fmt.Println("❌ Пароль не может быть пустым: файл содержит секрет второго фактора")
+		return
+	}
+
+	fmt.Print("Файл для сохранения: ")
+	if !scanner.Scan() {
+		return
+	}
+	path := strings.TrimSpace(scanner.Text())
+
+	entry := userTOTPEntry(user)
+	var data []byte
+	var err error
+	if format == "aegis" {
+		data, err = exportAegis(entry, password, auth.random)
+	} else {
+		data, err = exportAndOTP(entry, password, auth.random)
+	}
+	if err != nil {
+		fmt.Printf("❌ Ошибка экспорта: %v\n", err)
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Printf("❌ Не удалось создать файл: %v\n", err)
+		return
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("❌ Ошибка записи: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Секрет TOTP сохранен в %s\n", path)
+	fmt.Println("   Импортируйте файл в приложение и удалите его")
 }
 
-func (auth *TwoFactorAuth) verifyTOTPCode(secret, inputCode string) bool {
-	currentTime := time.Now()
-	
-	// Проверяем коды в окне ±1 интервал для компенсации расхождения времени
-	for offset := -1; offset <= 1; offset++ {
-		testTime := currentTime.Add(time.Duration(offset*30) * time.Second)
-		expectedCode := generateTOTPCode(secret, testTime)
-		
-		if inputCode == expectedCode {
-			return true
+func importTOTP(auth *TwoFactorAuth, user *User2FA, scanner *bufio.Scanner) {
+	if user.Is2FAEnabled {
+		fmt.Println("ℹ️  Двухфакторная аутентификация уже включена. Отключите ее перед импортом.")
+		return
+	}
+
+	fmt.Print("Файл Aegis или andOTP: ")
+	if !scanner.Scan() {
+		return
+	}
+	data, err := os.ReadFile(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		fmt.Printf("❌ Не удалось прочитать файл: %v\n", err)
+		return
+	}
+
+	fmt.Print("Пароль файла (пусто - файл не зашифрован): ")
+	password := readPasswordSimple(scanner)
+
+	entries, err := importTOTPEntries(data, password)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("❌ В файле нет записей TOTP")
+		return
+	}
+
+	entry := entries[0]
+	if len(entries) > 1 {
+		for i, e := range entries {
+			fmt.Printf("   %d. %s (%s, %s)\n", i+1, e.Name, e.Issuer, e.Type)
+		}
+		fmt.Print("Номер записи: ")
+		if !scanner.Scan() {
+			return
+		}
+		var index int
+		if _, err := fmt.Sscan(scanner.Text(), &index); err != nil || index < 1 || index > len(entries) {
+			fmt.Println("❌ Неверный номер")
+			return
 		}
+		entry = entries[index-1]
 	}
-	
-	return false
+	enrollTOTPEntry(auth, user, entry, scanner)
 }
 
-// Функции для резервных кодов
+// Ручной перенос секрета из приложения, не умеющего экспорт в файл
+func enterTOTPSecret(auth *TwoFactorAuth, user *User2FA, scanner *bufio.Scanner) {
+	if user.Is2FAEnabled {
+		fmt.Println("ℹ️  Двухфакторная аутентификация уже включена. Отключите ее перед импортом.")
+		return
+	}
 
-func generateBackupCodesList(count int) []string {
-	codes := make([]string, count)
-	
-	for i := 0; i < count; i++ {
-		codes[i] = generateBackupCode()
+	fmt.Print("Алгоритм (totp/steam/yandex): ")
+	if !scanner.Scan() {
+		return
 	}
-	
-	return codes
+	entry := TOTPEntry{Type: strings.ToLower(strings.TrimSpace(scanner.Text())), Name: user.Username, Algo: "SHA1", Digits: 6, Period: 30}
+	switch entry.Type {
+	case OTPSteam:
+		entry.Digits = 5
+	case OTPYandex:
+		entry.Algo, entry.Digits = "SHA256", 8
+	}
+
+	fmt.Print("Секрет (base32): ")
+	if !scanner.Scan() {
+		return
+	}
+	entry.Secret = strings.TrimSpace(scanner.Text())
+
+	if entry.Type == OTPYandex {
+		fmt.Print("PIN-код Яндекс Ключа: ")
+		entry.Pin = readPasswordSimple(scanner)
+	}
+
+	enrollTOTPEntry(auth, user, entry, scanner)
 }
 
-func generateBackupCode() string {
-	// Генерируем 8-символьный код из цифр и букв
-	charset := "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	code := make([]byte, 8)
-	
-	for i := range code {
-		randomBig, _ := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
-		code[i] = charset[randomBig.Int64()]
+// enrollTOTPEntry включает 2FA с перенесенным секретом после проверки кода из приложения
+func enrollTOTPEntry(auth *TwoFactorAuth, user *User2FA, entry TOTPEntry, scanner *bufio.Scanner) {
+	if err := entry.compatible(); err != nil {
+		fmt.Printf("❌ Запись не может быть перенесена: %v\n", err)
+		return
 	}
-	
-	return string(code)
+
+	// Совпадение кода подтверждает, что секрет и часы приложения согласованы с системой
+	fmt.Print("Введите код из приложения для подтверждения: ")
+	if !scanner.Scan() {
+		return
+	}
+	if !auth.verifyOTPCode(entry.Type, entry.storedSecret(), scanner.Text()) {
+		fmt.Println("❌ Неверный код. Секрет не импортирован.")
+		return
+	}
+
+	backupCodes := generateBackupCodesList(auth.random, auth.backupPolicy)
+	err := auth.store.Update(user.Username, func(user *User2FA) error {
+		user.TotpSecret = entry.storedSecret()
+		user.OTPAlgorithm = entry.Type
+		user.BackupCodes = backupCodes
+		user.Is2FAEnabled = true
+		user.PendingActivation = false
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	fmt.Println("✅ Секрет перенесен, двухфакторная аутентификация включена")
+	fmt.Println("🆘 РЕЗЕРВНЫЕ КОДЫ (сохраните в безопасном месте!):")
+	for i, code := range backupCodes {
+		fmt.Printf("   %2d. %s\n", i+1, code)
+	}
+}
+
+// Подключение YubiKey как второго фактора
+func enrollYubiKey(auth *TwoFactorAuth, scanner *bufio.Scanner) {
+	fmt.Println("=== ПОДКЛЮЧЕНИЕ YUBIKEY ===")
+	if auth.yubico == nil {
+		fmt.Println("❌ Сервер проверки Yubico OTP не настроен (флаг -yubico-client-id)")
+		return
+	}
+
+	user := authenticateUser(auth, scanner)
+	if user == nil {
+		return
+	}
+
+	// Если 2FA уже включена, замена второго фактора требует его подтверждения
+	if user.Is2FAEnabled {
+		fmt.Print("Текущий код 2FA: ")
+		if !scanner.Scan() {
+			return
+		}
+		if !auth.verifySecondFactor(user, strings.TrimSpace(scanner.Text())) {
+			fmt.Println("❌ Неверный код")
+			return
+		}
+	}
+
+	fmt.Print("Коснитесь YubiKey: ")
+	if !scanner.Scan() {
+		return
+	}
+	otp := strings.TrimSpace(scanner.Text())
+	if !isYubicoOTP(otp) {
+		fmt.Println("❌ Это не Yubico OTP (ожидается 44 символа modhex)")
+		return
+	}
+	if err := auth.yubico.Verify(otp, auth.random); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	var backupCodes []string
+	err := auth.store.Update(user.Username, func(user *User2FA) error {
+		user.YubiKeyID = yubicoPublicID(otp)
+		if !user.Is2FAEnabled {
+			backupCodes = generateBackupCodesList(auth.random, auth.backupPolicy)
+			user.BackupCodes = backupCodes
+			user.Is2FAEnabled = true
+			// Неподтвержденный секрет, выпущенный администратором, заменяется ключом
+			user.TotpSecret, user.OTPAlgorithm, user.PendingActivation = "", "", false
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	fmt.Printf("✅ YubiKey %s подключен. При входе достаточно коснуться ключа.\n", yubicoPublicID(otp))
+	if len(backupCodes) > 0 {
+		fmt.Println("🆘 РЕЗЕРВНЫЕ КОДЫ (сохраните в безопасном месте!):")
+		for i, code := range backupCodes {
+			fmt.Printf("   %2d. %s\n", i+1, code)
+		}
+	}
+}
+
+// Подключение ключа безопасности FIDO2 как второго фактора
+func enrollFIDO2(auth *TwoFactorAuth, scanner *bufio.Scanner) {
+	fmt.Println("=== ПОДКЛЮЧЕНИЕ КЛЮЧА БЕЗОПАСНОСТИ FIDO2 ===")
+	if auth.fido2 == nil {
+		fmt.Println("❌ Утилиты libfido2 не установлены (fido2-token, fido2-cred, fido2-assert)")
+		return
+	}
+
+	user := authenticateUser(auth, scanner)
+	if user == nil {
+		return
+	}
+
+	// Если 2FA уже включена, замена второго фактора требует его подтверждения
+	if user.Is2FAEnabled {
+		fmt.Print("Текущий код 2FA (Enter - ключ FIDO2): ")
+		if !scanner.Scan() {
+			return
+		}
+		if !auth.verifySecondFactor(user, strings.TrimSpace(scanner.Text())) {
+			fmt.Println("❌ Неверный код")
+			return
+		}
+	}
+
+	credID, publicKey, err := auth.fido2.Register(user.Username, auth.random)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	var backupCodes []string
+	err = auth.store.Update(user.Username, func(user *User2FA) error {
+		user.FIDO2CredID = credID
+		user.FIDO2Key = publicKey
+		if !user.Is2FAEnabled {
+			backupCodes = generateBackupCodesList(auth.random, auth.backupPolicy)
+			user.BackupCodes = backupCodes
+			user.Is2FAEnabled = true
+			// Неподтвержденный секрет, выпущенный администратором, заменяется ключом
+			user.TotpSecret, user.OTPAlgorithm, user.PendingActivation = "", "", false
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	fmt.Println("✅ Ключ безопасности подключен. При входе нажмите Enter вместо кода и коснитесь ключа.")
+	if len(backupCodes) > 0 {
+		fmt.Println("🆘 РЕЗЕРВНЫЕ КОДЫ (сохраните в безопасном месте!):")
+		for i, code := range backupCodes {
+			fmt.Printf("   %2d. %s\n", i+1, code)
+		}
+	}
+}
+
+// Массовое подключение 2FA: администратор выпускает секреты TOTP для списка пользователей
+// и печатает листы с QR-кодами; 2FA включается, когда пользователь подтвердит ее кодом при входе
+func bulkEnroll2FA(auth *TwoFactorAuth, scanner *bufio.Scanner, fontPath string) {
+	fmt.Println("=== МАССОВОЕ ПОДКЛЮЧЕНИЕ 2FA ===")
+
+	fmt.Print("Логины через запятую или путь к файлу со списком (по одному в строке): ")
+	if !scanner.Scan() {
+		return
+	}
+	input := strings.TrimSpace(scanner.Text())
+	text := input
+	if info, err := os.Stat(input); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(input)
+		if err != nil {
+			fmt.Printf("❌ Ошибка чтения списка: %v\n", err)
+			return
+		}
+		text = string(data)
+	}
+	usernames := parseUsernameList(text)
+	if len(usernames) == 0 {
+		fmt.Println("❌ Список пользователей пуст")
+		return
+	}
+
+	enrollments, skipped := auth.prepareEnrollments(usernames)
+	for _, reason := range skipped {
+		fmt.Printf("⚠️  Пропущен %s\n", reason)
+	}
+	if len(enrollments) == 0 {
+		fmt.Println("❌ Некого подключать")
+		return
+	}
+
+	fmt.Print("Файл PDF для листов подключения [2fa_enrollment.pdf]: ")
+	if !scanner.Scan() {
+		return
+	}
+	path := strings.TrimSpace(scanner.Text())
+	if path == "" {
+		path = "2fa_enrollment.pdf"
+	}
+
+	// Секреты сохраняются только после записи листов, иначе пользователи
+	// не смогли бы войти без кода, которого у них нет
+	if err := writeEnrollmentPDF(path, fontPath, enrollments, auth.clock.Now()); err != nil {
+		fmt.Printf("❌ Ошибка создания PDF: %v\n", err)
+		return
+	}
+	marked := 0
+	for _, enrollment := range enrollments {
+		if err := auth.markPending2FA(enrollment); err != nil {
+			fmt.Printf("❌ %s: %v\n", enrollment.Username, err)
+			continue
+		}
+		marked++
+	}
+
+	fmt.Printf("✅ Подготовлена 2FA для %d из %d пользователей, листы сохранены в %s\n", marked, len(usernames), path)
+	fmt.Println("📄 Передайте листы пользователям лично: при первом входе они подтвердят 2FA кодом из приложения")
+}
+
+// confirmPending2FA завершает вход пользователя с подготовленной администратором 2FA:
+// код из приложения подтверждает, что секрет с листа подключения добавлен
+func confirmPending2FA(auth *TwoFactorAuth, user *User2FA, scanner *bufio.Scanner) {
+	fmt.Println("🔐 Администратор подготовил для вас двухфакторную аутентификацию")
+	fmt.Println("   Отсканируйте QR-код с выданного листа приложением-аутентификатором")
+	fmt.Print("Введите код из приложения для подтверждения: ")
+	if !scanner.Scan() {
+		return
+	}
+
+	backupCodes, err := auth.activatePending2FA(user.Username, strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		fmt.Printf("❌ %v: 2FA не подтверждена, вход не выполнен\n", err)
+		return
+	}
+
+	fmt.Println("✅ Двухфакторная аутентификация подтверждена и включена!")
+	fmt.Println("🆘 РЕЗЕРВНЫЕ КОДЫ (сохраните в безопасном месте!):")
+	for i, code := range backupCodes {
+		fmt.Printf("   %2d. %s\n", i+1, code)
+	}
+	fmt.Printf("✅ Добро пожаловать, %s!\n", user.Username)
+	auth.recordLogin(user.Username)
+}
+
+// Функции аутентификации
+
+func (auth *TwoFactorAuth) authenticateFirstFactor(username, password string) AuthResult2FA {
+	user, exists := auth.store.GetUser(username)
+	if !exists {
+		return AuthResult2FA{false, "Пользователь не найден", false, nil}
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	if err != nil {
+		return AuthResult2FA{false, "Неверный пароль", false, nil}
+	}
+
+	return AuthResult2FA{true, "Первый фактор пройден", user.Is2FAEnabled || user.PendingActivation, user}
+}
+
+func (auth *TwoFactorAuth) verifySecondFactor(user *User2FA, code string) bool {
+	// Пустой ввод при подключенном ключе FIDO2 - подтверждение касанием ключа
+	if code == "" && user.FIDO2CredID != "" && auth.fido2 != nil {
+		if err := auth.fido2.Assert(user.FIDO2CredID, user.FIDO2Key, auth.random); err != nil {
+			fmt.Printf("❌ FIDO2: %v\n", err)
+			return false
+		}
+		return true
+	}
+
+	// Yubico OTP начинается с публичного идентификатора ключа пользователя
+	if user.YubiKeyID != "" && auth.yubico != nil && isYubicoOTP(code) && yubicoPublicID(code) == user.YubiKeyID {
+		return auth.yubico.Verify(code, auth.random) == nil
+	}
+
+	// Проверяем одноразовый код алгоритма пользователя
+	if code != "" && user.TotpSecret != "" && auth.verifyOTPCode(user.OTPAlgorithm, user.TotpSecret, code) {
+		return true
+	}
+
+	// Проверяем резервные коды (без учета дефисов и регистра).
+	// Поиск и удаление кода выполняются в одном обновлении, чтобы код нельзя было использовать дважды.
+	normalized := normalizeBackupCode(code)
+	matched := false
+	auth.store.Update(user.Username, func(user *User2FA) error {
+		for i, backupCode := range user.BackupCodes {
+			if normalized == normalizeBackupCode(backupCode) {
+				// Удаляем использованный одноразовый резервный код
+				if !auth.backupPolicy.MultiUse {
+					user.BackupCodes = append(user.BackupCodes[:i], user.BackupCodes[i+1:]...)
+				}
+				matched = true
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return matched
+}
+
+// recordLogin отмечает время успешного входа и возвращает обновленного пользователя
+func (auth *TwoFactorAuth) recordLogin(username string) *User2FA {
+	err := auth.store.Update(username, func(user *User2FA) error {
+		user.LastLogin = auth.clock.Now()
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	user, _ := auth.store.GetUser(username)
+	return user
+}
+
+// Функции генерации и проверки TOTP
+
+func generateTOTPSecret(random io.Reader) string {
+	// Генерируем 160-битный случайный секрет (рекомендация RFC 4226)
+	bytes := make([]byte, 20)
+	for i := range bytes {
+		randomBig, _ := rand.Int(random, big.NewInt(256))
+		bytes[i] = byte(randomBig.Int64())
+	}
+	
+	// Приложения-аутентификаторы принимают секрет в base32
+	return totpEncoding.EncodeToString(bytes)
+}
+
+// totpEncoding - base32 без дополнения, как в otpauth-ссылках
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// decodeTOTPSecret разбирает base32-секрет без учета регистра, пробелов и дополнения
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Алгоритмы одноразовых кодов
+const (
+	OTPTotp   = "totp"   // RFC 6238: HMAC-SHA1, 6 цифр
+	OTPSteam  = "steam"  // Steam Guard: HMAC-SHA1, 5 символов из алфавита Steam
+	OTPYandex = "yandex" // Яндекс Ключ: HMAC-SHA256 от ключа с PIN-кодом, 8 букв
+)
+
+// OTPAlgorithm вычисляет одноразовый код для момента времени. Все алгоритмы используют
+// шаг 30 секунд, поэтому окно проверки и диагностика часов для них общие.
+type OTPAlgorithm interface {
+	// Code возвращает код для момента timestamp (пустая строка - некорректный секрет)
+	Code(secret string, timestamp time.Time) string
+	// Normalize приводит введенный пользователем код к виду, который возвращает Code
+	Normalize(code string) string
+}
+
+// otpAlgorithms - поддерживаемые алгоритмы по названию
+var otpAlgorithms = map[string]OTPAlgorithm{
+	OTPTotp:   rfcTOTP{},
+	OTPSteam:  steamGuard{},
+	OTPYandex: yandexKey{},
+}
+
+// otpAlgorithm возвращает алгоритм по названию; пустое название - RFC 6238
+func otpAlgorithm(name string) (OTPAlgorithm, bool) {
+	if name == "" {
+		name = OTPTotp
+	}
+	algorithm, ok := otpAlgorithms[name]
+	return algorithm, ok
+}
+
+// hotpHash вычисляет HMAC от номера 30-секундного интервала
+func hotpHash(newHash func() hash.Hash, key []byte, timestamp time.Time) []byte {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(timestamp.Unix()/30)) // 30-секундные интервалы
+
+	mac := hmac.New(newHash, key)
+	mac.Write(counter)
+	return mac.Sum(nil)
+}
+
+// truncate выполняет динамическое усечение HMAC-SHA1 (RFC 4226, раздел 5.3)
+func truncate(hash []byte) uint32 {
+	offset := hash[len(hash)-1] & 0x0f
+	return binary.BigEndian.Uint32(hash[offset:offset+4]) & 0x7fffffff
+}
+
+// rfcTOTP - TOTP по RFC 6238 (HMAC-SHA1, 6 цифр)
+type rfcTOTP struct{}
+
+func (rfcTOTP) Code(secret string, timestamp time.Time) string {
+	return generateTOTPCode(secret, timestamp)
+}
+
+func (rfcTOTP) Normalize(code string) string {
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// steamGuard - коды Steam Guard: усечение как в RFC 4226, но результат записывается
+// пятью символами алфавита без похожих друг на друга букв и цифр
+type steamGuard struct{}
+
+const steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+
+func (steamGuard) Code(secret string, timestamp time.Time) string {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) == 0 {
+		return ""
+	}
+
+	value := truncate(hotpHash(sha1.New, key, timestamp))
+	code := make([]byte, 5)
+	for i := range code {
+		code[i] = steamAlphabet[value%uint32(len(steamAlphabet))]
+		value /= uint32(len(steamAlphabet))
+	}
+	return string(code)
+}
+
+func (steamGuard) Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// yandexKey - коды Яндекс Ключа. Секрет хранится в виде "PIN:SECRET": ключ HMAC-SHA256 -
+// SHA-256 от PIN-кода и первых 16 байт секрета, код - 8 латинских букв.
+type yandexKey struct{}
+
+func (yandexKey) Code(secret string, timestamp time.Time) string {
+	pin, encoded, ok := strings.Cut(secret, ":")
+	if !ok || pin == "" {
+		return ""
+	}
+	raw, err := decodeTOTPSecret(encoded)
+	if err != nil || len(raw) < 16 {
+		return ""
+	}
+
+	key := sha256.Sum256(append([]byte(pin), raw[:16]...))
+	hmacKey := key[:]
+	if hmacKey[0] == 0 {
+		hmacKey = hmacKey[1:]
+	}
+
+	hash := hotpHash(sha256.New, hmacKey, timestamp)
+	offset := hash[len(hash)-1] & 0x0f
+	value := binary.BigEndian.Uint64(hash[offset:offset+8]) & 0x7fffffffffffffff
+	value %= 208827064576 // 26^8
+
+	code := make([]byte, 8)
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i] = byte('a' + value%26)
+		value /= 26
+	}
+	return string(code)
+}
+
+func (yandexKey) Normalize(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// generateTOTPCode вычисляет код TOTP по RFC 6238 (HMAC-SHA1, 6 цифр, шаг 30 секунд).
+// Для некорректного секрета возвращает пустую строку.
+func generateTOTPCode(secret string, timestamp time.Time) string {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%06d", truncate(hotpHash(sha1.New, key, timestamp))%1000000)
+}
+
+// verifyOTPCode проверяет код алгоритма algorithm в окне ±1 интервал
+// для компенсации расхождения времени
+func (auth *TwoFactorAuth) verifyOTPCode(algorithm, secret, inputCode string) bool {
+	otp, ok := otpAlgorithm(algorithm)
+	if !ok {
+		return false
+	}
+	inputCode = otp.Normalize(inputCode)
+	currentTime := auth.clock.Now()
+	
+	for offset := -1; offset <= 1; offset++ {
+		testTime := currentTime.Add(time.Duration(offset*30) * time.Second)
+		expectedCode := otp.Code(secret, testTime)
+		
+		if expectedCode != "" && hmac.Equal([]byte(inputCode), []byte(expectedCode)) {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// queryNTPOffset запрашивает время у NTP-сервера (SNTP, RFC 4330)
+// и возвращает смещение локальных часов относительно него (порт по умолчанию 123, можно "адрес:порт")
+func queryNTPOffset(server string, clock Clock) (time.Duration, error) {
+	address := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		address = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", address, 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return 0, err
+	}
+
+	// Метка отправки запроса - случайное число: сервер возвращает ее в поле Originate,
+	// что отличает ответ на этот запрос от подложного или запоздавшего
+	request := make([]byte, ntpPacketSize)
+	request[0] = 0x1B // LI = 0, версия 3, режим клиента
+	if _, err := rand.Read(request[40:48]); err != nil {
+		return 0, err
+	}
+
+	sentAt := clock.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	response := make([]byte, ntpPacketSize+1)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+	receivedAt := clock.Now()
+
+	serverReceive, serverTransmit, err := parseNTPResponse(response[:n], request[40:48])
+	if err != nil {
+		return 0, err
+	}
+
+	offset := (serverReceive.Sub(sentAt) + serverTransmit.Sub(receivedAt)) / 2
+	return offset, nil
+}
+
+// ntpPacketSize - размер пакета NTP без расширений и аутентификации
+const ntpPacketSize = 48
+
+// parseNTPResponse проверяет ответ сервера и возвращает метки приема запроса и отправки ответа.
+// Отклоняются ответы не в режиме сервера (4), с индикатором LI = 3 (часы сервера не
+// синхронизированы), со stratum вне 1-15 (0 - пакет Kiss-o'-Death) и чужим полем Originate.
+func parseNTPResponse(response, origin []byte) (receive, transmit time.Time, err error) {
+	if len(response) < ntpPacketSize {
+		return time.Time{}, time.Time{}, fmt.Errorf("короткий ответ NTP: %d байт", len(response))
+	}
+	leap, mode, stratum := response[0]>>6, response[0]&0x07, response[1]
+	switch {
+	case mode != 4:
+		return time.Time{}, time.Time{}, fmt.Errorf("ответ не в режиме сервера (режим %d)", mode)
+	case leap == 3:
+		return time.Time{}, time.Time{}, fmt.Errorf("часы сервера не синхронизированы (LI = 3)")
+	case stratum == 0:
+		return time.Time{}, time.Time{}, fmt.Errorf("сервер отказал в обслуживании (Kiss-o'-Death %q)", response[12:16])
+	case stratum > 15:
+		return time.Time{}, time.Time{}, fmt.Errorf("недопустимый stratum %d", stratum)
+	case !bytes.Equal(response[24:32], origin):
+		return time.Time{}, time.Time{}, fmt.Errorf("ответ не соответствует запросу (поле Originate)")
+	case binary.BigEndian.Uint64(response[40:48]) == 0:
+		return time.Time{}, time.Time{}, fmt.Errorf("в ответе нет времени отправки")
+	}
+	return ntpTimestamp(response[32:40]), ntpTimestamp(response[40:48]), nil
+}
+
+// ntpTimestamp переводит 64-битную метку времени NTP в time.Time
+func ntpTimestamp(b []byte) time.Time {
+	const ntpEpochOffset = 2208988800 // секунд между 1900 и 1970 годами
+
+	seconds := int64(binary.BigEndian.Uint32(b[0:4]))
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+
+	return time.Unix(seconds-ntpEpochOffset, (fraction*1e9)>>32)
+}
+
+// Перенос секретов TOTP между системами (форматы Aegis и andOTP)
+
+// totpIssuer - название системы в записях приложений-аутентификаторов
+const totpIssuer = "Система 2FA"
+
+// TOTPEntry - секрет TOTP с параметрами, как его хранят приложения-аутентификаторы
+type TOTPEntry struct {
+	Type   string // Алгоритм: totp, steam, yandex
+	Name   string // Имя учетной записи
+	Issuer string // Название системы
+	Secret string // Секрет в base32
+	Algo   string // Хеш-функция HMAC
+	Digits int    // Количество цифр кода
+	Period int    // Шаг в секундах
+	Pin    string // PIN-код (только Яндекс Ключ)
+}
+
+// compatible проверяет, что коды записи совпадут с кодами этой системы
+func (e TOTPEntry) compatible() error {
+	expected := map[string]struct {
+		algo   string
+		digits int
+	}{
+		OTPTotp:   {"SHA1", 6},
+		OTPSteam:  {"SHA1", 5},
+		OTPYandex: {"SHA256", 8},
+	}
+	params, ok := expected[e.Type]
+	if !ok {
+		return fmt.Errorf("тип %s не поддерживается", e.Type)
+	}
+	if !strings.EqualFold(e.Algo, params.algo) || e.Digits != params.digits || e.Period != 30 {
+		return fmt.Errorf("параметры %s/%d/%d с не поддерживаются для %s (нужны %s/%d/30)",
+			e.Algo, e.Digits, e.Period, e.Type, params.algo, params.digits)
+	}
+	if e.Type == OTPYandex && e.Pin == "" {
+		return fmt.Errorf("для Яндекс Ключа нужен PIN-код")
+	}
+	if key, err := decodeTOTPSecret(e.Secret); err != nil || len(key) == 0 {
+		return fmt.Errorf("некорректный секрет")
+	}
+	return nil
+}
+
+// storedSecret возвращает секрет в том виде, в котором его хранит User2FA
+func (e TOTPEntry) storedSecret() string {
+	secret := strings.ToUpper(strings.ReplaceAll(e.Secret, " ", ""))
+	if e.Type == OTPYandex {
+		return e.Pin + ":" + secret
+	}
+	return secret
+}
+
+// userTOTPEntry описывает второй фактор пользователя записью для приложения-аутентификатора
+func userTOTPEntry(user *User2FA) TOTPEntry {
+	entry := TOTPEntry{Type: OTPTotp, Name: user.Username, Issuer: totpIssuer, Secret: user.TotpSecret, Algo: "SHA1", Digits: 6, Period: 30}
+	switch user.OTPAlgorithm {
+	case OTPSteam:
+		entry.Type, entry.Digits = OTPSteam, 5
+	case OTPYandex:
+		entry.Type, entry.Algo, entry.Digits = OTPYandex, "SHA256", 8
+		entry.Pin, entry.Secret, _ = strings.Cut(user.TotpSecret, ":")
+	}
+	return entry
+}
+
+// aegisVault - файл хранилища Aegis (версия 1): db - объект в открытом виде или base64 шифротекста
+type aegisVault struct {
+	Version int             `json:"version"`
+	Header  aegisHeader     `json:"header"`
+	DB      json.RawMessage `json:"db"`
+}
+
+type aegisHeader struct {
+	Slots  []aegisSlot  `json:"slots"`
+	Params *aegisParams `json:"params"`
+}
+
+type aegisParams struct {
+	Nonce string `json:"nonce"`
+	Tag   string `json:"tag"`
+}
+
+// aegisSlot - мастер-ключ, зашифрованный ключом из пароля (тип 1, scrypt)
+type aegisSlot struct {
+	Type      int         `json:"type"`
+	UUID      string      `json:"uuid"`
+	Key       string      `json:"key"`
+	KeyParams aegisParams `json:"key_params"`
+	N         int         `json:"n"`
+	R         int         `json:"r"`
+	P         int         `json:"p"`
+	Salt      string      `json:"salt"`
+}
+
+type aegisDB struct {
+	Version int          `json:"version"`
+	Entries []aegisEntry `json:"entries"`
+}
+
+type aegisEntry struct {
+	Type   string    `json:"type"`
+	UUID   string    `json:"uuid"`
+	Name   string    `json:"name"`
+	Issuer string    `json:"issuer"`
+	Note   string    `json:"note"`
+	Icon   *string   `json:"icon"`
+	Info   aegisInfo `json:"info"`
+}
+
+type aegisInfo struct {
+	Secret string `json:"secret"`
+	Algo   string `json:"algo"`
+	Digits int    `json:"digits"`
+	Period int    `json:"period"`
+	Pin    string `json:"pin,omitempty"` // Только для записей yandex
+}
+
+// andOTPEntry - запись резервной копии andOTP
+type andOTPEntry struct {
+	Secret        string   `json:"secret"`
+	Issuer        string   `json:"issuer"`
+	Label         string   `json:"label"`
+	Digits        int      `json:"digits"`
+	Type          string   `json:"type"`
+	Algorithm     string   `json:"algorithm"`
+	Thumbnail     string   `json:"thumbnail"`
+	LastUsed      int64    `json:"last_used"`
+	UsedFrequency int      `json:"used_frequency"`
+	Period        int      `json:"period"`
+	Tags          []string `json:"tags"`
+}
+
+const (
+	aegisScryptN      = 1 << 15 // Параметры scrypt, которые использует Aegis
+	aegisScryptR      = 8
+	aegisScryptP      = 1
+	andOTPIterations  = 150000 // Итерации PBKDF2 для резервной копии andOTP
+	andOTPHeaderBytes = 4 + 12 + 12
+)
+
+// exportAegis возвращает зашифрованное паролем хранилище Aegis с одной записью
+func exportAegis(entry TOTPEntry, password string, random io.Reader) ([]byte, error) {
+	db, err := json.Marshal(aegisDB{Version: 2, Entries: []aegisEntry{{
+		Type:   entry.Type,
+		UUID:   newUUID(random),
+		Name:   entry.Name,
+		Issuer: entry.Issuer,
+		Info:   aegisInfo{Secret: entry.Secret, Algo: entry.Algo, Digits: entry.Digits, Period: entry.Period, Pin: entry.Pin},
+	}}})
+	if err != nil {
+		return nil, err
+	}
+
+	masterKey, salt := make([]byte, 32), make([]byte, 32)
+	if _, err := io.ReadFull(random, masterKey); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+	slotKey, err := scrypt.Key([]byte(password), salt, aegisScryptN, aegisScryptR, aegisScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNonce, encryptedKey, keyTag, err := sealAESGCM(slotKey, masterKey, random)
+	if err != nil {
+		return nil, err
+	}
+	dbNonce, encryptedDB, dbTag, err := sealAESGCM(masterKey, db, random)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedDB, _ := json.Marshal(base64.StdEncoding.EncodeToString(encryptedDB))
+	vault := aegisVault{
+		Version: 1,
+		Header: aegisHeader{
+			Slots: []aegisSlot{{
+				Type:      1,
+				UUID:      newUUID(random),
+				Key:       hex.EncodeToString(encryptedKey),
+				KeyParams: aegisParams{Nonce: hex.EncodeToString(keyNonce), Tag: hex.EncodeToString(keyTag)},
+				N:         aegisScryptN,
+				R:         aegisScryptR,
+				P:         aegisScryptP,
+				Salt:      hex.EncodeToString(salt),
+			}},
+			Params: &aegisParams{Nonce: hex.EncodeToString(dbNonce), Tag: hex.EncodeToString(dbTag)},
+		},
+		DB: encodedDB,
+	}
+	return json.MarshalIndent(vault, "", "  ")
+}
+
+// exportAndOTP возвращает зашифрованную резервную копию andOTP (.json.aes):
+// итерации PBKDF2, соль и nonce, затем JSON, зашифрованный AES-256-GCM
+func exportAndOTP(entry TOTPEntry, password string, random io.Reader) ([]byte, error) {
+	if entry.Type == OTPYandex {
+		return nil, fmt.Errorf("andOTP не поддерживает Яндекс Ключ, используйте формат aegis")
+	}
+	plain, err := json.Marshal([]andOTPEntry{{
+		Secret:    entry.Secret,
+		Issuer:    entry.Issuer,
+		Label:     entry.Name,
+		Digits:    entry.Digits,
+		Type:      strings.ToUpper(entry.Type),
+		Algorithm: entry.Algo,
+		Thumbnail: "Default",
+		Period:    entry.Period,
+		Tags:      []string{},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 12)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key([]byte(password), salt, andOTPIterations, 32, sha1.New)
+	nonce, ciphertext, tag, err := sealAESGCM(key, plain, random)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(andOTPIterations))
+	out.Write(salt)
+	out.Write(nonce)
+	out.Write(ciphertext)
+	out.Write(tag)
+	return out.Bytes(), nil
+}
+
+// importTOTPEntries разбирает хранилище Aegis (открытое или зашифрованное) или резервную
+// копию andOTP (открытый JSON или .json.aes) и возвращает записи TOTP
+func importTOTPEntries(data []byte, password string) ([]TOTPEntry, error) {
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return importAegis(trimmed, password)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return importAndOTP(trimmed)
+	}
+
+	if len(data) < andOTPHeaderBytes+16 {
+		return nil, fmt.Errorf("формат файла не распознан")
+	}
+	iterations := int(binary.BigEndian.Uint32(data[0:4]))
+	salt, nonce := data[4:16], data[16:28]
+	key := pbkdf2.Key([]byte(password), salt, iterations, 32, sha1.New)
+	plain, err := openAESGCM(key, nonce, data[andOTPHeaderBytes:])
+	if err != nil {
+		return nil, fmt.Errorf("неверный пароль или поврежденный файл andOTP")
+	}
+	return importAndOTP(plain)
+}
+
+func importAegis(data []byte, password string) ([]TOTPEntry, error) {
+	var vault aegisVault
+	if err := json.Unmarshal(data, &vault); err != nil {
+		return nil, fmt.Errorf("некорректное хранилище Aegis: %v", err)
+	}
+	if vault.Version != 1 {
+		return nil, fmt.Errorf("неподдерживаемая версия хранилища Aegis: %d", vault.Version)
+	}
+
+	plain := []byte(vault.DB)
+	if vault.Header.Params != nil {
+		masterKey, err := unlockAegisSlots(vault.Header.Slots, password)
+		if err != nil {
+			return nil, err
+		}
+		var encoded string
+		if err := json.Unmarshal(vault.DB, &encoded); err != nil {
+			return nil, fmt.Errorf("некорректное хранилище Aegis: %v", err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("некорректное хранилище Aegis: %v", err)
+		}
+		if plain, err = openAESGCMHex(masterKey, *vault.Header.Params, ciphertext); err != nil {
+			return nil, fmt.Errorf("поврежденное хранилище Aegis")
+		}
+	}
+
+	var db aegisDB
+	if err := json.Unmarshal(plain, &db); err != nil {
+		return nil, fmt.Errorf("некорректное хранилище Aegis: %v", err)
+	}
+	var entries []TOTPEntry
+	for _, e := range db.Entries {
+		if _, ok := otpAlgorithm(e.Type); !ok {
+			continue
+		}
+		entries = append(entries, TOTPEntry{e.Type, e.Name, e.Issuer, e.Info.Secret, e.Info.Algo, e.Info.Digits, e.Info.Period, e.Info.Pin})
+	}
+	return entries, nil
+}
+
+// unlockAegisSlots расшифровывает мастер-ключ хранилища Aegis паролем
+func unlockAegisSlots(slots []aegisSlot, password string) ([]byte, error) {
+	for _, slot := range slots {
+		if slot.Type != 1 {
+			continue
+		}
+		salt, err := hex.DecodeString(slot.Salt)
+		if err != nil {
+			continue
+		}
+		slotKey, err := scrypt.Key([]byte(password), salt, slot.N, slot.R, slot.P, 32)
+		if err != nil {
+			continue
+		}
+		encryptedKey, err := hex.DecodeString(slot.Key)
+		if err != nil {
+			continue
+		}
+		if masterKey, err := openAESGCMHex(slotKey, slot.KeyParams, encryptedKey); err == nil {
+			return masterKey, nil
+		}
+	}
+	return nil, fmt.Errorf("неверный пароль хранилища Aegis")
+}
+
+func importAndOTP(data []byte) ([]TOTPEntry, error) {
+	var backup []andOTPEntry
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return nil, fmt.Errorf("некорректная резервная копия andOTP: %v", err)
+	}
+	var entries []TOTPEntry
+	for _, e := range backup {
+		otpType := strings.ToLower(e.Type)
+		if otpType != OTPTotp && otpType != OTPSteam {
+			continue
+		}
+		entries = append(entries, TOTPEntry{otpType, e.Label, e.Issuer, e.Secret, e.Algorithm, e.Digits, e.Period, ""})
+	}
+	return entries, nil
+}
+
+// sealAESGCM шифрует данные AES-256-GCM и возвращает nonce, шифротекст и тег отдельно
+func sealAESGCM(key, plain []byte, random io.Reader) (nonce, ciphertext, tag []byte, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := gcm.Seal(nil, nonce, plain, nil)
+	split := len(sealed) - gcm.Overhead()
+	return nonce, sealed[:split], sealed[split:], nil
+}
+
+// openAESGCM расшифровывает AES-GCM, тег - в конце шифротекста
+func openAESGCM(key, nonce, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+// openAESGCMHex расшифровывает AES-GCM с nonce и тегом в hex, как в хранилище Aegis
+func openAESGCMHex(key []byte, params aegisParams, ciphertext []byte) ([]byte, error) {
+	nonce, err := hex.DecodeString(params.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := hex.DecodeString(params.Tag)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(key, nonce, append(append([]byte{}, ciphertext...), tag...))
+}
+
+// newUUID возвращает случайный UUID версии 4
+func newUUID(random io.Reader) string {
+	b := make([]byte, 16)
+	io.ReadFull(random, b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// Проверка Yubico OTP (YubiKey) на сервере проверки
+
+// yubiCloudURL - сервер проверки YubiCloud
+const yubiCloudURL = "https://api.yubico.com/wsapi/2.0/verify"
+
+// yubicoModhex - алфавит modhex, которым YubiKey кодирует OTP
+const yubicoModhex = "cbdefghijklnrtuv"
+
+// YubicoValidator проверяет Yubico OTP на сервере проверки по протоколу 2.0
+// (YubiCloud или собственный, например yubikey-val)
+type YubicoValidator struct {
+	url      string
+	clientID string
+	apiKey   []byte // Ключ подписи HMAC-SHA1 (nil - запросы и ответы не подписываются)
+	client   *http.Client
+}
+
+// NewYubicoValidator создает клиент сервера проверки; apiKey - ключ API в base64 (может быть пустым)
+func NewYubicoValidator(serverURL, clientID, apiKey string) (*YubicoValidator, error) {
+	if _, err := url.ParseRequestURI(serverURL); err != nil {
+		return nil, fmt.Errorf("некорректный адрес сервера проверки: %v", err)
+	}
+	validator := &YubicoValidator{
+		url:      serverURL,
+		clientID: clientID,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if apiKey != "" {
+		key, err := base64.StdEncoding.DecodeString(apiKey)
+		if err != nil {
+			return nil, fmt.Errorf("ключ API должен быть в base64: %v", err)
+		}
+		validator.apiKey = key
+	}
+	return validator, nil
+}
+
+// isYubicoOTP проверяет, что строка похожа на Yubico OTP: 32-48 символов modhex
+// (обычно 44 - 12 символов идентификатора и 32 символа зашифрованного блока)
+func isYubicoOTP(otp string) bool {
+	if len(otp) < 32 || len(otp) > 48 {
+		return false
+	}
+	for _, c := range otp {
+		if !strings.ContainsRune(yubicoModhex, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// yubicoPublicID возвращает публичный идентификатор ключа - часть OTP перед зашифрованным блоком
+func yubicoPublicID(otp string) string {
+	return otp[:len(otp)-32]
+}
+
+// Verify проверяет OTP на сервере. Ответ принимается, только если он относится к этому
+// запросу (совпадают otp и nonce) и, при заданном ключе API, подписан сервером.
+func (v *YubicoValidator) Verify(otp string, random io.Reader) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := io.ReadFull(random, nonceBytes); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	params := map[string]string{"id": v.clientID, "otp": otp, "nonce": nonce}
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	if v.apiKey != nil {
+		query.Set("h", v.sign(params))
+	}
+
+	response, err := v.client.Get(v.url + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("сервер проверки недоступен: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("сервер проверки ответил %s", response.Status)
+	}
+
+	answer := map[string]string{}
+	lines := bufio.NewScanner(io.LimitReader(response.Body, 4096))
+	for lines.Scan() {
+		if key, value, ok := strings.Cut(strings.TrimSpace(lines.Text()), "="); ok {
+			answer[key] = value
+		}
+	}
+
+	if v.apiKey != nil {
+		signature := answer["h"]
+		delete(answer, "h")
+		if !hmac.Equal([]byte(signature), []byte(v.sign(answer))) {
+			return fmt.Errorf("неверная подпись ответа сервера проверки")
+		}
+	}
+	if answer["otp"] != otp || answer["nonce"] != nonce {
+		return fmt.Errorf("ответ сервера проверки не соответствует запросу")
+	}
+
+	switch status := answer["status"]; status {
+	case "OK":
+		return nil
+	case "BAD_OTP":
+		return fmt.Errorf("неверный код YubiKey")
+	case "REPLAYED_OTP":
+		return fmt.Errorf("код YubiKey уже использован")
+	default:
+		return fmt.Errorf("сервер проверки отклонил запрос: %s", status)
+	}
+}
+
+// sign вычисляет подпись протокола 2.0: HMAC-SHA1 от параметров, отсортированных по имени
+func (v *YubicoValidator) sign(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + params[key]
+	}
+
+	mac := hmac.New(sha1.New, v.apiKey)
+	mac.Write([]byte(strings.Join(pairs, "&")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Ключи безопасности FIDO2 через утилиты libfido2 (fido2-token, fido2-cred, fido2-assert)
+
+// fido2RelyingParty - идентификатор проверяющей стороны для учетных данных FIDO2
+const fido2RelyingParty = "ib2-password-security"
+
+// fido2Timeout - сколько ждать касания ключа
+const fido2Timeout = 60 * time.Second
+
+// FIDO2Tools выполняет регистрацию и проверку ключа безопасности утилитами libfido2.
+// Утилиты сами запрашивают PIN-код ключа с терминала и просят коснуться ключа.
+type FIDO2Tools struct {
+	device string // Путь к устройству (пусто - первое найденное)
+}
+
+// NewFIDO2Tools возвращает nil, если утилиты libfido2 не установлены
+func NewFIDO2Tools(device string) *FIDO2Tools {
+	for _, tool := range []string{"fido2-token", "fido2-cred", "fido2-assert"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil
+		}
+	}
+	return &FIDO2Tools{device: device}
+}
+
+// findDevice возвращает заданное устройство или первое из списка fido2-token -L
+func (f *FIDO2Tools) findDevice() (string, error) {
+	if f.device != "" {
+		return f.device, nil
+	}
+	output, err := f.run(nil, "fido2-token", "-L")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if path, _, ok := strings.Cut(line, ": "); ok && path != "" {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("ключ безопасности не подключен")
+}
+
+// run запускает утилиту libfido2, передавая input на stdin; сообщения утилиты видны пользователю
+func (f *FIDO2Tools) run(input []string, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fido2Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	if input != nil {
+		cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	}
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("ключ не ответил за %v", fido2Timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return output, nil
+}
+
+// fido2Challenge возвращает случайный хеш клиентских данных в base64
+func fido2Challenge(random io.Reader) (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := io.ReadFull(random, challenge); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(challenge), nil
+}
+
+// Register создает на ключе учетные данные ES256 для пользователя и проверяет аттестацию.
+// Возвращает идентификатор учетных данных (base64) и открытый ключ (PEM).
+func (f *FIDO2Tools) Register(username string, random io.Reader) (string, string, error) {
+	device, err := f.findDevice()
+	if err != nil {
+		return "", "", err
+	}
+	challenge, err := fido2Challenge(random)
+	if err != nil {
+		return "", "", err
+	}
+	userID := make([]byte, 32)
+	if _, err := io.ReadFull(random, userID); err != nil {
+		return "", "", err
+	}
+
+	fmt.Println("👆 Коснитесь ключа безопасности...")
+	credential, err := f.run([]string{challenge, fido2RelyingParty, username, base64.StdEncoding.EncodeToString(userID)},
+		"fido2-cred", "-M", device, "es256")
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(credential)), "\n")
+	if len(lines) < 6 || lines[0] != challenge || lines[1] != fido2RelyingParty {
+		return "", "", fmt.Errorf("ключ вернул учетные данные не для этого запроса")
+	}
+
+	// fido2-cred -V проверяет аттестацию и выводит идентификатор и открытый ключ
+	verified, err := f.run(lines, "fido2-cred", "-V", "es256")
+	if err != nil {
+		return "", "", err
+	}
+	credID, publicKey, ok := strings.Cut(string(verified), "\n")
+	if !ok || !strings.Contains(publicKey, "PUBLIC KEY") {
+		return "", "", fmt.Errorf("не удалось получить открытый ключ")
+	}
+	return strings.TrimSpace(credID), publicKey, nil
+}
+
+// Assert запрашивает у ключа подпись случайного вызова и проверяет ее открытым ключом
+// пользователя с обязательным подтверждением присутствия (касанием)
+func (f *FIDO2Tools) Assert(credID, publicKey string, random io.Reader) error {
+	device, err := f.findDevice()
+	if err != nil {
+		return err
+	}
+	challenge, err := fido2Challenge(random)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("👆 Коснитесь ключа безопасности...")
+	assertion, err := f.run([]string{challenge, fido2RelyingParty, credID}, "fido2-assert", "-G", "-p", device)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSpace(string(assertion)), "\n")
+	if len(lines) < 4 {
+		return fmt.Errorf("неполный ответ ключа")
+	}
+
+	keyFile, err := os.CreateTemp("", "fido2-key-*.pem")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyFile.Name())
+	_, err = keyFile.WriteString(publicKey)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Проверяется подпись нашего вызова, а не того, что вернул ключ
+	if _, err := f.run([]string{challenge, fido2RelyingParty, lines[2], lines[3]},
+		"fido2-assert", "-V", "-p", keyFile.Name(), "es256"); err != nil {
+		return fmt.Errorf("подпись ключа не прошла проверку")
+	}
+	return nil
+}
+
+// Массовое подключение 2FA: листы с QR-кодами для приложений-аутентификаторов
+
+// Шрифты TrueType с кириллицей, которые ищутся для листов подключения в PDF
+var pdfFontCandidates = []string{
+	"/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/dejavu/DejaVuSans.ttf",
+	"/usr/share/fonts/TTF/DejaVuSans.ttf",
+	"/usr/share/fonts/truetype/liberation/LiberationSans-Regular.ttf",
+	"/usr/share/fonts/liberation-sans/LiberationSans-Regular.ttf",
+	"/Library/Fonts/Arial Unicode.ttf",
+	"/System/Library/Fonts/Supplemental/Arial.ttf",
+	`C:\Windows\Fonts\arial.ttf`,
+}
+
+// PendingEnrollment - секрет TOTP, выпущенный администратором до подтверждения пользователем
+type PendingEnrollment struct {
+	Username string
+	Secret   string
+	URI      string // Ссылка otpauth:// для QR-кода
+}
+
+// otpauthURI возвращает ссылку otpauth://totp/, которую приложения-аутентификаторы читают из QR-кода
+func otpauthURI(entry TOTPEntry) string {
+	escape := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	}
+	return fmt.Sprintf("otpauth://totp/%s:%s?secret=%s&issuer=%s",
+		escape(entry.Issuer), escape(entry.Name), entry.Secret, escape(entry.Issuer))
+}
+
+// parseUsernameList разбирает список логинов: через запятую, пробелы или по одному в строке.
+// Пустые строки и строки, начинающиеся с #, пропускаются; повторы убираются.
+func parseUsernameList(text string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, username := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ';' || r == ' ' || r == '\t' }) {
+			if !seen[username] {
+				seen[username] = true
+				usernames = append(usernames, username)
+			}
+		}
+	}
+	return usernames
+}
+
+// prepareEnrollments выпускает секреты TOTP для пользователей списка, не сохраняя их.
+// Пользователи, которых нет или у которых 2FA уже включена, возвращаются в skipped с причиной;
+// для ожидающих подтверждения выпускается новый секрет взамен прежнего.
+func (auth *TwoFactorAuth) prepareEnrollments(usernames []string) (enrollments []PendingEnrollment, skipped []string) {
+	for _, username := range usernames {
+		user, exists := auth.store.GetUser(username)
+		switch {
+		case !exists:
+			skipped = append(skipped, username+": пользователь не найден")
+			continue
+		case user.Is2FAEnabled:
+			skipped = append(skipped, username+": 2FA уже включена")
+			continue
+		}
+		secret := generateTOTPSecret(auth.random)
+		entry := TOTPEntry{Type: OTPTotp, Name: username, Issuer: totpIssuer, Secret: secret, Algo: "SHA1", Digits: 6, Period: 30}
+		enrollments = append(enrollments, PendingEnrollment{Username: username, Secret: secret, URI: otpauthURI(entry)})
+	}
+	return enrollments, skipped
+}
+
+// markPending2FA сохраняет выпущенный секрет и отмечает 2FA пользователя как ожидающую подтверждения
+func (auth *TwoFactorAuth) markPending2FA(enrollment PendingEnrollment) error {
+	return auth.store.Update(enrollment.Username, func(user *User2FA) error {
+		if user.Is2FAEnabled {
+			return fmt.Errorf("2FA уже включена")
+		}
+		user.TotpSecret = enrollment.Secret
+		user.OTPAlgorithm = OTPTotp
+		user.BackupCodes = []string{}
+		user.PendingActivation = true
+		return nil
+	})
+}
+
+// activatePending2FA включает подготовленную администратором 2FA, если код из приложения
+// совпал с выпущенным секретом, и возвращает новые резервные коды
+func (auth *TwoFactorAuth) activatePending2FA(username, code string) ([]string, error) {
+	backupCodes := generateBackupCodesList(auth.random, auth.backupPolicy)
+	err := auth.store.Update(username, func(user *User2FA) error {
+		if !user.PendingActivation {
+			return fmt.Errorf("2FA не ожидает подтверждения")
+		}
+		if !auth.verifyOTPCode(OTPTotp, user.TotpSecret, code) {
+			return fmt.Errorf("неверный код аутентификации")
+		}
+		user.BackupCodes = backupCodes
+		user.Is2FAEnabled = true
+		user.PendingActivation = false
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backupCodes, nil
+}
+
+// writeEnrollmentPDF сохраняет листы подключения 2FA: по странице на пользователя с QR-кодом,
+// секретом для ручного ввода и порядком подтверждения при первом входе
+func writeEnrollmentPDF(path, fontPath string, enrollments []PendingEnrollment, issued time.Time) error {
+	if fontPath == "" {
+		for _, candidate := range pdfFontCandidates {
+			if _, err := os.Stat(candidate); err == nil {
+				fontPath = candidate
+				break
+			}
+		}
+		if fontPath == "" {
+			return fmt.Errorf("не найден шрифт с кириллицей, укажите его флагом -pdf-font")
+		}
+	}
+	font, err := os.ReadFile(fontPath)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения шрифта: %v", err)
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(20, 20, 20)
+	pdf.AddUTF8FontFromBytes("text", "", font)
+	for _, enrollment := range enrollments {
+		matrix, err := encodeQR([]byte(enrollment.URI))
+		if err != nil {
+			return fmt.Errorf("%s: %v", enrollment.Username, err)
+		}
+
+		pdf.AddPage()
+		pdf.SetFont("text", "", 16)
+		pdf.MultiCell(0, 9, "Подключение двухфакторной аутентификации", "", "L", false)
+		pdf.Ln(2)
+		pdf.SetFont("text", "", 11)
+		pdf.CellFormat(40, 7, "Система", "1", 0, "L", false, 0, "")
+		pdf.CellFormat(80, 7, totpIssuer, "1", 1, "L", false, 0, "")
+		pdf.CellFormat(40, 7, "Пользователь", "1", 0, "L", false, 0, "")
+		pdf.CellFormat(80, 7, enrollment.Username, "1", 1, "L", false, 0, "")
+		pdf.CellFormat(40, 7, "Выдано", "1", 0, "L", false, 0, "")
+		pdf.CellFormat(80, 7, issued.Format("2006-01-02 15:04"), "1", 1, "L", false, 0, "")
+		pdf.Ln(4)
+
+		steps := []string{
+			"Установите приложение-аутентификатор (Google Authenticator, Aegis, andOTP и т.п.).",
+			"Отсканируйте QR-код приложением.",
+			"Войдите в систему с логином и паролем и введите 6-значный код из приложения - это подтвердит подключение 2FA.",
+			"Сохраните резервные коды, которые система покажет после подтверждения.",
+		}
+		for i, step := range steps {
+			pdf.MultiCell(0, 6, fmt.Sprintf("%d. %s", i+1, step), "", "L", false)
+		}
+
+		pdf.Ln(4)
+		const qrSize = 60.0
+		pageWidth, _ := pdf.GetPageSize()
+		drawQR(pdf, matrix, (pageWidth-qrSize)/2, pdf.GetY(), qrSize)
+		pdf.SetY(pdf.GetY() + qrSize + 4)
+
+		pdf.MultiCell(0, 6, "Если QR-код не сканируется, введите ключ в приложении вручную (тип - по времени):", "", "L", false)
+		pdf.SetFont("text", "", 14)
+		pdf.MultiCell(0, 9, groupSecret(enrollment.Secret), "", "C", false)
+		pdf.Ln(4)
+		pdf.SetFont("text", "", 9)
+		pdf.MultiCell(0, 5, "Лист содержит секретный ключ второго фактора. Передайте его пользователю лично "+
+			"и уничтожьте после подключения. Пока код не подтвержден, вход без него невозможен.", "", "L", false)
+	}
+	return pdf.OutputFileAndClose(path)
+}
+
+// drawQR рисует QR-код со свободной зоной в 4 модуля в квадрате size × size мм
+func drawQR(pdf *gofpdf.Fpdf, matrix [][]bool, x, y, size float64) {
+	const quietZone = 4
+	module := size / float64(len(matrix)+2*quietZone)
+	pdf.SetFillColor(0, 0, 0)
+	for row, modules := range matrix {
+		// Соседние темные модули строки рисуются одним прямоугольником, чтобы между ними не было щелей
+		for col := 0; col < len(modules); {
+			if !modules[col] {
+				col++
+				continue
+			}
+			start := col
+			for col < len(modules) && modules[col] {
+				col++
+			}
+			pdf.Rect(x+float64(quietZone+start)*module, y+float64(quietZone+row)*module,
+				float64(col-start)*module, module, "F")
+		}
+	}
+}
+
+// groupSecret разбивает секрет base32 на группы по 4 символа для ручного ввода
+func groupSecret(secret string) string {
+	var groups []string
+	for len(secret) > 4 {
+		groups = append(groups, secret[:4])
+		secret = secret[4:]
+	}
+	return strings.Join(append(groups, secret), " ")
+}
+
+// Кодирование QR (ISO/IEC 18004): байтовый режим, уровень коррекции M, версии 1-20.
+// Ссылки otpauth с кириллическим названием системы занимают около 200 байт, этого достаточно.
+
+// qrVersion - блоки кодовых слов версии QR на уровне коррекции M
+type qrVersion struct {
+	ecPerBlock int   // Кодовых слов коррекции в каждом блоке
+	blocks1    int   // Блоков первой группы
+	data1      int   // Кодовых слов данных в блоке первой группы
+	blocks2    int   // Блоков второй группы (в каждом на одно кодовое слово данных больше)
+	align      []int // Координаты центров выравнивающих узоров
+}
+
+// qrVersions - параметры версий 1-20 (индекс = версия - 1)
+var qrVersions = []qrVersion{
+	{10, 1, 16, 0, nil},
+	{16, 1, 28, 0, []int{6, 18}},
+	{26, 1, 44, 0, []int{6, 22}},
+	{18, 2, 32, 0, []int{6, 26}},
+	{24, 2, 43, 0, []int{6, 30}},
+	{16, 4, 27, 0, []int{6, 34}},
+	{18, 4, 31, 0, []int{6, 22, 38}},
+	{22, 2, 38, 2, []int{6, 24, 42}},
+	{22, 3, 36, 2, []int{6, 26, 46}},
+	{26, 4, 43, 1, []int{6, 28, 50}},
+	{30, 1, 50, 4, []int{6, 30, 54}},
+	{22, 6, 36, 2, []int{6, 32, 58}},
+	{22, 8, 37, 1, []int{6, 34, 62}},
+	{24, 4, 40, 5, []int{6, 26, 46, 66}},
+	{24, 5, 41, 5, []int{6, 26, 48, 70}},
+	{28, 7, 45, 3, []int{6, 26, 50, 74}},
+	{28, 10, 46, 1, []int{6, 30, 54, 78}},
+	{26, 9, 43, 4, []int{6, 30, 56, 82}},
+	{26, 3, 44, 11, []int{6, 30, 58, 86}},
+	{26, 3, 41, 13, []int{6, 34, 62, 90}},
+}
+
+// dataCodewords возвращает число кодовых слов данных версии
+func (v qrVersion) dataCodewords() int {
+	return v.blocks1*v.data1 + v.blocks2*(v.data1+1)
+}
+
+// qrMatrix - модули QR-кода; function отмечает служебные узоры, которые не маскируются
+type qrMatrix struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// encodeQR кодирует данные в QR-код наименьшей подходящей версии.
+// Возвращает модули по строкам: true - темный модуль.
+func encodeQR(data []byte) ([][]bool, error) {
+	version := 0
+	for i, v := range qrVersions {
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*v.dataCodewords() {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("данные не помещаются в QR-код (%d байт)", len(data))
+	}
+	params := qrVersions[version-1]
+
+	// Поток битов: режим 0100 (байтовый), длина, данные, терминатор и байты-заполнители
+	var bits []bool
+	appendBits := func(value, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>uint(i))&1 == 1)
+		}
+	}
+	appendBits(0x4, 4)
+	if version >= 10 {
+		appendBits(len(data), 16)
+	} else {
+		appendBits(len(data), 8)
+	}
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	capacity := 8 * params.dataCodewords()
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, capacity/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	// Блоки данных с кодами Рида-Соломона, кодовые слова блоков чередуются
+	var blocks, ecBlocks [][]byte
+	for i := 0; i < params.blocks1+params.blocks2; i++ {
+		length := params.data1
+		if i >= params.blocks1 {
+			length++
+		}
+		blocks = append(blocks, codewords[:length])
+		ecBlocks = append(ecBlocks, qrReedSolomon(codewords[:length], params.ecPerBlock))
+		codewords = codewords[length:]
+	}
+	var interleaved []byte
+	for i := 0; i <= params.data1; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				interleaved = append(interleaved, block[i])
+			}
+		}
+	}
+	for i := 0; i < params.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			interleaved = append(interleaved, block[i])
+		}
+	}
+
+	matrix := newQRMatrix(version)
+	matrix.drawCodewords(interleaved)
+
+	// Выбирается маска с наименьшим штрафом
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		matrix.applyMask(mask)
+		matrix.drawFormatBits(mask)
+		if penalty := matrix.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		matrix.applyMask(mask)
+	}
+	matrix.applyMask(best)
+	matrix.drawFormatBits(best)
+	return matrix.modules, nil
+}
+
+// newQRMatrix создает матрицу версии со служебными узорами: поисковыми, синхронизации,
+// выравнивающими, местом под формат и номером версии
+func newQRMatrix(version int) *qrMatrix {
+	size := 17 + 4*version
+	m := &qrMatrix{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range m.modules {
+		m.modules[y] = make([]bool, size)
+		m.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		m.set(6, i, i%2 == 0)
+		m.set(i, 6, i%2 == 0)
+	}
+	for _, center := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					dist := max(abs(dx), abs(dy))
+					m.set(x, y, dist != 2 && dist != 4)
+				}
+			}
+		}
+	}
+	align := qrVersions[version-1].align
+	for i, cx := range align {
+		for j, cy := range align {
+			// Выравнивающие узоры не накладываются на поисковые
+			last := len(align) - 1
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					m.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	m.drawFormatBits(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			bit := (bits>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			m.set(a, b, bit)
+			m.set(b, a, bit)
+		}
+	}
+	return m
+}
+
+// set задает служебный модуль в столбце x строки y
+func (m *qrMatrix) set(x, y int, dark bool) {
+	m.modules[y][x] = dark
+	m.function[y][x] = true
+}
+
+// drawFormatBits записывает обе копии формата: уровень коррекции M (00) и номер маски
+func (m *qrMatrix) drawFormatBits(mask int) {
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		m.set(8, i, bit(i))
+	}
+	m.set(8, 7, bit(6))
+	m.set(8, 8, bit(7))
+	m.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		m.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		m.set(m.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		m.set(8, m.size-15+i, bit(i))
+	}
+	m.set(8, m.size-8, true)
+}
+
+// drawCodewords размещает кодовые слова зигзагом по парам столбцов снизу вверх и обратно
+func (m *qrMatrix) drawCodewords(codewords []byte) {
+	i := 0
+	for right := m.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Столбец синхронизации пропускается
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < m.size; vert++ {
+			y := vert
+			if upward {
+				y = m.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !m.function[y][x] && i < len(codewords)*8 {
+					m.modules[y][x] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask инвертирует модули данных по маске; повторное применение снимает маску
+func (m *qrMatrix) applyMask(mask int) {
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !m.function[y][x] {
+				m.modules[y][x] = !m.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty оценивает, насколько трудно считать код: длинные серии одного цвета,
+// блоки 2×2, узоры, похожие на поисковые, и перекос доли темных модулей
+func (m *qrMatrix) penalty() int {
+	penalty, dark := 0, 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, horizontal := range []bool{true, false} {
+		at := func(line, i int) bool {
+			if horizontal {
+				return m.modules[line][i]
+			}
+			return m.modules[i][line]
+		}
+		for line := 0; line < m.size; line++ {
+			run := 1
+			for i := 1; i <= m.size; i++ {
+				if i < m.size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+			for i := 0; i+11 <= m.size; i++ {
+				for _, pattern := range finderLike {
+					matched := true
+					for k, want := range pattern {
+						if at(line, i+k) != want {
+							matched = false
+							break
+						}
+					}
+					if matched {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+	for y := 0; y < m.size; y++ {
+		for x := 0; x < m.size; x++ {
+			if m.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := m.modules[y][x]
+				if m.modules[y-1][x] == c && m.modules[y][x-1] == c && m.modules[y-1][x-1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := m.size * m.size
+	penalty += abs(dark*20-total*10) / total * 10
+	return penalty
+}
+
+// qrReedSolomon вычисляет кодовые слова коррекции Рида-Соломона над GF(256)
+// с порождающим многочленом, корни которого - α^0 ... α^(degree-1)
+func qrReedSolomon(data []byte, degree int) []byte {
+	generator := make([]byte, degree)
+	generator[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range generator {
+			generator[j] = gfMultiply(generator[j], root)
+			if j+1 < degree {
+				generator[j] ^= generator[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+
+	remainder := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[degree-1] = 0
+		for i := range remainder {
+			remainder[i] ^= gfMultiply(generator[i], factor)
+		}
+	}
+	return remainder
+}
+
+// gfMultiply умножает элементы GF(256) по модулю x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+// abs возвращает модуль целого числа
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Функции для резервных кодов
+
+func generateBackupCodesList(random io.Reader, policy BackupCodePolicy) []string {
+	codes := make([]string, policy.Count)
+	
+	for i := 0; i < policy.Count; i++ {
+		codes[i] = generateBackupCode(random, policy)
+	}
+	
+	return codes
+}
+
+func generateBackupCode(random io.Reader, policy BackupCodePolicy) string {
+	// Генерируем код заданной длины из символов выбранного формата
+	charset := policy.Format.charset()
+
+	var code strings.Builder
+	for i := 0; i < policy.Length; i++ {
+		if policy.GroupSize > 0 && i > 0 && i%policy.GroupSize == 0 {
+			code.WriteByte('-')
+		}
+		randomBig, _ := rand.Int(random, big.NewInt(int64(len(charset))))
+		code.WriteByte(charset[randomBig.Int64()])
+	}
+	
+	return code.String()
+}
+
+// normalizeBackupCode убирает дефисы и пробелы и приводит код к верхнему регистру
+func normalizeBackupCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ToUpper(code)
+}
+
+// warnLowBackupCodes предупреждает, что одноразовые резервные коды заканчиваются
+func warnLowBackupCodes(auth *TwoFactorAuth, user *User2FA) {
+	if auth.backupPolicy.MultiUse || len(user.BackupCodes) >= auth.backupPolicy.WarnBelow {
+		return
+	}
+
+	fmt.Printf("⚠️  Осталось резервных кодов: %d. Сгенерируйте новые (пункт 5)\n", len(user.BackupCodes))
 }
 
 // Вспомогательные функции
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seededReader - детерминированный поток SHA-256(seed || номер блока) для проверок
+type seededReader struct {
+	seed    string
+	counter uint64
+	buffer  []byte
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		if len(r.buffer) == 0 {
+			block := binary.BigEndian.AppendUint64([]byte(r.seed), r.counter)
+			sum := sha256.Sum256(block)
+			r.buffer = sum[:]
+			r.counter++
+		}
+		copied := copy(p[n:], r.buffer)
+		r.buffer = r.buffer[copied:]
+		n += copied
+	}
+	return len(p), nil
+}
+
+// fixedClock - часы, всегда показывающие одно и то же время
+type fixedClock struct{ now time.Time }
+
+func (c fixedClock) Now() time.Time { return c.now }
+
+// TestSeededSecretAndBackupCodes проверяет, что секрет и резервные коды берутся только
+// из источника случайности менеджера: одинаковый поток дает одинаковые значения
+func TestSeededSecretAndBackupCodes(t *testing.T) {
+	auth := NewTwoFactorAuth()
+	auth.SetRandomSource(&seededReader{seed: "test"})
+	// Каждый байт секрета - один байт потока: base32 от первых 20 байт SHA-256("test" || 0)
+	if secret := generateTOTPSecret(auth.random); secret != "XDGD2H6PPAMP5KYH6ISCMMSWCEHOWO2X" {
+		t.Errorf("секрет %s", secret)
+	}
+
+	tests := []struct {
+		name   string
+		policy BackupCodePolicy
+		golden []string
+		format *regexp.Regexp
+	}{
+		{"по умолчанию", DefaultBackupCodePolicy(),
+			[]string{"M5PYHWRO", "XUOD1IZL", "96MS5E50", "JOO4V9TQ", "H7NY1W5B", "IEB4GEKE", "YZISP4BU", "CQP1IQ10", "686ITBZL", "IS4CLMM8"},
+			regexp.MustCompile(`^[A-Z0-9]{8}$`)},
+		{"цифры группами", BackupCodePolicy{Count: 3, Length: 12, Format: BackupCodeDigits, GroupSize: 4},
+			[]string{"8887-2462-6174", "3879-4302-2489", "5330-6718-3967"},
+			regexp.MustCompile(`^[0-9]{4}-[0-9]{4}-[0-9]{4}$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth.SetRandomSource(&seededReader{seed: "test"})
+			codes := generateBackupCodesList(auth.random, tt.policy)
+			if !reflect.DeepEqual(codes, tt.golden) {
+				t.Fatalf("коды %v, ожидается %v", codes, tt.golden)
+			}
+			for _, code := range codes {
+				if !tt.format.MatchString(code) {
+					t.Errorf("код %q не соответствует формату", code)
+				}
+			}
+		})
+	}
+
+	auth.SetRandomSource(nil)
+	if auth.random != io.Reader(rand.Reader) {
+		t.Error("SetRandomSource(nil) не вернул crypto/rand")
+	}
+}
+
+// TestVerifyOTPCodeFixedClock проверяет коды по векторам RFC 6238 (SHA-1, последние 6 цифр)
+// при подмененных часах, включая окно ±1 интервал
+func TestVerifyOTPCodeFixedClock(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // base32("12345678901234567890")
+	tests := []struct {
+		unix  int64
+		code  string
+		valid bool
+	}{
+		{59, "287082", true},
+		{1111111109, "081804", true},
+		{1234567890, "005924", true},
+		{2000000000, "279037", true},
+		{59 + 30, "287082", true},  // Предыдущий интервал
+		{59 + 60, "287082", false}, // Вне окна
+		{1234567890, "005925", false},
+	}
+	for _, tt := range tests {
+		auth := NewTwoFactorAuth()
+		auth.clock = fixedClock{time.Unix(tt.unix, 0)}
+		if got := auth.verifyOTPCode(OTPTotp, secret, tt.code); got != tt.valid {
+			t.Errorf("время %d, код %s: %v, ожидается %v", tt.unix, tt.code, got, tt.valid)
+		}
+	}
+}
+
+func TestSetBackupCodePolicy(t *testing.T) {
+	policy := func(change func(policy *BackupCodePolicy)) BackupCodePolicy {
+		p := DefaultBackupCodePolicy()
+		change(&p)
+		return p
+	}
+	tests := []struct {
+		name   string
+		policy BackupCodePolicy
+		valid  bool
+	}{
+		{"по умолчанию", DefaultBackupCodePolicy(), true},
+		{"цифры по 10 группами", policy(func(p *BackupCodePolicy) { p.Format, p.Length, p.GroupSize = BackupCodeDigits, 10, 5 }), true},
+		{"буквы и цифры по 6", policy(func(p *BackupCodePolicy) { p.Length = 6 }), true},
+		{"неизвестный формат", policy(func(p *BackupCodePolicy) { p.Format = 7 }), false},
+		{"без кодов", policy(func(p *BackupCodePolicy) { p.Count, p.WarnBelow = 0, 0 }), false},
+		{"слишком много кодов", policy(func(p *BackupCodePolicy) { p.Count = maxBackupCodes + 1 }), false},
+		{"короткий код", policy(func(p *BackupCodePolicy) { p.Length = 5 }), false},
+		{"короткий цифровой код", policy(func(p *BackupCodePolicy) { p.Format, p.Length = BackupCodeDigits, 9 }), false},
+		{"длинный код", policy(func(p *BackupCodePolicy) { p.Length = maxBackupCodeLength + 1 }), false},
+		{"группа длиной в код", policy(func(p *BackupCodePolicy) { p.GroupSize = p.Length }), false},
+		{"отрицательная группа", policy(func(p *BackupCodePolicy) { p.GroupSize = -1 }), false},
+		{"порог больше количества", policy(func(p *BackupCodePolicy) { p.WarnBelow = p.Count + 1 }), false},
+	}
+	for _, tt := range tests {
+		auth := NewTwoFactorAuth()
+		err := auth.SetBackupCodePolicy(tt.policy)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: ошибка %v, ожидается допустимость %v", tt.name, err, tt.valid)
+		}
+		if err != nil && auth.backupPolicy != DefaultBackupCodePolicy() {
+			t.Errorf("%s: отклоненная политика применена", tt.name)
+		}
+	}
+
+	for name, expected := range map[string]BackupCodeFormat{"alnum": BackupCodeAlphanumeric, " Digits ": BackupCodeDigits} {
+		if format, err := ParseBackupCodeFormat(name); err != nil || format != expected {
+			t.Errorf("формат %q: %v, %v", name, format, err)
+		}
+	}
+	if _, err := ParseBackupCodeFormat("hex"); err == nil {
+		t.Error("неизвестный формат принят")
+	}
+}
+
+// ntpReply собирает ответ сервера на запрос request со временем now и заголовком header
+func ntpReply(request []byte, now time.Time, header byte, stratum byte) []byte {
+	reply := make([]byte, ntpPacketSize)
+	reply[0], reply[1] = header, stratum
+	copy(reply[24:32], request[40:48])
+	seconds := uint32(now.Unix() + 2208988800)
+	for _, field := range [][]byte{reply[32:40], reply[40:48]} {
+		binary.BigEndian.PutUint32(field, seconds)
+	}
+	return reply
+}
+
+// startNTPServer запускает на loopback сервер, отвечающий на каждый запрос функцией reply
+func startNTPServer(t *testing.T, reply func(request []byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buffer := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply(buffer[:n]), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQueryNTPOffset(t *testing.T) {
+	local := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := local.Add(-90 * time.Second) // Часы сервера отстают на полтора интервала TOTP
+	tests := []struct {
+		name  string
+		reply func(request []byte) []byte
+		err   string
+	}{
+		{"верный ответ", func(request []byte) []byte { return ntpReply(request, server, 0x24, 2) }, ""},
+		{"режим клиента", func(request []byte) []byte { return ntpReply(request, server, 0x23, 2) }, "режиме сервера"},
+		{"LI = 3", func(request []byte) []byte { return ntpReply(request, server, 0xE4, 2) }, "LI = 3"},
+		{"Kiss-o'-Death", func(request []byte) []byte {
+			reply := ntpReply(request, server, 0x24, 0)
+			copy(reply[12:16], "RATE")
+			return reply
+		}, "RATE"},
+		{"stratum 16", func(request []byte) []byte { return ntpReply(request, server, 0x24, 16) }, "stratum"},
+		{"чужой Originate", func(request []byte) []byte {
+			return ntpReply(make([]byte, ntpPacketSize), server, 0x24, 2)
+		}, "Originate"},
+		{"короткий ответ", func(request []byte) []byte { return ntpReply(request, server, 0x24, 2)[:40] }, "короткий"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := startNTPServer(t, tt.reply)
+			offset, err := queryNTPOffset(address, fixedClock{local})
+			if tt.err == "" {
+				if err != nil || offset != -90*time.Second {
+					t.Errorf("смещение %v (%v), ожидается -1m30s", offset, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ошибка %v, ожидается %q", err, tt.err)
+			}
+		})
+	}
+
+	// totp-diag: код завершения 1, когда расхождение больше окна проверки
+	auth := NewTwoFactorAuth()
+	auth.clock = fixedClock{local}
+	if code := reportClockSkew(auth, startNTPServer(t, tests[0].reply)); code != 1 {
+		t.Errorf("код завершения %d при расхождении 90s, ожидается 1", code)
+	}
+	auth.clock = fixedClock{server.Add(5 * time.Second)}
+	if code := reportClockSkew(auth, startNTPServer(t, tests[0].reply)); code != 0 {
+		t.Errorf("код завершения %d при расхождении 5s, ожидается 0", code)
+	}
+}